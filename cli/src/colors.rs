@@ -1,10 +1,11 @@
 //! Core color type definitions and helper utilities.
 //!
 //! Provides fundamental color representations used throughout the colorizer library:
-//! - sRGB (8-bit and float)
+//! - sRGB (8-bit, with or without alpha, and float)
 //! - Linear RGB
 //! - HSL and HSV (cylindrical color spaces)
 //! - CIE Lab and Lch (perceptually uniform spaces)
+//! - OKLab and OKLch (perceptually uniform spaces with better hue linearity)
 
 use std::fmt;
 
@@ -79,6 +80,63 @@ impl Srgb8 {
     pub fn to_hex(&self) -> String {
         format!("#{:02x}{:02x}{:02x}", self.r, self.g, self.b)
     }
+
+    /// Converts to the three-digit form "#RGB" when that is lossless (e.g., `#ffcc00` as `#fc0`), and to "#RRGGBB"
+    /// otherwise.
+    pub fn to_short_hex(&self) -> String {
+        let doubled = |channel: u8| channel >> 4 == channel & 0x0f;
+        if doubled(self.r) && doubled(self.g) && doubled(self.b) {
+            format!("#{:x}{:x}{:x}", self.r & 0x0f, self.g & 0x0f, self.b & 0x0f)
+        } else {
+            self.to_hex()
+        }
+    }
+
+    /// Converts to HSL using the gamma-encoded components, as CSS `hsl()` does.
+    ///
+    /// Converting back with [Srgb8::from_hsl] returns the original color exactly.
+    pub fn to_hsl(self) -> Hsl {
+        self.into()
+    }
+
+    /// Creates a color from HSL, as CSS `hsl()` does.
+    pub fn from_hsl(hsl: Hsl) -> Self {
+        hsl.into()
+    }
+
+    /// Increases HSL lightness by `amount`, keeping hue and saturation.
+    ///
+    /// Unlike mixing with white, this doesn't wash out the hue of saturated colors.
+    ///
+    /// ```
+    /// use colorizer::colors::Srgb8;
+    ///
+    /// let blue = Srgb8::from_hex("#336699").unwrap();
+    /// assert_eq!(blue.lighten(0.2).to_hex(), "#6699cc");
+    /// ```
+    pub fn lighten(self, amount: f32) -> Self {
+        Self::from_hsl(crate::shades::lighten_hsl(self.to_hsl(), amount))
+    }
+
+    /// Decreases HSL lightness by `amount`, keeping hue and saturation.
+    pub fn darken(self, amount: f32) -> Self {
+        Self::from_hsl(crate::shades::darken_hsl(self.to_hsl(), amount))
+    }
+
+    /// Increases HSL saturation by `amount`.
+    pub fn saturate(self, amount: f32) -> Self {
+        Self::from_hsl(crate::shades::saturate_hsl(self.to_hsl(), amount))
+    }
+
+    /// Decreases HSL saturation by `amount`; an amount of 1 gives the gray of the same lightness.
+    pub fn desaturate(self, amount: f32) -> Self {
+        Self::from_hsl(crate::shades::desaturate_hsl(self.to_hsl(), amount))
+    }
+
+    /// Rotates the hue by `degrees`, wrapping around at 360°.
+    pub fn rotate_hue(self, degrees: f32) -> Self {
+        Self::from_hsl(crate::shades::rotate_hue_hsl(self.to_hsl(), degrees))
+    }
 }
 
 impl fmt::Display for Srgb8 {
@@ -87,6 +145,102 @@ impl fmt::Display for Srgb8 {
     }
 }
 
+/// sRGB color with 8-bit components and an 8-bit alpha, from 0 (transparent) to 255 (opaque).
+///
+/// Themes use it where the code should show through, like highlighted lines. Outputs that can't draw translucency,
+/// such as terminals, composite it over a backdrop first with [Srgba8::over].
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub struct Srgba8 {
+    pub r: u8,
+    pub g: u8,
+    pub b: u8,
+    pub a: u8,
+}
+
+impl Srgba8 {
+    /// Creates a new 8-bit sRGB color with alpha.
+    pub const fn new(r: u8, g: u8, b: u8, a: u8) -> Self {
+        Self { r, g, b, a }
+    }
+
+    /// Returns the color without its alpha.
+    pub const fn rgb(self) -> Srgb8 {
+        Srgb8::new(self.r, self.g, self.b)
+    }
+
+    pub const fn is_opaque(self) -> bool {
+        self.a == u8::MAX
+    }
+
+    /// Parses a hex color string in format "#RRGGBBAA" or "#RRGGBB" (opaque), with or without the '#'.
+    pub fn from_hex(hex: &str) -> Option<Self> {
+        let hex = hex.strip_prefix('#').unwrap_or(hex);
+        if !hex.is_ascii() {
+            return None;
+        }
+        match hex.len() {
+            6 => Srgb8::from_hex(hex).map(Self::from),
+            8 => {
+                let Srgb8 { r, g, b } = Srgb8::from_hex(&hex[..6])?;
+                let a = u8::from_str_radix(&hex[6..], 16).ok()?;
+                Some(Self::new(r, g, b, a))
+            }
+            _ => None,
+        }
+    }
+
+    /// Converts to hex string format "#RRGGBB" when opaque, so consumers that expect six digits keep working, and to
+    /// "#RRGGBBAA" otherwise.
+    pub fn to_hex(&self) -> String {
+        if self.is_opaque() {
+            self.rgb().to_hex()
+        } else {
+            format!("{}{:02x}", self.rgb().to_hex(), self.a)
+        }
+    }
+
+    /// Converts to a CSS color value: "#RRGGBB" when opaque and `rgba(r, g, b, alpha)` otherwise.
+    ///
+    /// ```
+    /// use colorizer::colors::Srgba8;
+    ///
+    /// assert_eq!(Srgba8::new(255, 255, 255, 255).to_css(), "#ffffff");
+    /// assert_eq!(Srgba8::new(255, 255, 255, 51).to_css(), "rgba(255, 255, 255, 0.2)");
+    /// ```
+    pub fn to_css(&self) -> String {
+        if self.is_opaque() {
+            return self.to_hex();
+        }
+        let alpha = format!("{:.3}", self.a as f32 / 255.0);
+        let alpha = alpha.trim_end_matches('0').trim_end_matches('.');
+        format!("rgba({}, {}, {}, {alpha})", self.r, self.g, self.b)
+    }
+
+    /// Composites the color over an opaque `backdrop`, giving the color a viewer sees.
+    ///
+    /// ```
+    /// use colorizer::colors::{Srgb8, Srgba8};
+    ///
+    /// let highlight = Srgba8::new(255, 255, 255, 51);
+    /// assert_eq!(highlight.over(Srgb8::new(0, 0, 0)), Srgb8::new(51, 51, 51));
+    /// ```
+    pub fn over(self, backdrop: Srgb8) -> Srgb8 {
+        crate::compositing::blend(self, backdrop.into()).rgb()
+    }
+}
+
+impl From<Srgb8> for Srgba8 {
+    fn from(c: Srgb8) -> Self {
+        Self::new(c.r, c.g, c.b, u8::MAX)
+    }
+}
+
+impl fmt::Display for Srgba8 {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}", self.to_hex())
+    }
+}
+
 /// HSL (Hue, Saturation, Lightness) color representation.
 ///
 /// Cylindrical color space where:
@@ -182,6 +336,51 @@ impl Lch {
     }
 }
 
+/// OKLab color representation.
+///
+/// Björn Ottosson's perceptual space, built from linear sRGB:
+/// - `l` is perceived lightness [0, 1]
+/// - `a` is green-red axis, roughly [-0.4, 0.4]
+/// - `b` is blue-yellow axis, roughly [-0.4, 0.4]
+///
+/// Hues stay truer than in CIE Lab when chroma or lightness change, which makes it a better fit for hue rotation.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Oklab {
+    pub l: f32,
+    pub a: f32,
+    pub b: f32,
+}
+
+impl Oklab {
+    /// Creates a new OKLab color.
+    ///
+    /// No clamping is performed; colors outside the sRGB gamut are representable.
+    pub const fn new(l: f32, a: f32, b: f32) -> Self {
+        Self { l, a, b }
+    }
+}
+
+/// OKLch color representation (cylindrical OKLab).
+///
+/// - `l` is lightness [0, 1] (same as OKLab)
+/// - `c` is chroma [0, ∞), rarely above 0.37 inside sRGB
+/// - `h` is hue angle in degrees [0, 360)
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Oklch {
+    pub l: f32,
+    pub c: f32,
+    pub h: f32,
+}
+
+impl Oklch {
+    /// Creates a new OKLch color with normalized hue.
+    ///
+    /// Hue is wrapped to [0, 360). L and C are not clamped.
+    pub fn new(l: f32, c: f32, h: f32) -> Self {
+        Self { l, c, h: wrap_degrees(h) }
+    }
+}
+
 /// CIE XYZ color representation (device-independent).
 ///
 /// Intermediate color space used for conversions between RGB and Lab.
@@ -291,6 +490,36 @@ mod tests {
         let color = Srgb8::new(255, 128, 0);
         assert_eq!(color.to_hex(), "#ff8000");
         assert_eq!(format!("{color}"), "#ff8000");
+        assert_eq!(color.to_short_hex(), "#ff8000");
+        assert_eq!(Srgb8::new(0xff, 0xcc, 0x00).to_short_hex(), "#fc0");
+        assert_eq!(Srgb8::new(0x11, 0x11, 0x10).to_short_hex(), "#111110");
+    }
+
+    #[test]
+    fn test_srgba8_hex_keeps_six_digits_when_opaque() {
+        assert_eq!(Srgba8::from(Srgb8::new(255, 128, 0)).to_hex(), "#ff8000");
+        assert_eq!(Srgba8::new(255, 128, 0, 0x80).to_hex(), "#ff800080");
+        assert_eq!(Srgba8::from_hex("#ff800080"), Some(Srgba8::new(255, 128, 0, 0x80)));
+        assert_eq!(Srgba8::from_hex("ff8000"), Some(Srgba8::new(255, 128, 0, 255)));
+        assert_eq!(Srgba8::from_hex("#ff80008"), None);
+        assert_eq!(Srgba8::new(0, 0, 0, 0).to_css(), "rgba(0, 0, 0, 0)");
+        assert_eq!(Srgba8::new(1, 2, 3, 128).to_css(), "rgba(1, 2, 3, 0.502)");
+    }
+
+    #[test]
+    fn test_srgb8_hsl_adjustments() {
+        let red = Srgb8::new(255, 0, 0);
+        assert_eq!(red.darken(0.25), Srgb8::new(128, 0, 0));
+        assert_eq!(red.lighten(0.25), Srgb8::new(255, 128, 128));
+        assert_eq!(red.desaturate(1.0), Srgb8::new(128, 128, 128));
+        assert_eq!(red.rotate_hue(120.0), Srgb8::new(0, 255, 0));
+        assert_eq!(red.rotate_hue(-120.0), Srgb8::new(0, 0, 255));
+        assert_eq!(red.rotate_hue(360.0), red);
+
+        let muted = Srgb8::new(153, 102, 102);
+        assert_eq!(muted.saturate(0.2).to_hsl().h, 0.0);
+        assert!(muted.saturate(0.2).to_hsl().s > muted.to_hsl().s);
+        assert_eq!(muted.saturate(0.0), muted);
     }
 
     #[test]
@@ -0,0 +1,157 @@
+//! Compositing translucent colors and mixing colors in a chosen space.
+//!
+//! [blend] draws one color over another the way browsers and editors draw translucent highlights. [mix] interpolates
+//! between two colors; mixing the gamma-encoded sRGB components, as CSS did before `color-mix()`, gives muddy, dark
+//! midpoints between saturated colors, so it can mix in linear light or OKLab instead.
+
+use crate::colors::{Oklab, Rgb, Srgb, Srgb8, Srgba8, clamp01};
+
+/// The color space [mix] interpolates in.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum MixSpace {
+    /// Gamma-encoded sRGB components, which is cheap and matches legacy CSS but darkens midpoints.
+    #[default]
+    Srgb,
+    /// Linear-light RGB, which keeps the physical brightness of the mix, like mixing light.
+    LinearRgb,
+    /// OKLab, which spaces perceived lightness evenly and keeps hues from passing through gray.
+    Oklab,
+}
+
+/// Composites `over` onto `under` with the source-over operator.
+///
+/// Components are composited gamma-encoded, as CSS and editors do, so a theme's translucent colors come out the way
+/// its author saw them. A translucent `under` gives a translucent result.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgba8;
+/// use colorizer::compositing::blend;
+///
+/// let highlight = Srgba8::new(255, 255, 255, 51);
+/// assert_eq!(blend(highlight, Srgba8::new(0, 0, 0, 255)), Srgba8::new(51, 51, 51, 255));
+/// assert_eq!(blend(highlight, Srgba8::new(0, 0, 0, 0)), highlight);
+/// ```
+pub fn blend(over: Srgba8, under: Srgba8) -> Srgba8 {
+    let (over_alpha, under_alpha) = (unit(over.a), unit(under.a));
+    let under_weight = under_alpha * (1.0 - over_alpha);
+    let alpha = over_alpha + under_weight;
+    if alpha == 0.0 {
+        return Srgba8::new(0, 0, 0, 0);
+    }
+    let channel = |over: u8, under: u8| to_u8((over as f32 * over_alpha + under as f32 * under_weight) / alpha / 255.0);
+    Srgba8::new(
+        channel(over.r, under.r),
+        channel(over.g, under.g),
+        channel(over.b, under.b),
+        to_u8(alpha),
+    )
+}
+
+/// Interpolates from `a` at `t = 0` to `b` at `t = 1` in `space`.
+///
+/// Alpha is interpolated linearly, and the components are weighted by it while mixing, as CSS `color-mix()` does,
+/// so a transparent end fades the other color out rather than tinting it.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::compositing::{MixSpace, mix};
+///
+/// let (red, green) = (Srgb8::new(255, 0, 0).into(), Srgb8::new(0, 255, 0).into());
+/// assert_eq!(mix(red, green, 0.5, MixSpace::Srgb).to_hex(), "#808000");
+/// assert_eq!(mix(red, green, 0.5, MixSpace::LinearRgb).to_hex(), "#bcbc00");
+/// ```
+pub fn mix(a: Srgba8, b: Srgba8, t: f32, space: MixSpace) -> Srgba8 {
+    let t = clamp01(t);
+    let (a_alpha, b_alpha) = (unit(a.a), unit(b.a));
+    let alpha = a_alpha + (b_alpha - a_alpha) * t;
+    if alpha == 0.0 {
+        return Srgba8::new(0, 0, 0, 0);
+    }
+    let (from, to) = (components(a.rgb(), space), components(b.rgb(), space));
+    let mixed = std::array::from_fn(|i| (from[i] * a_alpha + (to[i] * b_alpha - from[i] * a_alpha) * t) / alpha);
+    let Srgb8 { r, g, b } = from_components(mixed, space);
+    Srgba8::new(r, g, b, to_u8(alpha))
+}
+
+fn components(color: Srgb8, space: MixSpace) -> [f32; 3] {
+    match space {
+        MixSpace::Srgb => {
+            let Srgb { r, g, b } = color.into();
+            [r, g, b]
+        }
+        MixSpace::LinearRgb => {
+            let Rgb { r, g, b } = color.into();
+            [r, g, b]
+        }
+        MixSpace::Oklab => {
+            let Oklab { l, a, b } = color.into();
+            [l, a, b]
+        }
+    }
+}
+
+fn from_components([x, y, z]: [f32; 3], space: MixSpace) -> Srgb8 {
+    match space {
+        MixSpace::Srgb => Srgb::new(x, y, z).into(),
+        MixSpace::LinearRgb => Rgb::new(x, y, z).into(),
+        MixSpace::Oklab => Oklab::new(x, y, z).into(),
+    }
+}
+
+fn unit(channel: u8) -> f32 {
+    channel as f32 / 255.0
+}
+
+fn to_u8(value: f32) -> u8 {
+    (clamp01(value) * 255.0).round() as u8
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const BLACK: Srgba8 = Srgba8::new(0, 0, 0, 255);
+    const WHITE: Srgba8 = Srgba8::new(255, 255, 255, 255);
+
+    #[test]
+    fn source_over_matches_the_porter_duff_formula() {
+        assert_eq!(blend(WHITE, BLACK), WHITE);
+        assert_eq!(blend(Srgba8::new(255, 0, 0, 0), BLACK), BLACK);
+        assert_eq!(blend(Srgba8::new(255, 0, 0, 128), BLACK), Srgba8::new(128, 0, 0, 255));
+
+        // Half red over half blue: alpha 0.75, with red weighted twice as much as blue.
+        let layered = blend(Srgba8::new(255, 0, 0, 128), Srgba8::new(0, 0, 255, 128));
+        assert_eq!(layered, Srgba8::new(170, 0, 85, 192));
+    }
+
+    #[test]
+    fn mixing_ends_at_the_endpoints_in_every_space() {
+        let (from, to) = (Srgba8::new(0x33, 0x66, 0x99, 255), Srgba8::new(0xf8, 0x51, 0x49, 255));
+        for space in [MixSpace::Srgb, MixSpace::LinearRgb, MixSpace::Oklab] {
+            assert_eq!(mix(from, to, 0.0, space), from, "{space:?}");
+            assert_eq!(mix(from, to, 1.0, space), to, "{space:?}");
+        }
+    }
+
+    #[test]
+    fn linear_and_oklab_midpoints_are_brighter_than_srgb() {
+        let (red, green) = (Srgba8::new(255, 0, 0, 255), Srgba8::new(0, 255, 0, 255));
+        let lightness = |color: Srgba8| Oklab::from(color.rgb()).l;
+        let srgb = mix(red, green, 0.5, MixSpace::Srgb);
+        assert!(lightness(mix(red, green, 0.5, MixSpace::LinearRgb)) > lightness(srgb));
+        assert!(lightness(mix(red, green, 0.5, MixSpace::Oklab)) > lightness(srgb));
+        assert_eq!(mix(BLACK, WHITE, 0.5, MixSpace::Srgb), Srgba8::new(128, 128, 128, 255));
+    }
+
+    #[test]
+    fn mixing_weights_components_by_alpha() {
+        let transparent = Srgba8::new(0, 0, 0, 0);
+        let red = Srgba8::new(255, 0, 0, 255);
+        assert_eq!(mix(red, transparent, 0.5, MixSpace::Srgb), Srgba8::new(255, 0, 0, 128));
+        assert_eq!(mix(transparent, transparent, 0.5, MixSpace::Oklab), transparent);
+    }
+}
@@ -6,6 +6,8 @@
 //! - Linear RGB ↔ XYZ (D65 white point)
 //! - XYZ ↔ Lab (perceptually uniform)
 //! - Lab ↔ Lch (cylindrical representation)
+//! - sRGB ↔ HSL (the CSS `hsl()` model)
+//! - Linear RGB ↔ OKLab ↔ OKLch
 
 use crate::colors::*;
 
@@ -228,6 +230,158 @@ impl From<Lch> for Srgb8 {
     }
 }
 
+/// Converts OKLab to linear RGB without clamping, so callers can tell whether the color is inside the sRGB gamut.
+pub(crate) fn oklab_to_linear(c: Oklab) -> [f32; 3] {
+    let l = (c.l + 0.396_337_8 * c.a + 0.215_803_76 * c.b).powi(3);
+    let m = (c.l - 0.105_561_35 * c.a - 0.063_854_17 * c.b).powi(3);
+    let s = (c.l - 0.089_484_18 * c.a - 1.291_485_5 * c.b).powi(3);
+    [
+        4.076_741_7 * l - 3.307_711_6 * m + 0.230_969_94 * s,
+        -1.268_438 * l + 2.609_757_4 * m - 0.341_319_4 * s,
+        -0.004_196_086_3 * l - 0.703_418_6 * m + 1.707_614_7 * s,
+    ]
+}
+
+/// Slack for linear RGB components that land just outside [0, 1] through rounding in the OKLab round trip.
+const GAMUT_TOLERANCE: f32 = 1e-4;
+
+/// Reports whether `c` lies inside the sRGB gamut.
+pub(crate) fn in_srgb_gamut(c: Oklab) -> bool {
+    oklab_to_linear(c)
+        .iter()
+        .all(|c| (-GAMUT_TOLERANCE..=1.0 + GAMUT_TOLERANCE).contains(c))
+}
+
+/// Returns `chroma` if it `fits`, otherwise the largest smaller chroma that does, found by bisection.
+pub(crate) fn fit_chroma(chroma: f32, fits: impl Fn(f32) -> bool) -> f32 {
+    if fits(chroma) {
+        return chroma;
+    }
+    let (mut low, mut high) = (0.0, chroma);
+    for _ in 0..20 {
+        let mid = (low + high) / 2.0;
+        if fits(mid) {
+            low = mid;
+        } else {
+            high = mid;
+        }
+    }
+    low
+}
+
+const TOE_K1: f32 = 0.206;
+const TOE_K2: f32 = 0.03;
+const TOE_K3: f32 = (1.0 + TOE_K1) / (1.0 + TOE_K2);
+
+/// Maps OKLab lightness to Ottosson's toe-corrected estimate `Lr`, which tracks CIELab `L*` near black, so equal
+/// steps look equal across the whole range.
+pub(crate) fn oklab_toe(l: f32) -> f32 {
+    let x = TOE_K3 * l - TOE_K1;
+    0.5 * (x + (x * x + 4.0 * TOE_K2 * TOE_K3 * l).sqrt())
+}
+
+/// Inverse of [oklab_toe].
+pub(crate) fn oklab_toe_inv(lr: f32) -> f32 {
+    (lr * lr + TOE_K1 * lr) / (TOE_K3 * (lr + TOE_K2))
+}
+
+impl From<Rgb> for Oklab {
+    /// Converts linear RGB to OKLab through the LMS cone response, following Björn Ottosson's reference matrices.
+    fn from(c: Rgb) -> Self {
+        let l = (0.412_221_46 * c.r + 0.536_332_55 * c.g + 0.051_445_995 * c.b).cbrt();
+        let m = (0.211_903_5 * c.r + 0.680_699_5 * c.g + 0.107_396_96 * c.b).cbrt();
+        let s = (0.088_302_46 * c.r + 0.281_718_85 * c.g + 0.629_978_7 * c.b).cbrt();
+        Oklab::new(
+            0.210_454_26 * l + 0.793_617_8 * m - 0.004_072_047 * s,
+            1.977_998_5 * l - 2.428_592_2 * m + 0.450_593_7 * s,
+            0.025_904_037 * l + 0.782_771_77 * m - 0.808_675_77 * s,
+        )
+    }
+}
+
+impl From<Oklab> for Rgb {
+    /// Converts OKLab to linear RGB, clamping out-of-gamut components.
+    fn from(c: Oklab) -> Self {
+        let [r, g, b] = oklab_to_linear(c);
+        Rgb::new(r, g, b)
+    }
+}
+
+impl From<Oklab> for Oklch {
+    /// Converts OKLab to OKLch using cylindrical coordinates.
+    fn from(c: Oklab) -> Self {
+        Oklch::new(c.l, (c.a * c.a + c.b * c.b).sqrt(), c.b.atan2(c.a).to_degrees())
+    }
+}
+
+impl From<Oklch> for Oklab {
+    /// Converts OKLch to OKLab using inverse cylindrical transform.
+    fn from(c: Oklch) -> Self {
+        let h_rad = c.h.to_radians();
+        Oklab::new(c.l, c.c * h_rad.cos(), c.c * h_rad.sin())
+    }
+}
+
+impl From<Srgb8> for Oklab {
+    /// Direct conversion from 8-bit sRGB to OKLab (via linear RGB).
+    fn from(c: Srgb8) -> Self {
+        Oklab::from(Rgb::from(c))
+    }
+}
+
+impl From<Oklab> for Srgb8 {
+    /// Direct conversion from OKLab to 8-bit sRGB (via linear RGB), clamping out-of-gamut components.
+    fn from(c: Oklab) -> Self {
+        Srgb8::from(Rgb::from(c))
+    }
+}
+
+impl From<Srgb8> for Oklch {
+    /// Direct conversion from 8-bit sRGB to OKLch (via OKLab).
+    fn from(c: Srgb8) -> Self {
+        Oklch::from(Oklab::from(c))
+    }
+}
+
+impl From<Oklch> for Srgb8 {
+    /// Direct conversion from OKLch to 8-bit sRGB (via OKLab).
+    fn from(c: Oklch) -> Self {
+        Srgb8::from(Oklab::from(c))
+    }
+}
+
+impl From<Srgb> for Hsl {
+    /// Converts gamma-encoded sRGB to HSL, as CSS `hsl()` does.
+    ///
+    /// The cylindrical math is the same as `Hsl::from(Rgb)`; only the components it's applied to differ, which
+    /// keeps `#ff0000` at `hsl(0, 100%, 50%)`.
+    fn from(c: Srgb) -> Self {
+        Hsl::from(Rgb { r: c.r, g: c.g, b: c.b })
+    }
+}
+
+impl From<Hsl> for Srgb {
+    /// Converts HSL to gamma-encoded sRGB, as CSS `hsl()` does.
+    fn from(c: Hsl) -> Self {
+        let rgb = Rgb::from(c);
+        Srgb::new(rgb.r, rgb.g, rgb.b)
+    }
+}
+
+impl From<Srgb8> for Hsl {
+    /// Direct conversion from 8-bit sRGB to HSL (via float sRGB).
+    fn from(c: Srgb8) -> Self {
+        Hsl::from(Srgb::from(c))
+    }
+}
+
+impl From<Hsl> for Srgb8 {
+    /// Direct conversion from HSL to 8-bit sRGB (via float sRGB).
+    fn from(c: Hsl) -> Self {
+        Srgb8::from(Srgb::from(c))
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -400,4 +554,56 @@ mod tests {
         assert!(lab.a > 75.0 && lab.a < 85.0, "a should be around 79");
         assert!(lab.b < -105.0 && lab.b > -115.0, "b should be around -108");
     }
+
+    #[test]
+    fn test_oklab_reference_values() {
+        let white = Oklab::from(Srgb8::new(255, 255, 255));
+        assert!(approx_eq(white.l, 1.0) && approx_eq(white.a, 0.0) && approx_eq(white.b, 0.0));
+
+        // Reference values from Björn Ottosson's OKLab post.
+        let red = Oklab::from(Srgb8::new(255, 0, 0));
+        assert!(approx_eq(red.l, 0.628) && approx_eq(red.a, 0.2249) && approx_eq(red.b, 0.1258));
+        let blue = Oklch::from(Srgb8::new(0, 0, 255));
+        assert!(approx_eq(blue.l, 0.452) && (blue.c - 0.3132).abs() < 0.01 && (blue.h - 264.05).abs() < 0.5);
+    }
+
+    #[test]
+    fn test_oklch_round_trip() {
+        for c8 in [
+            Srgb8::new(255, 128, 0),
+            Srgb8::new(18, 52, 86),
+            Srgb8::new(127, 127, 127),
+        ] {
+            assert_eq!(Srgb8::from(Oklch::from(c8)), c8);
+        }
+    }
+
+    #[test]
+    fn test_oklab_to_linear_reports_out_of_gamut() {
+        let [r, g, b] = oklab_to_linear(Oklab::from(Oklch::new(0.7, 0.4, 150.0)));
+        assert!([r, g, b].iter().any(|&c| !(0.0..=1.0).contains(&c)));
+    }
+
+    #[test]
+    fn test_srgb8_hsl_round_trip_is_exact() {
+        for r in 0..=255u8 {
+            for g in 0..=255u8 {
+                for b in 0..=255u8 {
+                    let c8 = Srgb8::new(r, g, b);
+                    assert_eq!(Srgb8::from(Hsl::from(c8)), c8);
+                }
+            }
+        }
+    }
+
+    #[test]
+    fn test_srgb8_hsl_matches_css() {
+        let hsl = Hsl::from(Srgb8::new(255, 0, 0));
+        assert!(approx_eq(hsl.h, 0.0) && approx_eq(hsl.s, 1.0) && approx_eq(hsl.l, 0.5));
+
+        let hsl = Hsl::from(Srgb8::from_hex("#336699").unwrap());
+        assert!(approx_eq(hsl.h, 210.0) && approx_eq(hsl.s, 0.5) && approx_eq(hsl.l, 0.4));
+
+        assert_eq!(Srgb8::from(Hsl::new(120.0, 1.0, 0.25)), Srgb8::new(0, 128, 0));
+    }
 }
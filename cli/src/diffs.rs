@@ -5,14 +5,33 @@
 //! - ΔE76 (Euclidean distance)
 //! - ΔE94 (graphics/textiles variants)
 //! - ΔE2000 (CIEDE2000)
+//! - ΔEok (Euclidean distance in OKLab)
 //!
 //! Supporting helpers for "just noticeable difference" checks and enforcing a minimum perceptual spacing within color collections.
 
-use crate::colors::{Lab, wrap_degrees};
+use crate::colors::{Lab, Oklab, Srgb8, wrap_degrees};
 
 /// Default ΔE threshold commonly cited as the "just noticeable difference".
 pub const DEFAULT_JND_THRESHOLD: f32 = 2.3;
 
+/// Computes the perceptual distance between two 8-bit sRGB colors as CIEDE2000 (ΔE2000).
+///
+/// A value under [DEFAULT_JND_THRESHOLD] means most people can't tell the colors apart, which makes this a good
+/// test for near-duplicates in a palette.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::diffs::distance;
+///
+/// assert_eq!(distance(Srgb8::new(10, 20, 30), Srgb8::new(10, 20, 30)), 0.0);
+/// assert!(distance(Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255)) > 99.0);
+/// ```
+pub fn distance(a: Srgb8, b: Srgb8) -> f32 {
+    delta_e_2000(Lab::from(a), Lab::from(b))
+}
+
 /// Computes the original CIE76 ΔE as simple Euclidean distance in Lab space.
 pub fn delta_e_76(a: Lab, b: Lab) -> f32 {
     let dl = a.l - b.l;
@@ -21,6 +40,17 @@ pub fn delta_e_76(a: Lab, b: Lab) -> f32 {
     (dl * dl + da * da + db * db).sqrt()
 }
 
+/// Computes ΔEok, the Euclidean distance in OKLab.
+///
+/// OKLab lightness runs over [0, 1] instead of [0, 100], so values are about a hundredth of ΔE76; a just noticeable
+/// difference is roughly 0.02.
+pub fn delta_e_ok(a: Oklab, b: Oklab) -> f32 {
+    let dl = a.l - b.l;
+    let da = a.a - b.a;
+    let db = a.b - b.b;
+    (dl * dl + da * da + db * db).sqrt()
+}
+
 /// Computes the CIE94 ΔE with separate tuning constants for graphics/textiles.
 pub fn delta_e_94(a: Lab, b: Lab, is_textiles: bool) -> f32 {
     let (k_l, k1, k2) = if is_textiles { (2.0, 0.048, 0.014) } else { (1.0, 0.045, 0.015) };
@@ -0,0 +1,324 @@
+//! Multi-stop color ramps, for coloring values on a scale like log severity or the age of a diff.
+//!
+//! A [Gradient] interpolates between neighboring stops with [mix], so it follows whichever [MixSpace] it is given.
+//! Gradients can be written as CSS-style stop lists (`#000 0%, #f80 50%, #fff 100%`) and parsed from config files.
+
+use crate::colors::Srgba8;
+use crate::compositing::{MixSpace, mix};
+use crate::parse::ColorParseError;
+
+use std::fmt;
+use std::str::FromStr;
+
+/// Colors at positions along a ramp, with interpolation between them.
+///
+/// Positions usually run from 0 to 1. Before the first stop and after the last, the gradient holds the end colors.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::gradient::Gradient;
+///
+/// let heat: Gradient = "#000 0%, #f80 50%, #fff 100%".parse().unwrap();
+/// assert_eq!(heat.at(0.5).to_hex(), "#ff8800");
+/// assert_eq!(heat.at(0.75).to_hex(), "#ffc480");
+/// let steps: Vec<String> = heat.colors(3).iter().map(|color| color.to_hex()).collect();
+/// assert_eq!(steps, ["#000000", "#ff8800", "#ffffff"]);
+/// ```
+#[derive(Debug, Clone, PartialEq)]
+pub struct Gradient {
+    stops: Vec<(f32, Srgba8)>,
+    space: MixSpace,
+}
+
+impl Gradient {
+    /// Creates a gradient from stops in order, or `None` when there are none or a position is NaN.
+    ///
+    /// As in CSS, a stop positioned before an earlier one is moved up to it. Stops at the same position make a hard
+    /// edge there, and the gradient takes the later stop's color at that position.
+    pub fn new<C: Into<Srgba8>>(stops: impl IntoIterator<Item = (f32, C)>) -> Option<Self> {
+        let mut placed: Vec<(f32, Srgba8)> = Vec::new();
+        for (position, color) in stops {
+            if position.is_nan() {
+                return None;
+            }
+            let position = placed.last().map_or(position, |&(last, _)| position.max(last));
+            placed.push((position, color.into()));
+        }
+        if placed.is_empty() {
+            return None;
+        }
+        Some(Self { stops: placed, space: MixSpace::default() })
+    }
+
+    /// Interpolates between stops in `space` instead of gamma-encoded sRGB.
+    pub fn with_space(mut self, space: MixSpace) -> Self {
+        self.space = space;
+        self
+    }
+
+    pub fn stops(&self) -> &[(f32, Srgba8)] {
+        &self.stops
+    }
+
+    /// Returns the color at `t`, which is clamped to [0, 1].
+    pub fn at(&self, t: f32) -> Srgba8 {
+        let t = crate::colors::clamp01(t);
+        let next = self.stops.partition_point(|&(position, _)| position <= t);
+        if next == 0 {
+            return self.stops[0].1;
+        }
+        let Some(&(to, after)) = self.stops.get(next) else {
+            return self.stops[next - 1].1;
+        };
+        let (from, before) = self.stops[next - 1];
+        mix(before, after, (t - from) / (to - from), self.space)
+    }
+
+    /// Samples `count` evenly spaced colors from 0 to 1, both ends included.
+    pub fn colors(&self, count: usize) -> Vec<Srgba8> {
+        match count {
+            0 => Vec::new(),
+            1 => vec![self.at(0.0)],
+            _ => (0..count).map(|i| self.at(i as f32 / (count - 1) as f32)).collect(),
+        }
+    }
+}
+
+/// Errors raised when a string is not a gradient stop list.
+#[derive(Debug, Clone, PartialEq)]
+pub enum GradientParseError {
+    /// The value, or one of its stops, is empty.
+    Empty,
+    /// A stop whose color doesn't parse.
+    Color(ColorParseError),
+    /// A stop position that isn't a number or percentage.
+    Position(String),
+}
+
+impl fmt::Display for GradientParseError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            GradientParseError::Empty => write!(f, "gradient has no color stops"),
+            GradientParseError::Color(source) => write!(f, "invalid gradient stop: {source}"),
+            GradientParseError::Position(value) => write!(f, "'{value}' is not a valid gradient stop position"),
+        }
+    }
+}
+
+impl std::error::Error for GradientParseError {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        match self {
+            GradientParseError::Color(source) => Some(source),
+            _ => None,
+        }
+    }
+}
+
+impl FromStr for Gradient {
+    type Err = GradientParseError;
+
+    /// Parses comma-separated stops, each a color [crate::parse::parse_color_with_alpha] accepts followed by an
+    /// optional position as a percentage (`50%`) or a fraction (`0.5`).
+    ///
+    /// Missing positions are filled in as CSS does: the first stop defaults to 0%, the last to 100%, and stops
+    /// between are spaced evenly between their positioned neighbors.
+    fn from_str(value: &str) -> Result<Self, Self::Err> {
+        let mut colors = Vec::new();
+        let mut positions = Vec::new();
+        for stop in split_top_level(value) {
+            let stop = stop.trim();
+            if stop.is_empty() {
+                return Err(GradientParseError::Empty);
+            }
+            let (color, position) = match rsplit_top_level_whitespace(stop) {
+                Some((color, position))
+                    if position.starts_with(|ch: char| ch.is_ascii_digit() || ".-+".contains(ch)) =>
+                {
+                    (color, Some(parse_position(position)?))
+                }
+                _ => (stop, None),
+            };
+            colors.push(color.parse::<Srgba8>().map_err(GradientParseError::Color)?);
+            positions.push(position);
+        }
+        fill_positions(&mut positions);
+        Gradient::new(positions.into_iter().flatten().zip(colors)).ok_or(GradientParseError::Empty)
+    }
+}
+
+impl fmt::Display for Gradient {
+    /// Writes the stops in the form [Gradient::from_str] parses, with positions as percentages.
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        for (i, (position, color)) in self.stops.iter().enumerate() {
+            if i > 0 {
+                write!(f, ", ")?;
+            }
+            write!(f, "{color} {}%", (position * 1000.0).round() / 10.0)?;
+        }
+        Ok(())
+    }
+}
+
+/// Splits at commas outside parentheses, so `rgb(0, 0, 0) 10%` stays one stop.
+fn split_top_level(value: &str) -> Vec<&str> {
+    let mut parts = Vec::new();
+    let (mut depth, mut start) = (0usize, 0);
+    for (i, ch) in value.char_indices() {
+        match ch {
+            '(' => depth += 1,
+            ')' => depth = depth.saturating_sub(1),
+            ',' if depth == 0 => {
+                parts.push(&value[start..i]);
+                start = i + 1;
+            }
+            _ => {}
+        }
+    }
+    parts.push(&value[start..]);
+    parts
+}
+
+/// Splits a stop at its last whitespace outside parentheses.
+fn rsplit_top_level_whitespace(stop: &str) -> Option<(&str, &str)> {
+    let mut depth = 0usize;
+    let mut split = None;
+    for (i, ch) in stop.char_indices() {
+        match ch {
+            '(' => depth += 1,
+            ')' => depth = depth.saturating_sub(1),
+            _ if ch.is_whitespace() && depth == 0 => split = Some((i, i + ch.len_utf8())),
+            _ => {}
+        }
+    }
+    split.map(|(end, start)| (stop[..end].trim_end(), &stop[start..]))
+}
+
+fn parse_position(value: &str) -> Result<f32, GradientParseError> {
+    let parsed = match value.strip_suffix('%') {
+        Some(percent) => percent.parse::<f32>().map(|percent| percent / 100.0),
+        None => value.parse::<f32>(),
+    };
+    parsed
+        .ok()
+        .filter(|position| position.is_finite())
+        .ok_or_else(|| GradientParseError::Position(value.to_string()))
+}
+
+/// Fills in missing positions the way CSS does for gradient color stops.
+fn fill_positions(positions: &mut [Option<f32>]) {
+    let Some(last) = positions.len().checked_sub(1) else { return };
+    positions[0].get_or_insert(0.0);
+    positions[last].get_or_insert(1.0);
+    let mut start = 0;
+    for end in 1..positions.len() {
+        let Some(to) = positions[end] else { continue };
+        let from = positions[start].expect("runs start at a positioned stop");
+        let steps = (end - start) as f32;
+        for (step, position) in positions[start + 1..end].iter_mut().enumerate() {
+            *position = Some(from + (to - from) * (step + 1) as f32 / steps);
+        }
+        start = end;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+
+    const BLACK: Srgb8 = Srgb8::new(0, 0, 0);
+    const WHITE: Srgb8 = Srgb8::new(255, 255, 255);
+
+    fn hex(colors: &[Srgba8]) -> Vec<String> {
+        colors.iter().map(Srgba8::to_hex).collect()
+    }
+
+    #[test]
+    fn positions_outside_the_stops_and_the_unit_range_clamp() {
+        let gradient = Gradient::new([(0.25, BLACK), (0.75, WHITE)]).unwrap();
+        assert_eq!(gradient.at(0.0), BLACK.into());
+        assert_eq!(gradient.at(0.5).to_hex(), "#808080");
+        assert_eq!(gradient.at(1.0), WHITE.into());
+        assert_eq!(gradient.at(-3.0), BLACK.into());
+        assert_eq!(gradient.at(7.0), WHITE.into());
+        assert_eq!(gradient.at(f32::NAN), BLACK.into());
+    }
+
+    #[test]
+    fn duplicate_positions_make_a_hard_edge_that_takes_the_later_stop() {
+        let red = Srgb8::new(255, 0, 0);
+        let gradient = Gradient::new([(0.0, BLACK), (0.5, BLACK), (0.5, red), (1.0, red)]).unwrap();
+        assert_eq!(gradient.at(0.49), BLACK.into());
+        assert_eq!(gradient.at(0.5), red.into());
+
+        // Stops listed out of order are moved up to the stop before them, as in CSS.
+        let raised = Gradient::new([(0.0, BLACK), (0.6, WHITE), (0.2, red)]).unwrap();
+        assert_eq!(raised.stops()[2].0, 0.6);
+        assert_eq!(raised.at(0.6), red.into());
+    }
+
+    #[test]
+    fn single_stop_gradients_are_one_color() {
+        let gradient = Gradient::new([(0.3, WHITE)]).unwrap();
+        assert_eq!(hex(&gradient.colors(3)), ["#ffffff"; 3]);
+        assert_eq!(Gradient::new(Vec::<(f32, Srgb8)>::new()), None);
+        assert_eq!(Gradient::new([(f32::NAN, WHITE)]), None);
+    }
+
+    #[test]
+    fn sampling_includes_both_ends() {
+        let gradient = Gradient::new([(0.0, BLACK), (1.0, WHITE)]).unwrap();
+        assert_eq!(
+            hex(&gradient.colors(5)),
+            ["#000000", "#404040", "#808080", "#bfbfbf", "#ffffff"]
+        );
+        assert_eq!(hex(&gradient.colors(1)), ["#000000"]);
+        assert!(gradient.colors(0).is_empty());
+    }
+
+    #[test]
+    fn interpolation_follows_the_mix_space() {
+        let (red, green) = (Srgb8::new(255, 0, 0), Srgb8::new(0, 255, 0));
+        let gradient = Gradient::new([(0.0, red), (1.0, green)]).unwrap();
+        assert_eq!(gradient.at(0.5).to_hex(), "#808000");
+        assert_eq!(
+            gradient.clone().with_space(MixSpace::LinearRgb).at(0.5).to_hex(),
+            "#bcbc00"
+        );
+        assert_ne!(gradient.with_space(MixSpace::Oklab).at(0.5).to_hex(), "#808000");
+    }
+
+    #[test]
+    fn css_stop_lists_parse_with_missing_positions_filled_in() {
+        let gradient: Gradient = "#000, rgb(255, 136, 0) 40%, hsl(0 0% 100% / 50%), #fff 0.9"
+            .parse()
+            .unwrap();
+        let positions: Vec<f32> = gradient.stops().iter().map(|&(position, _)| position).collect();
+        assert_eq!(positions, [0.0, 0.4, 0.65, 0.9]);
+        assert_eq!(gradient.stops()[2].1, Srgba8::new(255, 255, 255, 128));
+        assert_eq!(
+            gradient.to_string(),
+            "#000000 0%, #ff8800 40%, #ffffff80 65%, #ffffff 90%"
+        );
+        assert_eq!(gradient.to_string().parse::<Gradient>(), Ok(gradient));
+
+        let spaced: Gradient = "red, lime, blue".parse().unwrap();
+        assert_eq!(spaced.stops()[1].0, 0.5);
+    }
+
+    #[test]
+    fn malformed_stop_lists_name_the_bad_stop() {
+        assert_eq!("".parse::<Gradient>(), Err(GradientParseError::Empty));
+        assert_eq!("#000, , #fff".parse::<Gradient>(), Err(GradientParseError::Empty));
+        assert_eq!(
+            "#000 5x%".parse::<Gradient>(),
+            Err(GradientParseError::Position("5x%".to_string()))
+        );
+        let err = "#000 0%, #ggg 100%".parse::<Gradient>().unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "invalid gradient stop: '#ggg' is not a valid hex color"
+        );
+    }
+}
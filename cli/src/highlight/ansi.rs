@@ -0,0 +1,581 @@
+//! Reading text that is already colored with ANSI escape sequences, such as `ls --color` or `git log --color` output.
+//!
+//! [parse] splits such text into [StyledSpan]s, each a run of visible text with the SGR attributes in effect, and
+//! [highlight] renders them with a [CallFormatter], so a terminal capture becomes HTML or is redrawn with another
+//! theme's colors. Escape sequences other than SGR (cursor movement, erasing, OSC titles and hyperlinks, and the
+//! like) are dropped, and so is a sequence cut off at the end of the input.
+//!
+//! This is a parser rather than a [super::lexers::Lexer]: the colors come from the escapes, not from token kinds,
+//! and the escapes themselves aren't part of the rendered text. No `ansi` lexer is registered, so colored input goes
+//! through [highlight] here rather than [super::highlight] or [super::Highlighter].
+
+use super::token::push_token;
+use super::{CallFormatter, Style, Theme, TokenKind};
+use crate::colors::Srgb8;
+use crate::compositing::{MixSpace, mix};
+use crate::highlight::formatters::Overlay;
+use crate::terminal::XTERM_PALETTE;
+
+use std::str::Split;
+
+/// A color selected by an SGR sequence.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum AnsiColor {
+    /// One of the 16 standard colors, selected with `30`–`37` and `90`–`97` (or their background forms); 8–15 are
+    /// the bright ones.
+    Named(u8),
+    /// An entry of the xterm 256-color palette (`38;5;n`).
+    Indexed(u8),
+    /// A 24-bit color (`38;2;r;g;b`).
+    Rgb(Srgb8),
+}
+
+/// The SGR attributes in effect for a run of text; the default is the terminal's plain text.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Default)]
+pub struct AnsiStyle {
+    /// `None` for the default foreground.
+    pub foreground: Option<AnsiColor>,
+    /// `None` for the default background.
+    pub background: Option<AnsiColor>,
+    pub bold: bool,
+    /// Dim text (`2`), drawn halfway between its foreground and background.
+    pub faint: bool,
+    pub italic: bool,
+    pub underline: bool,
+    pub strikethrough: bool,
+    /// Reverse video (`7`), which swaps the foreground and background.
+    pub reverse: bool,
+    /// Hidden text (`8`), drawn in its background color.
+    pub conceal: bool,
+}
+
+/// The colors an [AnsiStyle] is drawn with.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Palette {
+    /// The 16 standard colors, normal then bright.
+    pub colors: [Srgb8; 16],
+    /// The default foreground and background, which reverse video and dim text need.
+    pub foreground: Srgb8,
+    pub background: Srgb8,
+    /// Draws bold text in a standard color with the bright variant of that color, as many terminals do.
+    pub bold_is_bright: bool,
+}
+
+impl Palette {
+    /// Uses the theme's [Theme::terminal_colors], or xterm's when it has none, with the theme's text and background
+    /// as the defaults.
+    pub fn from_theme(theme: &Theme) -> Self {
+        let xterm = std::array::from_fn(|index| XTERM_PALETTE[index]);
+        Self {
+            colors: theme.terminal_colors.unwrap_or(xterm),
+            foreground: theme.foreground,
+            background: theme.background,
+            bold_is_bright: false,
+        }
+    }
+
+    /// Brightens bold text in the standard colors `30`–`37`; colors picked from the 256-color palette or as RGB
+    /// never change.
+    pub fn with_bold_is_bright(mut self, enabled: bool) -> Self {
+        self.bold_is_bright = enabled;
+        self
+    }
+
+    fn color(&self, color: AnsiColor, brighten: bool) -> Srgb8 {
+        match color {
+            AnsiColor::Named(index) if brighten && index < 8 => self.colors[index as usize + 8],
+            AnsiColor::Named(index) | AnsiColor::Indexed(index) if index < 16 => self.colors[index as usize],
+            AnsiColor::Named(index) | AnsiColor::Indexed(index) => XTERM_PALETTE[index as usize],
+            AnsiColor::Rgb(color) => color,
+        }
+    }
+}
+
+impl AnsiStyle {
+    /// Works out the colors and attributes to draw this style with, leaving unset whatever the terminal's defaults
+    /// would draw, so a formatter fills those in from its theme.
+    pub fn resolve(&self, palette: &Palette) -> Style {
+        let brighten = self.bold && palette.bold_is_bright;
+        let mut foreground = self.foreground.map(|color| palette.color(color, brighten));
+        let mut background = self.background.map(|color| palette.color(color, false));
+        if self.faint {
+            let (over, under) = (
+                foreground.unwrap_or(palette.foreground),
+                background.unwrap_or(palette.background),
+            );
+            foreground = Some(mix(over.into(), under.into(), 0.5, MixSpace::Srgb).rgb());
+        }
+        if self.reverse {
+            (foreground, background) = (
+                Some(background.unwrap_or(palette.background)),
+                Some(foreground.unwrap_or(palette.foreground)),
+            );
+        }
+        if self.conceal {
+            foreground = Some(background.unwrap_or(palette.background));
+        }
+        Style {
+            foreground,
+            background,
+            bold: self.bold.then_some(true),
+            italic: self.italic.then_some(true),
+            underline: self.underline.then_some(true),
+            strikethrough: self.strikethrough.then_some(true),
+        }
+    }
+
+    /// Applies the parameters of one SGR sequence (the `1;31` of `\x1b[1;31m`).
+    fn apply(&mut self, params: &str) {
+        let mut params = params.split(';');
+        while let Some(param) = params.next() {
+            let mut subparams = param.split(':');
+            let code = subparams.next().unwrap_or("");
+            // An empty parameter is 0; one too large for any code is skipped.
+            let Ok(code) = (if code.is_empty() { Ok(0) } else { code.parse::<u16>() }) else { continue };
+            match code {
+                0 => *self = AnsiStyle::default(),
+                1 => self.bold = true,
+                2 => self.faint = true,
+                3 => self.italic = true,
+                // `4:0` turns underlining off; `4:1` to `4:5` are its shapes.
+                4 => self.underline = subparams.next() != Some("0"),
+                7 => self.reverse = true,
+                8 => self.conceal = true,
+                9 => self.strikethrough = true,
+                21 => self.underline = true,
+                22 => (self.bold, self.faint) = (false, false),
+                23 => self.italic = false,
+                24 => self.underline = false,
+                27 => self.reverse = false,
+                28 => self.conceal = false,
+                29 => self.strikethrough = false,
+                30..=37 => self.foreground = Some(AnsiColor::Named((code - 30) as u8)),
+                38 => self.foreground = extended_color(subparams, &mut params).or(self.foreground),
+                39 => self.foreground = None,
+                40..=47 => self.background = Some(AnsiColor::Named((code - 40) as u8)),
+                48 => self.background = extended_color(subparams, &mut params).or(self.background),
+                49 => self.background = None,
+                // Underline colors aren't drawn, but their arguments mustn't be read as codes.
+                58 => _ = extended_color(subparams, &mut params),
+                90..=97 => self.foreground = Some(AnsiColor::Named((code - 90 + 8) as u8)),
+                100..=107 => self.background = Some(AnsiColor::Named((code - 100 + 8) as u8)),
+                _ => {}
+            }
+        }
+    }
+}
+
+/// Reads the color after a `38`, `48`, or `58`: from its own subparameters (`38:5:n`, `38:2::r:g:b`) when it has
+/// them, otherwise from the parameters that follow (`38;5;n`, `38;2;r;g;b`), which are consumed either way.
+fn extended_color<'p>(subparams: Split<'p, char>, params: &mut Split<'p, char>) -> Option<AnsiColor> {
+    let args: Vec<&str> = subparams.collect();
+    let (mode, values) = match args.split_first() {
+        Some((&mode, values)) => (mode, values.to_vec()),
+        None => {
+            let mode = params.next()?;
+            let count = match mode {
+                "5" => 1,
+                "2" => 3,
+                _ => 0,
+            };
+            (mode, params.by_ref().take(count).collect())
+        }
+    };
+    let number = |value: &str| if value.is_empty() { Some(0) } else { value.parse::<u8>().ok() };
+    match mode {
+        "5" => Some(AnsiColor::Indexed(number(values.first()?)?)),
+        // The colon form may carry a color space id before the components.
+        "2" => match values[values.len().checked_sub(3)?..] {
+            [r, g, b] => Some(AnsiColor::Rgb(Srgb8::new(number(r)?, number(g)?, number(b)?))),
+            _ => None,
+        },
+        _ => None,
+    }
+}
+
+/// A run of visible text and the style it is drawn in.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct StyledSpan {
+    /// Byte offset of the text in the parsed source.
+    pub start: usize,
+    /// End of the text, exclusive.
+    pub end: usize,
+    pub style: AnsiStyle,
+}
+
+impl StyledSpan {
+    /// Returns the text of the span within `src`.
+    pub fn text<'s>(&self, src: &'s str) -> &'s str {
+        &src[self.start..self.end]
+    }
+}
+
+/// Splits ANSI-colored `src` into the runs of text between its escape sequences, in order, each with the SGR
+/// attributes in effect there.
+///
+/// Spans skip the escape sequences, so their texts joined together are `src` with the escapes removed. Sequences
+/// this doesn't understand, or that are malformed or cut off, change nothing.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::ansi::{AnsiColor, parse};
+///
+/// let src = "\x1b[1;34msrc\x1b[0m  notes.txt\n";
+/// let spans = parse(src);
+/// assert_eq!(spans[0].text(src), "src");
+/// assert_eq!(spans[0].style.foreground, Some(AnsiColor::Named(4)));
+/// assert!(spans[0].style.bold);
+/// assert_eq!(spans[1].text(src), "  notes.txt\n");
+/// assert_eq!(spans[1].style, Default::default());
+/// ```
+pub fn parse(src: &str) -> Vec<StyledSpan> {
+    let bytes = src.as_bytes();
+    let mut spans = Vec::new();
+    let mut style = AnsiStyle::default();
+    let mut start = 0;
+    while let Some(found) = src[start..].find('\x1b') {
+        let escape = start + found;
+        if escape > start {
+            spans.push(StyledSpan { start, end: escape, style });
+        }
+        let end = sequence_end(bytes, escape);
+        if let Some(params) = sgr_params(&src[escape..end]) {
+            style.apply(params);
+        }
+        start = end;
+    }
+    if start < src.len() {
+        spans.push(StyledSpan { start, end: src.len(), style });
+    }
+    spans
+}
+
+/// End of the escape sequence starting with the ESC at `escape`, or of the input if it is cut off.
+///
+/// Every sequence ends on an ASCII byte, so the end is always a character boundary.
+fn sequence_end(bytes: &[u8], escape: usize) -> usize {
+    let Some(&kind) = bytes.get(escape + 1) else { return bytes.len() };
+    match kind {
+        // CSI: parameter bytes, then intermediate bytes, then a final byte. Anything else ends it early, and the
+        // offending byte is kept as text.
+        b'[' => {
+            let mut at = escape + 2;
+            while bytes.get(at).is_some_and(|byte| (0x30..=0x3f).contains(byte)) {
+                at += 1;
+            }
+            while bytes.get(at).is_some_and(|byte| (0x20..=0x2f).contains(byte)) {
+                at += 1;
+            }
+            match bytes.get(at) {
+                Some(0x40..=0x7e) => at + 1,
+                _ => at,
+            }
+        }
+        // OSC, DCS, SOS, PM, and APC strings run to BEL or ST (`ESC \`).
+        b']' | b'P' | b'X' | b'^' | b'_' => {
+            let mut at = escape + 2;
+            while at < bytes.len() {
+                match bytes[at] {
+                    0x07 => return at + 1,
+                    0x1b if bytes.get(at + 1) == Some(&b'\\') => return at + 2,
+                    _ => at += 1,
+                }
+            }
+            bytes.len()
+        }
+        // `ESC ( B` and the like: intermediate bytes, then a final byte.
+        0x20..=0x2f => {
+            let mut at = escape + 1;
+            while bytes.get(at).is_some_and(|byte| (0x20..=0x2f).contains(byte)) {
+                at += 1;
+            }
+            match bytes.get(at) {
+                Some(0x30..=0x7e) => at + 1,
+                _ => at,
+            }
+        }
+        0x30..=0x7e => escape + 2,
+        _ => escape + 1,
+    }
+}
+
+/// The parameters of `sequence` if it is an SGR sequence (`ESC [ ... m`) without private markers.
+fn sgr_params(sequence: &str) -> Option<&str> {
+    let params = sequence.strip_prefix("\x1b[")?.strip_suffix('m')?;
+    params
+        .bytes()
+        .all(|byte| byte.is_ascii_digit() || byte == b';' || byte == b':')
+        .then_some(params)
+}
+
+/// Renders ANSI-colored `src` with `formatter`: the text without its escapes, as [TokenKind::Text] in `theme`, with
+/// each styled span's colors from `palette` layered over it as an [Overlay].
+///
+/// Plain text keeps the theme's colors, so with [Palette::from_theme] a capture is redrawn in the theme's own
+/// terminal colors. Overlays the formatter already has apply to the text without escapes.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::Theme;
+/// use colorizer::highlight::ansi::{Palette, highlight};
+/// use colorizer::highlight::formatters::HtmlFormatter;
+///
+/// let theme = Theme::new("plain", Srgb8::new(0, 0, 0), Srgb8::new(0xcc, 0xcc, 0xcc));
+/// let html = highlight("ok \x1b[31mFAIL\x1b[39m\n", &Palette::from_theme(&theme), &theme, &HtmlFormatter::new());
+/// assert!(html.contains("FAIL"));
+/// assert!(!html.contains('\x1b'));
+/// ```
+pub fn highlight<F: CallFormatter>(src: &str, palette: &Palette, theme: &Theme, formatter: &F) -> String {
+    let mut text = String::with_capacity(src.len());
+    let mut tokens = Vec::new();
+    let mut overlays: Vec<Overlay> = Vec::new();
+    for span in parse(src) {
+        let start = text.len();
+        text.push_str(span.text(src));
+        push_token(&mut tokens, TokenKind::Text, start, text.len());
+        let style = span.style.resolve(palette);
+        if style.is_empty() {
+            continue;
+        }
+        match overlays.last_mut() {
+            Some(last) if last.end == start && last.style == style => last.end = text.len(),
+            _ => overlays.push(Overlay::bytes(start..text.len(), style)),
+        }
+    }
+
+    let mut formatter = formatter.clone();
+    formatter.extend_overlays(&overlays);
+    let mut out = String::with_capacity(text.len() * 2);
+    formatter.format(&text, &tokens, theme, &mut out);
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::formatters::{AnsiFormatter, HtmlFormatter};
+    use crate::terminal::{ColorChoice, ColorProfile};
+
+    const LS: &str = include_str!("../../../examples/ansi/ls-color.txt");
+    const GREP: &str = include_str!("../../../examples/ansi/grep-color.txt");
+    const GIT_LOG: &str = include_str!("../../../examples/ansi/git-log-color.txt");
+    const GO_TEST: &str = include_str!("../../../examples/ansi/go-test-v.txt");
+
+    fn stripped(src: &str) -> String {
+        parse(src).iter().map(|span| span.text(src)).collect()
+    }
+
+    /// The style of the span whose text is `text`.
+    fn style_of(src: &str, text: &str) -> AnsiStyle {
+        let spans = parse(src);
+        spans
+            .iter()
+            .find(|span| span.text(src) == text)
+            .unwrap_or_else(|| panic!("no span {text:?}"))
+            .style
+    }
+
+    fn palette() -> Palette {
+        Palette::from_theme(&Theme::new("plain", Srgb8::new(0, 0, 0), Srgb8::new(0xcc, 0xcc, 0xcc)))
+    }
+
+    #[test]
+    fn sgr_sets_and_partially_resets_attributes() {
+        let src = "\x1b[1;3;31mA\x1b[39mB\x1b[22mC\x1b[mD\x1b[4;9;7mE\x1b[24;27;29mF\x1b[4:0mG";
+        let bold_italic = AnsiStyle { bold: true, italic: true, ..AnsiStyle::default() };
+        assert_eq!(
+            style_of(src, "A"),
+            AnsiStyle { foreground: Some(AnsiColor::Named(1)), ..bold_italic }
+        );
+        assert_eq!(style_of(src, "B"), bold_italic);
+        assert_eq!(style_of(src, "C"), AnsiStyle { italic: true, ..AnsiStyle::default() });
+        assert_eq!(style_of(src, "D"), AnsiStyle::default());
+        let marked = AnsiStyle { underline: true, strikethrough: true, reverse: true, ..AnsiStyle::default() };
+        assert_eq!(style_of(src, "E"), marked);
+        assert_eq!(style_of(src, "F"), AnsiStyle::default());
+        assert_eq!(style_of(src, "G"), AnsiStyle::default());
+    }
+
+    #[test]
+    fn extended_colors_read_both_separators() {
+        let src =
+            "\x1b[38;5;208mA\x1b[48;2;1;2;3mB\x1b[38:2::10:20:30mC\x1b[38:5:99;1mD\x1b[58;5;1;92mE\x1b[38;5;300mF";
+        assert_eq!(style_of(src, "A").foreground, Some(AnsiColor::Indexed(208)));
+        assert_eq!(style_of(src, "B").background, Some(AnsiColor::Rgb(Srgb8::new(1, 2, 3))));
+        assert_eq!(
+            style_of(src, "C").foreground,
+            Some(AnsiColor::Rgb(Srgb8::new(10, 20, 30)))
+        );
+        let d = style_of(src, "D");
+        assert_eq!((d.foreground, d.bold), (Some(AnsiColor::Indexed(99)), true));
+        // The underline color's arguments aren't codes; `92` after them still is.
+        assert_eq!(style_of(src, "E").foreground, Some(AnsiColor::Named(10)));
+        // An out-of-range index is ignored without eating the next code.
+        assert_eq!(style_of(src, "F").foreground, Some(AnsiColor::Named(10)));
+    }
+
+    #[test]
+    fn other_sequences_are_stripped() {
+        let src = "a\x1b[Kb\x1b[2J\x1b[?25lc\x1b]0;title\x07d\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\\x1b(Be\x1b7f";
+        assert_eq!(stripped(src), "abcdlinkef");
+        assert!(parse(src).iter().all(|span| span.style == AnsiStyle::default()));
+        // A private-marker `m` sequence isn't SGR.
+        assert_eq!(style_of("\x1b[>4;2mx", "x"), AnsiStyle::default());
+    }
+
+    #[test]
+    fn cut_off_sequences_never_panic() {
+        for src in [
+            LS,
+            GREP,
+            GIT_LOG,
+            "é\x1b[38;2;1m\x1b]8;;é\x1b[1",
+            "\x1b",
+            "x\x1b(",
+            "x\x1b[1\nnext",
+        ] {
+            for end in (0..=src.len()).filter(|&end| src.is_char_boundary(end)) {
+                let prefix = &src[..end];
+                for span in parse(prefix) {
+                    assert!(span.start < span.end && span.end <= prefix.len());
+                    assert!(!span.text(prefix).contains('\x1b'), "{prefix:?}");
+                }
+            }
+        }
+        // A CSI interrupted by a newline drops what was read and keeps the newline.
+        assert_eq!(stripped("x\x1b[1\nnext"), "x\nnext");
+    }
+
+    #[test]
+    fn reverse_video_swaps_in_the_default_colors() {
+        let palette = palette();
+        let reversed = AnsiStyle { reverse: true, ..AnsiStyle::default() };
+        let style = reversed.resolve(&palette);
+        assert_eq!(
+            (style.foreground, style.background),
+            (Some(palette.background), Some(palette.foreground))
+        );
+
+        let red_on_blue =
+            AnsiStyle { foreground: Some(AnsiColor::Named(1)), background: Some(AnsiColor::Named(4)), ..reversed };
+        let style = red_on_blue.resolve(&palette);
+        assert_eq!(
+            (style.foreground, style.background),
+            (Some(palette.colors[4]), Some(palette.colors[1]))
+        );
+
+        let hidden = AnsiStyle { conceal: true, background: Some(AnsiColor::Named(2)), ..AnsiStyle::default() };
+        assert_eq!(hidden.resolve(&palette).foreground, Some(palette.colors[2]));
+        assert!(AnsiStyle::default().resolve(&palette).is_empty());
+    }
+
+    #[test]
+    fn bold_brightens_only_standard_colors_when_asked() {
+        let bold = |foreground| AnsiStyle { foreground: Some(foreground), bold: true, ..AnsiStyle::default() };
+        let plain = palette();
+        let bright = palette().with_bold_is_bright(true);
+        assert_eq!(
+            bold(AnsiColor::Named(4)).resolve(&plain).foreground,
+            Some(plain.colors[4])
+        );
+        assert_eq!(
+            bold(AnsiColor::Named(4)).resolve(&bright).foreground,
+            Some(plain.colors[12])
+        );
+        assert_eq!(
+            bold(AnsiColor::Named(12)).resolve(&bright).foreground,
+            Some(plain.colors[12])
+        );
+        assert_eq!(
+            bold(AnsiColor::Indexed(4)).resolve(&bright).foreground,
+            Some(plain.colors[4])
+        );
+        assert_eq!(
+            bold(AnsiColor::Indexed(196)).resolve(&bright).foreground,
+            Some(Srgb8::new(255, 0, 0))
+        );
+
+        // Brightening happens before reverse video, so the bright color becomes the background.
+        let reversed = AnsiStyle { reverse: true, ..bold(AnsiColor::Named(1)) };
+        assert_eq!(reversed.resolve(&bright).background, Some(plain.colors[9]));
+        assert_eq!(reversed.resolve(&bright).bold, Some(true));
+    }
+
+    #[test]
+    fn ls_capture_keeps_file_type_colors() {
+        assert_eq!(
+            stripped(LS),
+            "Cargo.toml\nREADME.md\nbuild.sh\ndangling\ndocs\nlink.md\nm.rs\nshared\nsrc\n"
+        );
+        let named = |index| Some(AnsiColor::Named(index));
+        let directory = style_of(LS, "docs");
+        assert_eq!((directory.foreground, directory.bold), (named(4), true));
+        assert_eq!(style_of(LS, "build.sh").foreground, named(2));
+        let orphan = style_of(LS, "dangling");
+        assert_eq!(
+            (orphan.foreground, orphan.background, orphan.bold),
+            (named(1), named(0), true)
+        );
+        let sticky = style_of(LS, "shared");
+        assert_eq!(
+            (sticky.foreground, sticky.background, sticky.bold),
+            (named(0), named(2), false)
+        );
+        assert_eq!(style_of(LS, "\nm.rs\n"), AnsiStyle::default());
+    }
+
+    #[test]
+    fn grep_and_git_log_captures_lose_only_their_escapes() {
+        assert_eq!(stripped(GREP), "2:    let error = 1;\n");
+        let matched = style_of(GREP, "error");
+        assert_eq!((matched.foreground, matched.bold), (Some(AnsiColor::Named(1)), true));
+        assert_eq!(style_of(GREP, ":").foreground, Some(AnsiColor::Named(6)));
+
+        let log = stripped(GIT_LOG);
+        assert!(
+            log.starts_with("*   f18caa0 (HEAD -> main, tag: v1) Merge topic\n"),
+            "{log}"
+        );
+        assert_eq!(log.lines().count(), 6);
+        let head = style_of(GIT_LOG, "HEAD -> ");
+        assert_eq!((head.foreground, head.bold), (Some(AnsiColor::Named(6)), true));
+        assert_eq!(style_of(GIT_LOG, "|").foreground, Some(AnsiColor::Named(1)));
+    }
+
+    #[test]
+    fn uncolored_go_test_output_passes_through() {
+        assert_eq!(
+            parse(GO_TEST),
+            [StyledSpan { start: 0, end: GO_TEST.len(), style: AnsiStyle::default() }]
+        );
+        let theme = Theme::new("plain", Srgb8::new(0, 0, 0), Srgb8::new(0xcc, 0xcc, 0xcc));
+        let html = highlight(GO_TEST, &palette(), &theme, &HtmlFormatter::new());
+        assert!(html.contains("--- FAIL: TestDiff"), "{html}");
+        assert!(!html.contains("color:#"), "{html}");
+    }
+
+    #[test]
+    fn highlight_renders_spans_as_overlays() {
+        let mut theme = Theme::new("plain", Srgb8::new(0, 0, 0), Srgb8::new(0xcc, 0xcc, 0xcc));
+        let mut colors = [Srgb8::new(0x11, 0x11, 0x11); 16];
+        colors[4] = Srgb8::new(0x12, 0x34, 0x56);
+        theme.terminal_colors = Some(colors);
+        let palette = Palette::from_theme(&theme);
+
+        let html = highlight(LS, &palette, &theme, &HtmlFormatter::new());
+        assert!(!html.contains('\x1b'));
+        assert!(html.contains("#123456"), "{html}");
+        assert!(html.contains(">docs<"), "{html}");
+
+        // With the ANSI formatter, a capture comes back out in the theme's terminal colors.
+        let ansi = AnsiFormatter::new()
+            .with_profile(ColorProfile::TrueColor)
+            .with_color(ColorChoice::Always);
+        let redrawn = highlight(LS, &palette, &theme, &ansi);
+        assert!(redrawn.contains("38;2;18;52;86"), "{redrawn:?}");
+        assert_eq!(stripped(&redrawn), stripped(LS));
+    }
+}
@@ -0,0 +1,257 @@
+//! Caching rendered output, so documents that are highlighted again and again are lexed and formatted once.
+
+use std::collections::{BTreeMap, HashMap};
+use std::fmt;
+use std::sync::{Arc, Mutex};
+
+/// A store of rendered documents, keyed by [cache_key], that a [super::Highlighter] checks before lexing.
+///
+/// Implementations are shared by every thread calling the highlighter, so they take `&self` and must be `Send` and
+/// `Sync`; [LruCache] is the bundled one. Anything that can hold bytes will do, such as a map behind a lock or a
+/// client for a cache server.
+pub trait Cache: Send + Sync {
+    /// Returns the output stored under `key`, if there is any.
+    fn get(&self, key: &str) -> Option<Vec<u8>>;
+
+    /// Stores `value` under `key`, replacing whatever was there.
+    fn set(&self, key: &str, value: Vec<u8>);
+}
+
+impl<C: Cache + ?Sized> Cache for Arc<C> {
+    fn get(&self, key: &str) -> Option<Vec<u8>> {
+        (**self).get(key)
+    }
+
+    fn set(&self, key: &str, value: Vec<u8>) {
+        (**self).set(key, value);
+    }
+}
+
+/// Returns the key output is cached under: 32 hex digits of a hash of the source text, the lexer's name, and the
+/// theme's and formatter's fingerprints (see [super::Theme::fingerprint] and [super::Formatter::fingerprint]).
+///
+/// The hash is 128-bit FNV-1a, stable across runs and builds so keys can live in a cache shared between processes.
+/// It is not cryptographic: keys are safe against accidental collisions, not against someone crafting sources to
+/// collide with each other.
+pub fn cache_key(src: &str, lexer: &str, theme: u64, formatter: u64) -> String {
+    let hash = fnv1a([
+        src.as_bytes(),
+        lexer.as_bytes(),
+        &theme.to_le_bytes(),
+        &formatter.to_le_bytes(),
+    ]);
+    format!("{hash:032x}")
+}
+
+/// Hashes `parts` with 128-bit FNV-1a, each preceded by its length so moving bytes from one part to the next changes
+/// the hash.
+pub(crate) fn fnv1a<'a>(parts: impl IntoIterator<Item = &'a [u8]>) -> u128 {
+    const OFFSET_BASIS: u128 = 0x6c62272e07bb014262b821756295c58d;
+    const PRIME: u128 = 0x0000000001000000000000000000013b;
+    let mut hash = OFFSET_BASIS;
+    for part in parts {
+        for &byte in (part.len() as u64).to_le_bytes().iter().chain(part) {
+            hash ^= u128::from(byte);
+            hash = hash.wrapping_mul(PRIME);
+        }
+    }
+    hash
+}
+
+/// A 64-bit fingerprint of `value`'s `Debug` rendering, for formatters whose fields all take part in it and print
+/// in a fixed order.
+pub(crate) fn debug_fingerprint(value: &impl fmt::Debug) -> u64 {
+    fnv1a([format!("{value:?}").as_bytes()]) as u64
+}
+
+/// A [Cache] that holds up to a number of bytes, evicting the least recently used entries to make room.
+///
+/// An entry's size is the length of its key plus that of its value; values larger than the whole cache aren't
+/// stored. One lock guards the entries, held only long enough to copy a value in or out.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Cache, LruCache};
+///
+/// let cache = LruCache::new(10);
+/// cache.set("a", b"1234".to_vec());
+/// cache.set("b", b"1234".to_vec());
+/// assert!(cache.get("a").is_some());
+/// cache.set("c", b"1234".to_vec());
+/// assert_eq!(cache.get("b"), None, "least recently used");
+/// assert_eq!(cache.get("a").as_deref(), Some(&b"1234"[..]));
+/// assert_eq!(cache.size(), 10);
+/// ```
+pub struct LruCache {
+    capacity: usize,
+    entries: Mutex<Entries>,
+}
+
+#[derive(Default)]
+struct Entries {
+    /// Values and the tick they were last used at.
+    values: HashMap<String, (Vec<u8>, u64)>,
+    /// Keys by the tick they were last used at, oldest first.
+    order: BTreeMap<u64, String>,
+    tick: u64,
+    size: usize,
+}
+
+impl Entries {
+    fn remove(&mut self, key: &str) {
+        if let Some((value, used)) = self.values.remove(key) {
+            self.order.remove(&used);
+            self.size -= key.len() + value.len();
+        }
+    }
+}
+
+impl LruCache {
+    /// Creates an empty cache that holds up to `capacity` bytes.
+    pub fn new(capacity: usize) -> Self {
+        Self { capacity, entries: Mutex::default() }
+    }
+
+    pub fn capacity(&self) -> usize {
+        self.capacity
+    }
+
+    /// Bytes held, keys included.
+    pub fn size(&self) -> usize {
+        self.lock().size
+    }
+
+    /// Number of entries.
+    pub fn len(&self) -> usize {
+        self.lock().values.len()
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.len() == 0
+    }
+
+    /// Removes every entry.
+    pub fn clear(&self) {
+        *self.lock() = Entries::default();
+    }
+
+    fn lock(&self) -> std::sync::MutexGuard<'_, Entries> {
+        self.entries.lock().unwrap_or_else(|err| err.into_inner())
+    }
+}
+
+impl Cache for LruCache {
+    fn get(&self, key: &str) -> Option<Vec<u8>> {
+        let mut entries = self.lock();
+        let tick = entries.tick;
+        let (value, used) = entries.values.get_mut(key)?;
+        let (value, previous) = (value.clone(), std::mem::replace(used, tick));
+        let key = entries
+            .order
+            .remove(&previous)
+            .expect("every value has a place in the order");
+        entries.order.insert(tick, key);
+        entries.tick += 1;
+        Some(value)
+    }
+
+    fn set(&self, key: &str, value: Vec<u8>) {
+        let mut entries = self.lock();
+        entries.remove(key);
+        let size = key.len() + value.len();
+        if size > self.capacity {
+            return;
+        }
+        while entries.size + size > self.capacity {
+            let (_, oldest) = entries.order.pop_first().expect("a cache over capacity has entries");
+            let (evicted, _) = entries
+                .values
+                .remove(&oldest)
+                .expect("every key in the order has a value");
+            entries.size -= oldest.len() + evicted.len();
+        }
+        let tick = entries.tick;
+        entries.tick += 1;
+        entries.size += size;
+        entries.order.insert(tick, key.to_owned());
+        entries.values.insert(key.to_owned(), (value, tick));
+    }
+}
+
+impl fmt::Debug for LruCache {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let entries = self.lock();
+        f.debug_struct("LruCache")
+            .field("capacity", &self.capacity)
+            .field("size", &entries.size)
+            .field("len", &entries.values.len())
+            .finish()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn the_cache_stays_within_its_byte_capacity() {
+        let cache = LruCache::new(100);
+        for i in 0..50 {
+            cache.set(&format!("key{i:02}"), vec![0; 20]);
+            assert!(cache.size() <= 100);
+        }
+        // Each entry takes 25 bytes, so the four newest fit.
+        assert_eq!(cache.len(), 4);
+        assert!(cache.get("key46").is_some() && cache.get("key45").is_none());
+
+        cache.set("key47", vec![1; 5]);
+        assert_eq!(cache.size(), 3 * 25 + 10);
+        cache.set("huge", vec![0; 200]);
+        assert_eq!(cache.get("huge"), None);
+        assert_eq!(cache.len(), 4);
+
+        cache.set("key47", vec![0; 200]);
+        assert_eq!(cache.get("key47"), None, "a value too large still drops the old one");
+        cache.clear();
+        assert!(cache.is_empty() && cache.size() == 0);
+    }
+
+    #[test]
+    fn the_cache_is_shared_across_threads() {
+        let cache = Arc::new(LruCache::new(1 << 16));
+        std::thread::scope(|scope| {
+            for worker in 0..8 {
+                let cache = Arc::clone(&cache);
+                scope.spawn(move || {
+                    for i in 0..200 {
+                        let key = format!("{}", (worker * 7 + i) % 64);
+                        match cache.get(&key) {
+                            Some(value) => assert_eq!(value, key.as_bytes()),
+                            None => cache.set(&key, key.clone().into_bytes()),
+                        }
+                    }
+                });
+            }
+        });
+        assert_eq!(cache.len(), 64);
+        let entries = cache.lock();
+        assert_eq!(entries.order.len(), entries.values.len());
+    }
+
+    #[test]
+    fn keys_change_with_every_input() {
+        let key = cache_key("fn main() {}", "Rust", 1, 2);
+        assert_eq!(key.len(), 32);
+        assert_eq!(key, cache_key("fn main() {}", "Rust", 1, 2));
+        for other in [
+            cache_key("fn main() { }", "Rust", 1, 2),
+            cache_key("fn main() {}", "Go", 1, 2),
+            cache_key("fn main() {}", "Rust", 3, 2),
+            cache_key("fn main() {}", "Rust", 1, 3),
+        ] {
+            assert_ne!(key, other);
+        }
+        assert_ne!(fnv1a([&b"ab"[..], b"c"]), fnv1a([&b"a"[..], b"bc"]));
+    }
+}
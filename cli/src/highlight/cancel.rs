@@ -0,0 +1,241 @@
+//! Stopping highlighting early, on request or when a deadline passes.
+
+use super::HighlightError;
+
+use std::fmt;
+use std::sync::Arc;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::time::{Duration, Instant};
+
+/// Why [HighlightError::Cancelled] stopped highlighting.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum CancelReason {
+    /// [CancelToken::cancel] was called.
+    Cancelled,
+    /// The token's deadline passed.
+    DeadlineExceeded,
+}
+
+impl fmt::Display for CancelReason {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            CancelReason::Cancelled => f.write_str("cancelled"),
+            CancelReason::DeadlineExceeded => f.write_str("deadline exceeded"),
+        }
+    }
+}
+
+/// Lets another thread, or a deadline, stop [super::highlight_cancellable] and [super::Lexer::tokenize_cancellable].
+///
+/// Clones share one cancellation flag, so a clone can be handed to whatever decides to stop. Lexing checks the token
+/// before every line; a line is never interrupted halfway, so a single line that takes long to lex (such as a rule
+/// lexer's regular expression backtracking through it) still runs to its end before the check.
+///
+/// # Examples
+///
+/// ```
+/// use std::time::Duration;
+/// use colorizer::highlight::{CancelReason, CancelToken, HighlightError, Lexer};
+/// use colorizer::highlight::lexers::PlainText;
+///
+/// let cancel = CancelToken::new().with_timeout(Duration::from_secs(5));
+/// let mut tokens = Vec::new();
+/// PlainText.tokenize_cancellable("a\nb\n", &cancel, &mut tokens).unwrap();
+///
+/// cancel.cancel();
+/// let err = PlainText.tokenize_cancellable("a\nb\n", &cancel, &mut Vec::new()).unwrap_err();
+/// assert!(matches!(err, HighlightError::Cancelled { reason: CancelReason::Cancelled, offset: 0, .. }));
+/// ```
+#[derive(Debug, Clone, Default)]
+pub struct CancelToken {
+    cancelled: Arc<AtomicBool>,
+    deadline: Option<Instant>,
+    partial: bool,
+}
+
+impl CancelToken {
+    /// Creates a token that only fires once [CancelToken::cancel] is called.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Also fires once `deadline` has passed.
+    pub fn with_deadline(mut self, deadline: Instant) -> Self {
+        self.deadline = Some(deadline);
+        self
+    }
+
+    /// Also fires once `timeout` has passed, counting from now.
+    pub fn with_timeout(self, timeout: Duration) -> Self {
+        self.with_deadline(Instant::now() + timeout)
+    }
+
+    /// Makes a cancelled [super::highlight_cancellable] return the rendering of the lines lexed so far in
+    /// [HighlightError::Cancelled], header and footer included, instead of `None`.
+    pub fn with_partial_output(mut self, enabled: bool) -> Self {
+        self.partial = enabled;
+        self
+    }
+
+    /// Cancels every clone of this token.
+    pub fn cancel(&self) {
+        self.cancelled.store(true, Ordering::Relaxed);
+    }
+
+    /// Returns why the token has fired, or `None` while work may go on.
+    pub fn reason(&self) -> Option<CancelReason> {
+        if self.cancelled.load(Ordering::Relaxed) {
+            Some(CancelReason::Cancelled)
+        } else if self.deadline.is_some_and(|deadline| Instant::now() >= deadline) {
+            Some(CancelReason::DeadlineExceeded)
+        } else {
+            None
+        }
+    }
+
+    pub fn is_cancelled(&self) -> bool {
+        self.reason().is_some()
+    }
+
+    pub(crate) fn wants_partial(&self) -> bool {
+        self.partial
+    }
+
+    /// Fails with [HighlightError::Cancelled] at `offset`, the number of bytes lexed so far, once the token has fired.
+    pub(crate) fn check(&self, offset: usize) -> Result<(), HighlightError> {
+        match self.reason() {
+            Some(reason) => Err(HighlightError::Cancelled { reason, offset, partial: None }),
+            None => Ok(()),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::formatters::HtmlFormatter;
+    use crate::highlight::lexers::{Diff, PlainText};
+    use crate::highlight::token::push_token;
+    use crate::highlight::{Lexer, LexerState, Theme, Token, TokenKind, highlight_cancellable};
+
+    use std::thread;
+
+    /// Spends `delay` on every line, standing in for a pathological pattern.
+    struct Slow {
+        delay: Duration,
+    }
+
+    impl Lexer for Slow {
+        fn name(&self) -> &str {
+            "Slow"
+        }
+
+        fn start(&self) -> Box<dyn LexerState + '_> {
+            Box::new(SlowState { delay: self.delay })
+        }
+    }
+
+    struct SlowState {
+        delay: Duration,
+    }
+
+    impl LexerState for SlowState {
+        fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+            thread::sleep(self.delay);
+            push_token(tokens, TokenKind::Text, offset, offset + line.len());
+            Ok(())
+        }
+    }
+
+    fn theme() -> Theme {
+        Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255))
+    }
+
+    #[test]
+    fn deadline_stops_a_slow_lexer_promptly() {
+        let src = "line\n".repeat(1_000);
+        let lexer = Slow { delay: Duration::from_millis(5) };
+        let started = Instant::now();
+        let cancel = CancelToken::new().with_timeout(Duration::from_millis(50));
+
+        let err = highlight_cancellable(&src, &lexer, &theme(), &HtmlFormatter::new(), &cancel).unwrap_err();
+        let elapsed = started.elapsed();
+        // Finishing would take five seconds; one line past the deadline is the most it may overrun.
+        assert!(elapsed < Duration::from_millis(500), "{elapsed:?}");
+        let HighlightError::Cancelled { reason, offset, partial } = err else { panic!("{err:?}") };
+        assert_eq!(reason, CancelReason::DeadlineExceeded);
+        assert!(offset > 0 && offset < src.len() && offset % 5 == 0, "{offset}");
+        assert_eq!(partial, None);
+    }
+
+    #[test]
+    fn partial_output_renders_the_lines_lexed_before_cancelling() {
+        let src = "one\ntwo\nthree\n";
+        let cancel = CancelToken::new().with_partial_output(true);
+
+        struct CancelAfterTwo<'c>(&'c CancelToken);
+        impl Lexer for CancelAfterTwo<'_> {
+            fn name(&self) -> &str {
+                "CancelAfterTwo"
+            }
+
+            fn start(&self) -> Box<dyn LexerState + '_> {
+                Box::new(CancelAfterTwoState { cancel: self.0, lines: 0 })
+            }
+        }
+        struct CancelAfterTwoState<'c> {
+            cancel: &'c CancelToken,
+            lines: usize,
+        }
+        impl LexerState for CancelAfterTwoState<'_> {
+            fn tokenize_line(
+                &mut self, line: &str, offset: usize, tokens: &mut Vec<Token>,
+            ) -> Result<(), HighlightError> {
+                self.lines += 1;
+                if self.lines == 2 {
+                    self.cancel.cancel();
+                }
+                push_token(tokens, TokenKind::Text, offset, offset + line.len());
+                Ok(())
+            }
+        }
+
+        let formatter = HtmlFormatter::new().with_classes("");
+        let err = highlight_cancellable(src, &CancelAfterTwo(&cancel), &theme(), &formatter, &cancel).unwrap_err();
+        let HighlightError::Cancelled { reason, offset, partial } = err else { panic!("{err:?}") };
+        assert_eq!((reason, offset), (CancelReason::Cancelled, 8));
+        assert_eq!(
+            partial.as_deref(),
+            Some("<pre class=\"pre\"><code>one\ntwo\n</code></pre>")
+        );
+    }
+
+    #[test]
+    fn cancelled_tokenizing_keeps_the_tokens_lexed_so_far() {
+        let cancel = CancelToken::new();
+        let mut tokens = Vec::new();
+        PlainText.tokenize_cancellable("a\n", &cancel, &mut tokens).unwrap();
+        assert_eq!(tokens, [Token::new(TokenKind::Text, 0, 2)]);
+
+        let clone = cancel.clone();
+        clone.cancel();
+        assert_eq!(cancel.reason(), Some(CancelReason::Cancelled));
+        let err = Diff::new()
+            .tokenize_cancellable("+a\n", &cancel, &mut tokens)
+            .unwrap_err();
+        assert!(matches!(err, HighlightError::Cancelled { offset: 0, .. }), "{err:?}");
+        assert_eq!(tokens.len(), 1, "tokens already in the buffer stay");
+    }
+
+    #[test]
+    fn passed_deadlines_fire_and_fresh_tokens_never_do() {
+        assert_eq!(CancelToken::new().reason(), None);
+        let past = CancelToken::new().with_deadline(Instant::now() - Duration::from_millis(1));
+        assert_eq!(past.reason(), Some(CancelReason::DeadlineExceeded));
+        assert_eq!(
+            past.check(3).unwrap_err().to_string(),
+            "highlighting stopped at byte 3: deadline exceeded"
+        );
+    }
+}
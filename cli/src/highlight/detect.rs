@@ -0,0 +1,650 @@
+//! Language detection from file names, shebang lines, and content heuristics.
+
+use super::Lexer;
+use super::lexers::{self, PlainText};
+
+use std::path::Path;
+
+/// A detected language and how sure the detector is about it.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Detection {
+    /// Lookup key suitable for [super::lexers::find] (e.g., "go", "cpp").
+    pub language: &'static str,
+    /// Confidence in [0, 1].
+    pub confidence: f32,
+}
+
+impl Detection {
+    const fn new(language: &'static str, confidence: f32) -> Self {
+        Self { language, confidence }
+    }
+}
+
+/// Confidence for an exact special file name like `Makefile`.
+const FILENAME_CONFIDENCE: f32 = 1.0;
+/// Confidence for an unambiguous extension.
+const EXTENSION_CONFIDENCE: f32 = 0.9;
+/// Confidence for an Emacs or Vim modeline naming the file's mode.
+const MODELINE_CONFIDENCE: f32 = 0.9;
+/// Confidence for an interpreter named on a shebang line.
+const SHEBANG_CONFIDENCE: f32 = 0.8;
+/// Confidence for an ambiguous extension resolved by content.
+const DISAMBIGUATED_CONFIDENCE: f32 = 0.7;
+
+const FILENAMES: &[(&str, &str)] = &[
+    ("Makefile", "makefile"),
+    ("makefile", "makefile"),
+    ("GNUmakefile", "makefile"),
+    ("Dockerfile", "dockerfile"),
+    ("Containerfile", "dockerfile"),
+    ("CMakeLists.txt", "cmake"),
+    ("Gemfile", "ruby"),
+    ("Rakefile", "ruby"),
+    ("Podfile", "ruby"),
+    ("Vagrantfile", "ruby"),
+    (".bashrc", "bash"),
+    (".bash_profile", "bash"),
+    (".profile", "bash"),
+    (".zshrc", "bash"),
+    ("Cargo.lock", "toml"),
+    (".env", "dotenv"),
+    (".gitconfig", "gitconfig"),
+    (".gitmodules", "gitconfig"),
+];
+
+const EXTENSIONS: &[(&str, &str)] = &[
+    ("go", "go"),
+    ("rs", "rust"),
+    ("py", "python"),
+    ("pyi", "python"),
+    ("pyw", "python"),
+    ("js", "javascript"),
+    ("mjs", "javascript"),
+    ("cjs", "javascript"),
+    ("jsx", "javascript"),
+    ("ts", "typescript"),
+    ("mts", "typescript"),
+    ("cts", "typescript"),
+    ("tsx", "tsx"),
+    ("json", "json"),
+    ("elm", "elm"),
+    ("c", "c"),
+    ("cc", "cpp"),
+    ("cpp", "cpp"),
+    ("cxx", "cpp"),
+    ("hh", "cpp"),
+    ("hpp", "cpp"),
+    ("hxx", "cpp"),
+    ("mm", "objective-c++"),
+    ("java", "java"),
+    ("kt", "kotlin"),
+    ("swift", "swift"),
+    ("cs", "c#"),
+    ("rb", "ruby"),
+    ("php", "php"),
+    ("pl", "perl"),
+    ("lua", "lua"),
+    ("sh", "bash"),
+    ("bash", "bash"),
+    ("zsh", "bash"),
+    ("hs", "haskell"),
+    ("ex", "elixir"),
+    ("exs", "elixir"),
+    ("html", "html"),
+    ("htm", "html"),
+    ("xml", "xml"),
+    ("css", "css"),
+    ("scss", "scss"),
+    ("md", "markdown"),
+    ("markdown", "markdown"),
+    ("yml", "yaml"),
+    ("yaml", "yaml"),
+    ("toml", "toml"),
+    ("ini", "ini"),
+    ("cfg", "ini"),
+    ("env", "dotenv"),
+    ("properties", "properties"),
+    ("gitconfig", "gitconfig"),
+    ("sql", "sql"),
+    ("diff", "diff"),
+    ("patch", "diff"),
+    ("mk", "makefile"),
+    ("dockerfile", "dockerfile"),
+    ("proto", "protobuf"),
+    ("graphql", "graphql"),
+    ("graphqls", "graphql"),
+    ("gql", "graphql"),
+];
+
+const INTERPRETERS: &[(&str, &str)] = &[
+    ("python", "python"),
+    ("pypy", "python"),
+    ("node", "javascript"),
+    ("nodejs", "javascript"),
+    ("bun", "javascript"),
+    ("deno", "typescript"),
+    ("ts-node", "typescript"),
+    ("sh", "bash"),
+    ("bash", "bash"),
+    ("dash", "bash"),
+    ("ksh", "bash"),
+    ("zsh", "bash"),
+    ("ruby", "ruby"),
+    ("perl", "perl"),
+    ("php", "php"),
+    ("lua", "lua"),
+    ("elixir", "elixir"),
+    ("runghc", "haskell"),
+];
+
+/// Editor mode names that aren't also a language key or extension above.
+const MODES: &[(&str, &str)] = &[
+    ("shell-script", "bash"),
+    ("c++", "cpp"),
+    ("js2", "javascript"),
+    ("jsonc", "json"),
+    ("gfm", "markdown"),
+    ("make", "makefile"),
+    ("conf-toml", "toml"),
+    ("cperl", "perl"),
+    ("nxml", "xml"),
+    ("objc", "objective-c"),
+    ("dosini", "ini"),
+    ("conf-javaprop", "properties"),
+];
+
+/// Lines at each end of a file that editors look for modelines in.
+const MODELINE_LINES: usize = 5;
+/// Bytes at each end of a file searched for modelines, so a huge single-line file isn't read through.
+const MODELINE_SCAN: usize = 4096;
+
+/// Guesses the language of a file from its name and contents.
+///
+/// Checks, in order: special file names, unambiguous extensions, modelines ([detect_modeline]), the shebang line, and
+/// content heuristics. Ambiguous extensions (`.h`, `.m`) are resolved by a modeline if there is one, and by content
+/// otherwise. Returns `None` when nothing matches; callers that know the language should look its lexer up directly.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::detect_language;
+///
+/// let detection = detect_language("deploy", "#!/usr/bin/env python3\nprint('hi')\n").unwrap();
+/// assert_eq!(detection.language, "python");
+///
+/// let header = detect_language("widget.h", "namespace ui {\nclass Widget {};\n}\n").unwrap();
+/// assert_eq!(header.language, "cpp");
+/// ```
+pub fn detect_language(filename: &str, contents: &str) -> Option<Detection> {
+    let path = Path::new(filename);
+    let name = path.file_name().and_then(|name| name.to_str()).unwrap_or("");
+
+    if let Some(&(_, language)) = FILENAMES.iter().find(|(known, _)| *known == name) {
+        return Some(Detection::new(language, FILENAME_CONFIDENCE));
+    }
+    if name.starts_with("Dockerfile.") {
+        return Some(Detection::new("dockerfile", FILENAME_CONFIDENCE));
+    }
+    if name.starts_with(".env.") {
+        return Some(Detection::new("dotenv", FILENAME_CONFIDENCE));
+    }
+
+    let extension = path
+        .extension()
+        .and_then(|ext| ext.to_str())
+        .map(str::to_ascii_lowercase);
+    if let Some(ext) = extension.as_deref()
+        && let Some(&(_, language)) = EXTENSIONS.iter().find(|(known, _)| *known == ext)
+    {
+        return Some(Detection::new(language, EXTENSION_CONFIDENCE));
+    }
+    if let Some(detection) = detect_modeline(contents) {
+        return Some(detection);
+    }
+    match extension.as_deref() {
+        Some("h") => return Some(Detection::new(classify_header(contents), DISAMBIGUATED_CONFIDENCE)),
+        Some("m") => return Some(Detection::new(classify_m_file(contents), DISAMBIGUATED_CONFIDENCE)),
+        _ => {}
+    }
+
+    detect_shebang(contents).or_else(|| detect_content(contents))
+}
+
+/// Finds a lexer for a file, falling back to [PlainText] with zero confidence so callers always get output.
+///
+/// File name patterns of lexers registered with [lexers::Registry::global] are checked first. Extensions missing
+/// from the built-in table are also looked up among the bundled and registered lexers.
+pub fn detect_lexer(filename: &str, contents: &str) -> (Box<dyn Lexer>, f32) {
+    if let Some(lexer) = lexers::Registry::global().registered_filename(filename) {
+        return (Box::new(lexer), FILENAME_CONFIDENCE);
+    }
+    if let Some(detection) = detect_language(filename, contents)
+        && let Some(lexer) = lexers::find(detection.language)
+    {
+        return (Box::new(lexer), detection.confidence);
+    }
+    let extension = Path::new(filename).extension().and_then(|ext| ext.to_str());
+    if let Some(lexer) = extension.and_then(lexers::find) {
+        return (Box::new(lexer), EXTENSION_CONFIDENCE);
+    }
+    (Box::new(PlainText), 0.0)
+}
+
+/// Finds the language an Emacs or Vim modeline in the first or last five lines of `contents` names.
+///
+/// Recognizes Emacs `-*- mode: ruby -*-` (or just `-*- ruby -*-`) lines, Vim `vim: ft=sh` and `vim: set filetype=sh
+/// :` modelines (`vi:` and `ex:` too), and, failing those, an Emacs `Local Variables:` block near the end. Mode names
+/// map to lookup keys for [super::lexers::find], such as `sh` to `bash` and `c++` to `cpp`. The first modeline that
+/// names a known language wins; malformed ones and unknown modes are skipped. Only the first and last 4 KiB are
+/// searched, so a file that is one enormous line costs no more than a short one.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::detect_modeline;
+///
+/// let vagrantfile = "# -*- mode: ruby -*-\n# vi: set ft=ruby :\nVagrant.configure(\"2\") do |config|\nend\n";
+/// assert_eq!(detect_modeline(vagrantfile).unwrap().language, "ruby");
+/// assert_eq!(detect_modeline("# vim: set ts=4 :\n"), None);
+/// ```
+pub fn detect_modeline(contents: &str) -> Option<Detection> {
+    let mut head = MODELINE_SCAN.min(contents.len());
+    while !contents.is_char_boundary(head) {
+        head -= 1;
+    }
+    let mut tail = contents.len().saturating_sub(MODELINE_SCAN);
+    while !contents.is_char_boundary(tail) {
+        tail += 1;
+    }
+    // A short file's first lines aren't also taken as its last.
+    let first = contents[..head].split_inclusive('\n').take(MODELINE_LINES);
+    let after_first = first.clone().map(str::len).sum::<usize>().max(tail);
+    let mut last: Vec<&str> = contents[after_first..].lines().rev().take(MODELINE_LINES).collect();
+    last.reverse();
+    first
+        .map(|line| line.trim_end_matches(['\n', '\r']))
+        .chain(last)
+        .find_map(|line| emacs_mode(line).or_else(|| vim_filetype(line)))
+        .or_else(|| local_variables_mode(&contents[tail..]))
+        .map(|language| Detection::new(language, MODELINE_CONFIDENCE))
+}
+
+/// Reads the mode from an Emacs `-*- mode: X; ... -*-` line.
+fn emacs_mode(line: &str) -> Option<&'static str> {
+    let (_, rest) = line.split_once("-*-")?;
+    let (vars, _) = rest.split_once("-*-")?;
+    let vars = vars.trim();
+    if !vars.contains(':') {
+        return mode_language(vars);
+    }
+    vars.split(';').find_map(|var| {
+        let (name, value) = var.split_once(':')?;
+        if name.trim().eq_ignore_ascii_case("mode") { mode_language(value.trim()) } else { None }
+    })
+}
+
+/// Reads the file type from a Vim modeline, which follows `vi:`, `vim:`, or `ex:` at the start of the line or after
+/// whitespace, as options separated by spaces or colons, or after `set` up to the closing colon.
+fn vim_filetype(line: &str) -> Option<&'static str> {
+    for marker in ["vim:", "Vim:", "vi:", "ex:"] {
+        let mut from = 0;
+        while let Some(found) = line[from..].find(marker) {
+            let at = from + found;
+            from = at + marker.len();
+            if line[..at].chars().next_back().is_some_and(|ch| !ch.is_whitespace()) {
+                continue;
+            }
+            let options = line[from..].trim_start();
+            let options = match options.strip_prefix("set ").or_else(|| options.strip_prefix("se ")) {
+                Some(set) => set.split(':').next().unwrap_or(""),
+                None => options,
+            };
+            let filetype = options
+                .split(|ch: char| ch == ':' || ch.is_whitespace())
+                .find_map(|option| {
+                    let (name, value) = option.split_once('=')?;
+                    matches!(name, "ft" | "filetype" | "syn" | "syntax").then_some(value)
+                });
+            if let Some(language) = filetype.and_then(mode_language) {
+                return Some(language);
+            }
+        }
+    }
+    None
+}
+
+/// Reads `mode: X` from an Emacs `Local Variables:` block, whose lines up to `End:` all start with the text before the
+/// opening line's `Local Variables:`.
+fn local_variables_mode(text: &str) -> Option<&'static str> {
+    let mut lines = text.lines();
+    let prefix = lines.find_map(|line| Some(&line[..line.find("Local Variables:")?]))?;
+    let mut mode = None;
+    for line in lines {
+        let var = line.strip_prefix(prefix)?.trim();
+        if var.starts_with("End:") {
+            return mode.and_then(mode_language);
+        }
+        if let Some((name, value)) = var.split_once(':')
+            && name.trim().eq_ignore_ascii_case("mode")
+        {
+            mode = mode.or(Some(value.trim()));
+        }
+    }
+    // Emacs ignores a block that never ends.
+    None
+}
+
+/// Maps an Emacs mode or Vim file type to a language key, or `None` for one colorizer doesn't know.
+fn mode_language(mode: &str) -> Option<&'static str> {
+    let mode = mode.to_ascii_lowercase();
+    let mode = mode.strip_suffix("-mode").unwrap_or(&mode);
+    MODES
+        .iter()
+        .chain(EXTENSIONS)
+        .find(|(known, _)| *known == mode)
+        .or_else(|| EXTENSIONS.iter().find(|(_, language)| *language == mode))
+        .map(|&(_, language)| language)
+}
+
+/// Maps a `#!` line to a language, handling `/usr/bin/env` (including `env -S`) and versioned interpreters.
+fn detect_shebang(contents: &str) -> Option<Detection> {
+    let line = contents.lines().next()?.strip_prefix("#!")?;
+    let mut words = line.split_whitespace();
+    let mut program = words.next()?.rsplit('/').next()?;
+    if program == "env" {
+        program = words.find(|word| !word.starts_with('-') && !word.contains('='))?;
+    }
+
+    let base = program.trim_end_matches(|ch: char| ch.is_ascii_digit() || ch == '.');
+    INTERPRETERS
+        .iter()
+        .find(|(interpreter, _)| *interpreter == base)
+        .map(|&(_, language)| Detection::new(language, SHEBANG_CONFIDENCE))
+}
+
+/// Lightweight content sniffing for files without a usable name.
+fn detect_content(contents: &str) -> Option<Detection> {
+    let trimmed = contents.trim_start_matches('\u{feff}').trim_start();
+    let lines = || trimmed.lines().map(str::trim);
+
+    if trimmed.starts_with("<?php") {
+        return Some(Detection::new("php", 0.9));
+    }
+    if trimmed.starts_with("<?xml") {
+        return Some(Detection::new("xml", 0.8));
+    }
+    let head: String = trimmed.chars().take(64).collect::<String>().to_ascii_lowercase();
+    if head.starts_with("<!doctype html") || head.starts_with("<html") {
+        return Some(Detection::new("html", 0.8));
+    }
+    if looks_like_json(trimmed) {
+        return Some(Detection::new("json", 0.6));
+    }
+    if lines().any(|line| line.starts_with("package ")) && lines().any(|line| line.starts_with("func ")) {
+        return Some(Detection::new("go", 0.7));
+    }
+    if trimmed.starts_with("diff --git ")
+        || (trimmed.starts_with("--- ") && lines().any(|line| line.starts_with("@@ ")))
+    {
+        return Some(Detection::new("diff", 0.7));
+    }
+    if lines().any(|line| line.starts_with("fn ") || line.starts_with("pub fn ") || line.starts_with("use std::")) {
+        return Some(Detection::new("rust", 0.5));
+    }
+    if lines().any(|line| line.starts_with("def ") && line.ends_with(':'))
+        || lines().any(|line| line.starts_with("from ") && line.contains(" import "))
+    {
+        return Some(Detection::new("python", 0.5));
+    }
+    if lines().any(|line| line.starts_with("#include")) {
+        return Some(Detection::new(classify_header(contents), 0.4));
+    }
+    if trimmed.starts_with("---\n") {
+        return Some(Detection::new("yaml", 0.4));
+    }
+    None
+}
+
+/// Checks for an object or array whose first element looks like JSON (a quoted key or a JSON value).
+fn looks_like_json(trimmed: &str) -> bool {
+    let rest = match trimmed.strip_prefix('{') {
+        Some(rest) => rest.trim_start(),
+        None => return trimmed.starts_with('[') && trimmed.trim_end().ends_with(']'),
+    };
+    rest.starts_with('}') || (rest.starts_with('"') && rest.split_once("\":").is_some())
+}
+
+/// Resolves a `.h` header to C, C++, or Objective-C.
+fn classify_header(contents: &str) -> &'static str {
+    const OBJC: &[&str] = &["@interface", "@implementation", "@protocol", "#import", "@end"];
+    const CPP: &[&str] = &[
+        "namespace ",
+        "class ",
+        "template<",
+        "template <",
+        "std::",
+        "public:",
+        "private:",
+        "#include <iostream>",
+    ];
+
+    if OBJC.iter().any(|marker| contents.contains(marker)) {
+        "objective-c"
+    } else if CPP.iter().any(|marker| contents.contains(marker)) {
+        "cpp"
+    } else {
+        "c"
+    }
+}
+
+/// Resolves a `.m` file to Objective-C or MATLAB.
+fn classify_m_file(contents: &str) -> &'static str {
+    const OBJC: &[&str] = &["@interface", "@implementation", "#import", "@end", "[self "];
+
+    if OBJC.iter().any(|marker| contents.contains(marker)) {
+        "objective-c"
+    } else if contents.lines().any(|line| {
+        let line = line.trim_start();
+        line.starts_with('%') || line.starts_with("function ") || line == "end"
+    }) {
+        "matlab"
+    } else {
+        "objective-c"
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn language(filename: &str, contents: &str) -> Option<&'static str> {
+        detect_language(filename, contents).map(|detection| detection.language)
+    }
+
+    #[test]
+    fn detects_real_world_files() {
+        let cases: &[(&str, &str, &str)] = &[
+            ("sample.go", include_str!("../../../examples/languages/sample.go"), "go"),
+            (
+                "sample.rs",
+                include_str!("../../../examples/languages/sample.rs"),
+                "rust",
+            ),
+            (
+                "sample.py",
+                include_str!("../../../examples/languages/sample.py"),
+                "python",
+            ),
+            (
+                "sample.js",
+                include_str!("../../../examples/languages/sample.js"),
+                "javascript",
+            ),
+            (
+                "sample.ts",
+                include_str!("../../../examples/languages/sample.ts"),
+                "typescript",
+            ),
+            (
+                "sample.elm",
+                include_str!("../../../examples/languages/sample.elm"),
+                "elm",
+            ),
+            ("src/App.tsx", "export const App = () => <div />;\n", "tsx"),
+            ("package.json", "{\n  \"name\": \"colorizer\"\n}\n", "json"),
+            ("Makefile", "all:\n\tcargo build\n", "makefile"),
+            ("docker/Dockerfile.dev", "FROM rust:1.85\n", "dockerfile"),
+            ("Gemfile", "source 'https://rubygems.org'\n", "ruby"),
+            ("/home/me/.bashrc", "export PATH=$HOME/bin:$PATH\n", "bash"),
+            ("config.YML", "key: value\n", "yaml"),
+            (
+                "/home/me/.gitconfig",
+                include_str!("../../../examples/languages/sample.gitconfig"),
+                "gitconfig",
+            ),
+            (
+                ".gitmodules",
+                "[submodule \"vendor/lib\"]\n\tpath = vendor/lib\n",
+                "gitconfig",
+            ),
+            (".env", include_str!("../../../examples/languages/sample.env"), "dotenv"),
+            (".env.production", "export NODE_ENV=production\n", "dotenv"),
+            (
+                "src/main/resources/application.properties",
+                "server.port=8080\n",
+                "properties",
+            ),
+            ("setup.cfg", "[metadata]\nname = colorizer\n", "ini"),
+            ("deploy", "#!/usr/bin/env python3\nimport sys\n", "python"),
+            ("install", "#!/bin/bash\nset -euo pipefail\n", "bash"),
+            (
+                "serve",
+                "#!/usr/bin/env -S node --experimental-modules\nconsole.log(1)\n",
+                "javascript",
+            ),
+            ("task", "#!/usr/bin/env -S deno run --allow-net\n", "typescript"),
+            ("bench", "#!/usr/bin/perl -w\nuse strict;\n", "perl"),
+            ("index", "<?php\necho 'hi';\n", "php"),
+            ("page", "<!DOCTYPE html>\n<html></html>\n", "html"),
+            ("feed", "<?xml version=\"1.0\"?>\n<rss/>\n", "xml"),
+            ("main", "package main\n\nimport \"fmt\"\n\nfunc main() {}\n", "go"),
+            ("response", "{\"ok\": true, \"items\": []}", "json"),
+            ("changes", "diff --git a/x b/x\n--- a/x\n+++ b/x\n@@ -1 +1 @@\n", "diff"),
+            (
+                "script",
+                "from pathlib import Path\n\ndef main():\n    pass\n",
+                "python",
+            ),
+        ];
+        for &(filename, contents, expected) in cases {
+            assert_eq!(language(filename, contents), Some(expected), "{filename}");
+        }
+    }
+
+    #[test]
+    fn ambiguous_extensions_are_resolved_by_content() {
+        assert_eq!(language("list.h", "struct list { int len; };\n"), Some("c"));
+        assert_eq!(language("vec.h", "template <typename T>\nclass Vec {};\n"), Some("cpp"));
+        assert_eq!(
+            language("View.h", "#import <UIKit/UIKit.h>\n@interface View : UIView\n@end\n"),
+            Some("objective-c")
+        );
+        assert_eq!(
+            language("AppDelegate.m", "@implementation AppDelegate\n@end\n"),
+            Some("objective-c")
+        );
+        assert_eq!(
+            language(
+                "solve.m",
+                "function x = solve(a, b)\n  % least squares\n  x = a \\ b;\nend\n"
+            ),
+            Some("matlab")
+        );
+    }
+
+    #[test]
+    fn confidence_reflects_the_evidence() {
+        let by_extension = detect_language("main.go", "").unwrap();
+        let by_shebang = detect_language("run", "#!/bin/sh\n").unwrap();
+        let by_content = detect_language("snippet", "fn main() {}\n").unwrap();
+        assert!(by_extension.confidence > by_shebang.confidence);
+        assert!(by_shebang.confidence > by_content.confidence);
+    }
+
+    #[test]
+    fn unknown_input_is_undetected() {
+        assert_eq!(language("notes", "just some words\n"), None);
+        assert_eq!(language("", ""), None);
+        assert_eq!(language("run", "#!/opt/custom/interpreter\n"), None);
+    }
+
+    #[test]
+    fn modelines_name_the_language_of_extensionless_files() {
+        let vagrantfile = "# -*- mode: ruby -*-\n# vi: set ft=ruby :\n\nVagrant.configure(\"2\") do |config|\n  \
+                           config.vm.box = \"debian/bookworm64\"\nend\n";
+        assert_eq!(language("infra/Vagrantfile.local", vagrantfile), Some("ruby"));
+        assert_eq!(detect_modeline(vagrantfile).unwrap().confidence, MODELINE_CONFIDENCE);
+
+        let bashrc = "alias ll='ls -l'\nexport EDITOR=vim\n\n# vim: set ft=sh ts=2 sw=2 :\n";
+        assert_eq!(language("dotfiles/bashrc", bashrc), Some("bash"));
+        assert_eq!(
+            language("settings", "{\n  // comments are fine\n}\n// vim: ft=jsonc\n"),
+            Some("json")
+        );
+        assert_eq!(
+            language("widget.h", "#include <stddef.h>\n// -*- c++ -*-\n"),
+            Some("cpp")
+        );
+        assert_eq!(
+            language(
+                "notes",
+                "some text\n\n# Local Variables:\n# fill-column: 80\n# mode: python\n# End:\n"
+            ),
+            Some("python")
+        );
+    }
+
+    #[test]
+    fn the_first_modeline_wins_and_outranks_content() {
+        let conflicting = "# -*- mode: python -*-\nfn main() {}\n# vim: ft=ruby\n";
+        assert_eq!(language("script", conflicting), Some("python"));
+        assert_eq!(language("script", "fn main() {}\n// vim: ft=c++\n"), Some("cpp"));
+        // An explicit file name still decides first.
+        assert_eq!(language("main.rs", "// vim: ft=python\n"), Some("rust"));
+    }
+
+    #[test]
+    fn malformed_and_distant_modelines_are_ignored() {
+        for contents in [
+            "# -*- -*-\n",
+            "# -*- mode: -*-\n",
+            "# -*- mode: cobol-ish -*-\n",
+            "# vim: set ts=4 :\n",
+            "# vim: ft=\n",
+            "# novim: ft=python\n",
+            "# Local Variables:\n# mode: python\n",
+        ] {
+            assert_eq!(detect_modeline(contents), None, "{contents:?}");
+        }
+        // Unknown modes give way to a later modeline that names a known one.
+        assert_eq!(language("x", "# -*- mode: cobol -*-\n# vim: ft=go\n"), Some("go"));
+
+        let middle = "x\n".repeat(20);
+        assert_eq!(detect_modeline(&format!("{middle}# vim: ft=python\n{middle}")), None);
+        let long_line = format!("{}# vim: ft=python\n", "x".repeat(3 * MODELINE_SCAN));
+        assert_eq!(detect_modeline(&long_line).unwrap().language, "python");
+        let buried = format!(
+            "{} # vim: ft=python {}\n",
+            "x".repeat(2 * MODELINE_SCAN),
+            "x".repeat(2 * MODELINE_SCAN)
+        );
+        assert_eq!(detect_modeline(&buried), None);
+    }
+
+    #[test]
+    fn undetected_files_fall_back_to_plain_text() {
+        let (lexer, confidence) = detect_lexer("notes", "just some words\n");
+        assert_eq!(lexer.name(), "Plain Text");
+        assert_eq!(confidence, 0.0);
+    }
+}
@@ -0,0 +1,819 @@
+//! Rich diffs between two versions of a document, with both sides highlighted.
+
+use super::formatters::Position;
+use super::lexers::changed_words;
+use super::{Formatter, HighlightError, Lexer, Theme, Token, TokenKind};
+
+use std::iter;
+use std::ops::Range;
+
+/// Marker shown after a changed last line that doesn't end in a newline, as `diff` prints it.
+pub(crate) const NO_NEWLINE: &str = "\\ No newline at end of file";
+
+/// How [highlight_diff] lays out the two versions.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum DiffMode {
+    /// One column, removed lines before the added lines that replace them, like a unified diff.
+    #[default]
+    Inline,
+    /// The old version on the left and the new one on the right, changed lines side by side.
+    SideBySide,
+}
+
+/// Layout and collapsing options for [highlight_diff].
+#[derive(Debug, Clone, Default)]
+pub struct DiffOptions {
+    mode: DiffMode,
+    context: Option<usize>,
+}
+
+impl DiffOptions {
+    /// Creates options for an inline diff showing every line.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn with_mode(mut self, mode: DiffMode) -> Self {
+        self.mode = mode;
+        self
+    }
+
+    /// Keeps only `lines` unchanged lines around each change and collapses longer unchanged stretches into a
+    /// [DiffRow::Collapsed] marker counting the lines left out, like the hunks of `diff -U`.
+    pub fn with_context(mut self, lines: usize) -> Self {
+        self.context = Some(lines);
+        self
+    }
+}
+
+/// What happened to a line between the two versions.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum LineChange {
+    Unchanged,
+    Added,
+    Removed,
+}
+
+/// A highlighted line of either version.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DiffLine<'a> {
+    /// One-based number of the line in its own version.
+    pub number: usize,
+    /// The line without its line ending.
+    pub text: &'a str,
+    /// Tokens covering `text`, with offsets relative to it.
+    pub tokens: Vec<Token>,
+    pub change: LineChange,
+    /// The words that differ from the line this one replaces or is replaced by, when the two have something in
+    /// common (see [super::lexers::Diff::with_inline_changes]).
+    pub emphasis: Option<Range<usize>>,
+}
+
+impl<'a> DiffLine<'a> {
+    /// Iterates over the line's tokens as `(kind, text, emphasized)`, splitting tokens that straddle the emphasis.
+    pub(crate) fn spans(&self) -> impl Iterator<Item = (TokenKind, &'a str, bool)> + '_ {
+        let text = self.text;
+        let emphasis = self.emphasis.clone().unwrap_or(0..0);
+        self.tokens.iter().flat_map(move |token| {
+            let clamp = |at: usize| at.clamp(token.start, token.end);
+            let cuts = [token.start, clamp(emphasis.start), clamp(emphasis.end), token.end];
+            (0..3).filter_map(move |piece| {
+                let (start, end) = (cuts[piece], cuts[piece + 1]);
+                (start < end).then(|| (token.kind, &text[start..end], piece == 1))
+            })
+        })
+    }
+
+    /// The `+`, `-`, or blank that marks the line's change, and the kind it is styled as.
+    pub(crate) fn sign(&self) -> (&'static str, TokenKind) {
+        match self.change {
+            LineChange::Unchanged => (" ", TokenKind::Whitespace),
+            LineChange::Added => ("+", TokenKind::GenericInserted),
+            LineChange::Removed => ("-", TokenKind::GenericDeleted),
+        }
+    }
+}
+
+/// A row of a rich diff, as handed to [Formatter::write_diff].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum DiffRow<'a> {
+    /// In [DiffMode::Inline], an unchanged line (both sides set) or a removed or added one (only that side set). In
+    /// [DiffMode::SideBySide], a line of either version or both, aligned.
+    Lines {
+        old: Option<DiffLine<'a>>,
+        new: Option<DiffLine<'a>>,
+    },
+    /// A stretch of unchanged lines left out, by count.
+    Collapsed { lines: usize },
+    /// Follows the changed last line of a version that doesn't end in a newline; the flags say which versions.
+    NoNewline { old: bool, new: bool },
+}
+
+impl DiffRow<'_> {
+    /// Text standing in for a [DiffRow::Collapsed] row.
+    pub(crate) fn collapsed_label(lines: usize) -> String {
+        format!("⋯ {lines} unchanged lines")
+    }
+}
+
+/// Highlights `old` and `new` with `lexer` and renders the differences between their lines with `formatter`.
+///
+/// Lines are matched with Myers' algorithm, the one behind `diff` and `git diff`, so the changes are as few as
+/// possible. Each version is lexed once as a whole, so strings and comments that span lines are styled as they are
+/// in that version, and every line keeps its token colors with the background of its change painted behind them
+/// ([Theme::diff_added_color] and [Theme::diff_removed_color]). When a run of removed lines is replaced by added
+/// ones, the lines are paired in order and the words that differ within each pair get [Theme::diff_changed_color].
+///
+/// Like `diff`, a last line with a newline and one without differ, and a changed last line without one is followed
+/// by a [DiffRow::NoNewline] note.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::{DiffOptions, Theme, highlight_diff};
+/// use colorizer::highlight::formatters::AnsiFormatter;
+/// use colorizer::highlight::lexers::PlainText;
+/// use colorizer::terminal::ColorProfile;
+///
+/// let theme = Theme::new("Plain", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+/// let formatter = AnsiFormatter::new().with_profile(ColorProfile::NoColor);
+/// let out = highlight_diff("a\nb\nc\n", "a\nB\nc\n", &PlainText, &theme, &formatter, &DiffOptions::new()).unwrap();
+/// assert_eq!(out, " a\n-b\n+B\n c\n");
+/// ```
+pub fn highlight_diff(
+    old: &str, new: &str, lexer: &dyn Lexer, theme: &Theme, formatter: &dyn Formatter, options: &DiffOptions,
+) -> Result<String, HighlightError> {
+    let rows = diff_rows(old, new, lexer, options)?;
+    let mut out = String::with_capacity((old.len() + new.len()) * 2);
+    formatter.write_diff(&rows, options.mode, theme, &mut out);
+    Ok(out)
+}
+
+/// Computes the rows [highlight_diff] renders, for formatters of your own.
+pub fn diff_rows<'a>(
+    old: &'a str, new: &'a str, lexer: &dyn Lexer, options: &DiffOptions,
+) -> Result<Vec<DiffRow<'a>>, HighlightError> {
+    let old = Version::new(old, lexer)?;
+    let new = Version::new(new, lexer)?;
+    let blocks = blocks(&edits(&old.lines, &new.lines));
+
+    let mut rows = Vec::new();
+    for (index, block) in blocks.iter().enumerate() {
+        match block {
+            Block::Same { old: lines, new: start } => {
+                // Stretches at either end of the document only need context on the side facing a change.
+                let keep = options.context.map(|context| {
+                    let before = if index == 0 { 0 } else { context };
+                    let after = if index == blocks.len() - 1 { 0 } else { context };
+                    (before, after)
+                });
+                push_unchanged(&mut rows, &old, &new, lines.clone(), *start, keep);
+            }
+            Block::Change { old: removed, new: added } => {
+                push_changes(&mut rows, &old, &new, removed.clone(), added.clone(), options.mode);
+            }
+        }
+    }
+    Ok(rows)
+}
+
+/// One version of the document, split into lines and lexed.
+struct Version<'a> {
+    src: &'a str,
+    /// Lines including their line endings, so a missing final newline makes the last line differ.
+    lines: Vec<&'a str>,
+    starts: Vec<usize>,
+    tokens: Vec<Token>,
+}
+
+impl<'a> Version<'a> {
+    fn new(src: &'a str, lexer: &dyn Lexer) -> Result<Self, HighlightError> {
+        let lines: Vec<&str> = src.split_inclusive('\n').collect();
+        let starts = lines
+            .iter()
+            .scan(0, |offset, line| {
+                let start = *offset;
+                *offset += line.len();
+                Some(start)
+            })
+            .collect();
+        Ok(Self { src, lines, starts, tokens: lexer.tokenize(src)? })
+    }
+
+    fn line(&self, index: usize, change: LineChange) -> DiffLine<'a> {
+        let line = self.lines[index];
+        let text = match line.strip_suffix('\n') {
+            Some(line) => line.strip_suffix('\r').unwrap_or(line),
+            None => line,
+        };
+        let start = self.starts[index];
+        DiffLine {
+            number: index + 1,
+            text,
+            tokens: clip(&self.tokens, start..start + text.len()),
+            change,
+            emphasis: None,
+        }
+    }
+
+    /// Whether line `index` is the last one and doesn't end in a newline.
+    fn lacks_newline(&self, index: usize) -> bool {
+        index + 1 == self.lines.len() && !self.src.ends_with('\n')
+    }
+}
+
+/// The parts of `tokens` (sorted, as lexers produce them) that fall within `range`, relative to its start.
+fn clip(tokens: &[Token], range: Range<usize>) -> Vec<Token> {
+    let first = tokens.partition_point(|token| token.end <= range.start);
+    tokens[first..]
+        .iter()
+        .take_while(|token| token.start < range.end)
+        .filter_map(|token| {
+            let (start, end) = (token.start.max(range.start), token.end.min(range.end));
+            (start < end).then(|| Token::new(token.kind, start - range.start, end - range.start))
+        })
+        .collect()
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Edit {
+    Keep,
+    Delete,
+    Insert,
+}
+
+/// Finds a shortest edit script turning lines `a` into `b`, matching the common prefix and suffix up front since
+/// most edits leave both.
+fn edits(a: &[&str], b: &[&str]) -> Vec<Edit> {
+    let prefix = a.iter().zip(b).take_while(|(x, y)| x == y).count();
+    let suffix = a[prefix..]
+        .iter()
+        .rev()
+        .zip(b[prefix..].iter().rev())
+        .take_while(|(x, y)| x == y)
+        .count();
+    let mut edits = vec![Edit::Keep; prefix];
+    edits.extend(myers(&a[prefix..a.len() - suffix], &b[prefix..b.len() - suffix]));
+    edits.extend(iter::repeat_n(Edit::Keep, suffix));
+    edits
+}
+
+/// Myers' O(ND) greedy algorithm: for each number of edits `d`, the furthest point reached on every diagonal `k`,
+/// then a walk back through the saved frontiers.
+fn myers(a: &[&str], b: &[&str]) -> Vec<Edit> {
+    let (n, m) = (a.len() as isize, b.len() as isize);
+    let max = n + m;
+    if max == 0 {
+        return Vec::new();
+    }
+    let offset = max;
+    let mut frontier = vec![0isize; 2 * max as usize + 2];
+    // Only diagonals -d..=d matter for step d, so each saved frontier holds just those.
+    let mut trace = Vec::new();
+    'search: for d in 0..=max {
+        trace.push(frontier[(offset - d) as usize..=(offset + d) as usize].to_vec());
+        for k in (-d..=d).step_by(2) {
+            let at = (offset + k) as usize;
+            let mut x = if k == -d || (k != d && frontier[at - 1] < frontier[at + 1]) {
+                frontier[at + 1]
+            } else {
+                frontier[at - 1] + 1
+            };
+            let mut y = x - k;
+            while x < n && y < m && a[x as usize] == b[y as usize] {
+                x += 1;
+                y += 1;
+            }
+            frontier[at] = x;
+            if x >= n && y >= m {
+                break 'search;
+            }
+        }
+    }
+
+    let mut edits = Vec::with_capacity(max as usize);
+    let (mut x, mut y) = (n, m);
+    for (d, saved) in trace.iter().enumerate().rev() {
+        let d = d as isize;
+        if d == 0 {
+            edits.extend(iter::repeat_n(Edit::Keep, x as usize));
+            break;
+        }
+        let reached = |k: isize| saved[(k + d) as usize];
+        let k = x - y;
+        let previous = if k == -d || (k != d && reached(k - 1) < reached(k + 1)) { k + 1 } else { k - 1 };
+        let (previous_x, previous_y) = (reached(previous), reached(previous) - previous);
+        while x > previous_x && y > previous_y {
+            edits.push(Edit::Keep);
+            x -= 1;
+            y -= 1;
+        }
+        edits.push(if x == previous_x { Edit::Insert } else { Edit::Delete });
+        (x, y) = (previous_x, previous_y);
+    }
+    edits.reverse();
+    edits
+}
+
+/// Runs of an edit script: lines both versions share, or lines removed and added in between.
+#[derive(Debug, PartialEq, Eq)]
+enum Block {
+    Same { old: Range<usize>, new: usize },
+    Change { old: Range<usize>, new: Range<usize> },
+}
+
+fn blocks(edits: &[Edit]) -> Vec<Block> {
+    let mut blocks = Vec::new();
+    let (mut old, mut new) = (0, 0);
+    let mut rest = edits;
+    while !rest.is_empty() {
+        let same = rest.iter().take_while(|&&edit| edit == Edit::Keep).count();
+        if same > 0 {
+            blocks.push(Block::Same { old: old..old + same, new });
+            (old, new) = (old + same, new + same);
+        }
+        let changed = rest[same..].iter().take_while(|&&edit| edit != Edit::Keep);
+        let (removed, added) = changed.fold((0, 0), |(removed, added), edit| match edit {
+            Edit::Delete => (removed + 1, added),
+            _ => (removed, added + 1),
+        });
+        if removed + added > 0 {
+            blocks.push(Block::Change { old: old..old + removed, new: new..new + added });
+            (old, new) = (old + removed, new + added);
+        }
+        rest = &rest[same + removed + added..];
+    }
+    blocks
+}
+
+/// Adds rows for unchanged `lines` of the old version (starting at `start` in the new one), collapsing all but
+/// `keep` lines before and after when the marker would stand for more than one line.
+fn push_unchanged<'a>(
+    rows: &mut Vec<DiffRow<'a>>, old: &Version<'a>, new: &Version<'a>, lines: Range<usize>, start: usize,
+    keep: Option<(usize, usize)>,
+) {
+    let unchanged = |offset: usize| DiffRow::Lines {
+        old: Some(old.line(lines.start + offset, LineChange::Unchanged)),
+        new: Some(new.line(start + offset, LineChange::Unchanged)),
+    };
+    let count = lines.len();
+    if let Some((before, after)) = keep
+        && count > before + after + 1
+    {
+        rows.extend((0..before).map(&unchanged));
+        rows.push(DiffRow::Collapsed { lines: count - before - after });
+        rows.extend((count - after..count).map(&unchanged));
+        return;
+    }
+    rows.extend((0..count).map(unchanged));
+}
+
+/// Adds rows for `removed` lines of the old version replaced by `added` lines of the new one.
+fn push_changes<'a>(
+    rows: &mut Vec<DiffRow<'a>>, old: &Version<'a>, new: &Version<'a>, removed: Range<usize>, added: Range<usize>,
+    mode: DiffMode,
+) {
+    let mut removed_lines: Vec<_> = removed
+        .clone()
+        .map(|index| old.line(index, LineChange::Removed))
+        .collect();
+    let mut added_lines: Vec<_> = added.clone().map(|index| new.line(index, LineChange::Added)).collect();
+    for (old_line, new_line) in removed_lines.iter_mut().zip(&mut added_lines) {
+        if let Some((old_change, new_change)) = changed_words(old_line.text, new_line.text) {
+            old_line.emphasis = Some(old_change);
+            new_line.emphasis = Some(new_change);
+        }
+    }
+    let old_note = removed.last().is_some_and(|index| old.lacks_newline(index));
+    let new_note = added.last().is_some_and(|index| new.lacks_newline(index));
+
+    match mode {
+        DiffMode::Inline => {
+            rows.extend(
+                removed_lines
+                    .into_iter()
+                    .map(|line| DiffRow::Lines { old: Some(line), new: None }),
+            );
+            if old_note {
+                rows.push(DiffRow::NoNewline { old: true, new: false });
+            }
+            rows.extend(
+                added_lines
+                    .into_iter()
+                    .map(|line| DiffRow::Lines { old: None, new: Some(line) }),
+            );
+            if new_note {
+                rows.push(DiffRow::NoNewline { old: false, new: true });
+            }
+        }
+        DiffMode::SideBySide => {
+            let (mut removed_lines, mut added_lines) = (removed_lines.into_iter(), added_lines.into_iter());
+            loop {
+                match (removed_lines.next(), added_lines.next()) {
+                    (None, None) => break,
+                    (old, new) => rows.push(DiffRow::Lines { old, new }),
+                }
+            }
+            if old_note || new_note {
+                rows.push(DiffRow::NoNewline { old: old_note, new: new_note });
+            }
+        }
+    }
+}
+
+/// Digits needed for the largest line number in `rows`.
+pub(crate) fn number_width(rows: &[DiffRow]) -> usize {
+    let largest = rows
+        .iter()
+        .filter_map(|row| match row {
+            DiffRow::Lines { old, new } => {
+                let numbers = [old, new].map(|line| line.as_ref().map_or(0, |line| line.number));
+                Some(numbers[0].max(numbers[1]))
+            }
+            _ => None,
+        })
+        .max()
+        .unwrap_or(0);
+    largest.max(1).to_string().len()
+}
+
+/// Width in characters of the left column of a side-by-side diff: its longest line or note.
+pub(crate) fn left_width(rows: &[DiffRow]) -> usize {
+    rows.iter()
+        .map(|row| match row {
+            DiffRow::Lines { old: Some(line), .. } => line.text.chars().count(),
+            DiffRow::NoNewline { old: true, .. } => NO_NEWLINE.chars().count(),
+            _ => 0,
+        })
+        .max()
+        .unwrap_or(0)
+}
+
+/// The default [Formatter::write_diff]: one plain text token batch after another, with the diff backgrounds
+/// painted by swapping in copies of the theme that put them behind every token.
+pub(crate) fn write_rows<F: Formatter + ?Sized>(
+    formatter: &F, rows: &[DiffRow], mode: DiffMode, theme: &Theme, out: &mut String,
+) {
+    let mut batches = Batches {
+        formatter,
+        theme,
+        added: theme.over_background(theme.diff_added_color()),
+        removed: theme.over_background(theme.diff_removed_color()),
+        changed: theme.over_background(theme.diff_changed_color()),
+        position: Position::new().with_total_lines(rows.len()),
+    };
+    let width = left_width(rows);
+    formatter.write_header(theme, out);
+    for row in rows {
+        match (row, mode) {
+            (DiffRow::Lines { old, new }, DiffMode::Inline) => {
+                let line = new
+                    .as_ref()
+                    .or(old.as_ref())
+                    .expect("diff rows have a line on some side");
+                batches.write_line(line, out);
+            }
+            (DiffRow::Lines { old, new }, DiffMode::SideBySide) => {
+                match old {
+                    Some(line) => batches.write_line(line, out),
+                    None => batches.write(" ", TokenKind::Whitespace, None, out),
+                }
+                let pad = width - old.as_ref().map_or(0, |line| line.text.chars().count());
+                let change = old.as_ref().map(|line| line.change);
+                batches.write(&" ".repeat(pad), TokenKind::Whitespace, change, out);
+                batches.write(" │ ", TokenKind::Punctuation, None, out);
+                if let Some(line) = new {
+                    batches.write_line(line, out);
+                }
+            }
+            (DiffRow::Collapsed { lines }, _) => {
+                batches.write(&DiffRow::collapsed_label(*lines), TokenKind::Comment, None, out);
+            }
+            (DiffRow::NoNewline { .. }, DiffMode::Inline) => batches.write(NO_NEWLINE, TokenKind::Comment, None, out),
+            (DiffRow::NoNewline { old, new }, DiffMode::SideBySide) => {
+                let left = if *old { NO_NEWLINE } else { "" };
+                batches.write(left, TokenKind::Comment, None, out);
+                batches.write(
+                    &" ".repeat(width + 1 - left.chars().count()),
+                    TokenKind::Whitespace,
+                    None,
+                    out,
+                );
+                batches.write(" │ ", TokenKind::Punctuation, None, out);
+                if *new {
+                    batches.write(NO_NEWLINE, TokenKind::Comment, None, out);
+                }
+            }
+        }
+        batches.write("\n", TokenKind::Whitespace, None, out);
+    }
+    formatter.write_footer(theme, batches.position, out);
+}
+
+/// State of [write_rows]: the themes each kind of line is painted with and where the next batch starts.
+struct Batches<'a, F: ?Sized> {
+    formatter: &'a F,
+    theme: &'a Theme,
+    added: Theme,
+    removed: Theme,
+    changed: Theme,
+    position: Position,
+}
+
+impl<F: Formatter + ?Sized> Batches<'_, F> {
+    /// Writes `text` as one token of `kind`, on the background of `change` (the theme's own for `None`).
+    fn write(&mut self, text: &str, kind: TokenKind, change: Option<LineChange>, out: &mut String) {
+        let theme = match change {
+            None | Some(LineChange::Unchanged) => self.theme,
+            Some(LineChange::Added) => &self.added,
+            Some(LineChange::Removed) => &self.removed,
+        };
+        emit(self.formatter, &mut self.position, text, kind, theme, out);
+    }
+
+    /// Writes a line's sign and its tokens, with the changed words on their own background.
+    fn write_line(&mut self, line: &DiffLine, out: &mut String) {
+        let (sign, kind) = line.sign();
+        self.write(sign, kind, Some(line.change), out);
+        for (kind, text, emphasized) in line.spans() {
+            if emphasized {
+                emit(self.formatter, &mut self.position, text, kind, &self.changed, out);
+            } else {
+                self.write(text, kind, Some(line.change), out);
+            }
+        }
+    }
+}
+
+/// Writes `text` as a batch of one token at `position`, then moves past it.
+fn emit<F: Formatter + ?Sized>(
+    formatter: &F, position: &mut Position, text: &str, kind: TokenKind, theme: &Theme, out: &mut String,
+) {
+    if !text.is_empty() {
+        formatter.write_tokens(text, &[Token::new(kind, 0, text.len())], theme, *position, out);
+        position.advance(text);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::formatters::{AnsiFormatter, HtmlFormatter};
+    use crate::highlight::lexers::{PlainText, Shell};
+    use crate::terminal::ColorProfile;
+
+    fn theme() -> Theme {
+        let mut theme = Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        theme.set(TokenKind::String, Srgb8::new(0, 255, 0));
+        theme.diff_added = Some(Srgb8::new(0, 40, 0));
+        theme.diff_removed = Some(Srgb8::new(40, 0, 0));
+        theme.diff_changed = Some(Srgb8::new(80, 80, 0));
+        theme
+    }
+
+    fn plain() -> AnsiFormatter {
+        AnsiFormatter::new().with_profile(ColorProfile::NoColor)
+    }
+
+    fn render(old: &str, new: &str, formatter: &dyn Formatter, options: &DiffOptions) -> String {
+        highlight_diff(old, new, &PlainText, &theme(), formatter, options).unwrap()
+    }
+
+    fn script(a: &str, b: &str) -> String {
+        let (a, b): (Vec<&str>, Vec<&str>) = (a.split(' ').collect(), b.split(' ').collect());
+        let edits = edits(&a, &b);
+        assert_eq!(edits.iter().filter(|&&edit| edit != Edit::Insert).count(), a.len());
+        assert_eq!(edits.iter().filter(|&&edit| edit != Edit::Delete).count(), b.len());
+        edits
+            .iter()
+            .map(|edit| match edit {
+                Edit::Keep => '=',
+                Edit::Delete => '-',
+                Edit::Insert => '+',
+            })
+            .collect()
+    }
+
+    #[test]
+    fn myers_finds_shortest_scripts() {
+        // The example from Myers' paper: five edits, keeping the longest common subsequence "CBBA".
+        let edits = script("A B C A B B A", "C B A B A C");
+        assert_eq!(edits.chars().filter(|&edit| edit != '=').count(), 5);
+        assert_eq!(edits.chars().filter(|&edit| edit == '=').count(), 4);
+
+        assert_eq!(script("a b c", "a b c"), "===");
+        assert_eq!(script("a b c", "a x c"), "=-+=");
+        assert_eq!(script("a", "b c"), "-++");
+        assert_eq!(script("a b c d", "b d"), "-=-=");
+    }
+
+    #[test]
+    fn inline_diffs_interleave_removed_and_added_lines() {
+        let out = render(
+            "one\ntwo\nthree\nfour\n",
+            "one\n2\nthree\nfive\n",
+            &plain(),
+            &DiffOptions::new(),
+        );
+        assert_eq!(out, " one\n-two\n+2\n three\n-four\n+five\n");
+    }
+
+    #[test]
+    fn side_by_side_diffs_pad_the_left_column() {
+        let options = DiffOptions::new().with_mode(DiffMode::SideBySide);
+        let out = render("a\nlonger\nc\n", "a\nb\nx\ny\nc\n", &plain(), &options);
+        assert_eq!(
+            out,
+            concat!(
+                " a      │  a\n",
+                "-longer │ +b\n",
+                "        │ +x\n",
+                "        │ +y\n",
+                " c      │  c\n",
+            )
+        );
+    }
+
+    #[test]
+    fn missing_final_newlines_are_changes() {
+        let out = render("a\nb", "a\nb\n", &plain(), &DiffOptions::new());
+        assert_eq!(out, " a\n-b\n\\ No newline at end of file\n+b\n");
+
+        let options = DiffOptions::new().with_mode(DiffMode::SideBySide);
+        let out = render("a\nb\n", "a\nc", &plain(), &options);
+        assert_eq!(out, " a │  a\n-b │ +c\n   │ \\ No newline at end of file\n");
+
+        // Identical versions without final newlines need no note.
+        assert_eq!(render("a\nb", "a\nb", &plain(), &DiffOptions::new()), " a\n b\n");
+    }
+
+    #[test]
+    fn long_unchanged_stretches_collapse() {
+        let old: String = (1..=20).map(|line| format!("{line}\n")).collect();
+        let new = old.replace("10\n", "ten\n");
+        let out = render(&old, &new, &plain(), &DiffOptions::new().with_context(2));
+        assert_eq!(
+            out,
+            "⋯ 7 unchanged lines\n 8\n 9\n-10\n+ten\n 11\n 12\n⋯ 8 unchanged lines\n"
+        );
+
+        // A marker never stands in for a single line.
+        let out = render("a\nb\nc\n", "a\nb\nC\n", &plain(), &DiffOptions::new().with_context(1));
+        assert_eq!(out, " a\n b\n-c\n+C\n");
+
+        let out = render(&old, &old, &plain(), &DiffOptions::new().with_context(3));
+        assert_eq!(out, "⋯ 20 unchanged lines\n");
+    }
+
+    #[test]
+    fn rows_carry_numbers_tokens_and_changed_words() {
+        let rows = diff_rows(
+            "echo \"hi\" now\nx\n",
+            "echo \"hey\" now\n",
+            &Shell,
+            &DiffOptions::new(),
+        )
+        .unwrap();
+        let [
+            DiffRow::Lines { old: Some(old), new: None },
+            DiffRow::Lines { old: Some(x), new: None },
+            DiffRow::Lines { old: None, new: Some(new) },
+        ] = &rows[..]
+        else {
+            panic!("{rows:?}");
+        };
+        assert_eq!(
+            (old.number, old.text, old.change),
+            (1, "echo \"hi\" now", LineChange::Removed)
+        );
+        assert_eq!((x.number, x.emphasis.clone()), (2, None));
+        assert_eq!((new.number, new.change), (1, LineChange::Added));
+        assert_eq!(&old.text[old.emphasis.clone().unwrap()], "hi");
+        assert_eq!(&new.text[new.emphasis.clone().unwrap()], "hey");
+
+        // The string token is split around the changed word.
+        let spans: Vec<_> = new.spans().filter(|(kind, ..)| *kind == TokenKind::String).collect();
+        assert_eq!(
+            spans,
+            [
+                (TokenKind::String, "\"", false),
+                (TokenKind::String, "hey", true),
+                (TokenKind::String, "\"", false)
+            ]
+        );
+        assert_eq!(new.tokens.last().map(|token| token.end), Some(new.text.len()));
+    }
+
+    #[test]
+    fn crlf_line_endings_are_not_shown() {
+        let rows = diff_rows("a\r\n", "b\r\n", &PlainText, &DiffOptions::new()).unwrap();
+        let DiffRow::Lines { old: Some(line), .. } = &rows[0] else { panic!("{rows:?}") };
+        assert_eq!(line.text, "a");
+        assert_eq!(line.tokens, [Token::new(TokenKind::Text, 0, 1)]);
+    }
+
+    #[test]
+    fn ansi_paints_changed_lines_to_the_terminal_edge() {
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::TrueColor);
+        let out = render("a\n", "b\n", &formatter, &DiffOptions::new());
+        assert_eq!(
+            out,
+            concat!(
+                "\x1b[38;2;200;200;200;48;2;40;0;0m-\x1b[0m\x1b[38;2;200;200;200;48;2;40;0;0ma\x1b[0m\x1b[48;2;40;0;0m\x1b[K\x1b[0m\n",
+                "\x1b[38;2;200;200;200;48;2;0;40;0m+\x1b[0m\x1b[38;2;200;200;200;48;2;0;40;0mb\x1b[0m\x1b[48;2;0;40;0m\x1b[K\x1b[0m\n",
+            )
+        );
+
+        // Changed words get their own background; blanks on changed lines are painted too.
+        let out = render("x = 1\n", "x = 2\n", &formatter, &DiffOptions::new());
+        assert!(out.contains("\x1b[38;2;200;200;200;48;2;80;80;0m2\x1b[0m"), "{out:?}");
+        assert!(out.contains("\x1b[38;2;200;200;200;48;2;0;40;0mx = \x1b[0m"), "{out:?}");
+    }
+
+    #[test]
+    fn side_by_side_line_numbers_follow_each_version() {
+        let formatter = plain().with_line_numbers(true);
+        let options = DiffOptions::new().with_mode(DiffMode::SideBySide);
+        let out = render("a\nb\n", "a\nc\nd\n", &formatter, &options);
+        assert_eq!(out, "1  a │ 1  a\n2 -b │ 2 +c\n     │ 3 +d\n");
+    }
+
+    #[test]
+    fn html_diffs_wrap_lines_and_cells() {
+        let formatter = HtmlFormatter::new().with_classes("c-");
+        let out = render("keep\nold\n", "keep\nnew\n", &formatter, &DiffOptions::new());
+        assert_eq!(
+            out,
+            concat!(
+                "<pre class=\"c-pre\"><code><span class=\"c-line\">keep\n</span>",
+                "<span class=\"c-line c-diff-removed\">old\n</span>",
+                "<span class=\"c-line c-diff-added\">new\n</span></code></pre>",
+            )
+        );
+
+        let options = DiffOptions::new().with_mode(DiffMode::SideBySide).with_context(0);
+        let out = render("a\nb\nc\nx 1\n", "a\nb\nc\nx 2\n<y>\n", &formatter, &options);
+        assert_eq!(
+            out,
+            concat!(
+                "<table class=\"c-pre c-diff\">\n",
+                "<tr><td class=\"c-diff-note\" colspan=\"2\">⋯ 3 unchanged lines</td></tr>\n",
+                "<tr><td class=\"c-diff-removed\">x <span class=\"c-diff-changed\">1</span></td>",
+                "<td class=\"c-diff-added\">x <span class=\"c-diff-changed\">2</span></td></tr>\n",
+                "<tr><td></td><td class=\"c-diff-added\">&lt;y&gt;</td></tr>\n",
+                "</table>",
+            )
+        );
+
+        let css = formatter.css(&theme());
+        assert!(css.contains(".c-diff-added { background-color: #002800; }"), "{css}");
+        assert!(
+            css.contains(".c-diff td { white-space: pre; vertical-align: top; }"),
+            "{css}"
+        );
+    }
+
+    #[test]
+    fn inline_style_diffs_carry_their_backgrounds() {
+        let out = render("a\n", "b\n", &HtmlFormatter::new(), &DiffOptions::new());
+        assert!(
+            out.contains("<span style=\"display: block; background-color: #280000;\">a\n</span>"),
+            "{out}"
+        );
+        assert!(
+            out.contains("<span style=\"display: block; background-color: #002800;\">b\n</span>"),
+            "{out}"
+        );
+    }
+
+    /// Writes each token's text behind the hex of its background, to check what the default rendering paints.
+    struct Backgrounds;
+
+    impl Formatter for Backgrounds {
+        fn write_tokens(&self, src: &str, tokens: &[Token], theme: &Theme, _position: Position, out: &mut String) {
+            for token in tokens {
+                let background = theme.style_for(token.kind).background;
+                out.push_str(&format!(
+                    "[{}]{}",
+                    background.map_or("-".into(), |color| color.to_hex()),
+                    token.text(src)
+                ));
+            }
+        }
+    }
+
+    #[test]
+    fn default_rendering_paints_backgrounds_through_write_tokens() {
+        let out = render("a b\nc\n", "a x\nc\n", &Backgrounds, &DiffOptions::new());
+        assert_eq!(
+            out,
+            concat!(
+                "[#280000]-[#280000]a [#505000]b[-]\n",
+                "[#002800]+[#002800]a [#505000]x[-]\n",
+                "[-] [-]c[-]\n",
+            )
+        );
+    }
+}
@@ -0,0 +1,340 @@
+//! Highlighting files and directory trees, detecting each file's language from its name and contents.
+
+use super::lexers::glob_match;
+use super::{Formatter, HighlightError, InputOptions, Theme, decode, detect_lexer};
+
+use std::path::{Path, PathBuf};
+use std::sync::Mutex;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::{fmt, fs, io, thread};
+
+/// Largest file [highlight_file] and [highlight_dir] read unless [FileOptions::with_max_size] raises the limit.
+pub const DEFAULT_MAX_FILE_SIZE: u64 = 4 * 1024 * 1024;
+
+/// Limits and filters for [highlight_file] and [highlight_dir].
+#[derive(Debug, Clone)]
+pub struct FileOptions {
+    max_size: u64,
+    input: InputOptions,
+    ignore: Vec<String>,
+    threads: Option<usize>,
+}
+
+impl FileOptions {
+    /// Creates options that read files up to [DEFAULT_MAX_FILE_SIZE], decode them with the default [InputOptions],
+    /// skip `.git` directories, and walk directories with one worker per core.
+    pub fn new() -> Self {
+        Self {
+            max_size: DEFAULT_MAX_FILE_SIZE,
+            input: InputOptions::new(),
+            ignore: vec![".git".to_string()],
+            threads: None,
+        }
+    }
+
+    /// Fails files larger than `bytes` with [HighlightError::TooLarge] before reading them.
+    pub fn with_max_size(mut self, bytes: u64) -> Self {
+        self.max_size = bytes;
+        self
+    }
+
+    /// Sets how file contents are decoded; see [InputOptions].
+    pub fn with_input(mut self, input: InputOptions) -> Self {
+        self.input = input;
+        self
+    }
+
+    /// Replaces the patterns of file and directory names [highlight_dir] skips, globs of literal characters, `*`,
+    /// and `?` (e.g., `target` or `*.min.js`). Ignored directories aren't descended into.
+    pub fn with_ignore(mut self, patterns: impl IntoIterator<Item = impl Into<String>>) -> Self {
+        self.ignore = patterns.into_iter().map(Into::into).collect();
+        self
+    }
+
+    /// Highlights at most `threads` files at a time in [highlight_dir] (at least one).
+    pub fn with_threads(mut self, threads: usize) -> Self {
+        self.threads = Some(threads.max(1));
+        self
+    }
+
+    fn ignores(&self, name: &str) -> bool {
+        self.ignore.iter().any(|pattern| glob_match(pattern, name))
+    }
+}
+
+impl Default for FileOptions {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+/// The files [highlight_dir] couldn't highlight or hand off, with why, sorted by path.
+#[derive(Debug)]
+pub struct DirError {
+    pub failures: Vec<(PathBuf, HighlightError)>,
+}
+
+impl fmt::Display for DirError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let count = self.failures.len();
+        write!(
+            f,
+            "failed to highlight {count} file{}",
+            if count == 1 { "" } else { "s" }
+        )?;
+        for (path, err) in &self.failures {
+            write!(f, "\n{}: {err}", path.display())?;
+        }
+        Ok(())
+    }
+}
+
+impl std::error::Error for DirError {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        self.failures.first().map(|(_, err)| err as _)
+    }
+}
+
+/// Reads the file at `path`, detects its language from the file name and contents (see [detect_lexer]), and renders
+/// it with `formatter` using `theme`.
+///
+/// Files over the size limit fail with [HighlightError::TooLarge] without being read, and binary files with
+/// [HighlightError::BinaryInput] unless the [InputOptions] allow them.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::formatters::HtmlFormatter;
+/// use colorizer::highlight::{FileOptions, HighlightError, Theme, highlight_file};
+///
+/// let theme = Theme::new("Plain", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+/// let formatter = HtmlFormatter::new().with_classes("");
+/// let html = highlight_file("../examples/languages/sample.go", &theme, &formatter, &FileOptions::new()).unwrap();
+/// assert!(html.contains("<span class=\"kw kd\">func</span>"));
+///
+/// let small = FileOptions::new().with_max_size(64);
+/// let err = highlight_file("../examples/languages/sample.go", &theme, &formatter, &small).unwrap_err();
+/// assert!(matches!(err, HighlightError::TooLarge { limit: 64, .. }));
+/// ```
+pub fn highlight_file(
+    path: impl AsRef<Path>, theme: &Theme, formatter: &dyn Formatter, options: &FileOptions,
+) -> Result<String, HighlightError> {
+    let path = path.as_ref();
+    let size = fs::metadata(path)?.len();
+    if size > options.max_size {
+        return Err(HighlightError::TooLarge { size, limit: options.max_size });
+    }
+    let decoded = decode(&fs::read(path)?, &options.input)?;
+    let (lexer, _) = detect_lexer(&path.to_string_lossy(), decoded.text());
+    let tokens = decoded.tokenize(&*lexer)?;
+    let mut out = String::with_capacity(decoded.text().len() * 2);
+    formatter.format(decoded.text(), &tokens, theme, &mut out);
+    Ok(out)
+}
+
+/// Highlights every file under `root` like [highlight_file] and passes each path (under `root`) and its output to
+/// `each`, e.g., to write it next to the source.
+///
+/// Files and directories whose names match [FileOptions::with_ignore] are skipped, and so are binary files unless the
+/// [InputOptions] allow them. Symbolic links to files are followed; links to directories aren't. Files are
+/// highlighted on a pool of worker threads, so `each` runs concurrently and in no particular order. A file that
+/// fails, or whose `each` call fails, doesn't stop the walk: the walk finishes, then every failure is returned
+/// together.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::formatters::HtmlFormatter;
+/// use colorizer::highlight::{FileOptions, Theme, highlight_dir};
+/// use std::path::PathBuf;
+/// use std::sync::Mutex;
+///
+/// let theme = Theme::new("Plain", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+/// let pages = Mutex::new(Vec::new());
+/// let options = FileOptions::new().with_ignore(["*.toml", "*.y*ml"]);
+/// highlight_dir("../examples/languages", &theme, &HtmlFormatter::new(), &options, |path, html| {
+///     let mut target = path.as_os_str().to_owned();
+///     target.push(".html");
+///     pages.lock().unwrap().push((PathBuf::from(target), html));
+///     Ok(())
+/// })
+/// .unwrap();
+/// assert!(pages.into_inner().unwrap().iter().any(|(path, _)| path.ends_with("sample.go.html")));
+/// ```
+pub fn highlight_dir<F>(
+    root: impl AsRef<Path>, theme: &Theme, formatter: &(dyn Formatter + Sync), options: &FileOptions, each: F,
+) -> Result<(), DirError>
+where
+    F: Fn(&Path, String) -> Result<(), HighlightError> + Sync,
+{
+    let mut files = Vec::new();
+    let mut failures = Vec::new();
+    walk(root.as_ref(), options, &mut files, &mut failures);
+
+    let threads = options
+        .threads
+        .unwrap_or_else(|| thread::available_parallelism().map_or(1, usize::from))
+        .min(files.len());
+    let next = AtomicUsize::new(0);
+    let failed = Mutex::new(failures);
+    thread::scope(|scope| {
+        for _ in 0..threads {
+            scope.spawn(|| {
+                while let Some(path) = files.get(next.fetch_add(1, Ordering::Relaxed)) {
+                    let result = match highlight_file(path, theme, formatter, options) {
+                        Ok(out) => each(path, out),
+                        Err(HighlightError::BinaryInput) => Ok(()),
+                        Err(err) => Err(err),
+                    };
+                    if let Err(err) = result {
+                        failed
+                            .lock()
+                            .unwrap_or_else(|err| err.into_inner())
+                            .push((path.clone(), err));
+                    }
+                }
+            });
+        }
+    });
+
+    let mut failures = failed.into_inner().unwrap_or_else(|err| err.into_inner());
+    if failures.is_empty() {
+        return Ok(());
+    }
+    failures.sort_by(|(a, _), (b, _)| a.cmp(b));
+    Err(DirError { failures })
+}
+
+/// Collects the files under `dir` in path order, recording directories that can't be listed as failures.
+fn walk(dir: &Path, options: &FileOptions, files: &mut Vec<PathBuf>, failures: &mut Vec<(PathBuf, HighlightError)>) {
+    let entries = match fs::read_dir(dir).and_then(|entries| entries.collect::<io::Result<Vec<_>>>()) {
+        Ok(entries) => entries,
+        Err(err) => return failures.push((dir.to_path_buf(), err.into())),
+    };
+    let mut entries: Vec<_> = entries
+        .into_iter()
+        .filter(|entry| !options.ignores(&entry.file_name().to_string_lossy()))
+        .collect();
+    entries.sort_by_key(|entry| entry.file_name());
+    for entry in entries {
+        let path = entry.path();
+        match entry.file_type() {
+            Ok(kind) if kind.is_dir() => walk(&path, options, files, failures),
+            Ok(kind) if kind.is_file() || (kind.is_symlink() && path.is_file()) => files.push(path),
+            Ok(_) => {}
+            Err(err) => failures.push((path, err.into())),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::formatters::HtmlFormatter;
+    use crate::highlight::highlight;
+    use crate::highlight::lexers::Go;
+
+    fn theme() -> Theme {
+        Theme::new("Files", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255))
+    }
+
+    /// A scratch directory removed when dropped.
+    struct Scratch(PathBuf);
+
+    impl Scratch {
+        fn new(name: &str, files: &[(&str, &[u8])]) -> Self {
+            let root = std::env::temp_dir().join(format!("colorizer-{name}-{}", std::process::id()));
+            for (path, contents) in files {
+                let path = root.join(path);
+                fs::create_dir_all(path.parent().unwrap()).unwrap();
+                fs::write(path, contents).unwrap();
+            }
+            Self(root)
+        }
+    }
+
+    impl Drop for Scratch {
+        fn drop(&mut self) {
+            let _ = fs::remove_dir_all(&self.0);
+        }
+    }
+
+    #[test]
+    fn files_are_detected_and_formatted() {
+        let src = fs::read_to_string("../examples/languages/sample.go").unwrap();
+        let formatter = HtmlFormatter::new();
+        let expected = highlight(&src, &Go, &theme(), &formatter).unwrap();
+        let html = highlight_file(
+            "../examples/languages/sample.go",
+            &theme(),
+            &formatter,
+            &FileOptions::new(),
+        );
+        assert_eq!(html.unwrap(), expected);
+
+        let err = highlight_file(
+            "../examples/languages/missing.go",
+            &theme(),
+            &formatter,
+            &FileOptions::new(),
+        );
+        assert!(matches!(err, Err(HighlightError::Io(_))));
+    }
+
+    #[test]
+    fn walks_skip_ignored_and_binary_files_and_collect_failures() {
+        let scratch = Scratch::new(
+            "walk",
+            &[
+                ("main.go", b"package main\n"),
+                ("src/lib.rs", b"fn main() {}\n"),
+                ("src/big.py", b"x = 1\n# padding\n"),
+                ("logo.png", b"\x89PNG\r\n\x1a\n\0\0"),
+                (".git/config", b"[core]\n"),
+                ("target/out.rs", b"fn out() {}\n"),
+                ("notes.txt", b"reject me\n"),
+            ],
+        );
+        let seen = Mutex::new(Vec::new());
+        let options = FileOptions::new()
+            .with_max_size(14)
+            .with_ignore([".git", "targ?t"])
+            .with_threads(3);
+        let err = highlight_dir(&scratch.0, &theme(), &HtmlFormatter::new(), &options, |path, html| {
+            let name = path.strip_prefix(&scratch.0).unwrap().to_path_buf();
+            if name.ends_with("notes.txt") {
+                return Err(HighlightError::Lex("rejected".to_string()));
+            }
+            assert!(html.starts_with("<pre"));
+            seen.lock().unwrap().push(name);
+            Ok(())
+        })
+        .unwrap_err();
+
+        let mut seen = seen.into_inner().unwrap();
+        seen.sort();
+        assert_eq!(seen, [PathBuf::from("main.go"), PathBuf::from("src/lib.rs")]);
+        let failed: Vec<_> = err
+            .failures
+            .iter()
+            .map(|(path, err)| (path.strip_prefix(&scratch.0).unwrap().to_path_buf(), err.to_string()))
+            .collect();
+        assert_eq!(
+            failed,
+            [
+                (
+                    PathBuf::from("notes.txt"),
+                    "failed to tokenize source: rejected".to_string()
+                ),
+                (
+                    PathBuf::from("src/big.py"),
+                    "file is 16 bytes, over the 14-byte limit".to_string()
+                ),
+            ]
+        );
+        assert!(err.to_string().starts_with("failed to highlight 2 files\n"), "{err}");
+    }
+}
@@ -0,0 +1,1353 @@
+//! Terminal formatter emitting ANSI escape sequences.
+
+use super::{
+    Formatter, KindCache, LineOptions, Overlay, Overlays, Piece, Position, ShowWhitespace, TextCursor, TextOptions,
+    ThemeCache,
+};
+use crate::colors::Srgb8;
+use crate::highlight::cache::debug_fingerprint;
+use crate::highlight::diffview::{self, NO_NEWLINE};
+use crate::highlight::width::Widths;
+use crate::highlight::{CallFormatter, DiffLine, DiffMode, DiffRow, LineChange, Style, Theme, Token, TokenKind};
+use crate::terminal::{ColorChoice, ColorProfile, color_override};
+
+use std::fmt::Write;
+use std::io::IsTerminal;
+use std::ops::{Range, RangeInclusive};
+
+pub(crate) const RESET: &str = "\x1b[0m";
+
+/// Tab stops of terminals, which raw tabs in the output advance to.
+const TERMINAL_TAB_WIDTH: usize = 8;
+
+/// Marks a continuation line when [AnsiFormatter::with_wrap_marker] is on; [Overflow::Wrap] indents by its width
+/// either way.
+const WRAP_MARKER: &str = "↪ ";
+const WRAP_INDENT: usize = 2;
+
+const ELLIPSIS: &str = "…";
+
+/// Renders tokens with SGR escapes for a [ColorProfile], resetting after every styled segment.
+///
+/// Escapes never span a newline so each output line is self-contained (safe to `grep`, page, or slice).
+/// Colors are quantized to the nearest palette entry when the profile is narrower than truecolor. Bold, italic,
+/// underline, and strikethrough map to SGR 1, 3, 4, and 9; [ColorProfile::NoColor] drops them along with the colors.
+///
+/// Line numbers are right-aligned in a gutter styled with [Theme::gutter_style]. Highlighted lines are painted with
+/// [Theme::line_highlight_color] up to the terminal edge (via erase-in-line), not just behind the text.
+///
+/// Rich diffs ([crate::highlight::highlight_diff]) paint added and removed lines the same way, and side-by-side rows
+/// pad the left column in its line's background; with line numbers on, each line shows its number in its own version.
+///
+/// Whether to color at all is decided when the formatter is configured, never per token: [ColorChoice::Never] turns
+/// color off, an explicit [AnsiFormatter::with_profile] is used as given, and otherwise `NO_COLOR`, `CLICOLOR_FORCE`,
+/// and `CLICOLOR` are honored (see [crate::terminal::color_override_from_env_values]) before falling back to whether
+/// the output is a terminal. Output of unknown kind counts as a terminal; pass the destination to
+/// [AnsiFormatter::with_output] to turn color off when piping.
+///
+/// [AnsiFormatter::with_max_width] keeps lines within a number of terminal cells, for panes narrower than the code.
+/// Widths follow the terminal's: East Asian wide characters and most emoji take two cells, and combining marks and
+/// whatever a zero-width joiner glues on take none.
+///
+/// [AnsiFormatter::with_overlays] layers styles over parts of the document; line overlays with a background paint it
+/// to the terminal edge like highlighted lines.
+#[derive(Debug, Clone)]
+pub struct AnsiFormatter {
+    /// Profile in effect, re-resolved whenever an option below changes.
+    profile: ColorProfile,
+    detected: ColorProfile,
+    requested: Option<ColorProfile>,
+    color: ColorChoice,
+    terminal: bool,
+    lines: LineOptions,
+    text: TextOptions,
+    max_width: Option<usize>,
+    overflow: Overflow,
+    wrap_marker: bool,
+    overlays: Overlays,
+    /// Escapes of the document being written, from its header on.
+    escapes: ThemeCache<EscapeTable>,
+}
+
+/// What [AnsiFormatter::with_max_width] does with a line wider than the limit.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum Overflow {
+    /// Breaks the line at the last character that fits and carries on on an indented continuation line.
+    #[default]
+    Wrap,
+    /// Cuts the line short and ends it with `…` in [Theme::gutter_style].
+    Truncate,
+}
+
+impl AnsiFormatter {
+    /// Creates a formatter using the profile detected from `COLORTERM`/`TERM`, subject to the color conventions.
+    pub fn new() -> Self {
+        let detected = ColorProfile::detect();
+        let formatter = Self {
+            profile: detected,
+            detected,
+            requested: None,
+            color: ColorChoice::Auto,
+            terminal: true,
+            lines: LineOptions::default(),
+            text: TextOptions::default(),
+            max_width: None,
+            overflow: Overflow::Wrap,
+            wrap_marker: false,
+            overlays: Overlays::default(),
+            escapes: ThemeCache::new(),
+        };
+        formatter.resolved()
+    }
+
+    /// Overrides the detected color profile, and with it the environment and terminal checks.
+    pub fn with_profile(mut self, profile: ColorProfile) -> Self {
+        self.requested = Some(profile);
+        self.resolved()
+    }
+
+    /// Forces color on or off, overriding every other setting; [ColorChoice::Auto] restores the default checks.
+    pub fn with_color(mut self, color: ColorChoice) -> Self {
+        self.color = color;
+        self.resolved()
+    }
+
+    /// Checks whether `output` (e.g., [std::io::stdout] or a [std::fs::File]) is a terminal, so that
+    /// [ColorChoice::Auto] drops escapes when it isn't.
+    pub fn with_output(mut self, output: &impl IsTerminal) -> Self {
+        self.terminal = output.is_terminal();
+        self.resolved()
+    }
+
+    /// Prefixes every line with its number.
+    pub fn with_line_numbers(mut self, enabled: bool) -> Self {
+        self.lines.numbers = enabled;
+        self
+    }
+
+    /// Number of the first line (default 1), for excerpts that start mid-file.
+    pub fn with_line_number_start(mut self, start: usize) -> Self {
+        self.lines.start = start;
+        self
+    }
+
+    /// Emphasizes the given ranges of displayed line numbers (so they account for [Self::with_line_number_start]).
+    pub fn with_highlight_lines(mut self, ranges: impl IntoIterator<Item = RangeInclusive<usize>>) -> Self {
+        self.lines.highlights = ranges.into_iter().collect();
+        self
+    }
+
+    /// Expands tabs to spaces up to the next multiple of `width` columns, counted from the start of the line's text so
+    /// code lines up the same with or without a gutter. Token offsets still refer to the original text.
+    pub fn with_tab_width(mut self, width: usize) -> Self {
+        self.text.tab_width = Some(width.max(1));
+        self
+    }
+
+    /// Drops the carriage return of `\r\n` line endings, which terminals would otherwise act on.
+    pub fn with_normalize_crlf(mut self, enabled: bool) -> Self {
+        self.text.normalize_crlf = enabled;
+        self
+    }
+
+    /// Draws spaces and tabs as `·` and `→` markers in [Theme::whitespace_style].
+    pub fn with_show_whitespace(mut self, mode: ShowWhitespace) -> Self {
+        self.text.whitespace = mode;
+        self
+    }
+
+    /// Keeps every line, gutter included, within `columns` terminal cells, handling longer ones as
+    /// [Self::with_overflow] says. Escapes take no cells, and a character is never split. Rich diffs aren't limited.
+    pub fn with_max_width(mut self, columns: usize) -> Self {
+        self.max_width = Some(columns.max(1));
+        self
+    }
+
+    /// Wraps or truncates lines wider than [Self::with_max_width]; wrapping is the default.
+    pub fn with_overflow(mut self, overflow: Overflow) -> Self {
+        self.overflow = overflow;
+        self
+    }
+
+    /// Starts each continuation of a wrapped line with `↪` in [Theme::gutter_style].
+    pub fn with_wrap_marker(mut self, enabled: bool) -> Self {
+        self.wrap_marker = enabled;
+        self
+    }
+
+    /// Layers `overlays` over the tokens they cover, in order; see [Overlay]. Byte offsets are into the whole
+    /// document, so batches written while streaming or for excerpts place them by [Position::offset].
+    pub fn with_overlays(mut self, overlays: impl IntoIterator<Item = Overlay>) -> Self {
+        self.overlays = Overlays(overlays.into_iter().collect());
+        self
+    }
+
+    /// Returns the color profile in use, [ColorProfile::NoColor] when color is off.
+    pub fn profile(&self) -> ColorProfile {
+        self.profile
+    }
+
+    fn resolved(mut self) -> Self {
+        // Forcing color onto a terminal that reports none still needs a palette; the 16 colors are the safest.
+        let forced = match self.detected {
+            ColorProfile::NoColor => ColorProfile::Ansi16,
+            profile => profile,
+        };
+        self.profile = match (self.color, self.requested) {
+            (ColorChoice::Never, _) => ColorProfile::NoColor,
+            (_, Some(profile)) => profile,
+            (ColorChoice::Always, None) => forced,
+            (ColorChoice::Auto, None) => match color_override() {
+                Some(true) => forced,
+                Some(false) => ColorProfile::NoColor,
+                None if self.terminal => self.detected,
+                None => ColorProfile::NoColor,
+            },
+        };
+        self
+    }
+}
+
+impl Default for AnsiFormatter {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl AnsiFormatter {
+    /// Builds the SGR escape for a resolved style in this formatter's profile; see [sgr_escape].
+    pub(crate) fn escape(&self, style: Style) -> Option<String> {
+        sgr_escape(self.profile, style)
+    }
+
+    /// Adds the line-highlight background to `style` unless the token sets its own.
+    fn highlighted(&self, style: Style, theme: &Theme) -> Style {
+        Style { background: style.background.or(Some(theme.line_highlight_color())), ..style }
+    }
+
+    /// Clears the rest of a highlighted line to its background.
+    fn line_end(&self, theme: &Theme) -> Option<String> {
+        let params = self.profile.background_params(theme.line_highlight_color())?;
+        Some(format!("\x1b[{params}m\x1b[K{RESET}"))
+    }
+
+    /// Lays out the `rendered` part of `line`, which starts `column` cells in, within the width limit.
+    fn flush_line(
+        &self, rendered: &mut String, escapes: &mut Escapes, line: usize, gutter: usize, column: usize,
+        out: &mut String,
+    ) {
+        if let Some(limit) = self.max_width
+            && !rendered.is_empty()
+        {
+            escapes
+                .fit(limit, gutter, self.lines.is_highlighted(line))
+                .write(rendered, column, out);
+            rendered.clear();
+        }
+    }
+
+    /// Finds the cell a batch continuing a line starts at, from the characters (not cells) an earlier batch wrote.
+    fn resume_column(&self, gutter: usize, text_column: usize) -> usize {
+        let Some(limit) = self.max_width else { return 0 };
+        let first = limit.saturating_sub(gutter);
+        match self.overflow {
+            Overflow::Truncate => gutter + text_column,
+            Overflow::Wrap if text_column < first => gutter + text_column,
+            Overflow::Wrap => {
+                let indent = gutter + WRAP_INDENT;
+                indent + (text_column - first) % limit.saturating_sub(indent).max(1)
+            }
+        }
+    }
+}
+
+/// Builds the SGR escape for a resolved style in `profile`: attributes (1 bold, 3 italic, 4 underline,
+/// 9 strikethrough), then foreground and optional background; `None` when the style has no foreground or the profile
+/// has no colors.
+pub(crate) fn sgr_escape(profile: ColorProfile, style: Style) -> Option<String> {
+    let foreground = profile.foreground_params(style.foreground?)?;
+    let mut escape = String::with_capacity(32);
+    escape.push_str("\x1b[");
+    for (flag, code) in [
+        (style.bold, "1;"),
+        (style.italic, "3;"),
+        (style.underline, "4;"),
+        (style.strikethrough, "9;"),
+    ] {
+        if flag == Some(true) {
+            escape.push_str(code);
+        }
+    }
+    escape.push_str(&foreground);
+    if let Some(background) = style.background.and_then(|color| profile.background_params(color)) {
+        escape.push(';');
+        escape.push_str(&background);
+    }
+    escape.push('m');
+    Some(escape)
+}
+
+/// The escapes a document needs, each built the first time it is used rather than for every token or line.
+struct Escapes<'a> {
+    formatter: &'a AnsiFormatter,
+    theme: &'a Theme,
+    table: EscapeTable,
+}
+
+/// Escapes built so far, which [AnsiFormatter] keeps between the batches of a document.
+struct EscapeTable {
+    /// Per [TokenKind]: resolved for plain lines and for highlighted lines.
+    plain: KindCache,
+    highlighted: KindCache,
+    gutter: [Option<Option<String>>; 2],
+    whitespace: [Option<Option<String>>; 2],
+    line_end: Option<Option<String>>,
+}
+
+impl EscapeTable {
+    fn new() -> Self {
+        Self {
+            plain: KindCache::new(),
+            highlighted: KindCache::new(),
+            gutter: [const { None }; 2],
+            whitespace: [const { None }; 2],
+            line_end: None,
+        }
+    }
+}
+
+impl<'a> Escapes<'a> {
+    fn new(formatter: &'a AnsiFormatter, theme: &'a Theme) -> Self {
+        Self::with_table(formatter, theme, EscapeTable::new())
+    }
+
+    fn with_table(formatter: &'a AnsiFormatter, theme: &'a Theme, table: EscapeTable) -> Self {
+        Self { formatter, theme, table }
+    }
+
+    fn token(&mut self, kind: TokenKind, highlighted: bool) -> Option<&str> {
+        let Self { formatter, theme, .. } = *self;
+        let cache = if highlighted { &mut self.table.highlighted } else { &mut self.table.plain };
+        cache.get_or_insert_with(kind, || {
+            let style = theme.style_for(kind);
+            formatter.escape(if highlighted { formatter.highlighted(style, theme) } else { style })
+        })
+    }
+
+    fn gutter(&mut self, highlighted: bool) -> Option<&str> {
+        let Self { formatter, theme, .. } = *self;
+        self.table.gutter[usize::from(highlighted)]
+            .get_or_insert_with(|| {
+                let style = theme.gutter_style();
+                formatter.escape(if highlighted { formatter.highlighted(style, theme) } else { style })
+            })
+            .as_deref()
+    }
+
+    fn whitespace(&mut self, highlighted: bool) -> Option<&str> {
+        let Self { formatter, theme, .. } = *self;
+        self.table.whitespace[usize::from(highlighted)]
+            .get_or_insert_with(|| {
+                let style = theme.whitespace_style();
+                formatter.escape(if highlighted { formatter.highlighted(style, theme) } else { style })
+            })
+            .as_deref()
+    }
+
+    /// Writes `text` of a token of `kind`; whitespace-only tokens stay unstyled unless a line highlight or an overlay
+    /// has to paint behind them.
+    fn write_token(
+        &mut self, kind: TokenKind, blank: bool, highlighted: bool, layer: Option<Style>, text: &str, out: &mut String,
+    ) {
+        if let Some(layer) = layer {
+            let escape = self.layered(self.theme.style_for(kind), highlighted, layer);
+            write_segment(out, escape.as_deref(), text);
+            return;
+        }
+        let escape = if blank && !highlighted { None } else { self.token(kind, highlighted) };
+        write_segment(out, escape, text);
+    }
+
+    /// Writes a whitespace marker, under `layer` when overlays cover it.
+    fn write_marker(&mut self, highlighted: bool, layer: Option<Style>, marker: &str, out: &mut String) {
+        match layer {
+            Some(layer) => {
+                let escape = self.layered(self.theme.whitespace_style(), highlighted, layer);
+                write_segment(out, escape.as_deref(), marker);
+            }
+            None => write_segment(out, self.whitespace(highlighted), marker),
+        }
+    }
+
+    /// Builds the escape for `style` with an overlay `layer` on top; layers vary along a line, so these aren't cached.
+    fn layered(&self, style: Style, highlighted: bool, layer: Style) -> Option<String> {
+        let style = if highlighted { self.formatter.highlighted(style, self.theme) } else { style };
+        self.formatter.escape(layer.inherit(style))
+    }
+
+    fn line_end(&mut self) -> Option<&str> {
+        let Self { formatter, theme, .. } = *self;
+        self.table
+            .line_end
+            .get_or_insert_with(|| formatter.line_end(theme))
+            .as_deref()
+    }
+
+    /// Returns the gutter style and, on highlighted lines, the line fill that overlong lines are laid out with.
+    fn fit(&mut self, limit: usize, gutter: usize, highlighted: bool) -> Fit<'_> {
+        self.gutter(highlighted);
+        if highlighted {
+            self.line_end();
+        }
+        Fit {
+            limit,
+            overflow: self.formatter.overflow,
+            marker: self.formatter.wrap_marker,
+            gutter,
+            escape: self.table.gutter[usize::from(highlighted)]
+                .as_ref()
+                .and_then(Option::as_deref),
+            fill: if highlighted { self.table.line_end.as_ref().and_then(Option::as_deref) } else { None },
+        }
+    }
+
+    fn write_gutter(&mut self, line: usize, width: usize, out: &mut String) {
+        let number = self.formatter.lines.number(line);
+        match self.gutter(self.formatter.lines.is_highlighted(line)) {
+            Some(escape) => {
+                out.push_str(escape);
+                let _ = write!(out, "{number:>width$} ");
+                out.push_str(RESET);
+            }
+            None => {
+                let _ = write!(out, "{number:>width$} ");
+            }
+        }
+    }
+
+    /// Fills the rest of a highlighted line, or one a line overlay paints, with its background.
+    fn write_line_end(&mut self, line: usize, out: &mut String) {
+        if let Some(background) = self.formatter.overlays.line_background(line) {
+            if let Some(params) = self.formatter.profile.background_params(background) {
+                let _ = write!(out, "\x1b[{params}m\x1b[K{RESET}");
+            }
+            return;
+        }
+        if self.formatter.lines.is_highlighted(line)
+            && let Some(escape) = self.line_end()
+        {
+            out.push_str(escape);
+        }
+    }
+}
+
+impl CallFormatter for AnsiFormatter {
+    fn set_line_numbers(&mut self, enabled: bool) {
+        self.lines.numbers = enabled;
+    }
+
+    fn extend_overlays(&mut self, overlays: &[Overlay]) {
+        self.overlays.0.extend_from_slice(overlays);
+    }
+}
+
+impl Formatter for AnsiFormatter {
+    fn write_tokens(&self, src: &str, tokens: &[Token], theme: &Theme, position: Position, out: &mut String) {
+        let width = self.lines.gutter_width(position);
+        let mut line = position.line;
+        let mut at_line_start = !position.mid_line;
+        // Batches of a document started with a header share its escapes.
+        let cached = self.escapes.take(theme);
+        let resumed = cached.is_some();
+        let mut escapes = Escapes::with_table(self, theme, cached.unwrap_or_else(EscapeTable::new));
+        let mut cursor = (!self.text.verbatim()).then(|| TextCursor::new(&self.text, src, position));
+        let mut pending = String::new();
+        // With a width limit, each line is rendered here first and then laid out into `out`.
+        let mut rendered = String::new();
+        let gutter = if self.lines.numbers { width + 1 } else { 0 };
+        let mut column = if position.mid_line { self.resume_column(gutter, position.column) } else { 0 };
+
+        for token in tokens
+            .iter()
+            .flat_map(|&token| self.overlays.split(token, position.offset))
+        {
+            let text = token.text(src);
+            let blank = text.trim().is_empty();
+
+            let mut at = token.start;
+            let mut segments = text.split('\n').peekable();
+            while let Some(segment) = segments.next() {
+                let ends_line = segments.peek().is_some();
+                let sink = if self.max_width.is_some() { &mut rendered } else { &mut *out };
+                if at_line_start && self.lines.numbers && (ends_line || !segment.is_empty()) {
+                    escapes.write_gutter(line, width, sink);
+                }
+                if !segment.is_empty() {
+                    at_line_start = false;
+                    let highlighted = self.lines.is_highlighted(line);
+                    let layer = if self.overlays.0.is_empty() {
+                        None
+                    } else {
+                        let start = position.offset + at;
+                        self.overlays.layer(line, start..start + segment.len())
+                    };
+                    match &mut cursor {
+                        None => escapes.write_token(token.kind, blank, highlighted, layer, segment, sink),
+                        Some(cursor) => {
+                            cursor.pieces(at, segment.len(), |piece| match piece {
+                                Piece::Text(text) => pending.push_str(text),
+                                Piece::Marker(marker) => {
+                                    if !pending.is_empty() {
+                                        let blank = blank || pending.trim().is_empty();
+                                        escapes.write_token(token.kind, blank, highlighted, layer, &pending, sink);
+                                        pending.clear();
+                                    }
+                                    escapes.write_marker(highlighted, layer, marker, sink);
+                                }
+                            });
+                            if !pending.is_empty() {
+                                let blank = blank || pending.trim().is_empty();
+                                escapes.write_token(token.kind, blank, highlighted, layer, &pending, sink);
+                                pending.clear();
+                            }
+                        }
+                    }
+                }
+                if ends_line {
+                    self.flush_line(&mut rendered, &mut escapes, line, gutter, column, out);
+                    column = 0;
+                    escapes.write_line_end(line, out);
+                    out.push('\n');
+                    line += 1;
+                    at_line_start = true;
+                    if let Some(cursor) = &mut cursor {
+                        cursor.new_line();
+                    }
+                }
+                at += segment.len() + 1;
+            }
+        }
+        // A line the next batch continues is laid out from the column it reached.
+        self.flush_line(&mut rendered, &mut escapes, line, gutter, column, out);
+        if resumed {
+            self.escapes.store(theme, escapes.table);
+        }
+    }
+
+    fn write_header(&self, theme: &Theme, _out: &mut String) {
+        self.escapes.store(theme, EscapeTable::new());
+    }
+
+    fn write_footer(&self, theme: &Theme, position: Position, out: &mut String) {
+        let table = self.escapes.take(theme);
+        if position.mid_line {
+            Escapes::with_table(self, theme, table.unwrap_or_else(EscapeTable::new)).write_line_end(position.line, out);
+        }
+    }
+
+    /// Writes the emphasis as overlays of [Theme::search_match_color], after any overlays of the formatter's own.
+    fn write_emphasized(
+        &self, src: &str, tokens: &[Token], emphasis: &[Range<usize>], theme: &Theme, position: Position,
+        out: &mut String,
+    ) {
+        let background = Style::new().with_background(theme.search_match_color());
+        let mut formatter = self.clone();
+        formatter.overlays.0.extend(
+            emphasis
+                .iter()
+                .map(|range| Overlay::bytes(position.offset + range.start..position.offset + range.end, background)),
+        );
+        formatter.write_tokens(src, tokens, theme, position, out);
+    }
+
+    fn write_diff(&self, rows: &[DiffRow], mode: DiffMode, theme: &Theme, out: &mut String) {
+        // Diff lines are highlighted lines with the diff colors as their line highlights.
+        let tinted = |color: Srgb8| {
+            let mut theme = theme.clone();
+            theme.line_highlight = Some(color.into());
+            theme
+        };
+        let (added, removed, changed) = (
+            tinted(theme.diff_added_color()),
+            tinted(theme.diff_removed_color()),
+            tinted(theme.diff_changed_color()),
+        );
+        let mut cells = DiffCells {
+            escapes: [
+                Escapes::new(self, theme),
+                Escapes::new(self, &added),
+                Escapes::new(self, &removed),
+            ],
+            changed: Escapes::new(self, &changed),
+            numbers: self.lines.numbers.then(|| diffview::number_width(rows)),
+        };
+
+        let width = diffview::left_width(rows);
+        for row in rows {
+            match (row, mode) {
+                (DiffRow::Lines { old, new }, DiffMode::Inline) => {
+                    let line = new
+                        .as_ref()
+                        .or(old.as_ref())
+                        .expect("diff rows have a line on some side");
+                    cells.write_line(line, out);
+                    cells.write_line_end(line.change, out);
+                }
+                (DiffRow::Lines { old, new }, DiffMode::SideBySide) => {
+                    let change = old.as_ref().map_or(LineChange::Unchanged, |line| line.change);
+                    match old {
+                        Some(line) => {
+                            cells.write_line(line, out);
+                            cells.write_pad(width - line.text.chars().count(), change, out);
+                        }
+                        None => {
+                            cells.write_blank(out);
+                            cells.write_pad(width + 1, change, out);
+                        }
+                    }
+                    cells.write_note(" │ ", out);
+                    if let Some(line) = new {
+                        cells.write_line(line, out);
+                        cells.write_line_end(line.change, out);
+                    }
+                }
+                (DiffRow::Collapsed { lines }, _) => {
+                    cells.write_blank(out);
+                    cells.write_note(&DiffRow::collapsed_label(*lines), out);
+                }
+                (DiffRow::NoNewline { .. }, DiffMode::Inline) => {
+                    cells.write_blank(out);
+                    cells.write_note(NO_NEWLINE, out);
+                }
+                (DiffRow::NoNewline { old, new }, DiffMode::SideBySide) => {
+                    cells.write_blank(out);
+                    let left = if *old { NO_NEWLINE } else { "" };
+                    cells.write_note(left, out);
+                    cells.write_pad(width + 1 - left.chars().count(), LineChange::Unchanged, out);
+                    cells.write_note(" │ ", out);
+                    if *new {
+                        cells.write_blank(out);
+                        cells.write_note(NO_NEWLINE, out);
+                    }
+                }
+            }
+            out.push('\n');
+        }
+    }
+
+    fn fingerprint(&self) -> Option<u64> {
+        Some(debug_fingerprint(self))
+    }
+}
+
+/// The escapes [AnsiFormatter::write_diff] writes lines with: plain, added, and removed lines (indexed by
+/// [LineChange]), and the changed words within paired lines.
+struct DiffCells<'a> {
+    escapes: [Escapes<'a>; 3],
+    changed: Escapes<'a>,
+    /// Gutter width when lines are numbered.
+    numbers: Option<usize>,
+}
+
+impl<'a> DiffCells<'a> {
+    fn escapes(&mut self, change: LineChange) -> &mut Escapes<'a> {
+        match change {
+            LineChange::Unchanged => &mut self.escapes[0],
+            LineChange::Added => &mut self.escapes[1],
+            LineChange::Removed => &mut self.escapes[2],
+        }
+    }
+
+    /// Writes a line's number, sign, and text, painting everything but unchanged lines, blanks included.
+    fn write_line(&mut self, line: &DiffLine, out: &mut String) {
+        let painted = line.change != LineChange::Unchanged;
+        if let Some(width) = self.numbers {
+            let number = line.number;
+            match self.escapes(line.change).gutter(painted) {
+                Some(escape) => {
+                    out.push_str(escape);
+                    let _ = write!(out, "{number:>width$} ");
+                    out.push_str(RESET);
+                }
+                None => {
+                    let _ = write!(out, "{number:>width$} ");
+                }
+            }
+        }
+        let (sign, kind) = line.sign();
+        let escape = if painted { self.escapes(line.change).token(kind, true) } else { None };
+        write_segment(out, escape, sign);
+        for (kind, text, emphasized) in line.spans() {
+            let escape = if emphasized {
+                self.changed.token(kind, true)
+            } else if painted || !text.trim().is_empty() {
+                self.escapes(line.change).token(kind, painted)
+            } else {
+                None
+            };
+            write_segment(out, escape, text);
+        }
+    }
+
+    /// Writes the empty gutter of a row without a line number.
+    fn write_blank(&self, out: &mut String) {
+        if let Some(width) = self.numbers {
+            let _ = write!(out, "{:width$} ", "");
+        }
+    }
+
+    /// Pads a side-by-side column with spaces in the background of `change`.
+    fn write_pad(&mut self, columns: usize, change: LineChange, out: &mut String) {
+        if columns == 0 {
+            return;
+        }
+        let painted = change != LineChange::Unchanged;
+        let escape = if painted { self.escapes(change).token(TokenKind::Whitespace, true) } else { None };
+        write_segment(out, escape, &" ".repeat(columns));
+    }
+
+    /// Writes a collapsed-lines marker, missing-newline note, or column rule in the gutter style.
+    fn write_note(&mut self, text: &str, out: &mut String) {
+        if !text.is_empty() {
+            write_segment(out, self.escapes[0].gutter(false), text);
+        }
+    }
+
+    /// Paints the rest of an added or removed line up to the terminal edge.
+    fn write_line_end(&mut self, change: LineChange, out: &mut String) {
+        if change != LineChange::Unchanged
+            && let Some(escape) = self.escapes(change).line_end()
+        {
+            out.push_str(escape);
+        }
+    }
+}
+
+/// Lays out one rendered line, escapes and all, within [AnsiFormatter::with_max_width].
+struct Fit<'e> {
+    limit: usize,
+    overflow: Overflow,
+    marker: bool,
+    /// Cells of the line-number gutter, which continuation lines leave blank.
+    gutter: usize,
+    /// Gutter style, for the wrap marker and the ellipsis.
+    escape: Option<&'e str>,
+    /// The highlighted-line fill, which every row of a wrapped line ends with.
+    fill: Option<&'e str>,
+}
+
+/// A part of a rendered line: an escape sequence or a character.
+enum Rendered<'a> {
+    Escape(&'a str),
+    Char(char),
+}
+
+/// Splits a rendered line into escape sequences, which are kept whole, and the characters between them, each with
+/// its byte offset.
+fn rendered(line: &str) -> impl Iterator<Item = (usize, Rendered<'_>)> {
+    let mut at = 0;
+    std::iter::from_fn(move || {
+        let rest = &line[at..];
+        let start = at;
+        if let Some(params) = rest.strip_prefix("\x1b[") {
+            let len = params
+                .find(|ch: char| ('@'..='~').contains(&ch))
+                .map_or(params.len(), |end| end + 1);
+            at += 2 + len;
+            return Some((start, Rendered::Escape(&line[start..at])));
+        }
+        let ch = rest.chars().next()?;
+        at += ch.len_utf8();
+        Some((start, Rendered::Char(ch)))
+    })
+}
+
+impl Fit<'_> {
+    /// Cells `ch` takes at `column`: raw tabs advance to the terminal's next tab stop.
+    fn cells(ch: char, column: usize, widths: &mut Widths) -> usize {
+        match ch {
+            '\t' => TERMINAL_TAB_WIDTH - column % TERMINAL_TAB_WIDTH,
+            _ => widths.next(ch),
+        }
+    }
+
+    /// Returns the cell `line` ends at when it starts at `column` and nothing limits it.
+    fn measure(line: &str, mut column: usize) -> usize {
+        let mut widths = Widths::default();
+        for (_, part) in rendered(line) {
+            if let Rendered::Char(ch) = part {
+                column += Self::cells(ch, column, &mut widths);
+            }
+        }
+        column
+    }
+
+    fn write(&self, line: &str, column: usize, out: &mut String) {
+        if Self::measure(line, column) <= self.limit {
+            out.push_str(line);
+            return;
+        }
+        match self.overflow {
+            Overflow::Wrap => self.wrap(line, column, out),
+            // A line cut short by an earlier batch has already been marked.
+            Overflow::Truncate if column >= self.limit => {}
+            Overflow::Truncate => self.truncate(line, column, out),
+        }
+    }
+
+    fn wrap(&self, line: &str, mut column: usize, out: &mut String) {
+        let mut widths = Widths::default();
+        let mut styled = Styled::default();
+        // A row always takes at least one character, so a limit narrower than the indent still makes progress.
+        let mut row_start = column;
+        for (at, part) in rendered(line) {
+            let ch = match part {
+                Rendered::Escape(escape) => {
+                    styled.escape(at, escape, out);
+                    continue;
+                }
+                Rendered::Char(ch) => ch,
+            };
+            let mut cells = Self::cells(ch, column, &mut widths);
+            if column + cells > self.limit && column > row_start {
+                styled.close(out);
+                if let Some(fill) = self.fill {
+                    out.push_str(fill);
+                }
+                out.push('\n');
+                column = self.write_continuation(out);
+                row_start = column;
+                styled.reopen(out);
+                if ch == '\t' {
+                    cells = Self::cells(ch, column, &mut widths);
+                }
+            }
+            styled.flush(line, out);
+            out.push(ch);
+            column += cells;
+        }
+        styled.flush(line, out);
+    }
+
+    fn truncate(&self, line: &str, mut column: usize, out: &mut String) {
+        let room = self.limit - 1;
+        let mut widths = Widths::default();
+        let mut styled = Styled::default();
+        for (at, part) in rendered(line) {
+            match part {
+                Rendered::Escape(escape) => styled.escape(at, escape, out),
+                Rendered::Char(ch) => {
+                    let cells = Self::cells(ch, column, &mut widths);
+                    if column + cells > room {
+                        styled.close(out);
+                        write_segment(out, self.escape, ELLIPSIS);
+                        return;
+                    }
+                    styled.flush(line, out);
+                    out.push(ch);
+                    column += cells;
+                }
+            }
+        }
+    }
+
+    /// Starts a continuation row: a blank gutter, then the marker or its width in spaces. Returns the cells used.
+    fn write_continuation(&self, out: &mut String) -> usize {
+        let marked = self.marker || self.fill.is_some();
+        let escape = if marked { self.escape } else { None };
+        if let Some(escape) = escape {
+            out.push_str(escape);
+        }
+        out.extend(std::iter::repeat_n(' ', self.gutter));
+        out.push_str(if self.marker { WRAP_MARKER } else { "  " });
+        if escape.is_some() {
+            out.push_str(RESET);
+        }
+        self.gutter + WRAP_INDENT
+    }
+}
+
+/// Tracks the style a rendered line has open, so a wrap can close it before the line break and reopen it after.
+/// Escapes that open a style are held back until the character they style, so none is left dangling at a break.
+#[derive(Default)]
+struct Styled<'a> {
+    /// The escape in effect in what has been written.
+    written: Option<&'a str>,
+    /// Escapes not yet written, as a byte range of the line, and the last of them.
+    held: Option<(usize, usize, &'a str)>,
+}
+
+impl<'a> Styled<'a> {
+    fn escape(&mut self, at: usize, escape: &'a str, out: &mut String) {
+        if escape == RESET {
+            // Nothing was styled by the held escapes, so they can go.
+            self.held = None;
+            out.push_str(RESET);
+            self.written = None;
+        } else {
+            let start = self.held.map_or(at, |(start, ..)| start);
+            self.held = Some((start, at + escape.len(), escape));
+        }
+    }
+
+    /// Writes the escapes held back.
+    fn flush(&mut self, line: &str, out: &mut String) {
+        if let Some((start, end, last)) = self.held.take() {
+            out.push_str(&line[start..end]);
+            self.written = Some(last);
+        }
+    }
+
+    fn close(&self, out: &mut String) {
+        if self.written.is_some() {
+            out.push_str(RESET);
+        }
+    }
+
+    fn reopen(&self, out: &mut String) {
+        if let Some(escape) = self.written {
+            out.push_str(escape);
+        }
+    }
+}
+
+/// Writes `text` wrapped in `escape` and a reset, or bare when there is no escape.
+fn write_segment(out: &mut String, escape: Option<&str>, text: &str) {
+    match escape {
+        Some(escape) => {
+            out.push_str(escape);
+            out.push_str(text);
+            out.push_str(RESET);
+        }
+        None => out.push_str(text),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+
+    fn theme() -> Theme {
+        let mut theme = Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        theme.set(TokenKind::Keyword, Srgb8::new(255, 0, 0));
+        theme.set(TokenKind::Comment, Srgb8::new(100, 100, 100));
+        theme
+    }
+
+    fn render(profile: ColorProfile, src: &str, tokens: &[Token]) -> String {
+        let mut out = String::new();
+        AnsiFormatter::new()
+            .with_profile(profile)
+            .format(src, tokens, &theme(), &mut out);
+        out
+    }
+
+    #[test]
+    fn styled_tokens_are_wrapped_in_escapes() {
+        let src = "fn main";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::Whitespace, 2, 3),
+            Token::new(TokenKind::Text, 3, 7),
+        ];
+        let out = render(ColorProfile::TrueColor, src, &tokens);
+        assert_eq!(out, "\x1b[38;2;255;0;0mfn\x1b[0m \x1b[38;2;200;200;200mmain\x1b[0m");
+    }
+
+    #[test]
+    fn overlays_layer_over_token_styles() {
+        let src = "fn main\nx";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::Whitespace, 2, 3),
+            Token::new(TokenKind::Text, 3, 7),
+            Token::new(TokenKind::Whitespace, 7, 8),
+            Token::new(TokenKind::Text, 8, 9),
+        ];
+        let formatter = AnsiFormatter::new()
+            .with_profile(ColorProfile::TrueColor)
+            .with_overlays([
+                Overlay::bytes(1..5, Style::new().with_background(Srgb8::new(0, 0, 80))),
+                Overlay::bytes(
+                    4..6,
+                    Style::new().with_underline(true).with_background(Srgb8::new(80, 0, 0)),
+                ),
+                Overlay::lines(1..2, Style::new().with_background(Srgb8::new(0, 40, 0))),
+            ]);
+        let mut out = String::new();
+        formatter.format(src, &tokens, &theme(), &mut out);
+        let (blue, both, green) = (";48;2;0;0;80m", ";48;2;80;0;0m", ";48;2;0;40;0m");
+        let (text, underlined) = ("\x1b[38;2;200;200;200", "\x1b[4;38;2;200;200;200");
+        // "a" is split off where the first overlay ends, and the second one's fields win over it.
+        assert_eq!(
+            out,
+            format!(
+                "\x1b[38;2;255;0;0mf\x1b[0m\x1b[38;2;255;0;0{blue}n\x1b[0m{text}{blue} \x1b[0m{text}{blue}m\x1b[0m\
+                 {underlined}{both}a\x1b[0m{underlined}{both}i\x1b[0m{text}mn\x1b[0m\n\
+                 {text}{green}x\x1b[0m\x1b[48;2;0;40;0m\x1b[K\x1b[0m"
+            )
+        );
+    }
+
+    #[test]
+    fn multi_line_tokens_reset_before_each_newline() {
+        let src = "/* a\nb */";
+        let tokens = [Token::new(TokenKind::Comment, 0, src.len())];
+        let out = render(ColorProfile::TrueColor, src, &tokens);
+        assert_eq!(
+            out,
+            "\x1b[38;2;100;100;100m/* a\x1b[0m\n\x1b[38;2;100;100;100mb */\x1b[0m"
+        );
+    }
+
+    #[test]
+    fn escapes_follow_the_color_profile() {
+        let cases = [
+            ("#ff0000", ColorProfile::TrueColor, "\x1b[38;2;255;0;0m"),
+            ("#ff0000", ColorProfile::Ansi256, "\x1b[38;5;196m"),
+            ("#ff0000", ColorProfile::Ansi16, "\x1b[91m"),
+            ("#6c7086", ColorProfile::TrueColor, "\x1b[38;2;108;112;134m"),
+            ("#6c7086", ColorProfile::Ansi256, "\x1b[38;5;60m"),
+            ("#6c7086", ColorProfile::Ansi16, "\x1b[90m"),
+            ("#89b4fa", ColorProfile::TrueColor, "\x1b[38;2;137;180;250m"),
+            ("#89b4fa", ColorProfile::Ansi256, "\x1b[38;5;111m"),
+            ("#89b4fa", ColorProfile::Ansi16, "\x1b[94m"),
+            ("#cdcd00", ColorProfile::Ansi16, "\x1b[33m"),
+        ];
+        for (hex, profile, escape) in cases {
+            let mut theme = theme();
+            theme.set(TokenKind::Keyword, Srgb8::from_hex(hex).unwrap());
+            let mut out = String::new();
+            AnsiFormatter::new().with_profile(profile).format(
+                "if",
+                &[Token::new(TokenKind::Keyword, 0, 2)],
+                &theme,
+                &mut out,
+            );
+            assert_eq!(out, format!("{escape}if\x1b[0m"), "{hex} {profile:?}");
+        }
+    }
+
+    #[test]
+    fn no_color_profile_emits_plain_text() {
+        let src = "fn main";
+        let tokens = [Token::new(TokenKind::Keyword, 0, 2), Token::new(TokenKind::Text, 2, 7)];
+        assert_eq!(render(ColorProfile::NoColor, src, &tokens), "fn main");
+    }
+
+    #[test]
+    fn font_attributes_precede_colors() {
+        let mut theme = theme();
+        theme.set_style(
+            TokenKind::Keyword,
+            Style::new()
+                .with_foreground(Srgb8::new(255, 0, 0))
+                .with_bold(true)
+                .with_underline(true),
+        );
+        theme.set_style(
+            TokenKind::KeywordDeclaration,
+            Style::new()
+                .with_bold(false)
+                .with_italic(true)
+                .with_strikethrough(true)
+                .with_background(Srgb8::new(0, 0, 255)),
+        );
+        let src = "if let";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::Whitespace, 2, 3),
+            Token::new(TokenKind::KeywordDeclaration, 3, 6),
+        ];
+        let mut out = String::new();
+        AnsiFormatter::new()
+            .with_profile(ColorProfile::TrueColor)
+            .format(src, &tokens, &theme, &mut out);
+        assert_eq!(
+            out,
+            "\x1b[1;4;38;2;255;0;0mif\x1b[0m \x1b[3;4;9;38;2;255;0;0;48;2;0;0;255mlet\x1b[0m"
+        );
+    }
+
+    #[test]
+    fn color_choice_overrides_the_profile() {
+        let src = "fn";
+        let tokens = [Token::new(TokenKind::Keyword, 0, 2)];
+        let render = |formatter: AnsiFormatter| {
+            let mut out = String::new();
+            formatter.format(src, &tokens, &theme(), &mut out);
+            out
+        };
+
+        let never = AnsiFormatter::new()
+            .with_profile(ColorProfile::TrueColor)
+            .with_color(ColorChoice::Never);
+        assert_eq!(never.profile(), ColorProfile::NoColor);
+        assert_eq!(render(never), "fn");
+
+        let mut piped = AnsiFormatter::new().with_color(ColorChoice::Always);
+        piped.terminal = false;
+        let piped = piped.resolved();
+        assert_ne!(piped.profile(), ColorProfile::NoColor);
+        assert!(render(piped).starts_with("\x1b["));
+
+        let mut dumb = AnsiFormatter::new();
+        dumb.detected = ColorProfile::NoColor;
+        assert_eq!(dumb.with_color(ColorChoice::Always).profile(), ColorProfile::Ansi16);
+    }
+
+    #[test]
+    fn piped_output_follows_the_environment() {
+        let mut formatter = AnsiFormatter::new();
+        formatter.detected = ColorProfile::TrueColor;
+        formatter.terminal = false;
+        let expected = match color_override() {
+            Some(true) => ColorProfile::TrueColor,
+            _ => ColorProfile::NoColor,
+        };
+        assert_eq!(formatter.resolved().profile(), expected);
+    }
+
+    fn render_lines(formatter: &AnsiFormatter, src: &str) -> String {
+        let mut out = String::new();
+        formatter.format(src, &[Token::new(TokenKind::Text, 0, src.len())], &theme(), &mut out);
+        out
+    }
+
+    #[test]
+    fn gutter_stays_aligned_past_four_digits() {
+        let src = "x\n".repeat(10);
+        let formatter = AnsiFormatter::new()
+            .with_profile(ColorProfile::NoColor)
+            .with_line_numbers(true)
+            .with_line_number_start(9_995);
+        let out = render_lines(&formatter, &src);
+        let lines: Vec<&str> = out.lines().collect();
+        assert_eq!(lines.len(), 10);
+        assert_eq!(lines[0], " 9995 x");
+        assert_eq!(lines[4], " 9999 x");
+        assert_eq!(lines[5], "10000 x");
+        assert_eq!(lines[9], "10004 x");
+    }
+
+    #[test]
+    fn highlighted_ranges_follow_the_start_offset() {
+        let formatter = AnsiFormatter::new()
+            .with_profile(ColorProfile::TrueColor)
+            .with_line_number_start(10)
+            .with_highlight_lines([11..=11]);
+        let out = render_lines(&formatter, "a\nb\nc");
+        let fg = "\x1b[38;2;200;200;200m";
+        let highlighted = "\x1b[38;2;200;200;200;48;2;30;30;30m";
+        let fill = "\x1b[48;2;30;30;30m\x1b[K\x1b[0m";
+        assert_eq!(out, format!("{fg}a\x1b[0m\n{highlighted}b\x1b[0m{fill}\n{fg}c\x1b[0m"));
+
+        // The last line has no newline, so the footer fills it.
+        let last = AnsiFormatter::new()
+            .with_profile(ColorProfile::TrueColor)
+            .with_line_number_start(10)
+            .with_highlight_lines([12..=20]);
+        assert!(render_lines(&last, "a\nb\nc").ends_with(&format!("{highlighted}c\x1b[0m{fill}")));
+    }
+
+    #[test]
+    fn gutter_uses_the_theme_style_and_highlight() {
+        let mut theme = theme();
+        theme.gutter = Style::new().with_foreground(Srgb8::new(1, 2, 3)).with_italic(true);
+        let src = "a\n\nb\n";
+        let formatter = AnsiFormatter::new()
+            .with_profile(ColorProfile::TrueColor)
+            .with_line_numbers(true)
+            .with_highlight_lines([2..=2]);
+        let mut out = String::new();
+        formatter.format(
+            src,
+            &[Token::new(TokenKind::Whitespace, 0, src.len())],
+            &theme,
+            &mut out,
+        );
+
+        let lines: Vec<&str> = out.lines().collect();
+        assert_eq!(lines[0], "\x1b[3;38;2;1;2;3m1 \x1b[0m\x1b[38;2;200;200;200ma\x1b[0m");
+        // Empty highlighted lines still get a gutter and the full-width fill.
+        assert_eq!(
+            lines[1],
+            "\x1b[3;38;2;1;2;3;48;2;30;30;30m2 \x1b[0m\x1b[48;2;30;30;30m\x1b[K\x1b[0m"
+        );
+        assert_eq!(lines.len(), 3);
+    }
+
+    #[test]
+    fn expanded_tabs_keep_code_aligned_after_the_gutter() {
+        let src = "a\tb\n\tc\n";
+        let tokens = [
+            Token::new(TokenKind::Text, 0, 3),
+            Token::new(TokenKind::Text, 3, 5),
+            Token::new(TokenKind::Text, 5, 7),
+        ];
+        let formatter = AnsiFormatter::new()
+            .with_profile(ColorProfile::NoColor)
+            .with_line_numbers(true)
+            .with_line_number_start(9)
+            .with_tab_width(4);
+        let mut out = String::new();
+        formatter.format(src, &tokens, &theme(), &mut out);
+        assert_eq!(out, " 9 a   b\n10     c\n");
+
+        // A batch continuing a line expands from the column it starts at.
+        let mut out = String::new();
+        let position = Position { mid_line: true, column: 2, ..Position::new() };
+        formatter.write_tokens(
+            "\tx",
+            &[Token::new(TokenKind::Text, 0, 2)],
+            &theme(),
+            position,
+            &mut out,
+        );
+        assert_eq!(out, "  x");
+    }
+
+    #[test]
+    fn normalized_line_endings_drop_carriage_returns_across_tokens() {
+        let src = "fn\r\nx\r\n";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::Text, 2, 3),
+            Token::new(TokenKind::Whitespace, 3, 4),
+            Token::new(TokenKind::Text, 4, 6),
+            Token::new(TokenKind::Whitespace, 6, 7),
+        ];
+        let mut out = String::new();
+        AnsiFormatter::new()
+            .with_profile(ColorProfile::TrueColor)
+            .with_normalize_crlf(true)
+            .format(src, &tokens, &theme(), &mut out);
+        let fg = "\x1b[38;2;200;200;200m";
+        assert_eq!(out, format!("\x1b[38;2;255;0;0mfn\x1b[0m\n{fg}x\x1b[0m\n"));
+    }
+
+    #[test]
+    fn visible_whitespace_uses_the_theme_style() {
+        let mut theme = theme();
+        theme.whitespace = Style::new().with_foreground(Srgb8::new(1, 2, 3));
+        let src = "a b\t \n";
+        let tokens = [Token::new(TokenKind::Text, 0, src.len())];
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::TrueColor);
+        let mut out = String::new();
+        formatter
+            .clone()
+            .with_show_whitespace(ShowWhitespace::Trailing)
+            .format(src, &tokens, &theme, &mut out);
+        let (fg, ws) = ("\x1b[38;2;200;200;200m", "\x1b[38;2;1;2;3m");
+        assert_eq!(out, format!("{fg}a b\x1b[0m{ws}→\x1b[0m    {ws}·\x1b[0m\n"));
+
+        let mut out = String::new();
+        formatter
+            .with_profile(ColorProfile::NoColor)
+            .with_show_whitespace(ShowWhitespace::All)
+            .format(src, &tokens, &theme, &mut out);
+        assert_eq!(out, "a·b→    ·\n");
+    }
+
+    fn render_fitted(formatter: AnsiFormatter, src: &str, tokens: &[Token]) -> String {
+        let mut out = String::new();
+        formatter.format(src, tokens, &theme(), &mut out);
+        out
+    }
+
+    #[test]
+    fn wrapped_cjk_comments_count_two_cells_per_character() {
+        let src = "// 注释注释注释\n";
+        let tokens = [
+            Token::new(TokenKind::Comment, 0, src.len() - 1),
+            Token::new(TokenKind::Whitespace, src.len() - 1, src.len()),
+        ];
+        let formatter = AnsiFormatter::new().with_max_width(10);
+        let plain = render_fitted(formatter.clone().with_profile(ColorProfile::NoColor), src, &tokens);
+        assert_eq!(plain, "// 注释注\n  释注释\n");
+
+        // The comment's style is closed before the break and reopened after the indent.
+        let colored = render_fitted(formatter.with_profile(ColorProfile::TrueColor), src, &tokens);
+        let gray = "\x1b[38;2;100;100;100m";
+        assert_eq!(colored, format!("{gray}// 注释注\x1b[0m\n  {gray}释注释\x1b[0m\n"));
+    }
+
+    #[test]
+    fn emoji_strings_are_cut_between_whole_glyphs() {
+        let src = "s = \"👍🏽 ok 👩\u{200d}💻!\"\n";
+        let tokens = [
+            Token::new(TokenKind::Text, 0, 4),
+            Token::new(TokenKind::String, 4, src.len() - 1),
+            Token::new(TokenKind::Whitespace, src.len() - 1, src.len()),
+        ];
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::NoColor);
+        let truncated = render_fitted(
+            formatter.clone().with_max_width(12).with_overflow(Overflow::Truncate),
+            src,
+            &tokens,
+        );
+        assert_eq!(truncated, "s = \"👍🏽 ok …\n");
+
+        let wrapped = render_fitted(formatter.with_max_width(8).with_wrap_marker(true), src, &tokens);
+        assert_eq!(wrapped, "s = \"👍🏽 \n↪ ok 👩\u{200d}💻!\n↪ \"\n");
+    }
+
+    #[test]
+    fn lines_exactly_at_the_limit_are_left_alone() {
+        let src = "abcdefghij\nabcdefghijk\n";
+        let tokens = [Token::new(TokenKind::Text, 0, src.len())];
+        let formatter = AnsiFormatter::new()
+            .with_profile(ColorProfile::NoColor)
+            .with_max_width(10);
+        assert_eq!(
+            render_fitted(formatter.clone(), src, &tokens),
+            "abcdefghij\nabcdefghij\n  k\n"
+        );
+        assert_eq!(
+            render_fitted(formatter.with_overflow(Overflow::Truncate), src, &tokens),
+            "abcdefghij\nabcdefghi…\n"
+        );
+    }
+
+    #[test]
+    fn wrapping_never_splits_an_escape() {
+        let src = "fn main";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::Whitespace, 2, 3),
+            Token::new(TokenKind::Text, 3, 7),
+        ];
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::TrueColor);
+        let (red, fg) = ("\x1b[38;2;255;0;0m", "\x1b[38;2;200;200;200m");
+        assert_eq!(
+            render_fitted(formatter.clone().with_max_width(4), src, &tokens),
+            format!("{red}fn\x1b[0m {fg}m\x1b[0m\n  {fg}ai\x1b[0m\n  {fg}n\x1b[0m")
+        );
+        // Breaking right before a token leaves no empty styled run behind.
+        assert!(
+            render_fitted(formatter.with_max_width(3), src, &tokens).starts_with(&format!("{red}fn\x1b[0m \n  {fg}m"))
+        );
+    }
+
+    #[test]
+    fn continuation_lines_leave_the_gutter_blank() {
+        let mut theme = theme();
+        theme.gutter = Style::new().with_foreground(Srgb8::new(1, 2, 3));
+        let src = "abcdefgh\nxy\n";
+        let formatter = AnsiFormatter::new()
+            .with_profile(ColorProfile::TrueColor)
+            .with_line_numbers(true)
+            .with_max_width(8)
+            .with_wrap_marker(true);
+        let mut out = String::new();
+        formatter.format(src, &[Token::new(TokenKind::Text, 0, src.len())], &theme, &mut out);
+        let (gutter, fg) = ("\x1b[38;2;1;2;3m", "\x1b[38;2;200;200;200m");
+        assert_eq!(
+            out,
+            format!(
+                "{gutter}1 \x1b[0m{fg}abcdef\x1b[0m\n{gutter}  ↪ \x1b[0m{fg}gh\x1b[0m\n{gutter}2 \x1b[0m{fg}xy\x1b[0m\n"
+            )
+        );
+    }
+}
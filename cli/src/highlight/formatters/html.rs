@@ -0,0 +1,1005 @@
+//! HTML formatter emitting either CSS classes or inline styles.
+
+use super::{
+    Formatter, KindCache, LineOptions, Overlay, Overlays, Piece, Position, ShowWhitespace, TextCursor, TextOptions,
+    ThemeCache, emphasis_spans,
+};
+use crate::highlight::cache::debug_fingerprint;
+use crate::highlight::diffview::{self, NO_NEWLINE};
+use crate::highlight::theme::css_declarations;
+use crate::highlight::{CallFormatter, DiffLine, DiffMode, DiffRow, LineChange, Style, Theme, Token, TokenKind};
+
+use std::fmt::Write;
+use std::ops::{Range, RangeInclusive};
+
+/// Renders tokens as a `<pre><code>` block of `<span>` elements.
+///
+/// By default spans carry inline `style` attributes so the markup is self-contained.
+/// [HtmlFormatter::with_classes] switches to prefixed class names that pair with [HtmlFormatter::css]; spans list the
+/// parent kind's class before their own (`kw kd`) so the stylesheet can inherit styles the way [Theme::style_for]
+/// does.
+///
+/// With line numbers or highlighted lines enabled, every line is wrapped in a block-level span so highlights span the
+/// full width. Numbers are kept out of copied text: class mode renders them from a `data-line` attribute through CSS
+/// generated content, and inline mode marks them `user-select: none`.
+///
+/// Rich diffs ([crate::highlight::highlight_diff]) render inline as a `<pre>` of block-level lines painted with the
+/// diff backgrounds, and side by side as a two-column `<table>` whose cells keep their whitespace. Class mode names
+/// the backgrounds `diff-added`, `diff-removed`, and `diff-changed`, and the markers for collapsed lines and missing
+/// newlines `diff-note`.
+///
+/// Search matches in snippets ([crate::highlight::extract_snippets]) are wrapped in spans painted with
+/// [Theme::search_match_color], the `match` class in class mode, inside the token spans' line so token colors show
+/// through.
+///
+/// [HtmlFormatter::with_overlays] layers styles over parts of the document as inline declarations on the spans they
+/// cover, on top of the token's classes in class mode; line overlays wrap lines in block-level spans and paint them
+/// full width.
+#[derive(Debug, Clone, Default)]
+pub struct HtmlFormatter {
+    class_prefix: Option<String>,
+    lines: LineOptions,
+    text: TextOptions,
+    overlays: Overlays,
+    /// Tags of the document being written, from its header on.
+    tags: ThemeCache<TagTable>,
+}
+
+impl HtmlFormatter {
+    /// Creates a formatter that uses inline styles.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Emits `class="{prefix}{kind}"` attributes instead of inline styles (e.g., `clz-kw` for keywords).
+    pub fn with_classes(mut self, prefix: impl Into<String>) -> Self {
+        self.class_prefix = Some(prefix.into());
+        self
+    }
+
+    /// Prefixes every line with its number.
+    pub fn with_line_numbers(mut self, enabled: bool) -> Self {
+        self.lines.numbers = enabled;
+        self
+    }
+
+    /// Number of the first line (default 1), for excerpts that start mid-file.
+    pub fn with_line_number_start(mut self, start: usize) -> Self {
+        self.lines.start = start;
+        self
+    }
+
+    /// Emphasizes the given ranges of displayed line numbers (so they account for [Self::with_line_number_start]).
+    pub fn with_highlight_lines(mut self, ranges: impl IntoIterator<Item = RangeInclusive<usize>>) -> Self {
+        self.lines.highlights = ranges.into_iter().collect();
+        self
+    }
+
+    /// Expands tabs to spaces up to the next multiple of `width` columns, so code lines up the same whatever the
+    /// gutter and the page's `tab-size`. Token offsets still refer to the original text.
+    pub fn with_tab_width(mut self, width: usize) -> Self {
+        self.text.tab_width = Some(width.max(1));
+        self
+    }
+
+    /// Drops the carriage return of `\r\n` line endings, so it doesn't end up inside the last span of each line.
+    pub fn with_normalize_crlf(mut self, enabled: bool) -> Self {
+        self.text.normalize_crlf = enabled;
+        self
+    }
+
+    /// Draws spaces and tabs as `·` and `→` markers in [Theme::whitespace_style] (the `ws` class in class mode). The
+    /// markers replace the whitespace, so they are copied along with the code.
+    pub fn with_show_whitespace(mut self, mode: ShowWhitespace) -> Self {
+        self.text.whitespace = mode;
+        self
+    }
+
+    /// Layers `overlays` over the tokens they cover, in order; see [Overlay]. Byte offsets are into the whole
+    /// document, so batches written while streaming or for excerpts place them by [Position::offset].
+    pub fn with_overlays(mut self, overlays: impl IntoIterator<Item = Overlay>) -> Self {
+        self.overlays = Overlays(overlays.into_iter().collect());
+        self
+    }
+
+    /// Renders the stylesheet for class mode: the theme's token rules ([Theme::css]) plus line, highlight, gutter,
+    /// whitespace, and diff rules. Inline mode needs no stylesheet, so this returns an empty string there.
+    pub fn css(&self, theme: &Theme) -> String {
+        let Some(prefix) = &self.class_prefix else { return String::new() };
+        let mut css = theme.css(prefix);
+        let _ = writeln!(css, ".{prefix}line {{ display: block; }}");
+        let _ = writeln!(
+            css,
+            ".{prefix}hl {{ background-color: {}; }}",
+            theme.line_highlight_rgba().to_css()
+        );
+        let _ = writeln!(
+            css,
+            ".{prefix}ln::before {{ content: attr(data-line) \" \"; {} }}",
+            inline_css(theme.gutter_style())
+        );
+        let _ = writeln!(css, ".{prefix}ws {{ {} }}", inline_css(theme.whitespace_style()));
+        let _ = writeln!(
+            css,
+            ".{prefix}diff {{ border-collapse: collapse; font-family: monospace; }}"
+        );
+        let _ = writeln!(css, ".{prefix}diff td {{ white-space: pre; vertical-align: top; }}");
+        for (class, color) in [
+            ("diff-added", theme.diff_added_color()),
+            ("diff-removed", theme.diff_removed_color()),
+            ("diff-changed", theme.diff_changed_color()),
+        ] {
+            let _ = writeln!(css, ".{prefix}{class} {{ background-color: {}; }}", color.to_hex());
+        }
+        let _ = writeln!(css, ".{prefix}diff-note {{ {} }}", inline_css(theme.gutter_style()));
+        let _ = writeln!(
+            css,
+            ".{prefix}match {{ background-color: {}; }}",
+            theme.search_match_color().to_hex()
+        );
+        css
+    }
+
+    /// Writes `spans` (which index into `src`) like [Formatter::write_tokens], wrapping each run of emphasized ones,
+    /// a line at a time, in a search-match span.
+    fn write_spans(
+        &self, src: &str, spans: impl IntoIterator<Item = (Token, bool)>, theme: &Theme, position: Position,
+        out: &mut String,
+    ) {
+        // Batches of a document started with a header share its tags.
+        let cached = self.tags.take(theme);
+        let resumed = cached.is_some();
+        let mut tags = Tags::with_table(self, theme, cached.unwrap_or_else(|| TagTable::new(self, theme)));
+        self.render_spans(&mut tags, src, spans, position, out);
+        if resumed {
+            self.tags.store(theme, tags.table);
+        }
+    }
+
+    fn render_spans(
+        &self, tags: &mut Tags, src: &str, spans: impl IntoIterator<Item = (Token, bool)>, position: Position,
+        out: &mut String,
+    ) {
+        let lines = self.wraps_lines();
+        let mut in_match = false;
+        if !lines && self.text.verbatim() && self.overlays.0.is_empty() {
+            for (token, emphasized) in spans {
+                tags.toggle_match(&mut in_match, emphasized, out);
+                let text = token.text(src);
+                write_span(out, tags.token(token.kind, text), text);
+            }
+            tags.toggle_match(&mut in_match, false, out);
+            return;
+        }
+
+        let width = self.lines.gutter_width(position);
+        let mut line = position.line;
+        let mut at_line_start = !position.mid_line;
+        let mut cursor = (!self.text.verbatim()).then(|| TextCursor::new(&self.text, src, position));
+        let spans = spans.into_iter().flat_map(|(token, emphasized)| {
+            self.overlays
+                .split(token, position.offset)
+                .map(move |token| (token, emphasized))
+        });
+        for (token, emphasized) in spans {
+            let text = token.text(src);
+            let mut at = token.start;
+            let mut segments = text.split('\n').peekable();
+            while let Some(segment) = segments.next() {
+                let ends_line = segments.peek().is_some();
+                if lines && at_line_start && (ends_line || !segment.is_empty()) {
+                    tags.open_line(line, width, out);
+                    at_line_start = false;
+                }
+                if !segment.is_empty() {
+                    tags.toggle_match(&mut in_match, emphasized, out);
+                    let start = position.offset + at;
+                    tags.layer = self.overlays.layer(line, start..start + segment.len());
+                    tags.write_segment(cursor.as_mut(), token.kind, text, at, segment, out);
+                }
+                if ends_line {
+                    // Matches close at line ends so they nest inside the line wrappers.
+                    tags.toggle_match(&mut in_match, false, out);
+                    out.push_str(if lines { "\n</span>" } else { "\n" });
+                    line += 1;
+                    at_line_start = true;
+                    if let Some(cursor) = &mut cursor {
+                        cursor.new_line();
+                    }
+                }
+                at += segment.len() + 1;
+            }
+        }
+        tags.toggle_match(&mut in_match, false, out);
+    }
+
+    /// Returns `true` when every line is wrapped in a block-level span.
+    fn wraps_lines(&self) -> bool {
+        self.lines.enabled() || self.overlays.has_lines()
+    }
+
+    /// Opening tag for text of `kind` (whitespace markers for `None`) under an overlay `layer`: the merged style
+    /// inline, or in class mode the kind's classes with the layer's fields declared inline on top of them.
+    fn layered_tag(&self, kind: Option<TokenKind>, theme: &Theme, layer: Style) -> String {
+        let style = kind.map_or_else(|| theme.whitespace_style(), |kind| theme.style_for(kind));
+        let Some(prefix) = &self.class_prefix else {
+            return format!("<span style=\"{}\">", inline_css(layer.inherit(style)));
+        };
+        let prefix = escape_html(prefix);
+        let classes = match kind {
+            None => format!("{prefix}ws"),
+            Some(TokenKind::Text | TokenKind::Whitespace) => String::new(),
+            Some(kind) => kind_classes(&prefix, kind),
+        };
+        let class = if classes.is_empty() { String::new() } else { format!(" class=\"{classes}\"") };
+        format!(
+            "<span{class} style=\"{}\">",
+            css_declarations(layer, layer.inherit(style))
+        )
+    }
+
+    /// Opening tag for spans of `kind`, or `None` when its tokens render as bare text.
+    fn token_tag(&self, kind: TokenKind, theme: &Theme) -> Option<String> {
+        if matches!(kind, TokenKind::Text | TokenKind::Whitespace) {
+            return None;
+        }
+        // Hovering over input the lexer couldn't classify says so.
+        let title = if kind == TokenKind::Error { " title=\"unexpected character\"" } else { "" };
+        let tag = match &self.class_prefix {
+            Some(prefix) => format!("<span class=\"{}\"{title}>", kind_classes(&escape_html(prefix), kind)),
+            None => format!("<span style=\"{}\"{title}>", inline_css(theme.style_for(kind))),
+        };
+        Some(tag)
+    }
+}
+
+/// The tags a document needs, each rendered once rather than for every token or line.
+struct Tags<'a> {
+    formatter: &'a HtmlFormatter,
+    theme: &'a Theme,
+    table: TagTable,
+    /// Merged overlays over the segment being written, if any.
+    layer: Option<Style>,
+}
+
+/// Tags rendered so far, which [HtmlFormatter] keeps between the batches of a document.
+struct TagTable {
+    /// Opening tag per [TokenKind], indexed by discriminant and built the first time the kind comes up.
+    spans: KindCache,
+    /// Line wrappers for plain and highlighted lines, and the markup around a gutter number; set up only when lines
+    /// are wrapped.
+    lines: Option<LineTags>,
+    whitespace: Option<String>,
+    search_match: Option<String>,
+}
+
+struct LineTags {
+    open: [String; 2],
+    gutter: (String, &'static str),
+}
+
+impl TagTable {
+    fn new(formatter: &HtmlFormatter, theme: &Theme) -> Self {
+        let lines = formatter.wraps_lines().then(|| match &formatter.class_prefix {
+            Some(prefix) => {
+                let prefix = escape_html(prefix);
+                LineTags {
+                    open: [
+                        format!("<span class=\"{prefix}line\">"),
+                        format!("<span class=\"{prefix}line {prefix}hl\">"),
+                    ],
+                    gutter: (format!("<span class=\"{prefix}ln\" data-line=\""), "\"></span>"),
+                }
+            }
+            None => LineTags {
+                open: [
+                    "<span style=\"display: block;\">".to_string(),
+                    format!(
+                        "<span style=\"display: block; background-color: {};\">",
+                        theme.line_highlight_rgba().to_css()
+                    ),
+                ],
+                gutter: (
+                    format!(
+                        "<span style=\"{} user-select: none; -webkit-user-select: none;\">",
+                        inline_css(theme.gutter_style())
+                    ),
+                    " </span>",
+                ),
+            },
+        });
+        Self { spans: KindCache::new(), lines, whitespace: None, search_match: None }
+    }
+}
+
+impl<'a> Tags<'a> {
+    fn new(formatter: &'a HtmlFormatter, theme: &'a Theme) -> Self {
+        Self::with_table(formatter, theme, TagTable::new(formatter, theme))
+    }
+
+    fn with_table(formatter: &'a HtmlFormatter, theme: &'a Theme, table: TagTable) -> Self {
+        Self { formatter, theme, table, layer: None }
+    }
+
+    /// Opening tag for a token span, or `None` when the token renders as bare text.
+    fn token(&mut self, kind: TokenKind, text: &str) -> Option<&str> {
+        if text.trim().is_empty() {
+            return None;
+        }
+        let Self { formatter, theme, .. } = *self;
+        self.table
+            .spans
+            .get_or_insert_with(kind, || formatter.token_tag(kind, theme))
+    }
+
+    /// Opening tag for whitespace markers.
+    fn whitespace(&mut self) -> &str {
+        let Self { formatter, theme, .. } = *self;
+        self.table
+            .whitespace
+            .get_or_insert_with(|| match &formatter.class_prefix {
+                Some(prefix) => format!("<span class=\"{}ws\">", escape_html(prefix)),
+                None => format!("<span style=\"{}\">", inline_css(theme.whitespace_style())),
+            })
+    }
+
+    /// Opens or closes a search-match span so that one is open exactly when `emphasized` is set.
+    fn toggle_match(&mut self, in_match: &mut bool, emphasized: bool, out: &mut String) {
+        if emphasized == *in_match {
+            return;
+        }
+        if emphasized {
+            let Self { formatter, theme, .. } = *self;
+            out.push_str(
+                self.table
+                    .search_match
+                    .get_or_insert_with(|| match &formatter.class_prefix {
+                        Some(prefix) => format!("<span class=\"{}match\">", escape_html(prefix)),
+                        None => format!(
+                            "<span style=\"background-color: {};\">",
+                            theme.search_match_color().to_hex()
+                        ),
+                    }),
+            );
+        } else {
+            out.push_str("</span>");
+        }
+        *in_match = emphasized;
+    }
+
+    /// Writes a segment of a token's `text`, applying the formatter's [TextOptions] through `cursor` when there is
+    /// one, under [Tags::layer] when overlays cover it.
+    fn write_segment(
+        &mut self, cursor: Option<&mut TextCursor>, kind: TokenKind, text: &str, at: usize, segment: &str,
+        out: &mut String,
+    ) {
+        // Layers vary along a line, so their tags are built for each segment rather than cached.
+        let Self { formatter, theme, layer, .. } = *self;
+        let layered = layer.map(|layer| {
+            (
+                formatter.layered_tag(Some(kind), theme, layer),
+                formatter.layered_tag(None, theme, layer),
+            )
+        });
+        let Some(cursor) = cursor else {
+            match &layered {
+                Some((tag, _)) => write_span(out, Some(tag), segment),
+                None => write_span(out, self.token(kind, text), segment),
+            }
+            return;
+        };
+        // Consecutive text pieces share one span; markers close it.
+        let mut open = false;
+        cursor.pieces(at, segment.len(), |piece| match piece {
+            Piece::Text(piece) => {
+                let tag = match &layered {
+                    Some((tag, _)) => Some(tag.as_str()),
+                    None => self.token(kind, text),
+                };
+                if !open && let Some(tag) = tag {
+                    out.push_str(tag);
+                    open = true;
+                }
+                push_escaped(out, piece);
+            }
+            Piece::Marker(marker) => {
+                if open {
+                    out.push_str("</span>");
+                    open = false;
+                }
+                let tag = match &layered {
+                    Some((_, tag)) => tag.as_str(),
+                    None => self.whitespace(),
+                };
+                write_span(out, Some(tag), marker);
+            }
+        });
+        if open {
+            out.push_str("</span>");
+        }
+    }
+
+    /// Opens the wrapper for line `line`, including its gutter.
+    fn open_line(&self, line: usize, width: usize, out: &mut String) {
+        let options = &self.formatter.lines;
+        let tags = self
+            .table
+            .lines
+            .as_ref()
+            .expect("line tags are set up when lines are wrapped");
+        let highlighted = options.is_highlighted(line);
+        match self.formatter.overlays.line_background(line) {
+            // Line overlays paint over the line highlight.
+            Some(background) => {
+                let _ = match &self.formatter.class_prefix {
+                    Some(prefix) => {
+                        let prefix = escape_html(prefix);
+                        let hl = if highlighted { format!(" {prefix}hl") } else { String::new() };
+                        write!(
+                            out,
+                            "<span class=\"{prefix}line{hl}\" style=\"background-color: {};\">",
+                            background.to_hex()
+                        )
+                    }
+                    None => write!(
+                        out,
+                        "<span style=\"display: block; background-color: {};\">",
+                        background.to_hex()
+                    ),
+                };
+            }
+            None => out.push_str(&tags.open[usize::from(highlighted)]),
+        }
+        if options.numbers {
+            let (open, close) = &tags.gutter;
+            out.push_str(open);
+            let _ = write!(out, "{:>width$}", options.number(line));
+            out.push_str(close);
+        }
+    }
+}
+
+impl CallFormatter for HtmlFormatter {
+    fn set_line_numbers(&mut self, enabled: bool) {
+        self.lines.numbers = enabled;
+    }
+
+    fn extend_overlays(&mut self, overlays: &[Overlay]) {
+        self.overlays.0.extend_from_slice(overlays);
+    }
+}
+
+impl Formatter for HtmlFormatter {
+    fn write_header(&self, theme: &Theme, out: &mut String) {
+        self.tags.store(theme, TagTable::new(self, theme));
+        match &self.class_prefix {
+            Some(prefix) => {
+                let _ = write!(out, "<pre class=\"{}pre\"><code>", escape_html(prefix));
+            }
+            None => {
+                let _ = write!(
+                    out,
+                    "<pre style=\"background-color: {}; color: {};\"><code>",
+                    theme.background.to_hex(),
+                    theme.foreground.to_hex()
+                );
+            }
+        }
+    }
+
+    fn write_tokens(&self, src: &str, tokens: &[Token], theme: &Theme, position: Position, out: &mut String) {
+        self.write_spans(src, tokens.iter().map(|&token| (token, false)), theme, position, out);
+    }
+
+    fn write_emphasized(
+        &self, src: &str, tokens: &[Token], emphasis: &[Range<usize>], theme: &Theme, position: Position,
+        out: &mut String,
+    ) {
+        self.write_spans(src, emphasis_spans(tokens, emphasis), theme, position, out);
+    }
+
+    fn write_footer(&self, theme: &Theme, position: Position, out: &mut String) {
+        self.tags.take(theme);
+        if self.wraps_lines() && position.mid_line {
+            out.push_str("</span>");
+        }
+        out.push_str("</code></pre>");
+    }
+
+    fn write_diff(&self, rows: &[DiffRow], mode: DiffMode, theme: &Theme, out: &mut String) {
+        // Token spans come from a formatter without line wrappers; the diff markup wraps lines itself.
+        let spans = Self { class_prefix: self.class_prefix.clone(), ..Self::default() };
+        let mut tags = Tags::new(&spans, theme);
+        let markup = DiffMarkup::new(self, theme, diffview::number_width(rows));
+
+        match mode {
+            DiffMode::Inline => {
+                self.write_header(theme, out);
+                for row in rows {
+                    match row {
+                        DiffRow::Lines { old, new } => {
+                            let line = new
+                                .as_ref()
+                                .or(old.as_ref())
+                                .expect("diff rows have a line on some side");
+                            out.push_str(&markup.line[change_index(line.change)]);
+                            markup.write_line(&mut tags, line, out);
+                        }
+                        DiffRow::Collapsed { lines } => {
+                            out.push_str(&markup.note_line);
+                            push_escaped(out, &DiffRow::collapsed_label(*lines));
+                        }
+                        DiffRow::NoNewline { .. } => {
+                            out.push_str(&markup.note_line);
+                            push_escaped(out, NO_NEWLINE);
+                        }
+                    }
+                    out.push_str("\n</span>");
+                }
+                out.push_str("</code></pre>");
+            }
+            DiffMode::SideBySide => {
+                out.push_str(&markup.table);
+                for row in rows {
+                    out.push_str("<tr>");
+                    match row {
+                        DiffRow::Lines { old, new } => {
+                            for line in [old, new] {
+                                match line {
+                                    Some(line) => {
+                                        out.push_str(&markup.cell[change_index(line.change)]);
+                                        markup.write_line(&mut tags, line, out);
+                                    }
+                                    None => out.push_str(&markup.cell[0]),
+                                }
+                                out.push_str("</td>");
+                            }
+                        }
+                        DiffRow::Collapsed { lines } => {
+                            out.push_str(&markup.collapsed_cell);
+                            push_escaped(out, &DiffRow::collapsed_label(*lines));
+                            out.push_str("</td>");
+                        }
+                        DiffRow::NoNewline { old, new } => {
+                            for noted in [old, new] {
+                                out.push_str(&markup.note_cell);
+                                if *noted {
+                                    push_escaped(out, NO_NEWLINE);
+                                }
+                                out.push_str("</td>");
+                            }
+                        }
+                    }
+                    out.push_str("</tr>\n");
+                }
+                out.push_str("</table>");
+            }
+        }
+    }
+
+    fn fingerprint(&self) -> Option<u64> {
+        Some(debug_fingerprint(self))
+    }
+}
+
+/// Index of a [LineChange] into the per-change wrappers of [DiffMarkup].
+fn change_index(change: LineChange) -> usize {
+    match change {
+        LineChange::Unchanged => 0,
+        LineChange::Added => 1,
+        LineChange::Removed => 2,
+    }
+}
+
+/// The wrappers [HtmlFormatter::write_diff] puts around lines, cells, and changed words, rendered once per diff.
+struct DiffMarkup<'a> {
+    formatter: &'a HtmlFormatter,
+    /// Opening tags of inline lines and of side-by-side cells, for unchanged, added, and removed lines.
+    line: [String; 3],
+    cell: [String; 3],
+    changed: String,
+    note_line: String,
+    note_cell: String,
+    collapsed_cell: String,
+    table: String,
+    gutter: (String, &'static str),
+    width: usize,
+}
+
+impl<'a> DiffMarkup<'a> {
+    fn new(formatter: &'a HtmlFormatter, theme: &Theme, width: usize) -> Self {
+        match &formatter.class_prefix {
+            Some(prefix) => {
+                let prefix = escape_html(prefix);
+                Self {
+                    formatter,
+                    line: [
+                        format!("<span class=\"{prefix}line\">"),
+                        format!("<span class=\"{prefix}line {prefix}diff-added\">"),
+                        format!("<span class=\"{prefix}line {prefix}diff-removed\">"),
+                    ],
+                    cell: [
+                        "<td>".to_string(),
+                        format!("<td class=\"{prefix}diff-added\">"),
+                        format!("<td class=\"{prefix}diff-removed\">"),
+                    ],
+                    changed: format!("<span class=\"{prefix}diff-changed\">"),
+                    note_line: format!("<span class=\"{prefix}line {prefix}diff-note\">"),
+                    note_cell: format!("<td class=\"{prefix}diff-note\">"),
+                    collapsed_cell: format!("<td class=\"{prefix}diff-note\" colspan=\"2\">"),
+                    table: format!("<table class=\"{prefix}pre {prefix}diff\">\n"),
+                    gutter: (format!("<span class=\"{prefix}ln\" data-line=\""), "\"></span>"),
+                    width,
+                }
+            }
+            None => {
+                let backgrounds =
+                    [None, Some(theme.diff_added_color()), Some(theme.diff_removed_color())].map(|color| {
+                        color.map_or(String::new(), |color| format!(" background-color: {};", color.to_hex()))
+                    });
+                let cell = "white-space: pre; vertical-align: top;";
+                let gutter = inline_css(theme.gutter_style());
+                Self {
+                    formatter,
+                    line: backgrounds
+                        .clone()
+                        .map(|background| format!("<span style=\"display: block;{background}\">")),
+                    cell: backgrounds.map(|background| format!("<td style=\"{cell}{background}\">")),
+                    changed: format!(
+                        "<span style=\"background-color: {};\">",
+                        theme.diff_changed_color().to_hex()
+                    ),
+                    note_line: format!("<span style=\"display: block; {gutter}\">"),
+                    note_cell: format!("<td style=\"{cell} {gutter}\">"),
+                    collapsed_cell: format!("<td style=\"{cell} {gutter}\" colspan=\"2\">"),
+                    table: format!(
+                        "<table style=\"background-color: {}; color: {}; border-collapse: collapse; font-family: monospace;\">\n",
+                        theme.background.to_hex(),
+                        theme.foreground.to_hex()
+                    ),
+                    gutter: (
+                        format!("<span style=\"{gutter} user-select: none; -webkit-user-select: none;\">"),
+                        " </span>",
+                    ),
+                    width,
+                }
+            }
+        }
+    }
+
+    /// Writes a line's number (when enabled) and its tokens, wrapping the changed words.
+    fn write_line(&self, tags: &mut Tags, line: &DiffLine, out: &mut String) {
+        if self.formatter.lines.numbers {
+            let (open, close) = &self.gutter;
+            let width = self.width;
+            out.push_str(open);
+            let _ = write!(out, "{:>width$}", line.number);
+            out.push_str(close);
+        }
+        let mut in_changed = false;
+        for (kind, text, emphasized) in line.spans() {
+            if emphasized != in_changed {
+                out.push_str(if emphasized { &self.changed } else { "</span>" });
+                in_changed = emphasized;
+            }
+            write_span(out, tags.token(kind, text), text);
+        }
+        if in_changed {
+            out.push_str("</span>");
+        }
+    }
+}
+
+/// Classes of `kind` and its ancestors, outermost first (e.g., `kw kd`), so the stylesheet's cascade supplies what a
+/// kind leaves unset.
+fn kind_classes(prefix: &str, kind: TokenKind) -> String {
+    let mut classes = vec![kind.class()];
+    let mut ancestor = kind.parent();
+    while let Some(kind) = ancestor {
+        classes.push(kind.class());
+        ancestor = kind.parent();
+    }
+    classes
+        .iter()
+        .rev()
+        .map(|class| format!("{prefix}{class}"))
+        .collect::<Vec<_>>()
+        .join(" ")
+}
+
+/// Declarations for a resolved style, leaving out attributes that are off since nothing needs overriding.
+fn inline_css(style: Style) -> String {
+    let visible = Style {
+        bold: style.bold.filter(|&on| on),
+        italic: style.italic.filter(|&on| on),
+        underline: style.underline.filter(|&on| on),
+        strikethrough: style.strikethrough.filter(|&on| on),
+        ..style
+    };
+    css_declarations(visible, style)
+}
+
+/// Writes escaped `text`, wrapped in `tag` and a closing `</span>` when there is one.
+fn write_span(out: &mut String, tag: Option<&str>, text: &str) {
+    match tag {
+        Some(tag) => {
+            out.push_str(tag);
+            push_escaped(out, text);
+            out.push_str("</span>");
+        }
+        None => push_escaped(out, text),
+    }
+}
+
+/// Escapes text for use in HTML element content and double- or single-quoted attribute values.
+pub(crate) fn escape_html(text: &str) -> String {
+    let mut escaped = String::with_capacity(text.len());
+    push_escaped(&mut escaped, text);
+    escaped
+}
+
+/// Appends `text` to `out` escaped like [escape_html], copying runs without special characters in one piece.
+pub(crate) fn push_escaped(out: &mut String, text: &str) {
+    let mut rest = text;
+    while let Some(at) = rest.find(['&', '<', '>', '"', '\'']) {
+        out.push_str(&rest[..at]);
+        out.push_str(match rest.as_bytes()[at] {
+            b'&' => "&amp;",
+            b'<' => "&lt;",
+            b'>' => "&gt;",
+            b'"' => "&quot;",
+            _ => "&#39;",
+        });
+        rest = &rest[at + 1..];
+    }
+    out.push_str(rest);
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::{Srgb8, Srgba8};
+
+    fn theme() -> Theme {
+        let mut theme = Theme::new("Test", Srgb8::new(0x10, 0x10, 0x10), Srgb8::new(0xee, 0xee, 0xee));
+        theme.set(TokenKind::Keyword, Srgb8::new(0xff, 0x00, 0x80));
+        theme.set(TokenKind::String, Srgb8::new(0x00, 0xff, 0x00));
+        theme
+    }
+
+    fn render(formatter: &HtmlFormatter, src: &str, tokens: &[Token]) -> String {
+        let mut out = String::new();
+        formatter.format(src, tokens, &theme(), &mut out);
+        out
+    }
+
+    #[test]
+    fn overlays_declare_their_fields_over_token_classes() {
+        let src = "if x\ny";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::Whitespace, 2, 3),
+            Token::new(TokenKind::Text, 3, 4),
+            Token::new(TokenKind::Whitespace, 4, 5),
+            Token::new(TokenKind::Text, 5, 6),
+        ];
+        let overlays = [
+            Overlay::bytes(
+                1..4,
+                Style::new().with_bold(true).with_background(Srgb8::new(0, 0, 0x80)),
+            ),
+            Overlay::lines(1..2, Style::new().with_background(Srgb8::new(0, 0x40, 0))),
+        ];
+        let html = render(
+            &HtmlFormatter::new().with_classes("").with_overlays(overlays),
+            src,
+            &tokens,
+        );
+        let layered = "style=\"background-color: #000080; font-weight: bold;\"";
+        assert_eq!(
+            html,
+            format!(
+                "<pre class=\"pre\"><code><span class=\"line\">\
+                 <span class=\"kw\">i</span><span class=\"kw\" {layered}>f</span><span {layered}> </span>\
+                 <span {layered}>x</span>\n</span><span class=\"line\" style=\"background-color: #004000;\">\
+                 <span style=\"background-color: #004000;\">y</span></span></code></pre>"
+            )
+        );
+
+        let inline = render(&HtmlFormatter::new().with_overlays(overlays), src, &tokens);
+        assert!(
+            inline.contains("<span style=\"color: #ff0080; background-color: #000080; font-weight: bold;\">f</span>"),
+            "{inline}"
+        );
+    }
+
+    #[test]
+    fn class_mode_prefixes_token_classes() {
+        let src = "if x";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::Whitespace, 2, 3),
+            Token::new(TokenKind::Text, 3, 4),
+        ];
+        let html = render(&HtmlFormatter::new().with_classes("clz-"), src, &tokens);
+        assert_eq!(
+            html,
+            "<pre class=\"clz-pre\"><code><span class=\"clz-kw\">if</span> x</code></pre>"
+        );
+    }
+
+    #[test]
+    fn custom_kinds_carry_their_ancestors_classes() {
+        let unit = TokenKind::register("HtmlUnit", TokenKind::NumberDate).unwrap();
+        let tokens = [Token::new(unit, 0, 2)];
+        let html = render(&HtmlFormatter::new().with_classes("clz-"), "5s", &tokens);
+        assert_eq!(
+            html,
+            "<pre class=\"clz-pre\"><code><span class=\"clz-nu clz-nd clz-xhtmlunit\">5s</span></code></pre>"
+        );
+    }
+
+    #[test]
+    fn inline_mode_uses_theme_colors() {
+        let src = "if";
+        let tokens = [Token::new(TokenKind::Keyword, 0, 2)];
+        let html = render(&HtmlFormatter::new(), src, &tokens);
+        assert_eq!(
+            html,
+            "<pre style=\"background-color: #101010; color: #eeeeee;\"><code><span style=\"color: #ff0080;\">if</span></code></pre>"
+        );
+    }
+
+    #[test]
+    fn token_text_is_escaped() {
+        let src = "\"<a & 'b'>\"";
+        let tokens = [Token::new(TokenKind::String, 0, src.len())];
+        let html = render(&HtmlFormatter::new().with_classes(""), src, &tokens);
+        assert!(html.contains("<span class=\"st\">&quot;&lt;a &amp; &#39;b&#39;&gt;&quot;</span>"));
+    }
+
+    #[test]
+    fn error_tokens_explain_themselves_on_hover() {
+        let src = "a@";
+        let tokens = [Token::new(TokenKind::Name, 0, 1), Token::new(TokenKind::Error, 1, 2)];
+        let classes = render(&HtmlFormatter::new().with_classes(""), src, &tokens);
+        assert!(classes.contains("<span class=\"er\" title=\"unexpected character\">@</span>"));
+        let inline = render(&HtmlFormatter::new(), src, &tokens);
+        assert!(inline.contains(
+            "<span style=\"color: #f44747; text-decoration: underline;\" title=\"unexpected character\">@</span>"
+        ));
+    }
+
+    #[test]
+    fn whitespace_is_preserved_without_spans() {
+        let src = "if\n\t\tx";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::String, 2, 5),
+            Token::new(TokenKind::String, 5, 6),
+        ];
+        let html = render(&HtmlFormatter::new().with_classes("c-"), src, &tokens);
+        assert_eq!(
+            html,
+            "<pre class=\"c-pre\"><code><span class=\"c-kw\">if</span>\n\t\t<span class=\"c-st\">x</span></code></pre>"
+        );
+    }
+
+    #[test]
+    fn styles_map_to_font_properties() {
+        let mut theme = theme();
+        theme.set_style(TokenKind::Keyword, Style::new().with_bold(true).with_underline(true));
+        theme.set_style(
+            TokenKind::KeywordDeclaration,
+            Style::new().with_bold(false).with_strikethrough(true),
+        );
+        let src = "if let";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::KeywordDeclaration, 3, 6),
+        ];
+
+        let mut inline = String::new();
+        HtmlFormatter::new().write_tokens(src, &tokens, &theme, Position::new(), &mut inline);
+        assert!(inline.contains("<span style=\"color: #eeeeee; font-weight: bold; text-decoration: underline;\">if"));
+        assert!(inline.contains("<span style=\"color: #eeeeee; text-decoration: underline line-through;\">let"));
+
+        let mut classes = String::new();
+        HtmlFormatter::new()
+            .with_classes("c-")
+            .write_tokens(src, &tokens, &theme, Position::new(), &mut classes);
+        assert!(classes.contains("<span class=\"c-kw c-kd\">let</span>"));
+    }
+
+    #[test]
+    fn class_mode_numbers_lines_with_data_attributes() {
+        let src = "x\ny";
+        let tokens = [Token::new(TokenKind::Text, 0, src.len())];
+        let formatter = HtmlFormatter::new()
+            .with_classes("c-")
+            .with_line_numbers(true)
+            .with_line_number_start(9)
+            .with_highlight_lines([10..=10]);
+        assert_eq!(
+            render(&formatter, src, &tokens),
+            "<pre class=\"c-pre\"><code>\
+             <span class=\"c-line\"><span class=\"c-ln\" data-line=\" 9\"></span>x\n</span>\
+             <span class=\"c-line c-hl\"><span class=\"c-ln\" data-line=\"10\"></span>y</span>\
+             </code></pre>"
+        );
+
+        let css = formatter.css(&theme());
+        assert!(css.starts_with(&theme().css("c-")));
+        assert!(css.contains(".c-line { display: block; }\n"));
+        assert!(css.contains(".c-hl { background-color: #313131; }\n"));
+        assert!(css.contains(".c-ln::before { content: attr(data-line) \" \"; color: #7f7f7f; }\n"));
+        assert_eq!(HtmlFormatter::new().css(&theme()), "");
+    }
+
+    #[test]
+    fn translucent_line_highlights_stay_translucent_in_css() {
+        let mut theme = theme();
+        theme.line_highlight = Some(Srgba8::new(255, 255, 255, 0x1a));
+        let formatter = HtmlFormatter::new().with_highlight_lines([1..=1]);
+        let mut html = String::new();
+        formatter.format("x\n", &[Token::new(TokenKind::Text, 0, 2)], &theme, &mut html);
+        assert!(html.contains("background-color: rgba(255, 255, 255, 0.102);"), "{html}");
+
+        let css = formatter.with_classes("c-").css(&theme);
+        assert!(css.contains(".c-hl { background-color: rgba(255, 255, 255, 0.102); }\n"));
+    }
+
+    #[test]
+    fn inline_mode_splits_multi_line_tokens_per_line() {
+        let src = "/* a\nb */\n";
+        let tokens = [
+            Token::new(TokenKind::String, 0, src.len() - 1),
+            Token::new(TokenKind::Text, 9, 10),
+        ];
+        let html = render(&HtmlFormatter::new().with_highlight_lines([2..=2]), src, &tokens);
+        assert_eq!(
+            html,
+            "<pre style=\"background-color: #101010; color: #eeeeee;\"><code>\
+             <span style=\"display: block;\"><span style=\"color: #00ff00;\">/* a</span>\n</span>\
+             <span style=\"display: block; background-color: #313131;\"><span style=\"color: #00ff00;\">b */</span>\n</span>\
+             </code></pre>"
+        );
+    }
+
+    #[test]
+    fn inline_gutter_is_not_selectable() {
+        let html = render(
+            &HtmlFormatter::new().with_line_numbers(true),
+            "a",
+            &[Token::new(TokenKind::Text, 0, 1)],
+        );
+        assert!(html.contains(
+            "<span style=\"color: #7f7f7f; user-select: none; -webkit-user-select: none;\">1 </span>a</span>"
+        ));
+    }
+
+    #[test]
+    fn whitespace_markers_get_their_own_spans() {
+        let src = "if\tx \r\n";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::Text, 2, src.len()),
+        ];
+        let formatter = HtmlFormatter::new()
+            .with_classes("c-")
+            .with_tab_width(4)
+            .with_normalize_crlf(true)
+            .with_show_whitespace(ShowWhitespace::Trailing);
+        assert_eq!(
+            render(&formatter, src, &tokens),
+            "<pre class=\"c-pre\"><code><span class=\"c-kw\">if</span>  x<span class=\"c-ws\">·</span>\n</code></pre>"
+        );
+
+        let inline = HtmlFormatter::new()
+            .with_line_numbers(true)
+            .with_show_whitespace(ShowWhitespace::All);
+        let html = render(&inline, "a\tb", &[Token::new(TokenKind::Text, 0, 3)]);
+        assert!(html.contains("1 </span>a<span style=\"color: #"), "{html}");
+        assert!(html.contains(";\">→</span>      b</span>"), "{html}");
+    }
+}
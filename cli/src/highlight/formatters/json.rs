@@ -0,0 +1,386 @@
+//! JSON formatter emitting the token stream itself, for tools that want structure rather than rendered text.
+
+use super::{Formatter, Position};
+use crate::highlight::cache::debug_fingerprint;
+use crate::highlight::{ColumnUnit, HighlightError, Theme, Token, TokenKind};
+
+use serde::{Deserialize, Serialize};
+
+use std::ops::Range;
+
+/// One token as written by [JsonFormatter].
+#[derive(Serialize)]
+struct Record<'a> {
+    #[serde(rename = "type")]
+    kind: &'static str,
+    start: usize,
+    end: usize,
+    line: usize,
+    col: usize,
+    value: &'a str,
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    lossy: bool,
+}
+
+/// The fields of a [Record] that [parse_token_json] needs; the rest are ignored.
+#[derive(Deserialize)]
+struct ParsedRecord {
+    #[serde(rename = "type")]
+    kind: String,
+    start: usize,
+    end: usize,
+}
+
+/// Writes tokens as JSON objects such as `{"type":"Keyword","start":120,"end":124,"line":7,"col":2,"value":"func"}`,
+/// one per line (newline-delimited JSON) or, with [JsonFormatter::with_pretty], as the elements of an array.
+///
+/// `type` is the stable [TokenKind::name]. `start` and `end` are byte offsets into the document, and `line` and `col`
+/// are 1-based, with columns counted in characters unless [JsonFormatter::with_columns] picks another unit, so
+/// consumers can use whichever coordinates they need. Values are escaped by serde_json, so control characters come
+/// out as valid JSON escapes. The theme is ignored.
+///
+/// Token text is always valid UTF-8 since sources are `&str`. When the source was decoded with
+/// [String::from_utf8_lossy], [JsonFormatter::with_lossy_source] flags the tokens containing replacement characters
+/// with `"lossy":true`.
+///
+/// [parse_token_json] reads either form back into tokens.
+#[derive(Debug, Clone, Default)]
+pub struct JsonFormatter {
+    pretty: bool,
+    lossy: bool,
+    columns: ColumnUnit,
+}
+
+impl JsonFormatter {
+    /// Creates a formatter writing newline-delimited JSON.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Writes a JSON array with one token per line instead.
+    pub fn with_pretty(mut self, pretty: bool) -> Self {
+        self.pretty = pretty;
+        self
+    }
+
+    /// Counts `col` in `unit` instead of characters: [ColumnUnit::Utf16] gives the columns JavaScript and language
+    /// servers use, and [ColumnUnit::Bytes] those of editors that count bytes.
+    ///
+    /// Columns are worked out as the tokens are written, continuing across streamed batches, so a consumer needn't
+    /// build a [crate::highlight::Positions] index for them.
+    pub fn with_columns(mut self, unit: ColumnUnit) -> Self {
+        self.columns = unit;
+        self
+    }
+
+    /// Marks tokens containing U+REPLACEMENT CHARACTER as `"lossy":true`, for sources that were decoded lossily.
+    pub fn with_lossy_source(mut self, lossy: bool) -> Self {
+        self.lossy = lossy;
+        self
+    }
+}
+
+impl Formatter for JsonFormatter {
+    fn write_header(&self, _theme: &Theme, out: &mut String) {
+        if self.pretty {
+            out.push('[');
+        }
+    }
+
+    fn write_tokens(&self, src: &str, tokens: &[Token], _theme: &Theme, position: Position, out: &mut String) {
+        let mut line = position.line;
+        let mut column = position.column_in(self.columns);
+        for token in tokens {
+            let value = token.text(src);
+            let record = Record {
+                kind: token.kind.name(),
+                start: position.offset + token.start,
+                end: position.offset + token.end,
+                line: line + 1,
+                col: column + 1,
+                value,
+                lossy: self.lossy && value.contains(char::REPLACEMENT_CHARACTER),
+            };
+            let json = serde_json::to_string(&record).expect("token records always serialize");
+            if self.pretty {
+                // Tokens cover the document from its first byte, so only the first one starts at zero.
+                out.push_str(if record.start == 0 { "\n  " } else { ",\n  " });
+                out.push_str(&json);
+            } else {
+                out.push_str(&json);
+                out.push('\n');
+            }
+
+            match value.rfind('\n') {
+                Some(newline) => {
+                    line += value.matches('\n').count();
+                    column = self.columns.measure(&value[newline + 1..]);
+                }
+                None => column += self.columns.measure(value),
+            }
+        }
+    }
+
+    /// Writes the tokens whole: the caller already knows where its matches are, and splitting tokens at them would
+    /// misreport the token stream.
+    fn write_emphasized(
+        &self, src: &str, tokens: &[Token], _emphasis: &[Range<usize>], theme: &Theme, position: Position,
+        out: &mut String,
+    ) {
+        self.write_tokens(src, tokens, theme, position, out);
+    }
+
+    fn write_footer(&self, _theme: &Theme, position: Position, out: &mut String) {
+        if self.pretty {
+            out.push_str(if position.offset == 0 { "]\n" } else { "\n]\n" });
+        }
+    }
+
+    fn fingerprint(&self) -> Option<u64> {
+        Some(debug_fingerprint(self))
+    }
+}
+
+/// Reads tokens written by [JsonFormatter], in either form, so they can be rendered by other formatters.
+///
+/// Only `type`, `start`, and `end` are used; the offsets are checked for order but not against any source.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::formatters::{JsonFormatter, parse_token_json};
+/// use colorizer::highlight::{Lexer, Theme, Formatter, lexers::Shell};
+///
+/// let src = "echo hi # greet\n";
+/// let tokens = Shell.tokenize(src).unwrap();
+/// let theme = Theme::new("Plain", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+/// let mut json = String::new();
+/// JsonFormatter::new().format(src, &tokens, &theme, &mut json);
+/// assert_eq!(parse_token_json(&json).unwrap(), tokens);
+/// ```
+pub fn parse_token_json(input: &str) -> Result<Vec<Token>, HighlightError> {
+    let records: Vec<ParsedRecord> = if input.trim_start().starts_with('[') {
+        serde_json::from_str(input).map_err(|err| HighlightError::Parse(err.to_string()))?
+    } else {
+        input
+            .lines()
+            .enumerate()
+            .filter(|(_, line)| !line.trim().is_empty())
+            .map(|(index, line)| {
+                serde_json::from_str(line).map_err(|err| HighlightError::Parse(format!("line {}: {err}", index + 1)))
+            })
+            .collect::<Result<_, _>>()?
+    };
+
+    records
+        .into_iter()
+        .map(|record| {
+            let kind = TokenKind::from_name(&record.kind)
+                .ok_or_else(|| HighlightError::Parse(format!("unknown token type {:?}", record.kind)))?;
+            if record.start > record.end {
+                return Err(HighlightError::Parse(format!(
+                    "token ends before it starts ({}..{})",
+                    record.start, record.end
+                )));
+            }
+            Ok(Token::new(kind, record.start, record.end))
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+
+    fn theme() -> Theme {
+        Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255))
+    }
+
+    fn render(formatter: &JsonFormatter, src: &str, tokens: &[Token]) -> String {
+        let mut out = String::new();
+        formatter.format(src, tokens, &theme(), &mut out);
+        out
+    }
+
+    fn sample() -> (&'static str, Vec<Token>) {
+        let src = "é = \"a\tb\u{1}\"\nfunc";
+        let tokens = vec![
+            Token::new(TokenKind::Name, 0, 2),
+            Token::new(TokenKind::Whitespace, 2, 3),
+            Token::new(TokenKind::Operator, 3, 4),
+            Token::new(TokenKind::Whitespace, 4, 5),
+            Token::new(TokenKind::String, 5, 11),
+            Token::new(TokenKind::Whitespace, 11, 12),
+            Token::new(TokenKind::KeywordDeclaration, 12, 16),
+        ];
+        (src, tokens)
+    }
+
+    #[test]
+    fn tokens_are_written_one_object_per_line() {
+        let (src, tokens) = sample();
+        assert_eq!(
+            render(&JsonFormatter::new(), src, &tokens),
+            concat!(
+                r#"{"type":"Name","start":0,"end":2,"line":1,"col":1,"value":"é"}"#,
+                "\n",
+                r#"{"type":"Whitespace","start":2,"end":3,"line":1,"col":2,"value":" "}"#,
+                "\n",
+                r#"{"type":"Operator","start":3,"end":4,"line":1,"col":3,"value":"="}"#,
+                "\n",
+                r#"{"type":"Whitespace","start":4,"end":5,"line":1,"col":4,"value":" "}"#,
+                "\n",
+                r#"{"type":"String","start":5,"end":11,"line":1,"col":5,"value":"\"a\tb\u0001\""}"#,
+                "\n",
+                r#"{"type":"Whitespace","start":11,"end":12,"line":1,"col":11,"value":"\n"}"#,
+                "\n",
+                r#"{"type":"KeywordDeclaration","start":12,"end":16,"line":2,"col":1,"value":"func"}"#,
+                "\n",
+            )
+        );
+    }
+
+    #[test]
+    fn pretty_mode_writes_an_array() {
+        let src = "a b";
+        let tokens = [
+            Token::new(TokenKind::Name, 0, 1),
+            Token::new(TokenKind::Whitespace, 1, 2),
+            Token::new(TokenKind::Name, 2, 3),
+        ];
+        let json = render(&JsonFormatter::new().with_pretty(true), src, &tokens);
+        assert_eq!(
+            json,
+            concat!(
+                "[\n",
+                r#"  {"type":"Name","start":0,"end":1,"line":1,"col":1,"value":"a"},"#,
+                "\n",
+                r#"  {"type":"Whitespace","start":1,"end":2,"line":1,"col":2,"value":" "},"#,
+                "\n",
+                r#"  {"type":"Name","start":2,"end":3,"line":1,"col":3,"value":"b"}"#,
+                "\n]\n",
+            )
+        );
+        assert_eq!(parse_token_json(&json).unwrap(), tokens);
+        assert_eq!(render(&JsonFormatter::new().with_pretty(true), "", &[]), "[]\n");
+    }
+
+    #[test]
+    fn streamed_batches_use_document_coordinates() {
+        let theme = theme();
+        let formatter = JsonFormatter::new();
+        let mut out = String::new();
+        let mut position = Position::new();
+        for chunk in ["ab\n", "cd", "ef\n"] {
+            formatter.write_tokens(
+                chunk,
+                &[Token::new(TokenKind::Text, 0, chunk.len())],
+                &theme,
+                position,
+                &mut out,
+            );
+            position.advance(chunk);
+        }
+        let lines: Vec<&str> = out.lines().collect();
+        assert_eq!(
+            lines[1],
+            r#"{"type":"Text","start":3,"end":5,"line":2,"col":1,"value":"cd"}"#
+        );
+        assert_eq!(
+            lines[2],
+            r#"{"type":"Text","start":5,"end":8,"line":2,"col":3,"value":"ef\n"}"#
+        );
+    }
+
+    #[test]
+    fn columns_count_the_chosen_unit() {
+        let src = "😀 = é\nb";
+        let tokens: Vec<Token> = [0, 4, 5, 6, 7, 9, 10, 11]
+            .windows(2)
+            .map(|pair| Token::new(TokenKind::Text, pair[0], pair[1]))
+            .collect();
+        let columns = |unit: ColumnUnit| -> Vec<usize> {
+            let json = render(&JsonFormatter::new().with_columns(unit), src, &tokens);
+            json.lines()
+                .map(|line| {
+                    serde_json::from_str::<serde_json::Value>(line).unwrap()["col"]
+                        .as_u64()
+                        .unwrap() as usize
+                })
+                .collect()
+        };
+        assert_eq!(columns(ColumnUnit::Chars), [1, 2, 3, 4, 5, 6, 1]);
+        assert_eq!(columns(ColumnUnit::Utf16), [1, 3, 4, 5, 6, 7, 1]);
+        assert_eq!(columns(ColumnUnit::Bytes), [1, 5, 6, 7, 8, 10, 1]);
+
+        // The same columns as a line index gives, also for a batch resuming mid-line.
+        let positions = crate::highlight::Positions::new(src);
+        let utf16: Vec<usize> = tokens
+            .iter()
+            .map(|token| positions.line_col(token.start, ColumnUnit::Utf16).1 + 1)
+            .collect();
+        assert_eq!(columns(ColumnUnit::Utf16), utf16);
+        let mut position = Position::new();
+        position.advance(&src[..5]);
+        let mut out = String::new();
+        let formatter = JsonFormatter::new().with_columns(ColumnUnit::Utf16);
+        formatter.write_tokens(
+            &src[5..],
+            &[Token::new(TokenKind::Text, 0, 1)],
+            &theme(),
+            position,
+            &mut out,
+        );
+        assert!(out.contains(r#""start":5,"end":6,"line":1,"col":4,"#), "{out}");
+    }
+
+    #[test]
+    fn lossy_sources_flag_replacement_characters() {
+        let src = String::from_utf8_lossy(b"ok \xff").into_owned();
+        let tokens = [
+            Token::new(TokenKind::Text, 0, 3),
+            Token::new(TokenKind::Error, 3, src.len()),
+        ];
+        let json = render(&JsonFormatter::new().with_lossy_source(true), &src, &tokens);
+        let lines: Vec<&str> = json.lines().collect();
+        assert!(!lines[0].contains("lossy"), "{json}");
+        assert_eq!(
+            lines[1],
+            r#"{"type":"Error","start":3,"end":6,"line":1,"col":4,"value":"�","lossy":true}"#
+        );
+        assert!(!render(&JsonFormatter::new(), &src, &tokens).contains("lossy"));
+    }
+
+    #[test]
+    fn parsing_round_trips_and_reports_bad_records() {
+        let (src, tokens) = sample();
+        assert_eq!(
+            parse_token_json(&render(&JsonFormatter::new(), src, &tokens)).unwrap(),
+            tokens
+        );
+
+        let err = parse_token_json("{\"type\":\"Text\",\"start\":0,\"end\":1}\n\nnot json\n").unwrap_err();
+        assert!(err.to_string().starts_with("failed to parse tokens: line 3:"), "{err}");
+        let err = parse_token_json(r#"{"type":"Keywrd","start":0,"end":1}"#).unwrap_err();
+        assert_eq!(err.to_string(), "failed to parse tokens: unknown token type \"Keywrd\"");
+        let err = parse_token_json(r#"[{"type":"Text","start":4,"end":1}]"#).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "failed to parse tokens: token ends before it starts (4..1)"
+        );
+    }
+
+    #[test]
+    fn custom_kinds_are_written_by_name() {
+        let cell = TokenKind::register("JsonCellReference", TokenKind::NameVariable).unwrap();
+        let tokens = [Token::new(cell, 0, 2)];
+        let json = render(&JsonFormatter::new(), "A1", &tokens);
+        assert_eq!(
+            json,
+            "{\"type\":\"JsonCellReference\",\"start\":0,\"end\":2,\"line\":1,\"col\":1,\"value\":\"A1\"}\n"
+        );
+        assert_eq!(parse_token_json(&json).unwrap(), tokens);
+    }
+}
@@ -0,0 +1,281 @@
+//! LaTeX formatter emitting a fancyvrb `Verbatim` environment.
+
+use super::{Formatter, KindCache, LineOptions, Position};
+use crate::colors::Srgb8;
+use crate::highlight::cache::debug_fingerprint;
+use crate::highlight::{Theme, Token, TokenKind};
+
+use std::fmt::Write;
+
+/// LaTeX specials and the name of the command that prints each one (`\clzZbs{}` for a backslash).
+const SPECIALS: [(char, &str); 10] = [
+    ('\\', "bs"),
+    ('{', "ob"),
+    ('}', "cb"),
+    ('%', "pc"),
+    ('#', "sh"),
+    ('_', "us"),
+    ('&', "am"),
+    ('$', "dl"),
+    ('^', "ca"),
+    ('~', "ti"),
+];
+
+/// Renders tokens as a fancyvrb `Verbatim` environment, the way Pygments' LaTeX output is used with fancyvrb and
+/// minted.
+///
+/// The environment makes `\`, `{`, and `}` command characters, so every token is wrapped in
+/// `\textcolor{clz-kw}{...}` (plus `\bfseries`/`\itshape` when the theme asks for them) and the specials
+/// `\ { } % # _ & $ ^ ~` in token text are written as commands. [LatexFormatter::preamble] defines the colors and
+/// escape commands; with it in the preamble the document only needs `\usepackage{xcolor,fancyvrb}`. Underline,
+/// strikethrough, and backgrounds aren't rendered.
+///
+/// Line numbers are left to fancyvrb's `numbers=left` option, so they are never part of the listing's text.
+#[derive(Debug, Clone)]
+pub struct LatexFormatter {
+    prefix: String,
+    lines: LineOptions,
+}
+
+impl LatexFormatter {
+    /// Creates a formatter whose colors and commands are named with the prefix `clz`.
+    pub fn new() -> Self {
+        Self { prefix: "clz".to_string(), lines: LineOptions::default() }
+    }
+
+    /// Names colors `{prefix}-{kind}` and commands `\{prefix}Z..`; since TeX command names are made of letters, other
+    /// characters are dropped from the prefix.
+    pub fn with_prefix(mut self, prefix: &str) -> Self {
+        self.prefix = prefix.chars().filter(char::is_ascii_alphabetic).collect();
+        self
+    }
+
+    /// Numbers lines through fancyvrb's `numbers=left`.
+    pub fn with_line_numbers(mut self, enabled: bool) -> Self {
+        self.lines.numbers = enabled;
+        self
+    }
+
+    /// Number of the first line (default 1), passed on as `firstnumber`.
+    pub fn with_line_number_start(mut self, start: usize) -> Self {
+        self.lines.start = start;
+        self
+    }
+
+    /// Renders the preamble definitions: one `\definecolor` per token kind from the theme, and the commands that print
+    /// LaTeX specials inside the environment.
+    pub fn preamble(&self, theme: &Theme) -> String {
+        let prefix = &self.prefix;
+        let mut out = String::from("% Highlighting colors and escapes; requires \\usepackage{xcolor,fancyvrb}.\n");
+        for kind in TokenKind::ALL {
+            let color = theme.style_for(kind).foreground.unwrap_or(theme.foreground);
+            let _ = writeln!(
+                out,
+                "\\definecolor{{{prefix}-{}}}{{HTML}}{{{}}}",
+                kind.class(),
+                html_hex(color)
+            );
+        }
+        for (ch, name) in SPECIALS {
+            let _ = writeln!(out, "\\def\\{prefix}Z{name}{{\\char`\\{ch}}}");
+        }
+        out
+    }
+
+    /// Opening of a token's command group, or `None` when the token renders as bare text.
+    fn token_tag(&self, kind: TokenKind, theme: &Theme) -> Option<String> {
+        if matches!(kind, TokenKind::Text | TokenKind::Whitespace) {
+            return None;
+        }
+        let style = theme.style_for(kind);
+        let mut tag = format!("\\textcolor{{{}-{}}}{{", self.prefix, kind.class());
+        let bold = style.bold == Some(true);
+        let italic = style.italic == Some(true);
+        if bold {
+            tag.push_str("\\bfseries");
+        }
+        if italic {
+            tag.push_str("\\itshape");
+        }
+        // An empty group ends the command name without a space, which Verbatim would print.
+        if bold || italic {
+            tag.push_str("{}");
+        }
+        Some(tag)
+    }
+
+    /// Appends `text` with LaTeX specials replaced by their commands.
+    fn push_escaped(&self, out: &mut String, text: &str) {
+        for ch in text.chars() {
+            match SPECIALS.iter().find(|&&(special, _)| special == ch) {
+                Some((_, name)) => {
+                    let _ = write!(out, "\\{}Z{name}{{}}", self.prefix);
+                }
+                None => out.push(ch),
+            }
+        }
+    }
+}
+
+impl Default for LatexFormatter {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Formatter for LatexFormatter {
+    fn write_header(&self, _theme: &Theme, out: &mut String) {
+        let _ = write!(
+            out,
+            "\\begin{{Verbatim}}[commandchars=\\\\\\{{\\}},formatcom=\\color{{{}-{}}}",
+            self.prefix,
+            TokenKind::Text.class()
+        );
+        if self.lines.numbers {
+            let _ = write!(out, ",numbers=left,firstnumber={}", self.lines.start);
+        }
+        out.push_str("]\n");
+    }
+
+    fn write_tokens(&self, src: &str, tokens: &[Token], theme: &Theme, _position: Position, out: &mut String) {
+        let mut tags = KindCache::new();
+        for token in tokens {
+            let text = token.text(src);
+            let tag = if text.trim().is_empty() {
+                None
+            } else {
+                tags.get_or_insert_with(token.kind, || self.token_tag(token.kind, theme))
+            };
+
+            // fancyvrb reads the listing line by line, so groups can't stay open across a newline.
+            let mut segments = text.split('\n').peekable();
+            while let Some(segment) = segments.next() {
+                if !segment.is_empty() {
+                    match tag {
+                        Some(tag) => {
+                            out.push_str(tag);
+                            self.push_escaped(out, segment);
+                            out.push('}');
+                        }
+                        None => self.push_escaped(out, segment),
+                    }
+                }
+                if segments.peek().is_some() {
+                    out.push('\n');
+                }
+            }
+        }
+    }
+
+    fn write_footer(&self, _theme: &Theme, position: Position, out: &mut String) {
+        // `\end{Verbatim}` has to start a line of its own.
+        if position.mid_line {
+            out.push('\n');
+        }
+        out.push_str("\\end{Verbatim}\n");
+    }
+
+    fn fingerprint(&self) -> Option<u64> {
+        Some(debug_fingerprint(self))
+    }
+}
+
+/// `RRGGBB` as xcolor's `HTML` model expects it.
+fn html_hex(color: Srgb8) -> String {
+    color.to_hex().trim_start_matches('#').to_uppercase()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::Style;
+
+    fn theme() -> Theme {
+        let mut theme = Theme::new("Test", Srgb8::new(0x10, 0x10, 0x10), Srgb8::new(0xee, 0xee, 0xee));
+        theme.set_style(
+            TokenKind::Keyword,
+            Style::new()
+                .with_foreground(Srgb8::new(0xff, 0x00, 0x80))
+                .with_bold(true),
+        );
+        theme.set(TokenKind::String, Srgb8::new(0x00, 0xaa, 0x00));
+        theme.set_style(
+            TokenKind::Comment,
+            Style::new()
+                .with_foreground(Srgb8::new(0x80, 0x80, 0x80))
+                .with_italic(true),
+        );
+        theme
+    }
+
+    fn render(formatter: &LatexFormatter, src: &str, tokens: &[Token]) -> String {
+        let mut out = String::new();
+        formatter.format(src, tokens, &theme(), &mut out);
+        out
+    }
+
+    #[test]
+    fn every_special_is_escaped_inside_token_commands() {
+        let src = "if \"\\{}%#_&$^~\" # x\n";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::Whitespace, 2, 3),
+            Token::new(TokenKind::String, 3, 15),
+            Token::new(TokenKind::Whitespace, 15, 16),
+            Token::new(TokenKind::Comment, 16, 19),
+            Token::new(TokenKind::Whitespace, 19, 20),
+        ];
+        assert_eq!(
+            render(&LatexFormatter::new(), src, &tokens),
+            "\\begin{Verbatim}[commandchars=\\\\\\{\\},formatcom=\\color{clz-tx}]\n\
+             \\textcolor{clz-kw}{\\bfseries{}if} \
+             \\textcolor{clz-st}{\"\\clzZbs{}\\clzZob{}\\clzZcb{}\\clzZpc{}\\clzZsh{}\\clzZus{}\\clzZam{}\\clzZdl{}\
+             \\clzZca{}\\clzZti{}\"} \
+             \\textcolor{clz-cm}{\\itshape{}\\clzZsh{} x}\n\
+             \\end{Verbatim}\n"
+        );
+    }
+
+    #[test]
+    fn groups_close_at_line_ends() {
+        let src = "/* a\nb */ x";
+        let tokens = [Token::new(TokenKind::Comment, 0, 9), Token::new(TokenKind::Text, 9, 11)];
+        let latex = render(&LatexFormatter::new().with_prefix("my-tex"), src, &tokens);
+        assert_eq!(
+            latex,
+            "\\begin{Verbatim}[commandchars=\\\\\\{\\},formatcom=\\color{mytex-tx}]\n\
+             \\textcolor{mytex-cm}{\\itshape{}/* a}\n\
+             \\textcolor{mytex-cm}{\\itshape{}b */} x\n\
+             \\end{Verbatim}\n"
+        );
+    }
+
+    #[test]
+    fn line_numbers_become_verbatim_options() {
+        let src = "x\n";
+        let tokens = [Token::new(TokenKind::Text, 0, 2)];
+        let latex = render(
+            &LatexFormatter::new().with_line_numbers(true).with_line_number_start(40),
+            src,
+            &tokens,
+        );
+        assert_eq!(
+            latex,
+            "\\begin{Verbatim}[commandchars=\\\\\\{\\},formatcom=\\color{clz-tx},numbers=left,firstnumber=40]\n\
+             x\n\
+             \\end{Verbatim}\n"
+        );
+    }
+
+    #[test]
+    fn preamble_defines_colors_and_escapes() {
+        let preamble = LatexFormatter::new().preamble(&theme());
+        assert!(preamble.starts_with("% Highlighting colors and escapes; requires \\usepackage{xcolor,fancyvrb}.\n"));
+        assert!(preamble.contains("\\definecolor{clz-tx}{HTML}{EEEEEE}\n"), "{preamble}");
+        assert!(preamble.contains("\\definecolor{clz-kw}{HTML}{FF0080}\n"), "{preamble}");
+        assert!(preamble.contains("\\definecolor{clz-cm}{HTML}{808080}\n"), "{preamble}");
+        assert!(preamble.contains("\\def\\clzZbs{\\char`\\\\}\n"), "{preamble}");
+        assert!(preamble.ends_with("\\def\\clzZti{\\char`\\~}\n"), "{preamble}");
+        assert_eq!(preamble.lines().count(), 1 + TokenKind::ALL.len() + SPECIALS.len());
+    }
+}
@@ -0,0 +1,708 @@
+//! Formatters that render token streams with a theme.
+
+use super::diffview::{self, DiffMode, DiffRow};
+use super::{ColumnUnit, Style, Theme, Token, TokenKind};
+use crate::colors::Srgb8;
+
+use std::fmt;
+use std::ops::{Range, RangeInclusive};
+use std::sync::Mutex;
+
+mod ansi;
+mod html;
+mod json;
+mod latex;
+mod svg;
+
+pub use ansi::{AnsiFormatter, Overflow};
+pub(crate) use ansi::{RESET, sgr_escape};
+pub use html::HtmlFormatter;
+pub use json::{JsonFormatter, parse_token_json};
+pub use latex::LatexFormatter;
+pub use svg::SvgFormatter;
+
+/// Renders tokens produced by a [super::lexers::Lexer] into a concrete output format.
+///
+/// Output is produced in three parts so it can be streamed: a header, any number of token batches, and a footer.
+/// Each batch is told where it starts in the document so line-oriented decorations (gutters, highlighted lines) stay
+/// correct across batches.
+///
+/// Formatters append to a caller-owned `String`, so rendering many documents can reuse one buffer (clear it between
+/// uses). The built-in formatters work out each token kind's escape or tag once per document, in the first batch that
+/// needs it, and reuse it in later batches; the allocations they make don't grow with the number of tokens or batches.
+/// The header, batches, and footer of one document must be given the same theme, unchanged in between.
+pub trait Formatter {
+    /// Appends anything that precedes the first token (e.g., an opening `<pre>`).
+    fn write_header(&self, _theme: &Theme, _out: &mut String) {}
+
+    /// Appends the rendering of `tokens` (which index into `src`) to `out`; `src` starts at `position`.
+    fn write_tokens(&self, src: &str, tokens: &[Token], theme: &Theme, position: Position, out: &mut String);
+
+    /// Appends anything that follows the last token; `position` is the end of the document.
+    fn write_footer(&self, _theme: &Theme, _position: Position, _out: &mut String) {}
+
+    /// Returns a hash of the formatter's output format and every option it was set up with, for keying cached
+    /// output (see [super::cache_key]), or `None` if the formatter can't tell, which turns caching off for it.
+    ///
+    /// The bundled formatters all return one; two formatters with the same fingerprint must render every document
+    /// the same way.
+    fn fingerprint(&self) -> Option<u64> {
+        None
+    }
+
+    /// Appends a complete rendering of `tokens` to `out`.
+    fn format(&self, src: &str, tokens: &[Token], theme: &Theme, out: &mut String) {
+        let start = Position::new().with_total_lines(src.lines().count());
+        let mut end = start;
+        end.advance(src);
+
+        self.write_header(theme, out);
+        self.write_tokens(src, tokens, theme, start, out);
+        self.write_footer(theme, end, out);
+    }
+
+    /// Appends a complete rendering of a rich diff (see [super::highlight_diff]), header and footer included.
+    ///
+    /// The default writes each row as a line of its own through [Formatter::write_tokens], starting with a `+`, `-`,
+    /// or blank sign, and paints the diff backgrounds by handing it copies of `theme` that set them behind every
+    /// token; side-by-side rows pad the left column with spaces and a `│` rule. Formatters that can paint whole lines
+    /// or lay out columns themselves override it.
+    fn write_diff(&self, rows: &[DiffRow], mode: DiffMode, theme: &Theme, out: &mut String) {
+        diffview::write_rows(self, rows, mode, theme, out);
+    }
+
+    /// Appends `tokens` like [Formatter::write_tokens], with the bytes in `emphasis` (sorted, disjoint ranges of
+    /// `src`) painted on [Theme::search_match_color] behind their token colors, as search snippets show matches
+    /// (see [super::extract_snippets]).
+    ///
+    /// The default splits the tokens at the edges of the emphasis and writes the emphasized runs as batches of their
+    /// own, with a copy of `theme` that sets the match background behind every token. Formatters that can wrap runs
+    /// of text themselves override it.
+    fn write_emphasized(
+        &self, src: &str, tokens: &[Token], emphasis: &[Range<usize>], theme: &Theme, position: Position,
+        out: &mut String,
+    ) {
+        if emphasis.is_empty() {
+            self.write_tokens(src, tokens, theme, position, out);
+            return;
+        }
+        let matched = theme.over_background(theme.search_match_color());
+        let spans = emphasis_spans(tokens, emphasis);
+        let mut at = position;
+        for run in spans.chunk_by(|a, b| a.1 == b.1) {
+            let batch: Vec<Token> = run.iter().map(|&(token, _)| token).collect();
+            let (start, end) = (batch[0].start, batch[batch.len() - 1].end);
+            // Tokens keep their offsets into `src`, so the batch position keeps the offset of `src` too.
+            self.write_tokens(src, &batch, if run[0].1 { &matched } else { theme }, at, out);
+            at.advance(&src[start..end]);
+            at.offset = position.offset;
+        }
+    }
+}
+
+/// Splits `tokens` at the edges of `emphasis` (sorted, disjoint ranges), pairing each piece with whether it is
+/// emphasized.
+pub(crate) fn emphasis_spans(tokens: &[Token], emphasis: &[Range<usize>]) -> Vec<(Token, bool)> {
+    let mut spans = Vec::with_capacity(tokens.len() + 2 * emphasis.len());
+    let mut marks = emphasis.iter().peekable();
+    for token in tokens {
+        let mut at = token.start;
+        while at < token.end {
+            while marks.next_if(|mark| mark.end <= at).is_some() {}
+            let (end, emphasized) = match marks.peek() {
+                Some(mark) if mark.start <= at => (mark.end.min(token.end), true),
+                Some(mark) => (mark.start.min(token.end), false),
+                None => (token.end, false),
+            };
+            spans.push((Token::new(token.kind, at, end), emphasized));
+            at = end;
+        }
+    }
+    spans
+}
+
+/// Location of a token batch within the document being formatted.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub struct Position {
+    /// Zero-based index of the line the batch starts on.
+    pub line: usize,
+    /// `true` when the batch continues a line begun by an earlier batch (an overlong line split while streaming).
+    pub mid_line: bool,
+    /// Byte offset of the batch in the document, which token offsets are relative to when streaming.
+    pub offset: usize,
+    /// Zero-based column, in characters, the batch starts at; nonzero only when [Position::mid_line] is set.
+    pub column: usize,
+    /// [Position::column] in bytes.
+    pub column_bytes: usize,
+    /// [Position::column] in UTF-16 code units.
+    pub column_utf16: usize,
+    /// Number of lines in the document, when known up front; used to size line-number gutters.
+    pub total_lines: Option<usize>,
+}
+
+impl Position {
+    /// Returns the start of a document of unknown length.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn with_total_lines(mut self, total: usize) -> Self {
+        self.total_lines = Some(total);
+        self
+    }
+
+    /// Moves past `text`.
+    pub fn advance(&mut self, text: &str) {
+        let newlines = text.bytes().filter(|&byte| byte == b'\n').count();
+        self.line += newlines;
+        self.offset += text.len();
+        let tail = match text.rfind('\n') {
+            Some(newline) => {
+                (self.column, self.column_bytes, self.column_utf16) = (0, 0, 0);
+                &text[newline + 1..]
+            }
+            None => text,
+        };
+        self.column += ColumnUnit::Chars.measure(tail);
+        self.column_bytes += tail.len();
+        self.column_utf16 += ColumnUnit::Utf16.measure(tail);
+        if !text.is_empty() {
+            self.mid_line = !text.ends_with('\n');
+        }
+    }
+
+    /// Returns the column the batch starts at, counted in `unit`.
+    pub fn column_in(&self, unit: ColumnUnit) -> usize {
+        match unit {
+            ColumnUnit::Bytes => self.column_bytes,
+            ColumnUnit::Chars => self.column,
+            ColumnUnit::Utf16 => self.column_utf16,
+        }
+    }
+}
+
+/// Gutter width used when the document length is unknown, enough for files under a million lines.
+const STREAMING_GUTTER_WIDTH: usize = 6;
+
+/// Line-number and line-highlight options shared by the bundled formatters.
+#[derive(Debug, Clone)]
+pub(crate) struct LineOptions {
+    pub(crate) numbers: bool,
+    pub(crate) start: usize,
+    /// Ranges of displayed line numbers (so they account for `start`).
+    pub(crate) highlights: Vec<RangeInclusive<usize>>,
+}
+
+impl Default for LineOptions {
+    fn default() -> Self {
+        Self { numbers: false, start: 1, highlights: Vec::new() }
+    }
+}
+
+impl LineOptions {
+    /// Returns `true` when lines need per-line decoration at all.
+    pub(crate) fn enabled(&self) -> bool {
+        self.numbers || !self.highlights.is_empty()
+    }
+
+    /// Displayed number of the zero-based line `index`.
+    pub(crate) fn number(&self, index: usize) -> usize {
+        self.start + index
+    }
+
+    pub(crate) fn is_highlighted(&self, index: usize) -> bool {
+        let number = self.number(index);
+        self.highlights.iter().any(|range| range.contains(&number))
+    }
+
+    /// Digits needed for the largest line number, so gutters stay right-aligned throughout the document.
+    pub(crate) fn gutter_width(&self, position: Position) -> usize {
+        match position.total_lines {
+            Some(total) => self.number(total.saturating_sub(1)).to_string().len(),
+            None => STREAMING_GUTTER_WIDTH,
+        }
+    }
+}
+
+/// A style layered over part of the document when it is formatted; see [AnsiFormatter::with_overlays] and
+/// [HtmlFormatter::with_overlays].
+///
+/// The fields the overlay's style sets replace those of each token it covers, and the rest keep the token's own, so
+/// an overlay that only sets a background paints it behind the token colors. Tokens are split where an overlay starts
+/// or ends. Where overlays overlap, they apply in order, so a later one's fields win.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Overlay {
+    /// Start of the covered range: a byte offset into the document, or a zero-based line index when
+    /// [Overlay::line] is set.
+    pub start: usize,
+    /// End of the covered range, exclusive.
+    pub end: usize,
+    pub style: Style,
+    /// Covers whole lines, painting the overlay's background to the end of each line rather than just behind its
+    /// text.
+    pub line: bool,
+}
+
+impl Overlay {
+    /// Layers `style` over the bytes `range` of the document.
+    pub fn bytes(range: Range<usize>, style: Style) -> Self {
+        Self { start: range.start, end: range.end, style, line: false }
+    }
+
+    /// Layers `style` over the zero-based lines `range` of the document, whole.
+    pub fn lines(range: Range<usize>, style: Style) -> Self {
+        Self { start: range.start, end: range.end, style, line: true }
+    }
+}
+
+/// The overlays a bundled formatter applies, in the order they were given.
+#[derive(Debug, Clone, Default)]
+pub(crate) struct Overlays(pub(crate) Vec<Overlay>);
+
+impl Overlays {
+    /// Returns `true` when some overlay covers whole lines, which then need painting to their ends.
+    pub(crate) fn has_lines(&self) -> bool {
+        self.0.iter().any(|overlay| overlay.line)
+    }
+
+    /// Splits `token`, whose offsets are relative to document offset `offset`, where byte overlays start or end.
+    pub(crate) fn split(&self, token: Token, offset: usize) -> impl Iterator<Item = Token> + '_ {
+        let mut at = token.start;
+        std::iter::from_fn(move || {
+            if at >= token.end {
+                return None;
+            }
+            let end = self
+                .0
+                .iter()
+                .filter(|overlay| !overlay.line)
+                .flat_map(|overlay| [overlay.start, overlay.end])
+                .filter_map(|edge| edge.checked_sub(offset))
+                .filter(|&edge| edge > at && edge < token.end)
+                .min()
+                .unwrap_or(token.end);
+            let piece = Token::new(token.kind, at, end);
+            at = end;
+            Some(piece)
+        })
+    }
+
+    /// Merges every overlay covering the bytes `range` (document offsets) of zero-based line `line`, or returns
+    /// `None` when none does.
+    pub(crate) fn layer(&self, line: usize, range: Range<usize>) -> Option<Style> {
+        self.0
+            .iter()
+            .filter(|overlay| match overlay.line {
+                true => (overlay.start..overlay.end).contains(&line),
+                false => overlay.start <= range.start && range.end <= overlay.end && !range.is_empty(),
+            })
+            .fold(None, |layer, overlay| {
+                Some(overlay.style.inherit(layer.unwrap_or_default()))
+            })
+    }
+
+    /// Background of the line overlays on zero-based line `line`, which fills the line past its text.
+    pub(crate) fn line_background(&self, line: usize) -> Option<Srgb8> {
+        self.0
+            .iter()
+            .rev()
+            .filter(|overlay| overlay.line && (overlay.start..overlay.end).contains(&line))
+            .find_map(|overlay| overlay.style.background)
+    }
+}
+
+/// Which whitespace the formatters draw as markers: `·` for a space and `→` for a tab, styled with
+/// [Theme::whitespace_style].
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum ShowWhitespace {
+    /// Whitespace is written as is.
+    #[default]
+    Off,
+    /// Spaces and tabs at the end of a line, the kind editors strip on save.
+    Trailing,
+    /// Every space and tab.
+    All,
+}
+
+/// Per-kind cache of rendered tags (or `None` for kinds that render as bare text), with room for every built-in kind
+/// up front and custom kinds added as they turn up.
+pub(crate) struct KindCache(Vec<Option<Option<String>>>);
+
+impl KindCache {
+    pub(crate) fn new() -> Self {
+        Self(vec![None; TokenKind::ALL.len()])
+    }
+
+    pub(crate) fn get_or_insert_with(&mut self, kind: TokenKind, tag: impl FnOnce() -> Option<String>) -> Option<&str> {
+        let slot = kind.slot();
+        if slot >= self.0.len() {
+            self.0.resize(slot + 1, None);
+        }
+        self.0[slot].get_or_insert_with(tag).as_deref()
+    }
+}
+
+/// What a formatter works out for one theme, kept from [Formatter::write_header] to [Formatter::write_footer] so
+/// every batch of a document reuses it.
+///
+/// The header stores a value for its theme and the footer drops it. Each batch takes it out while it renders and puts
+/// it back after, so batches rendered at the same time on other threads build their own instead of waiting. The
+/// theme is recognized by its address, which stays put while the document borrows it. Clones start empty, and all
+/// caches print alike so they don't affect [Formatter::fingerprint].
+pub(crate) struct ThemeCache<T>(Mutex<Option<(usize, T)>>);
+
+impl<T> ThemeCache<T> {
+    pub(crate) const fn new() -> Self {
+        Self(Mutex::new(None))
+    }
+
+    pub(crate) fn store(&self, theme: &Theme, value: T) {
+        *self.lock() = Some((address(theme), value));
+    }
+
+    /// Empties the cache, returning what it held if that was stored for `theme`.
+    pub(crate) fn take(&self, theme: &Theme) -> Option<T> {
+        self.lock()
+            .take()
+            .and_then(|(stored, value)| (stored == address(theme)).then_some(value))
+    }
+
+    fn lock(&self) -> std::sync::MutexGuard<'_, Option<(usize, T)>> {
+        self.0.lock().unwrap_or_else(|err| err.into_inner())
+    }
+}
+
+fn address(theme: &Theme) -> usize {
+    std::ptr::from_ref(theme) as usize
+}
+
+impl<T> Default for ThemeCache<T> {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl<T> Clone for ThemeCache<T> {
+    fn clone(&self) -> Self {
+        Self::new()
+    }
+}
+
+impl<T> fmt::Debug for ThemeCache<T> {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.debug_struct("ThemeCache").finish_non_exhaustive()
+    }
+}
+
+/// Tab stops used for tab markers when no tab width is set, the default of terminals and browsers.
+const DEFAULT_TAB_WIDTH: usize = 8;
+
+/// Blanks that expanded tabs are sliced from.
+const SPACES: &str = "                                ";
+
+/// Tab, line-ending, and whitespace options shared by the bundled formatters.
+#[derive(Debug, Clone, Default)]
+pub(crate) struct TextOptions {
+    /// Column interval of the stops tabs are expanded to, or `None` to keep tabs.
+    pub(crate) tab_width: Option<usize>,
+    /// Drops the carriage return of `\r\n` line endings.
+    pub(crate) normalize_crlf: bool,
+    pub(crate) whitespace: ShowWhitespace,
+}
+
+impl TextOptions {
+    /// Returns `true` when text is written exactly as lexed.
+    pub(crate) fn verbatim(&self) -> bool {
+        self.tab_width.is_none() && !self.normalize_crlf && self.whitespace == ShowWhitespace::Off
+    }
+}
+
+/// A run of text to write: as it comes, or a whitespace marker to style with [Theme::whitespace_style].
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum Piece<'a> {
+    Text(&'a str),
+    Marker(&'static str),
+}
+
+/// Applies [TextOptions] to the segments of one token batch, tracking the column tabs expand from and where the
+/// current line's trailing whitespace starts. Columns count characters from the start of the line's text (not the
+/// gutter), so tab stops line up however wide the gutter is.
+pub(crate) struct TextCursor<'a> {
+    options: &'a TextOptions,
+    src: &'a str,
+    column: usize,
+    /// End of the current line in `src` and the offset its trailing whitespace starts at.
+    line: Option<(usize, usize)>,
+}
+
+impl<'a> TextCursor<'a> {
+    /// Starts at `position`; a batch continuing a line picks up at its column, which counts a tab written by an
+    /// earlier batch as one column.
+    pub(crate) fn new(options: &'a TextOptions, src: &'a str, position: Position) -> Self {
+        Self { options, src, column: if position.mid_line { position.column } else { 0 }, line: None }
+    }
+
+    /// Splits `src[at..at + len]`, a part of a line without its newline, into the pieces to write.
+    pub(crate) fn pieces(&mut self, at: usize, len: usize, mut write: impl FnMut(Piece<'a>)) {
+        let src = self.src;
+        let mut end = at + len;
+        if self.options.normalize_crlf && src[..end].ends_with('\r') && src[end..].starts_with('\n') {
+            end -= 1;
+        }
+        let trailing = match self.line {
+            Some((line_end, trailing)) if at <= line_end => trailing,
+            _ => {
+                let line_end = src[at..].find('\n').map_or(src.len(), |newline| at + newline);
+                let trailing = at + src[at..line_end].trim_end_matches([' ', '\t', '\r']).len();
+                self.line = Some((line_end, trailing));
+                trailing
+            }
+        };
+        let options = self.options;
+        let marked = |offset: usize| match options.whitespace {
+            ShowWhitespace::Off => false,
+            ShowWhitespace::Trailing => offset >= trailing,
+            ShowWhitespace::All => true,
+        };
+
+        let mut run = at;
+        for (offset, ch) in src[at..end].char_indices() {
+            let offset = at + offset;
+            match ch {
+                '\t' => {
+                    let width = options.tab_width.unwrap_or(DEFAULT_TAB_WIDTH).max(1);
+                    let stop = (self.column / width + 1) * width;
+                    let marker = marked(offset);
+                    if marker || options.tab_width.is_some() {
+                        if run < offset {
+                            write(Piece::Text(&src[run..offset]));
+                        }
+                        run = offset + 1;
+                        let mut blanks = stop - self.column;
+                        if marker {
+                            write(Piece::Marker("→"));
+                            blanks -= 1;
+                        }
+                        while blanks > 0 {
+                            let chunk = blanks.min(SPACES.len());
+                            write(Piece::Text(&SPACES[..chunk]));
+                            blanks -= chunk;
+                        }
+                    }
+                    self.column = stop;
+                }
+                ' ' if marked(offset) => {
+                    if run < offset {
+                        write(Piece::Text(&src[run..offset]));
+                    }
+                    run = offset + 1;
+                    write(Piece::Marker("·"));
+                    self.column += 1;
+                }
+                _ => self.column += 1,
+            }
+        }
+        if run < end {
+            write(Piece::Text(&src[run..end]));
+        }
+    }
+
+    /// Moves to the start of the next line.
+    pub(crate) fn new_line(&mut self) {
+        self.column = 0;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::TokenKind;
+    use crate::highlight::allocations::allocations;
+    use crate::terminal::ColorProfile;
+
+    /// A source of `lines` lines with a handful of tokens (of a few kinds) each.
+    fn document(lines: usize) -> (String, Vec<Token>) {
+        let mut src = String::new();
+        let mut tokens = Vec::new();
+        for _ in 0..lines {
+            for (kind, text) in [
+                (TokenKind::Keyword, "let"),
+                (TokenKind::Whitespace, " "),
+                (TokenKind::Name, "x"),
+                (TokenKind::Operator, " = "),
+                (TokenKind::String, "\"<a & b>\""),
+                (TokenKind::Punctuation, ";"),
+                (TokenKind::Comment, " // done\n"),
+            ] {
+                tokens.push(Token::new(kind, src.len(), src.len() + text.len()));
+                src.push_str(text);
+            }
+        }
+        (src, tokens)
+    }
+
+    /// Fails if formatting `lines` lines allocates more than a fixed budget, whatever the token count. Output is
+    /// written into a buffer with room to spare, so only the formatter's own allocations are counted.
+    fn assert_allocations_bounded(formatter: &dyn Formatter) {
+        let theme = Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        let (src, tokens) = document(1_000);
+        let mut out = String::with_capacity(src.len() * 64);
+        let count = allocations(|| formatter.format(&src, &tokens, &theme, &mut out));
+        assert!(
+            count <= 2 * TokenKind::ALL.len() + 16,
+            "{count} allocations for {} tokens",
+            tokens.len()
+        );
+    }
+
+    /// Fails if formatting a document a line per batch, as [crate::highlight::highlight_reader] does, allocates more
+    /// than the same fixed budget.
+    fn assert_batched_allocations_bounded(formatter: &dyn Formatter) {
+        let theme = Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        let (line, tokens) = document(1);
+        let mut out = String::with_capacity(line.len() * 64 * 1_000);
+        let count = allocations(|| {
+            let mut position = Position::new();
+            formatter.write_header(&theme, &mut out);
+            for _ in 0..1_000 {
+                formatter.write_tokens(&line, &tokens, &theme, position, &mut out);
+                position.advance(&line);
+            }
+            formatter.write_footer(&theme, position, &mut out);
+        });
+        assert!(
+            count <= 2 * TokenKind::ALL.len() + 16,
+            "{count} allocations for 1000 batches"
+        );
+    }
+
+    #[test]
+    fn batches_reuse_the_document_escapes() {
+        assert_batched_allocations_bounded(&AnsiFormatter::new().with_profile(ColorProfile::TrueColor));
+        assert_batched_allocations_bounded(
+            &AnsiFormatter::new()
+                .with_profile(ColorProfile::Ansi256)
+                .with_line_numbers(true)
+                .with_highlight_lines([2..=400]),
+        );
+        assert_batched_allocations_bounded(&HtmlFormatter::new());
+        assert_batched_allocations_bounded(&HtmlFormatter::new().with_classes("clz-").with_line_numbers(true));
+    }
+
+    #[test]
+    fn ansi_allocations_do_not_grow_with_tokens() {
+        for profile in [ColorProfile::TrueColor, ColorProfile::Ansi256, ColorProfile::NoColor] {
+            assert_allocations_bounded(&AnsiFormatter::new().with_profile(profile));
+            assert_allocations_bounded(
+                &AnsiFormatter::new()
+                    .with_profile(profile)
+                    .with_line_numbers(true)
+                    .with_highlight_lines([2..=400]),
+            );
+        }
+    }
+
+    #[test]
+    fn html_allocations_do_not_grow_with_tokens() {
+        assert_allocations_bounded(&HtmlFormatter::new());
+        assert_allocations_bounded(&HtmlFormatter::new().with_classes("clz-"));
+        assert_allocations_bounded(
+            &HtmlFormatter::new()
+                .with_classes("clz-")
+                .with_line_numbers(true)
+                .with_highlight_lines([2..=400]),
+        );
+    }
+
+    #[test]
+    fn position_tracks_lines_and_partial_batches() {
+        let mut position = Position::new();
+        position.advance("a\nb");
+        assert_eq!((position.line, position.mid_line, position.column), (1, true, 1));
+        position.advance("é");
+        assert_eq!((position.line, position.mid_line, position.column), (1, true, 2));
+        assert_eq!(
+            (
+                position.column_in(ColumnUnit::Bytes),
+                position.column_in(ColumnUnit::Utf16)
+            ),
+            (3, 2)
+        );
+        position.advance("😀");
+        assert_eq!(
+            (position.column, position.column_bytes, position.column_utf16),
+            (3, 7, 4)
+        );
+        position.advance("c\n");
+        assert_eq!((position.line, position.mid_line, position.column), (2, false, 0));
+        assert_eq!((position.column_bytes, position.column_utf16), (0, 0));
+        position.advance("");
+        assert_eq!((position.line, position.mid_line, position.column), (2, false, 0));
+        assert_eq!(position.offset, 11);
+    }
+
+    #[test]
+    fn highlights_use_displayed_numbers() {
+        let options = LineOptions { numbers: true, start: 120, highlights: vec![125..=126] };
+        assert!(!options.is_highlighted(0));
+        assert!(options.is_highlighted(5));
+        assert!(options.is_highlighted(6));
+        assert!(!options.is_highlighted(7));
+        assert_eq!(options.gutter_width(Position::new().with_total_lines(10)), 3);
+        assert_eq!(options.gutter_width(Position::new().with_total_lines(9_900)), 5);
+        assert_eq!(options.gutter_width(Position::new()), STREAMING_GUTTER_WIDTH);
+    }
+
+    fn pieces(options: &TextOptions, src: &str, column: usize) -> Vec<String> {
+        let position = Position { mid_line: column > 0, column, ..Position::new() };
+        let mut cursor = TextCursor::new(options, src, position);
+        let mut pieces = Vec::new();
+        cursor.pieces(0, src.find('\n').unwrap_or(src.len()), |piece| {
+            pieces.push(match piece {
+                Piece::Text(text) => text.to_string(),
+                Piece::Marker(marker) => format!("[{marker}]"),
+            })
+        });
+        pieces
+    }
+
+    #[test]
+    fn text_cursor_expands_tabs_from_the_batch_column() {
+        let tabs = TextOptions { tab_width: Some(4), ..TextOptions::default() };
+        assert_eq!(pieces(&tabs, "ab\tc\td", 0), ["ab", "  ", "c", "   ", "d"]);
+        // Characters, not bytes, count toward the column.
+        assert_eq!(pieces(&tabs, "éé\tx", 0).concat(), "éé  x");
+        assert_eq!(pieces(&tabs, "\tx", 3).concat(), " x");
+
+        let trailing = TextOptions { whitespace: ShowWhitespace::Trailing, ..TextOptions::default() };
+        assert_eq!(pieces(&trailing, "a b \t\r\n", 0), ["a b", "[·]", "[→]", "   ", "\r"]);
+        let normalized = TextOptions { normalize_crlf: true, ..trailing.clone() };
+        assert_eq!(pieces(&normalized, "a b \t\r\n", 0).concat(), "a b[·][→]   ");
+        let all = TextOptions { whitespace: ShowWhitespace::All, ..TextOptions::default() };
+        assert_eq!(pieces(&all, "a b", 0).concat(), "a[·]b");
+    }
+
+    #[test]
+    #[ignore = "timing; run with `cargo test --release -- --ignored`"]
+    fn formatting_throughput() {
+        let theme = Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        let (src, tokens) = document(100_000);
+        let formatters: [(&str, &dyn Formatter); 3] = [
+            ("ansi", &AnsiFormatter::new().with_profile(ColorProfile::TrueColor)),
+            ("html", &HtmlFormatter::new()),
+            ("html classes", &HtmlFormatter::new().with_classes("clz-")),
+        ];
+        for (name, formatter) in formatters {
+            let mut out = String::new();
+            let started = std::time::Instant::now();
+            let count = allocations(|| formatter.format(&src, &tokens, &theme, &mut out));
+            let elapsed = started.elapsed();
+            println!(
+                "{name}: {elapsed:?} and {count} allocations for {} tokens",
+                tokens.len()
+            );
+            assert!(elapsed.as_millis() < 500, "{name} took {elapsed:?}");
+        }
+    }
+}
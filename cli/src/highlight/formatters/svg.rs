@@ -0,0 +1,458 @@
+//! SVG formatter for embedding highlighted code as an image.
+
+use super::html::push_escaped;
+use super::{Formatter, KindCache, Position};
+use crate::highlight::cache::debug_fingerprint;
+use crate::highlight::{Style, Theme, Token, TokenKind};
+
+use std::fmt::{self, Write};
+
+/// Spacing between baselines, as a multiple of the font size.
+const LINE_HEIGHT: f32 = 1.5;
+
+/// Share of the font size most monospace fonts advance per character.
+const ADVANCE_RATIO: f32 = 0.6;
+
+/// Renders tokens as a standalone `<svg>` image: a background `<rect>` and one `<text>` element per line, with a
+/// `<tspan>` carrying `fill`, `font-weight`, `font-style`, and `text-decoration` for each styled token.
+///
+/// The image is sized from the line count and the longest line, assuming every character advances by the same width
+/// ([SvgFormatter::with_advance]). Tabs are expanded to spaces before measuring so columns line up the way they would
+/// in an editor. Characters wider than one column (e.g., CJK) make a line render longer than measured, and token
+/// backgrounds aren't drawn.
+///
+/// Only [Formatter::format] knows the whole document, so it is the one that writes `width`, `height`, and `viewBox`.
+/// When streamed (e.g., through [crate::highlight::highlight_reader]), the root element is written without a size and
+/// viewers fall back to their default; tab stops also restart in every batch of a line split across batches.
+#[derive(Debug, Clone)]
+pub struct SvgFormatter {
+    font_family: String,
+    font_size: f32,
+    advance: Option<f32>,
+    tab_width: usize,
+    transparent: bool,
+}
+
+impl SvgFormatter {
+    /// Creates a formatter with a 14px system monospace font, 4-column tabs, and the theme's background.
+    pub fn new() -> Self {
+        Self {
+            font_family: "ui-monospace, SFMono-Regular, Menlo, Consolas, monospace".to_string(),
+            font_size: 14.0,
+            advance: None,
+            tab_width: 4,
+            transparent: false,
+        }
+    }
+
+    /// Sets the CSS `font-family` list; it should name monospace fonts for the measured size to hold.
+    pub fn with_font_family(mut self, family: impl Into<String>) -> Self {
+        self.font_family = family.into();
+        self
+    }
+
+    /// Sets the font size in pixels, which also scales the padding and line spacing.
+    pub fn with_font_size(mut self, size: f32) -> Self {
+        self.font_size = size;
+        self
+    }
+
+    /// Sets the width in pixels each character advances by (default 0.6 of the font size).
+    pub fn with_advance(mut self, advance: f32) -> Self {
+        self.advance = Some(advance);
+        self
+    }
+
+    /// Sets the column interval of tab stops.
+    pub fn with_tab_width(mut self, width: usize) -> Self {
+        self.tab_width = width.max(1);
+        self
+    }
+
+    /// Leaves out the background rectangle so the image shows whatever it is placed on.
+    pub fn with_transparent_background(mut self, transparent: bool) -> Self {
+        self.transparent = transparent;
+        self
+    }
+
+    fn advance(&self) -> f32 {
+        self.advance.unwrap_or(self.font_size * ADVANCE_RATIO)
+    }
+
+    fn padding(&self) -> f32 {
+        self.font_size
+    }
+
+    fn line_height(&self) -> f32 {
+        self.font_size * LINE_HEIGHT
+    }
+
+    /// Width and height of an image showing `src`.
+    fn size(&self, src: &str) -> (f32, f32) {
+        let longest = src
+            .lines()
+            .map(|line| expanded_width(line, self.tab_width))
+            .max()
+            .unwrap_or(0);
+        let lines = src.lines().count();
+        let padding = 2.0 * self.padding();
+        (
+            padding + longest as f32 * self.advance(),
+            padding + lines as f32 * self.line_height(),
+        )
+    }
+
+    /// Writes the root element, sized when `size` is known, along with the background and the group text inherits
+    /// its font from.
+    fn write_open(&self, theme: &Theme, size: Option<(f32, f32)>, out: &mut String) {
+        out.push_str("<svg xmlns=\"http://www.w3.org/2000/svg\"");
+        if let Some((width, height)) = size {
+            let (width, height) = (Px(width), Px(height));
+            let _ = write!(
+                out,
+                " width=\"{width}\" height=\"{height}\" viewBox=\"0 0 {width} {height}\""
+            );
+        }
+        out.push_str(" xml:space=\"preserve\">");
+        if !self.transparent {
+            let _ = write!(
+                out,
+                "<rect width=\"100%\" height=\"100%\" fill=\"{}\"/>",
+                theme.background.to_hex()
+            );
+        }
+        out.push_str("<g font-family=\"");
+        push_escaped(out, &self.font_family);
+        let _ = write!(
+            out,
+            "\" font-size=\"{}\" fill=\"{}\">",
+            Px(self.font_size),
+            theme.foreground.to_hex()
+        );
+    }
+
+    /// Opens the `<text>` element for line `line` (0-based).
+    fn open_line(&self, line: usize, out: &mut String) {
+        let baseline = self.padding() + line as f32 * self.line_height() + self.font_size;
+        let _ = write!(out, "<text x=\"{}\" y=\"{}\">", Px(self.padding()), Px(baseline));
+    }
+}
+
+impl Default for SvgFormatter {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Formatter for SvgFormatter {
+    fn write_header(&self, theme: &Theme, out: &mut String) {
+        self.write_open(theme, None, out);
+    }
+
+    fn write_tokens(&self, src: &str, tokens: &[Token], theme: &Theme, position: Position, out: &mut String) {
+        let mut spans = KindCache::new();
+        let mut line = position.line;
+        let mut at_line_start = !position.mid_line;
+        let mut column = 0;
+        let mut expanded = String::new();
+
+        for token in tokens {
+            let text = token.text(src);
+            let span = if text.trim().is_empty() {
+                None
+            } else {
+                spans.get_or_insert_with(token.kind, || tspan(theme, token.kind))
+            };
+
+            let mut segments = text.split('\n').peekable();
+            while let Some(segment) = segments.next() {
+                let ends_line = segments.peek().is_some();
+                if at_line_start && !segment.is_empty() {
+                    self.open_line(line, out);
+                    at_line_start = false;
+                }
+                if !segment.is_empty() {
+                    expanded.clear();
+                    column = expand_tabs(segment, column, self.tab_width, &mut expanded);
+                    if let Some(span) = span {
+                        out.push_str(span);
+                    }
+                    push_escaped(out, &expanded);
+                    if span.is_some() {
+                        out.push_str("</tspan>");
+                    }
+                }
+                if ends_line {
+                    // Blank lines get no element; the next line's `y` leaves room for them.
+                    if !at_line_start {
+                        out.push_str("</text>\n");
+                    }
+                    line += 1;
+                    column = 0;
+                    at_line_start = true;
+                }
+            }
+        }
+    }
+
+    fn write_footer(&self, _theme: &Theme, position: Position, out: &mut String) {
+        if position.mid_line {
+            out.push_str("</text>\n");
+        }
+        out.push_str("</g></svg>\n");
+    }
+
+    fn format(&self, src: &str, tokens: &[Token], theme: &Theme, out: &mut String) {
+        let start = Position::new().with_total_lines(src.lines().count());
+        let mut end = start;
+        end.advance(src);
+
+        self.write_open(theme, Some(self.size(src)), out);
+        self.write_tokens(src, tokens, theme, start, out);
+        self.write_footer(theme, end, out);
+    }
+
+    fn fingerprint(&self) -> Option<u64> {
+        Some(debug_fingerprint(self))
+    }
+}
+
+/// Opening `<tspan>` for tokens of `kind`, or `None` when they look like plain text.
+fn tspan(theme: &Theme, kind: TokenKind) -> Option<String> {
+    let style = theme.style_for(kind);
+    let Style { foreground, bold, italic, underline, strikethrough, .. } = style;
+    let mut tag = String::from("<tspan");
+    if let Some(color) = foreground.filter(|&color| color != theme.foreground) {
+        let _ = write!(tag, " fill=\"{}\"", color.to_hex());
+    }
+    if bold == Some(true) {
+        tag.push_str(" font-weight=\"bold\"");
+    }
+    if italic == Some(true) {
+        tag.push_str(" font-style=\"italic\"");
+    }
+    match (underline == Some(true), strikethrough == Some(true)) {
+        (true, true) => tag.push_str(" text-decoration=\"underline line-through\""),
+        (true, false) => tag.push_str(" text-decoration=\"underline\""),
+        (false, true) => tag.push_str(" text-decoration=\"line-through\""),
+        (false, false) => {}
+    }
+    if tag.len() == "<tspan".len() {
+        return None;
+    }
+    tag.push('>');
+    Some(tag)
+}
+
+/// Appends `text` to `out` with tabs replaced by spaces up to the next multiple of `tab_width`, given that `text`
+/// starts at `column`. Returns the column after it.
+fn expand_tabs(text: &str, mut column: usize, tab_width: usize, out: &mut String) -> usize {
+    for ch in text.chars() {
+        if ch == '\t' {
+            let stop = (column / tab_width + 1) * tab_width;
+            out.extend(std::iter::repeat_n(' ', stop - column));
+            column = stop;
+        } else {
+            out.push(ch);
+            column += 1;
+        }
+    }
+    column
+}
+
+/// Number of columns `line` takes up once its tabs are expanded.
+fn expanded_width(line: &str, tab_width: usize) -> usize {
+    line.chars().fold(0, |column, ch| {
+        if ch == '\t' { (column / tab_width + 1) * tab_width } else { column + 1 }
+    })
+}
+
+/// A length in pixels, written with at most two decimals so float noise doesn't leak into the markup.
+struct Px(f32);
+
+impl fmt::Display for Px {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let rounded = (self.0 * 100.0).round() / 100.0;
+        write!(f, "{rounded}")
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::testing::assert_golden_text;
+
+    fn theme() -> Theme {
+        let mut theme = Theme::new("Test", Srgb8::new(0x10, 0x10, 0x10), Srgb8::new(0xee, 0xee, 0xee));
+        theme.set(TokenKind::Keyword, Srgb8::new(0xff, 0x00, 0x80));
+        theme.set_style(
+            TokenKind::Comment,
+            Style::new()
+                .with_foreground(Srgb8::new(0x80, 0x80, 0x80))
+                .with_italic(true),
+        );
+        theme
+    }
+
+    fn render(formatter: &SvgFormatter, src: &str, tokens: &[Token]) -> String {
+        let mut out = String::new();
+        formatter.format(src, tokens, &theme(), &mut out);
+        out
+    }
+
+    /// Tokens for a small Go program, kept inline so the golden file only depends on this formatter.
+    fn go_sample() -> (String, Vec<Token>) {
+        let pieces = [
+            (TokenKind::Comment, "// Greet prints a <greeting> & waves.\n"),
+            (TokenKind::KeywordDeclaration, "func"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::NameFunction, "Greet"),
+            (TokenKind::Punctuation, "("),
+            (TokenKind::Name, "name"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::KeywordType, "string"),
+            (TokenKind::Punctuation, ")"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Punctuation, "{"),
+            (TokenKind::Whitespace, "\n\t"),
+            (TokenKind::Keyword, "if"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Name, "name"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Operator, "=="),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::String, "\"\""),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Punctuation, "{"),
+            (TokenKind::Whitespace, "\n\t\t"),
+            (TokenKind::Name, "name"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Operator, "="),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::String, "\"world\""),
+            (TokenKind::Whitespace, "\n\t"),
+            (TokenKind::Punctuation, "}"),
+            (TokenKind::Whitespace, "\n\t"),
+            (TokenKind::Name, "fmt"),
+            (TokenKind::Punctuation, "."),
+            (TokenKind::NameFunction, "Printf"),
+            (TokenKind::Punctuation, "("),
+            (TokenKind::String, "\"Hello, %s!"),
+            (TokenKind::StringEscape, "\\n"),
+            (TokenKind::String, "\""),
+            (TokenKind::Punctuation, ","),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Name, "name"),
+            (TokenKind::Punctuation, ")"),
+            (TokenKind::Whitespace, "\t"),
+            (TokenKind::Comment, "// 'quoted'"),
+            (TokenKind::Whitespace, "\n"),
+            (TokenKind::Punctuation, "}"),
+            (TokenKind::Whitespace, "\n"),
+        ];
+        let mut src = String::new();
+        let mut tokens = Vec::new();
+        for (kind, text) in pieces {
+            tokens.push(Token::new(kind, src.len(), src.len() + text.len()));
+            src.push_str(text);
+        }
+        (src, tokens)
+    }
+
+    #[test]
+    fn tabs_expand_to_the_next_stop() {
+        let mut out = String::new();
+        assert_eq!(expand_tabs("\ta\tbc\t", 0, 4, &mut out), 12);
+        assert_eq!(out, "    a   bc  ");
+        out.clear();
+        assert_eq!(expand_tabs("\t", 3, 4, &mut out), 4);
+        assert_eq!(out, " ");
+        assert_eq!(expanded_width("\ta\tbc\t", 4), 12);
+        assert_eq!(expanded_width("", 4), 0);
+    }
+
+    #[test]
+    fn size_follows_the_longest_expanded_line() {
+        let formatter = SvgFormatter::new().with_font_size(10.0).with_advance(5.0);
+        // Padding 10 on each side, 15 per line, and 6 columns for "\tab" with 4-column tabs.
+        assert_eq!(formatter.size("\tab\nxyz\n"), (20.0 + 6.0 * 5.0, 20.0 + 2.0 * 15.0));
+        assert_eq!(
+            formatter.with_tab_width(8).size("\tab\n"),
+            (20.0 + 10.0 * 5.0, 20.0 + 15.0)
+        );
+        assert_eq!(SvgFormatter::new().size(""), (28.0, 28.0));
+    }
+
+    #[test]
+    fn tokens_become_styled_tspans() {
+        let src = "if <x> // &\n";
+        let tokens = [
+            Token::new(TokenKind::Keyword, 0, 2),
+            Token::new(TokenKind::Whitespace, 2, 3),
+            Token::new(TokenKind::Text, 3, 6),
+            Token::new(TokenKind::Whitespace, 6, 7),
+            Token::new(TokenKind::Comment, 7, 11),
+            Token::new(TokenKind::Whitespace, 11, 12),
+        ];
+        let svg = render(
+            &SvgFormatter::new().with_font_size(10.0).with_advance(6.0),
+            src,
+            &tokens,
+        );
+        assert_eq!(
+            svg,
+            "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"86\" height=\"35\" viewBox=\"0 0 86 35\" \
+             xml:space=\"preserve\"><rect width=\"100%\" height=\"100%\" fill=\"#101010\"/><g \
+             font-family=\"ui-monospace, SFMono-Regular, Menlo, Consolas, monospace\" font-size=\"10\" \
+             fill=\"#eeeeee\"><text x=\"10\" y=\"20\"><tspan fill=\"#ff0080\">if</tspan> &lt;x&gt; <tspan \
+             fill=\"#808080\" font-style=\"italic\">// &amp;</tspan></text>\n</g></svg>\n"
+        );
+    }
+
+    #[test]
+    fn transparent_background_drops_the_rect() {
+        let src = "x";
+        let tokens = [Token::new(TokenKind::Text, 0, 1)];
+        let svg = render(&SvgFormatter::new().with_transparent_background(true), src, &tokens);
+        assert!(!svg.contains("<rect"), "{svg}");
+        assert!(svg.contains("<text x=\"14\" y=\"28\">x</text>"), "{svg}");
+    }
+
+    #[test]
+    fn streamed_batches_continue_lines_without_a_size() {
+        let theme = theme();
+        let formatter = SvgFormatter::new().with_transparent_background(true);
+        let mut out = String::new();
+        let mut position = Position::new();
+        formatter.write_header(&theme, &mut out);
+        for chunk in ["ab", "c\n", "\n", "d"] {
+            formatter.write_tokens(
+                chunk,
+                &[Token::new(TokenKind::Text, 0, chunk.len())],
+                &theme,
+                position,
+                &mut out,
+            );
+            position.advance(chunk);
+        }
+        formatter.write_footer(&theme, position, &mut out);
+        assert!(
+            out.starts_with("<svg xmlns=\"http://www.w3.org/2000/svg\" xml:space=\"preserve\"><g "),
+            "{out}"
+        );
+        assert!(out.ends_with("<text x=\"14\" y=\"28\">abc</text>\n<text x=\"14\" y=\"70\">d</text>\n</g></svg>\n"));
+    }
+
+    #[test]
+    fn go_sample_matches_golden_svg() {
+        const GOLDEN: &str = "../examples/golden/sample.go.svg";
+        let schemes = crate::tinted_theming::load_base24_schemes("../examples/base24/catppuccin-mocha.yml").unwrap();
+        let theme = Theme::from_base24(&schemes[0]);
+        let (src, tokens) = go_sample();
+
+        let mut actual = String::new();
+        SvgFormatter::new().format(&src, &tokens, &theme, &mut actual);
+        assert_golden_text(&actual, GOLDEN);
+    }
+}
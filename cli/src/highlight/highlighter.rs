@@ -0,0 +1,458 @@
+//! A lexer, theme, and formatter set up once and shared across requests and threads.
+
+use super::cache::{Cache, cache_key, debug_fingerprint};
+use super::formatters::Overlay;
+use super::range::{RangeOptions, ranges_cancellable};
+use super::{CancelToken, Formatter, HighlightError, Lexer, Theme, TokenKind, highlight_cancellable};
+
+use std::borrow::Cow;
+use std::io::Write;
+use std::ops::RangeInclusive;
+use std::sync::Arc;
+
+/// A formatter whose line numbers and overlays a [Highlighter] can change for a single call.
+///
+/// The highlighter makes the change on a copy, so the formatter it shares never does. [super::formatters::AnsiFormatter]
+/// and [super::formatters::HtmlFormatter] implement it.
+pub trait CallFormatter: Formatter + Clone {
+    /// Turns line numbers on or off.
+    fn set_line_numbers(&mut self, enabled: bool);
+
+    /// Layers `overlays` over the document after the formatter's own.
+    fn extend_overlays(&mut self, overlays: &[Overlay]);
+}
+
+/// Per-call settings for [Highlighter::highlight_with], which leave the highlighter itself unchanged.
+#[derive(Debug, Clone, Default)]
+pub struct CallOptions {
+    lines: Vec<RangeInclusive<usize>>,
+    context: usize,
+    line_numbers: Option<bool>,
+    overlays: Vec<Overlay>,
+    cancel: CancelToken,
+}
+
+impl CallOptions {
+    /// Creates options that render the whole document the way the highlighter's formatter is set up to.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Renders only lines `lines` (1-based, inclusive), like [super::highlight_ranges]; call it again to add more
+    /// ranges.
+    pub fn with_lines(mut self, lines: RangeInclusive<usize>) -> Self {
+        self.lines.push(lines);
+        self
+    }
+
+    /// Also renders `lines` lines before and after each range given to [CallOptions::with_lines].
+    pub fn with_context(mut self, lines: usize) -> Self {
+        self.context = lines;
+        self
+    }
+
+    /// Turns line numbers on or off for this call, whatever the formatter's own setting.
+    pub fn with_line_numbers(mut self, enabled: bool) -> Self {
+        self.line_numbers = Some(enabled);
+        self
+    }
+
+    /// Layers `overlays` over the document for this call, after any the formatter has.
+    pub fn with_overlays(mut self, overlays: impl IntoIterator<Item = Overlay>) -> Self {
+        self.overlays.extend(overlays);
+        self
+    }
+
+    /// Stops with [HighlightError::Cancelled] once `cancel` fires, checking before every line.
+    pub fn with_cancel(mut self, cancel: CancelToken) -> Self {
+        self.cancel = cancel;
+        self
+    }
+}
+
+/// A lexer, theme, and formatter set up once and shared by every request, from any number of threads.
+///
+/// Nothing in it changes after [Highlighter::new], which resolves the theme's inherited styles up front; every call
+/// lexes with a state of its own and works out escapes or tags from those styles into its own output. Line numbers
+/// and overlays set through [CallOptions] apply to a copy of the formatter made for that call. A highlighter is
+/// `Send` and `Sync` whenever its formatter is, as the bundled ones are, so it can sit in a `static`, an `Arc`, or
+/// a server's shared state instead of being rebuilt for every request.
+///
+/// The free functions such as [super::highlight] are just as safe to call from many threads with shared lexers,
+/// themes, and formatters; a highlighter saves passing the three around and keeps the per-call options in one place.
+///
+/// [Highlighter::with_cache] adds a [Cache] of rendered output, for servers that render the same documents over and
+/// over. A call whose output is cached returns it without lexing; the key covers the source, the lexer's name, the
+/// theme and formatter fingerprints, and the call's options, so changing any of them renders afresh. Formatters
+/// whose [Formatter::fingerprint] is `None` are never cached.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::formatters::AnsiFormatter;
+/// use colorizer::highlight::lexers::PlainText;
+/// use colorizer::highlight::{CallOptions, Highlighter, Theme};
+/// use colorizer::terminal::ColorProfile;
+///
+/// let theme = Theme::new("Plain", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+/// let highlighter = Highlighter::new(&PlainText, theme, AnsiFormatter::new().with_profile(ColorProfile::NoColor));
+/// let outputs: Vec<String> = std::thread::scope(|scope| {
+///     let workers: Vec<_> = (0..4).map(|_| scope.spawn(|| highlighter.highlight("a\nb\n").unwrap())).collect();
+///     workers.into_iter().map(|worker| worker.join().unwrap()).collect()
+/// });
+/// assert!(outputs.iter().all(|out| out == "a\nb\n"));
+///
+/// let options = CallOptions::new().with_lines(2..=2).with_line_numbers(true);
+/// assert_eq!(highlighter.highlight_with("a\nb\n", &options).unwrap(), "2 b\n");
+/// ```
+pub struct Highlighter<'a, F> {
+    lexer: &'a dyn Lexer,
+    theme: Theme,
+    formatter: F,
+    cache: Option<Arc<dyn Cache>>,
+    /// Fingerprints of the theme and the shared formatter, worked out once since neither changes.
+    theme_fingerprint: u64,
+    formatter_fingerprint: Option<u64>,
+}
+
+impl<'a, F: CallFormatter> Highlighter<'a, F> {
+    /// Bundles `lexer`, `theme`, and `formatter`, resolving the theme's styles now rather than on the first call.
+    pub fn new(lexer: &'a dyn Lexer, theme: Theme, formatter: F) -> Self {
+        theme.style_for(TokenKind::Text);
+        let (theme_fingerprint, formatter_fingerprint) = (theme.fingerprint(), formatter.fingerprint());
+        Self { lexer, theme, formatter, cache: None, theme_fingerprint, formatter_fingerprint }
+    }
+
+    /// Keeps rendered output in `cache`, and returns it from there on later calls with the same input. Pass an
+    /// `Arc` to share one cache between several highlighters.
+    pub fn with_cache(mut self, cache: impl Cache + 'static) -> Self {
+        self.cache = Some(Arc::new(cache));
+        self
+    }
+
+    pub fn lexer(&self) -> &'a dyn Lexer {
+        self.lexer
+    }
+
+    pub fn theme(&self) -> &Theme {
+        &self.theme
+    }
+
+    pub fn formatter(&self) -> &F {
+        &self.formatter
+    }
+
+    /// Highlights all of `src`.
+    pub fn highlight(&self, src: &str) -> Result<String, HighlightError> {
+        self.highlight_with(src, &CallOptions::new())
+    }
+
+    /// Highlights `src` with the per-call `options`.
+    pub fn highlight_with(&self, src: &str, options: &CallOptions) -> Result<String, HighlightError> {
+        let formatter = self.formatter_for(options);
+        let key = self
+            .cache
+            .as_ref()
+            .and_then(|_| self.key_for(src, options, formatter.as_ref()));
+        if let (Some(cache), Some(key)) = (&self.cache, &key)
+            && let Some(out) = cache.get(key).and_then(|hit| String::from_utf8(hit).ok())
+        {
+            return Ok(out);
+        }
+
+        let out = if options.lines.is_empty() {
+            highlight_cancellable(src, self.lexer, &self.theme, formatter.as_ref(), &options.cancel)?
+        } else {
+            let ranges = RangeOptions::new().with_context(options.context);
+            let lines = options.lines.iter().cloned();
+            ranges_cancellable(
+                src,
+                lines,
+                &ranges,
+                self.lexer,
+                &self.theme,
+                formatter.as_ref(),
+                &options.cancel,
+            )?
+        };
+        if let (Some(cache), Some(key)) = (&self.cache, key) {
+            cache.set(&key, out.clone().into_bytes());
+        }
+        Ok(out)
+    }
+
+    /// Returns the key [Highlighter::highlight_with] caches the output of `src` under with `options`, or `None` if
+    /// the formatter has no fingerprint.
+    pub fn cache_key(&self, src: &str, options: &CallOptions) -> Option<String> {
+        self.key_for(src, options, self.formatter_for(options).as_ref())
+    }
+
+    /// Highlights `src` with the per-call `options` and writes the result to `writer`.
+    pub fn highlight_to(&self, mut writer: impl Write, src: &str, options: &CallOptions) -> Result<(), HighlightError> {
+        writer.write_all(self.highlight_with(src, options)?.as_bytes())?;
+        Ok(())
+    }
+
+    /// The key of `src` rendered by `formatter`, the shared one or a copy for `options`.
+    fn key_for(&self, src: &str, options: &CallOptions, formatter: &F) -> Option<String> {
+        let shared = std::ptr::eq(formatter, &self.formatter);
+        let mut fingerprint = if shared { self.formatter_fingerprint } else { formatter.fingerprint() }?;
+        // Line numbers and overlays are already part of the formatter; the line ranges aren't.
+        if !options.lines.is_empty() {
+            fingerprint = debug_fingerprint(&(fingerprint, &options.lines, options.context));
+        }
+        Some(cache_key(src, self.lexer.name(), self.theme_fingerprint, fingerprint))
+    }
+
+    /// The shared formatter, or a copy of it adjusted for `options`.
+    fn formatter_for(&self, options: &CallOptions) -> Cow<'_, F> {
+        if options.line_numbers.is_none() && options.overlays.is_empty() {
+            return Cow::Borrowed(&self.formatter);
+        }
+        let mut formatter = self.formatter.clone();
+        if let Some(enabled) = options.line_numbers {
+            formatter.set_line_numbers(enabled);
+        }
+        formatter.extend_overlays(&options.overlays);
+        Cow::Owned(formatter)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::formatters::{AnsiFormatter, HtmlFormatter, Position};
+    use crate::highlight::lexers::find;
+    use crate::highlight::{CancelReason, LexerState, LruCache, Style, Token, highlight};
+    use crate::terminal::ColorProfile;
+
+    use std::sync::atomic::{AtomicUsize, Ordering};
+    use std::thread;
+    use std::time::Instant;
+
+    const SRC: &str = "package main\n\nfunc main() {\n\tprintln(\"hi\") // greet\n}\n";
+
+    fn theme() -> Theme {
+        let mut theme = Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        theme.set(TokenKind::Keyword, Srgb8::new(255, 0, 0));
+        theme.set(TokenKind::String, Srgb8::new(0, 255, 0));
+        theme
+    }
+
+    fn formatter() -> AnsiFormatter {
+        AnsiFormatter::new().with_profile(ColorProfile::TrueColor)
+    }
+
+    #[test]
+    fn highlighters_are_shareable_across_threads() {
+        fn shareable<T: Send + Sync>() {}
+        shareable::<Highlighter<'static, AnsiFormatter>>();
+        shareable::<Highlighter<'static, HtmlFormatter>>();
+    }
+
+    #[test]
+    fn one_highlighter_serves_many_threads_with_different_options() {
+        let lexer = find("go").unwrap();
+        let highlighter = Highlighter::new(lexer, theme(), formatter());
+        let marked = Overlay::bytes(0..7, Style::new().with_background(Srgb8::new(0, 0, 255)));
+        let calls = [
+            CallOptions::new(),
+            CallOptions::new().with_line_numbers(true),
+            CallOptions::new().with_lines(3..=4).with_context(1),
+            CallOptions::new().with_overlays([marked]),
+        ];
+        let expected: Vec<String> = calls
+            .iter()
+            .map(|options| highlighter.highlight_with(SRC, options).unwrap())
+            .collect();
+        assert_eq!(expected[0], highlight(SRC, lexer, &theme(), &formatter()).unwrap());
+        assert!(expected.windows(2).all(|pair| pair[0] != pair[1]));
+
+        thread::scope(|scope| {
+            for worker in 0..64 {
+                let (highlighter, calls, expected) = (&highlighter, &calls, &expected);
+                scope.spawn(move || {
+                    for round in 0..20 {
+                        let call = (worker + round) % calls.len();
+                        assert_eq!(highlighter.highlight_with(SRC, &calls[call]).unwrap(), expected[call]);
+                    }
+                });
+            }
+        });
+    }
+
+    #[test]
+    fn call_options_leave_the_shared_formatter_alone() {
+        let highlighter = Highlighter::new(find("go").unwrap(), theme(), HtmlFormatter::new().with_classes("clz-"));
+        let plain = highlighter.highlight(SRC).unwrap();
+        let numbered = highlighter
+            .highlight_with(SRC, &CallOptions::new().with_line_numbers(true))
+            .unwrap();
+        assert_ne!(numbered, plain);
+        assert_eq!(highlighter.highlight(SRC).unwrap(), plain);
+
+        let mut out = Vec::new();
+        highlighter.highlight_to(&mut out, SRC, &CallOptions::new()).unwrap();
+        assert_eq!(String::from_utf8(out).unwrap(), plain);
+    }
+
+    #[test]
+    fn cancelled_calls_stop_in_line_ranges_too() {
+        let highlighter = Highlighter::new(find("go").unwrap(), theme(), formatter());
+        let cancel = CancelToken::new();
+        cancel.cancel();
+        for options in [CallOptions::new(), CallOptions::new().with_lines(4..=4)] {
+            let err = highlighter
+                .highlight_with(SRC, &options.with_cancel(cancel.clone()))
+                .unwrap_err();
+            assert!(matches!(
+                err,
+                HighlightError::Cancelled { reason: CancelReason::Cancelled, offset: 0, .. }
+            ));
+        }
+    }
+
+    /// Wraps a lexer, counting the documents it starts lexing.
+    struct Counting {
+        lexer: &'static dyn Lexer,
+        starts: AtomicUsize,
+    }
+
+    impl Lexer for Counting {
+        fn name(&self) -> &str {
+            self.lexer.name()
+        }
+
+        fn start(&self) -> Box<dyn LexerState + '_> {
+            self.starts.fetch_add(1, Ordering::Relaxed);
+            self.lexer.start()
+        }
+    }
+
+    #[test]
+    fn cached_calls_skip_lexing() {
+        let lexer = Counting { lexer: find("go").unwrap(), starts: AtomicUsize::new(0) };
+        let cache = Arc::new(LruCache::new(1 << 20));
+        let highlighter = Highlighter::new(&lexer, theme(), formatter()).with_cache(Arc::clone(&cache));
+        let uncached = highlight(SRC, find("go").unwrap(), &theme(), &formatter()).unwrap();
+        for _ in 0..3 {
+            assert_eq!(highlighter.highlight(SRC).unwrap(), uncached);
+        }
+        assert_eq!(lexer.starts.load(Ordering::Relaxed), 1);
+
+        let numbered = CallOptions::new().with_line_numbers(true);
+        let lines = CallOptions::new().with_lines(2..=3);
+        for options in [&numbered, &lines, &numbered, &lines] {
+            highlighter.highlight_with(SRC, options).unwrap();
+        }
+        assert_eq!(lexer.starts.load(Ordering::Relaxed), 3);
+        assert_eq!(cache.len(), 3);
+        highlighter.highlight(&SRC.replace("hi", "ho")).unwrap();
+        assert_eq!(lexer.starts.load(Ordering::Relaxed), 4);
+    }
+
+    #[test]
+    fn changing_one_formatter_option_changes_the_key() {
+        let lexer = find("go").unwrap();
+        let key = |formatter: AnsiFormatter| {
+            Highlighter::new(lexer, theme(), formatter)
+                .cache_key(SRC, &CallOptions::new())
+                .unwrap()
+        };
+        assert_eq!(key(formatter()), key(formatter()));
+        assert_ne!(key(formatter()), key(formatter().with_profile(ColorProfile::Ansi256)));
+        assert_ne!(key(formatter()), key(formatter().with_tab_width(4)));
+
+        let highlighter = Highlighter::new(lexer, theme(), formatter());
+        let plain = highlighter.cache_key(SRC, &CallOptions::new());
+        assert_ne!(
+            highlighter.cache_key(SRC, &CallOptions::new().with_line_numbers(true)),
+            plain
+        );
+        assert_ne!(highlighter.cache_key(SRC, &CallOptions::new().with_lines(1..=2)), plain);
+        let mut recolored = theme();
+        recolored.set(TokenKind::Comment, Srgb8::new(1, 2, 3));
+        assert_ne!(
+            Highlighter::new(lexer, recolored, formatter()).cache_key(SRC, &CallOptions::new()),
+            plain
+        );
+    }
+
+    #[test]
+    fn formatters_without_a_fingerprint_are_never_cached() {
+        #[derive(Clone)]
+        struct Texts;
+
+        impl Formatter for Texts {
+            fn write_tokens(&self, src: &str, tokens: &[Token], _: &Theme, _: Position, out: &mut String) {
+                tokens.iter().for_each(|token| out.push_str(token.text(src)));
+            }
+        }
+
+        impl CallFormatter for Texts {
+            fn set_line_numbers(&mut self, _: bool) {}
+
+            fn extend_overlays(&mut self, _: &[Overlay]) {}
+        }
+
+        let cache = Arc::new(LruCache::new(1 << 20));
+        let highlighter = Highlighter::new(find("go").unwrap(), theme(), Texts).with_cache(Arc::clone(&cache));
+        assert_eq!(highlighter.highlight(SRC).unwrap(), SRC);
+        assert_eq!(highlighter.cache_key(SRC, &CallOptions::new()), None);
+        assert!(cache.is_empty());
+    }
+
+    #[test]
+    #[ignore = "timing; run with `cargo test --release -- --ignored`"]
+    fn shared_highlighter_beats_per_request_construction() {
+        const REQUESTS: usize = 200;
+        let started = Instant::now();
+        for _ in 0..REQUESTS {
+            let theme = crate::highlight::themes::load_vscode_path("../examples/themes/dark_plus.json").unwrap();
+            let lexer = find("go").unwrap();
+            highlight(SRC, lexer, &theme, &formatter()).unwrap();
+        }
+        let per_request = started.elapsed();
+
+        let theme = crate::highlight::themes::load_vscode_path("../examples/themes/dark_plus.json").unwrap();
+        let highlighter = Highlighter::new(find("go").unwrap(), theme, formatter());
+        let started = Instant::now();
+        for _ in 0..REQUESTS {
+            highlighter.highlight(SRC).unwrap();
+        }
+        let shared = started.elapsed();
+        assert!(
+            shared * 2 < per_request,
+            "per request {per_request:?}, shared {shared:?}"
+        );
+    }
+
+    #[test]
+    #[ignore = "timing; run with `cargo test --release -- --ignored`"]
+    fn cached_snippets_render_in_next_to_no_time() {
+        const SNIPPETS: usize = 1_000;
+        // Ten different blocks of about a thousand bytes, each rendered a hundred times.
+        let snippets: Vec<String> = (0..10)
+            .map(|i| SRC.replace("hi", &format!("hi {i}")).repeat(20))
+            .collect();
+        let uncached = Highlighter::new(find("go").unwrap(), theme(), formatter());
+        let cached = Highlighter::new(find("go").unwrap(), theme(), formatter()).with_cache(LruCache::new(1 << 20));
+        for snippet in &snippets {
+            cached.highlight(snippet).unwrap();
+        }
+
+        let started = Instant::now();
+        for i in 0..SNIPPETS {
+            uncached.highlight(&snippets[i % snippets.len()]).unwrap();
+        }
+        let rendered = started.elapsed();
+        let started = Instant::now();
+        for i in 0..SNIPPETS {
+            cached.highlight(&snippets[i % snippets.len()]).unwrap();
+        }
+        let hits = started.elapsed();
+        assert!(hits * 10 < rendered, "rendered {rendered:?}, cached {hits:?}");
+    }
+}
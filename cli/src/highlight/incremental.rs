@@ -0,0 +1,407 @@
+//! Re-highlighting of edited documents without re-lexing them from the top.
+
+use super::lexers::{Lexer, LexerState};
+use super::lines::{StyledLine, push_text};
+use super::{HighlightError, Theme, Token};
+
+use std::ops::Range;
+
+/// A lexed line: its tokens, with offsets relative to the line start, and the state lexing it started from.
+struct Line {
+    start: usize,
+    len: usize,
+    tokens: Vec<Token>,
+    /// `None` when the lexer's states can't be copied.
+    state: Option<Box<dyn LexerState>>,
+}
+
+/// A document kept tokenized across edits, for editors that re-highlight on every keystroke.
+///
+/// The lexer state at the start of every line is kept as a checkpoint (see [LexerState::snapshot]). An edit re-lexes
+/// from the checkpoint of the line it starts on, and stops at the first line past the edit whose new starting state
+/// matches the old one ([LexerState::same_as]); every line after that would lex exactly as before. Opening or closing
+/// a block comment or raw string therefore re-lexes up to wherever the construct's effect ends, while a typical
+/// keystroke re-lexes a single line. Lexers whose states can't be copied or compared still work, but every edit
+/// re-lexes the document from the top.
+///
+/// Unlike [Lexer::tokenize], tokens never span a line break: a block comment over three lines yields one token per
+/// line.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Incremental, TokenKind};
+/// use colorizer::highlight::lexers::Shell;
+///
+/// let mut doc = Incremental::new(&Shell, "echo hi\nls\n").unwrap();
+/// let changed = doc.edit(5..7, "\"hi").unwrap();
+/// assert_eq!(doc.source(), "echo \"hi\nls\n");
+/// // The unterminated string now runs through the next line, so both lines changed.
+/// assert_eq!(changed, 0..3);
+/// assert!(doc.tokens_for_lines(1..2).iter().all(|token| token.kind == TokenKind::String));
+/// ```
+pub struct Incremental<'l> {
+    lexer: &'l dyn Lexer,
+    src: String,
+    /// Every line of `src`; the last one lacks a newline, so the list ends with an empty line when `src` ends in one.
+    lines: Vec<Line>,
+}
+
+impl<'l> Incremental<'l> {
+    /// Lexes `src` in full with `lexer`.
+    pub fn new(lexer: &'l dyn Lexer, src: impl Into<String>) -> Result<Self, HighlightError> {
+        let mut doc = Self { lexer, src: src.into(), lines: Vec::new() };
+        doc.lines = doc.lex_from(0, lexer.start(), 0, &[], 0)?.0;
+        Ok(doc)
+    }
+
+    /// Returns the current text of the document.
+    pub fn source(&self) -> &str {
+        &self.src
+    }
+
+    /// Returns the number of lines, counting the empty line after a trailing newline.
+    pub fn line_count(&self) -> usize {
+        self.lines.len()
+    }
+
+    /// Replaces the bytes in `range` with `text` and re-lexes the lines that changed.
+    ///
+    /// Returns the zero-based lines, in the edited document, whose tokens may differ from before; every other line
+    /// only moved. When lexing fails, the edit is undone and the document is left as it was.
+    ///
+    /// # Panics
+    ///
+    /// Panics if `range` is out of bounds or doesn't fall on `char` boundaries, like [String::replace_range].
+    pub fn edit(&mut self, range: Range<usize>, text: &str) -> Result<Range<usize>, HighlightError> {
+        let delta = text.len() as isize - range.len() as isize;
+        // Lexing a line depends only on its text and starting state, so the line holding the edit is the first that
+        // can change; the line holding its end is the last whose text changed.
+        let mut first = self.line_at(range.start);
+        let last = self.line_at(range.end);
+        let state = match self.lines[first].state.as_ref().and_then(|state| state.snapshot()) {
+            Some(state) => state,
+            None => {
+                first = 0;
+                self.lexer.start()
+            }
+        };
+        let start = self.lines[first].start;
+        let changed_end = (self.lines[last].start + self.lines[last].len) as isize + delta;
+
+        let removed = self.src[range.clone()].to_string();
+        self.src.replace_range(range.clone(), text);
+        let old = std::mem::take(&mut self.lines);
+        let (lexed, replaced) = match self.lex_from(start, state, changed_end as usize, &old[last + 1..], delta) {
+            Ok(result) => result,
+            Err(err) => {
+                self.src.replace_range(range.start..range.start + text.len(), &removed);
+                self.lines = old;
+                return Err(err);
+            }
+        };
+
+        // Lines after the point of convergence keep their tokens and states and only move.
+        let changed = first..first + lexed.len();
+        let mut lines = old;
+        let tail = lines.split_off(last + 1 + replaced);
+        lines.truncate(first);
+        lines.extend(lexed);
+        lines.extend(
+            tail.into_iter()
+                .map(|line| Line { start: line.start.wrapping_add_signed(delta), ..line }),
+        );
+        self.lines = lines;
+        Ok(changed)
+    }
+
+    /// Returns the tokens of lines `lines` (zero-based, clamped to the document), with offsets into
+    /// [Incremental::source].
+    pub fn tokens_for_lines(&self, lines: Range<usize>) -> Vec<Token> {
+        let end = lines.end.min(self.lines.len());
+        let start = lines.start.min(end);
+        self.lines[start..end]
+            .iter()
+            .flat_map(|line| {
+                line.tokens
+                    .iter()
+                    .map(|token| Token::new(token.kind, line.start + token.start, line.start + token.end))
+            })
+            .collect()
+    }
+
+    /// Returns lines `lines` (zero-based, clamped to the document) as [StyledLine]s in `theme`, the way
+    /// [super::highlight_lines] splits a whole document, so a terminal UI can draw what's visible after each edit.
+    pub fn styled_lines(&self, lines: Range<usize>, theme: &Theme) -> Vec<StyledLine> {
+        let end = lines.end.min(self.lines.len());
+        let start = lines.start.min(end);
+        self.lines[start..end]
+            .iter()
+            .map(|line| {
+                let text = &self.src[line.start..line.start + line.len];
+                let mut styled = vec![StyledLine::default()];
+                for token in &line.tokens {
+                    push_text(&mut styled, token.text(text), theme.style_for(token.kind));
+                }
+                styled.swap_remove(0)
+            })
+            .collect()
+    }
+
+    /// Index of the line containing byte `offset`; an offset at a line's end belongs to the next line.
+    fn line_at(&self, offset: usize) -> usize {
+        self.lines
+            .partition_point(|line| line.start <= offset)
+            .saturating_sub(1)
+    }
+
+    /// Lexes lines of `src` from the line starting at `start` with `state`.
+    ///
+    /// Once past `changed_end`, lexing stops at the first line that starts where one of the old lines in `rest` now
+    /// starts (after shifting them by `delta`) with a matching state, or at the end of the document. Returns the new
+    /// lines and how many lines of `rest` they replace.
+    fn lex_from(
+        &self, mut start: usize, mut state: Box<dyn LexerState + 'l>, changed_end: usize, rest: &[Line], delta: isize,
+    ) -> Result<(Vec<Line>, usize), HighlightError> {
+        let mut lines = Vec::new();
+        let mut next = 0;
+        loop {
+            let len = match self.src[start..].find('\n') {
+                Some(newline) => newline + 1,
+                None => self.src.len() - start,
+            };
+            let text = &self.src[start..start + len];
+            let checkpoint = state.snapshot();
+            let mut tokens = Vec::new();
+            if !text.is_empty() {
+                state.tokenize_line(text, 0, &mut tokens)?;
+            }
+            lines.push(Line { start, len, tokens, state: checkpoint });
+            start += len;
+            // Only a line that ends in a newline is followed by another one.
+            if !text.ends_with('\n') {
+                return Ok((lines, rest.len()));
+            }
+            if start >= changed_end {
+                while next < rest.len() && rest[next].start.wrapping_add_signed(delta) < start {
+                    next += 1;
+                }
+                let converged = rest.get(next).is_some_and(|line| {
+                    line.start.wrapping_add_signed(delta) == start
+                        && line.state.as_ref().is_some_and(|old| old.same_as(&*state))
+                });
+                if converged {
+                    return Ok((lines, next));
+                }
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::TokenKind;
+    use crate::highlight::lexers::{Markdown, PlainText, Shell};
+    use crate::highlight::token::push_token;
+
+    use std::any::Any;
+    use std::time::Instant;
+
+    /// Minimal lexer whose `/* ... */` comments span lines; `copyable` decides whether its states support
+    /// checkpoints.
+    struct BlockComments {
+        copyable: bool,
+    }
+
+    #[derive(Clone, PartialEq)]
+    struct BlockCommentState {
+        copyable: bool,
+        in_comment: bool,
+    }
+
+    impl Lexer for BlockComments {
+        fn name(&self) -> &str {
+            "Block Comments"
+        }
+
+        fn start(&self) -> Box<dyn LexerState + '_> {
+            Box::new(BlockCommentState { copyable: self.copyable, in_comment: false })
+        }
+    }
+
+    impl LexerState for BlockCommentState {
+        fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+            let mut pos = 0;
+            while pos < line.len() {
+                let rest = &line[pos..];
+                let (kind, len) = match (self.in_comment, rest.find(if self.in_comment { "*/" } else { "/*" })) {
+                    (true, Some(end)) => {
+                        self.in_comment = false;
+                        (TokenKind::Comment, end + 2)
+                    }
+                    (false, Some(0)) => {
+                        self.in_comment = true;
+                        (TokenKind::Comment, 2)
+                    }
+                    (false, Some(start)) => (TokenKind::Text, start),
+                    (in_comment, None) => (
+                        if in_comment { TokenKind::Comment } else { TokenKind::Text },
+                        rest.len(),
+                    ),
+                };
+                push_token(tokens, kind, offset + pos, offset + pos + len);
+                pos += len;
+            }
+            Ok(())
+        }
+
+        fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+            self.copyable.then(|| Box::new(self.clone()) as Box<dyn LexerState>)
+        }
+
+        fn same_as(&self, other: &dyn LexerState) -> bool {
+            crate::highlight::lexers::same_state(self, other)
+        }
+
+        fn as_any(&self) -> Option<&dyn Any> {
+            Some(self)
+        }
+    }
+
+    const COMMENTS: BlockComments = BlockComments { copyable: true };
+
+    fn assert_matches_fresh_lex(doc: &Incremental) {
+        let fresh = Incremental::new(doc.lexer, doc.source()).unwrap();
+        assert_eq!(doc.line_count(), fresh.line_count());
+        assert_eq!(
+            doc.tokens_for_lines(0..doc.line_count()),
+            fresh.tokens_for_lines(0..fresh.line_count()),
+            "{:?}",
+            doc.source()
+        );
+    }
+
+    #[test]
+    fn single_line_edits_relex_one_line() {
+        let src = "a\n".repeat(100);
+        let mut doc = Incremental::new(&COMMENTS, src).unwrap();
+        assert_eq!(doc.line_count(), 101);
+        assert_eq!(doc.edit(50..51, "bcd").unwrap(), 25..26);
+        assert_eq!(doc.tokens_for_lines(25..26), [Token::new(TokenKind::Text, 50, 54)]);
+        assert_eq!(doc.tokens_for_lines(26..27), [Token::new(TokenKind::Text, 54, 56)]);
+        assert_matches_fresh_lex(&doc);
+    }
+
+    #[test]
+    fn block_comments_invalidate_until_convergence() {
+        let mut doc = Incremental::new(&COMMENTS, "a\nb\nc\nd */\ne\nf\n").unwrap();
+        // Opening a comment changes everything up to its existing terminator, then the states agree again.
+        assert_eq!(doc.edit(2..2, "/*").unwrap(), 1..4);
+        assert_eq!(doc.tokens_for_lines(2..3), [Token::new(TokenKind::Comment, 6, 8)]);
+        assert_eq!(doc.tokens_for_lines(4..5), [Token::new(TokenKind::Text, 13, 15)]);
+        assert_matches_fresh_lex(&doc);
+
+        // Removing the terminator lets the comment run to the end of the document.
+        assert_eq!(doc.edit(10..12, "").unwrap(), 3..7);
+        assert!(
+            doc.tokens_for_lines(4..6)
+                .iter()
+                .all(|token| token.kind == TokenKind::Comment)
+        );
+        assert_matches_fresh_lex(&doc);
+
+        assert_eq!(doc.edit(2..4, "").unwrap(), 1..7);
+        assert_matches_fresh_lex(&doc);
+    }
+
+    #[test]
+    fn edits_across_lines_match_a_fresh_lex() {
+        let mut doc = Incremental::new(&COMMENTS, "one /* two\nthree */ four\nfive\n").unwrap();
+        let edits: [(Range<usize>, &str); 6] = [
+            (3..14, ""),
+            (0..0, "zero\n/*\n"),
+            (8..8, "*/"),
+            (28..28, "\n\n\n"),
+            (0..31, "x"),
+            (1..1, "\n/* open"),
+        ];
+        for (range, text) in edits {
+            doc.edit(range, text).unwrap();
+            assert_matches_fresh_lex(&doc);
+        }
+        assert_eq!(doc.source(), "x\n/* open\n");
+    }
+
+    #[test]
+    fn edits_at_the_end_extend_the_document() {
+        let mut doc = Incremental::new(&PlainText, "").unwrap();
+        assert_eq!(doc.line_count(), 1);
+        assert_eq!(doc.edit(0..0, "a\n").unwrap(), 0..2);
+        assert_eq!(doc.edit(2..2, "b").unwrap(), 1..2);
+        assert_eq!(
+            doc.tokens_for_lines(0..9),
+            [Token::new(TokenKind::Text, 0, 2), Token::new(TokenKind::Text, 2, 3)]
+        );
+        assert_matches_fresh_lex(&doc);
+    }
+
+    #[test]
+    fn states_without_snapshots_relex_from_the_top() {
+        let lexer = BlockComments { copyable: false };
+        let mut doc = Incremental::new(&lexer, "a\nb\nc\n").unwrap();
+        assert_eq!(doc.edit(4..5, "d").unwrap(), 0..4);
+        assert_eq!(doc.source(), "a\nb\nd\n");
+        assert_matches_fresh_lex(&doc);
+    }
+
+    #[test]
+    fn shell_heredocs_reconverge_after_their_terminator() {
+        let src = "cat <<EOF\nbody $x\nEOF\necho done\n";
+        let mut doc = Incremental::new(&Shell, src).unwrap();
+        // Retyping the terminator re-lexes through the end once, then the heredoc closes at the same line again.
+        assert_eq!(doc.edit(18..21, "EOX").unwrap(), 2..5);
+        assert!(
+            doc.tokens_for_lines(3..4)
+                .iter()
+                .all(|token| token.kind == TokenKind::String)
+        );
+        assert_eq!(doc.edit(18..21, "EOF").unwrap(), 2..5);
+        assert_matches_fresh_lex(&doc);
+        assert_eq!(doc.edit(16..17, "y").unwrap(), 1..2);
+        assert_matches_fresh_lex(&doc);
+    }
+
+    #[test]
+    fn markdown_fences_checkpoint_their_embedded_state() {
+        let markdown = Markdown::new();
+        let mut doc = Incremental::new(&markdown, "```text\ncode\n```\n\n# Title\n").unwrap();
+        assert_eq!(doc.edit(9..9, "more ").unwrap(), 1..2);
+        // Breaking the closing fence turns the rest of the document into code.
+        assert_eq!(doc.edit(18..19, "").unwrap(), 2..6);
+        assert!(
+            doc.tokens_for_lines(4..5)
+                .iter()
+                .all(|token| token.kind == TokenKind::Text)
+        );
+        assert_matches_fresh_lex(&doc);
+    }
+
+    #[test]
+    #[ignore = "timing; run with `cargo test --release -- --ignored`"]
+    fn keystrokes_in_large_files_relex_quickly() {
+        let src = "for f in *.txt; do\n  echo \"$f: ${count:-0}\" | tr a-z A-Z\ndone\n".repeat(3_334);
+        let mut doc = Incremental::new(&Shell, src).unwrap();
+        assert!(doc.line_count() > 10_000);
+
+        let edits = 1_000;
+        let started = Instant::now();
+        for i in 0..edits {
+            let at = doc.source().len() / edits * i;
+            let at = (at..).find(|&at| doc.source().is_char_boundary(at)).unwrap();
+            doc.edit(at..at, "x").unwrap();
+        }
+        let average = started.elapsed() / edits as u32;
+        assert!(average.as_micros() < 1_000, "average edit took {average:?}");
+    }
+}
@@ -0,0 +1,376 @@
+//! Decoding byte input that may not be valid UTF-8, or may not be text at all.
+
+use super::token::push_token;
+use super::{Formatter, HighlightError, Lexer, Theme, Token, TokenKind};
+
+use std::fmt::Write;
+use std::ops::Range;
+
+/// How many leading bytes [is_binary] looks at, the same window `git` and `grep` use.
+pub(crate) const SNIFF_LEN: usize = 8 * 1024;
+
+/// What to do with byte sequences that aren't valid UTF-8.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum InvalidUtf8 {
+    /// Substitute U+FFFD REPLACEMENT CHARACTER for each maximal invalid sequence, as [String::from_utf8_lossy] does.
+    /// The character is lexed like any other.
+    #[default]
+    Replace,
+    /// Keep each invalid byte, written as a `\xNN` escape since output is text, in an [TokenKind::Error] token.
+    Pass,
+    /// Fail with [HighlightError::InvalidUtf8] at the first invalid sequence.
+    Error,
+}
+
+/// How byte input is turned into text before lexing.
+#[derive(Debug, Clone, Default)]
+pub struct InputOptions {
+    invalid_utf8: InvalidUtf8,
+    allow_binary: bool,
+}
+
+impl InputOptions {
+    /// Creates options that replace invalid UTF-8 and reject binary input.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Sets the policy for invalid UTF-8.
+    pub fn with_invalid_utf8(mut self, policy: InvalidUtf8) -> Self {
+        self.invalid_utf8 = policy;
+        self
+    }
+
+    /// Highlights input that [is_binary] flags instead of failing with [HighlightError::BinaryInput].
+    pub fn with_allow_binary(mut self, allow: bool) -> Self {
+        self.allow_binary = allow;
+        self
+    }
+
+    pub(crate) fn invalid_utf8(&self) -> InvalidUtf8 {
+        self.invalid_utf8
+    }
+
+    /// Fails with [HighlightError::BinaryInput] when `head`, the start of the input, looks binary and that isn't
+    /// allowed.
+    pub(crate) fn check_binary(&self, head: &[u8]) -> Result<(), HighlightError> {
+        if !self.allow_binary && is_binary(head) {
+            return Err(HighlightError::BinaryInput);
+        }
+        Ok(())
+    }
+}
+
+/// Returns `true` when the first 8 KiB of `bytes` contain a NUL byte, which text in any ASCII-compatible encoding
+/// doesn't.
+pub fn is_binary(bytes: &[u8]) -> bool {
+    bytes[..bytes.len().min(SNIFF_LEN)].contains(&0)
+}
+
+/// An invalid sequence: where its stand-in sits in the decoded text, and where the bytes sat in the input.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Invalid {
+    text: Range<usize>,
+    source: Range<usize>,
+}
+
+/// Text decoded from bytes by [decode], with what it needs to map offsets back to the input.
+///
+/// Stand-ins for invalid sequences rarely have the same length as the bytes they replace, so token offsets into
+/// [Decoded::text] drift from the input after the first one; [Decoded::source_offset] and [Decoded::source_tokens]
+/// undo that.
+#[derive(Debug, Clone, Default)]
+pub struct Decoded {
+    text: String,
+    invalid: Vec<Invalid>,
+    policy: InvalidUtf8,
+}
+
+impl Decoded {
+    /// The text to lex and format.
+    pub fn text(&self) -> &str {
+        &self.text
+    }
+
+    /// Returns `true` when the input was valid UTF-8, so offsets need no mapping.
+    pub fn is_exact(&self) -> bool {
+        self.invalid.is_empty()
+    }
+
+    /// Maps a byte offset into [Decoded::text] to the matching offset in the input. Offsets inside a stand-in map to
+    /// the start of the bytes it replaced.
+    pub fn source_offset(&self, offset: usize) -> usize {
+        let index = self.invalid.partition_point(|invalid| invalid.text.start <= offset);
+        let Some(invalid) = index.checked_sub(1).map(|index| &self.invalid[index]) else { return offset };
+        if offset >= invalid.text.end {
+            invalid.source.end + (offset - invalid.text.end)
+        } else {
+            invalid.source.start
+        }
+    }
+
+    /// Returns `tokens`, lexed from [Decoded::text], with offsets into the input instead.
+    pub fn source_tokens(&self, tokens: &[Token]) -> Vec<Token> {
+        tokens
+            .iter()
+            .map(|token| {
+                Token::new(
+                    token.kind,
+                    self.source_offset(token.start),
+                    self.source_offset(token.end),
+                )
+            })
+            .collect()
+    }
+
+    /// Lexes the text with `lexer`, splitting out the bytes [InvalidUtf8::Pass] kept as [TokenKind::Error] tokens.
+    pub fn tokenize(&self, lexer: &dyn Lexer) -> Result<Vec<Token>, HighlightError> {
+        let mut tokens = lexer.tokenize(&self.text)?;
+        self.mark_passed(&mut tokens);
+        Ok(tokens)
+    }
+
+    /// Splits the bytes [InvalidUtf8::Pass] kept out of `tokens`, lexed from the text, as [TokenKind::Error] tokens.
+    pub(crate) fn mark_passed(&self, tokens: &mut Vec<Token>) {
+        if self.policy == InvalidUtf8::Pass && !self.invalid.is_empty() {
+            *tokens = mark_invalid(tokens, &self.invalid);
+        }
+    }
+}
+
+/// Decodes `bytes` into text following `options`.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{InputOptions, InvalidUtf8, decode};
+///
+/// let decoded = decode(b"a\xffb", &InputOptions::new()).unwrap();
+/// assert_eq!(decoded.text(), "a\u{fffd}b");
+/// // "b" starts at byte 4 of the text but byte 2 of the input.
+/// assert_eq!(decoded.source_offset(4), 2);
+///
+/// let passed = decode(b"a\xffb", &InputOptions::new().with_invalid_utf8(InvalidUtf8::Pass)).unwrap();
+/// assert_eq!(passed.text(), "a\\xffb");
+/// ```
+pub fn decode(bytes: &[u8], options: &InputOptions) -> Result<Decoded, HighlightError> {
+    options.check_binary(bytes)?;
+    let mut decoded = Decoded::default();
+    decode_chunk(bytes, 0, options, &mut decoded)?;
+    Ok(decoded)
+}
+
+/// Decodes `bytes`, which start at byte `base` of the input, into `decoded`, replacing what it held, without sniffing
+/// for binary content. Streams decode each chunk this way into one reused [Decoded], so the stand-ins' input ranges,
+/// and the offsets in errors, count from the start of the input.
+pub(crate) fn decode_chunk(
+    bytes: &[u8], base: usize, options: &InputOptions, decoded: &mut Decoded,
+) -> Result<(), HighlightError> {
+    let policy = options.invalid_utf8();
+    decoded.policy = policy;
+    let Decoded { text, invalid, .. } = decoded;
+    text.clear();
+    text.reserve(bytes.len());
+    invalid.clear();
+    let mut offset = base;
+    for chunk in bytes.utf8_chunks() {
+        text.push_str(chunk.valid());
+        offset += chunk.valid().len();
+        let bad = chunk.invalid();
+        if bad.is_empty() {
+            continue;
+        }
+
+        let start = text.len();
+        match policy {
+            InvalidUtf8::Replace => text.push(char::REPLACEMENT_CHARACTER),
+            InvalidUtf8::Pass => {
+                for byte in bad {
+                    let _ = write!(text, "\\x{byte:02x}");
+                }
+            }
+            InvalidUtf8::Error => return Err(HighlightError::InvalidUtf8 { offset }),
+        }
+        invalid.push(Invalid { text: start..text.len(), source: offset..offset + bad.len() });
+        offset += bad.len();
+    }
+    Ok(())
+}
+
+/// Splits `tokens` so the stand-ins in `invalid` become [TokenKind::Error] tokens of their own, one per run of
+/// adjacent stand-ins.
+fn mark_invalid(tokens: &[Token], invalid: &[Invalid]) -> Vec<Token> {
+    let mut marked = Vec::with_capacity(tokens.len() + invalid.len() * 2);
+    let mut spans = invalid.iter().map(|invalid| &invalid.text).peekable();
+    for token in tokens {
+        let mut start = token.start;
+        while let Some(span) = spans.peek() {
+            if span.start >= token.end {
+                break;
+            }
+            if span.start > start {
+                marked.push(Token::new(token.kind, start, span.start));
+            }
+            let end = span.end.min(token.end);
+            if end > start.max(span.start) {
+                push_token(&mut marked, TokenKind::Error, start.max(span.start), end);
+            }
+            start = start.max(end);
+            if span.end > token.end {
+                break;
+            }
+            spans.next();
+        }
+        if start < token.end {
+            marked.push(Token::new(token.kind, start, token.end));
+        }
+    }
+    marked
+}
+
+/// Decodes `bytes` following `options`, then highlights the text like [super::highlight].
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::{HighlightError, InputOptions, Theme, highlight_bytes};
+/// use colorizer::highlight::formatters::HtmlFormatter;
+/// use colorizer::highlight::lexers::PlainText;
+///
+/// let theme = Theme::new("Plain", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+/// let formatter = HtmlFormatter::new().with_classes("");
+/// let html = highlight_bytes(b"caf\xe9", &PlainText, &theme, &formatter, &InputOptions::new()).unwrap();
+/// assert_eq!(html, "<pre class=\"pre\"><code>caf\u{fffd}</code></pre>");
+///
+/// let binary = highlight_bytes(b"\x7fELF\0\0", &PlainText, &theme, &formatter, &InputOptions::new());
+/// assert!(matches!(binary, Err(HighlightError::BinaryInput)));
+/// ```
+pub fn highlight_bytes(
+    bytes: &[u8], lexer: &dyn Lexer, theme: &Theme, formatter: &dyn Formatter, options: &InputOptions,
+) -> Result<String, HighlightError> {
+    let decoded = decode(bytes, options)?;
+    let tokens = decoded.tokenize(lexer)?;
+    let mut out = String::with_capacity(decoded.text.len() * 2);
+    formatter.format(&decoded.text, &tokens, theme, &mut out);
+    Ok(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::{self, Markdown, PlainText, Shell};
+
+    use rand::rngs::StdRng;
+    use rand::seq::IndexedRandom;
+    use rand::{Rng, SeedableRng};
+
+    fn options(policy: InvalidUtf8) -> InputOptions {
+        InputOptions::new().with_invalid_utf8(policy)
+    }
+
+    #[test]
+    fn replacement_offsets_map_back_to_the_input() {
+        let bytes = b"ab\xe2\x82 cd\xff\xfe";
+        let decoded = decode(bytes, &InputOptions::new()).unwrap();
+        // A truncated sequence is one replacement; each stray byte is another.
+        assert_eq!(decoded.text(), "ab\u{fffd} cd\u{fffd}\u{fffd}");
+        assert!(!decoded.is_exact());
+        assert_eq!(decoded.source_offset(2), 2);
+        assert_eq!(decoded.source_offset(3), 2);
+        assert_eq!(decoded.source_offset(5), 4);
+        assert_eq!(decoded.source_offset(8), 7);
+        assert_eq!(decoded.source_offset(11), 8);
+        assert_eq!(decoded.source_offset(decoded.text().len()), bytes.len());
+
+        let tokens = decoded.tokenize(&PlainText).unwrap();
+        assert_eq!(
+            decoded.source_tokens(&tokens),
+            [Token::new(TokenKind::Text, 0, bytes.len())]
+        );
+    }
+
+    #[test]
+    fn passed_bytes_become_error_tokens() {
+        let bytes = b"echo \xff\xfe done\n";
+        let decoded = decode(bytes, &options(InvalidUtf8::Pass)).unwrap();
+        assert_eq!(decoded.text(), "echo \\xff\\xfe done\n");
+        let tokens = decoded.tokenize(&Shell).unwrap();
+        let errors: Vec<_> = tokens.iter().filter(|token| token.kind == TokenKind::Error).collect();
+        assert_eq!(errors.len(), 1, "{tokens:?}");
+        assert_eq!(errors[0].text(decoded.text()), "\\xff\\xfe");
+        assert_eq!(
+            decoded.source_tokens(&[*errors[0]]),
+            [Token::new(TokenKind::Error, 5, 7)]
+        );
+    }
+
+    #[test]
+    fn error_policy_reports_the_first_bad_offset() {
+        let result = decode(b"ok\n\xc3(", &options(InvalidUtf8::Error));
+        assert!(matches!(result, Err(HighlightError::InvalidUtf8 { offset: 3 })));
+        assert!(
+            decode("ok\n".as_bytes(), &options(InvalidUtf8::Error))
+                .unwrap()
+                .is_exact()
+        );
+    }
+
+    #[test]
+    fn nul_bytes_in_the_first_8k_mean_binary() {
+        assert!(is_binary(b"\x89PNG\r\n\x1a\n\0\0\0\rIHDR"));
+        assert!(!is_binary("plain text\n".as_bytes()));
+        let mut late = vec![b'a'; SNIFF_LEN];
+        late.push(0);
+        assert!(!is_binary(&late));
+
+        assert!(matches!(
+            decode(b"a\0b", &InputOptions::new()),
+            Err(HighlightError::BinaryInput)
+        ));
+        let allowed = InputOptions::new().with_allow_binary(true);
+        assert_eq!(decode(b"a\0b", &allowed).unwrap().text(), "a\0b");
+    }
+
+    #[test]
+    fn mutated_input_never_breaks_the_lexers() {
+        let samples: &[&[u8]] = &[
+            r#"{"name": "café", "tags": ["a", "b"], "n": -1.5e3, "ok": true, "none": null}"#.as_bytes(),
+            b"package main\n\nimport \"fmt\"\n\nfunc main() {\n\ts := `raw`\n\tfmt.Println(\"h\xc3\xa9\", s, 'x')\n}\n",
+            "# T\u{00ed}tulo\n\n```sh\necho \"\u{1f600}\"\n```\n".as_bytes(),
+        ];
+        let markdown = Markdown::new();
+        let mut bundled: Vec<&dyn Lexer> = vec![&PlainText, &Shell, &markdown];
+        for name in ["json", "go"] {
+            bundled.extend(lexers::find(name));
+        }
+
+        let mut rng = StdRng::seed_from_u64(0x0c01_0035);
+        for _ in 0..300 {
+            let mut bytes = samples.choose(&mut rng).unwrap().to_vec();
+            for _ in 0..rng.random_range(1..6) {
+                let at = rng.random_range(0..bytes.len());
+                match rng.random_range(0..3) {
+                    0 => bytes[at] = rng.random_range(0x80..=0xff),
+                    1 => bytes.insert(at, rng.random_range(0x80..=0xff)),
+                    _ => {
+                        bytes.remove(at);
+                    }
+                }
+            }
+
+            for policy in [InvalidUtf8::Replace, InvalidUtf8::Pass] {
+                let decoded = decode(&bytes, &options(policy)).unwrap();
+                for &lexer in &bundled {
+                    let tokens = decoded.tokenize(lexer).unwrap();
+                    let text: String = tokens.iter().map(|token| token.text(decoded.text())).collect();
+                    assert_eq!(text, decoded.text(), "{} on {bytes:?}", lexer.name());
+
+                    let mapped = decoded.source_tokens(&tokens);
+                    assert_eq!(mapped.last().map_or(0, |token| token.end), bytes.len());
+                    assert!(mapped.windows(2).all(|pair| pair[0].end == pair[1].start));
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,300 @@
+//! Coverage reports for lexers: which kinds of tokens they produce on a sample, and where they give up on it.
+
+use super::Lexer;
+use crate::highlight::{ColumnUnit, HighlightError, Positions, Token, TokenKind};
+
+use std::collections::BTreeMap;
+use std::fmt;
+use std::ops::Range;
+
+/// Bytes of the line kept on either side of an unmatched region.
+const CONTEXT: usize = 32;
+
+/// Number of unmatched regions [assert_coverage] shows.
+const SHOWN_REGIONS: usize = 5;
+
+/// Tokens of one kind in a [Report], and the bytes they cover.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct KindCount {
+    pub tokens: usize,
+    pub bytes: usize,
+}
+
+/// A run of input a lexer couldn't classify, with where it is and the text around it.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Unmatched {
+    /// Byte range in the source.
+    pub range: Range<usize>,
+    /// Zero-based line the region starts on.
+    pub line: usize,
+    /// Zero-based column, in characters, the region starts at.
+    pub column: usize,
+    /// Up to 32 bytes of the line before the region.
+    pub before: String,
+    /// The region's text, up to the end of its first line.
+    pub text: String,
+    /// Up to 32 bytes of the line after the region.
+    pub after: String,
+}
+
+/// What [analyze] found: the tokens a lexer produced for a sample, by kind, and the input it couldn't classify.
+///
+/// Unclassified input is what lexers mark as [TokenKind::Error], or as a custom kind registered under it. Its
+/// [Display](fmt::Display) form is a summary for people: totals, a line per kind, and each unmatched region with a
+/// caret under it.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Report {
+    /// The lexer's name.
+    pub language: String,
+    /// Length of the sample.
+    pub bytes: usize,
+    /// Number of tokens.
+    pub tokens: usize,
+    pub kinds: BTreeMap<TokenKind, KindCount>,
+    /// Bytes covered by error tokens.
+    pub error_bytes: usize,
+    /// Number of unmatched regions, each a run of adjacent error tokens; only the first of them are in
+    /// [Report::unmatched].
+    pub unmatched_regions: usize,
+    pub unmatched: Vec<Unmatched>,
+}
+
+impl Report {
+    /// The share of the sample's bytes covered by error tokens, from 0 to 1; 0 for an empty sample.
+    pub fn error_ratio(&self) -> f64 {
+        if self.bytes == 0 { 0.0 } else { self.error_bytes as f64 / self.bytes as f64 }
+    }
+}
+
+/// Tokenizes `src` with `lexer` and reports its coverage, with the first `regions` unmatched regions in detail.
+///
+/// This is for checking a lexer against real code while writing or importing it, such as rejecting imported
+/// [Chroma](super::load_chroma_xml) definitions that leave too much of a sample corpus unmatched; [assert_coverage]
+/// does that check in a test. Fails only if the lexer does.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::TokenKind;
+/// use colorizer::highlight::lexers::{Go, analyze};
+///
+/// let report = analyze(&Go, "x := 1 @ 2\ny := `ok`\n", 10).unwrap();
+/// assert_eq!(report.kinds[&TokenKind::Number].tokens, 2);
+/// assert_eq!(report.error_bytes, 1);
+/// let unmatched = &report.unmatched[0];
+/// assert_eq!((unmatched.line, unmatched.column, unmatched.text.as_str()), (0, 7, "@"));
+/// assert!(report.to_string().starts_with("Go: 21 bytes in "));
+/// ```
+pub fn analyze(lexer: &dyn Lexer, src: &str, regions: usize) -> Result<Report, HighlightError> {
+    let tokens = lexer.tokenize(src)?;
+    let mut report = Report {
+        language: lexer.name().to_string(),
+        bytes: src.len(),
+        tokens: tokens.len(),
+        kinds: BTreeMap::new(),
+        error_bytes: 0,
+        unmatched_regions: 0,
+        unmatched: Vec::new(),
+    };
+    for token in &tokens {
+        let count = report.kinds.entry(token.kind).or_default();
+        count.tokens += 1;
+        count.bytes += token.len();
+    }
+
+    let positions = Positions::new(src);
+    for range in unmatched_ranges(&tokens) {
+        report.error_bytes += range.len();
+        report.unmatched_regions += 1;
+        if report.unmatched.len() < regions {
+            report.unmatched.push(unmatched(&positions, range));
+        }
+    }
+    Ok(report)
+}
+
+/// Panics, showing the [Report], unless `lexer` tokenizes `src` with at most `max_error_ratio` of its bytes unmatched.
+///
+/// A test helper for lexers, bundled or imported: `assert_coverage(&lexer, sample, 0.0)` requires that nothing is
+/// left unmatched, and a small ratio tolerates the odd construct a table doesn't know.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::lexers::{Python, assert_coverage};
+///
+/// assert_coverage(&Python, "def f(x):\n    return x ** 2\n", 0.0);
+/// ```
+pub fn assert_coverage(lexer: &dyn Lexer, src: &str, max_error_ratio: f64) {
+    let report = match analyze(lexer, src, SHOWN_REGIONS) {
+        Ok(report) => report,
+        Err(err) => panic!("{} failed to tokenize the sample: {err}", lexer.name()),
+    };
+    assert!(
+        report.error_ratio() <= max_error_ratio,
+        "{} leaves more than {:.1}% of the sample unmatched\n{report}",
+        report.language,
+        max_error_ratio * 100.0
+    );
+}
+
+/// Byte ranges of the runs of adjacent error tokens.
+fn unmatched_ranges(tokens: &[Token]) -> Vec<Range<usize>> {
+    let mut ranges: Vec<Range<usize>> = Vec::new();
+    for token in tokens.iter().filter(|token| token.kind.builtin() == TokenKind::Error) {
+        match ranges.last_mut() {
+            Some(last) if last.end == token.start => last.end = token.end,
+            _ => ranges.push(token.start..token.end),
+        }
+    }
+    ranges
+}
+
+fn unmatched(positions: &Positions, range: Range<usize>) -> Unmatched {
+    let src = positions.source();
+    let (line, column) = positions.line_col(range.start, ColumnUnit::Chars);
+    let text = positions.line(line);
+    let end = range.end.min(text.end);
+    let before = ceil_char_boundary(src, range.start.saturating_sub(CONTEXT).max(text.start));
+    let after = floor_char_boundary(src, (end + CONTEXT).min(text.end));
+    Unmatched {
+        line,
+        column,
+        before: src[before..range.start].to_string(),
+        text: src[range.start..end].to_string(),
+        after: src[end..after].to_string(),
+        range,
+    }
+}
+
+fn floor_char_boundary(text: &str, mut offset: usize) -> usize {
+    while !text.is_char_boundary(offset) {
+        offset -= 1;
+    }
+    offset
+}
+
+fn ceil_char_boundary(text: &str, mut offset: usize) -> usize {
+    while !text.is_char_boundary(offset) {
+        offset += 1;
+    }
+    offset
+}
+
+impl fmt::Display for Report {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        writeln!(
+            f,
+            "{}: {} bytes in {} tokens, {} bytes ({:.1}%) unmatched in {} regions",
+            self.language,
+            self.bytes,
+            self.tokens,
+            self.error_bytes,
+            self.error_ratio() * 100.0,
+            self.unmatched_regions
+        )?;
+        for (kind, count) in &self.kinds {
+            writeln!(
+                f,
+                "  {:<22} {:>6} tokens {:>8} bytes",
+                kind.name(),
+                count.tokens,
+                count.bytes
+            )?;
+        }
+        for region in &self.unmatched {
+            let at = format!("{}:{}", region.line + 1, region.column + 1);
+            writeln!(f, "  {at:<8} {}{}{}", region.before, region.text, region.after)?;
+            let indent = 2 + 8 + 1 + region.before.chars().count();
+            let width = region.text.chars().count().max(1);
+            writeln!(f, "{:indent$}{}", "", "^".repeat(width))?;
+        }
+        if self.unmatched_regions > self.unmatched.len() {
+            writeln!(f, "  and {} more", self.unmatched_regions - self.unmatched.len())?;
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::{C, Cpp, Go, GraphQL, Protobuf, Rule, RuleLexer, RuleTable, Rust};
+
+    fn words() -> RuleLexer {
+        let table = RuleTable::new().with_state(
+            "root",
+            vec![
+                Rule::new(r"[a-z]+", TokenKind::Name),
+                Rule::new(r"\s+", TokenKind::Whitespace),
+            ],
+        );
+        RuleLexer::new("Words", &table).unwrap()
+    }
+
+    #[test]
+    fn reports_count_kinds_and_locate_unmatched_input() {
+        let src = "one two\nthree 4é5 six\n\tseven !!";
+        let report = analyze(&words(), src, 1).unwrap();
+        assert_eq!(report.kinds[&TokenKind::Name], KindCount { tokens: 5, bytes: 19 });
+        assert_eq!(report.error_bytes, 6);
+        assert_eq!(report.unmatched_regions, 2);
+        assert!((report.error_ratio() - 6.0 / src.len() as f64).abs() < 1e-9);
+        assert_eq!(
+            report.unmatched,
+            [Unmatched {
+                range: 14..18,
+                line: 1,
+                column: 6,
+                before: "three ".to_string(),
+                text: "4é5".to_string(),
+                after: " six".to_string(),
+            }]
+        );
+        assert_eq!(
+            report.to_string(),
+            "Words: 32 bytes in 13 tokens, 6 bytes (18.8%) unmatched in 2 regions\n\
+             \x20 Whitespace                  6 tokens        7 bytes\n\
+             \x20 Error                       2 tokens        6 bytes\n\
+             \x20 Name                        5 tokens       19 bytes\n\
+             \x20 2:7      three 4é5 six\n\
+             \x20                ^^^\n\
+             \x20 and 1 more\n"
+        );
+    }
+
+    #[test]
+    fn context_is_clipped_to_the_line() {
+        let long = format!("{} ! {}\n", "é".repeat(40), "a".repeat(40));
+        let report = analyze(&words(), &long, 5).unwrap();
+        let region = &report.unmatched[1];
+        assert_eq!(region.text, "!");
+        assert!(region.before.len() <= CONTEXT && region.before.ends_with("é "));
+        assert_eq!(region.after, format!(" {}", "a".repeat(31)));
+        assert_eq!(analyze(&words(), "", 5).unwrap().error_ratio(), 0.0);
+    }
+
+    #[test]
+    fn bundled_lexers_cover_their_samples() {
+        assert_coverage(&Go, include_str!("../../../../examples/languages/sample.go"), 0.0);
+        assert_coverage(&Rust, include_str!("../../../../examples/languages/sample.rs"), 0.0);
+        assert_coverage(
+            &Protobuf,
+            include_str!("../../../../examples/languages/schema.proto"),
+            0.0,
+        );
+        assert_coverage(
+            &GraphQL,
+            include_str!("../../../../examples/languages/schema.graphql"),
+            0.0,
+        );
+        assert_coverage(&Cpp, include_str!("../../../../examples/languages/query.hpp"), 0.0);
+        assert_coverage(&C, "int main(void) { return 0; }\n", 0.0);
+    }
+
+    #[test]
+    #[should_panic(expected = "Words leaves more than 10.0% of the sample unmatched")]
+    fn coverage_below_the_threshold_fails() {
+        assert_coverage(&words(), "ok 1234", 0.1);
+    }
+}
@@ -0,0 +1,913 @@
+//! Stateful lexers for C and C++ source, sharing one state that knows which language it reads.
+
+use super::{Cursor, Lexer, LexerState, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+const KEYWORDS: &[&str] = &[
+    "_Alignas",
+    "_Alignof",
+    "_Atomic",
+    "_Generic",
+    "_Noreturn",
+    "_Static_assert",
+    "_Thread_local",
+    "alignas",
+    "alignof",
+    "auto",
+    "break",
+    "case",
+    "const",
+    "constexpr",
+    "continue",
+    "default",
+    "do",
+    "else",
+    "extern",
+    "for",
+    "goto",
+    "if",
+    "inline",
+    "register",
+    "restrict",
+    "return",
+    "sizeof",
+    "static",
+    "static_assert",
+    "switch",
+    "thread_local",
+    "typeof",
+    "typeof_unqual",
+    "volatile",
+    "while",
+];
+
+/// C++ keywords on top of C's, through C++20's coroutines, concepts, and modules' `export`.
+const CPP_KEYWORDS: &[&str] = &[
+    "catch",
+    "co_await",
+    "co_return",
+    "co_yield",
+    "const_cast",
+    "consteval",
+    "constinit",
+    "decltype",
+    "delete",
+    "dynamic_cast",
+    "explicit",
+    "export",
+    "final",
+    "friend",
+    "mutable",
+    "new",
+    "noexcept",
+    "operator",
+    "override",
+    "private",
+    "protected",
+    "public",
+    "reinterpret_cast",
+    "requires",
+    "static_cast",
+    "this",
+    "throw",
+    "try",
+    "typeid",
+    "virtual",
+];
+
+const DECLARATIONS: &[&str] = &["enum", "struct", "typedef", "union"];
+
+const CPP_DECLARATIONS: &[&str] = &["class", "concept", "namespace", "template", "typename", "using"];
+
+/// Keywords after which the next name is the one being declared.
+const NAMES_CLASS: &[&str] = &["class", "concept", "enum", "namespace", "struct", "typename", "union"];
+
+const CONSTANTS: &[&str] = &["NULL", "false", "nullptr", "true"];
+
+/// Built-in types, plus the fixed-width and size types of `<stdint.h>` and `<stddef.h>`.
+const TYPES: &[&str] = &[
+    "_BitInt",
+    "_Bool",
+    "_Complex",
+    "_Imaginary",
+    "bool",
+    "char",
+    "char8_t",
+    "char16_t",
+    "char32_t",
+    "double",
+    "float",
+    "int",
+    "int8_t",
+    "int16_t",
+    "int32_t",
+    "int64_t",
+    "intmax_t",
+    "intptr_t",
+    "long",
+    "ptrdiff_t",
+    "short",
+    "signed",
+    "size_t",
+    "ssize_t",
+    "uint8_t",
+    "uint16_t",
+    "uint32_t",
+    "uint64_t",
+    "uintmax_t",
+    "uintptr_t",
+    "unsigned",
+    "void",
+    "wchar_t",
+];
+
+/// Predefined identifiers and macros, and the compiler built-ins that read like calls.
+const BUILTINS: &[&str] = &[
+    "_Pragma",
+    "__DATE__",
+    "__FILE__",
+    "__LINE__",
+    "__TIME__",
+    "__VA_ARGS__",
+    "__VA_OPT__",
+    "__attribute__",
+    "__cplusplus",
+    "__declspec",
+    "__func__",
+    "__has_cpp_attribute",
+    "__has_include",
+];
+
+/// Operators, longest first.
+const OPERATORS: &[&str] = &[
+    "<=>", "<<=", ">>=", "->*", "...", "->", "++", "--", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||", "+=", "-=",
+    "*=", "/=", "%=", "&=", "|=", "^=", "::", ".*", "+", "-", "*", "/", "%", "&", "|", "^", "~", "!", "<", ">", "=",
+    "?",
+];
+
+/// Lexer for C, through C23.
+///
+/// Preprocessor directives are read as the preprocessor reads them, one logical line at a time, so a trailing
+/// backslash carries a directive (or a `//` comment) onto the next line. The `#` and directive name are
+/// [TokenKind::CommentPreproc]; an `#include` path, in quotes or angle brackets, is [TokenKind::String]; the macro
+/// named by `#define`, `#undef`, `#ifdef`, and `#ifndef` is [TokenKind::NameMacro], and a macro's replacement list is
+/// lexed as code, with `#` and `##` as operators. Conditions (`#if`, `#elif`) are code too, with `defined` as
+/// [TokenKind::NameBuiltin] and its operand a macro. `#pragma`, `#error`, `#warning`, and unknown directives are
+/// preprocessor text up to any comment.
+///
+/// Strings and character literals, with their `L`, `u`, `U`, and `u8` prefixes, are [TokenKind::String] with their
+/// escapes as [TokenKind::StringEscape]; malformed escapes are [TokenKind::Error]. Numbers keep their suffixes and
+/// C23 digit separators (`1'000'000`). The names after `struct`, `union`, and `enum` are [TokenKind::NameClass],
+/// names before `(` [TokenKind::NameFunction], and those inside `[[...]]` attributes [TokenKind::NameAttribute].
+/// Doxygen comments (`///`, `//!`, `/** */`, `/*! */`) are [TokenKind::CommentDoc].
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::C;
+///
+/// let src = "#include <stdio.h>\n#define SQUARE(x) ((x) * (x))\nint main(void) { return SQUARE(2); }\n";
+/// let tokens = C.tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("#include"), Some(TokenKind::CommentPreproc));
+/// assert_eq!(kind("<stdio.h>"), Some(TokenKind::String));
+/// assert_eq!(kind("SQUARE"), Some(TokenKind::NameMacro));
+/// assert_eq!(kind("main"), Some(TokenKind::NameFunction));
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct C;
+
+impl Lexer for C {
+    fn name(&self) -> &str {
+        "C"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(CState::new(false))
+    }
+}
+
+/// Lexer for C++, through C++20.
+///
+/// This is [C] with C++'s keywords, including `concept`, `requires`, and the coroutine keywords, and its literals:
+/// raw strings with custom delimiters (`R"sql(...)sql"`) are single [TokenKind::String] runs however many lines they
+/// span, and user-defined literal suffixes (`"abc"s`, `10_km`) belong to their literal. A name before `::` is a
+/// namespace or class qualifier, [TokenKind::NameClass], as is a capitalized name after `::` unless `(` follows it;
+/// the names after `class`, `namespace`, `concept`, and `typename` are [TokenKind::NameClass] too. A quote inside a
+/// number is a digit separator, and elsewhere starts a character literal only if one closes on the line, so neither
+/// separators nor a `<` opening template arguments throw off the lines that follow.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Cpp;
+///
+/// let src = "template <typename T> concept Small = sizeof(T) <= 1'024;\nauto q = R\"(a \"quoted\" b)\"s;\nstd::vector<int> v = io::read();\n";
+/// let tokens = Cpp.tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("concept"), Some(TokenKind::KeywordDeclaration));
+/// assert_eq!(kind("Small"), Some(TokenKind::NameClass));
+/// assert_eq!(kind("1'024"), Some(TokenKind::Number));
+/// assert_eq!(kind("R\"(a \"quoted\" b)\"s"), Some(TokenKind::String));
+/// assert_eq!(kind("std"), Some(TokenKind::NameClass));
+/// assert_eq!(kind("read"), Some(TokenKind::NameFunction));
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct Cpp;
+
+impl Lexer for Cpp {
+    fn name(&self) -> &str {
+        "C++"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(CState::new(true))
+    }
+}
+
+/// What the next characters continue.
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Mode {
+    Code,
+    /// Inside `/* ... */`.
+    BlockComment {
+        doc: bool,
+    },
+    /// A `//` comment carried onto this line by a trailing backslash.
+    LineComment {
+        doc: bool,
+    },
+    /// A string or character literal carried onto this line by a trailing backslash.
+    Quoted {
+        quote: char,
+    },
+    /// Inside a raw string, which ends at `)`, the delimiter, and `"`.
+    Raw {
+        delimiter: String,
+    },
+}
+
+/// The preprocessor directive the line belongs to, which decides how the rest of it is lexed.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Directive {
+    /// `#include` and its kin, whose path is a string.
+    Include,
+    /// `#define`, `#undef`, `#ifdef`, and the like, before the macro they name.
+    Macro { define: bool },
+    /// A macro's parameter list, between `#define NAME(` and `)`.
+    Parameters,
+    /// Code with `#` and `##` as operators: a macro's replacement list, an `#if` condition, or `#line`.
+    Code,
+    /// `#pragma`, `#error`, and unknown directives, whose text is left as it is.
+    Text,
+}
+
+/// A declared name the next identifier may be.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Expect {
+    Nothing,
+    ClassName,
+    /// The operand of `defined`, possibly in parentheses.
+    Macro,
+    /// The operand of `__has_include`, whose `<...>` is a header name rather than comparisons.
+    Header,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct CState {
+    cpp: bool,
+    mode: Mode,
+    directive: Option<Directive>,
+    expect: Expect,
+    /// Nothing but whitespace and comments since the start of the logical line, so `#` starts a directive.
+    line_start: bool,
+    /// The line ended in a backslash, which joins it to the next.
+    spliced: bool,
+    /// The last token was `::`, `.`, or `->`, so an identifier is a member.
+    after_scope: bool,
+    after_member: bool,
+    /// Inside `[[...]]`.
+    attribute: bool,
+}
+
+impl CState {
+    fn new(cpp: bool) -> Self {
+        Self {
+            cpp,
+            mode: Mode::Code,
+            directive: None,
+            expect: Expect::Nothing,
+            line_start: true,
+            spliced: false,
+            after_scope: false,
+            after_member: false,
+            attribute: false,
+        }
+    }
+}
+
+impl LexerState for CState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        while cursor.pos < line.len() {
+            match self.mode.clone() {
+                Mode::Code => self.code(&mut cursor),
+                Mode::BlockComment { doc } => self.block_comment(&mut cursor, doc),
+                Mode::LineComment { doc } => self.line_comment(&mut cursor, doc),
+                Mode::Quoted { quote } => self.quoted(&mut cursor, quote),
+                Mode::Raw { delimiter } => self.raw(&mut cursor, &delimiter),
+            }
+        }
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl CState {
+    fn code(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+
+        if ch.is_whitespace() {
+            let len = cursor.run_until(|ch| !ch.is_whitespace());
+            if rest[..len].contains('\n') {
+                self.end_line();
+            }
+            cursor.emit(TokenKind::Whitespace, len);
+            return;
+        }
+        if ch == '\\' && (rest[1..].starts_with('\n') || rest[1..].starts_with("\r\n")) {
+            self.spliced = true;
+            let kind = if self.directive.is_some() { TokenKind::CommentPreproc } else { TokenKind::Punctuation };
+            cursor.emit(kind, 1);
+            return;
+        }
+        if rest.starts_with("//") {
+            let doc = rest.starts_with("///") || rest.starts_with("//!");
+            self.line_comment(cursor, doc);
+            return;
+        }
+        if rest.starts_with("/*") {
+            let doc = (rest.starts_with("/**") && !rest.starts_with("/**/")) || rest.starts_with("/*!");
+            cursor.emit(if doc { TokenKind::CommentDoc } else { TokenKind::Comment }, 2);
+            self.mode = Mode::BlockComment { doc };
+            return;
+        }
+        if ch == '#' && self.line_start {
+            self.directive(cursor);
+            return;
+        }
+        self.line_start = false;
+        match self.directive {
+            Some(Directive::Text) => {
+                let len = text_len(rest);
+                cursor.emit(TokenKind::CommentPreproc, len);
+                return;
+            }
+            Some(Directive::Include) if ch == '<' || ch == '"' => {
+                cursor.emit(TokenKind::String, header_len(rest));
+                return;
+            }
+            _ if self.expect == Expect::Header && ch == '<' => {
+                self.expect = Expect::Nothing;
+                cursor.emit(TokenKind::String, header_len(rest));
+                return;
+            }
+            _ => {}
+        }
+
+        let (mut after_scope, mut after_member) = (false, false);
+        let mut expect = Expect::Nothing;
+        match ch {
+            '"' | '\'' => self.literal(cursor, 0),
+            '0'..='9' => cursor.emit(TokenKind::Number, number_len(rest)),
+            '.' if rest[1..].starts_with(|ch: char| ch.is_ascii_digit()) => {
+                cursor.emit(TokenKind::Number, number_len(rest))
+            }
+            _ if ch == '_' || ch.is_alphabetic() => {
+                let len = cursor.run_until(|ch| !(ch == '_' || ch.is_alphanumeric()));
+                let word = &rest[..len];
+                if let Some(prefix) = self.string_prefix(word, &rest[len..]) {
+                    self.literal(cursor, prefix);
+                    return;
+                }
+                let kind = self.word(word, &rest[len..]);
+                if self.expect == Expect::Macro && kind == TokenKind::NameMacro {
+                    self.directive = self.directive.map(|directive| match directive {
+                        Directive::Macro { define: true } if rest[len..].starts_with('(') => Directive::Parameters,
+                        Directive::Macro { .. } => Directive::Code,
+                        directive => directive,
+                    });
+                } else if NAMES_CLASS.contains(&word) && (self.cpp || DECLARATIONS.contains(&word)) {
+                    expect = Expect::ClassName;
+                } else if word == "defined" && self.directive == Some(Directive::Code) {
+                    expect = Expect::Macro;
+                } else if matches!(word, "__has_include" | "__has_include_next") {
+                    expect = Expect::Header;
+                }
+                cursor.emit(kind, len);
+            }
+            '#' if self.directive.is_some() => {
+                let len = if rest.starts_with("##") { 2 } else { 1 };
+                cursor.emit(TokenKind::Operator, len);
+            }
+            '[' if rest.starts_with("[[") && !self.attribute => {
+                self.attribute = true;
+                cursor.emit(TokenKind::Punctuation, 2);
+            }
+            ']' if rest.starts_with("]]") && self.attribute => {
+                self.attribute = false;
+                cursor.emit(TokenKind::Punctuation, 2);
+            }
+            '(' | ')' | '{' | '}' | '[' | ']' | ';' | ',' | ':' | '.'
+                if !rest.starts_with("::") && !rest.starts_with("...") && !rest.starts_with(".*") =>
+            {
+                match ch {
+                    '(' if matches!(self.expect, Expect::Macro | Expect::Header) => expect = self.expect,
+                    ')' if self.directive == Some(Directive::Parameters) => self.directive = Some(Directive::Code),
+                    '.' => after_member = true,
+                    _ => {}
+                }
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            _ => match OPERATORS.iter().find(|operator| rest.starts_with(*operator)) {
+                Some(operator) => {
+                    after_scope = *operator == "::";
+                    after_member = *operator == "->";
+                    cursor.emit(TokenKind::Operator, operator.len());
+                }
+                None => cursor.emit(TokenKind::Error, ch.len_utf8()),
+            },
+        }
+        self.after_scope = after_scope;
+        self.after_member = after_member;
+        self.expect = expect;
+    }
+
+    /// Ends a line: a directive ends with it unless the line was spliced onto the next.
+    fn end_line(&mut self) {
+        if self.spliced {
+            self.spliced = false;
+            return;
+        }
+        if self.directive.take().is_some() {
+            self.expect = Expect::Nothing;
+        }
+        self.line_start = true;
+    }
+
+    /// Lexes the `#` and name of a directive, which set how the rest of the logical line is read.
+    fn directive(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let gap = rest[1..]
+            .find(|ch: char| ch != ' ' && ch != '\t')
+            .map_or(rest.len() - 1, |at| at);
+        let name = &rest[1 + gap..];
+        let name = &name[..name
+            .find(|ch: char| !(ch == '_' || ch.is_ascii_alphanumeric()))
+            .unwrap_or(name.len())];
+        self.line_start = false;
+        self.expect = Expect::Nothing;
+        self.directive = Some(match name {
+            "include" | "include_next" | "import" | "embed" => Directive::Include,
+            "define" => Directive::Macro { define: true },
+            "undef" | "ifdef" | "ifndef" | "elifdef" | "elifndef" => Directive::Macro { define: false },
+            "if" | "elif" | "else" | "endif" | "line" | "" => Directive::Code,
+            _ => Directive::Text,
+        });
+        let mut len = 1 + gap + name.len();
+        match self.directive {
+            Some(Directive::Macro { .. }) => self.expect = Expect::Macro,
+            Some(Directive::Text) => len += text_len(&rest[len..]),
+            _ => {}
+        }
+        cursor.emit(TokenKind::CommentPreproc, len);
+    }
+
+    /// Classifies an identifier, followed by `next`.
+    fn word(&self, word: &str, next: &str) -> TokenKind {
+        let cpp = self.cpp;
+        if self.expect == Expect::Macro {
+            return TokenKind::NameMacro;
+        }
+        if self.attribute {
+            return TokenKind::NameAttribute;
+        }
+        if cpp && next.starts_with("::") {
+            return TokenKind::NameClass;
+        }
+        if KEYWORDS.contains(&word) || (cpp && CPP_KEYWORDS.contains(&word)) {
+            TokenKind::Keyword
+        } else if DECLARATIONS.contains(&word) || (cpp && CPP_DECLARATIONS.contains(&word)) {
+            TokenKind::KeywordDeclaration
+        } else if CONSTANTS.contains(&word) {
+            TokenKind::KeywordConstant
+        } else if TYPES.contains(&word) {
+            TokenKind::KeywordType
+        } else if BUILTINS.contains(&word) || (self.directive == Some(Directive::Code) && word == "defined") {
+            TokenKind::NameBuiltin
+        } else if self.after_member {
+            if next.starts_with('(') { TokenKind::NameFunction } else { TokenKind::Name }
+        } else if next.starts_with('(') {
+            TokenKind::NameFunction
+        } else if self.expect == Expect::ClassName || (cpp && self.after_scope && word.starts_with(char::is_uppercase))
+        {
+            TokenKind::NameClass
+        } else {
+            TokenKind::Name
+        }
+    }
+
+    /// The length of `word` as a literal's prefix, when `next` starts the literal: an encoding prefix before a quote,
+    /// or in C++ a raw string's `R` after one.
+    fn string_prefix(&self, word: &str, next: &str) -> Option<usize> {
+        let encoding = matches!(word, "L" | "u" | "U" | "u8");
+        let raw = self.cpp && matches!(word, "R" | "LR" | "uR" | "UR" | "u8R");
+        let quoted = next.starts_with('"') || (encoding && next.starts_with('\''));
+        ((encoding || raw) && quoted).then_some(word.len())
+    }
+
+    /// Lexes a string or character literal whose quote comes after a `prefix`-byte prefix.
+    fn literal(&mut self, cursor: &mut Cursor, prefix: usize) {
+        let rest = cursor.rest();
+        if rest[..prefix].ends_with('R') {
+            // The delimiter is at most 16 characters, none of them spaces, parentheses, or backslashes.
+            let body = &rest[prefix + 1..];
+            let delimiter_len = body.find(|ch: char| !ch.is_ascii_graphic() || matches!(ch, '(' | ')' | '\\' | '"'));
+            match delimiter_len.filter(|&len| len <= 16 && body[len..].starts_with('(')) {
+                Some(len) => {
+                    cursor.emit(TokenKind::String, prefix + 2 + len);
+                    self.mode = Mode::Raw { delimiter: body[..len].to_owned() };
+                }
+                None => {
+                    cursor.emit(TokenKind::String, prefix);
+                    cursor.emit(TokenKind::Error, 1);
+                    self.quoted(cursor, '"');
+                }
+            }
+            return;
+        }
+
+        let quote = rest[prefix..].chars().next().expect("a literal starts with a quote");
+        if quote == '\'' && !char_closes(&rest[prefix + 1..]) {
+            cursor.emit(TokenKind::Error, prefix + 1);
+            return;
+        }
+        cursor.emit(TokenKind::String, prefix + 1);
+        self.quoted(cursor, quote);
+    }
+
+    /// Lexes the rest of a literal closed by `quote`, which ends at the quote or, unterminated, at the end of the
+    /// line; a backslash ending the line carries it on.
+    fn quoted(&mut self, cursor: &mut Cursor, quote: char) {
+        self.mode = Mode::Code;
+        loop {
+            let rest = cursor.rest();
+            match cursor.peek() {
+                None | Some('\n') => return,
+                Some(ch) if ch == quote => {
+                    cursor.emit(TokenKind::String, 1);
+                    self.suffix(cursor);
+                    return;
+                }
+                Some('\\') if rest[1..].starts_with('\n') || rest[1..].starts_with("\r\n") => {
+                    cursor.emit(TokenKind::String, rest.len());
+                    self.mode = Mode::Quoted { quote };
+                    return;
+                }
+                Some('\\') => emit_escape(cursor, self.cpp),
+                Some(_) => {
+                    let len = rest.find([quote, '\\', '\n']).unwrap_or(rest.len());
+                    cursor.emit(TokenKind::String, len);
+                }
+            }
+        }
+    }
+
+    fn raw(&mut self, cursor: &mut Cursor, delimiter: &str) {
+        let rest = cursor.rest();
+        let closing = format!("){delimiter}\"");
+        match rest.find(&closing) {
+            Some(end) => {
+                cursor.emit(TokenKind::String, end + closing.len());
+                self.mode = Mode::Code;
+                self.suffix(cursor);
+            }
+            None => cursor.emit(TokenKind::String, rest.len()),
+        }
+    }
+
+    /// Lexes a C++ user-defined literal suffix right after a closing quote as part of the literal.
+    fn suffix(&mut self, cursor: &mut Cursor) {
+        if self.cpp && cursor.peek().is_some_and(|ch| ch == '_' || ch.is_alphabetic()) {
+            let len = cursor.run_until(|ch| !(ch == '_' || ch.is_alphanumeric()));
+            cursor.emit(TokenKind::String, len);
+        }
+    }
+
+    /// Lexes a `//` comment to the end of the line, taking the line break with it when a backslash carries the
+    /// comment on.
+    fn line_comment(&mut self, cursor: &mut Cursor, doc: bool) {
+        let rest = cursor.rest();
+        let len = rest.find('\n').unwrap_or(rest.len());
+        let spliced = rest[..len].trim_end_matches('\r').ends_with('\\') && len < rest.len();
+        self.mode = if spliced { Mode::LineComment { doc } } else { Mode::Code };
+        let kind = if doc { TokenKind::CommentDoc } else { TokenKind::Comment };
+        cursor.emit(kind, if spliced { rest.len() } else { len });
+    }
+
+    fn block_comment(&mut self, cursor: &mut Cursor, doc: bool) {
+        let kind = if doc { TokenKind::CommentDoc } else { TokenKind::Comment };
+        match cursor.rest().find("*/") {
+            Some(end) => {
+                cursor.emit(kind, end + 2);
+                self.mode = Mode::Code;
+            }
+            None => {
+                // The line break is part of the comment, so it doesn't end a directive the comment is in.
+                let len = cursor.rest().len();
+                cursor.emit(kind, len);
+            }
+        }
+    }
+}
+
+/// Length of the header name `rest` starts with, in quotes or angle brackets; unterminated, it runs to the end of the
+/// line.
+fn header_len(rest: &str) -> usize {
+    let close = if rest.starts_with('<') { '>' } else { '"' };
+    rest[1..].find(close).map_or(rest.trim_end().len(), |end| end + 2)
+}
+
+/// Length of a directive's text before a comment, a line splice, or the line break.
+fn text_len(rest: &str) -> usize {
+    let mut len = rest.find(['\n', '/', '\\']).unwrap_or(rest.len());
+    while len < rest.len() {
+        let at = &rest[len..];
+        let stops = at.starts_with("//") || at.starts_with("/*") || at.starts_with('\n') || {
+            let after = &at[1..];
+            at.starts_with('\\') && (after.starts_with('\n') || after.starts_with("\r\n"))
+        };
+        if stops {
+            break;
+        }
+        len += 1 + at[1..].find(['\n', '/', '\\']).unwrap_or(at.len() - 1);
+    }
+    // Trailing whitespace, and the `\r` of a CRLF, stay whitespace.
+    rest[..len].trim_end().len()
+}
+
+/// Whether the text after an opening `'` closes on this line as a character literal: a run of characters or escapes
+/// followed by `'`.
+fn char_closes(body: &str) -> bool {
+    let mut chars = body.chars();
+    let mut empty = true;
+    while let Some(ch) = chars.next() {
+        match ch {
+            '\'' => return !empty,
+            '\n' => return false,
+            '\\' => {
+                chars.next();
+            }
+            _ => {}
+        }
+        empty = false;
+    }
+    false
+}
+
+/// Emits the escape sequence at the cursor, or the backslash and next character as an error.
+fn emit_escape(cursor: &mut Cursor, cpp: bool) {
+    let rest = cursor.rest();
+    match escape_len(rest, cpp) {
+        Some(len) => cursor.emit(TokenKind::StringEscape, len),
+        None => {
+            let next = rest[1..].chars().next().filter(|&ch| ch != '\n');
+            cursor.emit(TokenKind::Error, 1 + next.map_or(0, char::len_utf8));
+        }
+    }
+}
+
+/// Length of the escape sequence `rest` starts with, or `None` when it isn't a valid one.
+fn escape_len(rest: &str, cpp: bool) -> Option<usize> {
+    let run = |from: usize, max: usize, radix: u32| {
+        let digits = rest[from..]
+            .chars()
+            .take(max)
+            .take_while(|ch| ch.is_digit(radix))
+            .count();
+        (digits > 0).then_some(from + digits)
+    };
+    let exact = |len: usize| {
+        rest.get(2..2 + len)?
+            .chars()
+            .all(|ch| ch.is_ascii_hexdigit())
+            .then_some(2 + len)
+    };
+    let next = rest[1..].chars().next()?;
+    // C++23's delimited escapes: `\x{41}`, `\o{101}`, `\u{1F600}`, and `\N{LATIN SMALL LETTER A}`.
+    if cpp && matches!(next, 'x' | 'o' | 'u' | 'N') && rest[2..].starts_with('{') {
+        return rest.find('}').filter(|&end| end > 3).map(|end| end + 1);
+    }
+    match next {
+        'a' | 'b' | 'f' | 'n' | 'r' | 't' | 'v' | 'e' | 'E' | '\\' | '\'' | '"' | '?' => Some(2),
+        'x' => run(2, usize::MAX, 16),
+        'u' => exact(4),
+        'U' => exact(8),
+        '0'..='7' => run(1, 3, 8),
+        _ => None,
+    }
+}
+
+/// Length of the number literal `rest` starts with: decimal, hex (with `p` exponents), octal, or binary, with digit
+/// separators and any suffix, a C++ user-defined one included.
+fn number_len(rest: &str) -> usize {
+    let bytes = rest.as_bytes();
+    let hex = rest.starts_with("0x") || rest.starts_with("0X");
+    let mut len = 0;
+    while let Some(&byte) = bytes.get(len) {
+        let exponent_sign = matches!(byte, b'+' | b'-')
+            && len > 0
+            && if hex { matches!(bytes[len - 1], b'p' | b'P') } else { matches!(bytes[len - 1], b'e' | b'E') };
+        let separator = byte == b'\''
+            && len > 0
+            && bytes[len - 1].is_ascii_alphanumeric()
+            && bytes.get(len + 1).is_some_and(u8::is_ascii_alphanumeric);
+        if byte.is_ascii_alphanumeric() || byte == b'_' || byte == b'.' || exponent_sign || separator {
+            len += 1;
+        } else {
+            break;
+        }
+    }
+    len
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::assert_fixture_tokens;
+
+    /// Non-whitespace tokens as (kind, text) pairs.
+    fn kinds(lexer: &dyn Lexer, src: &str) -> Vec<(TokenKind, String)> {
+        let tokens = lexer.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src).to_owned()))
+            .collect()
+    }
+
+    fn texts(lexer: &dyn Lexer, src: &str, kind: TokenKind) -> Vec<String> {
+        kinds(lexer, src)
+            .into_iter()
+            .filter(|(k, _)| *k == kind)
+            .map(|(_, text)| text)
+            .collect()
+    }
+
+    #[test]
+    fn directives_extend_over_spliced_lines() {
+        let src = "#include <sys/types.h>\n#  include \"local.h\" // ours\n#define MAX(a, b) \\\n  ((a) > (b) ? (a) : (b))\nint x = MAX(1, 2);\n#pragma once\n#pragma omp parallel \\\n  for\n#error \"no\" /* why */\n";
+        assert_eq!(
+            texts(&C, src, TokenKind::CommentPreproc),
+            [
+                "#include",
+                "#  include",
+                "#define",
+                "\\",
+                "#pragma once",
+                "#pragma omp parallel",
+                "\\",
+                "for",
+                "#error \"no\""
+            ]
+        );
+        assert_eq!(texts(&C, src, TokenKind::String), ["<sys/types.h>", "\"local.h\""]);
+        assert_eq!(texts(&C, src, TokenKind::NameMacro), ["MAX"]);
+        // The parameters and replacement list are code, and so is the line after the directive.
+        assert_eq!(texts(&C, src, TokenKind::Name), ["a", "b", "a", "b", "a", "b", "x"]);
+        assert_eq!(texts(&C, src, TokenKind::NameFunction), ["MAX"]);
+        assert_eq!(texts(&C, src, TokenKind::Comment), ["// ours", "/* why */"]);
+    }
+
+    #[test]
+    fn conditionals_name_their_macros() {
+        let src = "#ifdef __linux__\n#if defined(FOO) && !defined BAR && VERSION >= 2\n#  define STR(x) #x\n#  define CAT(a, b) a ## b\n#elif __has_include(<optional>)\n#endif // FOO\n";
+        assert_eq!(
+            texts(&C, src, TokenKind::CommentPreproc),
+            ["#ifdef", "#if", "#  define", "#  define", "#elif", "#endif"]
+        );
+        assert_eq!(
+            texts(&C, src, TokenKind::NameMacro),
+            ["__linux__", "FOO", "BAR", "STR", "CAT"]
+        );
+        assert_eq!(
+            texts(&C, src, TokenKind::NameBuiltin),
+            ["defined", "defined", "__has_include"]
+        );
+        assert_eq!(
+            texts(&C, src, TokenKind::Name),
+            ["VERSION", "x", "x", "a", "b", "a", "b"]
+        );
+        assert_eq!(texts(&C, src, TokenKind::Operator), ["&&", "!", "&&", ">=", "#", "##"]);
+        assert_eq!(texts(&C, src, TokenKind::String), ["<optional>"]);
+        // `#` only opens a directive at the start of a line.
+        assert_eq!(texts(&C, "x # y\n", TokenKind::Error), ["#"]);
+    }
+
+    #[test]
+    fn raw_strings_use_their_delimiters_across_lines() {
+        let src = "auto sql = R\"sql(SELECT \")\" FROM t\nWHERE a = ')';)sql\"_q;\nauto e = u8R\"(é)\"; auto bad = R\"a b(x)a b\";\n";
+        assert_eq!(
+            texts(&Cpp, src, TokenKind::String),
+            [
+                "R\"sql(SELECT \")\" FROM t\nWHERE a = ')';)sql\"_q",
+                "u8R\"(é)\"",
+                "R",
+                "a b(x)a b\""
+            ]
+        );
+        // In C, `R` is just a name before an ordinary string.
+        assert_eq!(texts(&C, "R\"(x)\"", TokenKind::Name), ["R"]);
+        // A delimiter with a space in it is an error, and the string is read as an ordinary one.
+        assert_eq!(texts(&Cpp, src, TokenKind::Error), ["\""]);
+    }
+
+    #[test]
+    fn literals_keep_prefixes_separators_and_suffixes() {
+        let src = "auto n = 1'000'000ULL + 0x1p-3f + 0b1010'1010 + 10_km + 1.5e+3_deg;\nchar c = 'x', d = '\\'', e = L'\\x4f60', f = u8'a';\nauto s = \"tab\\there\\u00e9\\q\"s + U\"\\x{1F600}\";\nbool lt = a<'b'>c;\n";
+        assert_eq!(
+            texts(&Cpp, src, TokenKind::Number),
+            ["1'000'000ULL", "0x1p-3f", "0b1010'1010", "10_km", "1.5e+3_deg"]
+        );
+        assert_eq!(
+            texts(&Cpp, src, TokenKind::StringEscape),
+            ["\\'", "\\x4f60", "\\t", "\\u00e9", "\\x{1F600}"]
+        );
+        assert_eq!(texts(&Cpp, src, TokenKind::Error), ["\\q"]);
+        let strings = texts(&Cpp, src, TokenKind::String);
+        assert!(strings.contains(&"L'".to_owned()) && strings.contains(&"u8'a'".to_owned()));
+        assert!(
+            strings.contains(&"\"s".to_owned()),
+            "the suffix belongs to the string: {strings:?}"
+        );
+        assert!(strings.contains(&"'b'".to_owned()));
+        assert_eq!(texts(&Cpp, src, TokenKind::Operator).last().unwrap(), ">");
+
+        // A quote that can't close a character literal is an error, not the start of one that swallows the line.
+        assert_eq!(texts(&C, "x = ' ;\ny = 1;\n", TokenKind::Error), ["'"]);
+        assert_eq!(texts(&C, "char *s = \"a\\\nb\";\n", TokenKind::String), ["\"a\\\nb\""]);
+    }
+
+    #[test]
+    fn scopes_classes_and_functions() {
+        let src = "namespace io { class Reader final : public Base { public: [[nodiscard]] std::size_t read(); }; }\nauto io::Reader::read() -> std::size_t { return this->buffer.size() + Traits::Max + co_await next(); }\ntemplate <typename T> requires std::integral<T> struct Box;\n";
+        assert_eq!(
+            texts(&Cpp, src, TokenKind::NameClass),
+            [
+                "io", "Reader", "std", "io", "Reader", "std", "Traits", "Max", "T", "std", "Box"
+            ]
+        );
+        assert_eq!(
+            texts(&Cpp, src, TokenKind::NameFunction),
+            ["read", "read", "size", "next"]
+        );
+        assert_eq!(texts(&Cpp, src, TokenKind::NameAttribute), ["nodiscard"]);
+        assert!(texts(&Cpp, src, TokenKind::Keyword).contains(&"co_await".to_owned()));
+        assert!(texts(&Cpp, src, TokenKind::Keyword).contains(&"requires".to_owned()));
+        // C has none of C++'s keywords, and no `::` qualifiers to look for.
+        assert_eq!(texts(&C, "int class = this;\n", TokenKind::Name), ["class", "this"]);
+        assert_eq!(texts(&C, "struct point { int x; };\n", TokenKind::NameClass), ["point"]);
+    }
+
+    #[test]
+    fn spliced_line_comments_continue() {
+        let src = "// one \\\n   still a comment\nint x; /// doc\n/** block\n */\n";
+        assert_eq!(texts(&C, src, TokenKind::Comment), ["// one \\\n   still a comment"]);
+        assert_eq!(texts(&C, src, TokenKind::CommentDoc), ["/// doc", "/** block\n */"]);
+        assert_eq!(texts(&C, src, TokenKind::KeywordType), ["int"]);
+    }
+
+    #[test]
+    fn header_matches_golden_tokens() {
+        // A header mixing macros, templates, and raw strings, the hardest mix for C++ highlighters.
+        assert_fixture_tokens(&Cpp, "query.hpp");
+    }
+}
@@ -0,0 +1,699 @@
+//! Importer for Chroma's XML lexer definitions.
+
+use super::registry::LexerConfig;
+use super::rules::{Rule, RuleError, RuleLexer, RuleTable};
+use crate::highlight::TokenKind;
+use crate::highlight::themes::plist::decode_entities;
+
+use std::collections::BTreeMap;
+use std::fmt;
+use std::io::{self, Read};
+
+/// Chroma token type prefixes mapped to token kinds, most specific first.
+///
+/// Chroma's types form a hierarchy spelled out in their names (`LiteralStringDouble` is a `LiteralString`), so the
+/// longest listed prefix decides.
+const CHROMA_KINDS: &[(&str, TokenKind)] = &[
+    ("TextWhitespace", TokenKind::Whitespace),
+    ("TextPunctuation", TokenKind::Punctuation),
+    ("Text", TokenKind::Text),
+    ("Whitespace", TokenKind::Whitespace),
+    ("Other", TokenKind::Text),
+    ("Error", TokenKind::Error),
+    ("KeywordConstant", TokenKind::KeywordConstant),
+    ("KeywordDeclaration", TokenKind::KeywordDeclaration),
+    ("KeywordType", TokenKind::KeywordType),
+    ("Keyword", TokenKind::Keyword),
+    ("NameBuiltin", TokenKind::NameBuiltin),
+    ("NameFunctionMagic", TokenKind::NameMacro),
+    ("NameFunction", TokenKind::NameFunction),
+    ("NameClass", TokenKind::NameClass),
+    ("NameException", TokenKind::NameClass),
+    ("NameNamespace", TokenKind::NameClass),
+    ("NameTag", TokenKind::NameTag),
+    ("NameAttribute", TokenKind::NameAttribute),
+    ("NameProperty", TokenKind::NameAttribute),
+    ("NameDecorator", TokenKind::NameAttribute),
+    ("NameVariable", TokenKind::NameVariable),
+    ("NameConstant", TokenKind::NameConstant),
+    ("NameEntity", TokenKind::NameConstant),
+    ("NameLabel", TokenKind::NameLabel),
+    ("Name", TokenKind::Name),
+    ("LiteralStringRegex", TokenKind::StringRegex),
+    ("LiteralStringEscape", TokenKind::StringEscape),
+    ("LiteralStringInterpol", TokenKind::StringEscape),
+    ("LiteralStringBacktick", TokenKind::StringBacktick),
+    ("LiteralString", TokenKind::String),
+    ("LiteralNumber", TokenKind::Number),
+    ("LiteralDate", TokenKind::NumberDate),
+    ("Literal", TokenKind::String),
+    ("OperatorWord", TokenKind::Keyword),
+    ("Operator", TokenKind::Operator),
+    ("Punctuation", TokenKind::Punctuation),
+    ("CommentPreproc", TokenKind::CommentPreproc),
+    ("CommentHashbang", TokenKind::CommentPreproc),
+    ("Comment", TokenKind::Comment),
+    ("GenericHeading", TokenKind::GenericHeading),
+    ("GenericSubheading", TokenKind::GenericSubheading),
+    ("GenericInserted", TokenKind::GenericInserted),
+    ("GenericDeleted", TokenKind::GenericDeleted),
+    ("GenericEmph", TokenKind::GenericEmph),
+    ("GenericStrong", TokenKind::GenericStrong),
+    ("GenericError", TokenKind::Error),
+    ("GenericTraceback", TokenKind::Error),
+    ("Generic", TokenKind::Text),
+];
+
+/// Errors raised while importing a Chroma lexer definition.
+#[derive(Debug)]
+pub enum ChromaError {
+    Io(io::Error),
+    /// The document isn't well-formed XML or isn't a `<lexer>` definition.
+    Parse(String),
+    /// A rule uses something that can't be imported, or its pattern doesn't compile.
+    Rules(RuleError),
+}
+
+impl fmt::Display for ChromaError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            ChromaError::Io(source) => write!(f, "failed to read Chroma lexer: {source}"),
+            ChromaError::Parse(message) => write!(f, "failed to parse Chroma lexer: {message}"),
+            ChromaError::Rules(source) => write!(f, "failed to import Chroma lexer: {source}"),
+        }
+    }
+}
+
+impl std::error::Error for ChromaError {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        match self {
+            ChromaError::Io(source) => Some(source),
+            ChromaError::Rules(source) => Some(source),
+            ChromaError::Parse(_) => None,
+        }
+    }
+}
+
+impl From<io::Error> for ChromaError {
+    fn from(source: io::Error) -> Self {
+        ChromaError::Io(source)
+    }
+}
+
+/// Loads a lexer definition in Chroma's XML format, ready to pass to [super::register] or
+/// [LexerConfig::into_lexer].
+///
+/// The `<config>` block supplies the name, aliases, file name patterns, and MIME types, and `case_insensitive` and
+/// `dot_all` become flags on every pattern. Each `<state>` becomes a [RuleTable] state: rules can emit a `<token>` or
+/// `<bygroups>`, and move with `<push>` (several `state` attributes push several states; none repeats the current
+/// one), `<pop depth>`, `<include>`, and `<combined>`, alone or wrapped in `<mutators>`. Chroma's token types map
+/// onto the nearest [TokenKind] (`LiteralStringDouble` to [TokenKind::String], `NameBuiltinPseudo` to
+/// [TokenKind::NameBuiltin]).
+///
+/// Chroma patterns use .NET regular expression syntax. Inline `s` and `m` flags are translated for Oniguruma; balancing
+/// groups, conditionals, character class subtraction, and explicit-capture mode have no equivalent, so rules using
+/// them are rejected, as are rules that delegate to other lexers (`<using>`, `<usingself>`, `<usingbygroup>`). Errors
+/// name the state and rule they come from.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::load_chroma_xml;
+///
+/// let xml = r#"<lexer>
+///   <config><name>Notes</name><alias>note</alias><filename>*.note</filename></config>
+///   <rules>
+///     <state name="root">
+///       <rule pattern=";.*$"><token type="CommentSingle"/></rule>
+///       <rule pattern="[^;]+"><token type="Text"/></rule>
+///     </state>
+///   </rules>
+/// </lexer>"#;
+/// let lexer = load_chroma_xml(xml.as_bytes()).unwrap().into_lexer().unwrap();
+/// let tokens = lexer.tokenize("todo ; later\n").unwrap();
+/// assert_eq!(tokens[1].kind, TokenKind::Comment);
+/// ```
+pub fn load_chroma_xml(mut reader: impl Read) -> Result<LexerConfig, ChromaError> {
+    let mut text = String::new();
+    reader.read_to_string(&mut text)?;
+    parse_chroma_xml(&text)
+}
+
+fn parse_chroma_xml(text: &str) -> Result<LexerConfig, ChromaError> {
+    let root = Element::parse(text)?;
+    if root.name != "lexer" {
+        return Err(ChromaError::Parse(format!("expected <lexer>, found <{}>", root.name)));
+    }
+    let config = root
+        .child("config")
+        .ok_or_else(|| ChromaError::Parse("missing <config>".to_string()))?;
+    let name = config
+        .child("name")
+        .map(|name| name.text.trim())
+        .filter(|name| !name.is_empty())
+        .ok_or_else(|| ChromaError::Parse("missing <name> in <config>".to_string()))?;
+    let flag = |key: &str| config.child(key).is_some_and(|value| value.text.trim() == "true");
+    let flags = match (flag("case_insensitive"), flag("dot_all")) {
+        (false, false) => "",
+        (true, false) => "(?i)",
+        // Oniguruma's Ruby syntax spells dotall `m`.
+        (false, true) => "(?m)",
+        (true, true) => "(?im)",
+    };
+
+    let rules = root
+        .child("rules")
+        .ok_or_else(|| ChromaError::Parse("missing <rules>".to_string()))?;
+    let mut importer = Importer { flags, states: BTreeMap::new() };
+    for state in rules.children_named("state") {
+        let state_name = state
+            .attribute("name")
+            .ok_or_else(|| ChromaError::Parse("a <state> has no name".to_string()))?;
+        let imported = state
+            .children_named("rule")
+            .enumerate()
+            .map(|(index, rule)| importer.rule(state_name, index + 1, rule))
+            .collect::<Result<_, _>>()
+            .map_err(ChromaError::Rules)?;
+        importer.states.insert(state_name.to_string(), imported);
+    }
+
+    let mut table = RuleTable::new();
+    for (state, rules) in importer.states {
+        table = table.with_state(&state, rules);
+    }
+    // Compiling up front reports bad patterns now, by state and pattern, rather than at registration.
+    let lexer = RuleLexer::new(name, &table).map_err(ChromaError::Rules)?;
+
+    let mut imported = LexerConfig::new(name);
+    for alias in config.children_named("alias") {
+        imported = imported.with_alias(alias.text.trim());
+    }
+    for pattern in config.children_named("filename") {
+        imported = imported.with_filename(pattern.text.trim());
+    }
+    for mime_type in config.children_named("mime_type") {
+        imported = imported.with_mime_type(mime_type.text.trim());
+    }
+    Ok(imported.with_lexer(lexer))
+}
+
+/// Converts Chroma rules, collecting the states that `<combined>` synthesizes along the way.
+struct Importer {
+    flags: &'static str,
+    states: BTreeMap<String, Vec<Rule>>,
+}
+
+impl Importer {
+    fn rule(&mut self, state: &str, index: usize, element: &Element) -> Result<Rule, RuleError> {
+        let fail = |message: String| RuleError::new(format!("state {state:?} rule {index}: {message}"));
+        if let Some(include) = element.child("include") {
+            let target = include
+                .attribute("state")
+                .ok_or_else(|| fail("<include> has no state".into()))?;
+            return Ok(Rule::include(target));
+        }
+
+        let pattern = match element.attribute("pattern") {
+            Some(pattern) => {
+                let translated =
+                    translate_pattern(pattern).map_err(|message| fail(format!("pattern {pattern:?}: {message}")))?;
+                format!("{}{translated}", self.flags)
+            }
+            None => String::new(),
+        };
+        let mut rule = Rule::by_groups(&pattern, &[]);
+
+        for child in &element.children {
+            match child.name.as_str() {
+                "token" => rule = rule.with_token(chroma_kind(child).map_err(fail)?),
+                "bygroups" => {
+                    let mut kinds = Vec::new();
+                    for group in &child.children {
+                        match group.name.as_str() {
+                            "token" => kinds.push(chroma_kind(group).map_err(fail)?),
+                            other => return Err(fail(format!("<{other}> inside <bygroups> is not supported"))),
+                        }
+                    }
+                    rule = rule.with_groups(&kinds);
+                }
+                "mutators" => {
+                    for mutator in &child.children {
+                        rule = self.mutate(state, rule, mutator).map_err(fail)?;
+                    }
+                }
+                "push" | "pop" | "combined" => rule = self.mutate(state, rule, child).map_err(fail)?,
+                other => return Err(fail(format!("<{other}> is not supported"))),
+            }
+        }
+        Ok(rule)
+    }
+
+    /// Applies one `<push>`, `<pop>`, or `<combined>` to `rule`.
+    fn mutate(&mut self, state: &str, rule: Rule, mutator: &Element) -> Result<Rule, String> {
+        match mutator.name.as_str() {
+            "push" => {
+                let targets = mutator.attributes_named("state").collect::<Vec<_>>();
+                if targets.is_empty() {
+                    return Ok(rule.with_push(state));
+                }
+                Ok(targets.into_iter().fold(rule, |rule, target| match target {
+                    "#push" => rule.with_push(state),
+                    target => rule.with_push(target),
+                }))
+            }
+            "pop" => {
+                let depth = mutator.attribute("depth").unwrap_or("1");
+                let depth = depth.parse().map_err(|_| format!("<pop> has a bad depth {depth:?}"))?;
+                Ok(rule.with_pop(depth))
+            }
+            "combined" => {
+                let parts: Vec<&str> = mutator.attributes_named("state").collect();
+                if parts.is_empty() {
+                    return Err("<combined> names no states".to_string());
+                }
+                let combined = parts.join("+");
+                self.states
+                    .entry(combined.clone())
+                    .or_insert_with(|| parts.iter().map(|part| Rule::include(part)).collect());
+                Ok(rule.with_push(&combined))
+            }
+            other => Err(format!("<{other}> is not supported")),
+        }
+    }
+}
+
+/// Maps a `<token type="...">` onto a token kind.
+fn chroma_kind(token: &Element) -> Result<TokenKind, String> {
+    let name = token.attribute("type").ok_or("<token> has no type")?;
+    CHROMA_KINDS
+        .iter()
+        .find(|(prefix, _)| name.starts_with(prefix))
+        .map(|&(_, kind)| kind)
+        .ok_or_else(|| format!("unknown token type {name:?}"))
+}
+
+/// Rewrites a .NET-flavored pattern for Oniguruma's Ruby syntax, or explains why it can't be.
+///
+/// The flavors agree on nearly everything Chroma lexers use. The exception is inline flags: .NET's `s` (dot matches
+/// newline) is Ruby's `m`, and .NET's `m` (`^` and `$` at line breaks) is always on in Ruby, so it is dropped.
+fn translate_pattern(pattern: &str) -> Result<String, String> {
+    let mut out = String::with_capacity(pattern.len());
+    let mut chars = pattern.char_indices().peekable();
+    let mut in_class = false;
+    while let Some((index, ch)) = chars.next() {
+        match ch {
+            '\\' => {
+                out.push(ch);
+                if let Some((_, escaped)) = chars.next() {
+                    out.push(escaped);
+                }
+            }
+            '[' if !in_class => {
+                in_class = true;
+                out.push(ch);
+                // A `]` right after the opening (or after `^`) is a literal member, not the end of the class.
+                if let Some(&(_, '^')) = chars.peek() {
+                    out.push('^');
+                    chars.next();
+                }
+                if let Some(&(_, ']')) = chars.peek() {
+                    out.push(']');
+                    chars.next();
+                }
+            }
+            '-' if in_class && pattern[index + 1..].starts_with('[') => {
+                return Err("character class subtraction is not supported".to_string());
+            }
+            ']' if in_class => {
+                in_class = false;
+                out.push(ch);
+            }
+            '(' if !in_class && pattern[index + 1..].starts_with('?') => {
+                let rest = &pattern[index + 2..];
+                let consumed = translate_group(rest, &mut out)?;
+                // Skip the `?` and whatever translate_group consumed after it.
+                for _ in 0..=rest[..consumed].chars().count() {
+                    chars.next();
+                }
+            }
+            _ => out.push(ch),
+        }
+    }
+    Ok(out)
+}
+
+/// Translates the opening of a `(?...` group, given the text after `(?`; returns how many bytes of it were consumed.
+fn translate_group(rest: &str, out: &mut String) -> Result<usize, String> {
+    if rest.starts_with('(') {
+        return Err("conditional groups are not supported".to_string());
+    }
+    if let Some(name) = rest.strip_prefix('<').or_else(|| rest.strip_prefix('\'')) {
+        // `(?<=` and `(?<!` are lookbehinds; anything else is a named group, which must not be a balancing one.
+        if !name.starts_with(['=', '!']) {
+            let end = name.find(['>', '\'']).unwrap_or(name.len());
+            if name[..end].contains('-') {
+                return Err("balancing groups are not supported".to_string());
+            }
+        }
+        out.push_str("(?");
+        return Ok(0);
+    }
+
+    let flags_end = rest
+        .find(|ch: char| !matches!(ch, 'i' | 'm' | 's' | 'x' | 'n' | '-'))
+        .unwrap_or(rest.len());
+    let terminator = rest[flags_end..].chars().next();
+    if flags_end == 0 || !matches!(terminator, Some(')' | ':')) {
+        out.push_str("(?");
+        return Ok(0);
+    }
+    let flags = &rest[..flags_end];
+    if flags.contains('n') {
+        return Err("explicit capture (the n flag) is not supported".to_string());
+    }
+    let (on, off) = flags.split_once('-').unwrap_or((flags, ""));
+    let ruby = |flags: &str| -> String {
+        flags
+            .chars()
+            .filter_map(|flag| match flag {
+                's' => Some('m'),
+                'm' => None,
+                flag => Some(flag),
+            })
+            .collect()
+    };
+    let (on, off) = (ruby(on), ruby(off));
+    let terminator = terminator.expect("checked above");
+    match (on.is_empty() && off.is_empty(), terminator) {
+        // Nothing left to set: drop a bare flag group, keep a scoped one as a plain group.
+        (true, ')') => {}
+        (true, _) => out.push_str("(?:"),
+        (false, _) => {
+            out.push_str("(?");
+            out.push_str(&on);
+            if !off.is_empty() {
+                out.push('-');
+                out.push_str(&off);
+            }
+            out.push(terminator);
+        }
+    }
+    Ok(flags_end + 1)
+}
+
+/// A parsed XML element; Chroma definitions need nothing beyond elements, attributes, and text.
+#[derive(Debug, Default)]
+struct Element {
+    name: String,
+    attributes: Vec<(String, String)>,
+    children: Vec<Element>,
+    text: String,
+}
+
+impl Element {
+    fn parse(text: &str) -> Result<Element, ChromaError> {
+        let mut parser = XmlParser { text, pos: 0 };
+        parser.skip_misc();
+        parser.element()
+    }
+
+    fn child<'a>(&'a self, name: &'a str) -> Option<&'a Element> {
+        self.children_named(name).next()
+    }
+
+    fn children_named<'a>(&'a self, name: &'a str) -> impl Iterator<Item = &'a Element> {
+        self.children.iter().filter(move |child| child.name == name)
+    }
+
+    fn attribute<'a>(&'a self, name: &'a str) -> Option<&'a str> {
+        self.attributes_named(name).next()
+    }
+
+    /// Values of every attribute called `name`; Chroma repeats `state` to list several states.
+    fn attributes_named<'a>(&'a self, name: &'a str) -> impl Iterator<Item = &'a str> {
+        self.attributes
+            .iter()
+            .filter(move |(key, _)| key == name)
+            .map(|(_, value)| value.as_str())
+    }
+}
+
+struct XmlParser<'a> {
+    text: &'a str,
+    pos: usize,
+}
+
+impl<'a> XmlParser<'a> {
+    fn rest(&self) -> &'a str {
+        &self.text[self.pos..]
+    }
+
+    fn error(&self, message: impl Into<String>) -> ChromaError {
+        ChromaError::Parse(format!("{} at byte {}", message.into(), self.pos))
+    }
+
+    /// Skips whitespace, comments, the XML declaration, and doctype.
+    fn skip_misc(&mut self) {
+        loop {
+            let trimmed = self.rest().trim_start();
+            self.pos = self.text.len() - trimmed.len();
+            let end = if trimmed.starts_with("<!--") {
+                trimmed.find("-->").map(|i| i + 3)
+            } else if trimmed.starts_with("<?") {
+                trimmed.find("?>").map(|i| i + 2)
+            } else if trimmed.starts_with("<!DOCTYPE") {
+                trimmed.find('>').map(|i| i + 1)
+            } else {
+                return;
+            };
+            self.pos += end.unwrap_or(trimmed.len());
+        }
+    }
+
+    /// Reads an element starting at `<`, with its attributes, text, and children.
+    fn element(&mut self) -> Result<Element, ChromaError> {
+        let rest = self.rest();
+        if !rest.starts_with('<') || rest.starts_with("</") {
+            return Err(self.error("expected an element"));
+        }
+        self.pos += 1;
+        let name_len = self
+            .rest()
+            .find(|ch: char| ch.is_whitespace() || ch == '/' || ch == '>')
+            .ok_or_else(|| self.error("unterminated tag"))?;
+        let mut element = Element { name: self.rest()[..name_len].to_string(), ..Element::default() };
+        self.pos += name_len;
+
+        loop {
+            self.pos = self.text.len() - self.rest().trim_start().len();
+            let rest = self.rest();
+            if rest.starts_with("/>") {
+                self.pos += 2;
+                return Ok(element);
+            }
+            if rest.starts_with('>') {
+                self.pos += 1;
+                break;
+            }
+            let eq = rest.find('=').ok_or_else(|| self.error("expected an attribute"))?;
+            let key = rest[..eq].trim().to_string();
+            self.pos += eq + 1;
+            self.pos = self.text.len() - self.rest().trim_start().len();
+            let quote = self
+                .rest()
+                .chars()
+                .next()
+                .filter(|&ch| ch == '"' || ch == '\'')
+                .ok_or_else(|| self.error(format!("attribute {key} is not quoted")))?;
+            let value_len = self.rest()[1..]
+                .find(quote)
+                .ok_or_else(|| self.error(format!("unterminated attribute {key}")))?;
+            let mut value = String::new();
+            decode_entities(&self.rest()[1..1 + value_len], &mut value);
+            element.attributes.push((key, value));
+            self.pos += value_len + 2;
+        }
+
+        loop {
+            let rest = self.rest();
+            if let Some(cdata) = rest.strip_prefix("<![CDATA[") {
+                let end = cdata.find("]]>").ok_or_else(|| self.error("unterminated CDATA"))?;
+                element.text.push_str(&cdata[..end]);
+                self.pos += "<![CDATA[".len() + end + 3;
+            } else if let Some(comment) = rest.strip_prefix("<!--") {
+                let end = comment.find("-->").ok_or_else(|| self.error("unterminated comment"))?;
+                self.pos += 4 + end + 3;
+            } else if let Some(close) = rest.strip_prefix("</") {
+                let end = close.find('>').ok_or_else(|| self.error("unterminated tag"))?;
+                if close[..end].trim() != element.name {
+                    return Err(self.error(format!("expected </{}>", element.name)));
+                }
+                self.pos += 2 + end + 1;
+                return Ok(element);
+            } else if rest.starts_with('<') {
+                let child = self.element()?;
+                element.children.push(child);
+            } else if rest.is_empty() {
+                return Err(self.error(format!("unclosed <{}>", element.name)));
+            } else {
+                let end = rest.find('<').unwrap_or(rest.len());
+                decode_entities(&rest[..end], &mut element.text);
+                self.pos += end;
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::{Registry, assert_fixture_tokens};
+
+    const ELIXIR: &str = include_str!("../../../../examples/lexers/elixir.xml");
+    const KOTLIN: &str = include_str!("../../../../examples/lexers/kotlin.xml");
+
+    fn lexer(xml: &str) -> Box<dyn crate::highlight::Lexer> {
+        load_chroma_xml(xml.as_bytes()).unwrap().into_lexer().unwrap()
+    }
+
+    fn import_error(xml: &str) -> String {
+        match load_chroma_xml(xml.as_bytes()) {
+            Ok(_) => panic!("expected the import to fail"),
+            Err(err) => err.to_string(),
+        }
+    }
+
+    /// A one-state lexer around `rules`.
+    fn definition(rules: &str) -> String {
+        format!(r#"<lexer><config><name>Test</name></config><rules><state name="root">{rules}</state></rules></lexer>"#)
+    }
+
+    #[test]
+    fn elixir_matches_golden_tokens() {
+        assert_fixture_tokens(lexer(ELIXIR).as_ref(), "sample.ex");
+    }
+
+    #[test]
+    fn kotlin_matches_golden_tokens() {
+        assert_fixture_tokens(lexer(KOTLIN).as_ref(), "sample.kt");
+    }
+
+    #[test]
+    fn config_registers_aliases_filenames_and_mime_types() {
+        let registry = Registry::new();
+        let lexer = registry.register(load_chroma_xml(KOTLIN.as_bytes()).unwrap()).unwrap();
+        assert_eq!(lexer.name(), "Kotlin");
+        assert_eq!(registry.get("kt").unwrap().name(), "Kotlin");
+        assert_eq!(registry.match_filename("build.gradle.kts").unwrap().name(), "Kotlin");
+        assert_eq!(registry.match_mime_type("text/x-kotlin").unwrap().name(), "Kotlin");
+    }
+
+    #[test]
+    fn token_types_map_to_the_nearest_kind() {
+        let kind = |name: &str| {
+            let token = Element { attributes: vec![("type".into(), name.into())], ..Element::default() };
+            chroma_kind(&token)
+        };
+        assert_eq!(kind("LiteralStringDouble"), Ok(TokenKind::String));
+        assert_eq!(kind("LiteralStringInterpol"), Ok(TokenKind::StringEscape));
+        assert_eq!(kind("NameBuiltinPseudo"), Ok(TokenKind::NameBuiltin));
+        assert_eq!(kind("KeywordNamespace"), Ok(TokenKind::Keyword));
+        assert_eq!(kind("OperatorWord"), Ok(TokenKind::Keyword));
+        assert_eq!(kind("TextWhitespace"), Ok(TokenKind::Whitespace));
+        assert_eq!(kind("Bogus"), Err("unknown token type \"Bogus\"".to_string()));
+    }
+
+    #[test]
+    fn combined_states_are_synthesized_once() {
+        let xml = r#"<lexer><config><name>Test</name></config><rules>
+            <state name="root">
+              <rule pattern="&lt;"><token type="Punctuation"/><combined state="word" state="close"/></rule>
+              <rule pattern="\s+"><token type="Text"/></rule>
+            </state>
+            <state name="word"><rule pattern="\w+"><token type="NameTag"/></rule></state>
+            <state name="close"><rule pattern=">"><token type="Punctuation"/><pop depth="1"/></rule></state>
+          </rules></lexer>"#;
+        let src = "<a b> <c>";
+        let tokens = lexer(xml).tokenize(src).unwrap();
+        let kinds: Vec<_> = tokens.iter().map(|token| (token.kind, token.text(src))).collect();
+        assert_eq!(
+            kinds,
+            [
+                (TokenKind::Punctuation, "<"),
+                (TokenKind::NameTag, "a"),
+                (TokenKind::Error, " "),
+                (TokenKind::NameTag, "b"),
+                (TokenKind::Punctuation, ">"),
+                (TokenKind::Text, " "),
+                (TokenKind::Punctuation, "<"),
+                (TokenKind::NameTag, "c"),
+                (TokenKind::Punctuation, ">"),
+            ]
+        );
+    }
+
+    #[test]
+    fn flags_apply_to_every_pattern() {
+        let xml = r#"<lexer><config><name>Test</name><case_insensitive>true</case_insensitive></config><rules>
+            <state name="root">
+              <rule pattern="select\b"><token type="Keyword"/></rule>
+              <rule pattern="\s+"><token type="Text"/></rule>
+              <rule pattern="\w+"><token type="Name"/></rule>
+            </state></rules></lexer>"#;
+        let tokens = lexer(xml).tokenize("SELECT x").unwrap();
+        assert_eq!(tokens[0].kind, TokenKind::Keyword);
+    }
+
+    #[test]
+    fn inline_flags_are_translated() {
+        assert_eq!(translate_pattern(r"(?s)/\*.*?\*/").unwrap(), r"(?m)/\*.*?\*/");
+        assert_eq!(translate_pattern(r"(?m)^#.*$").unwrap(), r"^#.*$");
+        assert_eq!(translate_pattern(r"(?i-s)abc").unwrap(), r"(?i-m)abc");
+        assert_eq!(translate_pattern(r"(?m:^x)").unwrap(), r"(?:^x)");
+        assert_eq!(
+            translate_pattern(r"(?<name>\w+)(?<=a)(?=b)").unwrap(),
+            r"(?<name>\w+)(?<=a)(?=b)"
+        );
+        assert_eq!(translate_pattern(r"[(?s)]\(?s\)").unwrap(), r"[(?s)]\(?s\)");
+    }
+
+    #[test]
+    fn unsupported_regex_features_name_the_rule() {
+        let rejected = [
+            (r"[a-z-[aeiou]]", "character class subtraction"),
+            (r"(?(open)x|y)", "conditional groups"),
+            (r"(?<close-open>\))", "balancing groups"),
+            (r"(?n)(a)", "explicit capture"),
+        ];
+        for (pattern, reason) in rejected {
+            let xml = definition(&format!(
+                r#"<rule pattern="\s+"><token type="Text"/></rule><rule pattern="{}"><token type="Text"/></rule>"#,
+                pattern.replace('<', "&lt;")
+            ));
+            let message = import_error(&xml);
+            assert!(message.starts_with("failed to import Chroma lexer: "), "{message}");
+            assert!(message.contains("state \"root\" rule 2"), "{message}");
+            assert!(message.contains(reason), "{message}");
+        }
+    }
+
+    #[test]
+    fn rejects_delegation_and_unknown_tokens() {
+        let using = definition(r#"<rule pattern="x"><using lexer="Go"/></rule>"#);
+        assert!(import_error(&using).contains("state \"root\" rule 1: <using> is not supported"));
+        let unknown = definition(r#"<rule pattern="x"><token type="Sparkly"/></rule>"#);
+        assert!(import_error(&unknown).contains("unknown token type \"Sparkly\""));
+        let missing = definition(r#"<rule><include state="nowhere"/></rule>"#);
+        assert!(import_error(&missing).contains("unknown state \"nowhere\""));
+    }
+
+    #[test]
+    fn rejects_malformed_documents() {
+        assert!(import_error("<lexer><config>").starts_with("failed to parse Chroma lexer: "));
+        assert!(import_error("<style/>").contains("expected <lexer>"));
+        assert!(import_error("<lexer><rules/></lexer>").contains("missing <config>"));
+        assert!(import_error("<lexer><config><name>x</name></config><rules></lexer>").contains("expected </rules>"));
+    }
+}
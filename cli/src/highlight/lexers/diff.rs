@@ -0,0 +1,506 @@
+//! Unified diff lexer with optional emphasis of the changed span within paired lines.
+
+use super::{Lexer, LexerState, same_state};
+use crate::highlight::token::push_token;
+use crate::highlight::{CancelToken, HighlightError, Token, TokenKind};
+
+use std::any::Any;
+use std::ops::Range;
+
+/// Line prefixes that introduce file-level metadata outside of hunks.
+const HEADERS: &[&str] = &[
+    "diff ",
+    "index ",
+    "--- ",
+    "+++ ",
+    "old mode ",
+    "new mode ",
+    "deleted file mode ",
+    "new file mode ",
+    "similarity index ",
+    "dissimilarity index ",
+    "rename from ",
+    "rename to ",
+    "copy from ",
+    "copy to ",
+    "Binary files ",
+    "GIT binary patch",
+    "Only in ",
+];
+
+/// Lexer for unified diffs, including `git diff` output and combined (`@@@`) merge diffs.
+///
+/// File headers are [TokenKind::GenericHeading], hunk ranges [TokenKind::GenericSubheading], added and removed lines
+/// [TokenKind::GenericInserted] and [TokenKind::GenericDeleted], and `\ No newline at end of file` markers
+/// [TokenKind::Comment]. Context lines and anything outside a diff (commit messages, diffstats) are plain text.
+/// Hunk line counts decide where a hunk ends, so a removed line reading `--- x` is not mistaken for a file header.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Diff;
+///
+/// let src = "@@ -1 +1 @@\n-let x = 1;\n+let x = 2;\n";
+/// let tokens = Diff::new().with_inline_changes(true).tokenize(src).unwrap();
+/// let changed: Vec<_> = tokens
+///     .iter()
+///     .filter(|token| matches!(token.kind, TokenKind::GenericDeletedChange | TokenKind::GenericInsertedChange))
+///     .map(|token| token.text(src))
+///     .collect();
+/// assert_eq!(changed, ["1", "2"]);
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct Diff {
+    inline_changes: bool,
+}
+
+impl Diff {
+    pub const fn new() -> Self {
+        Self { inline_changes: false }
+    }
+
+    /// Pairs each run of removed lines with the added lines right after it and marks the span that differs within
+    /// each pair as [TokenKind::GenericDeletedChange]/[TokenKind::GenericInsertedChange]: the words between their
+    /// common prefix and suffix. Lines with nothing in common are left whole.
+    ///
+    /// Pairing needs to see the added lines before the removed ones are emitted, so it only applies to whole-document
+    /// tokenizing ([Lexer::tokenize], [crate::highlight::highlight]); consumers that lex line by line, such as
+    /// [crate::highlight::highlight_reader] and [Lexer::tokens], color whole lines.
+    pub const fn with_inline_changes(mut self, enabled: bool) -> Self {
+        self.inline_changes = enabled;
+        self
+    }
+}
+
+impl Lexer for Diff {
+    fn name(&self) -> &str {
+        "Diff"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(DiffState::default())
+    }
+
+    fn tokenize_cancellable(
+        &self, src: &str, cancel: &CancelToken, tokens: &mut Vec<Token>,
+    ) -> Result<(), HighlightError> {
+        let mut state = DiffState::default();
+        let mut lines = Vec::new();
+        let mut offset = 0;
+        for text in src.split_inclusive('\n') {
+            let (class, prefix) = state.classify(text);
+            lines.push(Line { text, offset, class, prefix });
+            offset += text.len();
+        }
+
+        let changes = if self.inline_changes { pair_changes(&lines) } else { vec![None; lines.len()] };
+        for (line, change) in lines.iter().zip(changes) {
+            cancel.check(line.offset)?;
+            emit_line(line, change, tokens);
+        }
+        Ok(())
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum LineClass {
+    Header,
+    Hunk,
+    Context,
+    Inserted,
+    Deleted,
+    /// `\ No newline at end of file`.
+    Note,
+    Text,
+}
+
+/// Remaining line counts of the open hunk, one per parent plus the result.
+#[derive(Clone, PartialEq)]
+struct Hunk {
+    old: Vec<usize>,
+    new: usize,
+}
+
+#[derive(Clone, PartialEq, Default)]
+struct DiffState {
+    hunk: Option<Hunk>,
+}
+
+impl DiffState {
+    /// Classifies `line` and returns its class with the width of its `+`/`-`/` ` marker columns.
+    fn classify(&mut self, line: &str) -> (LineClass, usize) {
+        let content = line.trim_end_matches(['\n', '\r']);
+        if let Some(hunk) = &mut self.hunk {
+            if content.starts_with('\\') {
+                return (LineClass::Note, 0);
+            }
+            let columns = hunk.old.len();
+            let marks = &content.as_bytes()[..columns.min(content.len())];
+            // Some tools strip the trailing space from empty context lines, leaving them blank.
+            let well_formed = marks.len() == columns || content.is_empty();
+            if well_formed && marks.iter().all(|mark| matches!(mark, b' ' | b'+' | b'-')) {
+                let removed = marks.contains(&b'-');
+                if !removed {
+                    hunk.new = hunk.new.saturating_sub(1);
+                }
+                // A line is in a parent when it was removed from it, or when it survives and wasn't added to it.
+                for (i, old) in hunk.old.iter_mut().enumerate() {
+                    let mark = marks.get(i).copied().unwrap_or(b' ');
+                    if mark == b'-' || (!removed && mark == b' ') {
+                        *old = old.saturating_sub(1);
+                    }
+                }
+                if hunk.new == 0 && hunk.old.iter().all(|&count| count == 0) {
+                    self.hunk = None;
+                }
+                let class = match () {
+                    _ if removed => LineClass::Deleted,
+                    _ if marks.contains(&b'+') => LineClass::Inserted,
+                    _ => LineClass::Context,
+                };
+                return (class, marks.len());
+            }
+            // The counts were wrong (a hand-edited patch); recover by treating the line as if outside a hunk.
+            self.hunk = None;
+        }
+
+        if content.starts_with("@@")
+            && let Some(hunk) = parse_hunk(content)
+        {
+            self.hunk = (hunk.new > 0 || hunk.old.iter().any(|&count| count > 0)).then_some(hunk);
+            return (LineClass::Hunk, 0);
+        }
+        let class = match () {
+            _ if content.starts_with('\\') => LineClass::Note,
+            _ if HEADERS.iter().any(|header| content.starts_with(header)) => LineClass::Header,
+            _ => LineClass::Text,
+        };
+        (class, 0)
+    }
+}
+
+impl LexerState for DiffState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let (class, prefix) = self.classify(line);
+        emit_line(&Line { text: line, offset, class, prefix }, None, tokens);
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+/// Parses `@@ -a,b +c,d @@` (or `@@@ -a,b -c,d +e,f @@@` with one `-` range per parent) into line counts.
+fn parse_hunk(header: &str) -> Option<Hunk> {
+    let markers = header.bytes().take_while(|&byte| byte == b'@').count();
+    let mut old = Vec::new();
+    let mut new = None;
+    for range in header[markers..].split_whitespace() {
+        if range.bytes().all(|byte| byte == b'@') {
+            break;
+        }
+        let count = |spec: &str| match spec.split_once(',') {
+            Some((_, count)) => count.parse().ok(),
+            None => spec.parse::<usize>().ok().map(|_| 1),
+        };
+        match range.as_bytes()[0] {
+            b'-' => old.push(count(&range[1..])?),
+            b'+' => new = Some(count(&range[1..])?),
+            _ => return None,
+        }
+    }
+    (old.len() == markers - 1).then_some(Hunk { old, new: new? })
+}
+
+struct Line<'a> {
+    text: &'a str,
+    offset: usize,
+    class: LineClass,
+    prefix: usize,
+}
+
+impl Line<'_> {
+    /// End of the line's content, before the line ending.
+    fn content_end(&self) -> usize {
+        self.text.trim_end_matches(['\n', '\r']).len()
+    }
+}
+
+/// Appends the tokens for one line; `change` is the byte range (within the line) to emphasize.
+fn emit_line(line: &Line, change: Option<Range<usize>>, tokens: &mut Vec<Token>) {
+    let at = |index: usize| line.offset + index;
+    let end = line.content_end();
+    match line.class {
+        LineClass::Hunk => {
+            let markers = line.text.bytes().take_while(|&byte| byte == b'@').count();
+            let closing = line.text[markers..]
+                .find(&line.text[..markers])
+                .map_or(end, |index| markers + index + markers);
+            push_token(tokens, TokenKind::GenericSubheading, at(0), at(closing));
+            push_token(tokens, TokenKind::Text, at(closing), at(end));
+        }
+        LineClass::Inserted | LineClass::Deleted => {
+            let (kind, changed) = if line.class == LineClass::Inserted {
+                (TokenKind::GenericInserted, TokenKind::GenericInsertedChange)
+            } else {
+                (TokenKind::GenericDeleted, TokenKind::GenericDeletedChange)
+            };
+            let change = change.unwrap_or(end..end);
+            push_token(tokens, kind, at(0), at(change.start));
+            push_token(tokens, changed, at(change.start), at(change.end));
+            push_token(tokens, kind, at(change.end), at(end));
+        }
+        class => {
+            let kind = match class {
+                LineClass::Header => TokenKind::GenericHeading,
+                LineClass::Note => TokenKind::Comment,
+                _ => TokenKind::Text,
+            };
+            push_token(tokens, kind, at(0), at(end));
+        }
+    }
+    push_token(tokens, TokenKind::Whitespace, at(end), at(line.text.len()));
+}
+
+/// Finds the changed span of each removed line paired with an added line, indexed like `lines`.
+fn pair_changes(lines: &[Line]) -> Vec<Option<Range<usize>>> {
+    let mut changes = vec![None; lines.len()];
+    let mut i = 0;
+    while i < lines.len() {
+        let deleted = take_run(lines, &mut i, LineClass::Deleted);
+        let inserted = take_run(lines, &mut i, LineClass::Inserted);
+        if deleted.is_empty() && inserted.is_empty() {
+            i += 1;
+            continue;
+        }
+        for (&old, &new) in deleted.iter().zip(&inserted) {
+            if let Some((old_change, new_change)) = changed_spans(&lines[old], &lines[new]) {
+                changes[old] = Some(old_change);
+                changes[new] = Some(new_change);
+            }
+        }
+    }
+    changes
+}
+
+/// Collects consecutive lines of `class` starting at `*i`, stepping over `\ No newline` notes between them.
+fn take_run(lines: &[Line], i: &mut usize, class: LineClass) -> Vec<usize> {
+    let mut run = Vec::new();
+    while let Some(line) = lines.get(*i) {
+        match line.class {
+            found if found == class => run.push(*i),
+            LineClass::Note if !run.is_empty() => {}
+            _ => break,
+        }
+        *i += 1;
+    }
+    run
+}
+
+/// Finds the changed spans of two paired diff lines, as ranges within each line including its `-`/`+` prefix.
+fn changed_spans(old: &Line, new: &Line) -> Option<(Range<usize>, Range<usize>)> {
+    let a = &old.text[old.prefix..old.content_end()];
+    let b = &new.text[new.prefix..new.content_end()];
+    let (a_change, b_change) = changed_words(a, b)?;
+    Some((
+        old.prefix + a_change.start..old.prefix + a_change.end,
+        new.prefix + b_change.start..new.prefix + b_change.end,
+    ))
+}
+
+/// Splits two lines into common prefix, changed middle, and common suffix, returning the middles as ranges within
+/// each line. The common parts stop at word boundaries so `let` -> `const` emphasizes whole words rather than `le` ->
+/// `cons`. Returns `None` when the lines share nothing, since emphasizing all of both adds no information.
+pub(crate) fn changed_words(a: &str, b: &str) -> Option<(Range<usize>, Range<usize>)> {
+    let word = |ch: Option<char>| ch.is_some_and(|ch| ch.is_alphanumeric() || ch == '_');
+
+    let mut prefix: usize = a
+        .chars()
+        .zip(b.chars())
+        .take_while(|(x, y)| x == y)
+        .map(|(x, _)| x.len_utf8())
+        .sum();
+    while word(a[..prefix].chars().next_back())
+        && (word(a[prefix..].chars().next()) || word(b[prefix..].chars().next()))
+    {
+        prefix -= a[..prefix].chars().next_back().map_or(0, char::len_utf8);
+    }
+
+    let mut suffix: usize = a[prefix..]
+        .chars()
+        .rev()
+        .zip(b[prefix..].chars().rev())
+        .take_while(|(x, y)| x == y)
+        .map(|(x, _)| x.len_utf8())
+        .sum();
+    loop {
+        let (a_end, b_end) = (a.len() - suffix, b.len() - suffix);
+        let first = a[a_end..].chars().next();
+        if !word(first) || !(word(a[..a_end].chars().next_back()) || word(b[..b_end].chars().next_back())) {
+            break;
+        }
+        suffix -= first.map_or(0, char::len_utf8);
+    }
+
+    if prefix + suffix == 0 || (prefix + suffix == a.len() && a.len() == b.len()) {
+        return None;
+    }
+    Some((prefix..a.len() - suffix, prefix..b.len() - suffix))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::formatters::HtmlFormatter;
+    use crate::highlight::{Theme, highlight};
+
+    const SAMPLE: &str = include_str!("../../../../examples/languages/sample.diff");
+
+    fn kinds(lexer: Diff, src: &str) -> Vec<(TokenKind, &str)> {
+        lexer
+            .tokenize(src)
+            .unwrap()
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    fn kind_of(src: &str, text: &str) -> Option<TokenKind> {
+        kinds(Diff::new(), src)
+            .into_iter()
+            .find(|(_, t)| *t == text)
+            .map(|(kind, _)| kind)
+    }
+
+    #[test]
+    fn git_diff_lines_are_classified() {
+        assert_eq!(
+            kind_of(SAMPLE, "diff --git a/src/lib.rs b/src/lib.rs"),
+            Some(TokenKind::GenericHeading)
+        );
+        assert_eq!(kind_of(SAMPLE, "--- a/src/lib.rs"), Some(TokenKind::GenericHeading));
+        assert_eq!(kind_of(SAMPLE, "@@ -1,6 +1,7 @@"), Some(TokenKind::GenericSubheading));
+        assert_eq!(kind_of(SAMPLE, " fn main() {"), Some(TokenKind::Text));
+        assert_eq!(
+            kind_of(SAMPLE, "-    let name = \"world\";"),
+            Some(TokenKind::GenericDeleted)
+        );
+        assert_eq!(
+            kind_of(SAMPLE, "+    let name = \"colorizer\";"),
+            Some(TokenKind::GenericInserted)
+        );
+        assert_eq!(
+            kind_of(SAMPLE, "\\ No newline at end of file"),
+            Some(TokenKind::Comment)
+        );
+        assert_eq!(
+            kind_of(SAMPLE, "Binary files a/logo.png and b/logo.png differ"),
+            Some(TokenKind::GenericHeading)
+        );
+
+        let rebuilt: String = Diff::new()
+            .tokenize(SAMPLE)
+            .unwrap()
+            .iter()
+            .map(|t| t.text(SAMPLE))
+            .collect();
+        assert_eq!(rebuilt, SAMPLE);
+    }
+
+    #[test]
+    fn hunk_counts_keep_dashes_inside_hunks() {
+        let src = "@@ -1,2 +1 @@\n--- not a header\n-+++ nor this\n+x\n--- a/next\n";
+        assert_eq!(kind_of(src, "--- not a header"), Some(TokenKind::GenericDeleted));
+        assert_eq!(kind_of(src, "-+++ nor this"), Some(TokenKind::GenericDeleted));
+        assert_eq!(kind_of(src, "--- a/next"), Some(TokenKind::GenericHeading));
+    }
+
+    #[test]
+    fn combined_hunks_use_one_column_per_parent() {
+        let src = "diff --cc file\n@@@ -1,2 -1,2 +1,2 @@@\n- a\n +b\n++c\n  d\ntrailer\n";
+        assert_eq!(
+            kind_of(src, "@@@ -1,2 -1,2 +1,2 @@@"),
+            Some(TokenKind::GenericSubheading)
+        );
+        assert_eq!(kind_of(src, "- a"), Some(TokenKind::GenericDeleted));
+        assert_eq!(kind_of(src, " +b"), Some(TokenKind::GenericInserted));
+        assert_eq!(kind_of(src, "++c"), Some(TokenKind::GenericInserted));
+        assert_eq!(kind_of(src, "  d"), Some(TokenKind::Text));
+        assert_eq!(kind_of(src, "trailer"), Some(TokenKind::Text));
+    }
+
+    #[test]
+    fn hunk_headers_split_range_from_context() {
+        let src = "@@ -3,1 +3,1 @@ fn main() {\n-a\n+b\n";
+        let tokens = kinds(Diff::new(), src);
+        assert_eq!(tokens[0], (TokenKind::GenericSubheading, "@@ -3,1 +3,1 @@"));
+        assert_eq!(tokens[1], (TokenKind::Text, " fn main() {"));
+    }
+
+    #[test]
+    fn malformed_hunks_do_not_panic() {
+        for src in [
+            "@@\n",
+            "@@ -x +y @@\n+a\n",
+            "@@ -1 +1\n",
+            "@@@ -1 +1 @@@\n",
+            "@@ -0,0 +0,0 @@\n+a\n",
+            "+\n-\n",
+        ] {
+            let tokens = Diff::new().with_inline_changes(true).tokenize(src).unwrap();
+            assert_eq!(tokens.last().map(|token| token.end), Some(src.len()), "{src:?}");
+        }
+    }
+
+    #[test]
+    fn inline_changes_pair_removed_and_added_runs() {
+        let src =
+            "@@ -1,3 +1,3 @@\n-let a = 1;\n-let b = 2;\n\\ No newline at end of file\n+let a = 10;\n+const b = 2;\n";
+        let lexer = Diff::new().with_inline_changes(true);
+        let changed: Vec<_> = kinds(lexer, src)
+            .into_iter()
+            .filter(|(kind, _)| matches!(kind, TokenKind::GenericDeletedChange | TokenKind::GenericInsertedChange))
+            .collect();
+        assert_eq!(
+            changed,
+            [
+                (TokenKind::GenericDeletedChange, "1"),
+                (TokenKind::GenericDeletedChange, "let"),
+                (TokenKind::GenericInsertedChange, "10"),
+                (TokenKind::GenericInsertedChange, "const"),
+            ]
+        );
+    }
+
+    #[test]
+    fn unrelated_lines_are_not_emphasized() {
+        let src = "@@ -1 +1 @@\n-abc\n+xyz\n";
+        let tokens = kinds(Diff::new().with_inline_changes(true), src);
+        assert!(tokens.contains(&(TokenKind::GenericDeleted, "-abc")));
+        assert!(tokens.contains(&(TokenKind::GenericInserted, "+xyz")));
+    }
+
+    #[test]
+    fn git_diff_output_highlights_to_html() {
+        let mut theme = Theme::new("Diff", Srgb8::new(0, 0, 0), Srgb8::new(0xee, 0xee, 0xee));
+        theme.set(TokenKind::GenericInserted, Srgb8::new(0, 0xff, 0));
+        theme.set(TokenKind::GenericDeleted, Srgb8::new(0xff, 0, 0));
+        let lexer = Diff::new().with_inline_changes(true);
+        let html = highlight(SAMPLE, &lexer, &theme, &HtmlFormatter::new().with_classes("")).unwrap();
+        assert!(html.contains(r#"<span class="gd">-    let name = &quot;</span><span class="gd gdc">world</span>"#));
+        assert!(html.contains(r#"<span class="gi gic">colorizer</span>"#));
+        assert!(html.contains(r#"<span class="cm">\ No newline at end of file</span>"#));
+    }
+}
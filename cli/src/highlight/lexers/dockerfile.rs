@@ -0,0 +1,962 @@
+//! Dockerfile lexer that hands `RUN` commands to the shell lexer and exec-form arrays to JSON.
+
+use super::embed::{Embedded, Resolver};
+use super::{Cursor, Lexer, LexerState, find, same_state};
+use crate::highlight::token::push_token;
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+/// Lexer for Dockerfiles and Containerfiles.
+///
+/// Instructions are [TokenKind::Keyword]s, and leading flags such as `--mount=type=cache,target=/root/.cache` are
+/// [TokenKind::NameAttribute]s with [TokenKind::String] values. Stage names (after `AS` and in `--from=`) are
+/// [TokenKind::NameLabel]s, `ENV` and `ARG` keys [TokenKind::NameVariable]s, and `LABEL` keys
+/// [TokenKind::NameAttribute]s, each with [TokenKind::String] values. Parser directives (`# syntax=`, `# escape=`,
+/// `# check=`) at the top of the file are [TokenKind::CommentPreproc], and `# escape=` changes the line continuation
+/// character.
+///
+/// Shell-form `RUN`, `CMD`, and `ENTRYPOINT` commands go to the shell lexer, continuation lines included, and
+/// exec-form arrays (`CMD ["nginx", "-g", "daemon off;"]`) to JSON. Heredoc bodies go to the shell lexer when they
+/// are the script of a `RUN`, to the interpreter named by their `#!` line or command (`RUN python3 <<EOF`), and are
+/// [TokenKind::String]s otherwise, as for `COPY <<EOF /etc/motd`.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Dockerfile;
+///
+/// let src = "FROM alpine:3.20 AS base\nRUN echo \"$HOME\"\n";
+/// let tokens = Dockerfile::new().tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|t| t.text(src) == text).map(|t| t.kind);
+/// assert_eq!(kind("base"), Some(TokenKind::NameLabel));
+/// assert_eq!(kind("$HOME"), Some(TokenKind::NameVariable));
+/// ```
+#[derive(Debug, Clone, Copy)]
+pub struct Dockerfile {
+    resolve: Resolver,
+}
+
+impl Dockerfile {
+    /// Creates a lexer that resolves embedded languages with [super::find].
+    pub const fn new() -> Self {
+        Self { resolve: find }
+    }
+
+    /// Creates a lexer that resolves embedded languages ("sh", "json", and heredoc interpreters such as "python")
+    /// with `resolve`.
+    pub const fn with_resolver(resolve: Resolver) -> Self {
+        Self { resolve }
+    }
+}
+
+impl Default for Dockerfile {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Lexer for Dockerfile {
+    fn name(&self) -> &str {
+        "Dockerfile"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(DockerfileState {
+            resolve: self.resolve,
+            directives: true,
+            escape: '\\',
+            mode: Mode::Start,
+            pending: Vec::new(),
+            line_start: true,
+        })
+    }
+}
+
+const INSTRUCTIONS: &[&str] = &[
+    "ADD",
+    "ARG",
+    "CMD",
+    "COPY",
+    "ENTRYPOINT",
+    "ENV",
+    "EXPOSE",
+    "FROM",
+    "HEALTHCHECK",
+    "LABEL",
+    "MAINTAINER",
+    "ONBUILD",
+    "RUN",
+    "SHELL",
+    "STOPSIGNAL",
+    "USER",
+    "VOLUME",
+    "WORKDIR",
+];
+
+/// Parser directives BuildKit recognizes; other `# key=value` comments are plain comments.
+const DIRECTIVES: &[&str] = &["syntax", "escape", "check"];
+
+/// How an instruction's arguments are read.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Syntax {
+    /// `FROM image [AS name]`
+    From,
+    /// `ENV key=value ...`, or the legacy `ENV key value`.
+    Env,
+    /// `ARG name[=default] ...`
+    Arg,
+    /// `LABEL key=value ...`
+    Label,
+    /// `EXPOSE port[/protocol] ...`
+    Expose,
+    /// `RUN`, `CMD`, and `ENTRYPOINT`: a shell command or an exec-form array.
+    Command,
+    /// `COPY`, `ADD`, `VOLUME`, and `SHELL`: paths (or heredocs) or a JSON array.
+    Files,
+    /// `HEALTHCHECK [flags] CMD ...` or `HEALTHCHECK NONE`
+    Healthcheck,
+    /// `ONBUILD INSTRUCTION ...`
+    Onbuild,
+    Words,
+}
+
+impl Syntax {
+    fn of(instruction: &str) -> Self {
+        match instruction {
+            "FROM" => Syntax::From,
+            "ENV" => Syntax::Env,
+            "ARG" => Syntax::Arg,
+            "LABEL" => Syntax::Label,
+            "EXPOSE" => Syntax::Expose,
+            "RUN" | "CMD" | "ENTRYPOINT" => Syntax::Command,
+            "COPY" | "ADD" | "VOLUME" | "SHELL" => Syntax::Files,
+            "HEALTHCHECK" => Syntax::Healthcheck,
+            "ONBUILD" => Syntax::Onbuild,
+            _ => Syntax::Words,
+        }
+    }
+}
+
+/// Position within an instruction's arguments, which may continue over several lines.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct Args {
+    syntax: Syntax,
+    /// Still reading leading `--flag[=value]` options.
+    flags: bool,
+    /// Arguments read after the flags.
+    words: usize,
+    /// `ENV key value`, where everything after the key is its value.
+    legacy: bool,
+}
+
+impl Args {
+    fn new(syntax: Syntax) -> Self {
+        Self { syntax, flags: true, words: 0, legacy: false }
+    }
+}
+
+#[derive(PartialEq)]
+enum Mode {
+    /// Before an instruction's keyword.
+    Start,
+    Args(Args),
+    /// A shell-form command.
+    Shell(Embedded),
+    /// An exec-form JSON array.
+    Json(Embedded),
+    /// The body of a heredoc, up to its delimiter line.
+    Heredoc(Heredoc),
+}
+
+#[derive(PartialEq)]
+struct Heredoc {
+    delimiter: String,
+    /// `<<-` strips leading tabs from the terminator.
+    strip_tabs: bool,
+    body: Body,
+}
+
+impl Heredoc {
+    fn snapshot(&self) -> Option<Self> {
+        let body = match &self.body {
+            Body::Script => Body::Script,
+            Body::Code(code) => Body::Code(code.snapshot()?),
+            Body::Data => Body::Data,
+        };
+        Some(Self { delimiter: self.delimiter.clone(), strip_tabs: self.strip_tabs, body })
+    }
+}
+
+#[derive(PartialEq)]
+enum Body {
+    /// A `RUN` script, in the shell unless its first line is a `#!` naming another interpreter.
+    Script,
+    /// Input to an interpreter (`RUN python3 <<EOF`), or a script once its first line has been read.
+    Code(Embedded),
+    /// File contents or input to some other command.
+    Data,
+}
+
+struct DockerfileState {
+    resolve: Resolver,
+    /// Still at the top of the file, where `# key=value` comments are parser directives.
+    directives: bool,
+    /// Line continuation character, set by the `escape` directive.
+    escape: char,
+    mode: Mode,
+    /// Heredocs opened by the current instruction, whose bodies follow its last line in order.
+    pending: Vec<Heredoc>,
+    /// The next piece of input starts a line (a long line may arrive in several pieces).
+    line_start: bool,
+}
+
+/// States of one document share their resolver, so it is left out of the comparison.
+impl PartialEq for DockerfileState {
+    fn eq(&self, other: &Self) -> bool {
+        self.directives == other.directives
+            && self.escape == other.escape
+            && self.mode == other.mode
+            && self.pending == other.pending
+            && self.line_start == other.line_start
+    }
+}
+
+impl LexerState for DockerfileState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let line_start = std::mem::replace(&mut self.line_start, line.ends_with('\n'));
+        if matches!(self.mode, Mode::Heredoc(_)) {
+            return self.heredoc(line, offset, tokens, line_start);
+        }
+
+        let content = line.trim_end_matches(['\n', '\r']);
+        let indent = content.len() - content.trim_start_matches([' ', '\t']).len();
+        if line_start && (content[indent..].is_empty() || content[indent..].starts_with('#')) {
+            // Comments and blank lines may sit inside a continued instruction without ending it.
+            let kind = if content[indent..].is_empty() {
+                TokenKind::Whitespace
+            } else if self.directives && self.directive(&content[indent + 1..]) {
+                TokenKind::CommentPreproc
+            } else {
+                TokenKind::Comment
+            };
+            if kind != TokenKind::CommentPreproc {
+                self.directives = false;
+            }
+            push_token(tokens, TokenKind::Whitespace, offset, offset + indent);
+            push_token(tokens, kind, offset + indent, offset + content.len());
+            push_token(
+                tokens,
+                TokenKind::Whitespace,
+                offset + content.len(),
+                offset + line.len(),
+            );
+            return Ok(());
+        }
+        self.directives = false;
+
+        let complete = line.ends_with('\n');
+        let trimmed = content.trim_end_matches([' ', '\t']);
+        let continued = complete && trimmed.ends_with(self.escape);
+        let end = if continued { trimmed.len() - self.escape.len_utf8() } else { content.len() };
+
+        let mut cursor = Cursor { line: &line[..end], offset, pos: 0, tokens };
+        while cursor.pos < end {
+            match &mut self.mode {
+                Mode::Start => self.instruction(&mut cursor),
+                Mode::Args(_) => self.args(&mut cursor),
+                Mode::Shell(_) => self.shell(&mut cursor)?,
+                Mode::Json(body) => {
+                    body.tokenize(cursor.rest(), offset + cursor.pos, cursor.tokens)?;
+                    cursor.pos = end;
+                }
+                Mode::Heredoc(_) => unreachable!("heredoc bodies start on the line after their instruction"),
+            }
+        }
+
+        if continued {
+            push_token(tokens, TokenKind::Punctuation, offset + end, offset + trimmed.len());
+        }
+        let rest = if continued { trimmed.len() } else { end };
+        push_token(tokens, TokenKind::Whitespace, offset + rest, offset + line.len());
+        if complete && !continued {
+            self.next_instruction();
+        }
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        let mode = match &self.mode {
+            Mode::Start => Mode::Start,
+            Mode::Args(args) => Mode::Args(*args),
+            Mode::Shell(body) => Mode::Shell(body.snapshot()?),
+            Mode::Json(body) => Mode::Json(body.snapshot()?),
+            Mode::Heredoc(heredoc) => Mode::Heredoc(heredoc.snapshot()?),
+        };
+        let pending = self.pending.iter().map(Heredoc::snapshot).collect::<Option<_>>()?;
+        Some(Box::new(DockerfileState {
+            resolve: self.resolve,
+            directives: self.directives,
+            escape: self.escape,
+            mode,
+            pending,
+            line_start: self.line_start,
+        }))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl DockerfileState {
+    /// Recognizes a `# key=value` parser directive (given without its `#`), applying `escape`.
+    fn directive(&mut self, text: &str) -> bool {
+        let Some((key, value)) = text.split_once('=') else {
+            return false;
+        };
+        let key = key.trim().to_ascii_lowercase();
+        if !DIRECTIVES.contains(&key.as_str()) {
+            return false;
+        }
+        if key == "escape"
+            && let Some(escape @ ('\\' | '`')) = value.trim().chars().next()
+        {
+            self.escape = escape;
+        }
+        true
+    }
+
+    /// Ends the current instruction: the next line is its first heredoc body, or another instruction.
+    fn next_instruction(&mut self) {
+        self.mode = if self.pending.is_empty() { Mode::Start } else { Mode::Heredoc(self.pending.remove(0)) };
+    }
+
+    fn heredoc(
+        &mut self, line: &str, offset: usize, tokens: &mut Vec<Token>, line_start: bool,
+    ) -> Result<(), HighlightError> {
+        let resolve = self.resolve;
+        let Mode::Heredoc(heredoc) = &mut self.mode else {
+            unreachable!("called in a heredoc body");
+        };
+
+        let content = line.trim_end_matches(['\n', '\r']);
+        let tabs = if heredoc.strip_tabs { content.len() - content.trim_start_matches('\t').len() } else { 0 };
+        if line_start && content[tabs..] == heredoc.delimiter {
+            push_token(tokens, TokenKind::Whitespace, offset, offset + tabs);
+            push_token(tokens, TokenKind::NameLabel, offset + tabs, offset + content.len());
+            push_token(
+                tokens,
+                TokenKind::Whitespace,
+                offset + content.len(),
+                offset + line.len(),
+            );
+            self.next_instruction();
+            return Ok(());
+        }
+
+        if heredoc.body == Body::Script {
+            let language = line.strip_prefix("#!").map_or(Some("sh"), shebang_language);
+            heredoc.body = Body::Code(Embedded::start(resolve, language));
+        }
+        match &mut heredoc.body {
+            Body::Code(code) => code.tokenize(line, offset, tokens)?,
+            _ => push_token(tokens, TokenKind::String, offset, offset + line.len()),
+        }
+        Ok(())
+    }
+
+    fn instruction(&mut self, cursor: &mut Cursor) {
+        let space = cursor.run_until(|ch| !matches!(ch, ' ' | '\t'));
+        cursor.emit(TokenKind::Whitespace, space);
+        let len = cursor.run_until(|ch| !ch.is_ascii_alphabetic());
+        let instruction = cursor.rest()[..len].to_ascii_uppercase();
+        let known = INSTRUCTIONS.contains(&instruction.as_str());
+        cursor.emit(if known { TokenKind::Keyword } else { TokenKind::Text }, len);
+        self.mode = Mode::Args(Args::new(Syntax::of(&instruction)));
+    }
+
+    fn args(&mut self, cursor: &mut Cursor) {
+        let Mode::Args(mut args) = self.mode else {
+            unreachable!("called in an instruction's arguments");
+        };
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        if matches!(ch, ' ' | '\t') {
+            let len = cursor.run_until(|ch| !matches!(ch, ' ' | '\t'));
+            cursor.emit(TokenKind::Whitespace, len);
+            return;
+        }
+        if args.flags && rest.starts_with("--") {
+            self.flag(cursor);
+            return;
+        }
+        args.flags = false;
+
+        let len = word_len(rest, self.escape);
+        let word = &rest[..len];
+        match args.syntax {
+            Syntax::Command | Syntax::Files if args.words == 0 && ch == '[' => {
+                self.mode = Mode::Json(Embedded::start(self.resolve, Some("json")));
+                return;
+            }
+            Syntax::Command => {
+                self.mode = Mode::Shell(Embedded::start(self.resolve, Some("sh")));
+                return;
+            }
+            Syntax::Onbuild => {
+                // The rest is an instruction of its own.
+                self.mode = Mode::Start;
+                return;
+            }
+            Syntax::Healthcheck if word.eq_ignore_ascii_case("CMD") => {
+                cursor.emit(TokenKind::Keyword, len);
+                self.mode = Mode::Args(Args { flags: false, ..Args::new(Syntax::Command) });
+                return;
+            }
+            Syntax::Healthcheck if word.eq_ignore_ascii_case("NONE") => cursor.emit(TokenKind::Keyword, len),
+            Syntax::From => match args.words {
+                0 => self.word(cursor, len, TokenKind::String),
+                1 if word.eq_ignore_ascii_case("AS") => cursor.emit(TokenKind::Keyword, len),
+                2 => cursor.emit(TokenKind::NameLabel, len),
+                _ => self.word(cursor, len, TokenKind::Text),
+            },
+            Syntax::Env if args.legacy => self.word(cursor, len, TokenKind::String),
+            Syntax::Env | Syntax::Arg => {
+                if !self.pair(cursor, len, TokenKind::NameVariable) {
+                    args.legacy = args.syntax == Syntax::Env && args.words == 0;
+                }
+            }
+            Syntax::Label => {
+                self.pair(cursor, len, TokenKind::NameAttribute);
+            }
+            Syntax::Expose => self.port(cursor, len),
+            Syntax::Files if word.starts_with("<<") => match heredoc_marker(word) {
+                Some(marker) => {
+                    emit_marker(cursor.tokens, cursor.offset + cursor.pos, &marker);
+                    cursor.pos += marker.len;
+                    self.pending.push(marker.heredoc(Body::Data));
+                }
+                None => self.word(cursor, len, TokenKind::Text),
+            },
+            _ if word.bytes().all(|byte| byte.is_ascii_digit()) => cursor.emit(TokenKind::Number, len),
+            _ => self.word(cursor, len, TokenKind::Text),
+        }
+        args.words += 1;
+        self.mode = Mode::Args(args);
+    }
+
+    /// Reads a `key=value` argument, or a lone key; returns whether it had a value.
+    fn pair(&self, cursor: &mut Cursor, len: usize, key: TokenKind) -> bool {
+        let name = key_len(&cursor.rest()[..len], self.escape);
+        cursor.emit(key, name);
+        if name == len {
+            return false;
+        }
+        cursor.emit(TokenKind::Operator, 1);
+        self.word(cursor, len - name - 1, TokenKind::String);
+        true
+    }
+
+    /// Reads a `--name[=value]` flag.
+    fn flag(&self, cursor: &mut Cursor) {
+        let name = cursor.run_until(|ch| matches!(ch, '=' | ' ' | '\t'));
+        let flag = &cursor.rest()[2..name];
+        cursor.emit(TokenKind::NameAttribute, name);
+        if cursor.peek() != Some('=') {
+            return;
+        }
+        cursor.emit(TokenKind::Operator, 1);
+
+        let len = word_len(cursor.rest(), self.escape);
+        let value = &cursor.rest()[..len];
+        if flag == "from" {
+            cursor.emit(TokenKind::NameLabel, len);
+            return;
+        }
+        if !value.contains('=') {
+            self.word(cursor, len, TokenKind::String);
+            return;
+        }
+        // Options such as `--mount=type=cache,target=/root/.cache` are comma-separated `key=value` lists.
+        for (i, option) in value.split(',').enumerate() {
+            if i > 0 {
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            match option.split_once('=') {
+                Some((key, rest)) => {
+                    cursor.emit(TokenKind::NameAttribute, key.len());
+                    cursor.emit(TokenKind::Operator, 1);
+                    self.word(cursor, rest.len(), TokenKind::String);
+                }
+                None => self.word(cursor, option.len(), TokenKind::String),
+            }
+        }
+    }
+
+    /// Emits a word of `len` bytes as `kind`, with its quoted parts, escapes, and `$variable` references.
+    fn word(&self, cursor: &mut Cursor, len: usize, kind: TokenKind) {
+        let end = cursor.pos + len;
+        let mut quote = None;
+        while cursor.pos < end {
+            let rest = &cursor.line[cursor.pos..end];
+            let ch = rest.chars().next().expect("cursor is before the end of the word");
+            if ch == self.escape && quote != Some('\'') && rest.len() > ch.len_utf8() {
+                let next = rest[ch.len_utf8()..].chars().next().map_or(0, char::len_utf8);
+                cursor.emit(TokenKind::StringEscape, ch.len_utf8() + next);
+                continue;
+            }
+            if ch == '$' && quote != Some('\'') {
+                let name = variable_len(rest);
+                if name > 0 {
+                    cursor.emit(TokenKind::NameVariable, name);
+                    continue;
+                }
+            }
+            match quote {
+                None if matches!(ch, '"' | '\'') => {
+                    quote = Some(ch);
+                    cursor.emit(TokenKind::String, 1);
+                }
+                Some(open) if ch == open => {
+                    quote = None;
+                    cursor.emit(TokenKind::String, 1);
+                }
+                _ => {
+                    let stop = |next: char| next == self.escape || matches!(next, '$' | '"' | '\'');
+                    let len = rest[ch.len_utf8()..]
+                        .find(stop)
+                        .map_or(rest.len(), |i| i + ch.len_utf8());
+                    cursor.emit(if quote.is_some() { TokenKind::String } else { kind }, len);
+                }
+            }
+        }
+    }
+
+    /// Hands a shell-form command to the shell lexer, except for heredoc markers, whose bodies follow the
+    /// instruction.
+    fn shell(&mut self, cursor: &mut Cursor) -> Result<(), HighlightError> {
+        let resolve = self.resolve;
+        let Mode::Shell(body) = &mut self.mode else {
+            unreachable!("called in a shell-form command");
+        };
+        let rest = cursor.rest();
+        let mut start = 0;
+        let mut quote = None;
+        let mut i = 0;
+        while let Some(ch) = rest[i..].chars().next() {
+            match (quote, ch) {
+                (Some('\''), '\'') => quote = None,
+                (Some('\''), _) => {}
+                (_, '\\') => i += rest[i + 1..].chars().next().map_or(0, char::len_utf8),
+                (None, '\'' | '"') => quote = Some(ch),
+                (Some('"'), '"') => quote = None,
+                (None, '<') => {
+                    if let Some(marker) = heredoc_marker(&rest[i..]) {
+                        let at = cursor.offset + cursor.pos;
+                        body.tokenize(&rest[start..i], at + start, cursor.tokens)?;
+                        emit_marker(cursor.tokens, at + i, &marker);
+
+                        // The body is a script when the marker is the whole command, and the input of the command
+                        // it redirects (`python3 <<EOF`, `<<EOF cat > file`) otherwise.
+                        let before = rest[..i].rsplit(['&', '|', ';', '(']).next().unwrap_or_default();
+                        let after = rest[i + marker.len..]
+                            .split(['&', '|', ';', ')'])
+                            .next()
+                            .unwrap_or_default();
+                        let mut words = before.split_whitespace().chain(after.split_whitespace());
+                        let body = match words.find(|word| !word.starts_with('<')) {
+                            None => Body::Script,
+                            Some(program) => match interpreter_language(program) {
+                                Some(language) => Body::Code(Embedded::start(resolve, Some(language))),
+                                None => Body::Data,
+                            },
+                        };
+                        i += marker.len;
+                        start = i;
+                        self.pending.push(marker.heredoc(body));
+                        continue;
+                    }
+                }
+                _ => {}
+            }
+            i += ch.len_utf8();
+        }
+        body.tokenize(&rest[start..], cursor.offset + cursor.pos + start, cursor.tokens)?;
+        cursor.pos = cursor.line.len();
+        Ok(())
+    }
+
+    /// Emits an `EXPOSE` argument such as `8080`, `8000-8010`, or `53/udp`.
+    fn port(&self, cursor: &mut Cursor, len: usize) {
+        let word = &cursor.rest()[..len];
+        let (number, protocol) = word.split_once('/').unwrap_or((word, ""));
+        let numeric = !number.is_empty() && number.bytes().all(|byte| byte.is_ascii_digit() || byte == b'-');
+        if !numeric || !protocol.bytes().all(|byte| byte.is_ascii_alphabetic()) {
+            self.word(cursor, len, TokenKind::Text);
+            return;
+        }
+        cursor.emit(TokenKind::Number, number.len());
+        if word.contains('/') {
+            cursor.emit(TokenKind::Punctuation, 1);
+            cursor.emit(TokenKind::Keyword, protocol.len());
+        }
+    }
+}
+
+/// A `<<EOF`, `<<-EOF`, or `<<"EOF"` heredoc marker.
+struct Marker {
+    delimiter: String,
+    strip_tabs: bool,
+    /// Length of the marker, quotes included.
+    len: usize,
+}
+
+impl Marker {
+    fn heredoc(self, body: Body) -> Heredoc {
+        Heredoc { delimiter: self.delimiter, strip_tabs: self.strip_tabs, body }
+    }
+}
+
+/// Emits a heredoc marker starting at `start`: the `<<` or `<<-` operator, then the delimiter.
+fn emit_marker(tokens: &mut Vec<Token>, start: usize, marker: &Marker) {
+    let operator = if marker.strip_tabs { 3 } else { 2 };
+    push_token(tokens, TokenKind::Operator, start, start + operator);
+    push_token(tokens, TokenKind::NameLabel, start + operator, start + marker.len);
+}
+
+/// Reads a heredoc marker at the start of `text`, which a here-string (`<<<`) is not.
+fn heredoc_marker(text: &str) -> Option<Marker> {
+    let rest = text.strip_prefix("<<")?;
+    let (strip_tabs, rest) = match rest.strip_prefix('-') {
+        Some(rest) => (true, rest),
+        None => (false, rest),
+    };
+    let (quote, rest) = match rest.chars().next() {
+        Some(quote @ ('"' | '\'')) => (Some(quote), &rest[1..]),
+        _ => (None, rest),
+    };
+    let len = rest
+        .find(|ch: char| !(ch.is_ascii_alphanumeric() || ch == '_'))
+        .unwrap_or(rest.len());
+    if len == 0 {
+        return None;
+    }
+    let mut end = len;
+    if let Some(quote) = quote {
+        if !rest[len..].starts_with(quote) {
+            return None;
+        }
+        end += 1;
+    }
+    Some(Marker { delimiter: rest[..len].to_string(), strip_tabs, len: text.len() - rest.len() + end })
+}
+
+/// Language of the interpreter a command runs, for heredocs piped into it (`python3 <<EOF`).
+fn interpreter_language(program: &str) -> Option<&'static str> {
+    let name = program.rsplit('/').next().unwrap_or(program);
+    Some(match name {
+        "sh" | "bash" | "ash" | "dash" | "zsh" | "ksh" => "sh",
+        "node" | "nodejs" => "javascript",
+        "perl" => "perl",
+        "ruby" => "ruby",
+        "php" => "php",
+        _ if name.starts_with("python") => "python",
+        _ => return None,
+    })
+}
+
+/// Language named by a `#!` line (given without its `#!`), such as `/usr/bin/env python3`; the shell by default.
+fn shebang_language(line: &str) -> Option<&'static str> {
+    let mut words = line.split_whitespace();
+    let program = match words.next() {
+        Some(program) if program.rsplit('/').next() == Some("env") => words.find(|word| !word.starts_with('-')),
+        program => program,
+    };
+    Some(program.and_then(interpreter_language).unwrap_or("sh"))
+}
+
+/// Length of the whitespace-separated word at the start of `text`, keeping quoted spaces and escapes inside it.
+fn word_len(text: &str, escape: char) -> usize {
+    let mut quote = None;
+    let mut chars = text.char_indices();
+    while let Some((i, ch)) = chars.next() {
+        match quote {
+            None if matches!(ch, ' ' | '\t') => return i,
+            Some('\'') if ch == '\'' => quote = None,
+            Some('\'') => {}
+            _ if ch == escape => {
+                chars.next();
+            }
+            None if matches!(ch, '"' | '\'') => quote = Some(ch),
+            Some(open) if ch == open => quote = None,
+            _ => {}
+        }
+    }
+    text.len()
+}
+
+/// Length of the key before the first unquoted `=` of a `key=value` word.
+fn key_len(word: &str, escape: char) -> usize {
+    let mut quote = None;
+    let mut chars = word.char_indices();
+    while let Some((i, ch)) = chars.next() {
+        match quote {
+            None if ch == '=' => return i,
+            _ if ch == escape => {
+                chars.next();
+            }
+            None if matches!(ch, '"' | '\'') => quote = Some(ch),
+            Some(open) if ch == open => quote = None,
+            _ => {}
+        }
+    }
+    word.len()
+}
+
+/// Length of a `$name` or `${name...}` reference at the start of `text`, or 0 if there is none.
+fn variable_len(text: &str) -> usize {
+    let rest = &text[1..];
+    if rest.starts_with('{') {
+        return rest.find('}').map_or(0, |close| close + 2);
+    }
+    let mut chars = rest.char_indices();
+    match chars.next() {
+        Some((_, ch)) if ch.is_ascii_alphabetic() || ch == '_' => {}
+        _ => return 0,
+    }
+    1 + chars
+        .find(|&(_, ch)| !(ch.is_ascii_alphanumeric() || ch == '_'))
+        .map_or(rest.len(), |(i, _)| i)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::{Python, Shell};
+    use super::*;
+    use crate::highlight::lexers::assert_fixture_tokens;
+
+    /// Emits each piece of an embedded region as one token of its kind.
+    struct Marker(TokenKind);
+
+    impl Lexer for Marker {
+        fn name(&self) -> &str {
+            "Marker"
+        }
+
+        fn start(&self) -> Box<dyn LexerState + '_> {
+            Box::new(Marker(self.0))
+        }
+    }
+
+    impl LexerState for Marker {
+        fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+            push_token(tokens, self.0, offset, offset + line.len());
+            Ok(())
+        }
+    }
+
+    static SHELL: Marker = Marker(TokenKind::NameBuiltin);
+    static JSON: Marker = Marker(TokenKind::NameConstant);
+    static PYTHON: Marker = Marker(TokenKind::KeywordDeclaration);
+
+    fn resolve(language: &str) -> Option<&'static dyn Lexer> {
+        let lexer: &'static dyn Lexer = match language {
+            "sh" => &SHELL,
+            "json" => &JSON,
+            "python" => &PYTHON,
+            _ => return None,
+        };
+        Some(lexer)
+    }
+
+    const DOCKERFILE: Dockerfile = Dockerfile::with_resolver(resolve);
+
+    fn kinds(src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = DOCKERFILE.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    #[test]
+    fn parser_directives_only_lead_the_file() {
+        let src = "# syntax=docker/dockerfile:1\n# escape=`\n# check=skip=all\n# not=a directive\n# syntax=late\nFROM scratch\n";
+        let tokens = kinds(src);
+        assert_eq!(tokens[0], (TokenKind::CommentPreproc, "# syntax=docker/dockerfile:1"));
+        assert_eq!(tokens[1], (TokenKind::CommentPreproc, "# escape=`"));
+        assert_eq!(tokens[2], (TokenKind::CommentPreproc, "# check=skip=all"));
+        assert_eq!(tokens[3], (TokenKind::Comment, "# not=a directive"));
+        assert_eq!(tokens[4], (TokenKind::Comment, "# syntax=late"));
+        assert_eq!(tokens[5], (TokenKind::Keyword, "FROM"));
+    }
+
+    #[test]
+    fn escape_directive_changes_the_continuation_character() {
+        let src = "# escape=`\nRUN dir c:\\ `\n    && echo done\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "dir c:\\ ")));
+        assert!(tokens.contains(&(TokenKind::Punctuation, "`")));
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "    && echo done")));
+    }
+
+    #[test]
+    fn stages_and_flags() {
+        let src = "FROM --platform=$BUILDPLATFORM golang:1.22 AS builder\n\
+                   COPY --from=builder --chmod=755 /out/app /usr/local/bin/\n\
+                   RUN --mount=type=cache,target=/root/.cache go build ./...\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::NameAttribute, "--platform")));
+        assert!(tokens.contains(&(TokenKind::NameVariable, "$BUILDPLATFORM")));
+        assert!(tokens.contains(&(TokenKind::String, "golang:1.22")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "AS")));
+        assert!(tokens.contains(&(TokenKind::NameLabel, "builder")));
+        assert!(tokens.contains(&(TokenKind::NameAttribute, "--from")));
+        assert_eq!(
+            tokens
+                .iter()
+                .filter(|token| **token == (TokenKind::NameLabel, "builder"))
+                .count(),
+            2
+        );
+        assert!(tokens.contains(&(TokenKind::String, "755")));
+
+        let mount = tokens.iter().position(|token| token.1 == "--mount").unwrap();
+        assert_eq!(
+            tokens[mount + 1..mount + 9],
+            [
+                (TokenKind::Operator, "="),
+                (TokenKind::NameAttribute, "type"),
+                (TokenKind::Operator, "="),
+                (TokenKind::String, "cache"),
+                (TokenKind::Punctuation, ","),
+                (TokenKind::NameAttribute, "target"),
+                (TokenKind::Operator, "="),
+                (TokenKind::String, "/root/.cache"),
+            ]
+        );
+        assert_eq!(tokens[mount + 9], (TokenKind::NameBuiltin, "go build ./..."));
+    }
+
+    #[test]
+    fn env_arg_and_label_pairs() {
+        let src = "ENV A=1 B=\"two words\" PATH=/app/bin:$PATH\nENV LEGACY some value\nARG VERSION\nARG USER=app\n\
+                   LABEL org.opencontainers.image.title=\"web\"\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::NameVariable, "A")));
+        assert!(tokens.contains(&(TokenKind::String, "1")));
+        assert!(tokens.contains(&(TokenKind::String, "\"two words\"")));
+        assert!(tokens.contains(&(TokenKind::String, "/app/bin:")));
+        assert!(tokens.contains(&(TokenKind::NameVariable, "$PATH")));
+        assert!(tokens.contains(&(TokenKind::NameVariable, "LEGACY")));
+        assert!(tokens.contains(&(TokenKind::String, "some")));
+        assert!(tokens.contains(&(TokenKind::String, "value")));
+        assert!(tokens.contains(&(TokenKind::NameVariable, "VERSION")));
+        assert!(tokens.contains(&(TokenKind::NameVariable, "USER")));
+        assert!(tokens.contains(&(TokenKind::NameAttribute, "org.opencontainers.image.title")));
+        assert!(tokens.contains(&(TokenKind::String, "\"web\"")));
+    }
+
+    #[test]
+    fn continued_commands_are_one_shell_region() {
+        let src = "RUN apt-get update && \\\n    # cache busting\n\n    apt-get install -y curl\nUSER app\n";
+        let tokens = DOCKERFILE.tokenize(src).unwrap();
+        let shell: Vec<_> = tokens
+            .iter()
+            .filter(|token| token.kind == TokenKind::NameBuiltin)
+            .map(|token| token.text(src))
+            .collect();
+        assert_eq!(shell, ["apt-get update && ", "    apt-get install -y curl"]);
+        let kinds = kinds(src);
+        assert!(kinds.contains(&(TokenKind::Punctuation, "\\")));
+        assert!(kinds.contains(&(TokenKind::Comment, "# cache busting")));
+        assert!(kinds.contains(&(TokenKind::Keyword, "USER")));
+    }
+
+    #[test]
+    fn exec_forms_are_json() {
+        let src = "CMD [\"nginx\", \"-g\", \"daemon off;\"]\nSHELL [\"/bin/bash\", \"-c\"]\nentrypoint echo hi\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::NameConstant, "[\"nginx\", \"-g\", \"daemon off;\"]")));
+        assert!(tokens.contains(&(TokenKind::NameConstant, "[\"/bin/bash\", \"-c\"]")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "entrypoint")));
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "echo hi")));
+    }
+
+    #[test]
+    fn heredoc_bodies_follow_their_command() {
+        let src = "RUN <<EOF\nset -e\nEOF\nRUN python3 - <<-'PY' > /dev/null\n\tprint(1)\n\tPY\n\
+                   COPY <<EOF /etc/motd\nhello $USER\nEOF\nRUN <<A cat > a && <<B sh\none\nA\ntwo\nB\n\
+                   RUN <<EOF\n#!/usr/bin/env python3\nprint(2)\nEOF\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::Operator, "<<")));
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "set -e\n")));
+        assert!(tokens.contains(&(TokenKind::Operator, "<<-")));
+        assert!(tokens.contains(&(TokenKind::NameLabel, "'PY'")));
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, " > /dev/null")));
+        assert!(tokens.contains(&(TokenKind::KeywordDeclaration, "\tprint(1)\n")));
+        assert!(tokens.contains(&(TokenKind::NameLabel, "PY")));
+        assert!(tokens.contains(&(TokenKind::String, "hello $USER\n")));
+        assert!(tokens.contains(&(TokenKind::String, "one\n")));
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "two\n")));
+        assert!(kinds("RUN <<EOF bash\necho\nEOF\n").contains(&(TokenKind::NameBuiltin, "echo\n")));
+        assert!(tokens.contains(&(TokenKind::KeywordDeclaration, "#!/usr/bin/env python3\nprint(2)\n")));
+        assert_eq!(tokens.last(), Some(&(TokenKind::NameLabel, "EOF")));
+    }
+
+    #[test]
+    fn healthcheck_onbuild_and_expose() {
+        let src = "HEALTHCHECK --interval=30s CMD curl -f http://localhost/\nHEALTHCHECK NONE\n\
+                   ONBUILD RUN make\nEXPOSE 80/tcp 53/udp 8000-8010 $PORT\nUSER 1000\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::NameAttribute, "--interval")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "CMD")));
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "curl -f http://localhost/")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "NONE")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "ONBUILD")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "RUN")));
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "make")));
+        assert!(tokens.contains(&(TokenKind::Number, "80")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "udp")));
+        assert!(tokens.contains(&(TokenKind::Number, "8000-8010")));
+        assert!(tokens.contains(&(TokenKind::NameVariable, "$PORT")));
+        assert!(tokens.contains(&(TokenKind::Number, "1000")));
+    }
+
+    #[test]
+    fn states_compare_through_embedded_regions() {
+        let lexer = Dockerfile::with_resolver(real);
+        let mut state = lexer.start();
+        state
+            .tokenize_line("RUN <<EOF cat && \\\n", 0, &mut Vec::new())
+            .unwrap();
+        let copy = state.snapshot().unwrap();
+        assert!(copy.same_as(&*state));
+        state.tokenize_line("    echo more\n", 0, &mut Vec::new()).unwrap();
+        assert!(!copy.same_as(&*state));
+    }
+
+    fn real(language: &str) -> Option<&'static dyn Lexer> {
+        let lexer: &'static dyn Lexer = match language {
+            "sh" => &Shell,
+            "python" => &Python,
+            "json" => &JSON,
+            _ => return None,
+        };
+        Some(lexer)
+    }
+
+    #[test]
+    fn dockerfile_matches_golden_tokens() {
+        assert_fixture_tokens(&Dockerfile::with_resolver(real), "Dockerfile");
+    }
+}
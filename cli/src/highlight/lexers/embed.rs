@@ -0,0 +1,47 @@
+//! Delegation to another lexer for regions of a document written in a different language.
+
+use super::{Lexer, LexerState, PlainText};
+use crate::highlight::{HighlightError, Token};
+
+/// Maps a language name (e.g., "go", "css") to the lexer for regions written in it.
+pub type Resolver = fn(&str) -> Option<&'static dyn Lexer>;
+
+/// Lexing state for one embedded region, such as a Markdown code fence or an HTML `<script>` body.
+///
+/// The host lexer feeds the region's text piece by piece at its absolute offsets, so the embedded tokens splice into
+/// the host's token stream without any remapping.
+pub(crate) struct Embedded {
+    state: Box<dyn LexerState>,
+}
+
+impl Embedded {
+    /// Starts a region in `language`, falling back to [PlainText] when it is missing or `resolve` doesn't know it.
+    pub(crate) fn start(resolve: Resolver, language: Option<&str>) -> Self {
+        let state = match language.and_then(resolve) {
+            Some(lexer) => lexer.start(),
+            None => Box::new(PlainText),
+        };
+        Self { state }
+    }
+
+    /// Tokenizes the next piece of the region, which starts at `offset` in the host document.
+    pub(crate) fn tokenize(
+        &mut self, text: &str, offset: usize, tokens: &mut Vec<Token>,
+    ) -> Result<(), HighlightError> {
+        if text.is_empty() {
+            return Ok(());
+        }
+        self.state.tokenize_line(text, offset, tokens)
+    }
+
+    /// Copies the region's state; see [LexerState::snapshot].
+    pub(crate) fn snapshot(&self) -> Option<Self> {
+        self.state.snapshot().map(|state| Self { state })
+    }
+}
+
+impl PartialEq for Embedded {
+    fn eq(&self, other: &Self) -> bool {
+        self.state.same_as(&*other.state)
+    }
+}
@@ -0,0 +1,648 @@
+//! Stateful lexer for Go source.
+
+use super::{Cursor, Lexer, LexerState, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+const KEYWORDS: &[&str] = &[
+    "break",
+    "case",
+    "continue",
+    "default",
+    "defer",
+    "else",
+    "fallthrough",
+    "for",
+    "go",
+    "goto",
+    "if",
+    "import",
+    "package",
+    "range",
+    "return",
+    "select",
+    "switch",
+];
+
+const DECLARATIONS: &[&str] = &["chan", "const", "func", "interface", "map", "struct", "type", "var"];
+
+const CONSTANTS: &[&str] = &["true", "false", "nil", "iota"];
+
+const TYPES: &[&str] = &[
+    "bool",
+    "byte",
+    "complex64",
+    "complex128",
+    "error",
+    "float32",
+    "float64",
+    "int",
+    "int8",
+    "int16",
+    "int32",
+    "int64",
+    "rune",
+    "string",
+    "uint",
+    "uint8",
+    "uint16",
+    "uint32",
+    "uint64",
+    "uintptr",
+];
+
+/// Predeclared functions, plus the constraint types `any` and `comparable`.
+const BUILTINS: &[&str] = &[
+    "any",
+    "append",
+    "cap",
+    "clear",
+    "close",
+    "comparable",
+    "complex",
+    "copy",
+    "delete",
+    "imag",
+    "len",
+    "make",
+    "max",
+    "min",
+    "new",
+    "panic",
+    "print",
+    "println",
+    "real",
+    "recover",
+];
+
+/// Operators, longest first.
+const OPERATORS: &[&str] = &[
+    "<<=", ">>=", "&^=", "...", "&&", "||", "<-", "++", "--", "==", "!=", "<=", ">=", ":=", "+=", "-=", "*=", "/=",
+    "%=", "&=", "|=", "^=", "<<", ">>", "&^", "+", "-", "*", "/", "%", "&", "|", "^", "<", ">", "=", "!", "~",
+];
+
+/// Lexer for Go.
+///
+/// Raw strings are single [TokenKind::StringBacktick] tokens however many lines they span. Rune literals and
+/// interpreted strings are [TokenKind::String] with their escapes (`\x00`, `\u00e9`, `\377`) as
+/// [TokenKind::StringEscape]; malformed escapes are [TokenKind::Error]. Raw-string struct tags are split into
+/// [TokenKind::NameAttribute] keys and [TokenKind::String] values. Predeclared functions and the constraints `any`
+/// and `comparable` are [TokenKind::NameBuiltin], predeclared types [TokenKind::KeywordType], and the names in
+/// `func` and `type` declarations [TokenKind::NameFunction] and [TokenKind::NameClass]; a predeclared name being
+/// declared anew, as in `len := 3` or `func f(any int)`, shadows the original and is a plain [TokenKind::Name].
+/// Brackets, including those of type parameter lists, are [TokenKind::Punctuation]; `//go:` directives are
+/// [TokenKind::CommentPreproc].
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Go;
+///
+/// let src = "func Map[T any](xs []T) {}\n";
+/// let tokens = Go.tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("Map"), Some(TokenKind::NameFunction));
+/// assert_eq!(kind("any"), Some(TokenKind::NameBuiltin));
+/// assert_eq!(kind("["), Some(TokenKind::Punctuation));
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct Go;
+
+impl Lexer for Go {
+    fn name(&self) -> &str {
+        "Go"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(GoState {
+            mode: Mode::Code,
+            groups: Vec::new(),
+            expect: Expect::Nothing,
+            struct_next: false,
+            after_dot: false,
+            statement_start: true,
+        })
+    }
+}
+
+/// What the next characters continue.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Mode {
+    Code,
+    /// Inside `/* ... */`.
+    BlockComment,
+    /// Inside a backtick string; `tag` when it is a struct tag.
+    Raw {
+        tag: bool,
+    },
+}
+
+/// An open bracket.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Group {
+    Block,
+    /// The body of a `struct` type, where strings are field tags.
+    Struct,
+    Paren,
+    /// A method receiver, after which comes the method name.
+    Receiver,
+    /// `type ( ... )`, which declares a type on each line.
+    TypeList,
+    Bracket,
+}
+
+/// A declared name the next identifier may be.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Expect {
+    Nothing,
+    FuncName,
+    TypeName,
+    VarName,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct GoState {
+    mode: Mode,
+    groups: Vec<Group>,
+    expect: Expect,
+    /// The last token was `struct`, so the next `{` opens a struct body.
+    struct_next: bool,
+    /// The last token was `.`, so an identifier is a field, method, or package member.
+    after_dot: bool,
+    /// At the start of a line or after `;`, `{`, or `(`.
+    statement_start: bool,
+}
+
+impl LexerState for GoState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        while cursor.pos < line.len() {
+            match self.mode {
+                Mode::Code => self.code(&mut cursor),
+                Mode::BlockComment => self.block_comment(&mut cursor),
+                Mode::Raw { tag: false } => self.raw(&mut cursor),
+                Mode::Raw { tag: true } => self.tag(&mut cursor),
+            }
+        }
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl GoState {
+    fn code(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+
+        if ch.is_whitespace() {
+            let len = cursor.run_until(|ch| !ch.is_whitespace());
+            self.statement_start |= rest[..len].contains('\n');
+            cursor.emit(TokenKind::Whitespace, len);
+            return;
+        }
+        if rest.starts_with("//") {
+            let len = cursor.run_until(|ch| ch == '\n');
+            let directive = rest.starts_with("//go:") || rest.starts_with("//line ");
+            cursor.emit(
+                if directive { TokenKind::CommentPreproc } else { TokenKind::Comment },
+                len,
+            );
+            return;
+        }
+        if rest.starts_with("/*") {
+            cursor.emit(TokenKind::Comment, 2);
+            self.mode = Mode::BlockComment;
+            return;
+        }
+
+        let (mut statement_start, mut struct_next, mut after_dot) = (false, false, false);
+        let mut expect = Expect::Nothing;
+        match ch {
+            '"' => self.string(cursor),
+            '\'' => rune(cursor),
+            '`' => {
+                cursor.emit(TokenKind::StringBacktick, 1);
+                self.mode = Mode::Raw { tag: self.groups.last() == Some(&Group::Struct) };
+            }
+            '0'..='9' => cursor.emit(TokenKind::Number, number_len(rest)),
+            '.' if rest[1..].starts_with(|ch: char| ch.is_ascii_digit()) => {
+                cursor.emit(TokenKind::Number, number_len(rest))
+            }
+            _ if ch == '_' || ch.is_alphabetic() => {
+                let len = cursor.run_until(|ch| !(ch == '_' || ch.is_alphanumeric()));
+                let word = &rest[..len];
+                let kind = self.word(word, &rest[len..]);
+                match word {
+                    "func" if self.groups.is_empty() => expect = Expect::FuncName,
+                    "type" => expect = Expect::TypeName,
+                    "var" | "const" => expect = Expect::VarName,
+                    "struct" => struct_next = true,
+                    _ => {}
+                }
+                cursor.emit(kind, len);
+            }
+            '{' | '(' | '[' => {
+                let group = match ch {
+                    '{' if self.struct_next => Group::Struct,
+                    '{' => Group::Block,
+                    '(' if self.expect == Expect::FuncName => Group::Receiver,
+                    '(' if self.expect == Expect::TypeName => Group::TypeList,
+                    '(' => Group::Paren,
+                    _ => Group::Bracket,
+                };
+                self.groups.push(group);
+                statement_start = ch != '[';
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '}' | ')' | ']' => {
+                if self.groups.pop() == Some(Group::Receiver) {
+                    expect = Expect::FuncName;
+                }
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ';' | ',' | ':' | '.' if !rest.starts_with(":=") && !rest.starts_with("...") => {
+                statement_start = ch == ';';
+                after_dot = ch == '.';
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            _ => match OPERATORS.iter().find(|operator| rest.starts_with(*operator)) {
+                Some(operator) => cursor.emit(TokenKind::Operator, operator.len()),
+                None => cursor.emit(TokenKind::Error, ch.len_utf8()),
+            },
+        }
+        self.statement_start = statement_start;
+        self.struct_next = struct_next;
+        self.after_dot = after_dot;
+        self.expect = expect;
+    }
+
+    /// Classifies an identifier, given the rest of the line `after` it.
+    fn word(&self, word: &str, after: &str) -> TokenKind {
+        let call = after.starts_with('(');
+        if KEYWORDS.contains(&word) {
+            TokenKind::Keyword
+        } else if DECLARATIONS.contains(&word) {
+            TokenKind::KeywordDeclaration
+        } else if self.expect == Expect::FuncName {
+            TokenKind::NameFunction
+        } else if self.expect == Expect::TypeName
+            || (self.statement_start && self.groups.last() == Some(&Group::TypeList))
+        {
+            TokenKind::NameClass
+        } else if self.after_dot {
+            if call { TokenKind::NameFunction } else { TokenKind::Name }
+        } else if self.declares(after) {
+            TokenKind::Name
+        } else if CONSTANTS.contains(&word) {
+            TokenKind::KeywordConstant
+        } else if TYPES.contains(&word) {
+            TokenKind::KeywordType
+        } else if BUILTINS.contains(&word) {
+            TokenKind::NameBuiltin
+        } else if call {
+            TokenKind::NameFunction
+        } else {
+            TokenKind::Name
+        }
+    }
+
+    /// Whether an identifier followed by `after` is being declared, which for a predeclared one like `len` or `any`
+    /// shadows it: the name after `var` or `const`, a name on the left of `:=`, or a parameter name, which a type
+    /// follows.
+    fn declares(&self, after: &str) -> bool {
+        if self.expect == Expect::VarName {
+            return true;
+        }
+        // Only a type can follow a name after a space in parentheses: `(len int)`, `(any *T)`, `(cap []byte)`, but not
+        // `(iota * 2)`.
+        let parameter = after.trim_start().trim_start_matches('*');
+        if matches!(self.groups.last(), Some(Group::Paren | Group::Receiver))
+            && after.starts_with([' ', '\t'])
+            && parameter.starts_with(|ch: char| ch == '_' || ch == '[' || ch.is_alphabetic())
+        {
+            return true;
+        }
+        // `a, b := ...` declares every name before the `:=`.
+        let mut rest = after.trim_start();
+        while let Some(next) = rest.strip_prefix(',') {
+            let next = next.trim_start();
+            let name = next
+                .find(|ch: char| !(ch == '_' || ch.is_alphanumeric()))
+                .unwrap_or(next.len());
+            if name == 0 {
+                return false;
+            }
+            rest = next[name..].trim_start();
+        }
+        rest.starts_with(":=")
+    }
+
+    /// Lexes an interpreted string, which ends at its closing quote or, unterminated, at the end of the line.
+    fn string(&mut self, cursor: &mut Cursor) {
+        cursor.emit(TokenKind::String, 1);
+        loop {
+            let rest = cursor.rest();
+            match cursor.peek() {
+                None | Some('\n') => return,
+                Some('"') => {
+                    cursor.emit(TokenKind::String, 1);
+                    return;
+                }
+                Some('\\') => emit_escape(cursor, '"'),
+                Some(_) => {
+                    let len = rest.find(['"', '\\', '\n']).unwrap_or(rest.len());
+                    cursor.emit(TokenKind::String, len);
+                }
+            }
+        }
+    }
+
+    fn block_comment(&mut self, cursor: &mut Cursor) {
+        match cursor.rest().find("*/") {
+            Some(end) => {
+                cursor.emit(TokenKind::Comment, end + 2);
+                self.mode = Mode::Code;
+            }
+            None => cursor.emit(TokenKind::Comment, cursor.rest().len()),
+        }
+    }
+
+    fn raw(&mut self, cursor: &mut Cursor) {
+        match cursor.rest().find('`') {
+            Some(end) => {
+                cursor.emit(TokenKind::StringBacktick, end + 1);
+                self.mode = Mode::Code;
+            }
+            None => cursor.emit(TokenKind::StringBacktick, cursor.rest().len()),
+        }
+    }
+
+    /// Lexes a struct tag's `key:"value"` pairs; text that doesn't fit the convention stays part of the string.
+    fn tag(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        match ch {
+            '`' => {
+                cursor.emit(TokenKind::StringBacktick, 1);
+                self.mode = Mode::Code;
+            }
+            _ if ch.is_whitespace() => cursor.emit(TokenKind::Whitespace, cursor.run_until(|ch| !ch.is_whitespace())),
+            ':' => cursor.emit(TokenKind::Punctuation, 1),
+            '"' => {
+                // Values are quoted Go strings, where `\"` doesn't end them.
+                let mut len = 1;
+                let mut chars = rest[1..].char_indices();
+                while let Some((at, ch)) = chars.next() {
+                    match ch {
+                        '\\' => {
+                            chars.next();
+                        }
+                        '"' => {
+                            len = at + 2;
+                            break;
+                        }
+                        '`' | '\n' => {
+                            len = at + 1;
+                            break;
+                        }
+                        _ => len = at + 1 + ch.len_utf8(),
+                    }
+                }
+                cursor.emit(TokenKind::String, len);
+            }
+            _ => {
+                let len = cursor.run_until(|ch| ch == ':' || ch == '"' || ch == '`' || ch.is_whitespace());
+                let key = rest[len..].starts_with(":\"");
+                cursor.emit(
+                    if key { TokenKind::NameAttribute } else { TokenKind::StringBacktick },
+                    len,
+                );
+            }
+        }
+    }
+}
+
+/// Lexes a rune literal, or marks a stray quote as an error.
+fn rune(cursor: &mut Cursor) {
+    let rest = cursor.rest();
+    let body = &rest[1..];
+    let len = match body.chars().next() {
+        Some('\\') => escape_len(body, '\''),
+        Some(ch) if ch != '\'' && ch != '\n' => Some(ch.len_utf8()),
+        _ => None,
+    };
+    match len {
+        Some(len) if body[len..].starts_with('\'') => {
+            cursor.emit(TokenKind::String, 1);
+            if body.starts_with('\\') {
+                cursor.emit(TokenKind::StringEscape, len);
+            } else {
+                cursor.emit(TokenKind::String, len);
+            }
+            cursor.emit(TokenKind::String, 1);
+        }
+        _ => cursor.emit(TokenKind::Error, 1),
+    }
+}
+
+/// Emits the escape sequence at the cursor, or the backslash and next character as an error.
+fn emit_escape(cursor: &mut Cursor, quote: char) {
+    let rest = cursor.rest();
+    match escape_len(rest, quote) {
+        Some(len) => cursor.emit(TokenKind::StringEscape, len),
+        None => {
+            let next = rest[1..].chars().next().filter(|&ch| ch != '\n');
+            cursor.emit(TokenKind::Error, 1 + next.map_or(0, char::len_utf8));
+        }
+    }
+}
+
+/// Length of the escape sequence `rest` starts with, or `None` when it isn't a valid one inside `quote`s.
+fn escape_len(rest: &str, quote: char) -> Option<usize> {
+    let digits = |len: usize, radix: u32| {
+        let digits = rest.get(2..2 + len)?;
+        digits.chars().all(|ch| ch.is_digit(radix)).then_some(2 + len)
+    };
+    match rest[1..].chars().next()? {
+        'a' | 'b' | 'f' | 'n' | 'r' | 't' | 'v' | '\\' => Some(2),
+        ch if ch == quote => Some(2),
+        'x' => digits(2, 16),
+        'u' => digits(4, 16),
+        'U' => digits(8, 16),
+        '0'..='7' => rest.get(1..4)?.chars().all(|ch| ch.is_digit(8)).then_some(4),
+        _ => None,
+    }
+}
+
+/// Length of the number literal `rest` starts with: decimal, hex (with `p` exponents), octal, or binary, with digit
+/// separators and an optional imaginary `i`.
+fn number_len(rest: &str) -> usize {
+    let bytes = rest.as_bytes();
+    let hex = rest.starts_with("0x") || rest.starts_with("0X");
+    let mut len = 0;
+    while let Some(&byte) = bytes.get(len) {
+        let exponent_sign = matches!(byte, b'+' | b'-')
+            && len > 0
+            && if hex { matches!(bytes[len - 1], b'p' | b'P') } else { matches!(bytes[len - 1], b'e' | b'E') };
+        if byte.is_ascii_alphanumeric()
+            || byte == b'_'
+            || exponent_sign
+            || (byte == b'.' && !rest[len..].starts_with(".."))
+        {
+            len += 1;
+        } else {
+            break;
+        }
+    }
+    len
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::formatters::AnsiFormatter;
+    use crate::highlight::lexers::assert_fixture_tokens;
+    use crate::highlight::testing::{assert_golden_output, assert_round_trip};
+    use crate::highlight::themes::load_tmtheme;
+    use crate::terminal::ColorProfile;
+
+    /// Non-whitespace tokens as (kind, text) pairs.
+    fn kinds(src: &str) -> Vec<(TokenKind, &str)> {
+        assert_round_trip(&Go, src);
+        Go.tokenize(src)
+            .unwrap()
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    fn texts(src: &str, kind: TokenKind) -> Vec<&str> {
+        kinds(src)
+            .into_iter()
+            .filter(|(k, _)| *k == kind)
+            .map(|(_, text)| text)
+            .collect()
+    }
+
+    #[test]
+    fn raw_strings_are_one_token_across_lines() {
+        let src = "q := `SELECT \"a\", 'b'\nFROM t\n`\nx := 1\n";
+        assert_eq!(texts(src, TokenKind::StringBacktick), ["`SELECT \"a\", 'b'\nFROM t\n`"]);
+        assert_eq!(texts(src, TokenKind::Number), ["1"]);
+    }
+
+    #[test]
+    fn rune_literals_keep_their_escapes() {
+        let src = r"a, b, c, d, e := 'x', '\x00', '\u00e9', '\'', '\377'";
+        assert_eq!(
+            texts(src, TokenKind::StringEscape),
+            [r"\x00", r"\u00e9", r"\'", r"\377"]
+        );
+        assert!(texts(src, TokenKind::Error).is_empty());
+        assert_eq!(
+            texts(src, TokenKind::String),
+            ["'x'", "'", "'", "'", "'", "'", "'", "'", "'"]
+        );
+
+        for malformed in [r"'\q'", r"'\x0'", "'ab'", "''"] {
+            assert_eq!(kinds(malformed)[0].0, TokenKind::Error, "{malformed}");
+        }
+        assert_eq!(texts("\"ok\\n\\q\"", TokenKind::Error), ["\\q"]);
+    }
+
+    #[test]
+    fn generics_use_punctuation_and_builtin_constraints() {
+        let src = "func Map[T any, U comparable](xs []T, f func(T) U) []U {\n\treturn clear(min(a, b), max(c))\n}\n";
+        assert_eq!(texts(src, TokenKind::NameFunction), ["Map"]);
+        assert_eq!(
+            texts(src, TokenKind::NameBuiltin),
+            ["any", "comparable", "clear", "min", "max"]
+        );
+        assert!(texts(src, TokenKind::Operator).is_empty());
+        assert_eq!(
+            texts(src, TokenKind::Name),
+            ["T", "U", "xs", "T", "f", "T", "U", "U", "a", "b", "c"]
+        );
+    }
+
+    #[test]
+    fn struct_tags_split_into_keys_and_values() {
+        let src = "type User struct {\n\tName string `json:\"name,omitempty\" db:\"n\\\"x\"`\n\tAge  int    \"plain\"\n}\nvar s = `json:\"no\"`\n";
+        assert_eq!(texts(src, TokenKind::NameClass), ["User"]);
+        assert_eq!(texts(src, TokenKind::NameAttribute), ["json", "db"]);
+        assert!(texts(src, TokenKind::String).contains(&"\"name,omitempty\""));
+        assert!(texts(src, TokenKind::String).contains(&"\"n\\\"x\""));
+        assert!(texts(src, TokenKind::String).contains(&"\"plain\""));
+        // Outside a struct body, the same text is an ordinary raw string.
+        assert!(texts(src, TokenKind::StringBacktick).contains(&"`json:\"no\"`"));
+    }
+
+    #[test]
+    fn declarations_and_method_receivers() {
+        let src = "type (\n\tA int\n\tB[T any] struct{ v T }\n)\n\nfunc (s *Set[T]) Add(v T) bool {\n\tf := func(x int) int { return x }\n\treturn s.m.Has(v) && f(1) > 0\n}\n";
+        assert_eq!(texts(src, TokenKind::NameClass), ["A", "B"]);
+        assert_eq!(texts(src, TokenKind::NameFunction), ["Add", "Has", "f"]);
+        assert_eq!(texts(src, TokenKind::KeywordType), ["int", "bool", "int", "int"]);
+    }
+
+    #[test]
+    fn shadowed_predeclared_names_are_plain_names() {
+        let src = "func f(any int, cap *[]byte, new func() any) (len int) {\n\tvar string = \"s\"\n\tcopy, ok := m[any]\n\tnil := iota * 2\n\treturn len(x) + cap + max(new(int), 1)\n}\n";
+        assert_eq!(
+            texts(src, TokenKind::Name),
+            ["any", "cap", "new", "len", "string", "copy", "ok", "m", "nil", "x"]
+        );
+        // Declarations don't track scope, so later uses keep their usual kind.
+        assert_eq!(
+            texts(src, TokenKind::NameBuiltin),
+            ["any", "any", "len", "cap", "max", "new"]
+        );
+        assert_eq!(texts(src, TokenKind::KeywordType), ["int", "byte", "int", "int"]);
+        assert_eq!(texts(src, TokenKind::KeywordConstant), ["iota"]);
+    }
+
+    #[test]
+    fn numbers_comments_and_directives() {
+        let src = "//go:build linux\n/* a\nb */ x := 0x1p-2 + 1_000.5e+3i + 0b1010 + 0o17 + .5 // done\n";
+        assert_eq!(texts(src, TokenKind::CommentPreproc), ["//go:build linux"]);
+        assert_eq!(texts(src, TokenKind::Comment), ["/* a\nb */", "// done"]);
+        assert_eq!(
+            texts(src, TokenKind::Number),
+            ["0x1p-2", "1_000.5e+3i", "0b1010", "0o17", ".5"]
+        );
+        assert_eq!(texts("a[1:]...", TokenKind::Operator), ["..."]);
+    }
+
+    #[test]
+    fn standard_library_matches_golden_tokens() {
+        // `slices/slices.go` from Go 1.27, as gofmt left it.
+        assert_fixture_tokens(&Go, "slices.go");
+    }
+
+    #[test]
+    fn sample_matches_golden_terminal_output() {
+        let theme = load_tmtheme(include_str!("../../../../examples/themes/monokai.tmTheme").as_bytes()).unwrap();
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::TrueColor);
+        assert_golden_output(
+            &Go,
+            &theme,
+            &formatter,
+            "../examples/languages/sample.go",
+            "../examples/golden/sample.go.ansi",
+        );
+    }
+}
@@ -0,0 +1,224 @@
+//! Lexers backed by the syntect grammars bundled through two-face.
+
+use super::{Lexer, LexerState, same_state};
+use crate::highlight::token::push_token;
+use crate::highlight::{HighlightError, Token, TokenKind};
+use crate::syntax::{find_syntax_by_name, syntax_set};
+
+use std::any::Any;
+use std::collections::HashMap;
+use std::sync::{Mutex, OnceLock};
+use syntect::parsing::{ParseState, ScopeStack, SyntaxReference};
+
+/// Lexer that parses source with a syntect grammar and classifies the resulting scope stacks into [TokenKind]s.
+#[derive(Debug, Clone, Copy)]
+pub struct GrammarLexer {
+    syntax: &'static SyntaxReference,
+}
+
+impl GrammarLexer {
+    /// Looks up a grammar by language name or file extension (e.g., "go", "rs").
+    pub fn find(name: &str) -> Option<Self> {
+        find_syntax_by_name(syntax_set(), name).map(|syntax| Self { syntax })
+    }
+
+    /// Like [GrammarLexer::find], but returns a shared `'static` lexer so states that embed other languages (e.g.,
+    /// Markdown code fences) can own the embedded lexer's state.
+    ///
+    /// Results are cached by name, so repeated lookups (one per fence or `<script>`, say) skip the search.
+    pub fn find_static(name: &str) -> Option<&'static GrammarLexer> {
+        static FOUND: OnceLock<Mutex<HashMap<String, Option<usize>>>> = OnceLock::new();
+        let all = shared();
+        let mut found = FOUND
+            .get_or_init(Default::default)
+            .lock()
+            .unwrap_or_else(|err| err.into_inner());
+        let index = *found.entry(name.to_string()).or_insert_with(|| {
+            let syntax = Self::find(name)?.syntax;
+            all.iter().position(|lexer| std::ptr::eq(lexer.syntax, syntax))
+        });
+        index.map(|index| &all[index])
+    }
+
+    /// Iterates over every bundled grammar.
+    pub fn all() -> impl Iterator<Item = Self> {
+        syntax_set().syntaxes().iter().map(|syntax| Self { syntax })
+    }
+
+    /// Loads every bundled grammar and compiles the patterns each one tries at the start of a document.
+    ///
+    /// Grammars are otherwise loaded on the first lookup and compiled pattern by pattern as lexing first reaches
+    /// them, which keeps a one-off highlight fast. Long-running servers can call this at startup to move that work
+    /// out of their first requests. Calling it again does nothing that wasn't already done.
+    pub fn precompile_all() {
+        for lexer in shared() {
+            let _ = lexer.start().tokenize_line("\n", 0, &mut Vec::new());
+        }
+    }
+}
+
+/// Every bundled grammar, as `'static` lexers.
+fn shared() -> &'static [GrammarLexer] {
+    static ALL: OnceLock<Vec<GrammarLexer>> = OnceLock::new();
+    ALL.get_or_init(|| GrammarLexer::all().collect())
+}
+
+impl Lexer for GrammarLexer {
+    fn name(&self) -> &str {
+        &self.syntax.name
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(GrammarState { parse: ParseState::new(self.syntax), stack: ScopeStack::new() })
+    }
+}
+
+/// Parser and scope stack carried between lines.
+#[derive(Clone, PartialEq)]
+struct GrammarState {
+    parse: ParseState,
+    stack: ScopeStack,
+}
+
+impl LexerState for GrammarState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let ops = self
+            .parse
+            .parse_line(line, syntax_set())
+            .map_err(|err| HighlightError::Lex(err.to_string()))?;
+
+        let mut cursor = 0;
+        for (position, op) in ops {
+            if position > cursor {
+                push_classified(tokens, &self.stack, line, offset, cursor..position);
+                cursor = position;
+            }
+            self.stack
+                .apply(&op)
+                .map_err(|err| HighlightError::Lex(err.to_string()))?;
+        }
+        push_classified(tokens, &self.stack, line, offset, cursor..line.len());
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+fn push_classified(
+    tokens: &mut Vec<Token>, stack: &ScopeStack, line: &str, offset: usize, span: std::ops::Range<usize>,
+) {
+    if span.is_empty() {
+        return;
+    }
+    let scopes: Vec<String> = stack.as_slice().iter().map(|scope| scope.build_string()).collect();
+    let mut kind = TokenKind::from_scopes(scopes.iter().map(String::as_str));
+    if kind == TokenKind::Text && line[span.clone()].trim().is_empty() {
+        kind = TokenKind::Whitespace;
+    }
+    push_token(tokens, kind, offset + span.start, offset + span.end);
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    use std::time::Instant;
+
+    const GO_SAMPLE: &str = include_str!("../../../../examples/languages/sample.go");
+
+    #[test]
+    fn go_tokens_round_trip_the_source() {
+        let lexer = GrammarLexer::find("go").expect("go grammar is bundled");
+        let tokens = lexer.tokenize(GO_SAMPLE).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(GO_SAMPLE)).collect();
+        assert_eq!(rebuilt, GO_SAMPLE);
+    }
+
+    #[test]
+    fn go_keywords_and_strings_are_classified() {
+        let lexer = GrammarLexer::find("go").unwrap();
+        let src = "package main\n\nfunc main() { println(\"hi\") }\n";
+        let tokens = lexer.tokenize(src).unwrap();
+        let kind_of = |text: &str| {
+            tokens
+                .iter()
+                .find(|token| token.text(src) == text)
+                .map(|token| token.kind)
+        };
+
+        assert!(matches!(
+            kind_of("package"),
+            Some(TokenKind::Keyword | TokenKind::KeywordDeclaration)
+        ));
+        assert_eq!(kind_of("\"hi\""), Some(TokenKind::String));
+    }
+
+    #[test]
+    fn every_grammar_round_trips_with_byte_accurate_offsets() {
+        let samples = [
+            GO_SAMPLE,
+            "// ünïcödé 👋 \"strings\" and 'chars'\n\tx := \"€\" /* ∑ */\r\n",
+            "<tag attr=\"ß\">{{ 日本語 }}</tag>\n# heading 🎨\n",
+            "no trailing newline ✓",
+        ];
+        for lexer in GrammarLexer::all() {
+            for src in samples {
+                let mut end = 0;
+                for item in lexer.tokens(src) {
+                    let (token, text) = item.unwrap_or_else(|err| panic!("{}: {err}", lexer.name()));
+                    assert_eq!(token.start, end, "{}: gap or overlap before {token:?}", lexer.name());
+                    assert_eq!(text, &src[token.start..token.end]);
+                    end = token.end;
+                }
+                assert_eq!(end, src.len(), "{} dropped trailing input", lexer.name());
+            }
+        }
+    }
+
+    #[test]
+    fn unknown_language_is_none() {
+        assert!(GrammarLexer::find("definitely-not-a-language").is_none());
+        assert!(GrammarLexer::find_static("definitely-not-a-language").is_none());
+    }
+
+    #[test]
+    fn concurrent_first_lookups_share_one_lexer() {
+        let found: Vec<_> = std::thread::scope(|scope| {
+            let workers: Vec<_> = (0..8)
+                .map(|i| {
+                    scope.spawn(move || {
+                        let name = if i % 2 == 0 { "go" } else { "Go" };
+                        GrammarLexer::find_static(name).map(|lexer| lexer as *const GrammarLexer as usize)
+                    })
+                })
+                .collect();
+            workers.into_iter().map(|worker| worker.join().unwrap()).collect()
+        });
+        assert!(found[0].is_some());
+        assert!(found.iter().all(|&lexer| lexer == found[0]));
+    }
+
+    #[test]
+    #[ignore = "timing; run with `cargo test --release -- --ignored`"]
+    fn repeated_highlights_reuse_loaded_grammars() {
+        let highlight = || {
+            let started = Instant::now();
+            let lexer = GrammarLexer::find_static("go").unwrap();
+            lexer.tokenize(GO_SAMPLE).unwrap();
+            started.elapsed()
+        };
+        let cold = highlight();
+        let warm = highlight();
+        assert!(warm * 10 < cold, "cold {cold:?}, warm {warm:?}");
+    }
+}
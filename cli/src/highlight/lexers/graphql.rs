@@ -0,0 +1,521 @@
+//! Stateful lexer for GraphQL schemas and operations.
+
+use super::{Cursor, Lexer, LexerState, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+const KEYWORDS: &[&str] = &[
+    "extend",
+    "implements",
+    "mutation",
+    "on",
+    "query",
+    "repeatable",
+    "subscription",
+];
+
+const DECLARATIONS: &[&str] = &[
+    "directive",
+    "enum",
+    "fragment",
+    "input",
+    "interface",
+    "scalar",
+    "schema",
+    "type",
+    "union",
+];
+
+const CONSTANTS: &[&str] = &["true", "false", "null"];
+
+/// The built-in scalar types.
+const TYPES: &[&str] = &["Boolean", "Float", "ID", "Int", "String"];
+
+/// Lexer for GraphQL, covering both type system documents (schemas) and executable ones (operations and fragments).
+///
+/// Keywords are only recognized between definitions, since inside braces words like `type` and `query` are ordinary
+/// field names. The names declared by `type`, `interface`, `enum`, `input`, `scalar`, and `union` are
+/// [TokenKind::NameClass] and those of operations and fragments [TokenKind::NameFunction]; type references are
+/// [TokenKind::NameClass] too, except for the built-in scalars, which are [TokenKind::KeywordType]. The `!` and `[]`
+/// that make a type non-null or a list are [TokenKind::Operator], apart from the [TokenKind::Punctuation] of list
+/// values. Fields and arguments are [TokenKind::Name], variables such as `$id` [TokenKind::NameVariable], directives
+/// such as `@deprecated` [TokenKind::NameAttribute], and enum values, including directive locations,
+/// [TokenKind::NameConstant].
+///
+/// Descriptions, the strings before a definition, field, argument, or enum value, are [TokenKind::CommentDoc];
+/// strings used as values are [TokenKind::String]. Either may be a `"""` block string spanning lines. Escapes are
+/// [TokenKind::StringEscape] and `#` comments [TokenKind::Comment].
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::GraphQL;
+///
+/// let src = "\"\"\"A user.\"\"\"\ntype User {\n  name: String! @deprecated(reason: \"Use handle\")\n}\n";
+/// let tokens = GraphQL.tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("\"\"\"A user.\"\"\""), Some(TokenKind::CommentDoc));
+/// assert_eq!(kind("User"), Some(TokenKind::NameClass));
+/// assert_eq!(kind("String"), Some(TokenKind::KeywordType));
+/// assert_eq!(kind("!"), Some(TokenKind::Operator));
+/// assert_eq!(kind("@deprecated"), Some(TokenKind::NameAttribute));
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct GraphQL;
+
+impl Lexer for GraphQL {
+    fn name(&self) -> &str {
+        "GraphQL"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(GraphQLState {
+            mode: Mode::Code,
+            groups: Vec::new(),
+            expect: Expect::Nothing,
+            body_next: None,
+            paren_next: Group::Arguments,
+            type_next: false,
+            list_types: 0,
+            after_equals: false,
+            after_spread: false,
+            directive: false,
+            locations: false,
+        })
+    }
+}
+
+/// What the next characters continue.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Mode {
+    Code,
+    /// Inside a `"""` block string; `description` unless it is a value.
+    BlockString {
+        description: bool,
+    },
+}
+
+/// An open bracket.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Group {
+    /// A selection set.
+    Selection,
+    /// The fields of a `type`, `interface`, `input`, or `schema`.
+    Definition,
+    /// The values of an `enum`.
+    Enum,
+    /// Arguments passed to a field or directive.
+    Arguments,
+    /// Argument or variable definitions, which give types.
+    Parameters,
+    /// An `{ ... }` input object value.
+    Object,
+    /// A `[ ... ]` list value.
+    List,
+}
+
+/// A declared name the next identifier may be.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Expect {
+    Nothing,
+    TypeName,
+    /// The name of an operation or fragment.
+    OperationName,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct GraphQLState {
+    mode: Mode,
+    groups: Vec<Group>,
+    expect: Expect,
+    /// What the next `{` between definitions opens, set by the keyword of the definition.
+    body_next: Option<Group>,
+    /// What the next `(` opens.
+    paren_next: Group,
+    /// After the `:` of a field, argument, or variable definition (or a `[` after it), where a type comes next.
+    type_next: bool,
+    /// `[`s of list types not yet closed.
+    list_types: usize,
+    /// The last token was `=`, so a default value comes next.
+    after_equals: bool,
+    /// The last token was `...`, so a fragment name or `on` comes next.
+    after_spread: bool,
+    /// In a `directive` definition, before its `on`.
+    directive: bool,
+    /// In the locations listed after a directive definition's `on`.
+    locations: bool,
+}
+
+impl LexerState for GraphQLState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        while cursor.pos < line.len() {
+            match self.mode {
+                Mode::Code => self.code(&mut cursor),
+                Mode::BlockString { description } => self.block_string(&mut cursor, description),
+            }
+        }
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl GraphQLState {
+    fn code(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+
+        if ch.is_whitespace() {
+            cursor.emit(TokenKind::Whitespace, cursor.run_until(|ch| !ch.is_whitespace()));
+            return;
+        }
+        if ch == '#' {
+            cursor.emit(TokenKind::Comment, cursor.run_until(|ch| ch == '\n'));
+            return;
+        }
+
+        let (mut expect, mut paren_next) = (Expect::Nothing, Group::Arguments);
+        let (mut type_next, mut after_equals, mut after_spread) = (false, false, false);
+        let description = !self.in_value();
+        match ch {
+            '"' if rest.starts_with("\"\"\"") => {
+                cursor.emit(if description { TokenKind::CommentDoc } else { TokenKind::String }, 3);
+                self.mode = Mode::BlockString { description };
+            }
+            '"' => string(
+                cursor,
+                if description { TokenKind::CommentDoc } else { TokenKind::String },
+            ),
+            '$' | '@' if rest[1..].starts_with(is_name_start) => {
+                let len = 1 + rest[1..].find(|ch| !is_name_char(ch)).unwrap_or(rest.len() - 1);
+                if ch == '@' && self.directive {
+                    paren_next = Group::Parameters;
+                }
+                cursor.emit(
+                    if ch == '$' { TokenKind::NameVariable } else { TokenKind::NameAttribute },
+                    len,
+                );
+            }
+            '0'..='9' => cursor.emit(TokenKind::Number, number_len(rest)),
+            '-' if rest[1..].starts_with(|ch: char| ch.is_ascii_digit()) => {
+                cursor.emit(TokenKind::Number, number_len(rest))
+            }
+            _ if is_name_start(ch) => {
+                let len = cursor.run_until(|ch| !is_name_char(ch));
+                let word = &rest[..len];
+                let kind = self.word(word, rest[len..].trim_start());
+                match (kind, word) {
+                    (TokenKind::KeywordDeclaration, "type" | "interface" | "input") => {
+                        (expect, self.body_next) = (Expect::TypeName, Some(Group::Definition))
+                    }
+                    (TokenKind::KeywordDeclaration, "enum") => {
+                        (expect, self.body_next) = (Expect::TypeName, Some(Group::Enum))
+                    }
+                    (TokenKind::KeywordDeclaration, "scalar" | "union") => expect = Expect::TypeName,
+                    (TokenKind::KeywordDeclaration, "schema") => self.body_next = Some(Group::Definition),
+                    (TokenKind::KeywordDeclaration, "fragment") => expect = Expect::OperationName,
+                    (TokenKind::KeywordDeclaration, "directive") => self.directive = true,
+                    (TokenKind::Keyword, "query" | "mutation" | "subscription") => {
+                        (expect, paren_next) = (Expect::OperationName, Group::Parameters)
+                    }
+                    (TokenKind::Keyword, "on") if self.directive => (self.directive, self.locations) = (false, true),
+                    (TokenKind::Keyword, "on") => expect = Expect::TypeName,
+                    (TokenKind::NameFunction, _) if self.expect == Expect::OperationName => {
+                        paren_next = Group::Parameters
+                    }
+                    (TokenKind::Name, _) if self.groups.last() == Some(&Group::Definition) => {
+                        paren_next = Group::Parameters
+                    }
+                    _ => {}
+                }
+                if kind == TokenKind::KeywordDeclaration || (kind == TokenKind::Keyword && word != "on") {
+                    self.locations = false;
+                }
+                cursor.emit(kind, len);
+            }
+            '{' => {
+                let group = if self.in_value() {
+                    Group::Object
+                } else if self.groups.is_empty() {
+                    self.body_next.take().unwrap_or(Group::Selection)
+                } else {
+                    Group::Selection
+                };
+                self.groups.push(group);
+                (self.directive, self.locations) = (false, false);
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '(' => {
+                self.groups.push(self.paren_next);
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '[' if self.type_next => {
+                self.list_types += 1;
+                type_next = true;
+                cursor.emit(TokenKind::Operator, 1);
+            }
+            ']' if self.list_types > 0 => {
+                self.list_types -= 1;
+                cursor.emit(TokenKind::Operator, 1);
+            }
+            '[' => {
+                self.groups.push(Group::List);
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '}' | ')' | ']' => {
+                self.groups.pop();
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ':' => {
+                type_next =
+                    !self.in_value() && matches!(self.groups.last(), Some(Group::Definition | Group::Parameters));
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '.' if rest.starts_with("...") => {
+                after_spread = true;
+                cursor.emit(TokenKind::Punctuation, 3);
+            }
+            ',' => cursor.emit(TokenKind::Punctuation, 1),
+            '=' => {
+                after_equals = true;
+                cursor.emit(TokenKind::Operator, 1);
+            }
+            '!' | '|' | '&' => cursor.emit(TokenKind::Operator, 1),
+            _ => cursor.emit(TokenKind::Error, ch.len_utf8()),
+        }
+        self.expect = expect;
+        self.paren_next = paren_next;
+        self.type_next = type_next;
+        self.after_equals = after_equals;
+        self.after_spread = after_spread;
+    }
+
+    /// Whether the cursor is in a value: an argument, a default, or inside an object or list value.
+    fn in_value(&self) -> bool {
+        (self.after_equals && !self.groups.is_empty())
+            || matches!(self.groups.last(), Some(Group::Arguments | Group::Object | Group::List))
+    }
+
+    /// Classifies a name, given the rest of the line after it with leading whitespace removed.
+    fn word(&self, word: &str, next: &str) -> TokenKind {
+        if self.type_next {
+            return if TYPES.contains(&word) { TokenKind::KeywordType } else { TokenKind::NameClass };
+        }
+        match self.expect {
+            Expect::TypeName => return TokenKind::NameClass,
+            Expect::OperationName => return TokenKind::NameFunction,
+            Expect::Nothing => {}
+        }
+        if self.in_value() {
+            return if matches!(self.groups.last(), Some(Group::Arguments | Group::Object)) && next.starts_with(':') {
+                TokenKind::Name
+            } else if CONSTANTS.contains(&word) {
+                TokenKind::KeywordConstant
+            } else {
+                TokenKind::NameConstant
+            };
+        }
+        match self.groups.last() {
+            None if KEYWORDS.contains(&word) => TokenKind::Keyword,
+            None if DECLARATIONS.contains(&word) => TokenKind::KeywordDeclaration,
+            None if self.locations => TokenKind::NameConstant,
+            None => TokenKind::NameClass,
+            Some(Group::Enum) => TokenKind::NameConstant,
+            Some(Group::Selection) if self.after_spread && word == "on" => TokenKind::Keyword,
+            Some(Group::Selection) if self.after_spread => TokenKind::NameFunction,
+            Some(_) => TokenKind::Name,
+        }
+    }
+
+    /// Lexes part of a block string, whose only escape is `\"""`.
+    fn block_string(&mut self, cursor: &mut Cursor, description: bool) {
+        let kind = if description { TokenKind::CommentDoc } else { TokenKind::String };
+        let rest = cursor.rest();
+        let close = rest.find("\"\"\"");
+        match rest.find("\\\"\"\"") {
+            Some(0) => cursor.emit(TokenKind::StringEscape, 4),
+            Some(escape) if close.is_none_or(|close| escape < close) => cursor.emit(kind, escape),
+            _ => match close {
+                Some(close) => {
+                    cursor.emit(kind, close + 3);
+                    self.mode = Mode::Code;
+                }
+                None => cursor.emit(kind, rest.len()),
+            },
+        }
+    }
+}
+
+fn is_name_start(ch: char) -> bool {
+    ch == '_' || ch.is_ascii_alphabetic()
+}
+
+fn is_name_char(ch: char) -> bool {
+    ch == '_' || ch.is_ascii_alphanumeric()
+}
+
+/// Lexes a single-line string as `kind`, which ends at its closing quote or, unterminated, at the end of the line.
+fn string(cursor: &mut Cursor, kind: TokenKind) {
+    cursor.emit(kind, 1);
+    loop {
+        let rest = cursor.rest();
+        match cursor.peek() {
+            None | Some('\n') => return,
+            Some('"') => {
+                cursor.emit(kind, 1);
+                return;
+            }
+            Some('\\') => match escape_len(rest) {
+                Some(len) => cursor.emit(TokenKind::StringEscape, len),
+                None => {
+                    let next = rest[1..].chars().next().filter(|&ch| ch != '\n');
+                    cursor.emit(TokenKind::Error, 1 + next.map_or(0, char::len_utf8));
+                }
+            },
+            Some(_) => {
+                let len = rest.find(['"', '\\', '\n']).unwrap_or(rest.len());
+                cursor.emit(kind, len);
+            }
+        }
+    }
+}
+
+/// Length of the escape sequence `rest` starts with, or `None` when it isn't a valid one.
+fn escape_len(rest: &str) -> Option<usize> {
+    match rest[1..].chars().next()? {
+        '"' | '\\' | '/' | 'b' | 'f' | 'n' | 'r' | 't' => Some(2),
+        'u' if rest[2..].starts_with('{') => {
+            let close = rest.find('}')?;
+            let digits = &rest[3..close];
+            (!digits.is_empty() && digits.chars().all(|ch| ch.is_ascii_hexdigit())).then_some(close + 1)
+        }
+        'u' => {
+            let digits = rest.get(2..6)?;
+            digits.chars().all(|ch| ch.is_ascii_hexdigit()).then_some(6)
+        }
+        _ => None,
+    }
+}
+
+/// Length of the number literal `rest` starts with: an integer or float, with an optional leading `-`.
+fn number_len(rest: &str) -> usize {
+    let bytes = rest.as_bytes();
+    let mut len = usize::from(bytes[0] == b'-');
+    while let Some(&byte) = bytes.get(len) {
+        let exponent_sign = matches!(byte, b'+' | b'-') && matches!(bytes[len - 1], b'e' | b'E');
+        if byte.is_ascii_alphanumeric() || byte == b'.' || exponent_sign {
+            len += 1;
+        } else {
+            break;
+        }
+    }
+    len
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::assert_fixture_tokens;
+
+    /// Non-whitespace tokens as (kind, text) pairs.
+    fn kinds(src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = GraphQL.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    fn texts(src: &str, kind: TokenKind) -> Vec<&str> {
+        kinds(src)
+            .into_iter()
+            .filter(|(k, _)| *k == kind)
+            .map(|(_, text)| text)
+            .collect()
+    }
+
+    #[test]
+    fn type_definitions_split_names_types_and_modifiers() {
+        let src = "type Query implements Node & Entity {\n  type: String\n  users(first: Int = 10, role: Role = ADMIN, tags: [String!]! = [\"a\"]): [[User!]]!\n}\nunion Result = User | Error\n";
+        assert_eq!(
+            texts(src, TokenKind::NameClass),
+            ["Query", "Node", "Entity", "Role", "User", "Result", "User", "Error"]
+        );
+        assert_eq!(texts(src, TokenKind::KeywordType), ["String", "Int", "String"]);
+        assert_eq!(texts(src, TokenKind::Name), ["type", "users", "first", "role", "tags"]);
+        assert_eq!(texts(src, TokenKind::NameConstant), ["ADMIN"]);
+        assert_eq!(
+            texts(src, TokenKind::Operator),
+            // Neighboring operators merge into one token.
+            ["&", "=", "=", "[", "!]!", "=", "[[", "!]]!", "=", "|"]
+        );
+        assert_eq!(texts(src, TokenKind::String), ["\"a\""]);
+    }
+
+    #[test]
+    fn descriptions_are_doc_strings_and_values_are_strings() {
+        let src = "\"\"\"\nA person, with \\\"\"\" quotes.\n\"\"\"\nenum Role {\n  \"Can do anything.\" ADMIN\n  USER @deprecated(reason: \"\"\"Use\nMEMBER\"\"\")\n}\n";
+        assert_eq!(
+            texts(src, TokenKind::CommentDoc),
+            ["\"\"\"\nA person, with ", " quotes.\n\"\"\"", "\"Can do anything.\""]
+        );
+        assert_eq!(texts(src, TokenKind::StringEscape), ["\\\"\"\""]);
+        assert_eq!(texts(src, TokenKind::NameConstant), ["ADMIN", "USER"]);
+        assert_eq!(texts(src, TokenKind::String), ["\"\"\"Use\nMEMBER\"\"\""]);
+        assert_eq!(texts(src, TokenKind::NameAttribute), ["@deprecated"]);
+    }
+
+    #[test]
+    fn operations_with_variables_and_fragments() {
+        let src = "query GetUser($id: ID!, $first: Int = 5) @cached {\n  user(id: $id, filter: {type: ACTIVE, after: null}) {\n    ...UserFields\n    ... on Admin { level }\n    alias: posts(first: $first) @include(if: true) { id }\n  }\n}\nfragment UserFields on User { name }\n{ me { id } }\n";
+        assert_eq!(
+            texts(src, TokenKind::NameFunction),
+            ["GetUser", "UserFields", "UserFields"]
+        );
+        assert_eq!(texts(src, TokenKind::NameClass), ["Admin", "User"]);
+        assert_eq!(texts(src, TokenKind::KeywordType), ["ID", "Int"]);
+        assert_eq!(texts(src, TokenKind::NameVariable), ["$id", "$first", "$id", "$first"]);
+        assert_eq!(texts(src, TokenKind::NameConstant), ["ACTIVE"]);
+        assert_eq!(texts(src, TokenKind::KeywordConstant), ["null", "true"]);
+        assert_eq!(texts(src, TokenKind::Keyword), ["query", "on", "on"]);
+        assert_eq!(texts(src, TokenKind::Number), ["5"]);
+        assert!(texts(src, TokenKind::Name).contains(&"type"));
+    }
+
+    #[test]
+    fn directive_definitions_list_locations() {
+        let src = "directive @auth(requires: Role = ADMIN) repeatable on OBJECT | FIELD_DEFINITION\n# done\nscalar Date @specifiedBy(url: \"https://x\\u00e9\\q\")\nschema { query: Query }\n";
+        assert_eq!(
+            texts(src, TokenKind::NameConstant),
+            ["ADMIN", "OBJECT", "FIELD_DEFINITION"]
+        );
+        assert_eq!(texts(src, TokenKind::NameClass), ["Role", "Date", "Query"]);
+        assert_eq!(texts(src, TokenKind::Name), ["requires", "url", "query"]);
+        assert_eq!(texts(src, TokenKind::Keyword), ["repeatable", "on"]);
+        assert_eq!(texts(src, TokenKind::StringEscape), ["\\u00e9"]);
+        assert_eq!(texts(src, TokenKind::Error), ["\\q"]);
+        assert_eq!(texts(src, TokenKind::Comment), ["# done"]);
+        assert_eq!(texts("f(a: -1.5e-3, b: 0)", TokenKind::Number), ["-1.5e-3", "0"]);
+    }
+
+    #[test]
+    fn api_schema_matches_golden_tokens() {
+        assert_fixture_tokens(&GraphQL, "schema.graphql");
+    }
+}
@@ -0,0 +1,516 @@
+//! HTML lexer that hands `<script>` and `<style>` bodies to the JavaScript, JSON, and CSS lexers.
+
+use super::embed::{Embedded, Resolver};
+use super::{Cursor, Lexer, LexerState, find, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+/// Lexer for HTML documents.
+///
+/// Tags are [TokenKind::NameTag], attributes [TokenKind::NameAttribute] with [TokenKind::String] values, character
+/// references [TokenKind::StringEscape], comments (including conditional comments and CDATA sections)
+/// [TokenKind::Comment], and doctypes [TokenKind::CommentPreproc]. The bodies of `<style>` and `<script>` elements
+/// go to the CSS and JavaScript lexers, or to JSON for `type="application/json"` and similar data blocks. Scripts
+/// with a `src` attribute or an unrecognized `type` (templates, for instance) are left as plain text.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Html;
+///
+/// let src = "<p class=\"lead\">Fish &amp; chips</p>\n";
+/// let tokens = Html::new().tokenize(src).unwrap();
+/// let tags: Vec<_> = tokens.iter().filter(|t| t.kind == TokenKind::NameTag).map(|t| t.text(src)).collect();
+/// assert_eq!(tags, ["p", "p"]);
+/// assert!(tokens.iter().any(|t| t.kind == TokenKind::StringEscape && t.text(src) == "&amp;"));
+/// ```
+#[derive(Debug, Clone, Copy)]
+pub struct Html {
+    resolve: Resolver,
+}
+
+impl Html {
+    /// Creates a lexer that resolves embedded languages with [super::find].
+    pub const fn new() -> Self {
+        Self { resolve: find }
+    }
+
+    /// Creates a lexer that resolves embedded languages ("javascript", "css", "json") with `resolve`.
+    pub const fn with_resolver(resolve: Resolver) -> Self {
+        Self { resolve }
+    }
+}
+
+impl Default for Html {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Lexer for Html {
+    fn name(&self) -> &str {
+        "HTML"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(HtmlState { resolve: self.resolve, mode: Mode::Content })
+    }
+}
+
+/// Elements whose contents are raw text in another language rather than markup.
+const RAW_ELEMENTS: &[&str] = &["script", "style"];
+
+/// A tag being read, possibly across lines.
+#[derive(Clone, PartialEq)]
+struct Tag {
+    name: String,
+    closing: bool,
+    /// Lowercased name of the attribute most recently read.
+    attribute: String,
+    /// After `=`, waiting for the attribute's value.
+    expect_value: bool,
+    /// Inside a quoted attribute value, with its quote character.
+    quote: Option<char>,
+    /// Value of the `type` attribute, which decides how a script body is lexed.
+    kind: Option<String>,
+    src: bool,
+}
+
+impl Tag {
+    fn new(name: &str, closing: bool) -> Self {
+        Self {
+            name: name.to_ascii_lowercase(),
+            closing,
+            attribute: String::new(),
+            expect_value: false,
+            quote: None,
+            kind: None,
+            src: false,
+        }
+    }
+
+    /// Records part of the current attribute's value.
+    fn value(&mut self, text: &str) {
+        if self.attribute == "type" {
+            self.kind.get_or_insert_with(String::new).push_str(text);
+        }
+    }
+
+    /// Language for the raw body of this element, or `None` to leave it as plain text.
+    fn body_language(&self) -> Option<&'static str> {
+        let kind = self.kind.as_deref().map(|kind| {
+            let mime = kind.split(';').next().unwrap_or_default();
+            mime.trim().to_ascii_lowercase()
+        });
+        match (self.name.as_str(), kind.as_deref()) {
+            ("style", None | Some("" | "text/css")) => Some("css"),
+            // An external script's body is ignored by browsers, so there is nothing to highlight.
+            ("script", _) if self.src => None,
+            (
+                "script",
+                None
+                | Some(
+                    ""
+                    | "module"
+                    | "text/javascript"
+                    | "application/javascript"
+                    | "text/ecmascript"
+                    | "application/ecmascript",
+                ),
+            ) => Some("javascript"),
+            ("script", Some("application/json" | "application/ld+json" | "importmap" | "speculationrules")) => {
+                Some("json")
+            }
+            _ => None,
+        }
+    }
+}
+
+#[derive(PartialEq)]
+enum Mode {
+    Content,
+    /// `<!-- ... -->`, which also covers conditional comments.
+    Comment,
+    /// `<![CDATA[ ... ]]>`
+    Cdata,
+    /// `<!DOCTYPE ...>` and `<?...>`
+    Declaration,
+    Tag(Tag),
+    /// Body of a `<script>` or `<style>` element, up to its closing tag.
+    Raw {
+        element: String,
+        body: Embedded,
+    },
+}
+
+struct HtmlState {
+    resolve: Resolver,
+    mode: Mode,
+}
+
+/// States of one document share their resolver, so it is left out of the comparison.
+impl PartialEq for HtmlState {
+    fn eq(&self, other: &Self) -> bool {
+        self.mode == other.mode
+    }
+}
+
+impl LexerState for HtmlState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        while cursor.pos < line.len() {
+            match &mut self.mode {
+                Mode::Content => self.content(&mut cursor),
+                Mode::Comment => self.until(&mut cursor, "-->", TokenKind::Comment),
+                Mode::Cdata => self.until(&mut cursor, "]]>", TokenKind::Comment),
+                Mode::Declaration => self.until(&mut cursor, ">", TokenKind::CommentPreproc),
+                Mode::Tag(_) => self.tag(&mut cursor),
+                Mode::Raw { element, body } => {
+                    let rest = cursor.rest();
+                    let end = find_closing_tag(rest, element).unwrap_or(rest.len());
+                    body.tokenize(&rest[..end], cursor.offset + cursor.pos, cursor.tokens)?;
+                    cursor.pos += end;
+                    if end < rest.len() {
+                        self.mode = Mode::Content;
+                    }
+                }
+            }
+        }
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        let mode = match &self.mode {
+            Mode::Content => Mode::Content,
+            Mode::Comment => Mode::Comment,
+            Mode::Cdata => Mode::Cdata,
+            Mode::Declaration => Mode::Declaration,
+            Mode::Tag(tag) => Mode::Tag(tag.clone()),
+            Mode::Raw { element, body } => Mode::Raw { element: element.clone(), body: body.snapshot()? },
+        };
+        Some(Box::new(HtmlState { resolve: self.resolve, mode }))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl HtmlState {
+    fn content(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        if rest.starts_with("<!--") {
+            cursor.emit(TokenKind::Comment, 4);
+            self.mode = Mode::Comment;
+        } else if rest.starts_with("<![CDATA[") {
+            cursor.emit(TokenKind::Comment, 9);
+            self.mode = Mode::Cdata;
+        } else if rest.starts_with("<!") || rest.starts_with("<?") {
+            cursor.emit(TokenKind::CommentPreproc, 2);
+            self.mode = Mode::Declaration;
+        } else if let Some(after) = rest.strip_prefix('<') {
+            let closing = after.starts_with('/');
+            let name = tag_name_len(&after[usize::from(closing)..]);
+            if name == 0 {
+                // A bare `<` (as in `a < b`) is text.
+                cursor.emit(TokenKind::Text, 1);
+                return;
+            }
+            cursor.emit(TokenKind::Punctuation, 1 + usize::from(closing));
+            self.mode = Mode::Tag(Tag::new(&cursor.rest()[..name], closing));
+            cursor.emit(TokenKind::NameTag, name);
+        } else if let Some(len) = entity_len(rest) {
+            cursor.emit(TokenKind::StringEscape, len);
+        } else if rest.starts_with('&') {
+            cursor.emit(TokenKind::Text, 1);
+        } else {
+            let len = cursor.run_until(|ch| ch == '<' || ch == '&');
+            cursor.emit(TokenKind::Text, len);
+        }
+    }
+
+    /// Emits `kind` through `terminator`, or through the end of the line if it isn't there.
+    fn until(&mut self, cursor: &mut Cursor, terminator: &str, kind: TokenKind) {
+        let rest = cursor.rest();
+        match rest.find(terminator) {
+            Some(end) => {
+                cursor.emit(kind, end + terminator.len());
+                self.mode = Mode::Content;
+            }
+            None => cursor.emit(kind, rest.len()),
+        }
+    }
+
+    fn tag(&mut self, cursor: &mut Cursor) {
+        let Mode::Tag(tag) = &mut self.mode else { unreachable!() };
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+
+        if let Some(quote) = tag.quote {
+            match rest.find(quote) {
+                Some(end) => {
+                    tag.value(&rest[..end]);
+                    tag.quote = None;
+                    cursor.emit(TokenKind::String, end + 1);
+                }
+                None => {
+                    tag.value(rest);
+                    cursor.emit(TokenKind::String, rest.len());
+                }
+            }
+            return;
+        }
+
+        match ch {
+            _ if ch.is_whitespace() => {
+                let len = cursor.run_until(|ch| !ch.is_whitespace());
+                cursor.emit(TokenKind::Whitespace, len);
+            }
+            '>' => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                let tag = match std::mem::replace(&mut self.mode, Mode::Content) {
+                    Mode::Tag(tag) => tag,
+                    _ => unreachable!(),
+                };
+                if !tag.closing && RAW_ELEMENTS.contains(&tag.name.as_str()) {
+                    let body = Embedded::start(self.resolve, tag.body_language());
+                    self.mode = Mode::Raw { element: tag.name, body };
+                }
+            }
+            '/' if rest.starts_with("/>") => {
+                cursor.emit(TokenKind::Punctuation, 2);
+                self.mode = Mode::Content;
+            }
+            '=' => {
+                tag.expect_value = true;
+                cursor.emit(TokenKind::Operator, 1);
+            }
+            '"' | '\'' if tag.expect_value => {
+                tag.expect_value = false;
+                tag.quote = Some(ch);
+                cursor.emit(TokenKind::String, 1);
+            }
+            _ if tag.expect_value => {
+                tag.expect_value = false;
+                let len = cursor.run_until(|ch| ch.is_whitespace() || ch == '>');
+                tag.value(&rest[..len]);
+                cursor.emit(TokenKind::String, len);
+            }
+            _ => {
+                let len = cursor.run_until(|ch| ch.is_whitespace() || matches!(ch, '=' | '>' | '/' | '"' | '\''));
+                if len == 0 {
+                    cursor.emit(TokenKind::Punctuation, ch.len_utf8());
+                    return;
+                }
+                tag.attribute = rest[..len].to_ascii_lowercase();
+                tag.src |= tag.attribute == "src";
+                cursor.emit(TokenKind::NameAttribute, len);
+            }
+        }
+    }
+}
+
+/// Length of the tag name at the start of `text`, or 0 if it doesn't start with one.
+fn tag_name_len(text: &str) -> usize {
+    if !text.starts_with(|ch: char| ch.is_ascii_alphabetic()) {
+        return 0;
+    }
+    text.find(|ch: char| !(ch.is_ascii_alphanumeric() || matches!(ch, '-' | ':' | '_' | '.')))
+        .unwrap_or(text.len())
+}
+
+/// Finds `</element` (ASCII case-insensitively) where it ends the raw body, returning its byte offset.
+fn find_closing_tag(text: &str, element: &str) -> Option<usize> {
+    let lower = text.to_ascii_lowercase();
+    let mut from = 0;
+    while let Some(found) = lower[from..].find("</") {
+        let start = from + found;
+        let after = &lower[start + 2..];
+        if let Some(tail) = after.strip_prefix(element) {
+            let next = tail.chars().next();
+            if next.is_none_or(|ch| ch.is_ascii_whitespace() || ch == '>' || ch == '/') {
+                return Some(start);
+            }
+        }
+        from = start + 2;
+    }
+    None
+}
+
+/// Length of a character reference (`&amp;`, `&#39;`, `&#x1F600;`) at the start of `text`.
+pub(super) fn entity_len(text: &str) -> Option<usize> {
+    let body = text.strip_prefix('&')?;
+    let (prefix, valid): (usize, fn(&char) -> bool) = match body.as_bytes() {
+        [b'#', b'x' | b'X', ..] => (2, char::is_ascii_hexdigit),
+        [b'#', ..] => (1, char::is_ascii_digit),
+        _ => (0, char::is_ascii_alphanumeric),
+    };
+    let len = body[prefix..].chars().take_while(valid).count();
+    (len > 0 && body[prefix + len..].starts_with(';')).then_some(1 + prefix + len + 1)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Marks everything it sees with one kind, so tests can tell which lexer handled a region.
+    struct Marker(TokenKind);
+
+    impl Lexer for Marker {
+        fn name(&self) -> &str {
+            "Marker"
+        }
+
+        fn start(&self) -> Box<dyn LexerState + '_> {
+            Box::new(Marker(self.0))
+        }
+    }
+
+    impl LexerState for Marker {
+        fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+            crate::highlight::token::push_token(tokens, self.0, offset, offset + line.len());
+            Ok(())
+        }
+    }
+
+    static JAVASCRIPT: Marker = Marker(TokenKind::KeywordDeclaration);
+    static CSS: Marker = Marker(TokenKind::NameClass);
+    static JSON: Marker = Marker(TokenKind::NameConstant);
+
+    fn resolve(language: &str) -> Option<&'static dyn Lexer> {
+        let lexer: &'static dyn Lexer = match language {
+            "javascript" => &JAVASCRIPT,
+            "css" => &CSS,
+            "json" => &JSON,
+            _ => return None,
+        };
+        Some(lexer)
+    }
+
+    const HTML: Html = Html::with_resolver(resolve);
+
+    fn kinds(src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = HTML.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    #[test]
+    fn tags_attributes_and_entities() {
+        let src =
+            "<!DOCTYPE html>\n<a href=\"/x?a=1&amp;b=2\" data-id=7 hidden>Tom &amp; Jerry &copy; a < b &#x27;</a>\n";
+        let tokens = kinds(src);
+        assert_eq!(tokens[0], (TokenKind::CommentPreproc, "<!DOCTYPE html>"));
+        assert!(tokens.contains(&(TokenKind::NameAttribute, "href")));
+        assert!(tokens.contains(&(TokenKind::String, "\"/x?a=1&amp;b=2\"")));
+        assert!(tokens.contains(&(TokenKind::String, "7")));
+        assert!(tokens.contains(&(TokenKind::NameAttribute, "hidden")));
+        assert!(tokens.contains(&(TokenKind::StringEscape, "&copy;")));
+        assert!(tokens.contains(&(TokenKind::StringEscape, "&#x27;")));
+        assert!(tokens.contains(&(TokenKind::Text, " a < b ")));
+        assert!(tokens.contains(&(TokenKind::Punctuation, "</")));
+    }
+
+    #[test]
+    fn script_and_style_bodies_are_delegated_with_absolute_offsets() {
+        let src = "<style>\np { color: red }\n</style>\n<script>let x = 1;</script>\n";
+        let tokens = HTML.tokenize(src).unwrap();
+        let css = tokens.iter().find(|token| token.kind == TokenKind::NameClass).unwrap();
+        assert_eq!(css.text(src), "\np { color: red }\n");
+        assert_eq!(css.start, src.find("\np {").unwrap());
+        let js = tokens
+            .iter()
+            .find(|token| token.kind == TokenKind::KeywordDeclaration)
+            .unwrap();
+        assert_eq!(js.text(src), "let x = 1;");
+        assert_eq!(
+            kinds(src)
+                .iter()
+                .filter(|(kind, _)| *kind == TokenKind::NameTag)
+                .count(),
+            4
+        );
+    }
+
+    #[test]
+    fn script_type_picks_the_language() {
+        let cases = [
+            (
+                "<script type=\"application/json\">{}</script>",
+                Some(TokenKind::NameConstant),
+            ),
+            ("<script type='importmap'>{}</script>", Some(TokenKind::NameConstant)),
+            ("<script type=module>{}</script>", Some(TokenKind::KeywordDeclaration)),
+            (
+                "<SCRIPT TYPE=\"text/javascript; charset=utf-8\">{}</SCRIPT>",
+                Some(TokenKind::KeywordDeclaration),
+            ),
+            ("<script type=\"text/x-template\">{}</script>", Some(TokenKind::Text)),
+            ("<script src=\"app.js\">{}</script>", Some(TokenKind::Text)),
+        ];
+        for (src, expected) in cases {
+            let body = kinds(src)
+                .into_iter()
+                .find(|(_, text)| *text == "{}")
+                .map(|(kind, _)| kind);
+            assert_eq!(body, expected, "{src}");
+        }
+    }
+
+    #[test]
+    fn scripts_end_only_at_their_closing_tag() {
+        let src = "<script>\nif (a </b) { s = \"</scripts>\" }\n</script >after";
+        let tokens = kinds(src);
+        assert_eq!(
+            tokens[3],
+            (TokenKind::KeywordDeclaration, "\nif (a </b) { s = \"</scripts>\" }\n")
+        );
+        assert_eq!(tokens.last(), Some(&(TokenKind::Text, "after")));
+    }
+
+    #[test]
+    fn comments_cdata_and_conditional_comments() {
+        let src = "<!--[if IE]><p>old</p><![endif]-->\n<svg><![CDATA[ x < y ]]></svg>\n<!-- multi\nline --><b>";
+        let tokens = kinds(src);
+        assert_eq!(tokens[0], (TokenKind::Comment, "<!--[if IE]><p>old</p><![endif]-->"));
+        assert!(tokens.contains(&(TokenKind::Comment, "<![CDATA[ x < y ]]>")));
+        assert!(tokens.contains(&(TokenKind::Comment, "<!-- multi\nline -->")));
+        assert_eq!(tokens.last(), Some(&(TokenKind::Punctuation, ">")));
+    }
+
+    #[test]
+    fn multi_line_tags_keep_their_attributes() {
+        let src = "<script\n  type=\"application/\njson\"\n>[1]</script>";
+        assert!(kinds(src).contains(&(TokenKind::Text, "[1]")));
+        let src = "<script\n  type=\"application/json\"\n>[1]</script>";
+        assert!(kinds(src).contains(&(TokenKind::NameConstant, "[1]")));
+    }
+
+    #[test]
+    fn unclosed_constructs_at_eof() {
+        for src in [
+            "<div class=\"open",
+            "<script>let x",
+            "<!-- never",
+            "<style",
+            "<",
+            "&amp",
+            "<a href=",
+            "<![CDATA[ x",
+        ] {
+            let tokens = HTML.tokenize(src).unwrap();
+            assert_eq!(tokens.last().map(|token| token.end), Some(src.len()), "{src:?}");
+        }
+    }
+}
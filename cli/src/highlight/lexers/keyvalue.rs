@@ -0,0 +1,687 @@
+//! Stateful lexer for key/value config files: INI, Git config, dotenv, and Java properties.
+
+use super::{Cursor, Lexer, LexerState, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+/// A key/value config format, whose syntax a [KeyValue] lexer follows.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Default)]
+pub enum KeyValueDialect {
+    /// Classic INI, as Windows and Python's `configparser` read it: `[section]` headers, `;` and `#` comments, `=` or
+    /// `:` between key and value, and `%(name)s` and `${section:key}` interpolation.
+    #[default]
+    Ini,
+    /// Git's config files: INI with `[section "subsection"]` headers, comments anywhere outside quotes, backslash
+    /// escapes, and values continued by a trailing backslash.
+    GitConfig,
+    /// dotenv files: no sections, `export` prefixes, single- and double-quoted values that may span lines, and
+    /// `$NAME` and `${NAME}` interpolation outside single quotes.
+    Dotenv,
+    /// Java properties: `#` and `!` comments, `=`, `:`, or whitespace between key and value, `\uXXXX` escapes, values
+    /// continued by a trailing backslash, and `${name}` placeholders. Quotes are just characters.
+    Properties,
+}
+
+impl KeyValueDialect {
+    fn syntax(self) -> &'static Syntax {
+        match self {
+            KeyValueDialect::Ini => &INI,
+            KeyValueDialect::GitConfig => &GIT_CONFIG,
+            KeyValueDialect::Dotenv => &DOTENV,
+            KeyValueDialect::Properties => &PROPERTIES,
+        }
+    }
+}
+
+/// How a dialect spells each part of a key/value file.
+struct Syntax {
+    name: &'static str,
+    /// `[section]` starts a section.
+    sections: bool,
+    /// `[section "subsection"]` names a subsection in quotes.
+    subsections: bool,
+    /// Characters that start a comment at the start of a line.
+    comments: &'static [char],
+    /// Where else comments may start.
+    inline_comments: InlineComments,
+    /// Characters between a key and its value.
+    separators: &'static [char],
+    /// The key ends at whitespace, which may separate it from its value alone.
+    space_separates: bool,
+    /// `export KEY=value` is allowed.
+    export: bool,
+    /// Quotes that make a value, or part of one, a string.
+    quotes: &'static [char],
+    /// Quotes open only at the start of a value, rather than anywhere in it.
+    leading_quotes: bool,
+    /// A quoted value may run over several lines.
+    multiline_quotes: bool,
+    /// A backslash ending a line continues the value on the next.
+    continuations: bool,
+    /// Backslash escapes apply inside double quotes.
+    quoted_escapes: bool,
+    /// Backslash escapes apply outside quotes too.
+    bare_escapes: bool,
+    variables: Variables,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum InlineComments {
+    Never,
+    /// After whitespace, so `a=b#c` has no comment.
+    AfterSpace,
+    /// Anywhere outside quotes.
+    Anywhere,
+}
+
+/// Which interpolation a dialect's values support.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Variables {
+    None,
+    /// `$NAME` and `${NAME}`, outside single quotes.
+    Shell,
+    /// `%(name)s` and `${section:key}`, with `%%` and `$$` for the characters themselves.
+    Ini,
+    /// `${name}`.
+    Braces,
+}
+
+static INI: Syntax = Syntax {
+    name: "INI",
+    sections: true,
+    subsections: false,
+    comments: &[';', '#'],
+    inline_comments: InlineComments::AfterSpace,
+    separators: &['=', ':'],
+    space_separates: false,
+    export: false,
+    quotes: &['"', '\''],
+    leading_quotes: true,
+    multiline_quotes: false,
+    continuations: false,
+    quoted_escapes: false,
+    bare_escapes: false,
+    variables: Variables::Ini,
+};
+
+static GIT_CONFIG: Syntax = Syntax {
+    name: "Git Config",
+    sections: true,
+    subsections: true,
+    comments: &[';', '#'],
+    inline_comments: InlineComments::Anywhere,
+    separators: &['='],
+    space_separates: false,
+    export: false,
+    quotes: &['"'],
+    leading_quotes: false,
+    multiline_quotes: false,
+    continuations: true,
+    quoted_escapes: true,
+    bare_escapes: true,
+    variables: Variables::None,
+};
+
+static DOTENV: Syntax = Syntax {
+    name: "dotenv",
+    sections: false,
+    subsections: false,
+    comments: &['#'],
+    inline_comments: InlineComments::AfterSpace,
+    separators: &['='],
+    space_separates: false,
+    export: true,
+    quotes: &['"', '\''],
+    leading_quotes: true,
+    multiline_quotes: true,
+    continuations: false,
+    quoted_escapes: true,
+    bare_escapes: false,
+    variables: Variables::Shell,
+};
+
+static PROPERTIES: Syntax = Syntax {
+    name: "Java Properties",
+    sections: false,
+    subsections: false,
+    comments: &['#', '!'],
+    inline_comments: InlineComments::Never,
+    separators: &['=', ':'],
+    space_separates: true,
+    export: false,
+    quotes: &[],
+    leading_quotes: false,
+    multiline_quotes: false,
+    continuations: true,
+    quoted_escapes: false,
+    bare_escapes: true,
+    variables: Variables::Braces,
+};
+
+/// Lexer for key/value config files in one of several dialects, which share everything but their details.
+///
+/// Section names are [TokenKind::NameClass] between [TokenKind::Punctuation] brackets, with Git's quoted
+/// subsections as [TokenKind::String]. Keys are [TokenKind::NameAttribute], the `=` or `:` after them
+/// [TokenKind::Operator], and dotenv's `export` a [TokenKind::Keyword]. Values are [TokenKind::Text] when bare and
+/// [TokenKind::String] when quoted, which is the distinction [crate::highlight::RedactSecrets] relies on to mask the
+/// values of secret-looking keys either way. Escapes in values are [TokenKind::StringEscape], including the trailing
+/// backslash that continues a value on the next line, and interpolated variables are [TokenKind::NameVariable].
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::{KeyValue, KeyValueDialect};
+///
+/// let src = "export DATABASE_URL=\"postgres://${DB_HOST}/app\" # primary\nDEBUG=true\n";
+/// let tokens = KeyValue::new(KeyValueDialect::Dotenv).tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("export"), Some(TokenKind::Keyword));
+/// assert_eq!(kind("DATABASE_URL"), Some(TokenKind::NameAttribute));
+/// assert_eq!(kind("="), Some(TokenKind::Operator));
+/// assert_eq!(kind("${DB_HOST}"), Some(TokenKind::NameVariable));
+/// assert_eq!(kind("# primary"), Some(TokenKind::Comment));
+/// assert_eq!(kind("true"), Some(TokenKind::Text));
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct KeyValue {
+    dialect: KeyValueDialect,
+}
+
+impl KeyValue {
+    /// Returns a lexer for `dialect`.
+    pub const fn new(dialect: KeyValueDialect) -> Self {
+        Self { dialect }
+    }
+
+    /// The dialect this lexer follows.
+    pub const fn dialect(&self) -> KeyValueDialect {
+        self.dialect
+    }
+}
+
+impl Lexer for KeyValue {
+    fn name(&self) -> &str {
+        self.dialect.syntax().name
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(KeyValueState { dialect: self.dialect, mode: Mode::LineStart })
+    }
+}
+
+/// What the next line starts with.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Mode {
+    LineStart,
+    /// A bare value continued from the line before.
+    Value,
+    /// A quoted value continued from the line before.
+    Quoted {
+        quote: char,
+    },
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct KeyValueState {
+    dialect: KeyValueDialect,
+    mode: Mode,
+}
+
+impl LexerState for KeyValueState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        // Everything up to the line break is lexed on its own, so the break is whitespace whatever ends the line.
+        let content = line.trim_end_matches(['\n', '\r']);
+        let mut cursor = Cursor { line: content, offset, pos: 0, tokens };
+        match std::mem::replace(&mut self.mode, Mode::LineStart) {
+            Mode::LineStart => self.line(&mut cursor),
+            Mode::Value => {
+                self.space(&mut cursor);
+                self.value(&mut cursor);
+            }
+            Mode::Quoted { quote } => {
+                if self.quoted(&mut cursor, quote) {
+                    self.value(&mut cursor);
+                }
+            }
+        }
+        cursor.line = line;
+        cursor.emit(TokenKind::Whitespace, line.len() - content.len());
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl KeyValueState {
+    fn syntax(&self) -> &'static Syntax {
+        self.dialect.syntax()
+    }
+
+    fn line(&mut self, cursor: &mut Cursor) {
+        let syntax = self.syntax();
+        self.space(cursor);
+        match cursor.peek() {
+            None => {}
+            Some(ch) if syntax.comments.contains(&ch) => cursor.emit(TokenKind::Comment, cursor.rest().len()),
+            Some('[') if syntax.sections => self.section(cursor),
+            Some(_) => self.entry(cursor),
+        }
+    }
+
+    fn space(&self, cursor: &mut Cursor) {
+        cursor.emit(TokenKind::Whitespace, cursor.run_until(|ch| !ch.is_whitespace()));
+    }
+
+    /// Lexes a `[section]` header, with a Git subsection in quotes.
+    fn section(&mut self, cursor: &mut Cursor) {
+        let syntax = self.syntax();
+        cursor.emit(TokenKind::Punctuation, 1);
+        self.space(cursor);
+        let len = cursor.run_until(|ch| ch == ']' || (syntax.subsections && ch == '"'));
+        cursor.emit(TokenKind::NameClass, cursor.rest()[..len].trim_end().len());
+        self.space(cursor);
+        if syntax.subsections && cursor.peek() == Some('"') {
+            cursor.emit(TokenKind::String, 1);
+            self.quoted(cursor, '"');
+            self.space(cursor);
+        }
+        if cursor.peek() == Some(']') {
+            cursor.emit(TokenKind::Punctuation, 1);
+        }
+        self.space(cursor);
+        let rest = cursor.rest();
+        let kind = if rest.starts_with(syntax.comments) { TokenKind::Comment } else { TokenKind::Error };
+        cursor.emit(kind, rest.len());
+    }
+
+    /// Lexes a `key = value` entry, or a key on its own.
+    fn entry(&mut self, cursor: &mut Cursor) {
+        let syntax = self.syntax();
+        if syntax.export
+            && let Some(after) = cursor.rest().strip_prefix("export")
+            && after.starts_with([' ', '\t'])
+        {
+            cursor.emit(TokenKind::Keyword, "export".len());
+            self.space(cursor);
+        }
+
+        if syntax.space_separates {
+            self.escaped_key(cursor);
+        } else {
+            let rest = cursor.rest();
+            let mut len = rest.find(syntax.separators).unwrap_or(rest.len());
+            if let Some(comment) = self.comment_in(&rest[..len]) {
+                len = comment;
+            }
+            cursor.emit(TokenKind::NameAttribute, rest[..len].trim_end().len());
+        }
+        self.space(cursor);
+        if cursor.peek().is_some_and(|ch| syntax.separators.contains(&ch)) {
+            cursor.emit(TokenKind::Operator, 1);
+            self.space(cursor);
+        }
+        self.value(cursor);
+    }
+
+    /// Lexes a Java properties key, which ends at the first separator or whitespace that isn't escaped.
+    fn escaped_key(&mut self, cursor: &mut Cursor) {
+        let syntax = self.syntax();
+        let ends = |ch: char| ch.is_whitespace() || syntax.separators.contains(&ch);
+        while let Some(ch) = cursor.peek() {
+            if ch == '\\' {
+                self.escape(cursor);
+            } else if ends(ch) {
+                return;
+            } else {
+                cursor.emit(TokenKind::NameAttribute, cursor.run_until(|ch| ch == '\\' || ends(ch)));
+            }
+        }
+    }
+
+    /// Where a comment starts in `text`, a key with no separator after it.
+    fn comment_in(&self, text: &str) -> Option<usize> {
+        let syntax = self.syntax();
+        let starts = |&(at, ch): &(usize, char)| {
+            syntax.comments.contains(&ch)
+                && match syntax.inline_comments {
+                    InlineComments::Never => false,
+                    InlineComments::AfterSpace => text[..at].ends_with(char::is_whitespace),
+                    InlineComments::Anywhere => true,
+                }
+        };
+        text.char_indices().find(starts).map(|(at, _)| at)
+    }
+
+    /// Lexes a value up to the end of the line or an inline comment.
+    fn value(&mut self, cursor: &mut Cursor) {
+        let syntax = self.syntax();
+        let special = |ch: char| {
+            ch.is_whitespace()
+                || matches!(ch, '\\' | '$' | '%')
+                || (syntax.inline_comments == InlineComments::Anywhere && syntax.comments.contains(&ch))
+                || (!syntax.leading_quotes && syntax.quotes.contains(&ch))
+        };
+        let mut leading = true;
+        while let Some(ch) = cursor.peek() {
+            let rest = cursor.rest();
+            let comment = match syntax.inline_comments {
+                InlineComments::Never => false,
+                InlineComments::AfterSpace => cursor.line[..cursor.pos].ends_with(char::is_whitespace),
+                InlineComments::Anywhere => true,
+            };
+            if comment && syntax.comments.contains(&ch) {
+                cursor.emit(TokenKind::Comment, rest.len());
+                return;
+            }
+            if ch.is_whitespace() {
+                // Space inside a value is part of it, so a bare value is one token; space that ends it isn't.
+                let len = cursor.run_until(|ch| !ch.is_whitespace());
+                let after = &rest[len..];
+                let trailing = after.is_empty()
+                    || (syntax.inline_comments != InlineComments::Never && after.starts_with(syntax.comments));
+                cursor.emit(if trailing { TokenKind::Whitespace } else { TokenKind::Text }, len);
+                continue;
+            }
+
+            let quoted = syntax.quotes.contains(&ch)
+                && (leading || !syntax.leading_quotes)
+                && (syntax.multiline_quotes || syntax.continuations || rest[1..].contains(ch));
+            leading = false;
+            if quoted {
+                cursor.emit(TokenKind::String, 1);
+                if !self.quoted(cursor, ch) {
+                    return;
+                }
+            } else if ch == '\\' && rest.len() == 1 && syntax.continuations {
+                cursor.emit(TokenKind::StringEscape, 1);
+                self.mode = Mode::Value;
+            } else if ch == '\\' && syntax.bare_escapes {
+                self.escape(cursor);
+            } else if !self.variable(cursor) {
+                let len = ch.len_utf8();
+                cursor.emit(
+                    TokenKind::Text,
+                    len + rest[len..].find(special).unwrap_or(rest.len() - len),
+                );
+            }
+        }
+    }
+
+    /// Lexes the rest of a value quoted with `quote`, returning whether it closes on this line.
+    fn quoted(&mut self, cursor: &mut Cursor, quote: char) -> bool {
+        let syntax = self.syntax();
+        // Single quotes are literal wherever they're allowed.
+        let escapes = quote == '"' && syntax.quoted_escapes;
+        let variables = quote == '"';
+        while let Some(ch) = cursor.peek() {
+            let rest = cursor.rest();
+            if ch == quote {
+                cursor.emit(TokenKind::String, 1);
+                return true;
+            } else if ch == '\\' && rest.len() == 1 && syntax.continuations {
+                cursor.emit(TokenKind::StringEscape, 1);
+                self.mode = Mode::Quoted { quote };
+                return false;
+            } else if ch == '\\' && escapes {
+                self.escape(cursor);
+            } else if !(variables && self.variable(cursor)) {
+                let len = ch.len_utf8();
+                let stop = |ch: char| ch == quote || (escapes && ch == '\\') || (variables && matches!(ch, '$' | '%'));
+                cursor.emit(
+                    TokenKind::String,
+                    len + rest[len..].find(stop).unwrap_or(rest.len() - len),
+                );
+            }
+        }
+        if syntax.multiline_quotes {
+            self.mode = Mode::Quoted { quote };
+        }
+        false
+    }
+
+    /// Lexes the interpolated variable at the cursor, returning whether there was one.
+    fn variable(&mut self, cursor: &mut Cursor) -> bool {
+        let rest = cursor.rest();
+        let braces = || Some(rest.strip_prefix("${")?.find('}')? + 3);
+        let (kind, len) = match self.syntax().variables {
+            Variables::Shell if rest.starts_with('$') => (
+                TokenKind::NameVariable,
+                braces().or_else(|| {
+                    let name = rest[1..].find(|ch: char| ch != '_' && !ch.is_ascii_alphanumeric());
+                    let name = name.unwrap_or(rest.len() - 1);
+                    let valid = name > 0 && !rest[1..].starts_with(|ch: char| ch.is_ascii_digit());
+                    valid.then_some(name + 1)
+                }),
+            ),
+            Variables::Ini if rest.starts_with("%%") || rest.starts_with("$$") => (TokenKind::StringEscape, Some(2)),
+            Variables::Ini => (
+                TokenKind::NameVariable,
+                braces().or_else(|| Some(rest.strip_prefix("%(")?.find(")s")? + 4)),
+            ),
+            Variables::Braces => (TokenKind::NameVariable, braces()),
+            _ => return false,
+        };
+        let Some(len) = len else { return false };
+        cursor.emit(kind, len);
+        true
+    }
+
+    /// Lexes the backslash escape at the cursor. Git rejects escapes it doesn't know, so they're
+    /// [TokenKind::Error]; so are properties' `\u` escapes without four hex digits.
+    fn escape(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let Some(next) = rest[1..].chars().next() else {
+            cursor.emit(TokenKind::StringEscape, 1);
+            return;
+        };
+        let hex = rest[1 + next.len_utf8()..]
+            .get(..4)
+            .is_some_and(|digits| digits.chars().all(|ch| ch.is_ascii_hexdigit()));
+        let (kind, len) = match (self.dialect, next) {
+            (KeyValueDialect::GitConfig, 'n' | 't' | 'b' | '"' | '\\') => (TokenKind::StringEscape, 2),
+            (KeyValueDialect::GitConfig, _) => (TokenKind::Error, 1 + next.len_utf8()),
+            (KeyValueDialect::Properties, 'u') if hex => (TokenKind::StringEscape, 6),
+            (KeyValueDialect::Properties, 'u') => (TokenKind::Error, 2),
+            _ => (TokenKind::StringEscape, 1 + next.len_utf8()),
+        };
+        cursor.emit(kind, len);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::assert_fixture_tokens;
+    use crate::highlight::{RedactSecrets, filter_tokens};
+
+    /// Non-whitespace tokens as (kind, text) pairs.
+    fn kinds(dialect: KeyValueDialect, src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = KeyValue::new(dialect).tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    fn texts(dialect: KeyValueDialect, src: &str, kind: TokenKind) -> Vec<&str> {
+        kinds(dialect, src)
+            .into_iter()
+            .filter(|(k, _)| *k == kind)
+            .map(|(_, text)| text)
+            .collect()
+    }
+
+    #[test]
+    fn ini_sections_keys_and_comments() {
+        let src = "; settings\n[server]\nhost = example.com ; public\nport: 8080\nurl = http://%(host)s:${port}/a#b\npercent = 100%%\nname = \"quoted\" # note\nbad = \"unclosed\n[ trailing ] junk\n";
+        let ini = KeyValueDialect::Ini;
+        assert_eq!(
+            kinds(ini, src)[..6],
+            [
+                (TokenKind::Comment, "; settings"),
+                (TokenKind::Punctuation, "["),
+                (TokenKind::NameClass, "server"),
+                (TokenKind::Punctuation, "]"),
+                (TokenKind::NameAttribute, "host"),
+                (TokenKind::Operator, "="),
+            ]
+        );
+        assert_eq!(
+            texts(ini, src, TokenKind::Comment),
+            ["; settings", "; public", "# note"]
+        );
+        assert_eq!(texts(ini, src, TokenKind::NameVariable), ["%(host)s", "${port}"]);
+        assert_eq!(texts(ini, src, TokenKind::StringEscape), ["%%"]);
+        assert_eq!(texts(ini, src, TokenKind::String), ["\"quoted\""]);
+        assert_eq!(
+            texts(ini, src, TokenKind::Text),
+            ["example.com", "8080", "http://", ":", "/a#b", "100", "\"unclosed"]
+        );
+        assert_eq!(texts(ini, src, TokenKind::Operator), ["=", ":", "=", "=", "=", "="]);
+        assert_eq!(texts(ini, src, TokenKind::NameClass), ["server", "trailing"]);
+        assert_eq!(texts(ini, src, TokenKind::Error), ["junk"]);
+    }
+
+    #[test]
+    fn git_subsections_escapes_and_continuations() {
+        let src = "[remote \"origin\"]\n\turl = git@example.com:me/repo.git # comment\n[alias]\n\tlg = log \\\n\t\t--oneline\n\tbad = a\\qb\n\tsay = \"tab\\there \\\n\t  still\" ; done\n[section \"a \\\"b\\\"\"]\n";
+        let git = KeyValueDialect::GitConfig;
+        assert_eq!(texts(git, src, TokenKind::NameClass), ["remote", "alias", "section"]);
+        assert_eq!(
+            texts(git, src, TokenKind::String),
+            ["\"origin\"", "\"tab", "here ", "\t  still\"", "\"a ", "b", "\""]
+        );
+        assert_eq!(
+            texts(git, src, TokenKind::StringEscape),
+            ["\\", "\\t", "\\", "\\\"", "\\\""]
+        );
+        assert_eq!(texts(git, src, TokenKind::Error), ["\\q"]);
+        assert_eq!(texts(git, src, TokenKind::Comment), ["# comment", "; done"]);
+        assert_eq!(texts(git, src, TokenKind::NameAttribute), ["url", "lg", "bad", "say"]);
+        assert_eq!(
+            texts(git, src, TokenKind::Text),
+            ["git@example.com:me/repo.git", "log ", "--oneline", "a", "b"]
+        );
+    }
+
+    #[test]
+    fn properties_escapes_continuations_and_separators() {
+        let src = "! comment\n# another\nkey=value\nspaced\\ key : a b\nwhite space\nfruits = apple, \\\n    banana\nunicode = caf\\u00e9 \\uZZ\npath = ${user.home}/app\n";
+        let props = KeyValueDialect::Properties;
+        assert_eq!(texts(props, src, TokenKind::Comment), ["! comment", "# another"]);
+        assert_eq!(
+            texts(props, src, TokenKind::NameAttribute),
+            ["key", "spaced", "key", "white", "fruits", "unicode", "path"]
+        );
+        assert_eq!(texts(props, src, TokenKind::StringEscape), ["\\ ", "\\", "\\u00e9"]);
+        assert_eq!(texts(props, src, TokenKind::Error), ["\\u"]);
+        assert_eq!(texts(props, src, TokenKind::NameVariable), ["${user.home}"]);
+        assert_eq!(
+            texts(props, src, TokenKind::Text),
+            ["value", "a b", "space", "apple, ", "banana", "caf", " ", "ZZ", "/app"]
+        );
+    }
+
+    #[test]
+    fn dotenv_exports_quotes_and_interpolation() {
+        let src = include_str!("../../../../examples/languages/sample.env");
+        let dotenv = KeyValueDialect::Dotenv;
+        assert_eq!(texts(dotenv, src, TokenKind::Keyword), ["export", "export", "export"]);
+        assert_eq!(
+            texts(dotenv, src, TokenKind::Comment),
+            [
+                "# Local development settings. Copy to .env.local and fill in the secrets.",
+                "# Database",
+                "# overridden in CI",
+                "# Third-party services",
+                "# shown on login",
+            ]
+        );
+        assert_eq!(
+            texts(dotenv, src, TokenKind::NameVariable),
+            ["${DB_USER}", "${DB_PASSWORD}", "${DB_HOST}", "$DB_PORT", "$USER"]
+        );
+        assert_eq!(texts(dotenv, src, TokenKind::StringEscape), ["\\n"]);
+        assert_eq!(
+            texts(dotenv, src, TokenKind::String),
+            [
+                "'s3cr3t p@ss'",
+                "\"postgres://",
+                ":",
+                "@",
+                ":",
+                "/app\"",
+                "\"Hello, ",
+                "!",
+                "Welcome back.\"",
+                "\"-----BEGIN CERTIFICATE-----",
+                "MIIBszCCAVmgAwIBAgIUE1...",
+                "-----END CERTIFICATE-----\"",
+            ]
+        );
+        assert_eq!(
+            texts(dotenv, src, TokenKind::Text),
+            [
+                "development",
+                "3000",
+                "localhost",
+                "5432",
+                "app",
+                "sk_test_not_a_real_key_0000",
+                "search,billing,new-dashboard"
+            ]
+        );
+        // Single quotes are literal.
+        assert_eq!(texts(dotenv, "A='$B \\n'\n", TokenKind::String), ["'$B \\n'"]);
+    }
+
+    #[test]
+    fn dotenv_values_are_redacted_whether_quoted_or_bare() {
+        let src = "export API_TOKEN=abc$SUFFIX # rotated\nDB_PASSWORD=\"p@ss ${PEPPER}word\"\nUSER_NAME=app\n";
+        let tokens = KeyValue::new(KeyValueDialect::Dotenv).tokenize(src).unwrap();
+        let (text, _) = filter_tokens(&RedactSecrets::new(), src, tokens.clone());
+        assert_eq!(
+            text,
+            "export API_TOKEN=•••••••• # rotated\nDB_PASSWORD=\"••••••••\"\nUSER_NAME=app\n"
+        );
+        let (text, _) = filter_tokens(&RedactSecrets::for_language("dotenv"), src, tokens);
+        assert_eq!(
+            text,
+            "export API_TOKEN=•••••••• # rotated\nDB_PASSWORD=\"••••••••\"\nUSER_NAME=••••••••\n"
+        );
+    }
+
+    #[test]
+    fn states_resume_inside_multiline_values() {
+        let lexer = KeyValue::new(KeyValueDialect::Dotenv);
+        let mut state = lexer.start();
+        let mut tokens = Vec::new();
+        state.tokenize_line("KEY=\"one\n", 0, &mut tokens).unwrap();
+        let open = state.snapshot().unwrap();
+        assert!(!open.same_as(lexer.start().as_ref()));
+        state.tokenize_line("two\" # done\n", 9, &mut tokens).unwrap();
+        assert!(state.same_as(lexer.start().as_ref()));
+        assert_eq!(lexer.name(), "dotenv");
+        assert_eq!(KeyValue::default().name(), "INI");
+    }
+
+    #[test]
+    fn gitconfig_matches_golden_tokens() {
+        assert_fixture_tokens(&KeyValue::new(KeyValueDialect::GitConfig), "sample.gitconfig");
+    }
+}
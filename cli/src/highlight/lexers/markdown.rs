@@ -0,0 +1,671 @@
+//! Markdown lexer that hands fenced code blocks to the lexer named by their info string.
+
+use super::embed::{Embedded, Resolver};
+use super::{Lexer, LexerState, find, same_state};
+use crate::highlight::token::push_token;
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+/// CommonMark-flavored Markdown lexer.
+///
+/// Headings, emphasis, links, inline code, lists, and block quotes are classified line by line. Fenced code blocks
+/// emit their delimiters as [TokenKind::PunctuationFence] and their info string as [TokenKind::NameLabel], and the
+/// body is tokenized by the lexer the [Resolver] returns for the info string's language, at the body's absolute
+/// offsets. Unknown or missing languages fall back to [super::PlainText], as do indented code blocks.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Markdown;
+///
+/// let src = "# Title\n\n```\nplain\n```\n";
+/// let tokens = Markdown::new().tokenize(src).unwrap();
+/// assert_eq!(tokens[0].kind, TokenKind::GenericHeading);
+/// assert!(tokens.iter().any(|token| token.kind == TokenKind::PunctuationFence && token.text(src) == "```"));
+/// ```
+#[derive(Debug, Clone, Copy)]
+pub struct Markdown {
+    resolve: Resolver,
+}
+
+impl Markdown {
+    /// Creates a lexer that resolves fence languages with [super::find].
+    pub const fn new() -> Self {
+        Self { resolve: find }
+    }
+
+    /// Creates a lexer that resolves fence languages with `resolve`.
+    pub const fn with_resolver(resolve: Resolver) -> Self {
+        Self { resolve }
+    }
+}
+
+impl Default for Markdown {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Lexer for Markdown {
+    fn name(&self) -> &str {
+        "Markdown"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(MarkdownState { resolve: self.resolve, fence: None, paragraph: false, list: false })
+    }
+}
+
+/// An open fenced code block.
+#[derive(PartialEq)]
+struct Fence {
+    marker: u8,
+    len: usize,
+    /// Block quote depth the fence was opened at; a line with fewer `>` markers ends it.
+    quotes: usize,
+    inner: Embedded,
+}
+
+struct MarkdownState {
+    resolve: Resolver,
+    fence: Option<Fence>,
+    /// The previous line was paragraph text, so indented lines continue it and `===`/`---` underline it.
+    paragraph: bool,
+    /// Inside a list, where indented list markers start nested items rather than code blocks.
+    list: bool,
+}
+
+/// States of one document share their resolver, so it is left out of the comparison.
+impl PartialEq for MarkdownState {
+    fn eq(&self, other: &Self) -> bool {
+        self.fence == other.fence && self.paragraph == other.paragraph && self.list == other.list
+    }
+}
+
+impl LexerState for MarkdownState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut pos = 0;
+        let mut quotes = 0;
+        let limit = self.fence.as_ref().map(|fence| fence.quotes);
+        while limit.is_none_or(|limit| quotes < limit) {
+            let Some((indent, len)) = quote_marker(&line[pos..]) else { break };
+            push_token(tokens, TokenKind::Whitespace, offset + pos, offset + pos + indent);
+            push_token(tokens, TokenKind::Keyword, offset + pos + indent, offset + pos + len);
+            pos += len;
+            quotes += 1;
+        }
+
+        if let Some(fence) = &mut self.fence {
+            if quotes == fence.quotes {
+                let rest = &line[pos..];
+                match closing_fence(rest, fence.marker, fence.len) {
+                    Some((indent, len)) => {
+                        push_token(tokens, TokenKind::Whitespace, offset + pos, offset + pos + indent);
+                        push_token(
+                            tokens,
+                            TokenKind::PunctuationFence,
+                            offset + pos + indent,
+                            offset + pos + len,
+                        );
+                        push_token(tokens, TokenKind::Whitespace, offset + pos + len, offset + line.len());
+                        self.fence = None;
+                    }
+                    None => fence.inner.tokenize(rest, offset + pos, tokens)?,
+                }
+                return Ok(());
+            }
+            // The enclosing block quote ended, which closes the fence with it.
+            self.fence = None;
+        }
+
+        self.tokenize_block(&line[pos..], offset + pos, quotes, tokens);
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        let fence = match &self.fence {
+            Some(fence) => Some(Fence {
+                marker: fence.marker,
+                len: fence.len,
+                quotes: fence.quotes,
+                inner: fence.inner.snapshot()?,
+            }),
+            None => None,
+        };
+        Some(Box::new(MarkdownState {
+            resolve: self.resolve,
+            fence,
+            paragraph: self.paragraph,
+            list: self.list,
+        }))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl MarkdownState {
+    /// Classifies a line outside code fences, after its `quotes` block quote markers.
+    fn tokenize_block(&mut self, rest: &str, offset: usize, quotes: usize, tokens: &mut Vec<Token>) {
+        let end = offset + rest.len();
+        let content = rest.trim_end_matches(['\n', '\r']);
+        if content.trim().is_empty() {
+            push_token(tokens, TokenKind::Whitespace, offset, end);
+            self.paragraph = false;
+            return;
+        }
+
+        let indent = rest.len() - rest.trim_start_matches([' ', '\t']).len();
+        let columns = indent_columns(&rest[..indent]);
+        if columns >= 4 && !self.paragraph && !(self.list && list_marker(&rest[indent..]).is_some()) {
+            // Indented code has no language, so it gets the same plain style as an unlabeled fence.
+            push_token(tokens, TokenKind::Text, offset, end);
+            return;
+        }
+
+        if let Some(fence) = opening_fence(rest) {
+            push_token(tokens, TokenKind::Whitespace, offset, offset + fence.indent);
+            push_token(
+                tokens,
+                TokenKind::PunctuationFence,
+                offset + fence.indent,
+                offset + fence.info.start,
+            );
+            let info = &rest[fence.info.clone()];
+            let trimmed = info.trim();
+            let info_start = fence.info.start + (info.len() - info.trim_start().len());
+            push_token(
+                tokens,
+                TokenKind::Whitespace,
+                offset + fence.info.start,
+                offset + info_start,
+            );
+            push_token(
+                tokens,
+                TokenKind::NameLabel,
+                offset + info_start,
+                offset + info_start + trimmed.len(),
+            );
+            push_token(tokens, TokenKind::Whitespace, offset + info_start + trimmed.len(), end);
+
+            let inner = Embedded::start(self.resolve, fence_language(trimmed));
+            self.fence = Some(Fence { marker: fence.marker, len: fence.len, quotes, inner });
+            self.paragraph = false;
+            return;
+        }
+
+        if columns < 4 && (is_atx_heading(&rest[indent..]) || (self.paragraph && is_setext_underline(content.trim()))) {
+            push_token(tokens, TokenKind::Whitespace, offset, offset + indent);
+            push_token(
+                tokens,
+                TokenKind::GenericHeading,
+                offset + indent,
+                offset + content.len(),
+            );
+            push_token(tokens, TokenKind::Whitespace, offset + content.len(), end);
+            self.paragraph = false;
+            self.list = false;
+            return;
+        }
+
+        if columns < 4 && is_thematic_break(content.trim()) {
+            push_token(tokens, TokenKind::Whitespace, offset, offset + indent);
+            push_token(tokens, TokenKind::Punctuation, offset + indent, offset + content.len());
+            push_token(tokens, TokenKind::Whitespace, offset + content.len(), end);
+            self.paragraph = false;
+            self.list = false;
+            return;
+        }
+
+        if let Some(len) = list_marker(&rest[indent..]) {
+            push_token(tokens, TokenKind::Whitespace, offset, offset + indent);
+            push_token(tokens, TokenKind::Keyword, offset + indent, offset + indent + len);
+            tokenize_inline(&rest[indent + len..], offset + indent + len, tokens);
+            self.paragraph = true;
+            self.list = true;
+            return;
+        }
+
+        if indent == 0 && !self.paragraph {
+            self.list = false;
+        }
+        tokenize_inline(rest, offset, tokens);
+        self.paragraph = true;
+    }
+}
+
+/// Matches a block quote marker (`>` after up to three spaces, plus one optional space), returning the indent and
+/// the marker's total length.
+fn quote_marker(rest: &str) -> Option<(usize, usize)> {
+    let indent = rest.len() - rest.trim_start_matches(' ').len();
+    if indent > 3 || !rest[indent..].starts_with('>') {
+        return None;
+    }
+    let space = usize::from(matches!(rest.as_bytes().get(indent + 1), Some(b' ' | b'\t')));
+    Some((indent, indent + 1 + space))
+}
+
+/// Width of leading whitespace with tabs advancing to the next multiple of four columns.
+fn indent_columns(indent: &str) -> usize {
+    indent.bytes().fold(
+        0,
+        |columns, byte| if byte == b'\t' { columns + 4 - columns % 4 } else { columns + 1 },
+    )
+}
+
+struct OpeningFence {
+    indent: usize,
+    marker: u8,
+    len: usize,
+    info: std::ops::Range<usize>,
+}
+
+fn opening_fence(rest: &str) -> Option<OpeningFence> {
+    let indent = rest.len() - rest.trim_start_matches(' ').len();
+    let marker = *rest.as_bytes().get(indent)?;
+    if indent > 3 || !matches!(marker, b'`' | b'~') {
+        return None;
+    }
+    let len = rest[indent..].bytes().take_while(|&byte| byte == marker).count();
+    let info = indent + len..rest.trim_end_matches(['\n', '\r']).len();
+    // Backtick fences can't have backticks in the info string, or ``` `code` ``` would open a block.
+    if len < 3 || (marker == b'`' && rest[info.clone()].contains('`')) {
+        return None;
+    }
+    Some(OpeningFence { indent, marker, len, info })
+}
+
+/// Matches a closing fence of at least `len` markers followed only by whitespace, returning its indent and end.
+fn closing_fence(rest: &str, marker: u8, len: usize) -> Option<(usize, usize)> {
+    let indent = rest.len() - rest.trim_start_matches(' ').len();
+    let run = rest[indent..].bytes().take_while(|&byte| byte == marker).count();
+    let tail = &rest[indent + run..];
+    (indent <= 3 && run >= len && tail.trim().is_empty()).then_some((indent, indent + run))
+}
+
+/// Extracts the language from an info string: `go`, `{.python}`, and `rust,ignore` all name their first word.
+fn fence_language(info: &str) -> Option<&str> {
+    let word = info.split([' ', '\t', ',']).next()?;
+    let language = word.trim_start_matches(['{', '.']).trim_end_matches('}');
+    (!language.is_empty()).then_some(language)
+}
+
+fn is_atx_heading(rest: &str) -> bool {
+    let hashes = rest.bytes().take_while(|&byte| byte == b'#').count();
+    (1..=6).contains(&hashes) && matches!(rest.as_bytes().get(hashes), None | Some(b' ' | b'\t' | b'\n' | b'\r'))
+}
+
+fn is_setext_underline(content: &str) -> bool {
+    let first = content.as_bytes()[0];
+    matches!(first, b'=' | b'-') && content.bytes().all(|byte| byte == first)
+}
+
+fn is_thematic_break(content: &str) -> bool {
+    let first = content.as_bytes()[0];
+    matches!(first, b'*' | b'-' | b'_')
+        && content
+            .bytes()
+            .all(|byte| matches!(byte, b' ' | b'\t') || byte == first)
+        && content.bytes().filter(|&byte| byte == first).count() >= 3
+}
+
+/// Matches a bullet (`-`, `*`, `+`) or ordered (`1.`, `2)`) list marker and the space after it.
+fn list_marker(rest: &str) -> Option<usize> {
+    let bytes = rest.as_bytes();
+    let digits = bytes.iter().take_while(|byte| byte.is_ascii_digit()).count();
+    let marker = match (digits, bytes.get(digits)) {
+        (0, Some(b'-' | b'*' | b'+')) => 1,
+        (1..=9, Some(b'.' | b')')) => digits + 1,
+        _ => return None,
+    };
+    match bytes.get(marker) {
+        Some(b' ' | b'\t') => Some(marker + 1),
+        None | Some(b'\n' | b'\r') => Some(marker),
+        _ => None,
+    }
+}
+
+/// Classifies inline spans: escapes, code spans, emphasis, links, and autolinks. Everything else is text.
+fn tokenize_inline(text: &str, offset: usize, tokens: &mut Vec<Token>) {
+    let bytes = text.as_bytes();
+    let mut plain = 0;
+    let mut i = 0;
+    // Flushes pending text, then pushes classified spans given as (kind, end) pairs starting at `i`.
+    let emit = |tokens: &mut Vec<Token>, plain: &mut usize, start: usize, spans: &[(TokenKind, usize)]| {
+        push_token(tokens, TokenKind::Text, offset + *plain, offset + start);
+        let mut from = start;
+        for &(kind, to) in spans {
+            push_token(tokens, kind, offset + from, offset + to);
+            from = to;
+        }
+        *plain = from;
+        from
+    };
+
+    while i < bytes.len() {
+        match bytes[i] {
+            b'\\' if bytes.get(i + 1).is_some_and(u8::is_ascii_punctuation) => {
+                i = emit(tokens, &mut plain, i, &[(TokenKind::StringEscape, i + 2)]);
+            }
+            b'`' => {
+                let run = bytes[i..].iter().take_while(|&&byte| byte == b'`').count();
+                match find_run(bytes, i + run, b'`', run) {
+                    Some(close) => i = emit(tokens, &mut plain, i, &[(TokenKind::StringBacktick, close + run)]),
+                    None => i += run,
+                }
+            }
+            marker @ (b'*' | b'_') => match emphasis(bytes, i, marker) {
+                Some((kind, end)) => i = emit(tokens, &mut plain, i, &[(kind, end)]),
+                None => i += bytes[i..].iter().take_while(|&&byte| byte == marker).count(),
+            },
+            b'!' if bytes.get(i + 1) == Some(&b'[') => match link(bytes, i + 1) {
+                Some(spans) => i = emit(tokens, &mut plain, i, &spans),
+                None => i += 2,
+            },
+            b'[' => match link(bytes, i) {
+                Some(spans) => i = emit(tokens, &mut plain, i, &spans),
+                None => i += 1,
+            },
+            b'<' => match autolink(bytes, i) {
+                Some(end) => {
+                    let spans = [
+                        (TokenKind::Punctuation, i + 1),
+                        (TokenKind::NameAttribute, end - 1),
+                        (TokenKind::Punctuation, end),
+                    ];
+                    i = emit(tokens, &mut plain, i, &spans);
+                }
+                None => i += 1,
+            },
+            _ => i += 1,
+        }
+    }
+    push_token(tokens, TokenKind::Text, offset + plain, offset + bytes.len());
+}
+
+/// Finds the next run of exactly `len` `marker` bytes at or after `from`.
+fn find_run(bytes: &[u8], from: usize, marker: u8, len: usize) -> Option<usize> {
+    let mut i = from;
+    while i < bytes.len() {
+        if bytes[i] != marker {
+            i += 1;
+            continue;
+        }
+        let run = bytes[i..].iter().take_while(|&&byte| byte == marker).count();
+        if run == len {
+            return Some(i);
+        }
+        i += run;
+    }
+    None
+}
+
+/// Matches `*em*`, `**strong**` (and the `_` forms) starting at `start`, returning the kind and the end offset.
+///
+/// Delimiters must hug the content, and `_` never opens or closes inside a word (`snake_case_name`).
+fn emphasis(bytes: &[u8], start: usize, marker: u8) -> Option<(TokenKind, usize)> {
+    let run = bytes[start..].iter().take_while(|&&byte| byte == marker).count();
+    let (kind, delimiter) = if run >= 2 { (TokenKind::GenericStrong, 2) } else { (TokenKind::GenericEmph, 1) };
+    let word_byte = |index: Option<usize>| {
+        index
+            .and_then(|index| bytes.get(index))
+            .is_some_and(u8::is_ascii_alphanumeric)
+    };
+    if marker == b'_' && word_byte(start.checked_sub(1)) {
+        return None;
+    }
+    let content = start + delimiter;
+    if bytes.get(content).is_none_or(u8::is_ascii_whitespace) {
+        return None;
+    }
+
+    let mut i = content + 1;
+    while i + delimiter <= bytes.len() {
+        let closes = bytes[i..i + delimiter].iter().all(|&byte| byte == marker)
+            && !bytes[i - 1].is_ascii_whitespace()
+            && !(marker == b'_' && word_byte(Some(i + delimiter)));
+        if closes {
+            return Some((kind, i + delimiter));
+        }
+        i += 1;
+    }
+    None
+}
+
+/// Matches `[text](url)` or `[text][label]` with the `[` at `open`; an image's `!` just before it is included.
+///
+/// Brackets nest, so a badge like `[![CI](badge.svg)](ci)` is one link whose text is the image. Returns the spans to
+/// emit as (kind, end) pairs starting from the `!` when present.
+fn link(bytes: &[u8], open: usize) -> Option<Vec<(TokenKind, usize)>> {
+    let mut depth = 0usize;
+    let close = open
+        + bytes[open..].iter().position(|&byte| {
+            match byte {
+                b'[' => depth += 1,
+                b']' => depth -= 1,
+                _ => {}
+            }
+            depth == 0
+        })?;
+    let (terminator, target) = match bytes.get(close + 1)? {
+        b'(' => (b')', TokenKind::NameAttribute),
+        b'[' => (b']', TokenKind::NameLabel),
+        _ => return None,
+    };
+    let target_start = close + 2;
+    let end = target_start + bytes[target_start..].iter().position(|&byte| byte == terminator)?;
+    Some(vec![
+        (TokenKind::Punctuation, open + 1),
+        (TokenKind::NameTag, close),
+        (TokenKind::Punctuation, target_start),
+        (target, end),
+        (TokenKind::Punctuation, end + 1),
+    ])
+}
+
+/// Matches `<scheme:...>` or `<user@host>` at `start`, returning the end offset past `>`.
+fn autolink(bytes: &[u8], start: usize) -> Option<usize> {
+    let len = bytes[start + 1..].iter().position(|&byte| byte == b'>')?;
+    let inner = &bytes[start + 1..start + 1 + len];
+    let scheme = inner.iter().take_while(|byte| byte.is_ascii_alphabetic()).count();
+    let url = scheme >= 2 && inner.get(scheme) == Some(&b':');
+    let email = inner.contains(&b'@') && !inner.starts_with(b"@");
+    (!inner.is_empty() && (url || email) && !inner.iter().any(|byte| byte.is_ascii_whitespace() || *byte == b'<'))
+        .then_some(start + len + 2)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::assert_fixture_tokens;
+
+    /// Tiny stand-in for a real grammar: keywords, strings, numbers, and `//`/`#` comments.
+    struct Code;
+
+    const KEYWORDS: &[&str] = &[
+        "package", "func", "import", "return", "def", "if", "for", "in", "fn", "let",
+    ];
+
+    impl Lexer for Code {
+        fn name(&self) -> &str {
+            "Code"
+        }
+
+        fn start(&self) -> Box<dyn LexerState + '_> {
+            Box::new(Code)
+        }
+    }
+
+    impl LexerState for Code {
+        fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+            let bytes = line.as_bytes();
+            let mut i = 0;
+            while i < bytes.len() {
+                let rest = &line[i..];
+                let (kind, len) = if rest.starts_with("//") || rest.starts_with('#') {
+                    (TokenKind::Comment, rest.trim_end_matches('\n').len())
+                } else if let Some(quoted) = rest.strip_prefix('"') {
+                    (TokenKind::String, quoted.find('"').map_or(rest.len(), |end| end + 2))
+                } else if bytes[i].is_ascii_alphanumeric() || bytes[i] == b'_' {
+                    let len = rest
+                        .bytes()
+                        .take_while(|b| b.is_ascii_alphanumeric() || *b == b'_')
+                        .count();
+                    let word = &rest[..len];
+                    let kind = match word {
+                        _ if KEYWORDS.contains(&word) => TokenKind::Keyword,
+                        _ if word.bytes().all(|b| b.is_ascii_digit()) => TokenKind::Number,
+                        _ => TokenKind::Name,
+                    };
+                    (kind, len)
+                } else if bytes[i].is_ascii_whitespace() {
+                    (TokenKind::Whitespace, 1)
+                } else {
+                    (TokenKind::Punctuation, rest.chars().next().map_or(1, char::len_utf8))
+                };
+                push_token(tokens, kind, offset + i, offset + i + len);
+                i += len;
+            }
+            Ok(())
+        }
+    }
+
+    static CODE: Code = Code;
+
+    fn resolve(language: &str) -> Option<&'static dyn Lexer> {
+        matches!(language, "go" | "python").then_some(&CODE as &'static dyn Lexer)
+    }
+
+    const MARKDOWN: Markdown = Markdown::with_resolver(resolve);
+
+    fn kinds(src: &str) -> Vec<(TokenKind, &str)> {
+        MARKDOWN
+            .tokenize(src)
+            .unwrap()
+            .into_iter()
+            .map(|token| (token.kind, token.text(src)))
+            .filter(|(kind, _)| *kind != TokenKind::Whitespace)
+            .collect()
+    }
+
+    fn kind_of(src: &str, text: &str) -> Option<TokenKind> {
+        kinds(src).into_iter().find(|(_, t)| *t == text).map(|(kind, _)| kind)
+    }
+
+    #[test]
+    fn fences_delegate_to_the_info_string_language() {
+        let src = "Intro\n\n```go\nfunc main() {}\n```\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::PunctuationFence, "```")));
+        assert!(tokens.contains(&(TokenKind::NameLabel, "go")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "func")));
+        assert!(tokens.contains(&(TokenKind::Name, "main")));
+
+        // Inner tokens carry absolute offsets into the document.
+        let all = MARKDOWN.tokenize(src).unwrap();
+        let func = all.iter().find(|token| token.kind == TokenKind::Keyword).unwrap();
+        assert_eq!(func.start, src.find("func").unwrap());
+    }
+
+    #[test]
+    fn unknown_and_missing_languages_are_plain_text() {
+        for src in [
+            "```brainfart\nfunc x\n```\n",
+            "```\nfunc x\n```\n",
+            "~~~ {.unknown}\nfunc x\n~~~\n",
+        ] {
+            assert_eq!(kind_of(src, "func x\n"), Some(TokenKind::Text), "{src:?}");
+        }
+        assert_eq!(fence_language("{.python}"), Some("python"));
+        assert_eq!(fence_language("rust,ignore"), Some("rust"));
+        assert_eq!(fence_language(""), None);
+    }
+
+    #[test]
+    fn closing_fences_must_match_marker_and_length() {
+        let src = "````python\n```\nreturn 1\n````\nafter *this*\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::Keyword, "return")));
+        assert!(tokens.contains(&(TokenKind::PunctuationFence, "````")));
+        assert!(tokens.contains(&(TokenKind::GenericEmph, "*this*")));
+    }
+
+    #[test]
+    fn indented_code_is_not_markdown() {
+        let src = "Para\n\n    # not a heading\n    - nor a list\n";
+        assert_eq!(
+            kind_of(src, "    # not a heading\n    - nor a list\n"),
+            Some(TokenKind::Text)
+        );
+    }
+
+    #[test]
+    fn fences_inside_block_quotes_strip_the_markers() {
+        let src = "> > ```go\n> > return 1\n> > ```\n> tail\n";
+        let tokens = MARKDOWN.tokenize(src).unwrap();
+        // The quote markers and the inner keyword are both keywords, so they merge into one token.
+        let ret = tokens.iter().find(|token| token.text(src).ends_with("return")).unwrap();
+        assert_eq!((ret.kind, ret.text(src)), (TokenKind::Keyword, "> > return"));
+        assert_eq!(
+            tokens
+                .iter()
+                .filter(|token| token.kind == TokenKind::PunctuationFence)
+                .count(),
+            2
+        );
+
+        // A line with fewer quote markers ends the fence along with the quote.
+        let early = "> ```go\n> return 1\nreturn 2\n";
+        assert_eq!(kind_of(early, "return 2\n"), Some(TokenKind::Text));
+    }
+
+    #[test]
+    fn unterminated_fences_run_to_the_end() {
+        let src = "# Title\n```python\ndef f():\n    return 1";
+        let tokens = MARKDOWN.tokenize(src).unwrap();
+        assert_eq!(tokens.last().unwrap().end, src.len());
+        assert_eq!(kind_of(src, "def"), Some(TokenKind::Keyword));
+        assert_eq!(kind_of(src, "1"), Some(TokenKind::Number));
+    }
+
+    #[test]
+    fn inline_markup_is_classified() {
+        let src =
+            "Use `x` with **bold**, _em_, snake_case_name, \\*literal\\*, [docs](https://x.io) and <https://y.io>.\n";
+        assert_eq!(kind_of(src, "`x`"), Some(TokenKind::StringBacktick));
+        assert_eq!(kind_of(src, "**bold**"), Some(TokenKind::GenericStrong));
+        assert_eq!(kind_of(src, "_em_"), Some(TokenKind::GenericEmph));
+        assert_eq!(kind_of(src, "\\*"), Some(TokenKind::StringEscape));
+        assert_eq!(kind_of(src, "docs"), Some(TokenKind::NameTag));
+        assert_eq!(kind_of(src, "https://x.io"), Some(TokenKind::NameAttribute));
+        assert_eq!(kind_of(src, "https://y.io"), Some(TokenKind::NameAttribute));
+        assert!(
+            kinds(src)
+                .iter()
+                .all(|(kind, text)| *kind != TokenKind::GenericEmph || !text.contains("case"))
+        );
+    }
+
+    #[test]
+    fn headings_lists_and_breaks() {
+        let src = "Title\n=====\n\n## Sub ##\n\n- one\n    - nested\n1. first\n\n***\n#hashtag\n";
+        assert_eq!(kind_of(src, "====="), Some(TokenKind::GenericHeading));
+        assert_eq!(kind_of(src, "## Sub ##"), Some(TokenKind::GenericHeading));
+        assert_eq!(kind_of(src, "- "), Some(TokenKind::Keyword));
+        assert_eq!(kind_of(src, "1. "), Some(TokenKind::Keyword));
+        assert_eq!(kind_of(src, "***"), Some(TokenKind::Punctuation));
+        assert_eq!(kind_of(src, "#hashtag\n"), Some(TokenKind::Text));
+    }
+
+    #[test]
+    fn readme_matches_golden_tokens() {
+        assert_fixture_tokens(&MARKDOWN, "sample.md");
+    }
+}
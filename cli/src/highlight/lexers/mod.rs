@@ -0,0 +1,452 @@
+//! Lexers that split source text into [Token] streams.
+//!
+//! Bundled languages come from the syntect grammars shipped with two-face (see [GrammarLexer]), plus hand-written
+//! lexers where a grammar can't express the structure (see [Markdown], [Html], [Diff], [Shell], [Go], [Python],
+//! [Rust], [Yaml], [Toml], [Sql], [Dockerfile], [TypeScript], [JavaScript], [Protobuf], [GraphQL], [C], [Cpp], and
+//! [KeyValue]). Use [find] to look up either by name. Applications can add languages, or replace bundled ones, by
+//! registering a [RuleTable] or their own [Lexer] with the [Registry]. The grammars are behind the default `grammars`
+//! feature; building without it leaves only the hand-written lexers, and most of the binary's size with the grammars.
+//!
+//! Lexing is line-oriented: a [Lexer] hands out a [LexerState] that tokenizes one line at a time and carries
+//! whatever context spans lines (open strings, block comments, nested grammars) to the next call. This is what
+//! lets [crate::highlight::highlight_reader] stream input without holding it all in memory, and states that can be
+//! copied and compared let [crate::highlight::Incremental] re-lex only the lines an edit affects.
+//!
+//! Input a lexer can't classify never stops it: the bundled lexers mark it as [TokenKind::Error] and carry on with
+//! the next character. Wrap a lexer in [Strict] to fail there instead.
+
+use super::{CancelToken, HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+mod analyze;
+mod c;
+mod chroma;
+mod diff;
+mod dockerfile;
+mod embed;
+mod go;
+#[cfg(feature = "grammars")]
+mod grammar;
+mod graphql;
+mod html;
+mod keyvalue;
+mod markdown;
+mod protobuf;
+mod python;
+mod registry;
+mod rules;
+mod rust;
+mod shell;
+mod sql;
+mod strict;
+mod toml;
+mod typescript;
+mod yaml;
+
+pub use analyze::{KindCount, Report, Unmatched, analyze, assert_coverage};
+pub use c::{C, Cpp};
+pub use chroma::{ChromaError, load_chroma_xml};
+pub use diff::Diff;
+pub(crate) use diff::changed_words;
+pub use dockerfile::Dockerfile;
+pub use embed::Resolver;
+pub use go::Go;
+#[cfg(feature = "grammars")]
+pub use grammar::GrammarLexer;
+pub use graphql::GraphQL;
+pub use html::Html;
+pub use keyvalue::{KeyValue, KeyValueDialect};
+pub use markdown::Markdown;
+pub use protobuf::Protobuf;
+pub use python::Python;
+pub(crate) use registry::glob_match;
+pub use registry::{LexerConfig, Registry, RegistryError};
+pub use rules::{PrevToken, ROOT, Rule, RuleError, RuleLexer, RuleTable};
+pub use rust::Rust;
+pub use shell::Shell;
+pub use sql::{Sql, SqlDialect};
+pub use strict::Strict;
+pub use toml::Toml;
+pub use typescript::{JavaScript, TypeScript};
+pub use yaml::Yaml;
+
+/// Looks up a lexer by language name or extension (e.g., "go", "Python", "md") in the [Registry::global] registry.
+///
+/// Registered lexers take precedence over bundled ones, and hand-written lexers over grammars for the same language.
+/// The bundled lexers are statics, so finding one costs nothing; the first grammar lookup loads the bundled grammars
+/// (see [GrammarLexer::precompile_all] to do that up front), and later ones are cached by name.
+pub fn find(name: &str) -> Option<&'static dyn Lexer> {
+    Registry::global().get(name)
+}
+
+/// Adds a language to the [Registry::global] registry; see [Registry::register].
+pub fn register(config: LexerConfig) -> Result<&'static dyn Lexer, RegistryError> {
+    Registry::global().register(config)
+}
+
+/// Splits source text into classified tokens.
+///
+/// Implementations must cover every byte of the input with tokens in ascending order so that concatenating the token
+/// texts reproduces the source exactly.
+pub trait Lexer: Send + Sync {
+    /// Human-readable language name (e.g., "Go").
+    fn name(&self) -> &str;
+
+    /// Returns a fresh state positioned at the start of a document.
+    fn start(&self) -> Box<dyn LexerState + '_>;
+
+    /// Lazily tokenizes `src`, lexing one line at a time as the iterator advances.
+    ///
+    /// Yields the same tokens as [Lexer::tokenize], each paired with its text; iteration stops after the first error.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use colorizer::highlight::{Lexer, TokenKind};
+    /// use colorizer::highlight::lexers::PlainText;
+    ///
+    /// let src = "héllo\nwörld\n";
+    /// let tokens: Vec<_> = PlainText.tokens(src).collect::<Result<_, _>>().unwrap();
+    /// assert_eq!(tokens.len(), 1);
+    /// let (token, text) = tokens[0];
+    /// assert_eq!((token.kind, token.start, token.end, text), (TokenKind::Text, 0, src.len(), src));
+    /// ```
+    fn tokens<'s>(&self, src: &'s str) -> Tokens<'_, 's> {
+        Tokens { state: self.start(), src, offset: 0, buffer: Vec::new(), next: 0, done: false, error: None }
+    }
+
+    /// Tokenizes the full source.
+    fn tokenize(&self, src: &str) -> Result<Vec<Token>, HighlightError> {
+        let mut tokens = Vec::new();
+        self.tokenize_cancellable(src, &CancelToken::new(), &mut tokens)?;
+        Ok(tokens)
+    }
+
+    /// Like [Lexer::tokenize], but appends to `tokens` and checks `cancel` before every line.
+    ///
+    /// Once the token fires, this fails with [HighlightError::Cancelled], whose `offset` is how far into `src` the
+    /// appended tokens reach; they stay in `tokens`. [Lexer::tokenize] delegates here, so lexers that tokenize whole
+    /// documents differently override this method rather than that one.
+    fn tokenize_cancellable(
+        &self, src: &str, cancel: &CancelToken, tokens: &mut Vec<Token>,
+    ) -> Result<(), HighlightError> {
+        let mut state = self.start();
+        let mut offset = 0;
+        for line in src.split_inclusive('\n') {
+            cancel.check(offset)?;
+            state.tokenize_line(line, offset, tokens)?;
+            offset += line.len();
+        }
+        Ok(())
+    }
+}
+
+impl<L: Lexer + ?Sized> Lexer for &L {
+    fn name(&self) -> &str {
+        (**self).name()
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        (**self).start()
+    }
+}
+
+/// Resumable lexing state for one document.
+pub trait LexerState: Send {
+    /// Tokenizes `line` (usually ending in `\n`) and appends its tokens to `tokens`, shifted by `offset`.
+    ///
+    /// Lines are fed in document order. A very long line may arrive split into several consecutive pieces.
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError>;
+
+    /// Copies the state so lexing can later resume from this point, as [crate::highlight::Incremental] does at every
+    /// line.
+    ///
+    /// The default returns `None`, meaning the state can't be copied; incremental re-highlighting then starts over
+    /// from the top of the document on every edit.
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        None
+    }
+
+    /// Reports whether `other` would lex the rest of a document exactly like this state does.
+    ///
+    /// Re-lexing after an edit stops as soon as the new state matches the one recorded before the edit. The default
+    /// never matches, so re-lexing runs to the end of the document.
+    fn same_as(&self, _other: &dyn LexerState) -> bool {
+        false
+    }
+
+    /// Returns the state as [Any] so that [LexerState::same_as] can downcast the other state; `None` by default.
+    fn as_any(&self) -> Option<&dyn Any> {
+        None
+    }
+}
+
+/// Implements [LexerState::same_as] for states that compare as plain values.
+pub(crate) fn same_state<S: PartialEq + 'static>(state: &S, other: &dyn LexerState) -> bool {
+    other.as_any().and_then(|other| other.downcast_ref::<S>()) == Some(state)
+}
+
+/// Checks a bundled lexer against a fixture in `examples/languages`: the tokens must match the golden of the same
+/// name plus `.tokens` in `examples/golden` (see [super::testing::assert_golden_tokens]), and none may be an error.
+#[cfg(test)]
+pub(crate) fn assert_fixture_tokens(lexer: &dyn Lexer, fixture: &str) {
+    let src = format!("../examples/languages/{fixture}");
+    super::testing::assert_golden_tokens(lexer, &src, format!("../examples/golden/{fixture}.tokens"));
+    let text = std::fs::read_to_string(&src).unwrap();
+    if let Some(token) = lexer
+        .tokenize(&text)
+        .unwrap()
+        .iter()
+        .find(|token| token.kind == TokenKind::Error)
+    {
+        panic!(
+            "{}: {fixture} has an error token at {}..{}: {:?}",
+            lexer.name(),
+            token.start,
+            token.end,
+            token.text(&text)
+        );
+    }
+}
+
+/// Iterator over the tokens of a source string; see [Lexer::tokens].
+pub struct Tokens<'l, 's> {
+    state: Box<dyn LexerState + 'l>,
+    src: &'s str,
+    offset: usize,
+    buffer: Vec<Token>,
+    next: usize,
+    done: bool,
+    error: Option<HighlightError>,
+}
+
+impl<'s> Iterator for Tokens<'_, 's> {
+    type Item = Result<(Token, &'s str), HighlightError>;
+
+    fn next(&mut self) -> Option<Self::Item> {
+        loop {
+            // The last buffered token is held back until the next line is lexed, since it may merge with that
+            // line's first token.
+            if self.next + 1 < self.buffer.len() || (self.done && self.next < self.buffer.len()) {
+                let token = self.buffer[self.next];
+                self.next += 1;
+                return Some(Ok((token, token.text(self.src))));
+            }
+            if self.done {
+                return self.error.take().map(Err);
+            }
+
+            self.buffer.drain(..self.next);
+            self.next = 0;
+            let rest = &self.src[self.offset..];
+            let line = match rest.find('\n') {
+                Some(newline) => &rest[..=newline],
+                None => rest,
+            };
+            if line.is_empty() {
+                self.done = true;
+                continue;
+            }
+            let held = self.buffer.last().copied();
+            if let Err(err) = self.state.tokenize_line(line, self.offset, &mut self.buffer) {
+                // Tokens from earlier lines are still yielded; partial output from the failing line is not.
+                self.buffer.clear();
+                self.buffer.extend(held);
+                self.done = true;
+                self.error = Some(err);
+                continue;
+            }
+            self.offset += line.len();
+        }
+    }
+}
+
+/// Position within a line being tokenized by a hand-written lexer, with helpers to emit tokens as it advances.
+struct Cursor<'a, 't> {
+    line: &'a str,
+    offset: usize,
+    pos: usize,
+    tokens: &'t mut Vec<Token>,
+}
+
+impl<'a> Cursor<'a, '_> {
+    fn rest(&self) -> &'a str {
+        &self.line[self.pos..]
+    }
+
+    fn peek(&self) -> Option<char> {
+        self.rest().chars().next()
+    }
+
+    fn emit(&mut self, kind: TokenKind, len: usize) {
+        super::token::push_token(self.tokens, kind, self.offset + self.pos, self.offset + self.pos + len);
+        self.pos += len;
+    }
+
+    /// Length of the prefix of the rest of the line whose characters don't satisfy `stop`.
+    fn run_until(&self, stop: impl Fn(char) -> bool) -> usize {
+        self.rest().find(stop).unwrap_or(self.rest().len())
+    }
+}
+
+/// Finds the end of a construct closed by `close` in `text`, with `depth` levels of it already open; given `open`,
+/// each one found opens another level, so the construct nests.
+///
+/// Returns the length of `text` up to and including the `close` that leaves no level open, or `None` when the
+/// construct runs past the end of `text`, with `depth` updated for the next line. This is the scanning behind
+/// [Rule::nested] and [Rule::delimited], and hand-written lexers share it for their block comments.
+fn delimited_end(text: &str, open: Option<&str>, close: &str, depth: &mut usize) -> Option<usize> {
+    let mut pos = 0;
+    loop {
+        let closing = text[pos..].find(close).map(|at| pos + at);
+        let opening = open.and_then(|open| text[pos..].find(open).map(|at| (pos + at, open.len())));
+        match (opening, closing) {
+            (Some((at, len)), closing) if closing.is_none_or(|closing| at < closing) => {
+                *depth += 1;
+                pos = at + len;
+            }
+            (_, Some(at)) => {
+                *depth = depth.saturating_sub(1);
+                pos = at + close.len();
+                if *depth == 0 {
+                    return Some(pos);
+                }
+            }
+            _ => return None,
+        }
+    }
+}
+
+/// Lexer that emits the whole input as [TokenKind::Text].
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub struct PlainText;
+
+impl Lexer for PlainText {
+    fn name(&self) -> &str {
+        "Plain Text"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(PlainText)
+    }
+}
+
+impl LexerState for PlainText {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        super::token::push_token(tokens, TokenKind::Text, offset, offset + line.len());
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(PlainText))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Emits one token per character, alternating kinds, so every line boundary splits tokens.
+    struct Alternating;
+
+    impl Lexer for Alternating {
+        fn name(&self) -> &str {
+            "Alternating"
+        }
+
+        fn start(&self) -> Box<dyn LexerState + '_> {
+            Box::new(Alternating)
+        }
+    }
+
+    impl LexerState for Alternating {
+        fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+            for (i, (start, ch)) in line.char_indices().enumerate() {
+                let kind = if i % 2 == 0 { TokenKind::Name } else { TokenKind::Operator };
+                tokens.push(Token::new(kind, offset + start, offset + start + ch.len_utf8()));
+            }
+            Ok(())
+        }
+    }
+
+    /// Fails on any line containing `!`.
+    struct Failing;
+
+    impl Lexer for Failing {
+        fn name(&self) -> &str {
+            "Failing"
+        }
+
+        fn start(&self) -> Box<dyn LexerState + '_> {
+            Box::new(Failing)
+        }
+    }
+
+    impl LexerState for Failing {
+        fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+            if line.contains('!') {
+                return Err(HighlightError::Lex(format!("bang at {offset}")));
+            }
+            PlainText.tokenize_line(line, offset, tokens)
+        }
+    }
+
+    #[test]
+    fn iterator_matches_tokenize() {
+        let src = "añb\nçd€\n\nz";
+        let collected: Vec<Token> = Alternating.tokens(src).map(|item| item.unwrap().0).collect();
+        assert_eq!(collected, Alternating.tokenize(src).unwrap());
+
+        let texts: String = Alternating.tokens(src).map(|item| item.unwrap().1).collect();
+        assert_eq!(texts, src);
+    }
+
+    #[test]
+    fn iterator_merges_tokens_across_lines() {
+        let src = "one\ntwo\nthree";
+        let tokens: Vec<_> = PlainText.tokens(src).collect::<Result<_, _>>().unwrap();
+        assert_eq!(tokens, vec![(Token::new(TokenKind::Text, 0, src.len()), src)]);
+    }
+
+    #[test]
+    fn iterator_stops_after_error() {
+        let mut tokens = Failing.tokens("ok\nbad!\nnever\n");
+        assert!(matches!(tokens.next(), Some(Ok((_, "ok\n")))));
+        assert!(matches!(tokens.next(), Some(Err(HighlightError::Lex(_)))));
+        assert!(tokens.next().is_none());
+    }
+
+    #[test]
+    fn delimited_end_counts_nesting_across_lines() {
+        let mut depth = 0;
+        assert_eq!(
+            delimited_end("(* a (* b *) c *) d", Some("(*"), "*)", &mut depth),
+            Some(17)
+        );
+        assert_eq!(depth, 0);
+
+        assert_eq!(delimited_end("(* a (* b\n", Some("(*"), "*)", &mut depth), None);
+        assert_eq!(depth, 2);
+        assert_eq!(delimited_end("*) still open\n", Some("(*"), "*)", &mut depth), None);
+        assert_eq!(delimited_end("*) x", Some("(*"), "*)", &mut depth), Some(2));
+
+        let mut depth = 1;
+        assert_eq!(delimited_end(" /* */ */", None, "*/", &mut depth), Some(6));
+    }
+
+    #[test]
+    fn plain_text_covers_input() {
+        let tokens = PlainText.tokenize("a < b\nc\n").unwrap();
+        assert_eq!(tokens, vec![Token::new(TokenKind::Text, 0, 8)]);
+        assert!(PlainText.tokenize("").unwrap().is_empty());
+    }
+}
@@ -0,0 +1,483 @@
+//! Stateful lexer for Protocol Buffers schemas.
+
+use super::{Cursor, Lexer, LexerState, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+const KEYWORDS: &[&str] = &[
+    "edition",
+    "extensions",
+    "import",
+    "max",
+    "option",
+    "optional",
+    "package",
+    "public",
+    "repeated",
+    "required",
+    "reserved",
+    "returns",
+    "stream",
+    "syntax",
+    "to",
+    "weak",
+];
+
+const DECLARATIONS: &[&str] = &["enum", "extend", "message", "oneof", "rpc", "service"];
+
+const CONSTANTS: &[&str] = &["true", "false", "inf", "nan"];
+
+const TYPES: &[&str] = &[
+    "bool", "bytes", "double", "fixed32", "fixed64", "float", "int32", "int64", "map", "sfixed32", "sfixed64",
+    "sint32", "sint64", "string", "uint32", "uint64",
+];
+
+/// Lexer for Protocol Buffers (`.proto` files), proto2, proto3, and editions alike.
+///
+/// The names declared by `message`, `enum`, `service`, and `extend` are [TokenKind::NameClass] and those declared by
+/// `rpc` [TokenKind::NameFunction]. In a field, the type is [TokenKind::KeywordType] when it is a scalar (or `map`)
+/// and [TokenKind::NameClass] when it names a message or enum, the field name is [TokenKind::NameAttribute], and the
+/// field number [TokenKind::Number]; enum values are [TokenKind::NameConstant]. Option names, including custom ones
+/// like `(my.option).field`, are [TokenKind::NameAttribute] wherever they appear: after `option`, in a field's
+/// `[...]` options, and as the keys of `{ ... }` aggregate values. Identifiers used as option values are
+/// [TokenKind::NameConstant]. Strings take either quote, with escapes as [TokenKind::StringEscape]; both `//` and
+/// `/* */` comments are [TokenKind::Comment].
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Protobuf;
+///
+/// let src = "message User {\n  repeated Role roles = 4 [(auth.scope) = ADMIN];\n}\n";
+/// let tokens = Protobuf.tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("User"), Some(TokenKind::NameClass));
+/// assert_eq!(kind("Role"), Some(TokenKind::NameClass));
+/// assert_eq!(kind("roles"), Some(TokenKind::NameAttribute));
+/// assert_eq!(kind("4"), Some(TokenKind::Number));
+/// assert_eq!(kind("scope"), Some(TokenKind::NameAttribute));
+/// assert_eq!(kind("ADMIN"), Some(TokenKind::NameConstant));
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct Protobuf;
+
+impl Lexer for Protobuf {
+    fn name(&self) -> &str {
+        "Protocol Buffers"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(ProtobufState {
+            block_comment: false,
+            groups: Vec::new(),
+            expect: Expect::Nothing,
+            body_next: None,
+            option_name: false,
+            value: false,
+        })
+    }
+}
+
+/// An open bracket.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Group {
+    /// The body of a `message`, `oneof`, or `extend`, which holds fields.
+    Message,
+    Enum,
+    Service,
+    /// Any other braces, such as an `rpc` body.
+    Block,
+    /// A `{ ... }` option value in text format.
+    Aggregate,
+    /// `[ ... ]` inside an option value.
+    List,
+    /// A field's `[ ... ]` options.
+    Options,
+    Paren,
+}
+
+/// A declared name the next identifier may be.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Expect {
+    Nothing,
+    TypeName,
+    RpcName,
+    /// The name of a `oneof`, which reads like a field.
+    OneofName,
+    /// The dotted name after `package`.
+    Package,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct ProtobufState {
+    /// Inside `/* ... */`.
+    block_comment: bool,
+    groups: Vec<Group>,
+    expect: Expect,
+    /// What the next `{` opens, set by the declaration it belongs to.
+    body_next: Option<Group>,
+    /// Between `option` (or a field's `[` or `,`) and the `=` that ends the option's name.
+    option_name: bool,
+    /// After `=`, until the value ends.
+    value: bool,
+}
+
+impl LexerState for ProtobufState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        while cursor.pos < line.len() {
+            if self.block_comment {
+                self.comment(&mut cursor);
+            } else {
+                self.code(&mut cursor);
+            }
+        }
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl ProtobufState {
+    fn code(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+
+        if ch.is_whitespace() {
+            cursor.emit(TokenKind::Whitespace, cursor.run_until(|ch| !ch.is_whitespace()));
+            return;
+        }
+        if rest.starts_with("//") {
+            cursor.emit(TokenKind::Comment, cursor.run_until(|ch| ch == '\n'));
+            return;
+        }
+        if rest.starts_with("/*") {
+            cursor.emit(TokenKind::Comment, 2);
+            self.block_comment = true;
+            return;
+        }
+
+        // A package name lasts until its `;`, dots and all.
+        let mut expect = if self.expect == Expect::Package { Expect::Package } else { Expect::Nothing };
+        match ch {
+            '"' | '\'' => string(cursor, ch),
+            '0'..='9' => cursor.emit(TokenKind::Number, number_len(rest)),
+            '.' if rest[1..].starts_with(|ch: char| ch.is_ascii_digit()) => {
+                cursor.emit(TokenKind::Number, number_len(rest))
+            }
+            _ if ch == '_' || ch.is_ascii_alphabetic() => {
+                let len = cursor.run_until(|ch| !(ch == '_' || ch.is_ascii_alphanumeric()));
+                let word = &rest[..len];
+                let kind = self.word(word, rest[len..].trim_start());
+                if kind == TokenKind::KeywordDeclaration || kind == TokenKind::Keyword {
+                    match word {
+                        "message" | "extend" => (expect, self.body_next) = (Expect::TypeName, Some(Group::Message)),
+                        "enum" => (expect, self.body_next) = (Expect::TypeName, Some(Group::Enum)),
+                        "service" => (expect, self.body_next) = (Expect::TypeName, Some(Group::Service)),
+                        "rpc" => (expect, self.body_next) = (Expect::RpcName, Some(Group::Block)),
+                        "oneof" => (expect, self.body_next) = (Expect::OneofName, Some(Group::Message)),
+                        "package" => expect = Expect::Package,
+                        "option" => self.option_name = true,
+                        _ => {}
+                    }
+                }
+                cursor.emit(kind, len);
+            }
+            '{' => {
+                let group =
+                    if self.in_value() { Group::Aggregate } else { self.body_next.take().unwrap_or(Group::Block) };
+                self.groups.push(group);
+                self.value = false;
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '[' => {
+                // Lists only appear inside aggregates; after a field number, `[` opens its options.
+                if matches!(self.groups.last(), Some(Group::Aggregate | Group::List)) {
+                    self.groups.push(Group::List);
+                } else {
+                    self.groups.push(Group::Options);
+                    self.option_name = true;
+                }
+                self.value = false;
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '(' => {
+                self.groups.push(Group::Paren);
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '}' | ']' | ')' => {
+                if self.groups.pop() == Some(Group::Options) {
+                    self.option_name = false;
+                }
+                if ch != ')' {
+                    self.value = false;
+                }
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ';' => {
+                expect = Expect::Nothing;
+                self.value = false;
+                self.option_name = false;
+                self.body_next = None;
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ',' => {
+                self.value = false;
+                self.option_name = self.groups.last() == Some(&Group::Options);
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '.' | ':' | '<' | '>' => cursor.emit(TokenKind::Punctuation, 1),
+            '=' => {
+                self.option_name = false;
+                self.value = true;
+                cursor.emit(TokenKind::Operator, 1);
+            }
+            '-' | '+' => cursor.emit(TokenKind::Operator, 1),
+            _ => cursor.emit(TokenKind::Error, ch.len_utf8()),
+        }
+        self.expect = expect;
+    }
+
+    /// Whether the cursor is in an option's value, as opposed to a declaration.
+    fn in_value(&self) -> bool {
+        self.value || matches!(self.groups.last(), Some(Group::Aggregate | Group::List))
+    }
+
+    /// Classifies an identifier, given the rest of the line after it with leading whitespace removed.
+    fn word(&self, word: &str, next: &str) -> TokenKind {
+        let group = self.groups.last().copied();
+        if self.option_name {
+            return TokenKind::NameAttribute;
+        }
+        match self.expect {
+            Expect::TypeName => return TokenKind::NameClass,
+            Expect::RpcName => return TokenKind::NameFunction,
+            Expect::OneofName => return TokenKind::NameAttribute,
+            Expect::Package => return TokenKind::Name,
+            Expect::Nothing => {}
+        }
+        if self.in_value() {
+            return if group == Some(Group::Aggregate) && next.starts_with([':', '{', '<']) {
+                TokenKind::NameAttribute
+            } else if CONSTANTS.contains(&word) {
+                TokenKind::KeywordConstant
+            } else {
+                TokenKind::NameConstant
+            };
+        }
+        if next.starts_with('=') {
+            match group {
+                Some(Group::Enum) => return TokenKind::NameConstant,
+                Some(Group::Message) => return TokenKind::NameAttribute,
+                _ => {}
+            }
+        }
+        if KEYWORDS.contains(&word) {
+            TokenKind::Keyword
+        } else if DECLARATIONS.contains(&word) {
+            TokenKind::KeywordDeclaration
+        } else if TYPES.contains(&word) {
+            TokenKind::KeywordType
+        } else {
+            TokenKind::NameClass
+        }
+    }
+
+    fn comment(&mut self, cursor: &mut Cursor) {
+        match cursor.rest().find("*/") {
+            Some(end) => {
+                cursor.emit(TokenKind::Comment, end + 2);
+                self.block_comment = false;
+            }
+            None => cursor.emit(TokenKind::Comment, cursor.rest().len()),
+        }
+    }
+}
+
+/// Lexes a string, which ends at its closing `quote` or, unterminated, at the end of the line.
+fn string(cursor: &mut Cursor, quote: char) {
+    cursor.emit(TokenKind::String, 1);
+    loop {
+        let rest = cursor.rest();
+        match cursor.peek() {
+            None | Some('\n') => return,
+            Some(ch) if ch == quote => {
+                cursor.emit(TokenKind::String, 1);
+                return;
+            }
+            Some('\\') => match escape_len(rest) {
+                Some(len) => cursor.emit(TokenKind::StringEscape, len),
+                None => {
+                    let next = rest[1..].chars().next().filter(|&ch| ch != '\n');
+                    cursor.emit(TokenKind::Error, 1 + next.map_or(0, char::len_utf8));
+                }
+            },
+            Some(_) => {
+                let len = rest.find([quote, '\\', '\n']).unwrap_or(rest.len());
+                cursor.emit(TokenKind::String, len);
+            }
+        }
+    }
+}
+
+/// Length of the escape sequence `rest` starts with, or `None` when it isn't a valid one.
+fn escape_len(rest: &str) -> Option<usize> {
+    // Hex and octal escapes take as many digits as follow, up to their limit.
+    let digits = |from: usize, max: usize, radix: u32| {
+        let len = rest[from..]
+            .chars()
+            .take(max)
+            .take_while(|ch| ch.is_digit(radix))
+            .count();
+        (len > 0).then_some(from + len)
+    };
+    let exact = |len: usize| {
+        let digits = rest.get(2..2 + len)?;
+        digits.chars().all(|ch| ch.is_ascii_hexdigit()).then_some(2 + len)
+    };
+    match rest[1..].chars().next()? {
+        'a' | 'b' | 'f' | 'n' | 'r' | 't' | 'v' | '\\' | '\'' | '"' | '?' => Some(2),
+        'x' | 'X' => digits(2, 2, 16),
+        'u' => exact(4),
+        'U' => exact(8),
+        '0'..='7' => digits(1, 3, 8),
+        _ => None,
+    }
+}
+
+/// Length of the number literal `rest` starts with: decimal, hex, or octal integers and decimal floats.
+fn number_len(rest: &str) -> usize {
+    let bytes = rest.as_bytes();
+    let hex = rest.starts_with("0x") || rest.starts_with("0X");
+    let mut len = 0;
+    while let Some(&byte) = bytes.get(len) {
+        let exponent_sign = !hex && matches!(byte, b'+' | b'-') && len > 0 && matches!(bytes[len - 1], b'e' | b'E');
+        if byte.is_ascii_alphanumeric() || byte == b'_' || byte == b'.' || exponent_sign {
+            len += 1;
+        } else {
+            break;
+        }
+    }
+    len
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::assert_fixture_tokens;
+
+    /// Non-whitespace tokens as (kind, text) pairs.
+    fn kinds(src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = Protobuf.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    fn texts(src: &str, kind: TokenKind) -> Vec<&str> {
+        kinds(src)
+            .into_iter()
+            .filter(|(k, _)| *k == kind)
+            .map(|(_, text)| text)
+            .collect()
+    }
+
+    #[test]
+    fn fields_split_into_types_names_and_numbers() {
+        let src = "message Order {\n  optional string id = 1;\n  repeated google.protobuf.Timestamp times = 2;\n  map<string, Item> items = 3;\n  oneof payment {\n    Card card = 15;\n  }\n  int32 max = 4;\n}\n";
+        assert_eq!(
+            texts(src, TokenKind::NameClass),
+            ["Order", "google", "protobuf", "Timestamp", "Item", "Card"]
+        );
+        assert_eq!(
+            texts(src, TokenKind::NameAttribute),
+            ["id", "times", "items", "payment", "card", "max"]
+        );
+        assert_eq!(texts(src, TokenKind::Number), ["1", "2", "3", "15", "4"]);
+        assert_eq!(texts(src, TokenKind::KeywordType), ["string", "map", "string", "int32"]);
+        assert_eq!(texts(src, TokenKind::Keyword), ["optional", "repeated"]);
+    }
+
+    #[test]
+    fn enums_services_and_reserved_ranges() {
+        let src = "enum Status {\n  option allow_alias = true;\n  STATUS_UNSPECIFIED = 0;\n  ACTIVE = 1 [deprecated = true];\n  reserved 2, 9 to max;\n}\nservice Orders {\n  rpc Watch(stream WatchRequest) returns (stream Order) {}\n}\n";
+        assert_eq!(
+            texts(src, TokenKind::NameClass),
+            ["Status", "Orders", "WatchRequest", "Order"]
+        );
+        assert_eq!(texts(src, TokenKind::NameFunction), ["Watch"]);
+        assert_eq!(texts(src, TokenKind::NameConstant), ["STATUS_UNSPECIFIED", "ACTIVE"]);
+        assert_eq!(texts(src, TokenKind::NameAttribute), ["allow_alias", "deprecated"]);
+        assert_eq!(texts(src, TokenKind::KeywordConstant), ["true", "true"]);
+        assert_eq!(
+            texts(src, TokenKind::Keyword),
+            ["option", "reserved", "to", "max", "stream", "returns", "stream"]
+        );
+    }
+
+    #[test]
+    fn custom_options_and_aggregate_values() {
+        let src = "option (my.option).level = HIGH;\nrpc Get(Req) returns (Res) {\n  option (google.api.http) = {\n    get: \"/v1/{name=users/*}\"\n    additional_bindings { post: \"/v1\" }\n  };\n}\nint32 n = 1 [(validate.rules).int32 = { gt: 0, in: [1, 2] }, json_name = \"N\"];\n";
+        assert_eq!(
+            texts(src, TokenKind::NameAttribute),
+            [
+                "my",
+                "option",
+                "level",
+                "google",
+                "api",
+                "http",
+                "get",
+                "additional_bindings",
+                "post",
+                "validate",
+                "rules",
+                "int32",
+                "gt",
+                "in",
+                "json_name",
+            ]
+        );
+        assert_eq!(texts(src, TokenKind::NameConstant), ["HIGH"]);
+        assert_eq!(texts(src, TokenKind::Number), ["1", "0", "1", "2"]);
+        assert!(texts(src, TokenKind::Error).is_empty());
+    }
+
+    #[test]
+    fn header_strings_and_comments() {
+        let src = "syntax = \"proto3\";\npackage acme.orders.v1; // ok\nimport public 'a\\x41b\\101\\q.proto';\n/* block\n   comment */ edition = \"2023\";\n";
+        assert_eq!(texts(src, TokenKind::Name), ["acme", "orders", "v1"]);
+        assert_eq!(
+            texts(src, TokenKind::Keyword),
+            ["syntax", "package", "import", "public", "edition"]
+        );
+        assert_eq!(texts(src, TokenKind::StringEscape), ["\\x41", "\\101"]);
+        assert_eq!(texts(src, TokenKind::Error), ["\\q"]);
+        assert_eq!(texts(src, TokenKind::Comment), ["// ok", "/* block\n   comment */"]);
+        assert_eq!(
+            texts("x = -1.5e-3, 0x1F, 017, inf", TokenKind::Number),
+            ["1.5e-3", "0x1F", "017"]
+        );
+    }
+
+    #[test]
+    fn api_schema_matches_golden_tokens() {
+        assert_fixture_tokens(&Protobuf, "schema.proto");
+    }
+}
@@ -0,0 +1,742 @@
+//! Stateful lexer for Python source.
+
+use super::{Cursor, Lexer, LexerState, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+const KEYWORDS: &[&str] = &[
+    "and", "as", "assert", "async", "await", "break", "continue", "del", "elif", "else", "except", "finally", "for",
+    "from", "global", "if", "import", "in", "is", "lambda", "nonlocal", "not", "or", "pass", "raise", "return", "try",
+    "while", "with", "yield",
+];
+
+const DECLARATIONS: &[&str] = &["class", "def"];
+
+const CONSTANTS: &[&str] = &["True", "False", "None"];
+
+/// Built-in functions and types.
+const BUILTINS: &[&str] = &[
+    "__import__",
+    "abs",
+    "aiter",
+    "all",
+    "anext",
+    "any",
+    "ascii",
+    "bin",
+    "bool",
+    "breakpoint",
+    "bytearray",
+    "bytes",
+    "callable",
+    "chr",
+    "classmethod",
+    "compile",
+    "complex",
+    "delattr",
+    "dict",
+    "dir",
+    "divmod",
+    "enumerate",
+    "eval",
+    "exec",
+    "filter",
+    "float",
+    "format",
+    "frozenset",
+    "getattr",
+    "globals",
+    "hasattr",
+    "hash",
+    "help",
+    "hex",
+    "id",
+    "input",
+    "int",
+    "isinstance",
+    "issubclass",
+    "iter",
+    "len",
+    "list",
+    "locals",
+    "map",
+    "max",
+    "memoryview",
+    "min",
+    "next",
+    "object",
+    "oct",
+    "open",
+    "ord",
+    "pow",
+    "print",
+    "property",
+    "range",
+    "repr",
+    "reversed",
+    "round",
+    "set",
+    "setattr",
+    "slice",
+    "sorted",
+    "staticmethod",
+    "str",
+    "sum",
+    "super",
+    "tuple",
+    "type",
+    "vars",
+    "zip",
+];
+
+/// Operators, longest first.
+const OPERATORS: &[&str] = &[
+    "**=", "//=", "<<=", ">>=", "...", "->", ":=", "**", "//", "<<", ">>", "<=", ">=", "==", "!=", "+=", "-=", "*=",
+    "/=", "%=", "@=", "&=", "|=", "^=", "+", "-", "*", "/", "%", "@", "&", "|", "^", "~", "<", ">", "=",
+];
+
+/// Lexer for Python.
+///
+/// String literals take any prefix (`r`, `b`, `f`, `t`, and their combinations, in either case) and are
+/// [TokenKind::String] with their escapes (`\n`, `\x7b`, `\N{DASH}`) as [TokenKind::StringEscape]; malformed
+/// escapes are [TokenKind::Error], and unrecognized ones keep their backslash as string text, as Python does.
+///
+/// F-strings (and t-strings) are lexed down to their replacement fields: the braces are [TokenKind::Punctuation],
+/// the expression inside is lexed as ordinary code (so it may hold strings, even f-strings, using the same quotes),
+/// the `!` of a `!r`, `!s`, or `!a` conversion is [TokenKind::Punctuation] and its flag [TokenKind::NameBuiltin]
+/// (it applies `repr`, `str`, or `ascii`), and the format spec from its `:` up to the closing brace is
+/// [TokenKind::StringEscape], re-entering expression mode for nested fields like `{value:{width}}`. `{{` and `}}`
+/// are escapes. Triple-quoted strings, and fields inside single-quoted ones, can span lines.
+///
+/// Built-in functions and types are [TokenKind::NameBuiltin], the names in `def` and `class` statements
+/// [TokenKind::NameFunction] and [TokenKind::NameClass], and decorators [TokenKind::NameAttribute]. The soft
+/// keywords `match`, `case`, and `type` are keywords only where they start a statement, so they stay names in
+/// `match = re.match(text)`.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Python;
+///
+/// let src = "f\"total: {x + y:.2f}\"\n";
+/// let tokens = Python.tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("{"), Some(TokenKind::Punctuation));
+/// assert_eq!(kind("+"), Some(TokenKind::Operator));
+/// assert_eq!(kind(":.2f"), Some(TokenKind::StringEscape));
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct Python;
+
+impl Lexer for Python {
+    fn name(&self) -> &str {
+        "Python"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(PythonState {
+            contexts: Vec::new(),
+            depth: 0,
+            expect: Expect::Nothing,
+            after_dot: false,
+            statement_start: true,
+            first_line: true,
+        })
+    }
+}
+
+/// How a string literal is quoted and what its prefix allows.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct Quote {
+    quote: char,
+    triple: bool,
+    raw: bool,
+    bytes: bool,
+    /// An f-string or t-string, whose braces open replacement fields.
+    format: bool,
+}
+
+impl Quote {
+    /// The text that closes the string.
+    fn delimiter(&self) -> &'static str {
+        match (self.quote, self.triple) {
+            ('\'', false) => "'",
+            ('\'', true) => "'''",
+            (_, false) => "\"",
+            (_, true) => "\"\"\"",
+        }
+    }
+}
+
+/// A nested construct the next characters continue, innermost last.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Context {
+    String(Quote),
+    /// A replacement field's expression, with the number of brackets opened inside it.
+    Field {
+        depth: usize,
+    },
+    /// A replacement field's format spec, which ends at the field's closing brace.
+    Spec,
+}
+
+/// A declared name the next identifier may be.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Expect {
+    Nothing,
+    FuncName,
+    ClassName,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct PythonState {
+    contexts: Vec<Context>,
+    /// Brackets open outside any string, across which line breaks don't end the statement.
+    depth: usize,
+    expect: Expect,
+    /// The last token was `.`, so an identifier is an attribute.
+    after_dot: bool,
+    /// At the start of a logical line or after `;`, where `@` begins a decorator.
+    statement_start: bool,
+    /// No line has been lexed yet, so a `#!` comment starting the line is a shebang.
+    first_line: bool,
+}
+
+impl LexerState for PythonState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        while cursor.pos < line.len() {
+            match self.contexts.last() {
+                Some(&Context::String(quote)) => self.string(&mut cursor, quote),
+                Some(Context::Spec) => self.spec(&mut cursor),
+                Some(Context::Field { .. }) | None => self.code(&mut cursor),
+            }
+        }
+        self.first_line = false;
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl PythonState {
+    /// Lexes code, either at the top level or inside a replacement field.
+    fn code(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+
+        if ch.is_whitespace() {
+            let len = cursor.run_until(|ch| !ch.is_whitespace());
+            self.statement_start |= rest[..len].contains('\n') && self.depth == 0 && self.contexts.is_empty();
+            cursor.emit(TokenKind::Whitespace, len);
+            return;
+        }
+        if ch == '#' {
+            let len = cursor.run_until(|ch| ch == '\n');
+            let shebang = self.first_line && cursor.pos == 0 && rest.starts_with("#!");
+            cursor.emit(
+                if shebang { TokenKind::CommentPreproc } else { TokenKind::Comment },
+                len,
+            );
+            return;
+        }
+        // At a field's top level, `}` closes it, `!` starts a conversion, and `:` starts the format spec (so
+        // `f'{x:=10}'` formats `x` with the spec `=10`).
+        if self.contexts.last() == Some(&Context::Field { depth: 0 }) {
+            match ch {
+                '}' => {
+                    cursor.emit(TokenKind::Punctuation, 1);
+                    self.contexts.pop();
+                    return;
+                }
+                '!' if !rest.starts_with("!=") => {
+                    cursor.emit(TokenKind::Punctuation, 1);
+                    cursor.emit(TokenKind::NameBuiltin, cursor.run_until(|ch| !ch.is_alphanumeric()));
+                    return;
+                }
+                ':' => {
+                    self.contexts.pop();
+                    self.contexts.push(Context::Spec);
+                    return;
+                }
+                _ => {}
+            }
+        }
+
+        let (mut statement_start, mut after_dot) = (false, false);
+        let mut expect = Expect::Nothing;
+        match ch {
+            '\'' | '"' => self.open_string(cursor, 0),
+            '0'..='9' => cursor.emit(TokenKind::Number, number_len(rest)),
+            '.' if rest[1..].starts_with(|ch: char| ch.is_ascii_digit()) => {
+                cursor.emit(TokenKind::Number, number_len(rest))
+            }
+            '@' if self.statement_start && rest[1..].starts_with(|ch: char| ch == '_' || ch.is_alphabetic()) => {
+                let len = 1 + rest[1..]
+                    .find(|ch: char| !(ch == '_' || ch == '.' || ch.is_alphanumeric()))
+                    .unwrap_or(rest.len() - 1);
+                cursor.emit(TokenKind::NameAttribute, len);
+            }
+            _ if ch == '_' || ch.is_alphabetic() => {
+                let len = cursor.run_until(|ch| !(ch == '_' || ch.is_alphanumeric()));
+                let word = &rest[..len];
+                if rest[len..].starts_with(['\'', '"']) && string_prefix(word).is_some() {
+                    self.open_string(cursor, len);
+                } else {
+                    let soft = self.soft_keyword(word, &rest[len..]);
+                    let kind = match soft {
+                        Some(kind) => kind,
+                        None => self.word(word, rest[len..].starts_with('(')),
+                    };
+                    match word {
+                        "def" => expect = Expect::FuncName,
+                        "class" => expect = Expect::ClassName,
+                        "type" if soft.is_some() => expect = Expect::ClassName,
+                        _ => {}
+                    }
+                    cursor.emit(kind, len);
+                }
+            }
+            '(' | '[' | '{' => {
+                match self.contexts.last_mut() {
+                    Some(Context::Field { depth }) => *depth += 1,
+                    _ => self.depth += 1,
+                }
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ')' | ']' | '}' => {
+                match self.contexts.last_mut() {
+                    Some(Context::Field { depth }) => *depth = depth.saturating_sub(1),
+                    _ => self.depth = self.depth.saturating_sub(1),
+                }
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ';' | ',' | ':' | '.' | '\\' if !rest.starts_with(":=") && !rest.starts_with("...") => {
+                statement_start = ch == ';';
+                after_dot = ch == '.';
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            _ => match OPERATORS.iter().find(|operator| rest.starts_with(*operator)) {
+                Some(operator) => cursor.emit(TokenKind::Operator, operator.len()),
+                None => cursor.emit(TokenKind::Error, ch.len_utf8()),
+            },
+        }
+        self.statement_start = statement_start;
+        self.after_dot = after_dot;
+        self.expect = expect;
+    }
+
+    /// Classifies `match`, `case`, and `type` where they start a statement rather than name a variable, given the
+    /// rest of the line `after` them.
+    ///
+    /// `match` and `case` are keywords at the start of a statement when an operand follows and the line ends in a
+    /// `:`, so `match = re.match(text)` and `match.group(1)` are plain names; `type` is a declaration when a name and
+    /// then `=` or type parameters follow, as in `type Pair[T] = tuple[T, T]`.
+    fn soft_keyword(&self, word: &str, after: &str) -> Option<TokenKind> {
+        if !self.statement_start || self.depth > 0 || !self.contexts.is_empty() || !after.starts_with([' ', '\t']) {
+            return None;
+        }
+        let operand = after.trim_start();
+        match word {
+            "match" | "case" => {
+                let starts =
+                    operand.starts_with(|ch: char| ch == '_' || ch.is_alphanumeric() || "([{'\"-*~".contains(ch));
+                let line = operand.trim_end();
+                let statement = line.rfind('#').map_or(line, |comment| line[..comment].trim_end());
+                let keyword = starts
+                    && (line.ends_with(':') || statement.ends_with(':'))
+                    && !["in ", "is ", "and ", "or ", "if ", "not "]
+                        .iter()
+                        .any(|word| operand.starts_with(word));
+                keyword.then_some(TokenKind::Keyword)
+            }
+            "type" => {
+                let name = operand
+                    .find(|ch: char| !(ch == '_' || ch.is_alphanumeric()))
+                    .unwrap_or(operand.len());
+                let next = operand[name..].trim_start();
+                let alias = name > 0 && (next.starts_with('[') || (next.starts_with('=') && !next.starts_with("==")));
+                alias.then_some(TokenKind::KeywordDeclaration)
+            }
+            _ => None,
+        }
+    }
+
+    /// Classifies an identifier; `call` is set when `(` follows it.
+    fn word(&self, word: &str, call: bool) -> TokenKind {
+        if KEYWORDS.contains(&word) {
+            TokenKind::Keyword
+        } else if DECLARATIONS.contains(&word) {
+            TokenKind::KeywordDeclaration
+        } else if CONSTANTS.contains(&word) {
+            TokenKind::KeywordConstant
+        } else if self.expect == Expect::FuncName {
+            TokenKind::NameFunction
+        } else if self.expect == Expect::ClassName {
+            TokenKind::NameClass
+        } else if self.after_dot {
+            if call { TokenKind::NameFunction } else { TokenKind::Name }
+        } else if BUILTINS.contains(&word) {
+            TokenKind::NameBuiltin
+        } else if call {
+            TokenKind::NameFunction
+        } else {
+            TokenKind::Name
+        }
+    }
+
+    /// Emits a string's prefix (the first `prefix` bytes) and opening quotes, and enters its body.
+    fn open_string(&mut self, cursor: &mut Cursor, prefix: usize) {
+        let rest = cursor.rest();
+        let (raw, bytes, format) = string_prefix(&rest[..prefix]).expect("prefix is a string prefix");
+        let quote = rest[prefix..].chars().next().expect("a quote follows the prefix");
+        let mut quote = Quote { quote, triple: false, raw, bytes, format };
+        quote.triple = rest[prefix..].starts_with(Quote { triple: true, ..quote }.delimiter());
+        cursor.emit(TokenKind::String, prefix + quote.delimiter().len());
+        self.contexts.push(Context::String(quote));
+    }
+
+    /// Lexes the next piece of a string body: text, an escape, a replacement field's opening brace, or the closing
+    /// quotes. A single-quoted string left open at the end of the line ends there.
+    fn string(&mut self, cursor: &mut Cursor, quote: Quote) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        match ch {
+            '\n' if !quote.triple => {
+                self.contexts.pop();
+            }
+            _ if rest.starts_with(quote.delimiter()) => {
+                cursor.emit(TokenKind::String, quote.delimiter().len());
+                self.contexts.pop();
+            }
+            '\\' if quote.raw => {
+                // A backslash keeps a quote from ending a raw string, but doesn't escape a brace.
+                let kept = rest[1..].starts_with([quote.quote, '\\', '\n']);
+                cursor.emit(TokenKind::String, if kept { 2 } else { 1 });
+            }
+            '\\' => emit_escape(cursor, quote.bytes),
+            '{' | '}' if quote.format && rest[1..].starts_with(ch) => cursor.emit(TokenKind::StringEscape, 2),
+            '{' if quote.format => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                self.contexts.push(Context::Field { depth: 0 });
+            }
+            '}' if quote.format => cursor.emit(TokenKind::Error, 1),
+            _ if ch == quote.quote => cursor.emit(TokenKind::String, 1),
+            _ => {
+                let len = cursor.run_until(|next| {
+                    next == quote.quote
+                        || next == '\\'
+                        || (next == '\n' && !quote.triple)
+                        || (quote.format && (next == '{' || next == '}'))
+                });
+                cursor.emit(TokenKind::String, len);
+            }
+        }
+    }
+
+    /// Lexes the next piece of a format spec: spec text, or a brace opening a nested field or closing the field.
+    fn spec(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        let quote = self.contexts.iter().rev().find_map(|context| match context {
+            Context::String(quote) => Some(*quote),
+            _ => None,
+        });
+        match ch {
+            '{' => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                self.contexts.push(Context::Field { depth: 0 });
+            }
+            '}' => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                self.contexts.pop();
+            }
+            // The string closing inside the spec leaves the field unterminated.
+            _ if quote.is_some_and(|quote| rest.starts_with(quote.delimiter())) => {
+                self.contexts.pop();
+            }
+            _ => {
+                let stop = quote.map(|quote| quote.quote);
+                let first = ch.len_utf8();
+                let len = rest[first..]
+                    .find(|next| next == '{' || next == '}' || Some(next) == stop)
+                    .map_or(rest.len(), |at| at + first);
+                cursor.emit(TokenKind::StringEscape, len);
+            }
+        }
+    }
+}
+
+/// What a string prefix allows, as (raw, bytes, format), or `None` when `word` isn't a prefix.
+fn string_prefix(word: &str) -> Option<(bool, bool, bool)> {
+    let prefix = match word.len() {
+        0..=2 => word.to_ascii_lowercase(),
+        _ => return None,
+    };
+    match prefix.as_str() {
+        "" | "u" => Some((false, false, false)),
+        "r" => Some((true, false, false)),
+        "b" => Some((false, true, false)),
+        "br" | "rb" => Some((true, true, false)),
+        "f" | "t" => Some((false, false, true)),
+        "fr" | "rf" | "tr" | "rt" => Some((true, false, true)),
+        _ => None,
+    }
+}
+
+/// Emits the escape sequence at the cursor. Unrecognized escapes leave the backslash as string text and malformed
+/// ones are errors.
+fn emit_escape(cursor: &mut Cursor, bytes: bool) {
+    let rest = cursor.rest();
+    let next = rest[1..].chars().next();
+    let recognized = next.is_some_and(|ch| "\n\r\\'\"abfnrtv01234567x".contains(ch) || (!bytes && "NuU".contains(ch)));
+    if !recognized {
+        cursor.emit(TokenKind::String, 1);
+        return;
+    }
+    match escape_len(rest) {
+        Some(len) => cursor.emit(TokenKind::StringEscape, len),
+        None => cursor.emit(TokenKind::Error, 1 + next.map_or(0, char::len_utf8)),
+    }
+}
+
+/// Length of the recognized escape sequence `rest` starts with, or `None` when it is malformed.
+fn escape_len(rest: &str) -> Option<usize> {
+    let digits = |len: usize| {
+        let digits = rest.get(2..2 + len)?;
+        digits.chars().all(|ch| ch.is_ascii_hexdigit()).then_some(2 + len)
+    };
+    match rest[1..].chars().next()? {
+        '\r' if rest[2..].starts_with('\n') => Some(3),
+        '0'..='7' => Some(1 + rest[1..].chars().take(3).take_while(|ch| ch.is_digit(8)).count()),
+        'x' => digits(2),
+        'u' => digits(4),
+        'U' => digits(8),
+        'N' => {
+            let name = rest[2..].strip_prefix('{')?;
+            let end = name.find(['}', '\n'])?;
+            (end > 0 && name[end..].starts_with('}')).then_some(4 + end)
+        }
+        _ => Some(2),
+    }
+}
+
+/// Length of the number literal `rest` starts with: decimal, hex, octal, or binary, with digit separators,
+/// exponents, and an optional imaginary `j`.
+fn number_len(rest: &str) -> usize {
+    let bytes = rest.as_bytes();
+    let hex = rest.starts_with("0x") || rest.starts_with("0X");
+    let mut len = 0;
+    let mut dot = false;
+    while let Some(&byte) = bytes.get(len) {
+        let exponent_sign = matches!(byte, b'+' | b'-') && len > 0 && !hex && matches!(bytes[len - 1], b'e' | b'E');
+        // A second `.` (or one before a name, as in `1.real`) ends the literal.
+        let fraction = byte == b'.'
+            && !dot
+            && !bytes.get(len + 1).is_some_and(|&next| {
+                next == b'_' || (next.is_ascii_alphabetic() && !matches!(next, b'e' | b'E' | b'j' | b'J'))
+            });
+        if byte.is_ascii_alphanumeric() || byte == b'_' || exponent_sign || fraction {
+            dot |= byte == b'.';
+            len += 1;
+        } else {
+            break;
+        }
+    }
+    len
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::assert_fixture_tokens;
+
+    /// Non-whitespace tokens as (kind, text) pairs.
+    fn kinds(src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = Python.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    fn texts(src: &str, kind: TokenKind) -> Vec<&str> {
+        kinds(src)
+            .into_iter()
+            .filter(|(k, _)| *k == kind)
+            .map(|(_, text)| text)
+            .collect()
+    }
+
+    #[test]
+    fn replacement_fields_split_into_delimiters_expressions_and_specs() {
+        use TokenKind::*;
+
+        assert_eq!(
+            kinds("f\"total: {x + y:.2f}\""),
+            [
+                (String, "f\"total: "),
+                (Punctuation, "{"),
+                (Name, "x"),
+                (Operator, "+"),
+                (Name, "y"),
+                (StringEscape, ":.2f"),
+                (Punctuation, "}"),
+                (String, "\""),
+            ]
+        );
+        assert_eq!(
+            kinds("f'{a!r:>10} {{x}} {b=!s}'"),
+            [
+                (String, "f'"),
+                (Punctuation, "{"),
+                (Name, "a"),
+                (Punctuation, "!"),
+                (NameBuiltin, "r"),
+                (StringEscape, ":>10"),
+                (Punctuation, "}"),
+                (String, " "),
+                (StringEscape, "{{"),
+                (String, "x"),
+                (StringEscape, "}}"),
+                (String, " "),
+                (Punctuation, "{"),
+                (Name, "b"),
+                (Operator, "="),
+                (Punctuation, "!"),
+                (NameBuiltin, "s"),
+                (Punctuation, "}"),
+                (String, "'"),
+            ]
+        );
+        // `!=` is a comparison, and `:` inside brackets or before `=` is no spec.
+        assert_eq!(texts("f'{3!=4!s:.3}'", TokenKind::Operator), ["!="]);
+        assert_eq!(texts("f'{3!=4!s:.3}'", TokenKind::NameBuiltin), ["s"]);
+        assert_eq!(texts("f'{x:=10}{(y:=10)}{d[1:2]}'", TokenKind::StringEscape), [":=10"]);
+    }
+
+    #[test]
+    fn format_specs_reenter_expressions() {
+        let src = "f'{value:{width:{0}}.{precision}} {10:#{3 != {4:5} and w}x}'";
+        assert_eq!(texts(src, TokenKind::StringEscape), [":", ":", ".", ":#", "x"]);
+        assert_eq!(texts(src, TokenKind::Name), ["value", "width", "precision", "w"]);
+        assert_eq!(texts(src, TokenKind::Keyword), ["and"]);
+        assert_eq!(texts(src, TokenKind::Number), ["0", "10", "3", "4", "5"]);
+        assert!(texts(src, TokenKind::Error).is_empty());
+    }
+
+    #[test]
+    fn expressions_reuse_quotes_and_nest_f_strings() {
+        let src = "f\"{d[\"#\"]}\" f'{f'{f'{y}'}'}' f\"{'\\n'.join(x)}\"";
+        assert_eq!(
+            kinds(src).into_iter().map(|(_, text)| text).collect::<Vec<_>>(),
+            [
+                "f\"", "{", "d", "[", "\"#\"", "]}", "\"", "f'", "{", "f'", "{", "f'", "{", "y", "}", "'", "}", "'",
+                "}", "'", "f\"", "{", "'", "\\n", "'", ".", "join", "(", "x", ")}", "\"",
+            ]
+        );
+        assert_eq!(texts(src, TokenKind::StringEscape), ["\\n"]);
+        assert_eq!(texts(src, TokenKind::NameFunction), ["join"]);
+    }
+
+    #[test]
+    fn raw_strings_keep_backslashes() {
+        let src = r#"rf'\d{x}\n' FR"\N{y}" r'\'' b'\x00\u0041' '\N{LEFT CURLY BRACKET}\d'"#;
+        assert_eq!(texts(src, TokenKind::Name), ["x", "y"]);
+        assert_eq!(
+            texts(src, TokenKind::StringEscape),
+            [r"\x00", r"\N{LEFT CURLY BRACKET}"]
+        );
+        assert_eq!(
+            texts(src, TokenKind::String),
+            [
+                r"rf'\d", r"\n'", r#"FR"\N"#, r#"""#, r"r'\''", "b'", r"\u0041'", "'", r"\d'",
+            ]
+        );
+        assert_eq!(texts(r"'\x4' '\N{}'", TokenKind::Error), [r"\x", r"\N"]);
+    }
+
+    #[test]
+    fn triple_quoted_f_strings_span_lines() {
+        let src = "s = f\"\"\"a {x\n  + 1  # {\"\n} b\n{y:>{w}}\"\"\" + 'open\nz = 1\n";
+        assert_eq!(texts(src, TokenKind::Comment), ["# {\""]);
+        assert_eq!(texts(src, TokenKind::String), ["f\"\"\"a ", " b\n", "\"\"\"", "'open"]);
+        assert_eq!(texts(src, TokenKind::StringEscape), [":>"]);
+        assert_eq!(texts(src, TokenKind::Name), ["s", "x", "y", "w", "z"]);
+
+        // A single-quoted field's expression may span lines too.
+        assert_eq!(texts("f'{1 +\n 2}'\n", TokenKind::Number), ["1", "2"]);
+    }
+
+    #[test]
+    fn declarations_decorators_and_numbers() {
+        let src = "#!/usr/bin/env python3\n@functools.cache\ndef fib(n: int) -> int:\n    return n if n < 2 else fib(n - 1) @ m\n\nclass A(Base): x = (0x_FF, 1_000.5e-3j, .5, 1.real, True)\n";
+        assert_eq!(texts(src, TokenKind::CommentPreproc), ["#!/usr/bin/env python3"]);
+        assert_eq!(texts(src, TokenKind::NameAttribute), ["@functools.cache"]);
+        assert_eq!(texts(src, TokenKind::NameFunction), ["fib", "fib"]);
+        assert_eq!(texts(src, TokenKind::NameClass), ["A"]);
+        assert_eq!(texts(src, TokenKind::NameBuiltin), ["int", "int"]);
+        assert_eq!(texts(src, TokenKind::KeywordDeclaration), ["def", "class"]);
+        assert_eq!(
+            texts(src, TokenKind::Number),
+            ["2", "1", "0x_FF", "1_000.5e-3j", ".5", "1"]
+        );
+        assert_eq!(texts(src, TokenKind::Operator), ["->", "<", "-", "@", "="]);
+        assert_eq!(texts(src, TokenKind::KeywordConstant), ["True"]);
+    }
+
+    #[test]
+    fn only_the_first_line_is_a_shebang() {
+        let src = "#!/usr/bin/env python3\nx = 1\n#!x\n";
+        assert_eq!(texts(src, TokenKind::CommentPreproc), ["#!/usr/bin/env python3"]);
+
+        // Incremental relexing hands each line over at offset 0, which mustn't make it look like the first.
+        let mut doc = crate::highlight::Incremental::new(&Python, src).unwrap();
+        doc.edit(src.len() - 2..src.len() - 1, "y").unwrap();
+        assert_eq!(
+            doc.tokens_for_lines(0..doc.line_count()),
+            Python.tokenize(doc.source()).unwrap()
+        );
+    }
+
+    #[test]
+    fn soft_keywords_are_keywords_only_where_they_start_statements() {
+        let src = "match = re.match(pattern, text)\nmatch.group(1)\nmatch (x, y):  # tuple\n    case [first, *rest] if first:\n        pass\n    case _:\n        case = match\nprint(match is None, type(x))\ntype Pair[T] = tuple[T, T]\ntype = 3\n";
+        assert_eq!(
+            texts(src, TokenKind::Keyword),
+            ["match", "case", "if", "pass", "case", "is"]
+        );
+        assert_eq!(texts(src, TokenKind::KeywordDeclaration), ["type"]);
+        assert_eq!(texts(src, TokenKind::NameClass), ["Pair"]);
+        assert_eq!(
+            texts(src, TokenKind::Name),
+            [
+                "match", "re", "pattern", "text", "match", "x", "y", "first", "rest", "first", "_", "case", "match",
+                "match", "x", "T", "T", "T",
+            ]
+        );
+        assert_eq!(texts(src, TokenKind::NameFunction), ["match", "group"]);
+        assert_eq!(texts(src, TokenKind::NameBuiltin), ["print", "type", "tuple", "type"]);
+    }
+
+    #[test]
+    fn cpython_f_string_cases_match_golden_tokens() {
+        // Edge cases from CPython's `Lib/test/test_fstring.py`, checked to parse under Python 3.13.
+        assert_fixture_tokens(&Python, "fstrings.py");
+    }
+}
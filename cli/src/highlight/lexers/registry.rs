@@ -0,0 +1,700 @@
+//! Registry of lexers by name, alias, file name, and MIME type, so applications can add languages or replace bundled
+//! ones.
+
+use super::rules::{RuleError, RuleLexer, RuleTable};
+use super::{
+    C, Cpp, Diff, Dockerfile, Go, GraphQL, Html, JavaScript, KeyValue, KeyValueDialect, Lexer, Markdown, Protobuf,
+    Python, Rust, Shell, Sql, SqlDialect, Toml, TypeScript, Yaml,
+};
+use crate::highlight::detect_language;
+
+use std::fmt;
+use std::path::Path;
+use std::sync::{RwLock, RwLockReadGuard};
+
+/// Errors raised while registering a lexer.
+#[derive(Debug)]
+pub enum RegistryError {
+    /// `alias` already names `language`, another registered lexer.
+    Conflict { alias: String, language: String },
+    /// The config has an empty name or no lexer.
+    Invalid(String),
+    /// The config's rule table doesn't compile.
+    Rules(RuleError),
+}
+
+impl fmt::Display for RegistryError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            RegistryError::Conflict { alias, language } => {
+                write!(
+                    f,
+                    "failed to register lexer: {alias:?} is already registered to {language}"
+                )
+            }
+            RegistryError::Invalid(message) => write!(f, "failed to register lexer: {message}"),
+            RegistryError::Rules(source) => write!(f, "failed to register lexer: {source}"),
+        }
+    }
+}
+
+impl std::error::Error for RegistryError {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        match self {
+            RegistryError::Rules(source) => Some(source),
+            _ => None,
+        }
+    }
+}
+
+impl From<RuleError> for RegistryError {
+    fn from(source: RuleError) -> Self {
+        RegistryError::Rules(source)
+    }
+}
+
+/// How a registered language is lexed.
+enum Source {
+    Rules(RuleTable),
+    Lexer(Box<dyn Lexer>),
+}
+
+/// Describes a language to [Registry::register]: the names it answers to, the files and MIME types it claims, and
+/// either a [RuleTable] or a ready-made [Lexer].
+pub struct LexerConfig {
+    name: String,
+    aliases: Vec<String>,
+    filenames: Vec<String>,
+    mime_types: Vec<String>,
+    source: Option<Source>,
+}
+
+impl LexerConfig {
+    /// Starts a config for the language called `name`, which is also its first alias.
+    pub fn new(name: &str) -> Self {
+        Self {
+            name: name.to_string(),
+            aliases: Vec::new(),
+            filenames: Vec::new(),
+            mime_types: Vec::new(),
+            source: None,
+        }
+    }
+
+    /// Adds another name to look the lexer up by (e.g., an extension like "rs").
+    pub fn with_alias(mut self, alias: &str) -> Self {
+        self.aliases.push(alias.to_string());
+        self
+    }
+
+    /// Claims files matching `pattern`, a glob where `*` matches any run of characters and `?` any one character.
+    ///
+    /// Patterns without a `/` are matched against the file name alone (`*.units`, `Unitfile`); others against the
+    /// whole path.
+    pub fn with_filename(mut self, pattern: &str) -> Self {
+        self.filenames.push(pattern.to_string());
+        self
+    }
+
+    /// Claims a MIME type (e.g., "text/x-units").
+    pub fn with_mime_type(mut self, mime_type: &str) -> Self {
+        self.mime_types.push(mime_type.to_string());
+        self
+    }
+
+    /// Lexes with a [RuleLexer] compiled from `rules` at registration.
+    pub fn with_rules(mut self, rules: RuleTable) -> Self {
+        self.source = Some(Source::Rules(rules));
+        self
+    }
+
+    /// Lexes with `lexer`.
+    pub fn with_lexer(mut self, lexer: impl Lexer + 'static) -> Self {
+        self.source = Some(Source::Lexer(Box::new(lexer)));
+        self
+    }
+
+    /// The language name the config was created with.
+    pub fn name(&self) -> &str {
+        &self.name
+    }
+
+    /// Builds the config's lexer without registering it, compiling its rule table if it has one.
+    pub fn into_lexer(self) -> Result<Box<dyn Lexer>, RegistryError> {
+        match self.source {
+            Some(Source::Rules(rules)) => Ok(Box::new(RuleLexer::new(&self.name, &rules)?)),
+            Some(Source::Lexer(lexer)) => Ok(lexer),
+            None => Err(RegistryError::Invalid(format!(
+                "{} has neither rules nor a lexer",
+                self.name
+            ))),
+        }
+    }
+}
+
+/// A registered language with its lookup keys normalized.
+struct Entry {
+    name: String,
+    /// Lowercase name and aliases.
+    aliases: Vec<String>,
+    filenames: Vec<String>,
+    /// Lowercase MIME types.
+    mime_types: Vec<String>,
+    lexer: &'static dyn Lexer,
+}
+
+/// Lexers registered at runtime, layered over the bundled ones.
+///
+/// Lookups check registered lexers first, so registering a language under a bundled name or alias ("go", "sh")
+/// replaces the bundled lexer for that name. Among registered lexers, names and aliases must be unique.
+///
+/// [Registry::global] is the registry [super::find] and [crate::highlight::detect_lexer] consult, which also makes
+/// registered languages available to Markdown fences and HTML `<script>` blocks. Separate registries made with
+/// [Registry::new] are independent of it and of each other. Registries can be shared between threads; registration
+/// takes a write lock that lookups wait for.
+///
+/// Registered lexers live for the rest of the process, which is what lets lookups hand out `'static` references that
+/// other lexers can embed.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::Lexer;
+/// use colorizer::highlight::lexers::{LexerConfig, Registry, Rule, RuleTable};
+/// use colorizer::highlight::TokenKind;
+///
+/// let registry = Registry::new();
+/// let rules = RuleTable::new().with_state("root", vec![
+///     Rule::new(r";.*", TokenKind::Comment),
+///     Rule::new(r"[^;]+", TokenKind::Text),
+/// ]);
+/// registry
+///     .register(LexerConfig::new("Notes").with_alias("note").with_filename("*.note").with_rules(rules))
+///     .unwrap();
+///
+/// assert_eq!(registry.get("NOTE").unwrap().name(), "Notes");
+/// assert_eq!(registry.match_filename("todo/today.note").unwrap().name(), "Notes");
+/// assert_eq!(registry.get("md").unwrap().name(), "Markdown");
+/// ```
+pub struct Registry {
+    entries: RwLock<Vec<Entry>>,
+}
+
+static GLOBAL: Registry = Registry::new();
+
+impl Registry {
+    /// Creates a registry with only the bundled lexers.
+    pub const fn new() -> Self {
+        Self { entries: RwLock::new(Vec::new()) }
+    }
+
+    /// The process-wide registry.
+    pub fn global() -> &'static Registry {
+        &GLOBAL
+    }
+
+    /// Adds a language, returning its lexer.
+    ///
+    /// Fails without changing the registry if a name or alias is already taken by another registered lexer, or if
+    /// the config's rule table doesn't compile.
+    pub fn register(&self, mut config: LexerConfig) -> Result<&'static dyn Lexer, RegistryError> {
+        if config.name.trim().is_empty() {
+            return Err(RegistryError::Invalid("a lexer needs a name".to_string()));
+        }
+        let mut aliases: Vec<String> = std::iter::once(&config.name)
+            .chain(&config.aliases)
+            .map(|alias| alias.to_lowercase())
+            .collect();
+        aliases.sort();
+        aliases.dedup();
+        let name = config.name.clone();
+        let filenames = std::mem::take(&mut config.filenames);
+        let mime_types = config.mime_types.iter().map(|mime| mime.to_lowercase()).collect();
+        let lexer = config.into_lexer()?;
+
+        let mut entries = self.entries.write().unwrap_or_else(|err| err.into_inner());
+        for alias in &aliases {
+            if let Some(entry) = entries.iter().find(|entry| entry.aliases.contains(alias)) {
+                return Err(RegistryError::Conflict { alias: alias.clone(), language: entry.name.clone() });
+            }
+        }
+        let lexer: &'static dyn Lexer = Box::leak(lexer);
+        entries.push(Entry { name, aliases, filenames, mime_types, lexer });
+        Ok(lexer)
+    }
+
+    /// Looks up a lexer by name or alias, ignoring case; registered lexers shadow bundled ones.
+    pub fn get(&self, name: &str) -> Option<&'static dyn Lexer> {
+        let key = name.to_lowercase();
+        let found = self
+            .read()
+            .iter()
+            .find(|entry| entry.aliases.contains(&key))
+            .map(|entry| entry.lexer);
+        found.or_else(|| bundled(name))
+    }
+
+    /// Finds the lexer for a file: registered file name patterns first (the most recently registered winning), then
+    /// the bundled file name and extension tables.
+    pub fn match_filename(&self, filename: &str) -> Option<&'static dyn Lexer> {
+        if let Some(lexer) = self.registered_filename(filename) {
+            return Some(lexer);
+        }
+        let detected = detect_language(filename, "").and_then(|detection| self.get(detection.language));
+        detected.or_else(|| {
+            Path::new(filename)
+                .extension()
+                .and_then(|ext| ext.to_str())
+                .and_then(|ext| self.get(ext))
+        })
+    }
+
+    /// Finds a registered lexer claiming `mime_type`, ignoring case and parameters such as `; charset=utf-8`.
+    pub fn match_mime_type(&self, mime_type: &str) -> Option<&'static dyn Lexer> {
+        let essence = mime_type.split(';').next().unwrap_or("").trim().to_lowercase();
+        self.read()
+            .iter()
+            .rev()
+            .find(|entry| entry.mime_types.contains(&essence))
+            .map(|entry| entry.lexer)
+    }
+
+    /// Names of every available language, registered and bundled, sorted and without duplicates.
+    pub fn names(&self) -> Vec<String> {
+        let mut names: Vec<String> = self.read().iter().map(|entry| entry.name.clone()).collect();
+        names.extend(BUNDLED.iter().map(|&(_, lexer)| lexer.name().to_string()));
+        #[cfg(feature = "grammars")]
+        names.extend(super::GrammarLexer::all().map(|lexer| lexer.name().to_string()));
+        names.sort_by_key(|name| name.to_lowercase());
+        names.dedup();
+        names
+    }
+
+    /// Finds a registered lexer whose file name patterns match `filename`.
+    pub(crate) fn registered_filename(&self, filename: &str) -> Option<&'static dyn Lexer> {
+        let path = filename.replace('\\', "/");
+        let name = path.rsplit('/').next().unwrap_or("");
+        self.read()
+            .iter()
+            .rev()
+            .find(|entry| {
+                entry.filenames.iter().any(|pattern| {
+                    let subject = if pattern.contains('/') { path.as_str() } else { name };
+                    glob_match(pattern, subject)
+                })
+            })
+            .map(|entry| entry.lexer)
+    }
+
+    fn read(&self) -> RwLockReadGuard<'_, Vec<Entry>> {
+        self.entries.read().unwrap_or_else(|err| err.into_inner())
+    }
+}
+
+impl Default for Registry {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+static MARKDOWN: Markdown = Markdown::new();
+static DIFF: Diff = Diff::new();
+static HTML: Html = Html::new();
+static SHELL: Shell = Shell;
+static GO: Go = Go;
+static PYTHON: Python = Python;
+static RUST: Rust = Rust;
+static YAML: Yaml = Yaml;
+static TOML: Toml = Toml;
+static SQL: Sql = Sql::new(SqlDialect::Ansi);
+static POSTGRES: Sql = Sql::new(SqlDialect::Postgres);
+static MYSQL: Sql = Sql::new(SqlDialect::MySql);
+static SQLITE: Sql = Sql::new(SqlDialect::Sqlite);
+static TSQL: Sql = Sql::new(SqlDialect::TSql);
+static DOCKERFILE: Dockerfile = Dockerfile::new();
+static TYPESCRIPT: TypeScript = TypeScript::new();
+static TSX: TypeScript = TypeScript::tsx();
+static JAVASCRIPT: JavaScript = JavaScript;
+static PROTOBUF: Protobuf = Protobuf;
+static GRAPHQL: GraphQL = GraphQL;
+static C_LEXER: C = C;
+static CPP: Cpp = Cpp;
+static INI: KeyValue = KeyValue::new(KeyValueDialect::Ini);
+static GIT_CONFIG: KeyValue = KeyValue::new(KeyValueDialect::GitConfig);
+static DOTENV: KeyValue = KeyValue::new(KeyValueDialect::Dotenv);
+static PROPERTIES: KeyValue = KeyValue::new(KeyValueDialect::Properties);
+
+/// Hand-written lexers by alias; they take precedence over grammars for the same language.
+static BUNDLED: &[(&str, &dyn Lexer)] = &[
+    ("markdown", &MARKDOWN),
+    ("md", &MARKDOWN),
+    ("diff", &DIFF),
+    ("patch", &DIFF),
+    ("html", &HTML),
+    ("htm", &HTML),
+    ("xhtml", &HTML),
+    ("bash", &SHELL),
+    ("sh", &SHELL),
+    ("shell", &SHELL),
+    ("zsh", &SHELL),
+    ("ksh", &SHELL),
+    ("dash", &SHELL),
+    ("go", &GO),
+    ("golang", &GO),
+    ("python", &PYTHON),
+    ("py", &PYTHON),
+    ("python3", &PYTHON),
+    ("rust", &RUST),
+    ("rs", &RUST),
+    ("yaml", &YAML),
+    ("yml", &YAML),
+    ("toml", &TOML),
+    ("sql", &SQL),
+    ("postgres", &POSTGRES),
+    ("postgresql", &POSTGRES),
+    ("psql", &POSTGRES),
+    ("mysql", &MYSQL),
+    ("mariadb", &MYSQL),
+    ("sqlite", &SQLITE),
+    ("sqlite3", &SQLITE),
+    ("tsql", &TSQL),
+    ("t-sql", &TSQL),
+    ("mssql", &TSQL),
+    ("dockerfile", &DOCKERFILE),
+    ("docker", &DOCKERFILE),
+    ("containerfile", &DOCKERFILE),
+    ("typescript", &TYPESCRIPT),
+    ("ts", &TYPESCRIPT),
+    ("mts", &TYPESCRIPT),
+    ("cts", &TYPESCRIPT),
+    ("tsx", &TSX),
+    ("javascript", &JAVASCRIPT),
+    ("js", &JAVASCRIPT),
+    ("mjs", &JAVASCRIPT),
+    ("cjs", &JAVASCRIPT),
+    ("jsx", &JAVASCRIPT),
+    ("protobuf", &PROTOBUF),
+    ("proto", &PROTOBUF),
+    ("proto3", &PROTOBUF),
+    ("graphql", &GRAPHQL),
+    ("gql", &GRAPHQL),
+    ("c", &C_LEXER),
+    ("h", &C_LEXER),
+    ("cpp", &CPP),
+    ("c++", &CPP),
+    ("cxx", &CPP),
+    ("cc", &CPP),
+    ("hpp", &CPP),
+    ("ini", &INI),
+    ("dosini", &INI),
+    ("cfg", &INI),
+    ("gitconfig", &GIT_CONFIG),
+    ("git-config", &GIT_CONFIG),
+    ("dotenv", &DOTENV),
+    ("env", &DOTENV),
+    ("properties", &PROPERTIES),
+    ("java-properties", &PROPERTIES),
+    ("jproperties", &PROPERTIES),
+];
+
+/// Looks up a bundled lexer by alias, ignoring case.
+fn bundled(name: &str) -> Option<&'static dyn Lexer> {
+    match BUNDLED.iter().find(|&&(alias, _)| alias.eq_ignore_ascii_case(name)) {
+        Some(&(_, lexer)) => Some(lexer),
+        None => grammar(name),
+    }
+}
+
+/// Looks up a bundled grammar by name or extension.
+#[cfg(feature = "grammars")]
+fn grammar(name: &str) -> Option<&'static dyn Lexer> {
+    super::GrammarLexer::find_static(name).map(|lexer| lexer as &'static dyn Lexer)
+}
+
+#[cfg(not(feature = "grammars"))]
+fn grammar(_name: &str) -> Option<&'static dyn Lexer> {
+    None
+}
+
+/// Matches `text` against a glob of literal characters, `*`, and `?`.
+pub(crate) fn glob_match(pattern: &str, text: &str) -> bool {
+    let pattern: Vec<char> = pattern.chars().collect();
+    let text: Vec<char> = text.chars().collect();
+    let (mut p, mut t) = (0, 0);
+    // Where the last `*` was and how much text it has absorbed, to backtrack to on a mismatch.
+    let mut star: Option<(usize, usize)> = None;
+    while t < text.len() {
+        match pattern.get(p) {
+            Some('*') => {
+                star = Some((p, t));
+                p += 1;
+            }
+            Some(&ch) if ch == '?' || ch == text[t] => {
+                p += 1;
+                t += 1;
+            }
+            _ => match star {
+                Some((star_p, star_t)) => {
+                    p = star_p + 1;
+                    t = star_t + 1;
+                    star = Some((star_p, star_t + 1));
+                }
+                None => return false,
+            },
+        }
+    }
+    pattern[p..].iter().all(|&ch| ch == '*')
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::{Rule, find};
+    use crate::highlight::{TokenKind, detect_lexer};
+
+    use rand::rngs::StdRng;
+    use rand::seq::IndexedRandom;
+    use rand::{Rng, SeedableRng};
+    use std::sync::mpsc;
+    use std::thread;
+    use std::time::Duration;
+
+    /// A fixed-output lexer, to tell registered lexers apart from bundled ones.
+    struct Named(&'static str);
+
+    impl Lexer for Named {
+        fn name(&self) -> &str {
+            self.0
+        }
+
+        fn start(&self) -> Box<dyn super::super::LexerState + '_> {
+            Box::new(super::super::PlainText)
+        }
+    }
+
+    fn rejection(registry: &Registry, config: LexerConfig) -> RegistryError {
+        match registry.register(config) {
+            Ok(lexer) => panic!("registered {}", lexer.name()),
+            Err(err) => err,
+        }
+    }
+
+    fn rules() -> RuleTable {
+        RuleTable::new().with_state("root", vec![Rule::new(r"[\s\S]+", TokenKind::Text)])
+    }
+
+    #[test]
+    fn registered_lexers_are_found_by_any_alias() {
+        let registry = Registry::new();
+        let lexer = registry
+            .register(
+                LexerConfig::new("Units")
+                    .with_alias("unit")
+                    .with_alias("UNITS")
+                    .with_rules(rules()),
+            )
+            .unwrap();
+        assert_eq!(lexer.name(), "Units");
+        for name in ["units", "Units", "UNIT", "unit"] {
+            assert_eq!(registry.get(name).map(|lexer| lexer.name()), Some("Units"), "{name}");
+        }
+        assert!(registry.get("unitz").is_none());
+        assert!(Registry::new().get("units").is_none(), "registries are independent");
+    }
+
+    #[test]
+    fn registered_lexers_override_bundled_ones() {
+        let registry = Registry::new();
+        assert_eq!(registry.get("bash").unwrap().name(), "Shell");
+        registry
+            .register(
+                LexerConfig::new("My Shell")
+                    .with_alias("bash")
+                    .with_lexer(Named("My Shell")),
+            )
+            .unwrap();
+        assert_eq!(registry.get("Bash").unwrap().name(), "My Shell");
+        // Detection maps `.sh` to "bash", so the override applies to file names too.
+        assert_eq!(registry.match_filename("deploy.sh").unwrap().name(), "My Shell");
+        assert_eq!(registry.get("zsh").unwrap().name(), "Shell");
+    }
+
+    #[test]
+    fn alias_collisions_are_rejected() {
+        let registry = Registry::new();
+        registry
+            .register(LexerConfig::new("Units").with_alias("u").with_rules(rules()))
+            .unwrap();
+        let err = rejection(
+            &registry,
+            LexerConfig::new("Uniforms").with_alias("U").with_rules(rules()),
+        );
+        assert_eq!(
+            err.to_string(),
+            "failed to register lexer: \"u\" is already registered to Units"
+        );
+        assert!(registry.get("uniforms").is_none(), "a failed registration adds nothing");
+
+        let err = rejection(&registry, LexerConfig::new("Empty"));
+        assert_eq!(
+            err.to_string(),
+            "failed to register lexer: Empty has neither rules nor a lexer"
+        );
+        let err = rejection(&registry, LexerConfig::new("Broken").with_rules(RuleTable::new()));
+        assert!(matches!(err, RegistryError::Rules(_)), "{err}");
+    }
+
+    #[test]
+    fn filenames_and_mime_types_select_lexers() {
+        let registry = Registry::new();
+        registry
+            .register(
+                LexerConfig::new("Units")
+                    .with_filename("*.units")
+                    .with_filename("Unitfile")
+                    .with_filename("conf/units-*.txt")
+                    .with_mime_type("text/x-units")
+                    .with_rules(rules()),
+            )
+            .unwrap();
+        for filename in [
+            "a.units",
+            "/srv/app/Unitfile",
+            "conf/units-metric.txt",
+            "C:\\work\\b.units",
+        ] {
+            assert_eq!(
+                registry.match_filename(filename).map(|lexer| lexer.name()),
+                Some("Units"),
+                "{filename}"
+            );
+        }
+        assert!(registry.registered_filename("units-metric.txt").is_none());
+        assert!(registry.registered_filename("a.units.bak").is_none());
+        assert_eq!(registry.match_filename("README.md").unwrap().name(), "Markdown");
+        assert!(registry.match_filename("notes").is_none());
+
+        assert_eq!(
+            registry.match_mime_type("Text/X-Units; charset=utf-8").unwrap().name(),
+            "Units"
+        );
+        assert!(registry.match_mime_type("text/plain").is_none());
+    }
+
+    #[test]
+    fn names_list_registered_and_bundled_languages() {
+        let registry = Registry::new();
+        registry
+            .register(LexerConfig::new("Units").with_rules(rules()))
+            .unwrap();
+        let names = registry.names();
+        for name in ["Units", "Markdown", "Shell"] {
+            assert!(names.iter().any(|known| known == name), "{name} missing from {names:?}");
+        }
+        let mut sorted = names.clone();
+        sorted.sort_by_key(|name| name.to_lowercase());
+        assert_eq!(names, sorted);
+    }
+
+    #[test]
+    fn concurrent_registration_and_lookup() {
+        let registry = Registry::new();
+        std::thread::scope(|scope| {
+            for i in 0..8 {
+                let registry = &registry;
+                scope.spawn(move || {
+                    let name = format!("lang{i}");
+                    registry
+                        .register(
+                            LexerConfig::new(&name)
+                                .with_alias("shared-alias-attempt")
+                                .with_rules(rules()),
+                        )
+                        .ok();
+                    registry
+                        .register(LexerConfig::new(&format!("{name}-b")).with_rules(rules()))
+                        .unwrap();
+                    assert!(registry.get(&format!("{name}-b")).is_some());
+                });
+            }
+        });
+        // Exactly one registration won the shared alias.
+        let winners = (0..8).filter(|i| registry.get(&format!("lang{i}")).is_some()).count();
+        assert_eq!(winners, 1);
+        assert!(registry.get("shared-alias-attempt").is_some());
+    }
+
+    #[test]
+    fn the_global_registry_feeds_find_and_detection() {
+        Registry::global()
+            .register(
+                LexerConfig::new("Registry Test Language")
+                    .with_alias("registry-test-language")
+                    .with_filename("*.registry-test")
+                    .with_lexer(Named("Registry Test Language")),
+            )
+            .unwrap();
+        assert_eq!(find("registry-test-language").unwrap().name(), "Registry Test Language");
+        let (lexer, confidence) = detect_lexer("x.registry-test", "");
+        assert_eq!((lexer.name(), confidence), ("Registry Test Language", 1.0));
+    }
+
+    /// Lexes `src` on another thread, failing if that takes longer than a generous bound or the tokens don't tile it.
+    fn assert_progresses(lexer: &'static dyn Lexer, src: String) {
+        let (send, recv) = mpsc::channel();
+        let name = lexer.name().to_string();
+        let input = src.clone();
+        thread::spawn(move || {
+            let _ = send.send(lexer.tokenize(&input));
+        });
+        let tokens = match recv.recv_timeout(Duration::from_secs(10)) {
+            Ok(result) => result.unwrap_or_else(|err| panic!("{name}: {err}")),
+            Err(mpsc::RecvTimeoutError::Timeout) => panic!("{name} did not finish lexing {src:?}"),
+            Err(mpsc::RecvTimeoutError::Disconnected) => panic!("{name} panicked lexing {src:?}"),
+        };
+        let mut at = 0;
+        for token in &tokens {
+            assert!(
+                token.start == at && token.end > at,
+                "{name}: {token:?} after byte {at} of {src:?}"
+            );
+            at = token.end;
+        }
+        assert_eq!(at, src.len(), "{name}: {src:?}");
+    }
+
+    #[test]
+    fn every_bundled_lexer_finishes_random_bytes() {
+        // An empty match that pushes and another that pops would spin in place without the lexer's guard.
+        let table = RuleTable::new()
+            .with_state(
+                "root",
+                vec![Rule::new(r"[a-z]+", TokenKind::Name), Rule::default_to("inner")],
+            )
+            .with_state("inner", vec![Rule::new("", TokenKind::Text).with_pop(1)]);
+        let spinning: &'static dyn Lexer = Box::leak(Box::new(RuleLexer::new("Spinning", &table).unwrap()));
+        let lexers = BUNDLED.iter().map(|&(_, lexer)| lexer).chain([spinning]);
+
+        let mut rng = StdRng::seed_from_u64(47);
+        let punctuation = b"\"'`#/*-+<>{}[]()$@!\\\n\r\t ;:=.,0x9e_az";
+        for lexer in lexers {
+            let bytes: Vec<u8> = (0..1024).map(|_| rng.random()).collect();
+            assert_progresses(lexer, String::from_utf8_lossy(&bytes).into_owned());
+            // Mostly punctuation opens and closes strings, comments, and brackets far more often than noise does.
+            let ascii: Vec<u8> = (0..1024).map(|_| *punctuation.choose(&mut rng).unwrap()).collect();
+            assert_progresses(lexer, String::from_utf8(ascii).unwrap());
+        }
+    }
+
+    #[test]
+    fn globs_match_stars_and_question_marks() {
+        assert!(glob_match("*.rs", "main.rs"));
+        assert!(glob_match("*.rs", ".rs"));
+        assert!(!glob_match("*.rs", "main.rsx"));
+        assert!(glob_match("Make?ile", "Makefile"));
+        assert!(glob_match("*a*b*", "xxaxxbxx"));
+        assert!(!glob_match("*a*b", "xxaxxbxxc"));
+        assert!(glob_match("*", ""));
+        assert!(glob_match("ü?.txt", "üé.txt"));
+    }
+}
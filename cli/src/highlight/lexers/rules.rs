@@ -0,0 +1,1294 @@
+//! Lexers defined by tables of regular-expression rules, in the style of Pygments' `RegexLexer`.
+
+use super::{Lexer, LexerState, delimited_end, same_state};
+use crate::highlight::token::push_token;
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use serde::{Deserialize, Deserializer, Serialize};
+use std::any::Any;
+use std::collections::BTreeMap;
+use std::fmt;
+use std::io::Write;
+use std::ops::Range;
+use std::sync::{Arc, Mutex};
+use syntect::parsing::{Regex, Region};
+
+/// Name of the state every document starts in.
+pub const ROOT: &str = "root";
+
+/// Consecutive empty matches allowed at one position before the lexer gives up on it, which stops rules that push and
+/// pop without consuming anything from looping forever.
+const MAX_EMPTY_MATCHES: usize = 64;
+
+/// Error raised when a [RuleTable] doesn't compile into a [RuleLexer].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RuleError(String);
+
+impl fmt::Display for RuleError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "invalid lexer rules: {}", self.0)
+    }
+}
+
+impl RuleError {
+    pub(crate) fn new(message: String) -> Self {
+        Self(message)
+    }
+}
+
+impl std::error::Error for RuleError {}
+
+/// Named states, each an ordered list of [Rule]s; lexing starts in [ROOT].
+///
+/// Tables deserialize from a map of state names to rule lists, so they can be loaded from JSON, YAML, or TOML. The
+/// JSON for a small string-aware lexer looks like this:
+///
+/// ```json
+/// {
+///   "root": [
+///     { "match": "#.*", "token": "Comment" },
+///     { "match": "([a-z_]+)(\\s*)(=)", "groups": ["NameAttribute", "Whitespace", "Operator"] },
+///     { "match": "\"", "token": "String", "push": "string" },
+///     { "match": "\\s+", "token": "Whitespace" }
+///   ],
+///   "string": [
+///     { "match": "\\\\.", "token": "StringEscape" },
+///     { "match": "\"", "token": "String", "pop": 1 },
+///     { "match": "[^\"\\\\\\n]+", "token": "String" }
+///   ]
+/// }
+/// ```
+///
+/// Each rule has these fields:
+///
+/// - `match`: an Oniguruma regular expression, tried at the current position only (never searched for further along
+///   the line). Patterns see one line at a time, including its line ending, so a match never spans lines; constructs
+///   that do are written as states that stay on the stack from one line to the next.
+/// - `token`: the [TokenKind::name] for the whole match, [TokenKind::Text] when omitted.
+/// - `groups`: kinds for the capture groups, in order; text between and around the groups gets `token`.
+/// - `pop`: how many states to leave after the match. The root state is never popped.
+/// - `push`: a state name (or a list of them) to enter after the match, and after popping.
+/// - `include`: the name of another state whose rules are spliced in at this point, instead of `match`.
+/// - `close`: makes the rule a delimited construct, such as a block comment, that runs from its match to the next
+///   `close` as `token` whatever the state's other rules say, across as many lines as it takes. `\1` to `\9` in
+///   `close` stand for the text of the match's capture groups, which is how Lua's long brackets pair `[==[` with
+///   `]==]` only.
+/// - `open`: instead of `match`, a literal opening delimiter for a `close` rule; every `open` inside the construct
+///   needs a `close` of its own, so `{ "open": "(*", "close": "*)", "token": "Comment" }` nests like OCaml comments.
+/// - `prevToken`: a condition on the last token before the match on the same line, other than whitespace, for words
+///   that are keywords only in some positions. `kind` lists the kinds it may have (a kind also admits the kinds
+///   under it, so `Keyword` admits `KeywordType`), `text` the texts it may have, and `lineStart: true` lets the rule
+///   match when there is no such token. The rule is skipped when the condition fails.
+/// - `lookahead`: a pattern that must match right after the match, without being consumed, or the rule is skipped.
+///
+/// Together these handle soft keywords. Python's `match` starts a statement only at the start of a line and before
+/// an operand, so `match = re.match(text)` is two plain names:
+///
+/// ```json
+/// {
+///   "match": "match\\b",
+///   "token": "Keyword",
+///   "prevToken": { "lineStart": true },
+///   "lookahead": "[ \\t]+[\\w(\\[{\"'-]"
+/// }
+/// ```
+///
+/// A construct still open at the end of the input is not an error: what was read of it keeps its kind, since partial
+/// buffers are highlighted all the time.
+///
+/// Rules in the current state are tried in order and the first match wins. A rule may match the empty string only if
+/// it pushes or pops, which makes `{ "match": "", "pop": 1 }` a fallback that leaves a state. Text no rule matches
+/// becomes one-character [TokenKind::Error] tokens, or [TokenKind::Whitespace] for line endings, and lexing carries on
+/// in the same state.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(transparent)]
+pub struct RuleTable {
+    states: BTreeMap<String, Vec<Rule>>,
+}
+
+impl RuleTable {
+    /// Creates an empty table.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Adds a state, replacing any earlier state with the same name.
+    pub fn with_state(mut self, name: &str, rules: Vec<Rule>) -> Self {
+        self.states.insert(name.to_string(), rules);
+        self
+    }
+}
+
+/// One entry in a [RuleTable] state; see there for the fields' meaning.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct Rule {
+    #[serde(rename = "match", default, skip_serializing_if = "String::is_empty")]
+    pattern: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    token: Option<TokenKind>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    groups: Vec<TokenKind>,
+    #[serde(default, skip_serializing_if = "is_zero")]
+    pop: usize,
+    #[serde(default, deserialize_with = "one_or_many", skip_serializing_if = "Vec::is_empty")]
+    push: Vec<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    include: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    open: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    close: Option<String>,
+    #[serde(rename = "prevToken", default, skip_serializing_if = "Option::is_none")]
+    prev_token: Option<PrevToken>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    lookahead: Option<String>,
+}
+
+impl Rule {
+    /// Matches `pattern` as a single token of `kind`.
+    pub fn new(pattern: &str, kind: TokenKind) -> Self {
+        Self { pattern: pattern.to_string(), token: Some(kind), ..Self::default() }
+    }
+
+    /// Matches `pattern` and gives its capture groups the kinds in `groups`, in order.
+    pub fn by_groups(pattern: &str, groups: &[TokenKind]) -> Self {
+        Self { pattern: pattern.to_string(), groups: groups.to_vec(), ..Self::default() }
+    }
+
+    /// Splices in the rules of `state`.
+    pub fn include(state: &str) -> Self {
+        Self { include: Some(state.to_string()), ..Self::default() }
+    }
+
+    /// Matches from the literal `open` to the `close` that pairs with it as one run of `kind`, counting the `open`s in
+    /// between, so `Rule::nested("{-", "-}", TokenKind::Comment)` reads Haskell's nested comments.
+    pub fn nested(open: &str, close: &str, kind: TokenKind) -> Self {
+        Self { open: Some(open.to_string()), close: Some(close.to_string()), token: Some(kind), ..Self::default() }
+    }
+
+    /// Matches `pattern` and everything after it up to `close` as one run of `kind`, where `\1` to `\9` in `close`
+    /// stand for the pattern's capture groups; `Rule::delimited(r"--\[(=*)\[", r"]\1]", TokenKind::Comment)`
+    /// reads Lua's long comments.
+    pub fn delimited(pattern: &str, close: &str, kind: TokenKind) -> Self {
+        Self { pattern: pattern.to_string(), close: Some(close.to_string()), token: Some(kind), ..Self::default() }
+    }
+
+    /// Matches the empty string and moves to `state`, for a state's fallback rule.
+    pub fn default_to(state: &str) -> Self {
+        Self::default().with_push(state)
+    }
+
+    /// Enters `state` after matching; call it again to push several states, the last one ending up on top.
+    pub fn with_push(mut self, state: &str) -> Self {
+        self.push.push(state.to_string());
+        self
+    }
+
+    /// Leaves `count` states after matching, before any pushes.
+    pub fn with_pop(mut self, count: usize) -> Self {
+        self.pop = count;
+        self
+    }
+
+    /// Kind for the text around the capture groups of a [Rule::by_groups] rule.
+    pub fn with_token(mut self, kind: TokenKind) -> Self {
+        self.token = Some(kind);
+        self
+    }
+
+    /// Matches only where the token before the match satisfies `prev`.
+    pub fn with_prev_token(mut self, prev: PrevToken) -> Self {
+        self.prev_token = Some(prev);
+        self
+    }
+
+    /// Matches only where `pattern` matches right after the match.
+    pub fn with_lookahead(mut self, pattern: &str) -> Self {
+        self.lookahead = Some(pattern.to_string());
+        self
+    }
+
+    /// Replaces the capture group kinds, for importers that read a rule's parts one at a time.
+    pub(crate) fn with_groups(mut self, groups: &[TokenKind]) -> Self {
+        self.groups = groups.to_vec();
+        self
+    }
+
+    fn moves(&self) -> bool {
+        self.pop > 0 || !self.push.is_empty()
+    }
+}
+
+/// A [Rule]'s condition on the token before its match: the last one on the line that isn't whitespace.
+///
+/// That token is the one the previous match produced, before tokens of the same kind merge, so `PrevToken::text(":")`
+/// allows a match after `):` when `)` and `:` were matched separately.
+///
+/// A token satisfies it when it has one of the kinds, or a kind under one of them, and one of the texts; an empty
+/// list allows any. [PrevToken::line_start] also allows, or on its own only allows, the match to have no such token.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::lexers::{PrevToken, Rule, RuleLexer, RuleTable};
+/// use colorizer::highlight::{Lexer, TokenKind};
+///
+/// // `as` is a keyword only after a name, as in `import a as b`.
+/// let table = RuleTable::new().with_state("root", vec![
+///     Rule::new(r"as\b", TokenKind::Keyword).with_prev_token(PrevToken::kind(TokenKind::Name)),
+///     Rule::new(r"\w+", TokenKind::Name),
+///     Rule::new(r"\s+", TokenKind::Whitespace),
+/// ]);
+/// let lexer = RuleLexer::new("Imports", &table).unwrap();
+/// let src = "as as as\n";
+/// let keywords: Vec<&str> = lexer.tokenize(src).unwrap().iter()
+///     .filter(|token| token.kind == TokenKind::Keyword)
+///     .map(|token| token.text(src))
+///     .collect();
+/// assert_eq!(keywords, ["as"]);
+/// ```
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct PrevToken {
+    #[serde(default, deserialize_with = "one_or_many", skip_serializing_if = "Vec::is_empty")]
+    kind: Vec<TokenKind>,
+    #[serde(default, deserialize_with = "one_or_many", skip_serializing_if = "Vec::is_empty")]
+    text: Vec<String>,
+    #[serde(rename = "lineStart", default, skip_serializing_if = "is_false")]
+    line_start: bool,
+}
+
+impl PrevToken {
+    /// Requires that nothing but whitespace comes before the match on its line.
+    pub fn line_start() -> Self {
+        Self { line_start: true, ..Self::default() }
+    }
+
+    /// Requires a token of `kind`, or of a kind under it.
+    pub fn kind(kind: TokenKind) -> Self {
+        Self::default().or_kind(kind)
+    }
+
+    /// Requires a token whose text is `text`.
+    pub fn text(text: &str) -> Self {
+        Self::default().or_text(text)
+    }
+
+    /// Also allows tokens of `kind`.
+    pub fn or_kind(mut self, kind: TokenKind) -> Self {
+        self.kind.push(kind);
+        self
+    }
+
+    /// Also allows tokens whose text is `text`.
+    pub fn or_text(mut self, text: &str) -> Self {
+        self.text.push(text.to_string());
+        self
+    }
+
+    /// Also allows the match to start its line.
+    pub fn or_line_start(mut self) -> Self {
+        self.line_start = true;
+        self
+    }
+
+    /// Whether `prev`, the kind and text of the token before a match, satisfies the condition.
+    fn allows(&self, prev: Option<(TokenKind, &str)>) -> bool {
+        let Some((kind, text)) = prev else { return self.line_start };
+        if self.line_start && self.kind.is_empty() && self.text.is_empty() {
+            return false;
+        }
+        let kind_matches = self.kind.is_empty()
+            || std::iter::successors(Some(kind), TokenKind::parent).any(|kind| self.kind.contains(&kind));
+        kind_matches && (self.text.is_empty() || self.text.iter().any(|allowed| allowed == text))
+    }
+}
+
+fn is_zero(count: &usize) -> bool {
+    *count == 0
+}
+
+fn is_false(flag: &bool) -> bool {
+    !*flag
+}
+
+/// Accepts `"push": "state"` as shorthand for `"push": ["state"]`, and likewise for the other lists.
+fn one_or_many<'de, D: Deserializer<'de>, T: Deserialize<'de>>(deserializer: D) -> Result<Vec<T>, D::Error> {
+    #[derive(Deserialize)]
+    #[serde(untagged)]
+    enum OneOrMany<T> {
+        One(T),
+        Many(Vec<T>),
+    }
+    Ok(match OneOrMany::deserialize(deserializer)? {
+        OneOrMany::One(state) => vec![state],
+        OneOrMany::Many(states) => states,
+    })
+}
+
+/// A rule with its pattern compiled and its state names resolved to indices.
+#[derive(Debug)]
+struct CompiledRule {
+    regex: Regex,
+    token: TokenKind,
+    groups: Vec<TokenKind>,
+    moves: bool,
+    pop: usize,
+    push: Vec<usize>,
+    /// For a delimited construct, the `open` that nests and the `close` that ends it, with group references.
+    open: Option<String>,
+    close: Option<String>,
+    /// The state the rule is written in, which differs from the one it's tried in when it was included, and its
+    /// index there.
+    origin: (usize, usize),
+    prev_token: Option<PrevToken>,
+    lookahead: Option<Regex>,
+}
+
+impl CompiledRule {
+    /// Emits the tokens for a match of this rule spanning `start..end` of the line.
+    fn emit(
+        &self, region: &Region, start: usize, end: usize, offset: usize, tokens: &mut Vec<Token>, last: &mut LastMatch,
+    ) {
+        let mut piece = |kind, start, end| {
+            push_token(tokens, kind, offset + start, offset + end);
+            note_match(last, kind, offset + start..offset + end);
+        };
+        let mut cursor = start;
+        for (index, &kind) in self.groups.iter().enumerate() {
+            // Groups that didn't participate, or that nest inside an earlier one, have nothing left to claim.
+            let Some((group_start, group_end)) = region.pos(index + 1) else { continue };
+            if group_start < cursor {
+                continue;
+            }
+            piece(self.token, cursor, group_start);
+            piece(kind, group_start, group_end);
+            cursor = group_end;
+        }
+        piece(self.token, cursor, end);
+    }
+
+    /// The closing delimiter for a match of this rule, with its group references filled in from `region`.
+    fn closing(&self, close: &str, region: &Region, line: &str) -> String {
+        let mut closing = String::with_capacity(close.len());
+        let mut chars = close.chars();
+        while let Some(ch) = chars.next() {
+            if ch != '\\' {
+                closing.push(ch);
+                continue;
+            }
+            match chars.next() {
+                Some(digit @ '1'..='9') => {
+                    let group = digit as usize - '0' as usize;
+                    if let Some((start, end)) = region.pos(group) {
+                        closing.push_str(&line[start..end]);
+                    }
+                }
+                Some(other) => closing.push(other),
+                None => closing.push('\\'),
+            }
+        }
+        closing
+    }
+}
+
+/// Lexer driven by a [RuleTable].
+///
+/// The table is compiled once; states share it, so starting a document or taking a snapshot only copies the stack of
+/// state indices.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::lexers::{Rule, RuleLexer, RuleTable};
+/// use colorizer::highlight::{Lexer, TokenKind};
+///
+/// let table = RuleTable::new()
+///     .with_state("root", vec![
+///         Rule::new(r"\b(?:let|in)\b", TokenKind::Keyword),
+///         Rule::new(r"'", TokenKind::String).with_push("string"),
+///         Rule::new(r"\s+", TokenKind::Whitespace),
+///         Rule::new(r"\w+", TokenKind::Name),
+///     ])
+///     .with_state("string", vec![
+///         Rule::new(r"'", TokenKind::String).with_pop(1),
+///         Rule::new(r"[^'\n]+", TokenKind::String),
+///     ]);
+/// let lexer = RuleLexer::new("Let", &table).unwrap();
+///
+/// let src = "let x = 'a b' in x\n";
+/// let tokens = lexer.tokenize(src).unwrap();
+/// assert_eq!(tokens[0].kind, TokenKind::Keyword);
+/// assert!(tokens.iter().any(|token| token.kind == TokenKind::String && token.text(src) == "'a b'"));
+/// ```
+#[derive(Debug, Clone)]
+pub struct RuleLexer {
+    name: String,
+    states: Arc<[Vec<CompiledRule>]>,
+    /// State names by index.
+    names: Arc<[String]>,
+    trace: Option<Arc<Trace>>,
+}
+
+impl RuleLexer {
+    /// Compiles `table`, reporting the first bad pattern, unknown state name, or include cycle.
+    pub fn new(name: &str, table: &RuleTable) -> Result<Self, RuleError> {
+        if !table.states.contains_key(ROOT) {
+            return Err(RuleError(format!("missing the {ROOT:?} state")));
+        }
+        // The root state goes first so a fresh stack is just index 0.
+        let names: Vec<&str> = std::iter::once(ROOT)
+            .chain(table.states.keys().map(String::as_str).filter(|&name| name != ROOT))
+            .collect();
+        let index_of = |state: &str, target: &str| {
+            names
+                .iter()
+                .position(|&name| name == target)
+                .ok_or_else(|| RuleError(format!("state {state:?}: unknown state {target:?}")))
+        };
+
+        let mut states = Vec::with_capacity(names.len());
+        for &state in &names {
+            let mut rules = Vec::new();
+            for (origin, index, rule) in flatten(table, state, &mut Vec::new())? {
+                if let Some(err) = delimiter_error(rule) {
+                    return Err(RuleError(format!("state {state:?}: {err}")));
+                }
+                let pattern = match &rule.open {
+                    Some(open) => escape(open),
+                    None => rule.pattern.clone(),
+                };
+                if pattern.is_empty() && !rule.moves() {
+                    return Err(RuleError(format!(
+                        "state {state:?}: a rule with no pattern must push or pop"
+                    )));
+                }
+                let source = format!("\\G(?:{pattern})");
+                if let Some(err) = Regex::try_compile(&source) {
+                    return Err(RuleError(format!(
+                        "state {state:?}: bad pattern {:?}: {err}",
+                        rule.pattern
+                    )));
+                }
+                let lookahead = match &rule.lookahead {
+                    Some(lookahead) => {
+                        let source = format!("\\G(?:{lookahead})");
+                        if let Some(err) = Regex::try_compile(&source) {
+                            return Err(RuleError(format!(
+                                "state {state:?}: bad lookahead {lookahead:?}: {err}"
+                            )));
+                        }
+                        Some(Regex::new(source))
+                    }
+                    None => None,
+                };
+                rules.push(CompiledRule {
+                    regex: Regex::new(source),
+                    token: rule.token.unwrap_or(TokenKind::Text),
+                    groups: rule.groups.clone(),
+                    moves: rule.moves(),
+                    pop: rule.pop,
+                    push: rule
+                        .push
+                        .iter()
+                        .map(|target| index_of(state, target))
+                        .collect::<Result<_, _>>()?,
+                    open: rule.open.clone(),
+                    close: rule.close.clone(),
+                    origin: (index_of(state, origin)?, index),
+                    prev_token: rule.prev_token.clone(),
+                    lookahead,
+                });
+            }
+            states.push(rules);
+        }
+        Ok(Self {
+            name: name.to_string(),
+            states: states.into(),
+            names: names.iter().map(|&name| name.to_string()).collect(),
+            trace: None,
+        })
+    }
+
+    /// Logs every step the lexer takes to `writer`, one line each, to debug a table that loops or whose rules match
+    /// in the wrong order.
+    ///
+    /// A line gives the byte range consumed, the current state, the rule that matched as `state[index]` in the table
+    /// (the state it's written in, which differs from the current one for included rules), and the text it consumed;
+    /// a rule that pushes or pops adds the stack it leaves, innermost last. Text no rule matches is logged as such,
+    /// and so is a position abandoned after too many empty matches. Write errors are ignored.
+    ///
+    /// Without a trace, lexing only checks that there is none; nothing is formatted or written.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use colorizer::highlight::lexers::{Rule, RuleLexer, RuleTable};
+    /// use colorizer::highlight::{Lexer, TokenKind};
+    /// use std::io::{self, Write};
+    /// use std::sync::{Arc, Mutex};
+    ///
+    /// #[derive(Clone, Default)]
+    /// struct Log(Arc<Mutex<Vec<u8>>>);
+    ///
+    /// impl Write for Log {
+    ///     fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+    ///         self.0.lock().unwrap().write(buf)
+    ///     }
+    ///     fn flush(&mut self) -> io::Result<()> {
+    ///         Ok(())
+    ///     }
+    /// }
+    ///
+    /// let table = RuleTable::new().with_state("root", vec![
+    ///     Rule::new(r"\w+", TokenKind::Name),
+    ///     Rule::new(r" ", TokenKind::Whitespace),
+    /// ]);
+    /// let log = Log::default();
+    /// let lexer = RuleLexer::new("Words", &table).unwrap().with_trace(log.clone());
+    /// lexer.tokenize("hi @\n").unwrap();
+    ///
+    /// let trace = String::from_utf8(log.0.lock().unwrap().clone()).unwrap();
+    /// assert_eq!(
+    ///     trace.lines().collect::<Vec<_>>(),
+    ///     [
+    ///         r#"0..2 root: root[0] "hi""#,
+    ///         r#"2..3 root: root[1] " ""#,
+    ///         r#"3..4 root: no rule matches "@""#,
+    ///         r#"4..5 root: no rule matches "\n""#,
+    ///     ]
+    /// );
+    /// ```
+    pub fn with_trace(mut self, writer: impl Write + Send + 'static) -> Self {
+        self.trace = Some(Arc::new(Trace {
+            names: Arc::clone(&self.names),
+            writer: Mutex::new(Box::new(writer)),
+        }));
+        self
+    }
+}
+
+/// Where a traced [RuleLexer] logs its steps.
+struct Trace {
+    names: Arc<[String]>,
+    writer: Mutex<Box<dyn Write + Send>>,
+}
+
+impl Trace {
+    fn log(&self, args: fmt::Arguments) {
+        let mut writer = self.writer.lock().unwrap_or_else(|err| err.into_inner());
+        let _ = writeln!(writer, "{args}");
+    }
+
+    /// Logs a match of `rule` in state `state`, with the stack it left if it moved.
+    fn matched(&self, at: Range<usize>, state: usize, rule: &CompiledRule, text: &str, stack: &[usize]) {
+        let names = &self.names;
+        let (origin, index) = rule.origin;
+        let (state, origin) = (&names[state], &names[origin]);
+        if rule.moves {
+            let stack: Vec<&str> = stack.iter().map(|&state| names[state].as_str()).collect();
+            self.log(format_args!(
+                "{at:?} {state}: {origin}[{index}] {text:?} -> {}",
+                stack.join("/")
+            ));
+        } else {
+            self.log(format_args!("{at:?} {state}: {origin}[{index}] {text:?}"));
+        }
+    }
+}
+
+impl fmt::Debug for Trace {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.debug_struct("Trace").finish_non_exhaustive()
+    }
+}
+
+/// Explains what's wrong with the `open` and `close` of `rule`, if anything.
+fn delimiter_error(rule: &Rule) -> Option<&'static str> {
+    match (&rule.open, &rule.close) {
+        (Some(_), None) => Some("a rule with `open` needs a `close`"),
+        (Some(_), Some(_)) if !rule.pattern.is_empty() => Some("a rule can't have both `match` and `open`"),
+        (Some(open), Some(_)) if open.is_empty() => Some("a rule's `open` can't be empty"),
+        (_, Some(close)) if close.is_empty() => Some("a rule's `close` can't be empty"),
+        (_, Some(_)) if rule.moves() => Some("a rule with `close` can't push or pop"),
+        _ => None,
+    }
+}
+
+/// Escapes `text` so that a pattern matches it literally.
+fn escape(text: &str) -> String {
+    let mut escaped = String::with_capacity(text.len());
+    for ch in text.chars() {
+        if "\\.^$|?*+()[]{}".contains(ch) {
+            escaped.push('\\');
+        }
+        escaped.push(ch);
+    }
+    escaped
+}
+
+/// The rules of `state` with includes expanded, in the order they are tried, each with the state it's written in and
+/// its index there.
+fn flatten<'t>(
+    table: &'t RuleTable, state: &str, visiting: &mut Vec<String>,
+) -> Result<Vec<(&'t str, usize, &'t Rule)>, RuleError> {
+    if visiting.iter().any(|seen| seen == state) {
+        return Err(RuleError(format!(
+            "state {:?}: includes {state:?} in a cycle",
+            visiting[0]
+        )));
+    }
+    let Some((state, rules)) = table.states.get_key_value(state) else {
+        let from = visiting.last().map_or(ROOT, String::as_str);
+        return Err(RuleError(format!("state {from:?}: unknown state {state:?}")));
+    };
+    visiting.push(state.to_string());
+    let mut flat = Vec::with_capacity(rules.len());
+    for (index, rule) in rules.iter().enumerate() {
+        match &rule.include {
+            Some(included) => flat.extend(flatten(table, included, visiting)?),
+            None => flat.push((state.as_str(), index, rule)),
+        }
+    }
+    visiting.pop();
+    Ok(flat)
+}
+
+impl Lexer for RuleLexer {
+    fn name(&self) -> &str {
+        &self.name
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(RuleState {
+            states: Arc::clone(&self.states),
+            stack: vec![0],
+            inside: None,
+            last: None,
+            trace: self.trace.clone(),
+        })
+    }
+}
+
+/// The shared rules and the stack of states entered so far, innermost last.
+#[derive(Clone)]
+struct RuleState {
+    states: Arc<[Vec<CompiledRule>]>,
+    stack: Vec<usize>,
+    /// The delimited construct being read, if any.
+    inside: Option<Inside>,
+    last: LastMatch,
+    trace: Option<Arc<Trace>>,
+}
+
+/// Kind and document span of the last token a match produced that isn't whitespace, which [PrevToken] conditions
+/// test. Tokens of one kind merge as they are pushed, so the token list can't tell `)` then `:` from `):`.
+type LastMatch = Option<(TokenKind, Range<usize>)>;
+
+/// Records a token of `kind` over `span` as the last match, unless it is whitespace or empty.
+fn note_match(last: &mut LastMatch, kind: TokenKind, span: Range<usize>) {
+    if kind != TokenKind::Whitespace && !span.is_empty() {
+        *last = Some((kind, span));
+    }
+}
+
+impl PartialEq for RuleState {
+    fn eq(&self, other: &Self) -> bool {
+        Arc::ptr_eq(&self.states, &other.states) && self.stack == other.stack && self.inside == other.inside
+    }
+}
+
+/// A delimited construct left open: the rule that opened it, the closing delimiter it waits for, and how many levels
+/// of it are open.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Inside {
+    state: usize,
+    rule: usize,
+    close: String,
+    depth: usize,
+}
+
+impl RuleState {
+    fn top_name<'t>(&self, trace: &'t Trace) -> &'t str {
+        &trace.names[*self.stack.last().expect("the root state is never popped")]
+    }
+
+    fn apply(&mut self, rule: &CompiledRule) {
+        let keep = self.stack.len().saturating_sub(rule.pop).max(1);
+        self.stack.truncate(keep);
+        self.stack.extend_from_slice(&rule.push);
+    }
+}
+
+/// Finds the first of `rules` matching at `pos` after the token `prev`, returning its index with the end of its match.
+fn matching(
+    rules: &[CompiledRule], line: &str, pos: usize, prev: Option<(TokenKind, &str)>, region: &mut Region,
+) -> Option<(usize, usize)> {
+    rules.iter().enumerate().find_map(|(index, rule)| {
+        if rule
+            .prev_token
+            .as_ref()
+            .is_some_and(|condition| !condition.allows(prev))
+        {
+            return None;
+        }
+        if !rule.regex.search(line, pos, line.len(), Some(region)) {
+            return None;
+        }
+        let (_, end) = region.pos(0)?;
+        if let Some(lookahead) = &rule.lookahead
+            && !lookahead.search(line, end, line.len(), None)
+        {
+            return None;
+        }
+        (end > pos || rule.moves).then_some((index, end))
+    })
+}
+
+/// The kind and text of the `last` match if it reaches into `line`, which starts at `offset`; for a match that began
+/// on an earlier line, only its text on this one.
+fn previous<'l>(last: &LastMatch, line: &'l str, offset: usize) -> Option<(TokenKind, &'l str)> {
+    let (kind, span) = last.as_ref().filter(|(_, span)| span.end > offset)?;
+    Some((*kind, line.get(span.start.saturating_sub(offset)..span.end - offset)?))
+}
+
+impl LexerState for RuleState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        // A handle of our own, so rules can be borrowed while the stack changes.
+        let states = Arc::clone(&self.states);
+        let mut region = Region::new();
+        let mut pos = 0;
+        let mut empty_matches = 0;
+        while pos < line.len() {
+            if let Some(inside) = &mut self.inside {
+                let rule = &states[inside.state][inside.rule];
+                let rest = &line[pos..];
+                let len = delimited_end(rest, rule.open.as_deref(), &inside.close, &mut inside.depth);
+                let end = pos + len.unwrap_or(rest.len());
+                push_token(tokens, rule.token, offset + pos, offset + end);
+                note_match(&mut self.last, rule.token, offset + pos..offset + end);
+                if let Some(trace) = &self.trace {
+                    trace.matched(offset + pos..offset + end, inside.state, rule, &line[pos..end], &[]);
+                }
+                if len.is_some() {
+                    self.inside = None;
+                }
+                pos = end;
+                continue;
+            }
+            if empty_matches < MAX_EMPTY_MATCHES {
+                let top = *self.stack.last().expect("the root state is never popped");
+                let prev = previous(&self.last, line, offset);
+                if let Some((index, end)) = matching(&states[top], line, pos, prev, &mut region) {
+                    let rule = &states[top][index];
+                    empty_matches = if end == pos { empty_matches + 1 } else { 0 };
+                    rule.emit(&region, pos, end, offset, tokens, &mut self.last);
+                    if let Some(close) = &rule.close {
+                        let close = rule.closing(close, &region, line);
+                        self.inside = Some(Inside { state: top, rule: index, close, depth: 1 });
+                    }
+                    self.apply(rule);
+                    if let Some(trace) = &self.trace {
+                        trace.matched(offset + pos..offset + end, top, rule, &line[pos..end], &self.stack);
+                    }
+                    pos = end;
+                    continue;
+                }
+            }
+
+            let rest = &line[pos..];
+            let (kind, len) = if rest.starts_with("\r\n") {
+                (TokenKind::Whitespace, 2)
+            } else {
+                let ch = rest.chars().next().expect("pos is inside the line");
+                let kind = if ch == '\n' { TokenKind::Whitespace } else { TokenKind::Error };
+                (kind, ch.len_utf8())
+            };
+            push_token(tokens, kind, offset + pos, offset + pos + len);
+            note_match(&mut self.last, kind, offset + pos..offset + pos + len);
+            if let Some(trace) = &self.trace {
+                let (at, state, text) = (offset + pos..offset + pos + len, self.top_name(trace), &rest[..len]);
+                match empty_matches {
+                    MAX_EMPTY_MATCHES => trace.log(format_args!(
+                        "{at:?} {state}: skipping {text:?} after {MAX_EMPTY_MATCHES} empty matches"
+                    )),
+                    _ => trace.log(format_args!("{at:?} {state}: no rule matches {text:?}")),
+                }
+            }
+            pos += len;
+            empty_matches = 0;
+        }
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// A worked example of the table format: a small unit-aware config language.
+    ///
+    /// ```text
+    /// # pump settings
+    /// flow = 12.5 l/min
+    /// label = "pump \"A\""
+    /// ```
+    ///
+    /// Keys are split from their `=` with capture groups, numbers push a state that picks up an optional unit and
+    /// then pops itself with an empty-match fallback, and strings get a state of their own so one left open carries on
+    /// to the next line.
+    const UNITS: &str = r##"{
+        "root": [
+            { "match": "#.*", "token": "Comment" },
+            { "match": "([A-Za-z_]\\w*)(\\s*)(=)", "groups": ["NameAttribute", "Whitespace", "Operator"] },
+            { "match": "-?\\d+(?:\\.\\d+)?", "token": "Number", "push": "unit" },
+            { "match": "\"", "token": "String", "push": "string" },
+            { "include": "space" }
+        ],
+        "unit": [
+            { "match": "( +)([a-z]+(?:/[a-z]+)?)", "groups": ["Whitespace", "KeywordType"], "pop": 1 },
+            { "match": "", "pop": 1 }
+        ],
+        "string": [
+            { "match": "\\\\.", "token": "StringEscape" },
+            { "match": "\"", "token": "String", "pop": 1 },
+            { "match": "[^\"\\\\\\n]+", "token": "String" }
+        ],
+        "space": [
+            { "match": "\\s+", "token": "Whitespace" }
+        ]
+    }"##;
+
+    fn units() -> RuleLexer {
+        let table: RuleTable = serde_json::from_str(UNITS).unwrap();
+        RuleLexer::new("Units", &table).unwrap()
+    }
+
+    fn kinds<'s>(lexer: &dyn Lexer, src: &'s str) -> Vec<(TokenKind, &'s str)> {
+        let tokens = lexer.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens.iter().map(|token| (token.kind, token.text(src))).collect()
+    }
+
+    #[test]
+    fn worked_example_tokenizes_keys_numbers_units_and_strings() {
+        use TokenKind::*;
+        let src = "# pump\nflow = 12.5 l/min\nlabel = \"pump \\\"A\\\"\"\nmax = 3\n";
+        assert_eq!(
+            kinds(&units(), src),
+            vec![
+                (Comment, "# pump"),
+                (Whitespace, "\n"),
+                (NameAttribute, "flow"),
+                (Whitespace, " "),
+                (Operator, "="),
+                (Whitespace, " "),
+                (Number, "12.5"),
+                (Whitespace, " "),
+                (KeywordType, "l/min"),
+                (Whitespace, "\n"),
+                (NameAttribute, "label"),
+                (Whitespace, " "),
+                (Operator, "="),
+                (Whitespace, " "),
+                (String, "\"pump "),
+                (StringEscape, "\\\""),
+                (String, "A"),
+                (StringEscape, "\\\""),
+                (String, "\""),
+                (Whitespace, "\n"),
+                (NameAttribute, "max"),
+                (Whitespace, " "),
+                (Operator, "="),
+                (Whitespace, " "),
+                (Number, "3"),
+                (Whitespace, "\n"),
+            ]
+        );
+    }
+
+    #[test]
+    fn states_carry_across_lines() {
+        let lexer = units();
+        let src = "note = \"two\nlines\" # done\n";
+        let tokens = kinds(&lexer, src);
+        assert!(tokens.contains(&(TokenKind::String, "lines\"")), "{tokens:?}");
+        assert!(tokens.contains(&(TokenKind::Comment, "# done")), "{tokens:?}");
+
+        let mut state = lexer.start();
+        let mut tokens = Vec::new();
+        state.tokenize_line("a = \"open\n", 0, &mut tokens).unwrap();
+        let inside = state.snapshot().unwrap();
+        assert!(!inside.same_as(&*lexer.start()));
+        state.tokenize_line("close\"\n", 10, &mut tokens).unwrap();
+        assert!(state.same_as(&*lexer.start()));
+    }
+
+    #[test]
+    fn unmatched_text_becomes_errors_without_losing_the_state() {
+        let src = "x = @1\r\n";
+        assert_eq!(
+            kinds(&units(), src)[4..],
+            [
+                (TokenKind::Error, "@"),
+                (TokenKind::Number, "1"),
+                (TokenKind::Whitespace, "\r\n"),
+            ]
+        );
+    }
+
+    #[test]
+    fn builder_tables_match_their_serialized_form() {
+        let table = RuleTable::new()
+            .with_state(
+                ROOT,
+                vec![Rule::new("a", TokenKind::Name).with_push("b"), Rule::include("b")],
+            )
+            .with_state(
+                "b",
+                vec![
+                    Rule::by_groups("(x)y", &[TokenKind::Keyword]).with_pop(1),
+                    Rule::default_to(ROOT),
+                ],
+            );
+        let json = serde_json::to_string(&table).unwrap();
+        assert_eq!(
+            json,
+            r#"{"b":[{"match":"(x)y","groups":["Keyword"],"pop":1},{"push":["root"]}],"root":[{"match":"a","token":"Name","push":["b"]},{"include":"b"}]}"#
+        );
+        assert_eq!(serde_json::from_str::<RuleTable>(&json).unwrap(), table);
+    }
+
+    #[test]
+    fn nested_constructs_count_their_delimiters_across_lines() {
+        let table: RuleTable = serde_json::from_str(
+            r#"{ "root": [
+                { "open": "(*", "close": "*)", "token": "Comment" },
+                { "match": "\\w+", "token": "Name" },
+                { "match": "\\s+", "token": "Whitespace" }
+            ] }"#,
+        )
+        .unwrap();
+        let lexer = RuleLexer::new("OCaml", &table).unwrap();
+        assert_eq!(
+            kinds(&lexer, "(* a (* b *)\nc *) x\n"),
+            [
+                (TokenKind::Comment, "(* a (* b *)\nc *)"),
+                (TokenKind::Whitespace, " "),
+                (TokenKind::Name, "x"),
+                (TokenKind::Whitespace, "\n"),
+            ]
+        );
+        // Left open at the end of the input, the comment is still a comment.
+        assert_eq!(
+            kinds(&lexer, "x (* (* *)\n"),
+            [
+                (TokenKind::Name, "x"),
+                (TokenKind::Whitespace, " "),
+                (TokenKind::Comment, "(* (* *)\n"),
+            ]
+        );
+
+        let mut state = lexer.start();
+        let mut tokens = Vec::new();
+        state.tokenize_line("(* (*\n", 0, &mut tokens).unwrap();
+        let mut once = lexer.start();
+        once.tokenize_line("(*\n", 0, &mut tokens).unwrap();
+        assert!(!state.same_as(&*once));
+        state.tokenize_line("*)\n", 6, &mut tokens).unwrap();
+        assert!(state.same_as(&*once));
+    }
+
+    #[test]
+    fn delimited_constructs_close_with_their_captured_level() {
+        let table = RuleTable::new().with_state(
+            ROOT,
+            vec![
+                Rule::delimited(r"--\[(=*)\[", r"]\1]", TokenKind::Comment),
+                Rule::delimited(r"\[(=*)\[", r"]\1]", TokenKind::String),
+                Rule::new(r"--.*", TokenKind::Comment),
+                Rule::new(r"\w+", TokenKind::Name),
+                Rule::new(r"=", TokenKind::Operator),
+                Rule::new(r"\s+", TokenKind::Whitespace),
+            ],
+        );
+        let lexer = RuleLexer::new("Lua", &table).unwrap();
+        let src = "--[==[ ]] ]=] [[\n]==] s = [[a]] t\n";
+        assert_eq!(
+            kinds(&lexer, src),
+            [
+                (TokenKind::Comment, "--[==[ ]] ]=] [[\n]==]"),
+                (TokenKind::Whitespace, " "),
+                (TokenKind::Name, "s"),
+                (TokenKind::Whitespace, " "),
+                (TokenKind::Operator, "="),
+                (TokenKind::Whitespace, " "),
+                (TokenKind::String, "[[a]]"),
+                (TokenKind::Whitespace, " "),
+                (TokenKind::Name, "t"),
+                (TokenKind::Whitespace, "\n"),
+            ]
+        );
+
+        let json = serde_json::to_string(&RuleTable::new().with_state(
+            ROOT,
+            vec![
+                Rule::nested("{-", "-}", TokenKind::Comment),
+                Rule::delimited("<(a)", r"\1>", TokenKind::String),
+            ],
+        ))
+        .unwrap();
+        assert_eq!(
+            json,
+            r#"{"root":[{"token":"Comment","open":"{-","close":"-}"},{"match":"<(a)","token":"String","close":"\\1>"}]}"#
+        );
+    }
+
+    /// Python's soft keywords: `match` and `case` start statements only at the start of a line and before an
+    /// operand, and `_` is a wildcard only after `case`.
+    const SOFT: &str = r##"{
+        "root": [
+            {
+                "match": "(?:match|case)\\b",
+                "token": "Keyword",
+                "prevToken": { "lineStart": true },
+                "lookahead": "[ \\t]+[\\w(\\[{\"'-]"
+            },
+            { "match": "_\\b", "token": "Keyword", "prevToken": { "text": "case" } },
+            { "match": "\\w+", "token": "Name" },
+            { "match": "[.(),:=]", "token": "Punctuation" },
+            { "match": "\\s+", "token": "Whitespace" }
+        ]
+    }"##;
+
+    fn keywords(lexer: &dyn Lexer, src: &str) -> Vec<(usize, std::string::String)> {
+        let tokens = lexer.tokenize(src).unwrap();
+        tokens
+            .iter()
+            .filter(|token| token.kind == TokenKind::Keyword)
+            .map(|token| (token.start, token.text(src).to_owned()))
+            .collect()
+    }
+
+    #[test]
+    fn soft_keywords_depend_on_the_previous_token_and_lookahead() {
+        let table: RuleTable = serde_json::from_str(SOFT).unwrap();
+        let lexer = RuleLexer::new("Soft", &table).unwrap();
+        assert_eq!(keywords(&lexer, "match = re.match(text)\nmatch.group(1)\n"), []);
+        let src = "match command.split():\n    case _:\n        case = _\n";
+        assert_eq!(
+            keywords(&lexer, src),
+            [(0, "match".into()), (27, "case".into()), (32, "_".into())]
+        );
+
+        // A kind admits the kinds under it, and a condition with kinds and texts needs both.
+        let table = RuleTable::new().with_state(
+            ROOT,
+            vec![
+                Rule::new(r"as\b", TokenKind::Keyword)
+                    .with_prev_token(PrevToken::kind(TokenKind::Name).or_text("import")),
+                Rule::new(r"import\b", TokenKind::KeywordDeclaration),
+                Rule::new(r"\w+", TokenKind::NameBuiltin),
+                Rule::new(r"\s+", TokenKind::Whitespace),
+            ],
+        );
+        let lexer = RuleLexer::new("Imports", &table).unwrap();
+        assert_eq!(keywords(&lexer, "as len as\nimport as\n"), []);
+        let table = table.with_state(
+            ROOT,
+            vec![
+                Rule::new(r"as\b", TokenKind::Keyword)
+                    .with_prev_token(PrevToken::kind(TokenKind::Name).or_kind(TokenKind::Keyword)),
+                Rule::new(r"import\b", TokenKind::KeywordDeclaration),
+                Rule::new(r"\w+", TokenKind::NameBuiltin),
+                Rule::new(r"\s+", TokenKind::Whitespace),
+            ],
+        );
+        let lexer = RuleLexer::new("Imports", &table).unwrap();
+        assert_eq!(
+            keywords(&lexer, "as len as\nimport as\n"),
+            [(7, "as".into()), (17, "as".into())]
+        );
+
+        let json = serde_json::to_string(&RuleTable::new().with_state(
+            ROOT,
+            vec![
+                Rule::new("case", TokenKind::Keyword)
+                    .with_prev_token(PrevToken::text(":").or_line_start())
+                    .with_lookahead(" "),
+            ],
+        ))
+        .unwrap();
+        assert_eq!(
+            json,
+            r#"{"root":[{"match":"case","token":"Keyword","prevToken":{"text":[":"],"lineStart":true},"lookahead":" "}]}"#
+        );
+        assert_eq!(
+            serde_json::from_str::<RuleTable>(&json).unwrap(),
+            serde_json::from_str(r#"{"root":[{"match":"case","token":"Keyword","prevToken":{"text":":","lineStart":true},"lookahead":" "}]}"#).unwrap()
+        );
+        let bad = RuleTable::new().with_state(ROOT, vec![Rule::new("x", TokenKind::Name).with_lookahead("(")]);
+        assert!(
+            RuleLexer::new("Bad", &bad)
+                .unwrap_err()
+                .to_string()
+                .starts_with(r#"invalid lexer rules: state "root": bad lookahead "(""#)
+        );
+    }
+
+    #[test]
+    fn prev_token_sees_matches_before_they_merge() {
+        use TokenKind::*;
+        // `(`, `)`, and `:` are separate matches, but their tokens merge into one `():`.
+        let table = RuleTable::new().with_state(
+            ROOT,
+            vec![
+                Rule::new(r"case\b", Keyword).with_prev_token(PrevToken::text(":")),
+                Rule::new(r"[():]", Punctuation),
+                Rule::new(r"\w+", Name),
+                Rule::new(r"\s+", Whitespace),
+            ],
+        );
+        let lexer = RuleLexer::new("Cases", &table).unwrap();
+        assert_eq!(
+            kinds(&lexer, "f(): case\ncase\n"),
+            vec![
+                (Name, "f"),
+                (Punctuation, "():"),
+                (Whitespace, " "),
+                (Keyword, "case"),
+                (Whitespace, "\n"),
+                (Name, "case"),
+                (Whitespace, "\n"),
+            ]
+        );
+    }
+
+    #[test]
+    fn empty_matches_cannot_loop_forever() {
+        let table = RuleTable::new()
+            .with_state(ROOT, vec![Rule::default_to("a")])
+            .with_state("a", vec![Rule::new("", TokenKind::Text).with_pop(1)]);
+        let lexer = RuleLexer::new("Loop", &table).unwrap();
+        assert_eq!(
+            kinds(&lexer, "ab\n"),
+            vec![(TokenKind::Error, "ab"), (TokenKind::Whitespace, "\n")]
+        );
+    }
+
+    /// A trace destination the test can read back.
+    #[derive(Clone, Default)]
+    struct Log(Arc<Mutex<Vec<u8>>>);
+
+    impl Write for Log {
+        fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+            self.0.lock().unwrap().write(buf)
+        }
+
+        fn flush(&mut self) -> std::io::Result<()> {
+            Ok(())
+        }
+    }
+
+    impl Log {
+        fn lines(&self) -> Vec<std::string::String> {
+            let log = std::string::String::from_utf8(self.0.lock().unwrap().clone()).unwrap();
+            log.lines().map(str::to_owned).collect()
+        }
+    }
+
+    #[test]
+    fn traces_name_states_rules_and_stacks() {
+        let log = Log::default();
+        let lexer = units().with_trace(log.clone());
+        lexer.tokenize("max = 3 kg\n").unwrap();
+        assert_eq!(
+            log.lines(),
+            [
+                r#"0..5 root: root[1] "max =""#,
+                r#"5..6 root: space[0] " ""#,
+                r#"6..7 root: root[2] "3" -> root/unit"#,
+                r#"7..10 unit: unit[0] " kg" -> root"#,
+                r#"10..11 root: space[0] "\n""#,
+            ]
+        );
+
+        let table = RuleTable::new()
+            .with_state(ROOT, vec![Rule::default_to("a")])
+            .with_state("a", vec![Rule::new("", TokenKind::Text).with_pop(1)]);
+        let log = Log::default();
+        RuleLexer::new("Loop", &table)
+            .unwrap()
+            .with_trace(log.clone())
+            .tokenize("x")
+            .unwrap();
+        let lines = log.lines();
+        assert_eq!(lines.len(), MAX_EMPTY_MATCHES + 1);
+        assert_eq!(lines[0], r#"0..0 root: root[0] "" -> root/a"#);
+        assert_eq!(lines[1], r#"0..0 a: a[0] "" -> root"#);
+        assert_eq!(
+            lines[MAX_EMPTY_MATCHES],
+            r#"0..1 root: skipping "x" after 64 empty matches"#
+        );
+
+        // Tracing doesn't change the tokens.
+        let src = "flow = 12.5 l/min\nlabel = \"a\"\n";
+        assert_eq!(lexer.tokenize(src).unwrap(), units().tokenize(src).unwrap());
+    }
+
+    #[test]
+    fn bad_tables_are_reported() {
+        let err = |table: RuleTable| RuleLexer::new("Bad", &table).unwrap_err().to_string();
+        assert_eq!(
+            err(RuleTable::new().with_state("main", vec![])),
+            "invalid lexer rules: missing the \"root\" state"
+        );
+        assert_eq!(
+            err(RuleTable::new().with_state(ROOT, vec![Rule::new("x", TokenKind::Name).with_push("strng")])),
+            "invalid lexer rules: state \"root\": unknown state \"strng\""
+        );
+        assert_eq!(
+            err(RuleTable::new().with_state(ROOT, vec![Rule::include("missing")])),
+            "invalid lexer rules: state \"root\": unknown state \"missing\""
+        );
+        assert_eq!(
+            err(RuleTable::new()
+                .with_state(ROOT, vec![Rule::include("a")])
+                .with_state("a", vec![Rule::include(ROOT)])),
+            "invalid lexer rules: state \"root\": includes \"root\" in a cycle"
+        );
+        assert_eq!(
+            err(RuleTable::new().with_state(ROOT, vec![Rule::new("", TokenKind::Name)])),
+            "invalid lexer rules: state \"root\": a rule with no pattern must push or pop"
+        );
+        assert_eq!(
+            err(RuleTable::new().with_state(ROOT, vec![Rule::nested("/*", "", TokenKind::Comment)])),
+            "invalid lexer rules: state \"root\": a rule's `close` can't be empty"
+        );
+        assert_eq!(
+            err(RuleTable::new().with_state(ROOT, vec![Rule::delimited("<", ">", TokenKind::Name).with_pop(1)])),
+            "invalid lexer rules: state \"root\": a rule with `close` can't push or pop"
+        );
+        assert!(
+            err(RuleTable::new().with_state(ROOT, vec![Rule::new("(unclosed", TokenKind::Name)]))
+                .starts_with("invalid lexer rules: state \"root\": bad pattern \"(unclosed\": "),
+        );
+
+        let typo = serde_json::from_str::<RuleTable>(r#"{"root": [{"match": "x", "tokn": "Name"}]}"#).unwrap_err();
+        assert!(typo.to_string().contains("unknown field `tokn`"), "{typo}");
+    }
+}
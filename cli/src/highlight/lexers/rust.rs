@@ -0,0 +1,717 @@
+//! Stateful lexer for Rust source.
+
+use super::{Cursor, Lexer, LexerState, delimited_end, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+const KEYWORDS: &[&str] = &[
+    "as", "async", "await", "break", "continue", "crate", "dyn", "else", "extern", "for", "if", "in", "loop", "match",
+    "move", "mut", "pub", "ref", "return", "self", "Self", "super", "unsafe", "use", "where", "while", "yield",
+];
+
+const DECLARATIONS: &[&str] = &[
+    "const", "enum", "fn", "impl", "let", "mod", "static", "struct", "trait", "type",
+];
+
+const CONSTANTS: &[&str] = &["true", "false"];
+
+const TYPES: &[&str] = &[
+    "bool", "char", "f32", "f64", "i8", "i16", "i32", "i64", "i128", "isize", "str", "u8", "u16", "u32", "u64", "u128",
+    "usize",
+];
+
+/// Names from the standard prelude.
+const BUILTINS: &[&str] = &[
+    "AsMut",
+    "AsRef",
+    "Box",
+    "Clone",
+    "Copy",
+    "Default",
+    "DoubleEndedIterator",
+    "Drop",
+    "Eq",
+    "Err",
+    "ExactSizeIterator",
+    "Extend",
+    "Fn",
+    "FnMut",
+    "FnOnce",
+    "From",
+    "FromIterator",
+    "Into",
+    "IntoIterator",
+    "Iterator",
+    "None",
+    "Ok",
+    "Option",
+    "Ord",
+    "PartialEq",
+    "PartialOrd",
+    "Result",
+    "Send",
+    "Sized",
+    "Some",
+    "String",
+    "Sync",
+    "ToOwned",
+    "ToString",
+    "TryFrom",
+    "TryInto",
+    "Unpin",
+    "Vec",
+];
+
+/// Operators, longest first.
+const OPERATORS: &[&str] = &[
+    "<<=", ">>=", "...", "..=", "->", "=>", "==", "!=", "<=", ">=", "&&", "||", "+=", "-=", "*=", "/=", "%=", "^=",
+    "&=", "|=", "<<", ">>", "..", "+", "-", "*", "/", "%", "^", "!", "&", "|", "=", "<", ">", "@", "?", "~",
+];
+
+/// Lexer for Rust.
+///
+/// Raw strings with any number of hashes (`r#"..."#`), byte and C strings, and strings spanning lines are single
+/// [TokenKind::String] runs, with escapes as [TokenKind::StringEscape] outside raw strings. A quote followed by a
+/// name is a lifetime or loop label ([TokenKind::NameLabel]) unless a closing quote makes it a character literal,
+/// so `'a'` and `'a,` come apart. Block comments nest, as `/* /* */ */` does in Rust.
+///
+/// Doc comments (`///`, `//!`, `/** */`, `/*! */`) are [TokenKind::CommentDoc], and macro invocations such as
+/// `println!` are [TokenKind::NameMacro], apart from [TokenKind::NameFunction] calls. Attributes are
+/// [TokenKind::CommentPreproc] from `#[` or `#![` to the matching `]`, with the attribute's name as
+/// [TokenKind::NameAttribute] and its arguments lexed as code. Number literals keep their type suffix
+/// (`1_000u64`, `2.5f32`) in one [TokenKind::Number] token, and the angle brackets of generics are
+/// [TokenKind::Punctuation] where a type precedes them.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Rust;
+///
+/// let src = "/// Says hi.\nfn greet<'a>(name: &'a str) { println!(\"{name}\"); }\n";
+/// let tokens = Rust.tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("/// Says hi."), Some(TokenKind::CommentDoc));
+/// assert_eq!(kind("'a"), Some(TokenKind::NameLabel));
+/// assert_eq!(kind("println!"), Some(TokenKind::NameMacro));
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct Rust;
+
+impl Lexer for Rust {
+    fn name(&self) -> &str {
+        "Rust"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(RustState {
+            mode: Mode::Code,
+            groups: Vec::new(),
+            expect: Expect::Nothing,
+            after_dot: false,
+            generics: false,
+            first_line: true,
+        })
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Mode {
+    Code,
+    /// Inside `depth` levels of `/* ... */`; `doc` for a doc comment.
+    BlockComment {
+        depth: usize,
+        doc: bool,
+    },
+    /// Inside a string literal; raw strings close with a quote and `hashes` `#`s, and have no escapes.
+    String {
+        hashes: Option<usize>,
+    },
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Group {
+    Block,
+    Paren,
+    Bracket,
+    /// The brackets of an attribute.
+    Attribute,
+    /// Generic parameters or arguments.
+    Angle,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Expect {
+    Nothing,
+    FuncName,
+    TypeName,
+    /// The name after `macro_rules!`.
+    MacroName,
+    /// The path right after `#[`.
+    AttributeName,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct RustState {
+    mode: Mode,
+    groups: Vec<Group>,
+    expect: Expect,
+    /// The last token was `.`, so an identifier is a field or method.
+    after_dot: bool,
+    /// The last token was a type, a declared name, `impl`, or `::`, so `<` opens generics.
+    generics: bool,
+    /// No line has been lexed yet, so one starting with `#!` (but not `#![`) is a shebang. Callers that lex a line at
+    /// a time may pass offsets relative to the line, so this can't be told from the offset.
+    first_line: bool,
+}
+
+impl LexerState for RustState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        while cursor.pos < line.len() {
+            match self.mode {
+                Mode::Code => self.code(&mut cursor),
+                Mode::BlockComment { depth, doc } => self.block_comment(&mut cursor, depth, doc),
+                Mode::String { hashes } => self.string(&mut cursor, hashes),
+            }
+        }
+        self.first_line = false;
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl RustState {
+    fn code(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+
+        if ch.is_whitespace() {
+            let len = cursor.run_until(|ch| !ch.is_whitespace());
+            cursor.emit(TokenKind::Whitespace, len);
+            return;
+        }
+        if rest.starts_with("//") {
+            // `////` and longer are ordinary comments.
+            let doc = (rest.starts_with("///") && !rest.starts_with("////")) || rest.starts_with("//!");
+            let len = cursor.run_until(|ch| ch == '\n');
+            cursor.emit(if doc { TokenKind::CommentDoc } else { TokenKind::Comment }, len);
+            return;
+        }
+        if rest.starts_with("/*") {
+            // `/**/` and `/***` are ordinary comments.
+            let doc = (rest.starts_with("/**") && !rest.starts_with("/**/") && !rest.starts_with("/***"))
+                || rest.starts_with("/*!");
+            self.block_comment(cursor, 0, doc);
+            return;
+        }
+        if rest.starts_with("#!") && !rest[2..].trim_start().starts_with('[') && self.first_line && cursor.pos == 0 {
+            let len = cursor.run_until(|ch| ch == '\n');
+            cursor.emit(TokenKind::CommentPreproc, len);
+            return;
+        }
+
+        let (mut after_dot, mut generics) = (false, false);
+        let mut expect = Expect::Nothing;
+        match ch {
+            '"' => {
+                cursor.emit(TokenKind::String, 1);
+                self.string(cursor, None);
+            }
+            '\'' => quote(cursor, 0),
+            // A tuple index (`pair.0.1`) is only digits.
+            '0'..='9' if self.after_dot => cursor.emit(TokenKind::Number, cursor.run_until(|ch| !ch.is_ascii_digit())),
+            '0'..='9' => cursor.emit(TokenKind::Number, number_len(rest)),
+            '#' if rest.starts_with("#[") || rest.starts_with("#![") => {
+                cursor.emit(TokenKind::CommentPreproc, if rest.starts_with("#[") { 2 } else { 3 });
+                self.groups.push(Group::Attribute);
+                expect = Expect::AttributeName;
+            }
+            '$' if rest[1..].starts_with(is_ident_start) => {
+                // A macro metavariable.
+                cursor.emit(TokenKind::NameVariable, 1 + ident_len(&rest[1..]));
+            }
+            _ if is_ident_start(ch) && self.literal(cursor) => {}
+            _ if is_ident_start(ch) => {
+                let len = ident_len(rest);
+                let word = &rest[..len];
+                let after = &rest[len..];
+                if after.starts_with('!') && !after.starts_with("!=") {
+                    cursor.emit(TokenKind::NameMacro, len + 1);
+                    if word == "macro_rules" {
+                        expect = Expect::MacroName;
+                    }
+                } else {
+                    let kind = self.word(word, after);
+                    match word {
+                        "fn" => expect = Expect::FuncName,
+                        "struct" | "enum" | "trait" | "type" | "union" if kind == TokenKind::KeywordDeclaration => {
+                            expect = Expect::TypeName
+                        }
+                        _ if kind == TokenKind::NameAttribute => expect = Expect::AttributeName,
+                        _ => {}
+                    }
+                    generics = matches!(
+                        kind,
+                        TokenKind::NameClass | TokenKind::NameBuiltin | TokenKind::KeywordType
+                    ) || matches!(self.expect, Expect::FuncName | Expect::TypeName)
+                        || word == "impl"
+                        || word == "for";
+                    cursor.emit(kind, len);
+                }
+            }
+            '{' | '(' | '[' => {
+                self.groups.push(match ch {
+                    '{' => Group::Block,
+                    '(' => Group::Paren,
+                    _ => Group::Bracket,
+                });
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '}' | ')' | ']' => {
+                let attribute = self.groups.pop() == Some(Group::Attribute);
+                cursor.emit(
+                    if attribute { TokenKind::CommentPreproc } else { TokenKind::Punctuation },
+                    1,
+                );
+            }
+            '<' if self.generics => {
+                self.groups.push(Group::Angle);
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '>' if self.groups.last() == Some(&Group::Angle) => {
+                self.groups.pop();
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ':' if rest.starts_with("::") => {
+                // Paths keep an attribute's name together (`#[serde::rename]`) and take turbofish generics.
+                if self.expect == Expect::AttributeName {
+                    expect = Expect::AttributeName;
+                }
+                generics = true;
+                cursor.emit(TokenKind::Punctuation, 2);
+            }
+            ';' | ',' | ':' => cursor.emit(TokenKind::Punctuation, 1),
+            '.' if !rest.starts_with("..") => {
+                after_dot = true;
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            _ => match OPERATORS.iter().find(|operator| rest.starts_with(*operator)) {
+                Some(operator) => cursor.emit(TokenKind::Operator, operator.len()),
+                None => cursor.emit(TokenKind::Error, ch.len_utf8()),
+            },
+        }
+        self.after_dot = after_dot;
+        self.generics = generics;
+        self.expect = expect;
+    }
+
+    /// Classifies an identifier; `after` is the rest of the line after it.
+    fn word(&self, word: &str, after: &str) -> TokenKind {
+        let call = after.starts_with('(') || after.starts_with("::<");
+        if self.expect == Expect::AttributeName {
+            TokenKind::NameAttribute
+        } else if self.expect == Expect::MacroName {
+            TokenKind::NameMacro
+        } else if KEYWORDS.contains(&word) {
+            TokenKind::Keyword
+        } else if DECLARATIONS.contains(&word)
+            || (word == "union" && after.starts_with(' ') && after.trim_start().starts_with(is_ident_start))
+        {
+            TokenKind::KeywordDeclaration
+        } else if self.expect == Expect::FuncName {
+            TokenKind::NameFunction
+        } else if self.expect == Expect::TypeName {
+            TokenKind::NameClass
+        } else if self.after_dot {
+            if call { TokenKind::NameFunction } else { TokenKind::Name }
+        } else if CONSTANTS.contains(&word) {
+            TokenKind::KeywordConstant
+        } else if TYPES.contains(&word) {
+            TokenKind::KeywordType
+        } else if BUILTINS.contains(&word) {
+            TokenKind::NameBuiltin
+        } else if word.starts_with(|ch: char| ch.is_uppercase()) {
+            // By convention, types and variants are CamelCase and constants and statics SCREAMING_CASE.
+            if word.len() > 1 && !word.contains(|ch: char| ch.is_lowercase()) {
+                TokenKind::NameConstant
+            } else {
+                TokenKind::NameClass
+            }
+        } else if call {
+            TokenKind::NameFunction
+        } else {
+            TokenKind::Name
+        }
+    }
+
+    /// Lexes a prefixed literal (`b'x'`, `b"..."`, `r#"..."#`, `c"..."`) or raw identifier (`r#type`) at the
+    /// cursor, returning whether there was one.
+    fn literal(&mut self, cursor: &mut Cursor) -> bool {
+        let rest = cursor.rest();
+        let prefix = if rest.starts_with("br") || rest.starts_with("cr") {
+            2
+        } else if rest.starts_with(['b', 'c', 'r']) {
+            1
+        } else {
+            return false;
+        };
+        let after = &rest[prefix..];
+        if rest.starts_with("b'") {
+            quote(cursor, 1);
+        } else if prefix == 1 && !rest.starts_with('r') && after.starts_with('"') {
+            cursor.emit(TokenKind::String, 2);
+            self.string(cursor, None);
+        } else if rest[prefix - 1..].starts_with('r') {
+            let hashes = after.len() - after.trim_start_matches('#').len();
+            if after[hashes..].starts_with('"') {
+                cursor.emit(TokenKind::String, prefix + hashes + 1);
+                self.string(cursor, Some(hashes));
+            } else if rest.starts_with("r#") && after[1..].starts_with(is_ident_start) {
+                cursor.emit(TokenKind::Name, 2 + ident_len(&after[1..]));
+            } else {
+                return false;
+            }
+        } else {
+            return false;
+        }
+        true
+    }
+
+    /// Lexes the rest of a string literal, which may continue over several lines.
+    fn string(&mut self, cursor: &mut Cursor, hashes: Option<usize>) {
+        self.mode = Mode::String { hashes };
+        loop {
+            let rest = cursor.rest();
+            match (cursor.peek(), hashes) {
+                (None, _) => return,
+                (Some('"'), None) => {
+                    cursor.emit(TokenKind::String, 1);
+                    break;
+                }
+                (Some('\\'), None) => {
+                    let len = escape_len(rest).unwrap_or(1 + rest[1..].chars().next().map_or(0, char::len_utf8));
+                    cursor.emit(TokenKind::StringEscape, len);
+                }
+                (Some(_), None) => {
+                    let len = rest.find(['"', '\\']).unwrap_or(rest.len());
+                    cursor.emit(TokenKind::String, len);
+                }
+                (Some(_), Some(hashes)) => {
+                    let closing = raw_end(rest, hashes);
+                    cursor.emit(TokenKind::String, closing.unwrap_or(rest.len()));
+                    if closing.is_none() {
+                        return;
+                    }
+                    break;
+                }
+            }
+        }
+        self.mode = Mode::Code;
+    }
+
+    /// Lexes a block comment to its end, counting the `/*`s and `*/`s in it; `depth` are already open.
+    fn block_comment(&mut self, cursor: &mut Cursor, mut depth: usize, doc: bool) {
+        let rest = cursor.rest();
+        let kind = if doc { TokenKind::CommentDoc } else { TokenKind::Comment };
+        match delimited_end(rest, Some("/*"), "*/", &mut depth) {
+            Some(len) => {
+                cursor.emit(kind, len);
+                self.mode = Mode::Code;
+            }
+            None => {
+                cursor.emit(kind, rest.len());
+                self.mode = Mode::BlockComment { depth, doc };
+            }
+        }
+    }
+}
+
+fn is_ident_start(ch: char) -> bool {
+    ch == '_' || ch.is_alphabetic()
+}
+
+fn ident_len(text: &str) -> usize {
+    text.find(|ch: char| !(ch == '_' || ch.is_alphanumeric()))
+        .unwrap_or(text.len())
+}
+
+/// Lexes a character literal, with `prefix` letters before its quote, or else a lifetime or label.
+fn quote(cursor: &mut Cursor, prefix: usize) {
+    let rest = cursor.rest();
+    let body = &rest[prefix + 1..];
+    let len = match body.chars().next() {
+        Some('\\') => escape_len(body),
+        Some(ch) if ch != '\'' && ch != '\n' => Some(ch.len_utf8()),
+        _ => None,
+    };
+    match len {
+        Some(len) if body[len..].starts_with('\'') => {
+            cursor.emit(TokenKind::String, prefix + 1);
+            if body.starts_with('\\') {
+                cursor.emit(TokenKind::StringEscape, len);
+            } else {
+                cursor.emit(TokenKind::String, len);
+            }
+            cursor.emit(TokenKind::String, 1);
+        }
+        _ if prefix == 0 && body.starts_with(is_ident_start) => {
+            cursor.emit(TokenKind::NameLabel, 1 + ident_len(body));
+        }
+        _ => cursor.emit(TokenKind::Error, prefix + 1),
+    }
+}
+
+/// Length of the escape sequence `rest` starts with, including the line break after a trailing `\`, or `None` when
+/// it isn't a valid one.
+fn escape_len(rest: &str) -> Option<usize> {
+    match rest[1..].chars().next()? {
+        'n' | 'r' | 't' | '0' | '\\' | '\'' | '"' => Some(2),
+        'x' => {
+            let digits = rest.get(2..4)?;
+            digits.chars().all(|ch| ch.is_ascii_hexdigit()).then_some(4)
+        }
+        'u' if rest[2..].starts_with('{') => {
+            let close = rest.find('}')?;
+            rest[3..close]
+                .chars()
+                .all(|ch| ch.is_ascii_hexdigit() || ch == '_')
+                .then_some(close + 1)
+        }
+        '\n' => Some(2),
+        '\r' if rest[2..].starts_with('\n') => Some(3),
+        _ => None,
+    }
+}
+
+/// Length of a raw string's remaining body and closing quote and `hashes` `#`s, if they're in `rest`.
+fn raw_end(rest: &str, hashes: usize) -> Option<usize> {
+    let mut from = 0;
+    while let Some(quote) = rest[from..].find('"') {
+        let end = from + quote + 1;
+        if rest[end..].bytes().take(hashes).filter(|&byte| byte == b'#').count() == hashes {
+            return Some(end + hashes);
+        }
+        from = end;
+    }
+    None
+}
+
+/// Length of the number literal `rest` starts with: decimal, hex, octal, or binary, with digit separators,
+/// exponents, and a type suffix (`1_000u64`, `2.5e-3f32`).
+fn number_len(rest: &str) -> usize {
+    let bytes = rest.as_bytes();
+    let prefixed = rest.starts_with("0x") || rest.starts_with("0o") || rest.starts_with("0b");
+    let mut len = 0;
+    while let Some(&byte) = bytes.get(len) {
+        let exponent_sign = matches!(byte, b'+' | b'-')
+            && len > 1
+            && !prefixed
+            && matches!(bytes[len - 1], b'e' | b'E')
+            && bytes[len - 2].is_ascii_digit();
+        // A `.` is a decimal point unless a range (`1..2`), method, or field (`1.max(2)`, `t.0.1`) follows.
+        let point = byte == b'.'
+            && !prefixed
+            && !rest[..len].contains(['.', 'e', 'E'])
+            && !rest[len + 1..].starts_with(|ch: char| ch == '.' || is_ident_start(ch));
+        if byte.is_ascii_alphanumeric() || byte == b'_' || exponent_sign || point {
+            len += 1;
+        } else {
+            break;
+        }
+    }
+    len
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::assert_fixture_tokens;
+
+    fn kinds(src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = Rust.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    fn texts(src: &str, kind: TokenKind) -> Vec<&str> {
+        kinds(src)
+            .into_iter()
+            .filter(|&(token, _)| token == kind)
+            .map(|(_, text)| text)
+            .collect()
+    }
+
+    #[test]
+    fn raw_strings_close_on_their_hash_count() {
+        let src = "let a = r\"x\"; let b = r#\"say \"hi\"\"#; let c = r##\"a \"# b\"##; let d = br#\"x\"#;\n";
+        assert_eq!(
+            texts(src, TokenKind::String),
+            ["r\"x\"", "r#\"say \"hi\"\"#", "r##\"a \"# b\"##", "br#\"x\"#"]
+        );
+        let src = "r#\"first\nsecond\"# + r#type\n";
+        let tokens = kinds(src);
+        assert_eq!(tokens[0], (TokenKind::String, "r#\"first\nsecond\"#"));
+        assert_eq!(tokens[2], (TokenKind::Name, "r#type"));
+    }
+
+    #[test]
+    fn byte_and_c_strings_keep_their_escapes() {
+        let src = "(b\"a\\x7f\", b'\\n', c\"nul\", '\\u{1F600}', '\\'')\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::String, "b\"a")));
+        assert!(tokens.contains(&(TokenKind::StringEscape, "\\x7f")));
+        assert!(tokens.contains(&(TokenKind::String, "b'")));
+        assert!(tokens.contains(&(TokenKind::StringEscape, "\\n")));
+        assert!(tokens.contains(&(TokenKind::String, "c\"nul\"")));
+        assert!(tokens.contains(&(TokenKind::StringEscape, "\\u{1F600}")));
+        assert!(tokens.contains(&(TokenKind::StringEscape, "\\'")));
+    }
+
+    #[test]
+    fn lifetimes_are_told_apart_from_char_literals() {
+        let src = "fn f<'a, 'b: 'a>(x: &'a str, c: char) -> &'static str { let q = ('a', 'b'); 'outer: loop { break 'outer; } }\n";
+        assert_eq!(
+            texts(src, TokenKind::NameLabel),
+            ["'a", "'b", "'a", "'a", "'static", "'outer", "'outer"]
+        );
+        assert_eq!(
+            kinds("('a', 'b')\n")[1..4],
+            [
+                (TokenKind::String, "'a'"),
+                (TokenKind::Punctuation, ","),
+                (TokenKind::String, "'b'")
+            ]
+        );
+    }
+
+    #[test]
+    fn block_comments_nest() {
+        let src = "/* outer /* inner */ still outer */ x\n";
+        let tokens = kinds(src);
+        assert_eq!(tokens[0], (TokenKind::Comment, "/* outer /* inner */ still outer */"));
+        assert_eq!(tokens[1], (TokenKind::Name, "x"));
+
+        let mut state = Rust.start();
+        let mut tokens = Vec::new();
+        for line in ["/* a /* b\n", "*/ still */ y\n"] {
+            state.tokenize_line(line, 0, &mut tokens).unwrap();
+        }
+        assert!(tokens.iter().any(|token| token.kind == TokenKind::Name));
+    }
+
+    #[test]
+    fn doc_comments_have_their_own_kind() {
+        let src = "/// item\n//! module\n//// plain\n/** block */\n/*! inner */\n/**/\n/*** plain */\n// plain\n";
+        assert_eq!(
+            texts(src, TokenKind::CommentDoc),
+            ["/// item", "//! module", "/** block */", "/*! inner */"]
+        );
+        assert_eq!(texts(src, TokenKind::Comment).len(), 4);
+    }
+
+    #[test]
+    fn attributes_and_macros() {
+        let src = "#![no_std]\n#[derive(Debug, Clone)]\n#[serde::rename(\"id\")]\nfn main() { println!(\"{}\", vec![1]); foo(); x != y; }\n";
+        let tokens = kinds(src);
+        assert_eq!(tokens[0], (TokenKind::CommentPreproc, "#!["));
+        assert_eq!(tokens[1], (TokenKind::NameAttribute, "no_std"));
+        assert_eq!(tokens[2], (TokenKind::CommentPreproc, "]"));
+        assert_eq!(tokens[3], (TokenKind::CommentPreproc, "#["));
+        assert_eq!(tokens[4], (TokenKind::NameAttribute, "derive"));
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "Clone")));
+        assert!(tokens.contains(&(TokenKind::NameAttribute, "serde")));
+        assert!(tokens.contains(&(TokenKind::NameAttribute, "rename")));
+        assert!(tokens.contains(&(TokenKind::NameMacro, "println!")));
+        assert!(tokens.contains(&(TokenKind::NameMacro, "vec!")));
+        assert!(tokens.contains(&(TokenKind::NameFunction, "foo")));
+        assert!(tokens.contains(&(TokenKind::Operator, "!=")));
+    }
+
+    #[test]
+    fn numbers_keep_their_suffixes() {
+        let src = "[1_000u64, 2.5f32, 0xFF_u8, 1e-3, 3.0E+2_f64, 0b1010, 0o77, 1..2, 1.max(2), t.0.1, 7.]\n";
+        assert_eq!(
+            texts(src, TokenKind::Number),
+            [
+                "1_000u64",
+                "2.5f32",
+                "0xFF_u8",
+                "1e-3",
+                "3.0E+2_f64",
+                "0b1010",
+                "0o77",
+                "1",
+                "2",
+                "1",
+                "2",
+                "0",
+                "1",
+                "7."
+            ]
+        );
+    }
+
+    #[test]
+    fn generics_use_punctuation() {
+        let src = "let v: Vec<Option<u8>> = Vec::<u8>::new(); if a < b && c >> 2 > d {}\n";
+        let tokens = kinds(src);
+        assert_eq!(
+            tokens
+                .iter()
+                .filter(|token| token.0 == TokenKind::Punctuation && token.1.contains('<'))
+                .count(),
+            3
+        );
+        assert!(tokens.contains(&(TokenKind::Punctuation, ">>")));
+        assert!(tokens.contains(&(TokenKind::Operator, "<")));
+        assert!(tokens.contains(&(TokenKind::Operator, ">>")));
+        assert!(tokens.contains(&(TokenKind::Operator, ">")));
+    }
+
+    #[test]
+    fn states_compare_across_strings_and_comments() {
+        let mut state = Rust.start();
+        state.tokenize_line("let s = r#\"open\n", 0, &mut Vec::new()).unwrap();
+        let copy = state.snapshot().unwrap();
+        assert!(copy.same_as(&*state));
+        state.tokenize_line("\"# ;\n", 0, &mut Vec::new()).unwrap();
+        assert!(!copy.same_as(&*state));
+    }
+
+    #[test]
+    fn only_the_first_line_is_a_shebang() {
+        let src = "#!/usr/bin/env rust-script\nfn main() {}\n#!x\n";
+        assert_eq!(texts(src, TokenKind::CommentPreproc), ["#!/usr/bin/env rust-script"]);
+
+        // Incremental relexing hands each line over at offset 0, which mustn't make it look like the first.
+        let mut doc = crate::highlight::Incremental::new(&Rust, src).unwrap();
+        doc.edit(src.len() - 2..src.len() - 1, "y").unwrap();
+        assert_eq!(
+            doc.tokens_for_lines(0..doc.line_count()),
+            Rust.tokenize(doc.source()).unwrap()
+        );
+    }
+
+    #[test]
+    fn arena_matches_golden_tokens() {
+        assert_fixture_tokens(&Rust, "arena.rs");
+    }
+}
@@ -0,0 +1,961 @@
+//! Stateful lexer for Bourne-style shell scripts (bash, sh, zsh).
+
+use super::{Cursor, Lexer, LexerState, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+const KEYWORDS: &[&str] = &[
+    "if", "then", "else", "elif", "fi", "case", "esac", "for", "select", "while", "until", "do", "done", "function",
+    "time", "coproc", "!", "[[", "]]",
+];
+
+/// Keywords after which the next word is still a command (`if grep ...`, `then echo ...`).
+const COMMAND_PREFIXES: &[&str] = &[
+    "if", "then", "else", "elif", "while", "until", "do", "time", "coproc", "!",
+];
+
+const BUILTINS: &[&str] = &[
+    ".",
+    ":",
+    "[",
+    "alias",
+    "bg",
+    "bind",
+    "break",
+    "builtin",
+    "caller",
+    "cd",
+    "command",
+    "compgen",
+    "complete",
+    "continue",
+    "declare",
+    "dirs",
+    "disown",
+    "echo",
+    "enable",
+    "eval",
+    "exec",
+    "exit",
+    "export",
+    "false",
+    "fc",
+    "fg",
+    "getopts",
+    "hash",
+    "help",
+    "history",
+    "jobs",
+    "kill",
+    "let",
+    "local",
+    "logout",
+    "mapfile",
+    "popd",
+    "printf",
+    "pushd",
+    "pwd",
+    "read",
+    "readarray",
+    "readonly",
+    "return",
+    "set",
+    "shift",
+    "shopt",
+    "source",
+    "suspend",
+    "test",
+    "times",
+    "trap",
+    "true",
+    "type",
+    "typeset",
+    "ulimit",
+    "umask",
+    "unalias",
+    "unset",
+    "wait",
+];
+
+/// Builtins whose `NAME=value` arguments are assignments.
+const DECLARATIONS: &[&str] = &["declare", "export", "local", "readonly", "typeset"];
+
+/// Control and redirection operators, longest first.
+const OPERATORS: &[&str] = &[
+    ";;&", "&>>", "<<<", ";;", ";&", "||", "&&", "|&", "&>", ">>", ">&", "<&", "<>", ">|", "|", "&", ";", ">", "<",
+];
+
+/// Parameter expansion operators (`${var:-default}`), longest first.
+const PARAM_OPERATORS: &[&str] = &[
+    ":-", ":=", ":?", ":+", "##", "%%", "//", "/#", "/%", "^^", ",,", "-", "=", "?", "+", "#", "%", "/", "^", ",", ":",
+    "@",
+];
+
+/// Lexer for shell scripts.
+///
+/// Tracks the nesting that trips up regex-based highlighters: heredocs (`<<EOF`, `<<-EOF`, and quoted delimiters
+/// that disable expansion), `$( ... )` and backtick command substitution, `${var:-default}` parameter expansion,
+/// arithmetic, and quoting. `$var` is a [TokenKind::NameVariable] inside double quotes and unquoted heredoc bodies
+/// but not inside single quotes. Heredoc bodies are [TokenKind::String] and their delimiters
+/// [TokenKind::NameLabel].
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Shell;
+///
+/// let src = "echo \"hi $USER\" '$HOME'\n";
+/// let tokens = Shell.tokenize(src).unwrap();
+/// let variables: Vec<_> = tokens
+///     .iter()
+///     .filter(|token| token.kind == TokenKind::NameVariable)
+///     .map(|token| token.text(src))
+///     .collect();
+/// assert_eq!(variables, ["$USER"]);
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct Shell;
+
+impl Lexer for Shell {
+    fn name(&self) -> &str {
+        "Shell"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(ShellState {
+            stack: vec![Context::Command(Command::new(End::Script))],
+            pending: Vec::new(),
+            line_start: true,
+        })
+    }
+}
+
+/// What closes a command context.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum End {
+    Script,
+    /// `$( ... )`
+    Paren,
+    /// `` `...` ``
+    Backtick,
+}
+
+/// Parser position within a command list.
+#[derive(Debug, Clone, PartialEq)]
+struct Command {
+    end: End,
+    /// The next word is in command position.
+    start: bool,
+    /// The cursor is inside a word, so quotes and expansions extend it rather than starting a new one.
+    word: bool,
+    /// Command position resumes after this word (it was a `NAME=value` prefix assignment).
+    resume_start: bool,
+    /// Open `(` subshells and function parentheses, so only the matching `)` ends a `$( ... )`.
+    parens: usize,
+    /// Words to skip before `in` is a keyword, and whether it belongs to a `case`.
+    expect_in: Option<(usize, bool)>,
+    /// Between `in`/`;;` and `)` of a `case` clause.
+    pattern: bool,
+    /// The next word names a function (after `function`).
+    function_name: bool,
+    /// Arguments are assignments (after `declare`, `local`, ...).
+    declaration: bool,
+}
+
+impl Command {
+    fn new(end: End) -> Self {
+        Self {
+            end,
+            start: true,
+            word: false,
+            resume_start: false,
+            parens: 0,
+            expect_in: None,
+            pattern: false,
+            function_name: false,
+            declaration: false,
+        }
+    }
+
+    /// Resets per-command flags after a control operator or newline.
+    fn next_command(&mut self) {
+        self.start = true;
+        self.word = false;
+        self.resume_start = false;
+        self.expect_in = None;
+        self.function_name = false;
+        self.declaration = false;
+    }
+
+    /// Marks the start of a word (unless already inside one); a word leaves command position.
+    fn begin_word(&mut self) {
+        if self.word {
+            return;
+        }
+        self.word = true;
+        self.start = false;
+        match &mut self.expect_in {
+            Some((0, _)) => self.expect_in = None,
+            Some((skip, _)) => *skip -= 1,
+            None => {}
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct Heredoc {
+    delimiter: String,
+    /// `<<-` strips leading tabs from body lines and the terminator.
+    strip_tabs: bool,
+    /// Unquoted delimiters leave `$var`, `$(...)`, and backticks active in the body.
+    expand: bool,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Context {
+    Command(Command),
+    Double,
+    Single {
+        /// `$'...'`, where backslash escapes apply.
+        ansi: bool,
+    },
+    Param {
+        name: bool,
+        operator: bool,
+        /// Inside double quotes or a heredoc, where the default value is part of the string.
+        quoted: bool,
+    },
+    Arithmetic {
+        depth: usize,
+    },
+    Heredoc(Heredoc),
+}
+
+#[derive(Clone, PartialEq)]
+struct ShellState {
+    stack: Vec<Context>,
+    /// Heredocs introduced on the current line, whose bodies start after its newline.
+    pending: Vec<Heredoc>,
+    /// The next piece of input starts a line (a long line may arrive in several pieces).
+    line_start: bool,
+}
+
+impl LexerState for ShellState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        while cursor.pos < line.len() {
+            let at_line_start = cursor.pos == 0 && self.line_start;
+            match self.stack.last().expect("the script context is never popped") {
+                Context::Command(_) => self.command(&mut cursor),
+                Context::Double => self.double(&mut cursor),
+                Context::Single { ansi } => {
+                    let ansi = *ansi;
+                    self.single(&mut cursor, ansi)
+                }
+                Context::Param { .. } => self.param(&mut cursor),
+                Context::Arithmetic { .. } => self.arithmetic(&mut cursor),
+                Context::Heredoc(_) => self.heredoc(&mut cursor, at_line_start),
+            }
+        }
+        self.line_start = line.ends_with('\n');
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl ShellState {
+    fn top_command(&mut self) -> &mut Command {
+        match self.stack.last_mut() {
+            Some(Context::Command(command)) => command,
+            _ => unreachable!("called from a command context"),
+        }
+    }
+
+    fn pop(&mut self) {
+        if self.stack.len() > 1 {
+            self.stack.pop();
+        }
+    }
+
+    fn command(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        let command = self.top_command();
+
+        if ch == '`' && command.end == End::Backtick {
+            cursor.emit(TokenKind::StringBacktick, 1);
+            self.pop();
+            return;
+        }
+        if ch == ')' && command.end == End::Paren && command.parens == 0 && !command.pattern {
+            cursor.emit(TokenKind::Punctuation, 1);
+            self.pop();
+            return;
+        }
+
+        match ch {
+            '\n' => {
+                cursor.emit(TokenKind::Whitespace, 1);
+                command.next_command();
+                // Heredoc bodies start on the line after the one that introduced them. Popping pushes the first
+                // heredoc last, so its body is read first.
+                while let Some(heredoc) = self.pending.pop() {
+                    self.stack.push(Context::Heredoc(heredoc));
+                }
+            }
+            ' ' | '\t' | '\r' => {
+                let len = cursor.run_until(|ch| !matches!(ch, ' ' | '\t' | '\r'));
+                cursor.emit(TokenKind::Whitespace, len);
+                command.word = false;
+                if command.resume_start {
+                    command.resume_start = false;
+                    command.start = true;
+                }
+            }
+            '#' if !command.word => {
+                let len = cursor.run_until(|ch| ch == '\n');
+                cursor.emit(TokenKind::Comment, len);
+            }
+            '\\' => {
+                command.begin_word();
+                let len = 1 + rest[1..].chars().next().map_or(0, char::len_utf8);
+                cursor.emit(TokenKind::StringEscape, len);
+            }
+            '\'' => {
+                command.begin_word();
+                cursor.emit(TokenKind::String, 1);
+                self.stack.push(Context::Single { ansi: false });
+            }
+            '"' => {
+                command.begin_word();
+                cursor.emit(TokenKind::String, 1);
+                self.stack.push(Context::Double);
+            }
+            '`' => {
+                command.begin_word();
+                cursor.emit(TokenKind::StringBacktick, 1);
+                self.stack.push(Context::Command(Command::new(End::Backtick)));
+            }
+            '$' => {
+                command.begin_word();
+                self.dollar(cursor);
+            }
+            '(' => {
+                if command.start && rest.starts_with("((") {
+                    cursor.emit(TokenKind::Punctuation, 2);
+                    command.start = false;
+                    command.word = true;
+                    self.stack.push(Context::Arithmetic { depth: 0 });
+                    return;
+                }
+                cursor.emit(TokenKind::Punctuation, 1);
+                if !command.pattern {
+                    command.parens += 1;
+                    command.next_command();
+                }
+            }
+            ')' => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                if command.pattern {
+                    command.pattern = false;
+                } else {
+                    command.parens = command.parens.saturating_sub(1);
+                }
+                command.next_command();
+            }
+            _ => {
+                if let Some(operator) = OPERATORS.iter().find(|operator| rest.starts_with(**operator)) {
+                    self.operator(cursor, operator);
+                } else {
+                    self.word(cursor);
+                }
+            }
+        }
+    }
+
+    fn operator(&mut self, cursor: &mut Cursor, operator: &str) {
+        let rest = cursor.rest();
+        if operator == "<" && rest.starts_with("<<") {
+            let strip_tabs = rest.starts_with("<<-");
+            cursor.emit(TokenKind::Operator, if strip_tabs { 3 } else { 2 });
+            self.heredoc_delimiter(cursor, strip_tabs);
+            self.top_command().word = false;
+            return;
+        }
+
+        cursor.emit(TokenKind::Operator, operator.len());
+        let command = self.top_command();
+        match operator {
+            ";;" | ";&" | ";;&" => {
+                command.next_command();
+                command.start = false;
+                command.pattern = true;
+            }
+            "|" if command.pattern => {}
+            ";" | "&" | "|" | "||" | "&&" | "|&" => command.next_command(),
+            // Redirections take a target word but leave the command position alone.
+            _ => command.word = false,
+        }
+    }
+
+    /// Reads the delimiter after `<<`/`<<-` and queues the heredoc for the following line.
+    fn heredoc_delimiter(&mut self, cursor: &mut Cursor, strip_tabs: bool) {
+        let space = cursor.run_until(|ch| !matches!(ch, ' ' | '\t'));
+        cursor.emit(TokenKind::Whitespace, space);
+
+        let rest = cursor.rest();
+        let mut delimiter = String::new();
+        let mut quoted = false;
+        let mut quote = None;
+        let mut chars = rest.char_indices();
+        let mut len = 0;
+        while let Some((_, ch)) = chars.next() {
+            match (quote, ch) {
+                (_, '\n') => break,
+                (Some(open), _) if ch == open => quote = None,
+                (Some(_), _) => delimiter.push(ch),
+                (None, '\'' | '"') => {
+                    quote = Some(ch);
+                    quoted = true;
+                }
+                (None, '\\') => {
+                    quoted = true;
+                    delimiter.extend(chars.next().map(|(_, next)| next));
+                }
+                (None, _) if is_word_boundary(ch) => break,
+                (None, _) => delimiter.push(ch),
+            }
+            len = chars.offset();
+        }
+
+        cursor.emit(TokenKind::NameLabel, len);
+        if !delimiter.is_empty() {
+            self.pending.push(Heredoc { delimiter, strip_tabs, expand: !quoted });
+        }
+    }
+
+    /// Classifies a run of ordinary word characters.
+    fn word(&mut self, cursor: &mut Cursor) {
+        let len = cursor.run_until(is_word_boundary).max(1);
+        let piece = &cursor.rest()[..len];
+        let after = &cursor.rest()[len..];
+        let command = self.top_command();
+        if command.word {
+            cursor.emit(TokenKind::Text, len);
+            return;
+        }
+
+        let at_start = command.start;
+        let declaration = command.declaration;
+        let expect_in = command.expect_in;
+        command.begin_word();
+
+        if command.pattern {
+            if piece == "esac" {
+                command.pattern = false;
+                cursor.emit(TokenKind::Keyword, len);
+            } else {
+                cursor.emit(TokenKind::Text, len);
+            }
+            return;
+        }
+
+        if let Some((0, case)) = expect_in
+            && piece == "in"
+        {
+            command.pattern = case;
+            cursor.emit(TokenKind::Keyword, len);
+            return;
+        }
+
+        if let Some(name) = assignment_name(piece).filter(|_| at_start || declaration) {
+            let operator = if piece[name..].starts_with("+=") { 2 } else { 1 };
+            cursor.emit(TokenKind::NameVariable, name);
+            cursor.emit(TokenKind::Operator, operator);
+            cursor.emit(TokenKind::Text, len - name - operator);
+            command.resume_start = at_start;
+            return;
+        }
+
+        if at_start && KEYWORDS.contains(&piece) {
+            cursor.emit(TokenKind::Keyword, len);
+            command.start = COMMAND_PREFIXES.contains(&piece);
+            command.word = false;
+            match piece {
+                "for" | "select" => command.expect_in = Some((1, false)),
+                "case" => command.expect_in = Some((1, true)),
+                "function" => command.function_name = true,
+                _ => {}
+            }
+            return;
+        }
+
+        let kind = if at_start && matches!(piece, "{" | "}") {
+            command.start = piece == "{";
+            command.word = false;
+            TokenKind::Punctuation
+        } else if command.function_name || (at_start && after.trim_start_matches([' ', '\t']).starts_with("()")) {
+            command.function_name = false;
+            TokenKind::NameFunction
+        } else if at_start && BUILTINS.contains(&piece) {
+            command.declaration = DECLARATIONS.contains(&piece);
+            TokenKind::NameBuiltin
+        } else if expect_in == Some((1, false)) {
+            // The loop variable of `for name in ...`.
+            TokenKind::NameVariable
+        } else if piece == "]]" {
+            TokenKind::Keyword
+        } else if piece.bytes().all(|byte| byte.is_ascii_digit()) {
+            TokenKind::Number
+        } else {
+            TokenKind::Text
+        };
+        cursor.emit(kind, len);
+    }
+
+    fn double(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        match cursor.peek() {
+            Some('"') => {
+                cursor.emit(TokenKind::String, 1);
+                self.pop();
+            }
+            Some('\\') if rest[1..].starts_with(['$', '`', '"', '\\', '\n']) => cursor.emit(TokenKind::StringEscape, 2),
+            Some('$') => self.dollar(cursor),
+            Some('`') => {
+                cursor.emit(TokenKind::StringBacktick, 1);
+                self.stack.push(Context::Command(Command::new(End::Backtick)));
+            }
+            _ => {
+                let len = run_len(rest, &['"', '\\', '$', '`']);
+                cursor.emit(TokenKind::String, len);
+            }
+        }
+    }
+
+    fn single(&mut self, cursor: &mut Cursor, ansi: bool) {
+        let rest = cursor.rest();
+        match cursor.peek() {
+            Some('\'') => {
+                cursor.emit(TokenKind::String, 1);
+                self.pop();
+            }
+            Some('\\') if ansi => {
+                let len = 1 + rest[1..].chars().next().map_or(0, char::len_utf8);
+                cursor.emit(TokenKind::StringEscape, len);
+            }
+            _ => {
+                let stop: &[char] = if ansi { &['\'', '\\'] } else { &['\''] };
+                let len = run_len(rest, stop);
+                cursor.emit(TokenKind::String, len);
+            }
+        }
+    }
+
+    /// Handles `$` at the cursor: variables, `${...}`, `$(...)`, `$((...))`, and `$'...'`/`$"..."` quoting.
+    fn dollar(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let next = rest[1..].chars().next();
+        let (kind, len, context) = if rest.starts_with("$((") {
+            (TokenKind::Punctuation, 3, Some(Context::Arithmetic { depth: 0 }))
+        } else if rest.starts_with("$(") {
+            (
+                TokenKind::Punctuation,
+                2,
+                Some(Context::Command(Command::new(End::Paren))),
+            )
+        } else if rest.starts_with("${") {
+            let quoted = matches!(self.stack.last(), Some(Context::Double | Context::Heredoc(_)));
+            (
+                TokenKind::NameVariable,
+                2,
+                Some(Context::Param { name: false, operator: false, quoted }),
+            )
+        } else if rest.starts_with("$'") {
+            (TokenKind::String, 2, Some(Context::Single { ansi: true }))
+        } else if rest.starts_with("$\"") {
+            (TokenKind::String, 2, Some(Context::Double))
+        } else if next.is_some_and(|ch| ch.is_ascii_alphabetic() || ch == '_') {
+            (TokenKind::NameVariable, 1 + identifier_len(&rest[1..]), None)
+        } else if next.is_some_and(|ch| ch.is_ascii_digit() || "@*#?$!-".contains(ch)) {
+            (TokenKind::NameVariable, 2, None)
+        } else {
+            (TokenKind::Text, 1, None)
+        };
+        cursor.emit(kind, len);
+        self.stack.extend(context);
+    }
+
+    fn param(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let Some(Context::Param { name, operator, quoted }) = self.stack.last_mut() else { unreachable!() };
+        let value = if *quoted { TokenKind::String } else { TokenKind::Text };
+        if rest.starts_with('}') {
+            cursor.emit(TokenKind::NameVariable, 1);
+            self.pop();
+            return;
+        }
+        if !*name {
+            *name = true;
+            // `${#var}`/`${!ref}` prefixes, then a name, positional, or special parameter and an optional subscript.
+            let prefix = usize::from(rest.starts_with(['#', '!']) && !rest[1..].starts_with('}'));
+            let body = &rest[prefix..];
+            let mut len = match body.chars().next() {
+                Some(ch) if ch.is_ascii_alphabetic() || ch == '_' => identifier_len(body),
+                Some(ch) if ch.is_ascii_digit() => body.bytes().take_while(u8::is_ascii_digit).count(),
+                Some(ch) if "@*#?$!-".contains(ch) => 1,
+                _ => 0,
+            };
+            if body[len..].starts_with('[') {
+                len += body[len..].find(']').map_or(body.len() - len, |close| close + 1);
+            }
+            cursor.emit(TokenKind::NameVariable, prefix + len);
+            return;
+        }
+        if !*operator {
+            *operator = true;
+            if let Some(op) = PARAM_OPERATORS.iter().find(|op| rest.starts_with(**op)) {
+                cursor.emit(TokenKind::Operator, op.len());
+            }
+            return;
+        }
+        match cursor.peek() {
+            Some('\'') => {
+                cursor.emit(TokenKind::String, 1);
+                self.stack.push(Context::Single { ansi: false });
+            }
+            Some('"') => {
+                cursor.emit(TokenKind::String, 1);
+                self.stack.push(Context::Double);
+            }
+            Some('$') => self.dollar(cursor),
+            Some('`') => {
+                cursor.emit(TokenKind::StringBacktick, 1);
+                self.stack.push(Context::Command(Command::new(End::Backtick)));
+            }
+            Some('\\') => {
+                let len = 1 + rest[1..].chars().next().map_or(0, char::len_utf8);
+                cursor.emit(TokenKind::StringEscape, len);
+            }
+            _ => {
+                let len = run_len(rest, &['}', '\'', '"', '$', '`', '\\']);
+                cursor.emit(value, len);
+            }
+        }
+    }
+
+    fn arithmetic(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let Some(Context::Arithmetic { depth }) = self.stack.last_mut() else { unreachable!() };
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        match ch {
+            _ if ch.is_whitespace() => {
+                let len = cursor.run_until(|ch| !ch.is_whitespace());
+                cursor.emit(TokenKind::Whitespace, len);
+            }
+            ')' if *depth == 0 && rest.starts_with("))") => {
+                cursor.emit(TokenKind::Punctuation, 2);
+                self.pop();
+            }
+            '(' => {
+                *depth += 1;
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ')' => {
+                *depth = depth.saturating_sub(1);
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '$' => self.dollar(cursor),
+            _ if ch.is_ascii_digit() => {
+                let len = cursor.run_until(|ch| !(ch.is_ascii_alphanumeric() || ch == '#'));
+                cursor.emit(TokenKind::Number, len);
+            }
+            _ if ch.is_ascii_alphabetic() || ch == '_' => cursor.emit(TokenKind::NameVariable, identifier_len(rest)),
+            _ => cursor.emit(TokenKind::Operator, ch.len_utf8()),
+        }
+    }
+
+    fn heredoc(&mut self, cursor: &mut Cursor, at_line_start: bool) {
+        let Some(Context::Heredoc(heredoc)) = self.stack.last() else { unreachable!() };
+        let rest = cursor.rest();
+        if at_line_start {
+            let tabs = if heredoc.strip_tabs { rest.len() - rest.trim_start_matches('\t').len() } else { 0 };
+            let content = rest.trim_end_matches(['\n', '\r']);
+            if content[tabs..] == heredoc.delimiter {
+                cursor.emit(TokenKind::Whitespace, tabs);
+                cursor.emit(TokenKind::NameLabel, content.len() - tabs);
+                cursor.emit(TokenKind::Whitespace, rest.len() - content.len());
+                self.stack.pop();
+                return;
+            }
+            cursor.emit(TokenKind::Whitespace, tabs);
+            if cursor.pos == cursor.line.len() {
+                return;
+            }
+        }
+
+        let rest = cursor.rest();
+        if !heredoc.expand {
+            cursor.emit(TokenKind::String, rest.len());
+            return;
+        }
+        match cursor.peek() {
+            Some('\\') if rest[1..].starts_with(['$', '`', '\\', '\n']) => cursor.emit(TokenKind::StringEscape, 2),
+            Some('$') => self.dollar(cursor),
+            Some('`') => {
+                cursor.emit(TokenKind::StringBacktick, 1);
+                self.stack.push(Context::Command(Command::new(End::Backtick)));
+            }
+            _ => {
+                let len = run_len(rest, &['\\', '$', '`']);
+                cursor.emit(TokenKind::String, len);
+            }
+        }
+    }
+}
+
+/// Length of the run at the start of `rest` before the next of `stops`, always including the first character, which
+/// may take several bytes.
+fn run_len(rest: &str, stops: &[char]) -> usize {
+    let first = rest.chars().next().map_or(0, char::len_utf8);
+    first + rest[first..].find(stops).unwrap_or(rest.len() - first)
+}
+
+fn is_word_boundary(ch: char) -> bool {
+    matches!(
+        ch,
+        ' ' | '\t' | '\r' | '\n' | '|' | '&' | ';' | '(' | ')' | '<' | '>' | '\'' | '"' | '`' | '$' | '\\'
+    )
+}
+
+fn identifier_len(text: &str) -> usize {
+    text.bytes()
+        .take_while(|byte| byte.is_ascii_alphanumeric() || *byte == b'_')
+        .count()
+}
+
+/// Length of the variable name in a `NAME=`, `NAME+=`, or `NAME[i]=` word, if it is one.
+fn assignment_name(word: &str) -> Option<usize> {
+    if !word.starts_with(|ch: char| ch.is_ascii_alphabetic() || ch == '_') {
+        return None;
+    }
+    let mut len = identifier_len(word);
+    if word[len..].starts_with('[') {
+        len += word[len..].find(']')? + 1;
+    }
+    let rest = &word[len..];
+    (rest.starts_with('=') || rest.starts_with("+=")).then_some(len)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Non-whitespace tokens as (kind, text) pairs.
+    fn kinds(src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = Shell.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    fn texts(src: &str, kind: TokenKind) -> Vec<&str> {
+        kinds(src)
+            .into_iter()
+            .filter(|(k, _)| *k == kind)
+            .map(|(_, text)| text)
+            .collect()
+    }
+
+    #[test]
+    fn variables_expand_in_double_quotes_only() {
+        let src = "echo \"$HOME/${dir:-tmp}\" '$literal' $'tab\\t' \\$escaped\n";
+        assert_eq!(texts(src, TokenKind::NameVariable), ["$HOME", "${dir", "}"]);
+        assert_eq!(texts(src, TokenKind::Operator), [":-"]);
+        assert!(kinds(src).contains(&(TokenKind::String, "'$literal'")));
+        assert!(kinds(src).contains(&(TokenKind::StringEscape, "\\t")));
+        assert!(kinds(src).contains(&(TokenKind::StringEscape, "\\$")));
+        assert!(kinds(src).contains(&(TokenKind::String, "tmp")));
+    }
+
+    #[test]
+    fn parameter_expansion_forms() {
+        let src = "n=${#items[@]} first=${1} last=${name%%.*} up=${v^^} ref=${!ptr}\n";
+        let variables = texts(src, TokenKind::NameVariable);
+        assert!(variables.contains(&"${#items[@]}"));
+        assert!(variables.contains(&"${1}"));
+        assert!(variables.contains(&"${!ptr}"));
+        assert_eq!(texts(src, TokenKind::Operator), ["=", "=", "=", "%%", "=", "^^", "="]);
+    }
+
+    #[test]
+    fn command_substitution_nests_quotes() {
+        let src = "out=\"$(echo \"$(date +%s) `hostname`\" | tr -d ')')\"\necho done\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "echo")));
+        assert!(tokens.contains(&(TokenKind::StringBacktick, "`")));
+        assert!(tokens.contains(&(TokenKind::Text, "hostname")));
+        assert!(tokens.contains(&(TokenKind::String, "')'")));
+        // The substitution closed, so the next line starts a command again.
+        assert_eq!(tokens.last(), Some(&(TokenKind::Text, "done")));
+        assert_eq!(
+            tokens
+                .iter()
+                .filter(|(kind, _)| *kind == TokenKind::NameBuiltin)
+                .count(),
+            2
+        );
+    }
+
+    #[test]
+    fn heredocs_expand_unless_the_delimiter_is_quoted() {
+        let src = "cat <<EOF\nhi $USER \\$HOME\nEOF\ncat <<'RAW'\n$USER\nRAW\necho after\n";
+        let tokens = kinds(src);
+        assert_eq!(texts(src, TokenKind::NameLabel), ["EOF", "EOF", "'RAW'", "RAW"]);
+        assert!(tokens.contains(&(TokenKind::NameVariable, "$USER")));
+        assert!(tokens.contains(&(TokenKind::StringEscape, "\\$")));
+        assert!(tokens.contains(&(TokenKind::String, "$USER\n")));
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "echo")));
+    }
+
+    #[test]
+    fn heredoc_inside_a_function_inside_a_subshell() {
+        let src =
+            "(\n  setup() {\n    cat <<-\"EOF\" > \"$out\"\n\tname: $NAME\n\t)} not the end\n\tEOF\n  }\n  setup\n)\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::NameFunction, "setup")));
+        assert!(tokens.contains(&(TokenKind::String, "name: $NAME\n")));
+        assert!(tokens.contains(&(TokenKind::String, ")} not the end\n")));
+        assert_eq!(texts(src, TokenKind::NameLabel), ["\"EOF\"", "EOF"]);
+        assert!(tokens.contains(&(TokenKind::NameVariable, "$out")));
+        assert_eq!(texts(src, TokenKind::Punctuation), ["(", "()", "{", "}", ")"]);
+    }
+
+    #[test]
+    fn multiple_heredocs_on_one_line_are_read_in_order() {
+        let src = "paste <<A <<B\none\nA\ntwo\nB\n";
+        assert_eq!(texts(src, TokenKind::String), ["one\n", "two\n"]);
+        assert_eq!(texts(src, TokenKind::NameLabel), ["A", "B", "A", "B"]);
+    }
+
+    #[test]
+    fn case_statements_with_fallthrough() {
+        let src = "case \"$1\" in\n  start|up) echo start ;;&\n  (*) echo any ;;\nesac\n";
+        let tokens = kinds(src);
+        assert_eq!(texts(src, TokenKind::Keyword), ["case", "in", "esac"]);
+        assert_eq!(texts(src, TokenKind::Operator), ["|", ";;&", ";;"]);
+        assert!(tokens.contains(&(TokenKind::Text, "start")));
+        assert_eq!(texts(src, TokenKind::NameBuiltin), ["echo", "echo"]);
+        assert_eq!(texts(src, TokenKind::Punctuation), [")", "(", ")"]);
+    }
+
+    #[test]
+    fn case_inside_command_substitution_keeps_its_parens() {
+        let src = "kind=$(case $x in a) echo A ;; esac)\necho $kind\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::Keyword, "esac")));
+        assert_eq!(tokens.last(), Some(&(TokenKind::NameVariable, "$kind")));
+    }
+
+    #[test]
+    fn keywords_builtins_and_assignments() {
+        let src = "#!/bin/bash\nfor f in *.txt; do\n  if [[ -f $f ]]; then local n=1; fi\ndone\nLANG=C sort 2>&1 # trailing\nfunction greet { :; }\necho a#b\n";
+        let tokens = kinds(src);
+        assert_eq!(
+            texts(src, TokenKind::Keyword),
+            ["for", "in", "do", "if", "[[", "]]", "then", "fi", "done", "function"]
+        );
+        assert!(tokens.contains(&(TokenKind::NameVariable, "f")));
+        assert!(tokens.contains(&(TokenKind::NameVariable, "n")));
+        assert!(tokens.contains(&(TokenKind::NameVariable, "LANG")));
+        assert!(tokens.contains(&(TokenKind::Text, "sort")));
+        assert!(tokens.contains(&(TokenKind::Number, "2")));
+        assert!(tokens.contains(&(TokenKind::NameFunction, "greet")));
+        assert_eq!(texts(src, TokenKind::Comment), ["#!/bin/bash", "# trailing"]);
+        assert!(tokens.contains(&(TokenKind::Text, "a#b")));
+    }
+
+    #[test]
+    fn arithmetic() {
+        let src = "(( count += 2 ))\ntotal=$(( (a + 0x1f) * $b ))\n";
+        let tokens = kinds(src);
+        assert!(tokens.contains(&(TokenKind::NameVariable, "count")));
+        assert!(tokens.contains(&(TokenKind::Number, "0x1f")));
+        assert!(tokens.contains(&(TokenKind::NameVariable, "$b")));
+        assert_eq!(texts(src, TokenKind::Punctuation), ["((", "))", "$((", "(", ")", "))"]);
+    }
+
+    #[test]
+    fn unterminated_constructs_run_to_the_end() {
+        for src in [
+            "echo \"open",
+            "cat <<EOF\nbody",
+            "x=$(echo",
+            "echo ${x:-",
+            "echo 'a\nb",
+            "cat <<",
+            "(( 1 +",
+        ] {
+            let tokens = Shell.tokenize(src).unwrap();
+            assert_eq!(tokens.last().map(|token| token.end), Some(src.len()), "{src:?}");
+        }
+    }
+
+    #[test]
+    fn quoted_text_may_start_with_multi_byte_characters() {
+        for src in [
+            "echo \"é\"",
+            "echo 'é'",
+            "echo $'é\\n'",
+            "echo ${x:-é}",
+            "cat <<EOF\né\nEOF\n",
+        ] {
+            let tokens = Shell.tokenize(src).unwrap();
+            let text: String = tokens.iter().map(|token| token.text(src)).collect();
+            assert_eq!(text, src);
+        }
+    }
+
+    #[test]
+    fn split_lines_only_check_terminators_at_line_start() {
+        // Overlong lines arrive in pieces; a piece that merely begins with the delimiter must not end the heredoc.
+        let mut state = Shell.start();
+        let mut tokens = Vec::new();
+        let mut offset = 0;
+        for piece in ["cat <<EOF\n", "xx", "EOF\n", "EOF\n", "echo $a\n"] {
+            state.tokenize_line(piece, offset, &mut tokens).unwrap();
+            offset += piece.len();
+        }
+        let kinds: Vec<_> = tokens.iter().map(|token| token.kind).collect();
+        assert_eq!(
+            tokens[5..7],
+            [
+                Token::new(TokenKind::String, 10, 16),
+                Token::new(TokenKind::NameLabel, 16, 19)
+            ]
+        );
+        assert!(kinds.ends_with(&[
+            TokenKind::NameBuiltin,
+            TokenKind::Whitespace,
+            TokenKind::NameVariable,
+            TokenKind::Whitespace
+        ]));
+    }
+}
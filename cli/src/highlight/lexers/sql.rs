@@ -0,0 +1,1155 @@
+//! Stateful lexer for SQL, with extensions for the PostgreSQL, MySQL, SQLite, and T-SQL dialects.
+
+use super::{Cursor, Lexer, LexerState, delimited_end, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+/// Statement keywords shared by every dialect.
+const KEYWORDS: &[&str] = &[
+    "ACTION",
+    "ADD",
+    "AFTER",
+    "ALL",
+    "ALTER",
+    "AND",
+    "ANY",
+    "AS",
+    "ASC",
+    "AUTHORIZATION",
+    "BEFORE",
+    "BEGIN",
+    "BETWEEN",
+    "BY",
+    "CALL",
+    "CASCADE",
+    "CASE",
+    "CAST",
+    "CHECK",
+    "COLLATE",
+    "COLUMN",
+    "COMMENT",
+    "COMMIT",
+    "CONSTRAINT",
+    "CREATE",
+    "CROSS",
+    "CURRENT",
+    "CURSOR",
+    "DATABASE",
+    "DECLARE",
+    "DEFAULT",
+    "DEFERRABLE",
+    "DEFERRED",
+    "DELETE",
+    "DESC",
+    "DISTINCT",
+    "DO",
+    "DROP",
+    "EACH",
+    "ELSE",
+    "END",
+    "ESCAPE",
+    "EXCEPT",
+    "EXECUTE",
+    "EXISTS",
+    "EXPLAIN",
+    "FETCH",
+    "FILTER",
+    "FIRST",
+    "FOLLOWING",
+    "FOR",
+    "FOREIGN",
+    "FROM",
+    "FULL",
+    "FUNCTION",
+    "GLOBAL",
+    "GRANT",
+    "GROUP",
+    "HAVING",
+    "IF",
+    "IMMEDIATE",
+    "IN",
+    "INDEX",
+    "INITIALLY",
+    "INNER",
+    "INSERT",
+    "INSTEAD",
+    "INTERSECT",
+    "INTO",
+    "IS",
+    "JOIN",
+    "KEY",
+    "LAST",
+    "LATERAL",
+    "LEFT",
+    "LIKE",
+    "LIMIT",
+    "LOCAL",
+    "MATCH",
+    "MERGE",
+    "NATURAL",
+    "NEXT",
+    "NO",
+    "NOT",
+    "NULLS",
+    "OF",
+    "OFFSET",
+    "ON",
+    "ONLY",
+    "OR",
+    "ORDER",
+    "OUTER",
+    "OVER",
+    "PARTITION",
+    "PRECEDING",
+    "PRIMARY",
+    "PROCEDURE",
+    "RANGE",
+    "RECURSIVE",
+    "REFERENCES",
+    "RELEASE",
+    "RENAME",
+    "REPLACE",
+    "RESTRICT",
+    "RETURN",
+    "RETURNS",
+    "REVOKE",
+    "RIGHT",
+    "ROLLBACK",
+    "ROW",
+    "ROWS",
+    "SAVEPOINT",
+    "SCHEMA",
+    "SELECT",
+    "SEQUENCE",
+    "SESSION",
+    "SET",
+    "TABLE",
+    "TEMP",
+    "TEMPORARY",
+    "THEN",
+    "TO",
+    "TRANSACTION",
+    "TRIGGER",
+    "TRUNCATE",
+    "TYPE",
+    "UNBOUNDED",
+    "UNION",
+    "UNIQUE",
+    "UPDATE",
+    "USAGE",
+    "USING",
+    "VALUES",
+    "VIEW",
+    "WHEN",
+    "WHERE",
+    "WINDOW",
+    "WITH",
+    "WITHOUT",
+    "WORK",
+    "ZONE",
+];
+
+const TYPES: &[&str] = &[
+    "BIGINT",
+    "BINARY",
+    "BIT",
+    "BLOB",
+    "BOOLEAN",
+    "CHAR",
+    "CHARACTER",
+    "CLOB",
+    "DATE",
+    "DECIMAL",
+    "DOUBLE",
+    "FLOAT",
+    "INT",
+    "INTEGER",
+    "INTERVAL",
+    "NCHAR",
+    "NUMERIC",
+    "PRECISION",
+    "REAL",
+    "SMALLINT",
+    "TIME",
+    "TIMESTAMP",
+    "VARBINARY",
+    "VARCHAR",
+    "VARYING",
+];
+
+const CONSTANTS: &[&str] = &["FALSE", "NULL", "TRUE", "UNKNOWN"];
+
+/// Standard functions, which are [TokenKind::NameBuiltin] when called.
+const FUNCTIONS: &[&str] = &[
+    "ABS",
+    "AVG",
+    "CEIL",
+    "CEILING",
+    "CHAR_LENGTH",
+    "CHARACTER_LENGTH",
+    "COALESCE",
+    "COUNT",
+    "DENSE_RANK",
+    "EXP",
+    "EXTRACT",
+    "FIRST_VALUE",
+    "FLOOR",
+    "GREATEST",
+    "LAG",
+    "LAST_VALUE",
+    "LEAD",
+    "LEAST",
+    "LENGTH",
+    "LN",
+    "LOWER",
+    "LTRIM",
+    "MAX",
+    "MIN",
+    "MOD",
+    "NTILE",
+    "NULLIF",
+    "OCTET_LENGTH",
+    "POSITION",
+    "POWER",
+    "RANK",
+    "REPLACE",
+    "ROUND",
+    "ROW_NUMBER",
+    "RTRIM",
+    "SQRT",
+    "SUBSTRING",
+    "SUM",
+    "TRIM",
+    "UPPER",
+];
+
+/// Functions written without parentheses, which are [TokenKind::NameBuiltin] wherever they appear.
+const NILADIC: &[&str] = &[
+    "CURRENT_DATE",
+    "CURRENT_TIME",
+    "CURRENT_TIMESTAMP",
+    "CURRENT_USER",
+    "LOCALTIME",
+    "LOCALTIMESTAMP",
+    "SESSION_USER",
+];
+
+/// Keywords after which the next name is the table, view, or type a statement is about.
+const CLASS_INTRODUCERS: &[&str] = &["INTO", "REFERENCES", "SEQUENCE", "TABLE", "TYPE", "UPDATE", "VIEW"];
+
+/// Keywords after which the next name is a routine being declared.
+const FUNCTION_INTRODUCERS: &[&str] = &["FUNCTION", "PROCEDURE"];
+
+/// Keywords that may come between an introducer and its name, as in `CREATE TABLE IF NOT EXISTS`.
+const QUALIFIERS: &[&str] = &["EXISTS", "IF", "NOT", "ONLY"];
+
+/// Operators, longest first.
+const OPERATORS: &[&str] = &[
+    "->>", "#>>", "<=>", "::", "||", "<>", "!=", "<=", ">=", "->", "#>", "@>", "<@", "&&", "<<", ">>", "=", "<", ">",
+    "+", "-", "*", "/", "%", "&", "|", "^", "~", "!", "@", "#",
+];
+
+/// A SQL dialect, whose extensions a [Sql] lexer recognizes on top of ANSI SQL.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Default)]
+pub enum SqlDialect {
+    /// Standard SQL only.
+    #[default]
+    Ansi,
+    /// PostgreSQL: dollar-quoted and `E'...'` strings, `::` casts, nested block comments, and psql meta-commands.
+    Postgres,
+    /// MySQL and MariaDB: backtick identifiers, `#` comments, double-quoted strings, backslash escapes, and
+    /// `@variables`.
+    MySql,
+    /// SQLite: backtick and bracketed identifiers, and `@name` parameters.
+    Sqlite,
+    /// Microsoft T-SQL: bracketed identifiers, `@variables`, and `#temp` tables.
+    TSql,
+}
+
+impl SqlDialect {
+    fn extensions(self) -> &'static Extensions {
+        match self {
+            SqlDialect::Ansi => &ANSI,
+            SqlDialect::Postgres => &POSTGRES,
+            SqlDialect::MySql => &MYSQL,
+            SqlDialect::Sqlite => &SQLITE,
+            SqlDialect::TSql => &TSQL,
+        }
+    }
+}
+
+/// What a dialect adds to ANSI SQL.
+struct Extensions {
+    name: &'static str,
+    keywords: &'static [&'static str],
+    types: &'static [&'static str],
+    functions: &'static [&'static str],
+    /// `$$ ... $$` and `$tag$ ... $tag$` strings, and `E'...'` strings with backslash escapes.
+    dollar_quotes: bool,
+    /// `/* /* */ */` is one comment.
+    nested_comments: bool,
+    /// Lines starting with `\` are client commands, such as psql's `\connect`.
+    meta_commands: bool,
+    /// `` `name` `` is an identifier.
+    backticks: bool,
+    /// `[name]` is an identifier.
+    brackets: bool,
+    /// `#` starts a comment.
+    hash_comments: bool,
+    /// `#name` is a temporary table.
+    hash_names: bool,
+    /// `"..."` is a string rather than an identifier.
+    double_quoted_strings: bool,
+    /// Every string may contain backslash escapes.
+    backslash_escapes: bool,
+    /// `@name` is a variable or parameter, and `@@name` a system variable.
+    at_variables: bool,
+}
+
+static ANSI: Extensions = Extensions {
+    name: "SQL",
+    keywords: &[],
+    types: &[],
+    functions: &[],
+    dollar_quotes: false,
+    nested_comments: false,
+    meta_commands: false,
+    backticks: false,
+    brackets: false,
+    hash_comments: false,
+    hash_names: false,
+    double_quoted_strings: false,
+    backslash_escapes: false,
+    at_variables: false,
+};
+
+static POSTGRES: Extensions = Extensions {
+    name: "PostgreSQL",
+    keywords: &[
+        "ALWAYS",
+        "ANALYZE",
+        "ARRAY",
+        "CACHE",
+        "CONCURRENTLY",
+        "CONFLICT",
+        "COPY",
+        "CYCLE",
+        "DEFINER",
+        "ENUM",
+        "EXTENSION",
+        "GENERATED",
+        "IDENTITY",
+        "ILIKE",
+        "IMMUTABLE",
+        "INCREMENT",
+        "INHERITS",
+        "INVOKER",
+        "LANGUAGE",
+        "LISTEN",
+        "MATERIALIZED",
+        "MAXVALUE",
+        "MINVALUE",
+        "NOTHING",
+        "NOTIFY",
+        "OWNED",
+        "OWNER",
+        "RETURNING",
+        "SECURITY",
+        "SIMILAR",
+        "STABLE",
+        "START",
+        "STDIN",
+        "STRICT",
+        "TABLESPACE",
+        "VACUUM",
+        "VOLATILE",
+    ],
+    types: &[
+        "BIGSERIAL",
+        "BOOL",
+        "BYTEA",
+        "CIDR",
+        "FLOAT4",
+        "FLOAT8",
+        "INET",
+        "INT2",
+        "INT4",
+        "INT8",
+        "JSON",
+        "JSONB",
+        "MONEY",
+        "OID",
+        "RECORD",
+        "REGCLASS",
+        "SERIAL",
+        "SMALLSERIAL",
+        "TEXT",
+        "TIMESTAMPTZ",
+        "TIMETZ",
+        "TSVECTOR",
+        "UUID",
+        "VOID",
+        "XML",
+    ],
+    functions: &[
+        "ARRAY_AGG",
+        "CONCAT",
+        "CURRVAL",
+        "DATE_TRUNC",
+        "FORMAT",
+        "GEN_RANDOM_UUID",
+        "GENERATE_SERIES",
+        "JSON_AGG",
+        "JSONB_AGG",
+        "JSONB_BUILD_OBJECT",
+        "NEXTVAL",
+        "NOW",
+        "REGEXP_REPLACE",
+        "SETVAL",
+        "STRING_AGG",
+        "TO_CHAR",
+        "TO_TIMESTAMP",
+        "UNNEST",
+    ],
+    dollar_quotes: true,
+    nested_comments: true,
+    meta_commands: true,
+    backticks: false,
+    brackets: false,
+    hash_comments: false,
+    hash_names: false,
+    double_quoted_strings: false,
+    backslash_escapes: false,
+    at_variables: false,
+};
+
+static MYSQL: Extensions = Extensions {
+    name: "MySQL",
+    keywords: &[
+        "AUTO_INCREMENT",
+        "CHARSET",
+        "DESCRIBE",
+        "DUPLICATE",
+        "ENGINE",
+        "IGNORE",
+        "LOCK",
+        "REGEXP",
+        "RLIKE",
+        "SHOW",
+        "TABLES",
+        "UNLOCK",
+        "UNSIGNED",
+        "USE",
+        "ZEROFILL",
+    ],
+    types: &[
+        "DATETIME",
+        "ENUM",
+        "JSON",
+        "LONGBLOB",
+        "LONGTEXT",
+        "MEDIUMBLOB",
+        "MEDIUMINT",
+        "MEDIUMTEXT",
+        "TEXT",
+        "TINYBLOB",
+        "TINYINT",
+        "TINYTEXT",
+        "YEAR",
+    ],
+    functions: &[
+        "CONCAT",
+        "CONCAT_WS",
+        "DATE_FORMAT",
+        "GROUP_CONCAT",
+        "IF",
+        "IFNULL",
+        "LAST_INSERT_ID",
+        "NOW",
+        "UNIX_TIMESTAMP",
+        "UUID",
+    ],
+    dollar_quotes: false,
+    nested_comments: false,
+    meta_commands: false,
+    backticks: true,
+    brackets: false,
+    hash_comments: true,
+    hash_names: false,
+    double_quoted_strings: true,
+    backslash_escapes: true,
+    at_variables: true,
+};
+
+static SQLITE: Extensions = Extensions {
+    name: "SQLite",
+    keywords: &[
+        "ABORT",
+        "ANALYZE",
+        "ATTACH",
+        "AUTOINCREMENT",
+        "CONFLICT",
+        "DETACH",
+        "EXCLUSIVE",
+        "FAIL",
+        "GLOB",
+        "IGNORE",
+        "INDEXED",
+        "NOTHING",
+        "PLAN",
+        "PRAGMA",
+        "QUERY",
+        "REINDEX",
+        "ROWID",
+        "STRICT",
+        "VACUUM",
+        "VIRTUAL",
+    ],
+    types: &["DATETIME", "TEXT"],
+    functions: &[
+        "CHANGES",
+        "DATE",
+        "DATETIME",
+        "GROUP_CONCAT",
+        "IFNULL",
+        "IIF",
+        "INSTR",
+        "JSON_EXTRACT",
+        "JULIANDAY",
+        "LAST_INSERT_ROWID",
+        "PRINTF",
+        "RANDOM",
+        "STRFTIME",
+        "TIME",
+        "TOTAL",
+        "TYPEOF",
+    ],
+    dollar_quotes: false,
+    nested_comments: false,
+    meta_commands: false,
+    backticks: true,
+    brackets: true,
+    hash_comments: false,
+    hash_names: false,
+    double_quoted_strings: false,
+    backslash_escapes: false,
+    at_variables: true,
+};
+
+static TSQL: Extensions = Extensions {
+    name: "T-SQL",
+    keywords: &[
+        "CATCH",
+        "CLUSTERED",
+        "EXEC",
+        "GO",
+        "IDENTITY",
+        "NOCOUNT",
+        "NONCLUSTERED",
+        "OUTPUT",
+        "PRINT",
+        "PROC",
+        "RAISERROR",
+        "TOP",
+        "TRAN",
+        "TRY",
+        "USE",
+        "WHILE",
+    ],
+    types: &[
+        "DATETIME",
+        "DATETIME2",
+        "DATETIMEOFFSET",
+        "MONEY",
+        "NTEXT",
+        "NVARCHAR",
+        "SMALLDATETIME",
+        "SQL_VARIANT",
+        "TINYINT",
+        "UNIQUEIDENTIFIER",
+        "XML",
+    ],
+    functions: &[
+        "CHARINDEX",
+        "CONVERT",
+        "DATEADD",
+        "DATEDIFF",
+        "FORMAT",
+        "GETDATE",
+        "GETUTCDATE",
+        "IIF",
+        "ISNULL",
+        "LEN",
+        "NEWID",
+        "SCOPE_IDENTITY",
+        "TRY_CAST",
+    ],
+    dollar_quotes: false,
+    nested_comments: false,
+    meta_commands: false,
+    backticks: false,
+    brackets: true,
+    hash_comments: false,
+    hash_names: true,
+    double_quoted_strings: false,
+    backslash_escapes: false,
+    at_variables: true,
+};
+
+/// Whether `list` contains `word`, ignoring ASCII case.
+fn contains(list: &[&str], word: &str) -> bool {
+    list.iter().any(|known| known.eq_ignore_ascii_case(word))
+}
+
+/// Lexer for SQL, in ANSI form or with the extensions of one [SqlDialect].
+///
+/// Keywords match in any case. Statement keywords are [TokenKind::Keyword], type names [TokenKind::KeywordType], and
+/// `NULL`, `TRUE`, and `FALSE` [TokenKind::KeywordConstant]. Functions such as `COUNT` and `COALESCE` are
+/// [TokenKind::NameBuiltin] when called, so themes can tell them from keywords; other calls are
+/// [TokenKind::NameFunction]. The name after `CREATE TABLE`, `INSERT INTO`, `REFERENCES`, and the like is
+/// [TokenKind::NameClass], and the name after `CREATE FUNCTION` [TokenKind::NameFunction].
+///
+/// Single-quoted strings are [TokenKind::String], with doubled quotes as [TokenKind::StringEscape]; they may span
+/// lines. Quoted identifiers are [TokenKind::Name]. Bind parameters (`$1`, `?`, `?1`, `:name`, and, where the dialect
+/// has them, `@name`) are [TokenKind::NameVariable]. `--` and `/* */` comments are [TokenKind::Comment].
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::{Sql, SqlDialect};
+///
+/// let src = "select count(*), name::text from users where id = $1 and note = $$it's$$;\n";
+/// let tokens = Sql::new(SqlDialect::Postgres).tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("select"), Some(TokenKind::Keyword));
+/// assert_eq!(kind("count"), Some(TokenKind::NameBuiltin));
+/// assert_eq!(kind("text"), Some(TokenKind::KeywordType));
+/// assert_eq!(kind("$1"), Some(TokenKind::NameVariable));
+/// assert_eq!(kind("$$it's$$"), Some(TokenKind::String));
+/// ```
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub struct Sql {
+    dialect: SqlDialect,
+}
+
+impl Sql {
+    /// Returns a lexer for `dialect`.
+    pub const fn new(dialect: SqlDialect) -> Self {
+        Self { dialect }
+    }
+
+    /// The dialect this lexer recognizes.
+    pub const fn dialect(&self) -> SqlDialect {
+        self.dialect
+    }
+}
+
+impl Lexer for Sql {
+    fn name(&self) -> &str {
+        self.dialect.extensions().name
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(SqlState { dialect: self.dialect, mode: Mode::Code, expect: Expect::Nothing, after_dot: false })
+    }
+}
+
+/// What the next characters continue.
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Mode {
+    Code,
+    /// Inside a block comment, `depth` levels deep.
+    BlockComment {
+        depth: usize,
+    },
+    /// Inside a string or quoted identifier of `kind` that ends at `close`, where a doubled `close` stands for
+    /// itself.
+    Quoted {
+        close: char,
+        kind: TokenKind,
+        backslashes: bool,
+    },
+    /// Inside a dollar-quoted string that ends at `$tag$`.
+    Dollar {
+        tag: String,
+    },
+}
+
+/// What the next name is, given the words before it.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Expect {
+    Nothing,
+    Class,
+    Function,
+    /// The type of a `::` cast.
+    Type,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct SqlState {
+    dialect: SqlDialect,
+    mode: Mode,
+    expect: Expect,
+    /// The last token was `.`, so a word is a column or member rather than a keyword.
+    after_dot: bool,
+}
+
+impl LexerState for SqlState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        while cursor.pos < line.len() {
+            match self.mode {
+                Mode::Code => self.code(&mut cursor),
+                Mode::BlockComment { .. } => self.block_comment(&mut cursor),
+                Mode::Quoted { .. } => self.quoted(&mut cursor),
+                Mode::Dollar { .. } => self.dollar(&mut cursor),
+            }
+        }
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl SqlState {
+    fn code(&mut self, cursor: &mut Cursor) {
+        let ext = self.dialect.extensions();
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+
+        if ch.is_whitespace() {
+            cursor.emit(TokenKind::Whitespace, cursor.run_until(|ch| !ch.is_whitespace()));
+            return;
+        }
+        if rest.starts_with("--") || (ch == '#' && ext.hash_comments) {
+            cursor.emit(TokenKind::Comment, cursor.run_until(|ch| ch == '\n'));
+            return;
+        }
+        if ch == '\\' && ext.meta_commands && cursor.line[..cursor.pos].trim().is_empty() {
+            cursor.emit(TokenKind::CommentPreproc, cursor.run_until(|ch| ch == '\n'));
+            return;
+        }
+        if rest.starts_with("/*") {
+            cursor.emit(TokenKind::Comment, 2);
+            self.mode = Mode::BlockComment { depth: 1 };
+            return;
+        }
+
+        let name = match self.expect {
+            Expect::Class => TokenKind::NameClass,
+            Expect::Function => TokenKind::NameFunction,
+            Expect::Nothing | Expect::Type => TokenKind::Name,
+        };
+        let (mut expect, mut after_dot) = (Expect::Nothing, false);
+        match ch {
+            '\'' => self.open(cursor, 1, '\'', TokenKind::String, ext.backslash_escapes),
+            '"' if ext.double_quoted_strings => self.open(cursor, 1, '"', TokenKind::String, ext.backslash_escapes),
+            // Quoted identifiers keep what is expected, which they settle when they close.
+            '"' => return self.open(cursor, 1, '"', name, false),
+            '`' if ext.backticks => return self.open(cursor, 1, '`', name, false),
+            '[' if ext.brackets => return self.open(cursor, 1, ']', name, false),
+            '$' if ext.dollar_quotes && dollar_tag(rest).is_some() => {
+                let tag = dollar_tag(rest).expect("checked above");
+                cursor.emit(TokenKind::String, tag.len() + 2);
+                self.mode = Mode::Dollar { tag: tag.to_string() };
+            }
+            '$' | '?' if rest[1..].starts_with(|ch: char| ch.is_ascii_digit()) => {
+                cursor.emit(TokenKind::NameVariable, 1 + ident_len(&rest[1..]))
+            }
+            '?' => cursor.emit(TokenKind::NameVariable, 1),
+            ':' | '$' if rest[1..].starts_with(ident_start) => {
+                cursor.emit(TokenKind::NameVariable, 1 + ident_len(&rest[1..]))
+            }
+            '@' if ext.at_variables && rest[1..].trim_start_matches('@').starts_with(ident_start) => {
+                let at = rest.len() - rest.trim_start_matches('@').len();
+                cursor.emit(TokenKind::NameVariable, at.min(2) + ident_len(&rest[at.min(2)..]))
+            }
+            '#' if ext.hash_names && rest[1..].trim_start_matches('#').starts_with(ident_start) => {
+                let hashes = rest.len() - rest.trim_start_matches('#').len();
+                cursor.emit(name, hashes + ident_len(&rest[hashes..]));
+                if cursor.rest().starts_with('.') {
+                    expect = self.expect;
+                }
+            }
+            '0'..='9' => cursor.emit(TokenKind::Number, number_len(rest)),
+            '.' if rest[1..].starts_with(|ch: char| ch.is_ascii_digit()) => {
+                cursor.emit(TokenKind::Number, number_len(rest))
+            }
+            _ if ident_start(ch) => {
+                let len = ident_len(rest);
+                let word = &rest[..len];
+                let after = &rest[len..];
+                let prefix = if after.starts_with('\'') { string_prefix(word, ext) } else { None };
+                if let Some(backslashes) = prefix {
+                    self.open(cursor, len + 1, '\'', TokenKind::String, backslashes);
+                } else {
+                    let kind = self.word(word, after, ext);
+                    let declared = matches!(kind, TokenKind::NameClass | TokenKind::NameFunction);
+                    if self.expect != Expect::Nothing && !declared {
+                        if contains(QUALIFIERS, word) || (kind == TokenKind::Name && after.starts_with('.')) {
+                            expect = self.expect;
+                        }
+                    } else if !self.after_dot {
+                        if contains(CLASS_INTRODUCERS, word) {
+                            expect = Expect::Class;
+                        } else if contains(FUNCTION_INTRODUCERS, word) {
+                            expect = Expect::Function;
+                        }
+                    }
+                    cursor.emit(kind, len);
+                }
+            }
+            '.' => {
+                after_dot = true;
+                expect = self.expect;
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '(' | ')' | '[' | ']' | ',' | ';' => cursor.emit(TokenKind::Punctuation, 1),
+            _ => match OPERATORS.iter().find(|operator| rest.starts_with(*operator)) {
+                Some(operator) => {
+                    if *operator == "::" {
+                        expect = Expect::Type;
+                    }
+                    cursor.emit(TokenKind::Operator, operator.len());
+                }
+                None => cursor.emit(TokenKind::Error, ch.len_utf8()),
+            },
+        }
+        self.expect = expect;
+        self.after_dot = after_dot;
+    }
+
+    /// Classifies a word, given the text `after` it on the line.
+    fn word(&self, word: &str, after: &str, ext: &Extensions) -> TokenKind {
+        let call = after.trim_start_matches([' ', '\t']).starts_with('(');
+        if self.after_dot && self.expect == Expect::Nothing {
+            return if call { TokenKind::NameFunction } else { TokenKind::Name };
+        }
+        if call && (contains(FUNCTIONS, word) || contains(ext.functions, word)) {
+            TokenKind::NameBuiltin
+        } else if contains(TYPES, word) || contains(ext.types, word) {
+            TokenKind::KeywordType
+        } else if contains(CONSTANTS, word) {
+            TokenKind::KeywordConstant
+        } else if contains(NILADIC, word) {
+            TokenKind::NameBuiltin
+        } else if contains(KEYWORDS, word) || contains(ext.keywords, word) {
+            TokenKind::Keyword
+        } else {
+            match self.expect {
+                Expect::Class if !after.starts_with('.') => TokenKind::NameClass,
+                Expect::Function if !after.starts_with('.') => TokenKind::NameFunction,
+                Expect::Type if !after.starts_with('.') => TokenKind::KeywordType,
+                _ if call && !after.starts_with([' ', '\t']) => TokenKind::NameFunction,
+                _ => TokenKind::Name,
+            }
+        }
+    }
+
+    /// Emits the `len` bytes that open a string or quoted identifier and enters it.
+    fn open(&mut self, cursor: &mut Cursor, len: usize, close: char, kind: TokenKind, backslashes: bool) {
+        cursor.emit(kind, len);
+        self.mode = Mode::Quoted { close, kind, backslashes };
+    }
+
+    /// Lexes the next piece of a string or quoted identifier: text, an escape, or the closing quote.
+    fn quoted(&mut self, cursor: &mut Cursor) {
+        let Mode::Quoted { close, kind, backslashes } = self.mode else {
+            unreachable!("not in a quoted span")
+        };
+        let rest = cursor.rest();
+        match cursor.peek() {
+            Some(ch) if ch == close && rest[1..].starts_with(close) => cursor.emit(
+                if kind == TokenKind::String { TokenKind::StringEscape } else { kind },
+                2,
+            ),
+            Some(ch) if ch == close => {
+                cursor.emit(kind, 1);
+                self.mode = Mode::Code;
+                self.after_dot = false;
+                if kind != TokenKind::String && !cursor.rest().starts_with('.') {
+                    self.expect = Expect::Nothing;
+                }
+            }
+            Some('\\') if backslashes => {
+                let next = rest[1..].chars().next().filter(|&ch| ch != '\n');
+                cursor.emit(TokenKind::StringEscape, 1 + next.map_or(0, char::len_utf8));
+            }
+            _ => {
+                let len = cursor.run_until(|ch| ch == close || (backslashes && ch == '\\'));
+                cursor.emit(kind, len);
+            }
+        }
+    }
+
+    /// Lexes the next piece of a dollar-quoted string: text, a `$` that doesn't close it, or the closing tag.
+    fn dollar(&mut self, cursor: &mut Cursor) {
+        let Mode::Dollar { tag } = &self.mode else { unreachable!("not in a dollar-quoted string") };
+        let rest = cursor.rest();
+        let close_len = tag.len() + 2;
+        if rest.starts_with('$') && rest[1..].starts_with(tag.as_str()) && rest[1 + tag.len()..].starts_with('$') {
+            cursor.emit(TokenKind::String, close_len);
+            self.mode = Mode::Code;
+            return;
+        }
+        // Step over the first character whole: it isn't the closing tag, and it may be more than a byte.
+        let first = rest.chars().next().map_or(0, char::len_utf8);
+        let len = rest[first..].find('$').map_or(rest.len(), |at| at + first);
+        cursor.emit(TokenKind::String, len);
+    }
+
+    /// Lexes a block comment up to its end or the end of the line, tracking nesting where the dialect allows it.
+    fn block_comment(&mut self, cursor: &mut Cursor) {
+        let Mode::BlockComment { mut depth } = self.mode else { unreachable!("not in a block comment") };
+        let open = self.dialect.extensions().nested_comments.then_some("/*");
+        let rest = cursor.rest();
+        match delimited_end(rest, open, "*/", &mut depth) {
+            Some(len) => {
+                cursor.emit(TokenKind::Comment, len);
+                self.mode = Mode::Code;
+            }
+            None => {
+                cursor.emit(TokenKind::Comment, rest.len());
+                self.mode = Mode::BlockComment { depth };
+            }
+        }
+    }
+}
+
+/// Whether `ch` may start an unquoted identifier.
+fn ident_start(ch: char) -> bool {
+    ch == '_' || ch.is_alphabetic()
+}
+
+/// Length of the identifier characters `rest` starts with; `$` counts after the first, as PostgreSQL and MySQL
+/// allow.
+fn ident_len(rest: &str) -> usize {
+    rest.find(|ch: char| !(ch == '_' || ch == '$' || ch.is_alphanumeric()))
+        .unwrap_or(rest.len())
+}
+
+/// The tag of the `$tag$` (or empty `$$`) dollar quote `rest` starts with.
+fn dollar_tag(rest: &str) -> Option<&str> {
+    let body = &rest[1..];
+    let len = body
+        .find(|ch: char| !(ch == '_' || ch.is_alphanumeric()))
+        .unwrap_or(body.len());
+    let tag = &body[..len];
+    let valid = tag.is_empty() || tag.starts_with(ident_start);
+    (valid && body[len..].starts_with('$')).then_some(tag)
+}
+
+/// Whether `word` is a string prefix such as `N` in `N'text'`, and if so, whether the string takes backslash
+/// escapes.
+fn string_prefix(word: &str, ext: &Extensions) -> Option<bool> {
+    match word.to_ascii_uppercase().as_str() {
+        "N" | "X" | "B" => Some(ext.backslash_escapes),
+        "E" if ext.dollar_quotes => Some(true),
+        _ => None,
+    }
+}
+
+/// Length of the number `rest` starts with: an integer, a decimal with an optional exponent, or a `0x` hex literal.
+fn number_len(rest: &str) -> usize {
+    let bytes = rest.as_bytes();
+    let digits = |from: usize, hex: bool| {
+        let run = bytes[from..]
+            .iter()
+            .take_while(|&&byte| byte == b'_' || if hex { byte.is_ascii_hexdigit() } else { byte.is_ascii_digit() })
+            .count();
+        from + run
+    };
+    if rest.len() > 2 && (rest.starts_with("0x") || rest.starts_with("0X")) && bytes[2].is_ascii_hexdigit() {
+        return digits(2, true);
+    }
+    let mut end = digits(0, false);
+    if bytes.get(end) == Some(&b'.') {
+        end = digits(end + 1, false);
+    }
+    if matches!(bytes.get(end), Some(b'e' | b'E')) {
+        let sign = usize::from(matches!(bytes.get(end + 1), Some(b'+' | b'-')));
+        if bytes.get(end + 1 + sign).is_some_and(u8::is_ascii_digit) {
+            end = digits(end + 1 + sign, false);
+        }
+    }
+    end
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::{assert_fixture_tokens, find};
+
+    /// Non-whitespace tokens as (kind, text) pairs.
+    fn kinds(dialect: SqlDialect, src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = Sql::new(dialect).tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    fn texts(dialect: SqlDialect, src: &str, kind: TokenKind) -> Vec<&str> {
+        kinds(dialect, src)
+            .into_iter()
+            .filter(|(k, _)| *k == kind)
+            .map(|(_, text)| text)
+            .collect()
+    }
+
+    #[test]
+    fn keywords_match_in_any_case_and_functions_are_builtins() {
+        let src = "Select COUNT(*), coalesce(a, 0), max (b), my_fn(c), current_timestamp From t Where x Is Not Null;\n";
+        let ansi = SqlDialect::Ansi;
+        assert_eq!(
+            texts(ansi, src, TokenKind::Keyword),
+            ["Select", "From", "Where", "Is", "Not"]
+        );
+        assert_eq!(
+            texts(ansi, src, TokenKind::NameBuiltin),
+            ["COUNT", "coalesce", "max", "current_timestamp"]
+        );
+        assert_eq!(texts(ansi, src, TokenKind::NameFunction), ["my_fn"]);
+        assert_eq!(texts(ansi, src, TokenKind::KeywordConstant), ["Null"]);
+        assert_eq!(texts(ansi, src, TokenKind::Name), ["a", "b", "c", "t", "x"]);
+    }
+
+    #[test]
+    fn strings_double_quotes_and_span_lines() {
+        let src = "select 'it''s', '', 'two\nlines', N'wide', 'a' || 'b'\n";
+        let ansi = SqlDialect::Ansi;
+        assert_eq!(
+            texts(ansi, src, TokenKind::String),
+            ["'it", "s'", "''", "'two\nlines'", "N'wide'", "'a'", "'b'"]
+        );
+        assert_eq!(texts(ansi, src, TokenKind::StringEscape), ["''"]);
+        assert_eq!(texts(ansi, src, TokenKind::Operator), ["||"]);
+        assert_eq!(
+            texts(SqlDialect::MySql, "select 'a\\'b', \"q\"\n", TokenKind::StringEscape),
+            ["\\'"]
+        );
+    }
+
+    #[test]
+    fn declared_names_are_classes_and_functions() {
+        let src = "CREATE TABLE IF NOT EXISTS public.users (id int REFERENCES \"Orgs\"(id));\nINSERT INTO t(a) VALUES (1);\nCREATE FUNCTION app.touch() RETURNS trigger;\n";
+        let ansi = SqlDialect::Ansi;
+        assert_eq!(texts(ansi, src, TokenKind::NameClass), ["users", "\"Orgs\"", "t"]);
+        assert_eq!(texts(ansi, src, TokenKind::NameFunction), ["touch"]);
+        assert_eq!(texts(ansi, src, TokenKind::Name), ["public", "id", "id", "a", "app"]);
+    }
+
+    #[test]
+    fn bind_parameters_are_variables() {
+        let src = "select * from t where a = $1 and b = :name and c = ? and d = ?2 and e = @p;\n";
+        assert_eq!(
+            texts(SqlDialect::Sqlite, src, TokenKind::NameVariable),
+            ["$1", ":name", "?", "?2", "@p"]
+        );
+        assert_eq!(
+            texts(SqlDialect::Ansi, src, TokenKind::NameVariable),
+            ["$1", ":name", "?", "?2"]
+        );
+        assert_eq!(
+            texts(
+                SqlDialect::TSql,
+                "SELECT @@ROWCOUNT, @id FROM #tmp;\n",
+                TokenKind::NameVariable
+            ),
+            ["@@ROWCOUNT", "@id"]
+        );
+    }
+
+    #[test]
+    fn postgres_dollar_quotes_casts_and_nested_comments() {
+        let src = "/* a /* b */ c */ select $fn$ body $x$ 'q' $fn$, $$x$$, '1'::regclass, x::app.mood, E'\\n';\n\\connect app\n";
+        let pg = SqlDialect::Postgres;
+        assert_eq!(texts(pg, src, TokenKind::Comment), ["/* a /* b */ c */"]);
+        assert_eq!(
+            texts(pg, src, TokenKind::String),
+            ["$fn$ body $x$ 'q' $fn$", "$$x$$", "'1'", "E'", "'"]
+        );
+        assert_eq!(texts(pg, src, TokenKind::KeywordType), ["regclass", "mood"]);
+        assert_eq!(texts(pg, src, TokenKind::StringEscape), ["\\n"]);
+        assert_eq!(texts(pg, src, TokenKind::CommentPreproc), ["\\connect app"]);
+
+        let ansi = kinds(SqlDialect::Ansi, "/* a /* b */ c */\n");
+        assert_eq!(ansi[0], (TokenKind::Comment, "/* a /* b */"));
+    }
+
+    #[test]
+    fn dollar_quoted_bodies_may_start_with_non_ascii() {
+        let pg = SqlDialect::Postgres;
+        assert_eq!(texts(pg, "SELECT $$é$$;\n", TokenKind::String), ["$$é$$"]);
+        let body = "CREATE FUNCTION f() RETURNS text AS $body$\n€ is $1$body$;\n";
+        assert_eq!(texts(pg, body, TokenKind::String), ["$body$\n€ is $1$body$"]);
+    }
+
+    #[test]
+    fn dialect_identifiers_and_comments() {
+        let mysql = "select `order`, \"text\" from t # note\n";
+        assert_eq!(texts(SqlDialect::MySql, mysql, TokenKind::Name), ["`order`", "t"]);
+        assert_eq!(texts(SqlDialect::MySql, mysql, TokenKind::String), ["\"text\""]);
+        assert_eq!(texts(SqlDialect::MySql, mysql, TokenKind::Comment), ["# note"]);
+
+        let tsql = "SELECT TOP 5 [first name] FROM [dbo].[People];\n";
+        assert_eq!(
+            texts(SqlDialect::TSql, tsql, TokenKind::Name),
+            ["[first name]", "[dbo]", "[People]"]
+        );
+        assert_eq!(
+            texts(SqlDialect::TSql, tsql, TokenKind::Keyword),
+            ["SELECT", "TOP", "FROM"]
+        );
+        assert_eq!(
+            texts(SqlDialect::Postgres, "select a[1];\n", TokenKind::Punctuation),
+            ["[", "];"]
+        );
+    }
+
+    #[test]
+    fn numbers_are_single_tokens() {
+        let src = "select 1, 1.5, .5, 6.02e23, 1e-3, 0xFF, 1_000;\n";
+        assert_eq!(
+            texts(SqlDialect::Ansi, src, TokenKind::Number),
+            ["1", "1.5", ".5", "6.02e23", "1e-3", "0xFF", "1_000"]
+        );
+    }
+
+    #[test]
+    fn aliases_select_dialects() {
+        for (alias, name) in [
+            ("sql", "SQL"),
+            ("postgres", "PostgreSQL"),
+            ("postgresql", "PostgreSQL"),
+            ("mysql", "MySQL"),
+            ("mariadb", "MySQL"),
+            ("sqlite", "SQLite"),
+            ("tsql", "T-SQL"),
+            ("mssql", "T-SQL"),
+        ] {
+            assert_eq!(find(alias).map(|lexer| lexer.name()), Some(name), "{alias}");
+        }
+    }
+
+    #[test]
+    fn dialect_samples_match_golden_tokens() {
+        assert_fixture_tokens(&Sql::new(SqlDialect::Postgres), "schema.sql");
+        assert_fixture_tokens(&Sql::new(SqlDialect::Sqlite), "migration.sql");
+    }
+}
@@ -0,0 +1,177 @@
+//! A lexer wrapper that fails on input the lexer can't classify instead of marking it.
+
+use super::{Lexer, LexerState};
+use crate::highlight::{CancelToken, HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+/// Wraps a lexer so that its first [TokenKind::Error] token fails lexing with [HighlightError::Unexpected].
+///
+/// Lexers recover from input they can't classify: they mark it as [TokenKind::Error], usually one character at a
+/// time, and carry on from the next character, which is what a highlighter wants. Validators and tests that would
+/// rather stop at such input wrap the lexer instead. Passing invalid UTF-8 through with
+/// [crate::highlight::InvalidUtf8::Pass] happens after lexing, so it never trips this.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{HighlightError, Lexer};
+/// use colorizer::highlight::lexers::{Go, Strict};
+///
+/// assert!(Go.tokenize("x := 1 @ 2\n").is_ok());
+/// let err = Strict::new(Go).tokenize("x := 1 @ 2\n").unwrap_err();
+/// assert!(matches!(err, HighlightError::Unexpected { offset: 7, found: '@' }));
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct Strict<L> {
+    lexer: L,
+}
+
+impl<L: Lexer> Strict<L> {
+    pub const fn new(lexer: L) -> Self {
+        Self { lexer }
+    }
+}
+
+impl<L: Lexer> Lexer for Strict<L> {
+    fn name(&self) -> &str {
+        self.lexer.name()
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(StrictState { inner: self.lexer.start() })
+    }
+
+    fn tokenize_cancellable(
+        &self, src: &str, cancel: &CancelToken, tokens: &mut Vec<Token>,
+    ) -> Result<(), HighlightError> {
+        // The wrapped lexer may tokenize whole documents its own way, so its tokens are checked afterwards.
+        let start = tokens.len();
+        self.lexer.tokenize_cancellable(src, cancel, tokens)?;
+        check(&tokens[start..], src, 0)
+    }
+}
+
+struct StrictState<'l> {
+    inner: Box<dyn LexerState + 'l>,
+}
+
+impl LexerState for StrictState<'_> {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        // The line's first token may have been merged into the last one before it.
+        let start = tokens.len().saturating_sub(1);
+        self.inner.tokenize_line(line, offset, tokens)?;
+        check(&tokens[start..], line, offset)
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        let inner = self.inner.snapshot()?;
+        Some(Box::new(StrictState { inner }))
+    }
+
+    // Both sides are strict states whose `as_any` is their inner state's, so the inner states compare directly.
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        self.inner.same_as(other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        self.inner.as_any()
+    }
+}
+
+/// Fails at the first [TokenKind::Error] token of `tokens` that falls within `text`, which starts at `offset`.
+fn check(tokens: &[Token], text: &str, offset: usize) -> Result<(), HighlightError> {
+    let Some(token) = tokens
+        .iter()
+        .find(|token| token.kind == TokenKind::Error && token.end > offset)
+    else {
+        return Ok(());
+    };
+    let at = token.start.max(offset);
+    let found = text[at - offset..]
+        .chars()
+        .next()
+        .expect("error tokens are never empty");
+    Err(HighlightError::Unexpected { offset: at, found })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::Incremental;
+    use crate::highlight::lexers::{Diff, Python, Rule, RuleLexer, RuleTable};
+
+    fn words() -> RuleLexer {
+        let table = RuleTable::new().with_state(
+            "root",
+            vec![
+                Rule::new(r"[a-z]+", TokenKind::Name),
+                Rule::new(r"\s+", TokenKind::Whitespace),
+            ],
+        );
+        RuleLexer::new("Words", &table).unwrap()
+    }
+
+    #[test]
+    fn strict_lexing_fails_where_recovery_would_mark_an_error() {
+        let src = "ok fine\nthen 42 no\n";
+        let tokens = words().tokenize(src).unwrap();
+        assert!(
+            tokens
+                .iter()
+                .any(|token| token.kind == TokenKind::Error && token.text(src) == "42")
+        );
+
+        let err = Strict::new(words()).tokenize(src).unwrap_err();
+        assert!(
+            matches!(err, HighlightError::Unexpected { offset: 13, found: '4' }),
+            "{err:?}"
+        );
+        assert_eq!(err.to_string(), "unexpected character '4' at byte 13");
+    }
+
+    #[test]
+    fn strict_lexing_passes_clean_input_through_unchanged() {
+        let src = "def f(x):\n    return x\n";
+        assert_eq!(
+            Strict::new(Python).tokenize(src).unwrap(),
+            Python.tokenize(src).unwrap()
+        );
+
+        let strict = Strict::new(Python);
+        assert!(strict.tokens(src).all(|token| token.is_ok()));
+    }
+
+    #[test]
+    fn strict_line_lexing_reports_absolute_offsets() {
+        let lexer = Strict::new(words());
+        let mut state = lexer.start();
+        let mut tokens = Vec::new();
+        state.tokenize_line("abc\n", 0, &mut tokens).unwrap();
+        let err = state.tokenize_line("de!f\n", 4, &mut tokens).unwrap_err();
+        assert!(
+            matches!(err, HighlightError::Unexpected { offset: 6, found: '!' }),
+            "{err:?}"
+        );
+    }
+
+    #[test]
+    fn strict_lexing_keeps_whole_document_tokenizing() {
+        // Inline changes are only found when the diff lexer sees the whole document.
+        let diff = Strict::new(Diff::new().with_inline_changes(true));
+        let tokens = diff.tokenize("@@ -1 +1 @@\n-let a = 1;\n+let a = 2;\n").unwrap();
+        assert!(
+            tokens
+                .iter()
+                .any(|token| token.kind == TokenKind::GenericInsertedChange)
+        );
+    }
+
+    #[test]
+    fn strict_states_support_incremental_relexing() {
+        let lexer = Strict::new(Python);
+        let mut doc = Incremental::new(&lexer, "a = 1\nb = 2\nc = 3\n").unwrap();
+        let changed = doc.edit(6..7, "bb").unwrap();
+        assert_eq!(changed, 1..2);
+    }
+}
@@ -0,0 +1,472 @@
+//! Stateful lexer for TOML documents.
+
+use super::{Cursor, Lexer, LexerState, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+/// Lexer for TOML 1.0.
+///
+/// Keys, bare or quoted, are [TokenKind::NameAttribute], and the names in `[table]` and `[[array]]` headers
+/// [TokenKind::NameClass]; the dots between the parts of a dotted key are [TokenKind::Punctuation]. Basic and literal
+/// strings, single-line or triple-quoted, are [TokenKind::String], with the escapes of basic strings (including a
+/// line-ending backslash) as [TokenKind::StringEscape].
+///
+/// Offset and local datetimes, dates, and times are [TokenKind::NumberDate]. Integers (with `_` separators and
+/// `0x`, `0o`, and `0b` prefixes) and floats (including `inf` and `nan`) are single [TokenKind::Number] tokens,
+/// `true` and `false` are [TokenKind::KeywordConstant], and a value that is none of these is an [TokenKind::Error].
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Toml;
+///
+/// let src = "[[bin]]\n\"name\" = \"shop\"\nsize = 1_000_000\nreleased = 1979-05-27T07:32:00Z\n";
+/// let tokens = Toml.tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("bin"), Some(TokenKind::NameClass));
+/// assert_eq!(kind("\"name\""), Some(TokenKind::NameAttribute));
+/// assert_eq!(kind("1_000_000"), Some(TokenKind::Number));
+/// assert_eq!(kind("1979-05-27T07:32:00Z"), Some(TokenKind::NumberDate));
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct Toml;
+
+impl Lexer for Toml {
+    fn name(&self) -> &str {
+        "TOML"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(TomlState { mode: Mode::Normal, groups: Vec::new(), key: true })
+    }
+}
+
+/// What the next characters continue.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Mode {
+    Normal,
+    /// Inside a `"""` string, or a `'''` one when `literal`.
+    Multiline {
+        literal: bool,
+    },
+}
+
+/// An open bracket in a value.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Group {
+    Array,
+    InlineTable,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct TomlState {
+    mode: Mode,
+    groups: Vec<Group>,
+    /// The next bare word or quoted string is a key rather than a value.
+    key: bool,
+}
+
+impl LexerState for TomlState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        if self.mode == Mode::Normal && self.groups.is_empty() {
+            self.key = true;
+        }
+        while cursor.pos < line.len() {
+            match self.mode {
+                Mode::Normal => self.normal(&mut cursor),
+                Mode::Multiline { literal } => multiline(&mut cursor, literal, &mut self.mode),
+            }
+        }
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl TomlState {
+    fn normal(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        let line_start = cursor.line[..cursor.pos].trim().is_empty();
+
+        if ch.is_whitespace() {
+            cursor.emit(TokenKind::Whitespace, cursor.run_until(|ch| !ch.is_whitespace()));
+            return;
+        }
+        match ch {
+            '#' => cursor.emit(TokenKind::Comment, cursor.run_until(|ch| ch == '\n')),
+            '[' if line_start && self.groups.is_empty() => header(cursor),
+            '=' => {
+                self.key = false;
+                cursor.emit(TokenKind::Operator, 1);
+            }
+            '.' if self.key => cursor.emit(TokenKind::Punctuation, 1),
+            '[' | '{' => {
+                self.groups
+                    .push(if ch == '[' { Group::Array } else { Group::InlineTable });
+                self.key = ch == '{';
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ']' | '}' => {
+                self.groups.pop();
+                self.key = false;
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ',' => {
+                self.key = self.groups.last() == Some(&Group::InlineTable);
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '"' | '\'' if self.key => {
+                let len = quoted_len(rest);
+                cursor.emit(TokenKind::NameAttribute, len);
+            }
+            '"' | '\'' => {
+                let literal = ch == '\'';
+                if rest.starts_with(if literal { "'''" } else { "\"\"\"" }) {
+                    cursor.emit(TokenKind::String, 3);
+                    self.mode = Mode::Multiline { literal };
+                } else {
+                    string(cursor, literal);
+                }
+            }
+            _ if self.key => {
+                let len = cursor.run_until(|ch| !bare_key(ch));
+                cursor.emit(TokenKind::NameAttribute, len.max(ch.len_utf8()));
+            }
+            _ => value(cursor),
+        }
+    }
+}
+
+/// Whether `ch` may appear in a bare key.
+fn bare_key(ch: char) -> bool {
+    ch.is_ascii_alphanumeric() || ch == '_' || ch == '-'
+}
+
+/// Lexes a `[table]` or `[[array]]` header up to its closing brackets.
+fn header(cursor: &mut Cursor) {
+    let open = if cursor.rest().starts_with("[[") { 2 } else { 1 };
+    cursor.emit(TokenKind::Punctuation, open);
+    while let Some(ch) = cursor.peek() {
+        let rest = cursor.rest();
+        match ch {
+            '\n' => return,
+            ']' => {
+                let close = if open == 2 && rest.starts_with("]]") { 2 } else { 1 };
+                cursor.emit(TokenKind::Punctuation, close);
+                return;
+            }
+            '.' => cursor.emit(TokenKind::Punctuation, 1),
+            '"' | '\'' => cursor.emit(TokenKind::NameClass, quoted_len(rest)),
+            _ if ch.is_whitespace() => cursor.emit(TokenKind::Whitespace, cursor.run_until(|ch| !ch.is_whitespace())),
+            _ if bare_key(ch) => cursor.emit(TokenKind::NameClass, cursor.run_until(|ch| !bare_key(ch))),
+            _ => cursor.emit(TokenKind::Error, ch.len_utf8()),
+        }
+    }
+}
+
+/// Length of the single-line quoted string `rest` starts with, up to its closing quote or the end of the line.
+fn quoted_len(rest: &str) -> usize {
+    let quote = rest.chars().next().expect("rest starts with a quote");
+    let mut chars = rest.char_indices().skip(1);
+    while let Some((at, ch)) = chars.next() {
+        match ch {
+            '\\' if quote == '"' => {
+                chars.next();
+            }
+            '\n' => return at,
+            _ if ch == quote => return at + 1,
+            _ => {}
+        }
+    }
+    rest.len()
+}
+
+/// Lexes a single-line string, which ends at its closing quote or, unterminated, at the end of the line.
+fn string(cursor: &mut Cursor, literal: bool) {
+    let quote = if literal { '\'' } else { '"' };
+    cursor.emit(TokenKind::String, 1);
+    loop {
+        match cursor.peek() {
+            None | Some('\n') => return,
+            Some(ch) if ch == quote => {
+                cursor.emit(TokenKind::String, 1);
+                return;
+            }
+            Some('\\') if !literal => emit_escape(cursor),
+            Some(_) => {
+                let len = cursor.run_until(|ch| ch == quote || ch == '\n' || (!literal && ch == '\\'));
+                cursor.emit(TokenKind::String, len);
+            }
+        }
+    }
+}
+
+/// Lexes the next piece of a triple-quoted string: text, an escape, or the closing quotes, which may be preceded by
+/// up to two quotes belonging to the content.
+fn multiline(cursor: &mut Cursor, literal: bool, mode: &mut Mode) {
+    let quote = if literal { '\'' } else { '"' };
+    let rest = cursor.rest();
+    match cursor.peek() {
+        Some(ch) if ch == quote => {
+            let run = rest.len() - rest.trim_start_matches(quote).len();
+            if run >= 3 {
+                cursor.emit(TokenKind::String, run.min(5));
+                *mode = Mode::Normal;
+            } else {
+                cursor.emit(TokenKind::String, run);
+            }
+        }
+        Some('\\') if !literal => {
+            // A backslash ending a line trims the line break and the whitespace that follows.
+            let trailing = rest[1..].trim_start_matches([' ', '\t']);
+            if trailing.starts_with(['\n', '\r']) {
+                cursor.emit(TokenKind::StringEscape, rest.len());
+            } else {
+                emit_escape(cursor);
+            }
+        }
+        _ => {
+            let len = cursor.run_until(|ch| ch == quote || (!literal && ch == '\\'));
+            cursor.emit(TokenKind::String, len);
+        }
+    }
+}
+
+/// Emits the escape sequence at the cursor, or the backslash and next character as an error.
+fn emit_escape(cursor: &mut Cursor) {
+    let rest = cursor.rest();
+    let digits = |len: usize| {
+        let digits = rest.get(2..2 + len)?;
+        digits.chars().all(|ch| ch.is_ascii_hexdigit()).then_some(2 + len)
+    };
+    let next = rest[1..].chars().next();
+    let len = match next {
+        Some('b' | 't' | 'n' | 'f' | 'r' | '"' | '\\') => Some(2),
+        Some('u') => digits(4),
+        Some('U') => digits(8),
+        _ => None,
+    };
+    match len {
+        Some(len) => cursor.emit(TokenKind::StringEscape, len),
+        None => cursor.emit(
+            TokenKind::Error,
+            1 + next.filter(|&ch| ch != '\n').map_or(0, char::len_utf8),
+        ),
+    }
+}
+
+/// Lexes a bare value: a datetime, number, or boolean.
+fn value(cursor: &mut Cursor) {
+    let rest = cursor.rest();
+    let end = |ch: char| ch.is_whitespace() || ",]}#".contains(ch);
+    let date = datetime_len(rest);
+    if date > 0 && rest[date..].chars().next().is_none_or(end) {
+        cursor.emit(TokenKind::NumberDate, date);
+        return;
+    }
+    let len = cursor.run_until(end);
+    let kind = match &rest[..len] {
+        "true" | "false" => TokenKind::KeywordConstant,
+        word if is_number(word) => TokenKind::Number,
+        _ => TokenKind::Error,
+    };
+    cursor.emit(kind, len);
+}
+
+/// Length of the RFC 3339 datetime, local date, or local time `rest` starts with, or 0.
+fn datetime_len(rest: &str) -> usize {
+    let bytes = rest.as_bytes();
+    let digits = |at: usize, len: usize| {
+        bytes
+            .get(at..at + len)
+            .is_some_and(|run| run.iter().all(u8::is_ascii_digit))
+    };
+    let byte = |at: usize| bytes.get(at).copied();
+    let time = |at: usize| {
+        let colon = |at: usize| byte(at) == Some(b':');
+        if !(digits(at, 2) && colon(at + 2) && digits(at + 3, 2) && colon(at + 5) && digits(at + 6, 2)) {
+            return None;
+        }
+        let mut end = at + 8;
+        if byte(end) == Some(b'.') && digits(end + 1, 1) {
+            end += 1;
+            while byte(end).is_some_and(|byte| byte.is_ascii_digit()) {
+                end += 1;
+            }
+        }
+        Some(end)
+    };
+
+    let date = digits(0, 4) && byte(4) == Some(b'-') && digits(5, 2) && byte(7) == Some(b'-') && digits(8, 2);
+    if !date {
+        return time(0).unwrap_or(0);
+    }
+    let Some(mut end) = matches!(byte(10), Some(b'T' | b't' | b' ')).then(|| time(11)).flatten() else {
+        return 10;
+    };
+    match byte(end) {
+        Some(b'Z' | b'z') => end += 1,
+        Some(b'+' | b'-') if digits(end + 1, 2) && byte(end + 3) == Some(b':') && digits(end + 4, 2) => end += 6,
+        _ => {}
+    }
+    end
+}
+
+/// Whether `word` is an integer or float, allowing `_` between digits.
+fn is_number(word: &str) -> bool {
+    let digits = |text: &str, radix: u32| {
+        text.starts_with(|ch: char| ch.is_digit(radix)) && text.chars().all(|ch| ch == '_' || ch.is_digit(radix))
+    };
+    for (prefix, radix) in [("0x", 16), ("0o", 8), ("0b", 2)] {
+        if let Some(rest) = word.strip_prefix(prefix) {
+            return digits(rest, radix);
+        }
+    }
+    let unsigned = word.strip_prefix(['+', '-']).unwrap_or(word);
+    if matches!(unsigned, "inf" | "nan") {
+        return true;
+    }
+    let (mantissa, exponent) = match unsigned.split_once(['e', 'E']) {
+        Some((mantissa, exponent)) => (mantissa, Some(exponent)),
+        None => (unsigned, None),
+    };
+    let mantissa = match mantissa.split_once('.') {
+        Some((whole, fraction)) => digits(whole, 10) && digits(fraction, 10),
+        None => digits(mantissa, 10),
+    };
+    mantissa && exponent.is_none_or(|exponent| digits(exponent.strip_prefix(['+', '-']).unwrap_or(exponent), 10))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::assert_fixture_tokens;
+
+    /// Non-whitespace tokens as (kind, text) pairs.
+    fn kinds(src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = Toml.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    fn texts(src: &str, kind: TokenKind) -> Vec<&str> {
+        kinds(src)
+            .into_iter()
+            .filter(|(k, _)| *k == kind)
+            .map(|(_, text)| text)
+            .collect()
+    }
+
+    #[test]
+    fn headers_and_dotted_keys() {
+        let src = "[package]\nname = \"x\"\n[[bin]]\n[ target.'cfg(unix)'.\"deps\" ] # t\nsite.\"google.com\" = true\n'lit key' . x = 1\n";
+        assert_eq!(
+            texts(src, TokenKind::NameClass),
+            ["package", "bin", "target", "'cfg(unix)'", "\"deps\""]
+        );
+        assert_eq!(
+            texts(src, TokenKind::NameAttribute),
+            ["name", "site", "\"google.com\"", "'lit key'", "x"]
+        );
+        assert_eq!(
+            texts(src, TokenKind::Punctuation),
+            ["[", "]", "[[", "]]", "[", ".", ".", "]", ".", "."]
+        );
+        assert_eq!(texts(src, TokenKind::String), ["\"x\""]);
+        assert_eq!(texts(src, TokenKind::Comment), ["# t"]);
+    }
+
+    #[test]
+    fn numbers_are_single_tokens() {
+        let src = "a = 1_000_000\nb = 0xDEAD_BEEF\nc = 0o755\nd = 0b1101\ne = +1.5e-3\nf = -inf\ng = nan\nh = 6.626e-34\ni = 1__\nj = true\nk = yes\n";
+        assert_eq!(
+            texts(src, TokenKind::Number),
+            [
+                "1_000_000",
+                "0xDEAD_BEEF",
+                "0o755",
+                "0b1101",
+                "+1.5e-3",
+                "-inf",
+                "nan",
+                "6.626e-34",
+                "1__"
+            ]
+        );
+        assert_eq!(texts(src, TokenKind::KeywordConstant), ["true"]);
+        assert_eq!(texts(src, TokenKind::Error), ["yes"]);
+    }
+
+    #[test]
+    fn datetimes_are_their_own_kind() {
+        let src = "odt = 1979-05-27T07:32:00Z\nspace = 1979-05-27 07:32:00.999-07:00\nldt = 1979-05-27t00:32:00\nld = 1979-05-27 # date\nlt = [07:32:00, 00:32:00.5]\nbad = 1979-05-27x\n";
+        assert_eq!(
+            texts(src, TokenKind::NumberDate),
+            [
+                "1979-05-27T07:32:00Z",
+                "1979-05-27 07:32:00.999-07:00",
+                "1979-05-27t00:32:00",
+                "1979-05-27",
+                "07:32:00",
+                "00:32:00.5",
+            ]
+        );
+        assert_eq!(texts(src, TokenKind::Error), ["1979-05-27x"]);
+    }
+
+    #[test]
+    fn multiline_strings_span_lines() {
+        let src = "a = \"\"\"\none \\\n   two \\t \\u00E9 \"\" \\q\"\"\"\"\"\nb = '''\n\\d '' ''''\nc = \"k = v\"\n";
+        assert_eq!(texts(src, TokenKind::StringEscape), ["\\\n", "\\t", "\\u00E9"]);
+        assert_eq!(texts(src, TokenKind::Error), ["\\q"]);
+        assert_eq!(
+            texts(src, TokenKind::String),
+            [
+                "\"\"\"\none ",
+                "   two ",
+                " ",
+                " \"\" ",
+                "\"\"\"\"\"",
+                "'''\n\\d '' ''''",
+                "\"k = v\""
+            ]
+        );
+        assert_eq!(texts(src, TokenKind::NameAttribute), ["a", "b", "c"]);
+    }
+
+    #[test]
+    fn inline_tables_and_arrays() {
+        let src = "deps = { serde = { version = \"1\", features = [\"derive\"] }, x.y = 2 }\nxs = [\n  1, # one\n  { a = 2 },\n  \"k\",\n]\nafter = 1\n";
+        assert_eq!(
+            texts(src, TokenKind::NameAttribute),
+            ["deps", "serde", "version", "features", "x", "y", "xs", "a", "after"]
+        );
+        assert_eq!(texts(src, TokenKind::String), ["\"1\"", "\"derive\"", "\"k\""]);
+        assert_eq!(texts(src, TokenKind::Number), ["2", "1", "2", "1"]);
+    }
+
+    #[test]
+    fn manifests_match_golden_tokens() {
+        assert_fixture_tokens(&Toml, "cargo.toml");
+        assert_fixture_tokens(&Toml, "pyproject.toml");
+    }
+}
@@ -0,0 +1,1528 @@
+//! Stateful lexer for TypeScript and TSX, and for JavaScript, which it reads as TypeScript without types.
+
+use super::html::entity_len;
+use super::{Cursor, Lexer, LexerState, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+const KEYWORDS: &[&str] = &[
+    "as",
+    "await",
+    "break",
+    "case",
+    "catch",
+    "continue",
+    "debugger",
+    "default",
+    "delete",
+    "do",
+    "else",
+    "export",
+    "extends",
+    "finally",
+    "for",
+    "if",
+    "implements",
+    "import",
+    "in",
+    "instanceof",
+    "new",
+    "return",
+    "super",
+    "switch",
+    "this",
+    "throw",
+    "try",
+    "typeof",
+    "void",
+    "while",
+    "with",
+    "yield",
+];
+
+/// Keywords that modify the declaration after them, and are ordinary names anywhere else (`get(key)`, `set = 1`).
+const MODIFIERS: &[&str] = &[
+    "abstract",
+    "accessor",
+    "async",
+    "declare",
+    "get",
+    "override",
+    "private",
+    "protected",
+    "public",
+    "readonly",
+    "set",
+    "static",
+];
+
+/// Words that are keywords in TypeScript but names in JavaScript, on top of `as` outside `import` and `export`.
+const TYPE_KEYWORDS: &[&str] = &[
+    "abstract",
+    "declare",
+    "enum",
+    "interface",
+    "module",
+    "namespace",
+    "override",
+    "private",
+    "protected",
+    "public",
+    "readonly",
+    "satisfies",
+    "type",
+];
+
+const CONSTANTS: &[&str] = &["true", "false", "null", "undefined", "NaN", "Infinity"];
+
+const TYPES: &[&str] = &[
+    "any", "bigint", "boolean", "never", "number", "object", "string", "symbol", "unknown", "void",
+];
+
+/// Keywords inside types, which take or relate other types rather than naming one.
+const TYPE_OPERATORS: &[&str] = &[
+    "abstract",
+    "as",
+    "asserts",
+    "extends",
+    "implements",
+    "in",
+    "infer",
+    "is",
+    "keyof",
+    "new",
+    "out",
+    "readonly",
+    "typeof",
+    "unique",
+];
+
+const BUILTINS: &[&str] = &[
+    "Array",
+    "ArrayBuffer",
+    "BigInt",
+    "Boolean",
+    "DataView",
+    "Date",
+    "Error",
+    "Function",
+    "Intl",
+    "JSON",
+    "Map",
+    "Math",
+    "Number",
+    "Object",
+    "Promise",
+    "Proxy",
+    "RangeError",
+    "Reflect",
+    "RegExp",
+    "Set",
+    "String",
+    "Symbol",
+    "TypeError",
+    "WeakMap",
+    "WeakRef",
+    "WeakSet",
+    "console",
+    "document",
+    "globalThis",
+    "isFinite",
+    "isNaN",
+    "parseFloat",
+    "parseInt",
+    "structuredClone",
+    "window",
+];
+
+/// Utility types from TypeScript's standard library, built in as far as types are concerned.
+const UTILITY_TYPES: &[&str] = &[
+    "Awaited",
+    "ConstructorParameters",
+    "Exclude",
+    "Extract",
+    "InstanceType",
+    "NonNullable",
+    "Omit",
+    "Parameters",
+    "Partial",
+    "Pick",
+    "Readonly",
+    "Record",
+    "Required",
+    "ReturnType",
+    "ThisType",
+];
+
+/// Operators, longest first.
+const OPERATORS: &[&str] = &[
+    ">>>=", "...", "===", "!==", "**=", "<<=", ">>=", ">>>", "&&=", "||=", "??=", "=>", "==", "!=", "<=", ">=", "&&",
+    "||", "??", "++", "--", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "**", "<<", ">>", "+", "-", "*", "/", "%",
+    "&", "|", "^", "~", "!", "<", ">", "=", "?",
+];
+
+/// Lexer for TypeScript, and with [TypeScript::tsx] for TSX.
+///
+/// Types are lexed as types wherever they appear: after a `:` annotation, `as`, or `satisfies`, in `type` aliases
+/// and `extends`/`implements` clauses, and between the angle brackets of type parameters and arguments
+/// (`useState<string | null>(null)`), which are told apart from `<` comparisons by what follows them. There,
+/// primitive types are [TokenKind::KeywordType], type operators such as `keyof` and `infer` [TokenKind::Keyword],
+/// other type names [TokenKind::NameClass], and angle brackets [TokenKind::Punctuation].
+///
+/// Template literals are [TokenKind::StringBacktick], with `${ }` interpolations lexed as code that may hold further
+/// templates. A `/` where an operand is expected starts a [TokenKind::StringRegex], and is a division anywhere else.
+/// Decorators are [TokenKind::NameAttribute], names declared by `function`, `class`, `interface`, `type`, and `enum`
+/// are [TokenKind::NameFunction] and [TokenKind::NameClass], and `?.`, `??`, and `!` non-null assertions are
+/// [TokenKind::Operator]s.
+///
+/// In TSX, a `<` where an operand is expected opens a JSX element. Tag names are [TokenKind::NameTag], attributes
+/// [TokenKind::NameAttribute] with [TokenKind::String] values, braces [TokenKind::Punctuation] around expressions
+/// that are lexed as code again, and children [TokenKind::Text] with character references as
+/// [TokenKind::StringEscape]. Fragments (`<>...</>`) and self-closing tags work, and elements nest inside
+/// expressions, templates included, inside elements to any depth.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::TypeScript;
+///
+/// let src = "const [n, setN] = useState<number>(0);\nconst el = <Button onClick={() => setN(n + 1)}>{n}</Button>;\n";
+/// let tokens = TypeScript::tsx().tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("useState"), Some(TokenKind::NameFunction));
+/// assert_eq!(kind("number"), Some(TokenKind::KeywordType));
+/// assert_eq!(kind("Button"), Some(TokenKind::NameTag));
+/// assert_eq!(kind("onClick"), Some(TokenKind::NameAttribute));
+/// ```
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub struct TypeScript {
+    jsx: bool,
+}
+
+impl TypeScript {
+    /// Creates a lexer for TypeScript.
+    pub const fn new() -> Self {
+        Self { jsx: false }
+    }
+
+    /// Creates a lexer for TSX, where `<` at the start of an expression opens a JSX element.
+    pub const fn tsx() -> Self {
+        Self { jsx: true }
+    }
+
+    /// Whether the lexer reads JSX elements.
+    pub const fn jsx(&self) -> bool {
+        self.jsx
+    }
+}
+
+impl Lexer for TypeScript {
+    fn name(&self) -> &str {
+        if self.jsx { "TSX" } else { "TypeScript" }
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(TypeScriptState::new(self.jsx, true))
+    }
+}
+
+/// Lexer for JavaScript, including JSX.
+///
+/// This is [TypeScript] without the type syntax: `:` never starts an annotation, `<` is a comparison unless it opens
+/// a JSX element, and `as`, `interface`, `type`, `enum`, and the TypeScript-only modifiers are ordinary names.
+/// Regular expressions, templates, and tagged templates are read the same way.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::JavaScript;
+///
+/// let src = "if (ok) return /fo+/gi.test(html`<b>${name}</b>`) ? a / b : 0;\n";
+/// let tokens = JavaScript.tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("/fo+/gi"), Some(TokenKind::StringRegex));
+/// assert_eq!(kind("html"), Some(TokenKind::NameFunction));
+/// assert_eq!(kind("name"), Some(TokenKind::Name));
+/// assert_eq!(kind("/"), Some(TokenKind::Operator));
+/// ```
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub struct JavaScript;
+
+impl Lexer for JavaScript {
+    fn name(&self) -> &str {
+        "JavaScript"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        // A `<` where an operand may start is never valid JavaScript outside JSX, so JSX is always on.
+        Box::new(TypeScriptState::new(true, false))
+    }
+}
+
+/// A nested construct the next characters continue, innermost last.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Context {
+    /// Inside `/* ... */`.
+    Comment,
+    /// A string continued onto the next line by a backslash.
+    String(char),
+    /// The text of a template literal.
+    Template,
+    /// Code in a template's `${ }` or a JSX `{ }`, which ends at the `}` that finds only `groups` brackets open.
+    Code {
+        groups: usize,
+    },
+    Type(Type),
+    /// A JSX tag, from its `<` to its `>`; `named` once its name has been read.
+    Tag {
+        closing: bool,
+        named: bool,
+    },
+    /// The children of a JSX element, up to its closing tag.
+    Children,
+}
+
+/// A type annotation, alias, or argument list.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct Type {
+    end: End,
+    /// Brackets opened inside the type.
+    depth: usize,
+    /// The last token completes a type, so a name or `{` after it is not part of the type.
+    complete: bool,
+    /// The last token was `)`, so `=>` continues a function type.
+    after_paren: bool,
+}
+
+impl Type {
+    fn new(end: End) -> Self {
+        Self { end, depth: 0, complete: false, after_paren: false }
+    }
+}
+
+/// What ends a type.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum End {
+    /// An annotation, `as` or `satisfies` type, or alias, which ends at `,`, `;`, `=`, or a closing bracket.
+    Annotation,
+    /// Type parameters or arguments, which end at the matching `>`.
+    Angle,
+    /// The `extends` and `implements` clauses of a class or interface, which end at its body.
+    Header,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum GroupKind {
+    Block,
+    /// An object literal, whose `:`s follow property names rather than start types.
+    Object,
+    Paren,
+    /// The parentheses after `for`, where `of` is a keyword.
+    ForHeader,
+    Bracket,
+}
+
+/// An open bracket, with the ternary `?`s inside it still waiting for their `:`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct Group {
+    kind: GroupKind,
+    ternaries: usize,
+}
+
+impl Group {
+    fn new(kind: GroupKind) -> Self {
+        Self { kind, ternaries: 0 }
+    }
+}
+
+/// The last significant token, as far as the meaning of a `:`, `<`, or `{` after it depends on it.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Last {
+    Other,
+    Name,
+    /// A name just declared by `function`, `class`, `interface`, or `type`, which may take type parameters.
+    Declared,
+    /// The `?` of an optional parameter or property.
+    Optional,
+    Close(char),
+    Arrow,
+    /// `else`, `try`, `finally`, or `do`, which a block follows.
+    BlockKeyword,
+    For,
+}
+
+/// What the next identifier may be.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Expect {
+    Nothing,
+    FuncName,
+    ClassName,
+    /// The class constructed by `new`.
+    New,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct TypeScriptState {
+    jsx: bool,
+    /// Types may be written, as in TypeScript but not JavaScript.
+    types: bool,
+    contexts: Vec<Context>,
+    /// Open brackets in code, outermost first; the first is the top level.
+    groups: Vec<Group>,
+    /// An operand may start here, so `/` opens a regular expression and, in TSX, `<` an element.
+    operand: bool,
+    /// A statement may start here, so `{` opens a block rather than an object literal.
+    statement: bool,
+    last: Last,
+    expect: Expect,
+    /// The last token was `.` or `?.`, so an identifier is a property.
+    after_dot: bool,
+    /// Between `class` or `interface` and its body, where `extends` and `implements` take types.
+    class_header: bool,
+    /// After `type Name`, whose `=` starts the aliased type.
+    type_alias: bool,
+    /// After `case`, whose `:` ends the label.
+    case_label: bool,
+    /// In an `import` or `export` clause, where `as` renames rather than casts.
+    module_clause: bool,
+    /// No line has been lexed yet, so one starting with `#!` is a shebang.
+    first_line: bool,
+}
+
+impl LexerState for TypeScriptState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        while cursor.pos < line.len() {
+            match self.contexts.last() {
+                Some(Context::Comment) => self.block_comment(&mut cursor),
+                Some(&Context::String(quote)) => self.string(&mut cursor, quote),
+                Some(Context::Template) => self.template(&mut cursor),
+                Some(&Context::Type(ty)) => self.types(&mut cursor, ty),
+                Some(&Context::Tag { closing, named }) => self.tag(&mut cursor, closing, named),
+                Some(Context::Children) => self.children(&mut cursor),
+                Some(Context::Code { .. }) | None => self.code(&mut cursor),
+            }
+        }
+        self.first_line = false;
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl TypeScriptState {
+    fn new(jsx: bool, types: bool) -> Self {
+        Self {
+            jsx,
+            types,
+            contexts: Vec::new(),
+            groups: vec![Group::new(GroupKind::Block)],
+            operand: true,
+            statement: true,
+            last: Last::Other,
+            expect: Expect::Nothing,
+            after_dot: false,
+            class_header: false,
+            type_alias: false,
+            case_label: false,
+            module_clause: false,
+            first_line: true,
+        }
+    }
+
+    /// Lexes whitespace or a comment, if the cursor is at one.
+    fn trivia(&mut self, cursor: &mut Cursor) -> bool {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        if ch.is_whitespace() {
+            let len = cursor.run_until(|ch| !ch.is_whitespace());
+            cursor.emit(TokenKind::Whitespace, len);
+        } else if rest.starts_with("//") || (rest.starts_with("#!") && self.first_line && cursor.pos == 0) {
+            // Triple-slash directives (`/// <reference types="node" />`) and shebangs are instructions to tools.
+            let directive = rest.starts_with("/// <") || rest.starts_with('#');
+            let len = cursor.run_until(|ch| ch == '\n');
+            cursor.emit(
+                if directive { TokenKind::CommentPreproc } else { TokenKind::Comment },
+                len,
+            );
+        } else if rest.starts_with("/*") {
+            cursor.emit(TokenKind::Comment, 2);
+            self.contexts.push(Context::Comment);
+        } else {
+            return false;
+        }
+        true
+    }
+
+    fn block_comment(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        match rest.find("*/") {
+            Some(end) => {
+                cursor.emit(TokenKind::Comment, end + 2);
+                self.contexts.pop();
+            }
+            None => cursor.emit(TokenKind::Comment, rest.len()),
+        }
+    }
+
+    /// Number of groups belonging to enclosing contexts, which code in this one can't close.
+    fn base(&self) -> usize {
+        self.contexts
+            .iter()
+            .rev()
+            .find_map(|context| match context {
+                Context::Code { groups } => Some(*groups),
+                _ => None,
+            })
+            .unwrap_or(1)
+    }
+
+    fn top_group(&mut self) -> &mut Group {
+        self.groups.last_mut().expect("the top level is never closed")
+    }
+
+    /// Lexes code, at the top level or inside an interpolation or JSX expression.
+    fn code(&mut self, cursor: &mut Cursor) {
+        if self.trivia(cursor) {
+            return;
+        }
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        if ch == '}' && self.contexts.last() == Some(&Context::Code { groups: self.groups.len() }) {
+            cursor.emit(TokenKind::Punctuation, 1);
+            self.contexts.pop();
+            return;
+        }
+        if is_ident_start(ch) {
+            self.word(cursor);
+            return;
+        }
+
+        let (operand, last) = (self.operand, self.last);
+        let statement = std::mem::take(&mut self.statement);
+        let expect = std::mem::replace(&mut self.expect, Expect::Nothing);
+        self.after_dot = false;
+        self.operand = true;
+        self.last = Last::Other;
+        match ch {
+            '\'' | '"' => {
+                cursor.emit(TokenKind::String, 1);
+                self.string(cursor, ch);
+                self.operand = false;
+            }
+            '`' => {
+                cursor.emit(TokenKind::StringBacktick, 1);
+                self.contexts.push(Context::Template);
+            }
+            '0'..='9' => {
+                cursor.emit(TokenKind::Number, number_len(rest));
+                self.operand = false;
+            }
+            '.' if rest[1..].starts_with(|ch: char| ch.is_ascii_digit()) => {
+                cursor.emit(TokenKind::Number, number_len(rest));
+                self.operand = false;
+            }
+            '#' if rest[1..].starts_with(is_ident_start) => {
+                cursor.emit(TokenKind::Name, 1 + ident_len(&rest[1..]));
+                self.operand = false;
+                self.last = Last::Name;
+            }
+            '@' if rest[1..].starts_with(is_ident_start) => {
+                let len = 1 + rest[1..]
+                    .find(|ch: char| !(ch == '.' || is_ident_char(ch)))
+                    .unwrap_or(rest.len() - 1);
+                cursor.emit(TokenKind::NameAttribute, len);
+            }
+            '<' => self.angle(cursor, operand, last),
+            '/' if operand && regex_len(rest).is_some() => {
+                cursor.emit(TokenKind::StringRegex, regex_len(rest).unwrap_or(1));
+                self.operand = false;
+            }
+            '{' => {
+                let kind = match last {
+                    Last::Arrow | Last::BlockKeyword => GroupKind::Block,
+                    _ if operand && !statement => GroupKind::Object,
+                    _ => GroupKind::Block,
+                };
+                self.groups.push(Group::new(kind));
+                self.statement = kind == GroupKind::Block;
+                self.class_header = false;
+                self.type_alias = false;
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '(' | '[' => {
+                let kind = match ch {
+                    '(' if last == Last::For => GroupKind::ForHeader,
+                    '(' => GroupKind::Paren,
+                    _ => GroupKind::Bracket,
+                };
+                self.groups.push(Group::new(kind));
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '}' | ')' | ']' => {
+                let closed = if self.groups.len() > self.base() { self.groups.pop() } else { None };
+                cursor.emit(TokenKind::Punctuation, 1);
+                self.operand = ch == '}' && closed.is_some_and(|group| group.kind == GroupKind::Block);
+                self.statement = self.operand;
+                self.last = Last::Close(ch);
+            }
+            ';' | ',' => {
+                if ch == ';' {
+                    self.statement = true;
+                    self.type_alias = false;
+                    self.case_label = false;
+                    self.module_clause = false;
+                }
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '.' if !rest.starts_with("...") => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                self.after_dot = true;
+                self.operand = false;
+            }
+            ':' => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                self.colon(last);
+            }
+            '?' => self.question(cursor),
+            '!' if !operand && !rest.starts_with("!=") => {
+                // A non-null assertion, which leaves an operand behind.
+                cursor.emit(TokenKind::Operator, 1);
+                self.operand = false;
+                self.last = Last::Name;
+            }
+            _ => match OPERATORS.iter().find(|operator| rest.starts_with(**operator)) {
+                Some(&operator) => {
+                    cursor.emit(TokenKind::Operator, operator.len());
+                    match operator {
+                        "=>" => self.last = Last::Arrow,
+                        "=" => {
+                            self.module_clause = false;
+                            if std::mem::take(&mut self.type_alias) {
+                                self.contexts.push(Context::Type(Type::new(End::Annotation)));
+                            }
+                        }
+                        // `x++` leaves an operand behind; `++x` still expects one.
+                        "++" | "--" => self.operand = operand,
+                        // `function*` still names a function next.
+                        "*" if expect == Expect::FuncName => self.expect = expect,
+                        _ => {}
+                    }
+                }
+                None => cursor.emit(TokenKind::Error, ch.len_utf8()),
+            },
+        }
+    }
+
+    /// Classifies an identifier or keyword.
+    fn word(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let len = ident_len(rest);
+        let word = &rest[..len];
+        let after = &rest[len..];
+        // A tag before a template is called with it, as in sql`SELECT ${id}`.
+        let call = after.starts_with(['(', '`']) || (self.types && generic_call(after));
+
+        let expect = std::mem::replace(&mut self.expect, Expect::Nothing);
+        self.statement = false;
+        let kind = if std::mem::take(&mut self.after_dot) {
+            self.operand = false;
+            self.last = Last::Name;
+            if call { TokenKind::NameFunction } else { TokenKind::Name }
+        } else if let Some(kind) = self.keyword(word, after) {
+            kind
+        } else {
+            self.operand = false;
+            self.last = Last::Name;
+            match expect {
+                Expect::FuncName | Expect::ClassName => {
+                    self.last = Last::Declared;
+                    if expect == Expect::FuncName { TokenKind::NameFunction } else { TokenKind::NameClass }
+                }
+                _ if BUILTINS.contains(&word) => TokenKind::NameBuiltin,
+                Expect::New => TokenKind::NameClass,
+                _ if call => TokenKind::NameFunction,
+                _ => TokenKind::Name,
+            }
+        };
+        cursor.emit(kind, len);
+    }
+
+    /// Classifies `word` if it is a keyword here, updating what the tokens after it mean.
+    fn keyword(&mut self, word: &str, after: &str) -> Option<TokenKind> {
+        let next = after.trim_start_matches([' ', '\t']);
+        let spaced = next.len() < after.len();
+        let names_next = spaced && next.starts_with(is_ident_start);
+        let after_operand = !self.operand;
+        if !self.types && (TYPE_KEYWORDS.contains(&word) || (word == "as" && !self.module_clause)) {
+            return self.not_keyword();
+        }
+
+        self.operand = true;
+        self.last = Last::Other;
+        let kind = match word {
+            _ if CONSTANTS.contains(&word) => {
+                self.operand = false;
+                TokenKind::KeywordConstant
+            }
+            "this" | "super" => {
+                self.operand = false;
+                self.last = Last::Name;
+                TokenKind::Keyword
+            }
+            "function" => {
+                self.expect = Expect::FuncName;
+                self.module_clause = false;
+                TokenKind::KeywordDeclaration
+            }
+            "class" => {
+                self.expect = Expect::ClassName;
+                self.class_header = true;
+                self.module_clause = false;
+                TokenKind::KeywordDeclaration
+            }
+            "interface" | "enum" if names_next => {
+                self.expect = Expect::ClassName;
+                self.class_header = word == "interface";
+                self.module_clause = false;
+                TokenKind::KeywordDeclaration
+            }
+            "type" if names_next => {
+                self.expect = Expect::ClassName;
+                self.type_alias = true;
+                TokenKind::KeywordDeclaration
+            }
+            // `import type { Props }` and `export type * from`.
+            "type" if spaced && next.starts_with(['{', '*']) => TokenKind::Keyword,
+            "namespace" | "module" if names_next || (spaced && next.starts_with(['"', '\''])) => {
+                self.expect = Expect::ClassName;
+                TokenKind::KeywordDeclaration
+            }
+            "const" | "let" | "var" => {
+                self.module_clause = false;
+                TokenKind::KeywordDeclaration
+            }
+            "new" => {
+                self.expect = Expect::New;
+                TokenKind::Keyword
+            }
+            "extends" | "implements" if self.class_header && self.types => {
+                self.contexts.push(Context::Type(Type::new(End::Header)));
+                TokenKind::Keyword
+            }
+            "as" | "satisfies" if after_operand && !self.module_clause => {
+                self.contexts.push(Context::Type(Type::new(End::Annotation)));
+                TokenKind::Keyword
+            }
+            "from" if spaced && next.starts_with(['"', '\'']) => {
+                self.module_clause = false;
+                TokenKind::Keyword
+            }
+            "of" if self
+                .groups
+                .last()
+                .is_some_and(|group| group.kind == GroupKind::ForHeader) =>
+            {
+                TokenKind::Keyword
+            }
+            "satisfies" | "from" | "of" => return self.not_keyword(),
+            "import" | "export" => {
+                self.module_clause = true;
+                TokenKind::Keyword
+            }
+            "default" => {
+                self.module_clause = false;
+                TokenKind::Keyword
+            }
+            "case" => {
+                self.case_label = true;
+                TokenKind::Keyword
+            }
+            "for" => {
+                self.last = Last::For;
+                TokenKind::Keyword
+            }
+            "else" | "try" | "finally" | "do" => {
+                self.last = Last::BlockKeyword;
+                TokenKind::Keyword
+            }
+            _ if MODIFIERS.contains(&word) => {
+                let modifies = spaced
+                    && (next.starts_with(|ch: char| is_ident_start(ch) || matches!(ch, '[' | '#' | '*'))
+                        || (word == "async" && next.starts_with('('))
+                        || (word == "static" && next.starts_with('{')));
+                if !modifies {
+                    return self.not_keyword();
+                }
+                TokenKind::Keyword
+            }
+            _ if KEYWORDS.contains(&word) => TokenKind::Keyword,
+            _ => return self.not_keyword(),
+        };
+        Some(kind)
+    }
+
+    /// Undoes the assumptions [Self::keyword] made, for a word that turned out to be a name.
+    fn not_keyword(&mut self) -> Option<TokenKind> {
+        self.operand = false;
+        self.last = Last::Name;
+        None
+    }
+
+    /// Lexes `<`: type parameters or arguments, a JSX element, or a comparison.
+    fn angle(&mut self, cursor: &mut Cursor, operand: bool, last: Last) {
+        let rest = cursor.rest();
+        let spaced = cursor.line[..cursor.pos].ends_with(char::is_whitespace) || cursor.pos == 0;
+        let types = self.types
+            && match last {
+                Last::Declared => true,
+                Last::Name if !spaced => generic_call(rest),
+                // `<T,>(x: T) => x` is a generic arrow function even in TSX.
+                _ if operand && self.jsx => generic_arrow(rest),
+                // `<T>(x: T) => x`, or an old-style `<Type>value` assertion.
+                _ => operand && generic_len(rest).is_some(),
+            };
+        if types {
+            cursor.emit(TokenKind::Punctuation, 1);
+            self.contexts.push(Context::Type(Type::new(End::Angle)));
+        } else if operand && self.jsx && rest[1..].starts_with(|ch: char| is_ident_start(ch) || ch == '>') {
+            cursor.emit(TokenKind::Punctuation, 1);
+            self.contexts.push(Context::Tag { closing: false, named: false });
+        } else {
+            let len = OPERATORS
+                .iter()
+                .find(|operator| rest.starts_with(**operator))
+                .map_or(1, |operator| operator.len());
+            cursor.emit(TokenKind::Operator, len);
+        }
+    }
+
+    /// Handles a `:`, which may end a ternary or a `case` label, or start a type annotation.
+    fn colon(&mut self, last: Last) {
+        let group = self.top_group();
+        if group.ternaries > 0 {
+            group.ternaries -= 1;
+            return;
+        }
+        let object = group.kind == GroupKind::Object;
+        if std::mem::take(&mut self.case_label) || !self.types {
+            return;
+        }
+        let annotation = match last {
+            // In an object literal, a name before `:` is a property, and so is a computed `[key]`.
+            Last::Name | Last::Optional | Last::Declared | Last::Close(']' | '}') => !object,
+            // A method's return type, in an object literal as anywhere else.
+            Last::Close(_) => true,
+            _ => false,
+        };
+        if annotation {
+            self.contexts.push(Context::Type(Type::new(End::Annotation)));
+        }
+    }
+
+    /// Lexes `?`: optional chaining, nullish coalescing, an optional marker, or a ternary.
+    fn question(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        if rest.starts_with("?.") && !rest[2..].starts_with(|ch: char| ch.is_ascii_digit()) {
+            cursor.emit(TokenKind::Operator, 2);
+            self.after_dot = true;
+            self.operand = false;
+        } else if rest.starts_with("??") {
+            cursor.emit(TokenKind::Operator, if rest.starts_with("??=") { 3 } else { 2 });
+        } else {
+            let next = rest[1..].trim_start_matches([' ', '\t']);
+            // An optional method (`load?(): void`) is written without a space, unlike a ternary before parentheses.
+            let method = rest[1..].starts_with('(');
+            if !self.types {
+                cursor.emit(TokenKind::Operator, 1);
+                self.top_group().ternaries += 1;
+            } else if method || next.starts_with([':', ')', ',']) || (next.starts_with('=') && !next.starts_with("=="))
+            {
+                cursor.emit(TokenKind::Punctuation, 1);
+                self.operand = false;
+                self.last = Last::Optional;
+            } else {
+                cursor.emit(TokenKind::Operator, 1);
+                self.top_group().ternaries += 1;
+            }
+        }
+    }
+
+    /// Lexes part of a type.
+    fn types(&mut self, cursor: &mut Cursor, mut ty: Type) {
+        if self.trivia(cursor) {
+            return;
+        }
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        if ty.depth == 0 && type_ends(ty, rest, ch) {
+            // The character after the type belongs to the code around it.
+            self.contexts.pop();
+            self.operand = false;
+            self.last = Last::Other;
+            return;
+        }
+        if ty.depth == 0 && ty.end == End::Angle && ch == '>' {
+            cursor.emit(TokenKind::Punctuation, 1);
+            self.contexts.pop();
+            self.operand = false;
+            self.last = Last::Close('>');
+            return;
+        }
+
+        ty.after_paren = false;
+        match ch {
+            _ if is_ident_start(ch) => {
+                let len = ident_len(rest);
+                let word = &rest[..len];
+                let after = &rest[len..];
+                let (kind, complete) = if after.starts_with(':') || after.starts_with("?:") || after.starts_with('.') {
+                    // A property or parameter name, or a namespace.
+                    (TokenKind::Name, false)
+                } else if TYPE_OPERATORS.contains(&word) {
+                    (TokenKind::Keyword, false)
+                } else if TYPES.contains(&word) {
+                    (TokenKind::KeywordType, true)
+                } else if CONSTANTS.contains(&word) {
+                    (TokenKind::KeywordConstant, true)
+                } else if word == "this" {
+                    (TokenKind::Keyword, true)
+                } else if BUILTINS.contains(&word) || UTILITY_TYPES.contains(&word) {
+                    (TokenKind::NameBuiltin, true)
+                } else {
+                    (TokenKind::NameClass, true)
+                };
+                cursor.emit(kind, len);
+                ty.complete = complete;
+            }
+            '\'' | '"' => {
+                cursor.emit(TokenKind::String, 1);
+                self.string(cursor, ch);
+                ty.complete = true;
+            }
+            '`' => {
+                cursor.emit(TokenKind::StringBacktick, 1);
+                ty.complete = true;
+                *self.contexts.last_mut().expect("in a type") = Context::Type(ty);
+                self.contexts.push(Context::Template);
+                return;
+            }
+            '0'..='9' => {
+                cursor.emit(TokenKind::Number, number_len(rest));
+                ty.complete = true;
+            }
+            '(' | '[' | '{' | '<' => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                ty.depth += 1;
+                ty.complete = false;
+            }
+            ')' | ']' | '}' | '>' => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                ty.depth = ty.depth.saturating_sub(1);
+                ty.complete = true;
+                ty.after_paren = ch == ')';
+            }
+            ':' | ',' | ';' | '.' if !rest.starts_with("...") => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                ty.complete = false;
+            }
+            _ => {
+                match OPERATORS.iter().find(|operator| rest.starts_with(**operator)) {
+                    Some(operator) => cursor.emit(TokenKind::Operator, operator.len()),
+                    None => cursor.emit(TokenKind::Error, ch.len_utf8()),
+                }
+                ty.complete = false;
+            }
+        }
+        if let Some(Context::Type(current)) = self.contexts.last_mut() {
+            *current = ty;
+        }
+    }
+
+    /// Lexes the rest of a string literal, which may continue onto the next line after a backslash.
+    fn string(&mut self, cursor: &mut Cursor, quote: char) {
+        let continued = self.contexts.last() == Some(&Context::String(quote));
+        loop {
+            let rest = cursor.rest();
+            match cursor.peek() {
+                None => return,
+                Some('\n') => {
+                    // Unterminated; the string ends with the line.
+                    if continued {
+                        self.contexts.pop();
+                    }
+                    return;
+                }
+                Some(ch) if ch == quote => {
+                    cursor.emit(TokenKind::String, 1);
+                    if continued {
+                        self.contexts.pop();
+                    }
+                    return;
+                }
+                Some('\\') => {
+                    let len = escape_len(rest);
+                    cursor.emit(TokenKind::StringEscape, len);
+                    if rest[..len].ends_with('\n') {
+                        if !continued {
+                            self.contexts.push(Context::String(quote));
+                        }
+                        return;
+                    }
+                }
+                Some(_) => {
+                    let len = rest.find([quote, '\\', '\n']).unwrap_or(rest.len());
+                    cursor.emit(TokenKind::String, len);
+                }
+            }
+        }
+    }
+
+    fn template(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        match cursor.peek() {
+            Some('`') => {
+                cursor.emit(TokenKind::StringBacktick, 1);
+                self.contexts.pop();
+                self.operand = false;
+                self.last = Last::Other;
+            }
+            Some('\\') => cursor.emit(TokenKind::StringEscape, escape_len(rest)),
+            Some('$') if rest.starts_with("${") => {
+                cursor.emit(TokenKind::Punctuation, 2);
+                self.contexts.push(Context::Code { groups: self.groups.len() });
+                self.operand = true;
+                self.last = Last::Other;
+            }
+            Some(ch) => {
+                let first = ch.len_utf8();
+                let len = rest[first..].find(['`', '\\', '$']).map_or(rest.len(), |i| i + first);
+                cursor.emit(TokenKind::StringBacktick, len);
+            }
+            None => {}
+        }
+    }
+
+    /// Opens a JSX `{ }` expression.
+    fn jsx_expression(&mut self, cursor: &mut Cursor) {
+        cursor.emit(TokenKind::Punctuation, 1);
+        self.contexts.push(Context::Code { groups: self.groups.len() });
+        self.operand = true;
+        self.last = Last::Other;
+    }
+
+    /// Ends a JSX element; one that isn't another element's child is an operand in the code around it.
+    fn element_end(&mut self) {
+        if self.contexts.last() != Some(&Context::Children) {
+            self.operand = false;
+            self.last = Last::Other;
+        }
+    }
+
+    fn tag(&mut self, cursor: &mut Cursor, closing: bool, named: bool) {
+        if self.trivia(cursor) {
+            return;
+        }
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        match ch {
+            '>' => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                self.contexts.pop();
+                if closing {
+                    self.element_end();
+                } else {
+                    self.contexts.push(Context::Children);
+                }
+            }
+            '/' if rest.starts_with("/>") => {
+                cursor.emit(TokenKind::Punctuation, 2);
+                self.contexts.pop();
+                self.element_end();
+            }
+            '{' => self.jsx_expression(cursor),
+            '"' | '\'' => {
+                let len = rest[1..].find(ch).map_or(rest.len(), |close| close + 2);
+                cursor.emit(TokenKind::String, len);
+            }
+            '=' => cursor.emit(TokenKind::Operator, 1),
+            _ if is_ident_start(ch) => {
+                let len = cursor.run_until(|ch| !(is_ident_char(ch) || matches!(ch, '-' | ':' | '.')));
+                if named {
+                    cursor.emit(TokenKind::NameAttribute, len);
+                } else {
+                    cursor.emit(TokenKind::NameTag, len);
+                    self.contexts.pop();
+                    self.contexts.push(Context::Tag { closing, named: true });
+                }
+            }
+            _ => cursor.emit(TokenKind::Punctuation, ch.len_utf8()),
+        }
+    }
+
+    fn children(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        match ch {
+            '{' => self.jsx_expression(cursor),
+            '<' if rest.starts_with("</") => {
+                cursor.emit(TokenKind::Punctuation, 2);
+                self.contexts.pop();
+                self.contexts.push(Context::Tag { closing: true, named: false });
+            }
+            '<' => {
+                cursor.emit(TokenKind::Punctuation, 1);
+                self.contexts.push(Context::Tag { closing: false, named: false });
+            }
+            _ if ch.is_whitespace() => {
+                let len = cursor.run_until(|ch| !ch.is_whitespace());
+                cursor.emit(TokenKind::Whitespace, len);
+            }
+            '&' => match entity_len(rest) {
+                Some(len) => cursor.emit(TokenKind::StringEscape, len),
+                None => cursor.emit(TokenKind::Text, 1),
+            },
+            _ => {
+                let len = cursor.run_until(|ch| ch.is_whitespace() || matches!(ch, '{' | '<' | '&'));
+                cursor.emit(TokenKind::Text, len);
+            }
+        }
+    }
+}
+
+/// Whether the code around a type resumes at `ch`, the first character after the type at its top level.
+fn type_ends(ty: Type, rest: &str, ch: char) -> bool {
+    match (ty.end, ch) {
+        (_, ')' | ']' | '}') => true,
+        (End::Angle, _) => false,
+        (End::Header, ',') => false,
+        (_, '{') => ty.complete,
+        (End::Annotation, ',' | ';' | '>') => true,
+        // `=>` after a parameter list continues a function type; after anything else it ends an arrow function's
+        // return type.
+        (End::Annotation, '=') => !rest.starts_with("=>") || (ty.complete && !ty.after_paren),
+        _ if is_ident_start(ch) => ty.complete && !TYPE_OPERATORS.contains(&&rest[..ident_len(rest)]),
+        _ => false,
+    }
+}
+
+fn is_ident_start(ch: char) -> bool {
+    ch == '_' || ch == '$' || ch.is_alphabetic()
+}
+
+fn is_ident_char(ch: char) -> bool {
+    ch == '_' || ch == '$' || ch.is_alphanumeric()
+}
+
+fn ident_len(text: &str) -> usize {
+    text.find(|ch: char| !is_ident_char(ch)).unwrap_or(text.len())
+}
+
+/// Length of the `<...>` type argument list `text` starts with, judged by the characters types are made of, or
+/// `None` when it looks like a comparison instead.
+fn generic_len(text: &str) -> Option<usize> {
+    let mut depth = 0;
+    let mut chars = text.char_indices();
+    while let Some((i, ch)) = chars.next() {
+        match ch {
+            '<' => depth += 1,
+            '>' => {
+                depth -= 1;
+                if depth == 0 {
+                    return Some(i + 1);
+                }
+            }
+            // The arrow of a function type.
+            '=' if text[i + 1..].starts_with('>') => {
+                chars.next();
+            }
+            '&' | '|' if text[i + 1..].starts_with(ch) => return None,
+            _ if is_ident_char(ch) || " \t.,[]|&'\":?{}();".contains(ch) => {}
+            _ => return None,
+        }
+    }
+    None
+}
+
+/// Whether `text` starts with type arguments to a call (`useState<string>(...)`) or tagged template.
+fn generic_call(text: &str) -> bool {
+    text.starts_with('<') && generic_len(text).is_some_and(|len| text[len..].starts_with(['(', '`']))
+}
+
+/// Whether `text` starts the type parameters of a generic arrow function in TSX, which `<T,>` and
+/// `<T extends U>` tell apart from an element.
+fn generic_arrow(text: &str) -> bool {
+    let name = ident_len(&text[1..]);
+    let after = &text[1 + name..];
+    name > 0 && (after.starts_with(',') || after.starts_with(" extends "))
+}
+
+/// Length of the regular expression literal `rest` starts with, or `None` if no `/` closes it on this line.
+fn regex_len(rest: &str) -> Option<usize> {
+    let mut class = false;
+    let mut chars = rest.char_indices().skip(1);
+    while let Some((i, ch)) = chars.next() {
+        match ch {
+            '\n' => return None,
+            '\\' => {
+                chars.next();
+            }
+            '[' => class = true,
+            ']' => class = false,
+            '/' if !class => {
+                let flags = rest[i + 1..]
+                    .find(|ch: char| !ch.is_ascii_alphabetic())
+                    .unwrap_or(rest.len() - i - 1);
+                return Some(i + 1 + flags);
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+/// Length of the escape sequence `rest` starts with, including a line continuation's newline.
+fn escape_len(rest: &str) -> usize {
+    let hex = |len: usize| {
+        let digits = rest.get(2..2 + len)?;
+        digits.bytes().all(|byte| byte.is_ascii_hexdigit()).then_some(2 + len)
+    };
+    match rest[1..].chars().next() {
+        None => 1,
+        Some('x') => hex(2).unwrap_or(2),
+        Some('u') if rest[2..].starts_with('{') => rest.find('}').map_or(2, |close| close + 1),
+        Some('u') => hex(4).unwrap_or(2),
+        Some('\r') if rest[2..].starts_with('\n') => 3,
+        Some(ch) => 1 + ch.len_utf8(),
+    }
+}
+
+/// Length of the number literal `rest` starts with: decimal, hex, octal, or binary, with digit separators and an
+/// optional `n` for a bigint.
+fn number_len(rest: &str) -> usize {
+    let bytes = rest.as_bytes();
+    let hex = rest.starts_with("0x") || rest.starts_with("0X");
+    let mut len = 0;
+    while let Some(&byte) = bytes.get(len) {
+        let exponent_sign = matches!(byte, b'+' | b'-') && len > 0 && !hex && matches!(bytes[len - 1], b'e' | b'E');
+        if byte.is_ascii_alphanumeric()
+            || byte == b'_'
+            || exponent_sign
+            || (byte == b'.' && !rest[len..].starts_with(".."))
+        {
+            len += 1;
+        } else {
+            break;
+        }
+    }
+    len
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::assert_fixture_tokens;
+
+    fn kinds(lexer: impl Lexer, src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = lexer.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    #[test]
+    fn annotations_and_generics_are_types() {
+        let src = "function first<T>(items: readonly T[], fallback?: T): T | undefined {\n  return items.length < 1 ? fallback : items[0];\n}\n";
+        let tokens = kinds(TypeScript::new(), src);
+        assert_eq!(tokens[1], (TokenKind::NameFunction, "first"));
+        assert_eq!(tokens[2], (TokenKind::Punctuation, "<"));
+        assert_eq!(tokens[3], (TokenKind::NameClass, "T"));
+        assert!(tokens.contains(&(TokenKind::Keyword, "readonly")));
+        assert!(tokens.contains(&(TokenKind::Punctuation, "?:")));
+        assert!(tokens.contains(&(TokenKind::KeywordConstant, "undefined")));
+        assert!(tokens.contains(&(TokenKind::Operator, "<")));
+        assert!(tokens.contains(&(TokenKind::Operator, "?")));
+        assert!(tokens.contains(&(TokenKind::Name, "fallback")));
+        assert!(!tokens.contains(&(TokenKind::NameClass, "fallback")));
+    }
+
+    #[test]
+    fn type_arguments_are_told_apart_from_comparisons() {
+        let src = "const map = new Map<string, number[]>();\nconst ok = a < b && c > d;\nconst s = useState<Record<string, boolean>>({});\nconst x = y as unknown as Props;\n";
+        let tokens = kinds(TypeScript::new(), src);
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "Map")));
+        assert!(tokens.contains(&(TokenKind::KeywordType, "string")));
+        assert!(tokens.contains(&(TokenKind::Operator, "<")));
+        assert!(tokens.contains(&(TokenKind::Operator, ">")));
+        assert!(tokens.contains(&(TokenKind::Name, "b")));
+        assert!(tokens.contains(&(TokenKind::NameFunction, "useState")));
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "Record")));
+        assert!(tokens.contains(&(TokenKind::KeywordType, "unknown")));
+        assert!(tokens.contains(&(TokenKind::NameClass, "Props")));
+    }
+
+    #[test]
+    fn declarations_name_types() {
+        let src = "export type Id = string | number;\ninterface User<T> extends Base<T> { id: Id; tags?: string[] }\nenum Color { Red }\nimport type { Props } from \"./props\";\n";
+        let tokens = kinds(TypeScript::new(), src);
+        assert_eq!(tokens[1], (TokenKind::KeywordDeclaration, "type"));
+        assert_eq!(tokens[2], (TokenKind::NameClass, "Id"));
+        assert!(tokens.contains(&(TokenKind::KeywordType, "number")));
+        assert!(tokens.contains(&(TokenKind::KeywordDeclaration, "interface")));
+        assert!(tokens.contains(&(TokenKind::NameClass, "User")));
+        assert!(tokens.contains(&(TokenKind::NameClass, "Base")));
+        assert!(tokens.contains(&(TokenKind::Name, "tags")));
+        assert!(tokens.contains(&(TokenKind::NameClass, "Color")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "type")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "from")));
+    }
+
+    #[test]
+    fn regex_follows_operators_and_division_follows_operands() {
+        let src =
+            "const re = /[/]+\\d/g, half = total / 2 / count;\nif (ok) return (a) / b;\nconst m = s.match(/x/)!;\n";
+        let tokens = kinds(TypeScript::new(), src);
+        assert!(tokens.contains(&(TokenKind::StringRegex, "/[/]+\\d/g")));
+        assert_eq!(
+            tokens
+                .iter()
+                .filter(|token| *token == &(TokenKind::Operator, "/"))
+                .count(),
+            3
+        );
+        assert!(tokens.contains(&(TokenKind::StringRegex, "/x/")));
+        assert!(tokens.contains(&(TokenKind::Operator, "!")));
+    }
+
+    #[test]
+    fn statement_braces_are_blocks_before_a_regex() {
+        let src = "{}/foo/g\nx = {}/foo/g\nreturn /[a-z/]+/.test(s) && typeof /x/ == (/y/i)\n";
+        let tokens = kinds(JavaScript, src);
+        let regexes: Vec<&str> = tokens
+            .iter()
+            .filter(|(kind, _)| *kind == TokenKind::StringRegex)
+            .map(|&(_, text)| text)
+            .collect();
+        assert_eq!(regexes, ["/foo/g", "/[a-z/]+/", "/x/", "/y/i"]);
+        // After an object literal, the same characters are two divisions.
+        assert_eq!(
+            tokens[2..8],
+            [
+                (TokenKind::Name, "x"),
+                (TokenKind::Operator, "="),
+                (TokenKind::Punctuation, "{}"),
+                (TokenKind::Operator, "/"),
+                (TokenKind::Name, "foo"),
+                (TokenKind::Operator, "/"),
+            ]
+        );
+    }
+
+    #[test]
+    fn tagged_templates_call_their_tag() {
+        let src = "const q = sql`SELECT ${id} FROM ${t(`x${y}`)}`, r = String.raw`\\n`;\n";
+        let tokens = kinds(JavaScript, src);
+        assert!(tokens.contains(&(TokenKind::NameFunction, "sql")));
+        assert!(tokens.contains(&(TokenKind::NameFunction, "raw")));
+        assert!(tokens.contains(&(TokenKind::Name, "id")));
+        assert!(tokens.contains(&(TokenKind::Name, "y")));
+        assert!(tokens.contains(&(TokenKind::StringBacktick, "`x")));
+    }
+
+    #[test]
+    fn javascript_reads_type_syntax_as_expressions() {
+        let src = "const a = b < c > (d);\nlet type = x ? y : z;\nf({ as: 1 }, interface as readonly);\nmodule.exports = { Id };\nimport { a as b } from \"m\";\n";
+        let tokens = kinds(JavaScript, src);
+        assert!(tokens.contains(&(TokenKind::Operator, "<")));
+        assert!(tokens.contains(&(TokenKind::Operator, ">")));
+        for name in ["type", "z", "interface", "readonly", "module", "Id"] {
+            assert!(tokens.contains(&(TokenKind::Name, name)), "{name}");
+        }
+        assert_eq!(
+            tokens.iter().filter(|&&token| token == (TokenKind::Name, "as")).count(),
+            2
+        );
+        assert!(tokens.contains(&(TokenKind::Keyword, "as")));
+    }
+
+    #[test]
+    fn templates_interpolate_nested_expressions() {
+        let src = "const s = `a ${list.map((x) => `<${x}>`).join(\"\\n\")} \\u{1F600} b`;\n";
+        let tokens = kinds(TypeScript::new(), src);
+        assert!(tokens.contains(&(TokenKind::StringBacktick, "`a ")));
+        assert!(tokens.contains(&(TokenKind::Punctuation, "${")));
+        assert!(tokens.contains(&(TokenKind::NameFunction, "map")));
+        assert!(tokens.contains(&(TokenKind::StringBacktick, "`<")));
+        assert!(tokens.contains(&(TokenKind::StringEscape, "\\n")));
+        assert!(tokens.contains(&(TokenKind::StringEscape, "\\u{1F600}")));
+        assert_eq!(tokens[tokens.len() - 2], (TokenKind::StringBacktick, " b`"));
+        assert_eq!(tokens.last(), Some(&(TokenKind::Punctuation, ";")));
+    }
+
+    #[test]
+    fn template_text_may_start_with_a_wide_character() {
+        let src = "const s = `${a}é${b}😀`;\n";
+        let tokens = kinds(TypeScript::new(), src);
+        assert!(tokens.contains(&(TokenKind::StringBacktick, "é")));
+        assert!(tokens.contains(&(TokenKind::StringBacktick, "😀`")));
+    }
+
+    #[test]
+    fn optional_chaining_and_nullish_coalescing() {
+        let src = "const n = user?.profile?.name ?? user?.[key] ?? cb?.(1) ?? (flag ? .5 : 0);\n";
+        let tokens = kinds(TypeScript::new(), src);
+        assert_eq!(
+            tokens
+                .iter()
+                .filter(|token| *token == &(TokenKind::Operator, "?."))
+                .count(),
+            4
+        );
+        assert!(tokens.contains(&(TokenKind::Name, "profile")));
+        assert!(tokens.contains(&(TokenKind::Operator, "??")));
+        assert!(tokens.contains(&(TokenKind::Operator, "?")));
+        assert!(tokens.contains(&(TokenKind::Number, ".5")));
+    }
+
+    #[test]
+    fn classes_decorators_and_modifiers() {
+        let src = "@Component({ selector: \"app\" })\nexport class App extends Base implements OnInit {\n  @Input() private readonly name: string = \"\";\n  #count = 0n;\n  static get = 1;\n  async load(): Promise<void> { await this.get(); }\n}\n";
+        let tokens = kinds(TypeScript::new(), src);
+        assert_eq!(tokens[0], (TokenKind::NameAttribute, "@Component"));
+        assert!(tokens.contains(&(TokenKind::Name, "selector")));
+        assert!(tokens.contains(&(TokenKind::NameClass, "App")));
+        assert!(tokens.contains(&(TokenKind::NameClass, "OnInit")));
+        assert!(tokens.contains(&(TokenKind::NameAttribute, "@Input")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "private")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "readonly")));
+        assert!(tokens.contains(&(TokenKind::Name, "#count")));
+        assert!(tokens.contains(&(TokenKind::Number, "0n")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "static")));
+        assert!(tokens.contains(&(TokenKind::Name, "get")));
+        assert!(tokens.contains(&(TokenKind::Keyword, "async")));
+        assert!(tokens.contains(&(TokenKind::NameBuiltin, "Promise")));
+        assert!(tokens.contains(&(TokenKind::KeywordType, "void")));
+    }
+
+    #[test]
+    fn jsx_tags_attributes_and_expressions() {
+        let src = "const el = <Foo.Bar title=\"hi\" count={n + 1} {...rest} data-x='y'>hello &amp; {name}</Foo.Bar>;\n";
+        let tokens = kinds(TypeScript::tsx(), src);
+        assert!(tokens.contains(&(TokenKind::NameTag, "Foo.Bar")));
+        assert!(tokens.contains(&(TokenKind::NameAttribute, "title")));
+        assert!(tokens.contains(&(TokenKind::String, "\"hi\"")));
+        assert!(tokens.contains(&(TokenKind::Number, "1")));
+        assert!(tokens.contains(&(TokenKind::Operator, "...")));
+        assert!(tokens.contains(&(TokenKind::NameAttribute, "data-x")));
+        assert!(tokens.contains(&(TokenKind::String, "'y'")));
+        assert!(tokens.contains(&(TokenKind::Text, "hello")));
+        assert!(tokens.contains(&(TokenKind::StringEscape, "&amp;")));
+        assert!(tokens.contains(&(TokenKind::Name, "name")));
+        assert!(tokens.contains(&(TokenKind::Punctuation, "}</")));
+        assert_eq!(tokens.last(), Some(&(TokenKind::Punctuation, ">;")));
+    }
+
+    #[test]
+    fn fragments_and_self_closing_tags() {
+        let src = "return (\n  <>\n    <Input value={v} />\n    <br/>\n  </>\n) / 2;\n";
+        let tokens = kinds(TypeScript::tsx(), src);
+        assert!(tokens.contains(&(TokenKind::NameTag, "Input")));
+        assert!(tokens.contains(&(TokenKind::NameTag, "br")));
+        assert_eq!(
+            tokens
+                .iter()
+                .filter(|token| *token == &(TokenKind::Punctuation, "/>"))
+                .count(),
+            2
+        );
+        assert!(tokens.contains(&(TokenKind::Punctuation, "</>")));
+        assert!(tokens.contains(&(TokenKind::Operator, "/")));
+    }
+
+    #[test]
+    fn jsx_nests_inside_templates_inside_jsx() {
+        let src = "<p>{`${ok ? <b>{`${n}!`}</b> : \"none\"} left`}</p>;\n";
+        let tokens = kinds(TypeScript::tsx(), src);
+        assert!(tokens.contains(&(TokenKind::NameTag, "p")));
+        assert!(tokens.contains(&(TokenKind::NameTag, "b")));
+        assert!(tokens.contains(&(TokenKind::StringBacktick, "!`")));
+        assert!(tokens.contains(&(TokenKind::String, "\"none\"")));
+        assert!(tokens.contains(&(TokenKind::StringBacktick, " left`")));
+        assert_eq!(tokens.last(), Some(&(TokenKind::Punctuation, ">;")));
+    }
+
+    #[test]
+    fn tsx_keeps_generic_arrows_and_comparisons() {
+        let src = "const id = <T,>(x: T) => x;\nconst lt = a <b;\n";
+        let tokens = kinds(TypeScript::tsx(), src);
+        assert_eq!(tokens[4], (TokenKind::NameClass, "T"));
+        assert!(!tokens.iter().any(|token| token.0 == TokenKind::NameTag));
+        assert!(tokens.contains(&(TokenKind::Operator, "<")));
+        let tokens = kinds(TypeScript::new(), "const n = <number>value;\n");
+        assert!(tokens.contains(&(TokenKind::KeywordType, "number")));
+    }
+
+    #[test]
+    fn states_compare_across_multi_line_constructs() {
+        let lexer = TypeScript::tsx();
+        let mut state = lexer.start();
+        state.tokenize_line("const el = <div>\n", 0, &mut Vec::new()).unwrap();
+        let copy = state.snapshot().unwrap();
+        assert!(copy.same_as(&*state));
+        state.tokenize_line("  {`${\n", 0, &mut Vec::new()).unwrap();
+        assert!(!copy.same_as(&*state));
+        let mut tokens = Vec::new();
+        state.tokenize_line("  1}`}</div>;\n", 0, &mut tokens).unwrap();
+        assert!(tokens.iter().any(|token| token.kind == TokenKind::NameTag));
+        assert!(!copy.same_as(&*state));
+        // Back at the top level, as after any first line.
+        let mut fresh = lexer.start();
+        fresh.tokenize_line("\n", 0, &mut Vec::new()).unwrap();
+        assert!(state.same_as(&*fresh));
+    }
+
+    #[test]
+    fn only_the_first_line_is_a_shebang() {
+        let src = "#!/usr/bin/env node\nlet x = 1;\n#!x\n";
+        let tokens = kinds(TypeScript::new(), src);
+        assert_eq!(tokens[0], (TokenKind::CommentPreproc, "#!/usr/bin/env node"));
+        assert_eq!(
+            tokens
+                .iter()
+                .filter(|&&(kind, _)| kind == TokenKind::CommentPreproc)
+                .count(),
+            1
+        );
+
+        // Incremental relexing hands each line over at offset 0, which mustn't make it look like the first.
+        let lexer = TypeScript::new();
+        let mut doc = crate::highlight::Incremental::new(&lexer, src).unwrap();
+        doc.edit(src.len() - 2..src.len() - 1, "y").unwrap();
+        assert_eq!(
+            doc.tokens_for_lines(0..doc.line_count()),
+            lexer.tokenize(doc.source()).unwrap()
+        );
+    }
+
+    #[test]
+    fn react_component_matches_golden_tokens() {
+        assert_fixture_tokens(&TypeScript::tsx(), "component.tsx");
+    }
+}
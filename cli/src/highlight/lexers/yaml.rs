@@ -0,0 +1,464 @@
+//! Stateful lexer for YAML documents.
+
+use super::{Cursor, Lexer, LexerState, same_state};
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+use std::any::Any;
+
+/// Lexer for YAML.
+///
+/// Mapping keys, plain or quoted, are [TokenKind::NameTag]; a colon inside a key's quotes doesn't end it. Plain
+/// values are typed the way the YAML 1.2 core schema resolves them: `null`, `~`, `true`, and `false` are
+/// [TokenKind::KeywordConstant], integers and floats (including `0x1F`, `1e3`, and `.inf`) [TokenKind::Number], and
+/// everything else [TokenKind::String], so `count: 3` and `count: "3"` highlight differently. Double-quoted escapes
+/// and the `''` of single-quoted strings are [TokenKind::StringEscape].
+///
+/// Anchors (`&name`) are [TokenKind::NameLabel], aliases (`*name`) [TokenKind::NameVariable], tags (`!!str`)
+/// [TokenKind::KeywordType], and `%` directives [TokenKind::CommentPreproc]. Indicators (`-`, `?`, `:`, the brackets
+/// and commas of flow collections, `|` and `>` with their chomping and indentation indicators) and the `---` and
+/// `...` document markers are [TokenKind::Punctuation].
+///
+/// The body of a literal or folded block scalar is [TokenKind::String] however it looks: it runs for as long as
+/// lines are indented past the key or `-` that introduced it, and `#` inside it starts no comment.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{Lexer, TokenKind};
+/// use colorizer::highlight::lexers::Yaml;
+///
+/// let src = "count: 3\nname: \"3\"\nscript: |\n  echo # not a comment\n";
+/// let tokens = Yaml.tokenize(src).unwrap();
+/// let kind = |text: &str| tokens.iter().find(|token| token.text(src) == text).map(|token| token.kind);
+/// assert_eq!(kind("count"), Some(TokenKind::NameTag));
+/// assert_eq!(kind("3"), Some(TokenKind::Number));
+/// assert_eq!(kind("\"3\""), Some(TokenKind::String));
+/// assert_eq!(kind("echo # not a comment"), Some(TokenKind::String));
+/// ```
+#[derive(Debug, Clone, Copy, Default)]
+pub struct Yaml;
+
+impl Lexer for Yaml {
+    fn name(&self) -> &str {
+        "YAML"
+    }
+
+    fn start(&self) -> Box<dyn LexerState + '_> {
+        Box::new(YamlState { mode: Mode::Normal, flow: 0, node: None })
+    }
+}
+
+/// What the next characters continue.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Mode {
+    Normal,
+    /// Inside a quoted scalar that continues past the end of a line.
+    Quoted(char),
+    /// After a `|` or `>` indicator, whose content is the following lines indented past `parent`, the column of the
+    /// key or `-` it belongs to. `indent` is the content's indentation once the first line fixes it.
+    Scalar {
+        parent: Option<usize>,
+        indent: Option<usize>,
+    },
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct YamlState {
+    mode: Mode,
+    /// Open flow collections (`[` and `{`), inside which commas and brackets end plain scalars.
+    flow: usize,
+    /// Column of the last key or `-` on the current line.
+    node: Option<usize>,
+}
+
+impl LexerState for YamlState {
+    fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+        let mut cursor = Cursor { line, offset, pos: 0, tokens };
+        self.node = None;
+        if let Mode::Scalar { parent, indent } = self.mode
+            && self.scalar_line(&mut cursor, parent, indent)
+        {
+            return Ok(());
+        }
+        while cursor.pos < line.len() {
+            match self.mode {
+                Mode::Quoted(quote) => self.quoted(&mut cursor, quote),
+                Mode::Normal | Mode::Scalar { .. } => self.normal(&mut cursor),
+            }
+        }
+        Ok(())
+    }
+
+    fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+        Some(Box::new(self.clone()))
+    }
+
+    fn same_as(&self, other: &dyn LexerState) -> bool {
+        same_state(self, other)
+    }
+
+    fn as_any(&self) -> Option<&dyn Any> {
+        Some(self)
+    }
+}
+
+impl YamlState {
+    /// Lexes a whole line as block scalar content, or returns false (leaving the scalar) when the line is indented too
+    /// little to belong to it. Blank lines always belong.
+    fn scalar_line(&mut self, cursor: &mut Cursor, parent: Option<usize>, indent: Option<usize>) -> bool {
+        let line = cursor.rest();
+        let spaces = line.len() - line.trim_start_matches(' ').len();
+        let text = line[spaces..].trim_end_matches(['\n', '\r']);
+        if text.is_empty() {
+            cursor.emit(TokenKind::Whitespace, line.len());
+            return true;
+        }
+        let indent = indent.unwrap_or(spaces);
+        let least = parent.map_or(0, |parent| parent + 1);
+        if spaces < indent.max(least) || (spaces == 0 && document_marker(line)) {
+            self.mode = Mode::Normal;
+            return false;
+        }
+        self.mode = Mode::Scalar { parent, indent: Some(indent) };
+        cursor.emit(TokenKind::Whitespace, indent);
+        cursor.emit(TokenKind::String, spaces - indent + text.len());
+        cursor.emit(TokenKind::Whitespace, line.len() - spaces - text.len());
+        true
+    }
+
+    fn normal(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let ch = cursor.peek().expect("cursor is before the end of the line");
+        let flow = self.flow > 0;
+        // Indicators only count when whitespace (or, in a flow collection, a comma or bracket) follows them.
+        let separated = |len: usize| {
+            rest[len..]
+                .chars()
+                .next()
+                .is_none_or(|next| next.is_whitespace() || (flow && ",[]{}".contains(next)))
+        };
+
+        if ch.is_whitespace() {
+            cursor.emit(TokenKind::Whitespace, cursor.run_until(|ch| !ch.is_whitespace()));
+            return;
+        }
+        if cursor.pos == 0 && !flow {
+            if document_marker(rest) {
+                cursor.emit(TokenKind::Punctuation, 3);
+                return;
+            }
+            if ch == '%' {
+                cursor.emit(TokenKind::CommentPreproc, cursor.run_until(|ch| ch == '\n'));
+                return;
+            }
+        }
+        match ch {
+            '#' => cursor.emit(TokenKind::Comment, cursor.run_until(|ch| ch == '\n')),
+            '-' | '?' | ':' if separated(1) => {
+                if ch == '-' {
+                    self.node = Some(cursor.pos);
+                }
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            '[' | '{' => {
+                self.flow += 1;
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ']' | '}' => {
+                self.flow = self.flow.saturating_sub(1);
+                cursor.emit(TokenKind::Punctuation, 1);
+            }
+            ',' if flow => cursor.emit(TokenKind::Punctuation, 1),
+            '&' | '*' | '!' => {
+                let len = cursor.run_until(|ch| ch.is_whitespace() || (flow && ",[]{}".contains(ch)));
+                let kind = match ch {
+                    '&' => TokenKind::NameLabel,
+                    '*' => TokenKind::NameVariable,
+                    _ => TokenKind::KeywordType,
+                };
+                cursor.emit(kind, len);
+            }
+            '|' | '>' if !flow => {
+                let len = 1 + rest[1..]
+                    .find(|ch: char| !matches!(ch, '+' | '-' | '1'..='9'))
+                    .unwrap_or(rest.len() - 1);
+                // An explicit indentation indicator counts from the owning node's column.
+                let explicit = rest[1..len].chars().find_map(|ch| ch.to_digit(10));
+                let indent = explicit.map(|digit| self.node.unwrap_or(0) + digit as usize);
+                self.mode = Mode::Scalar { parent: self.node, indent };
+                cursor.emit(TokenKind::Punctuation, len);
+            }
+            '"' | '\'' => self.open_quoted(cursor, ch),
+            _ => self.plain(cursor),
+        }
+    }
+
+    /// Whether `after`, the text following a scalar, makes that scalar a mapping key.
+    fn key_follows(&self, after: &str) -> bool {
+        let after = after.trim_start_matches([' ', '\t']);
+        let Some(value) = after.strip_prefix(':') else {
+            return false;
+        };
+        self.flow > 0 || value.chars().next().is_none_or(char::is_whitespace)
+    }
+
+    /// Lexes a plain (unquoted) scalar as a key or a typed value.
+    fn plain(&mut self, cursor: &mut Cursor) {
+        let rest = cursor.rest();
+        let flow = self.flow > 0;
+        let mut end = 0;
+        for (at, ch) in rest.char_indices() {
+            let next = rest[at + ch.len_utf8()..].chars().next();
+            let stop = match ch {
+                '\n' => true,
+                ':' => next.is_none_or(|next| next.is_whitespace() || (flow && ",[]{}".contains(next))),
+                '#' => rest[..at].ends_with([' ', '\t']),
+                ',' | '[' | ']' | '{' | '}' => flow,
+                _ => false,
+            };
+            if stop {
+                break;
+            }
+            if !ch.is_whitespace() {
+                end = at + ch.len_utf8();
+            }
+        }
+        let kind = if self.key_follows(&rest[end..]) {
+            self.node = Some(cursor.pos);
+            TokenKind::NameTag
+        } else {
+            scalar_kind(&rest[..end])
+        };
+        cursor.emit(kind, end);
+    }
+
+    /// Lexes a quoted scalar that closes on this line and is a key, or enters a quoted value.
+    fn open_quoted(&mut self, cursor: &mut Cursor, quote: char) {
+        let rest = cursor.rest();
+        if let Some(len) = quoted_len(rest, quote)
+            && self.key_follows(&rest[len..])
+        {
+            self.node = Some(cursor.pos);
+            cursor.emit(TokenKind::NameTag, len);
+            return;
+        }
+        cursor.emit(TokenKind::String, 1);
+        self.mode = Mode::Quoted(quote);
+    }
+
+    /// Lexes the next piece of a quoted value: text, an escape, or the closing quote.
+    fn quoted(&mut self, cursor: &mut Cursor, quote: char) {
+        let rest = cursor.rest();
+        match cursor.peek() {
+            Some('\'') if quote == '\'' && rest[1..].starts_with('\'') => cursor.emit(TokenKind::StringEscape, 2),
+            Some(ch) if ch == quote => {
+                cursor.emit(TokenKind::String, 1);
+                self.mode = Mode::Normal;
+            }
+            Some('\\') if quote == '"' => emit_escape(cursor),
+            _ => {
+                let len = cursor.run_until(|ch| ch == quote || (quote == '"' && ch == '\\'));
+                cursor.emit(TokenKind::String, len);
+            }
+        }
+    }
+}
+
+/// Whether `line` starts with a `---` or `...` document marker.
+fn document_marker(line: &str) -> bool {
+    (line.starts_with("---") || line.starts_with("...")) && line[3..].chars().next().is_none_or(char::is_whitespace)
+}
+
+/// Length of the quoted scalar `rest` starts with, including its quotes, when it closes on this line.
+fn quoted_len(rest: &str, quote: char) -> Option<usize> {
+    let mut chars = rest.char_indices().skip(1);
+    while let Some((at, ch)) = chars.next() {
+        match ch {
+            '\\' if quote == '"' => {
+                chars.next();
+            }
+            '\'' if quote == '\'' && rest[at + 1..].starts_with('\'') => {
+                chars.next();
+            }
+            _ if ch == quote => return Some(at + 1),
+            '\n' => return None,
+            _ => {}
+        }
+    }
+    None
+}
+
+/// Emits the double-quoted escape sequence at the cursor, or the backslash and next character as an error.
+fn emit_escape(cursor: &mut Cursor) {
+    let rest = cursor.rest();
+    let digits = |len: usize| {
+        let digits = rest.get(2..2 + len)?;
+        digits.chars().all(|ch| ch.is_ascii_hexdigit()).then_some(2 + len)
+    };
+    let next = rest[1..].chars().next();
+    let len = match next {
+        Some('x') => digits(2),
+        Some('u') => digits(4),
+        Some('U') => digits(8),
+        Some('\r') if rest[2..].starts_with('\n') => Some(3),
+        Some('0' | 'a' | 'b' | 't' | '\t' | 'n' | 'v' | 'f' | 'r' | 'e' | ' ' | '"' | '/' | '\\' | 'N' | '_' | 'L')
+        | Some('P' | '\n') => Some(2),
+        _ => None,
+    };
+    match len {
+        Some(len) => cursor.emit(TokenKind::StringEscape, len),
+        None => cursor.emit(
+            TokenKind::Error,
+            1 + next.filter(|&ch| ch != '\n').map_or(0, char::len_utf8),
+        ),
+    }
+}
+
+/// How the core schema resolves a plain scalar: null, boolean, number, or string.
+fn scalar_kind(text: &str) -> TokenKind {
+    match text {
+        "~" | "null" | "Null" | "NULL" | "true" | "True" | "TRUE" | "false" | "False" | "FALSE" => {
+            TokenKind::KeywordConstant
+        }
+        _ if is_number(text) => TokenKind::Number,
+        _ => TokenKind::String,
+    }
+}
+
+fn is_number(text: &str) -> bool {
+    let digits = |text: &str, radix: u32| text.chars().all(|ch| ch.is_digit(radix));
+    if let Some(hex) = text.strip_prefix("0x") {
+        return !hex.is_empty() && digits(hex, 16);
+    }
+    if let Some(octal) = text.strip_prefix("0o") {
+        return !octal.is_empty() && digits(octal, 8);
+    }
+    if matches!(text, ".nan" | ".NaN" | ".NAN") {
+        return true;
+    }
+    let unsigned = text.strip_prefix(['-', '+']).unwrap_or(text);
+    if matches!(unsigned, ".inf" | ".Inf" | ".INF") {
+        return true;
+    }
+    let (mantissa, exponent) = match unsigned.split_once(['e', 'E']) {
+        Some((mantissa, exponent)) => (mantissa, Some(exponent)),
+        None => (unsigned, None),
+    };
+    let mantissa = match mantissa.split_once('.') {
+        Some((whole, fraction)) => {
+            !(whole.is_empty() && fraction.is_empty()) && digits(whole, 10) && digits(fraction, 10)
+        }
+        None => !mantissa.is_empty() && digits(mantissa, 10),
+    };
+    let exponent = exponent.is_none_or(|exponent| {
+        let exponent = exponent.strip_prefix(['-', '+']).unwrap_or(exponent);
+        !exponent.is_empty() && digits(exponent, 10)
+    });
+    mantissa && exponent
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::lexers::assert_fixture_tokens;
+
+    /// Non-whitespace tokens as (kind, text) pairs.
+    fn kinds(src: &str) -> Vec<(TokenKind, &str)> {
+        let tokens = Yaml.tokenize(src).unwrap();
+        let rebuilt: String = tokens.iter().map(|token| token.text(src)).collect();
+        assert_eq!(rebuilt, src);
+        tokens
+            .into_iter()
+            .filter(|token| token.kind != TokenKind::Whitespace)
+            .map(|token| (token.kind, token.text(src)))
+            .collect()
+    }
+
+    fn texts(src: &str, kind: TokenKind) -> Vec<&str> {
+        kinds(src)
+            .into_iter()
+            .filter(|(k, _)| *k == kind)
+            .map(|(_, text)| text)
+            .collect()
+    }
+
+    #[test]
+    fn values_get_the_type_the_core_schema_resolves() {
+        let src = "count: 3\nquoted: \"3\"\nok: true\nnothing: ~\nratios: [-1.5e3, .5, 0x1F, .inf, 1.2.3]\nport: 8080:80\nurl: http://x:1/y # port 1\nname: yes\n";
+        assert_eq!(
+            texts(src, TokenKind::NameTag),
+            ["count", "quoted", "ok", "nothing", "ratios", "port", "url", "name"]
+        );
+        assert_eq!(texts(src, TokenKind::Number), ["3", "-1.5e3", ".5", "0x1F", ".inf"]);
+        assert_eq!(texts(src, TokenKind::KeywordConstant), ["true", "~"]);
+        assert_eq!(
+            texts(src, TokenKind::String),
+            ["\"3\"", "1.2.3", "8080:80", "http://x:1/y", "yes"]
+        );
+        assert_eq!(texts(src, TokenKind::Comment), ["# port 1"]);
+    }
+
+    #[test]
+    fn block_scalars_are_governed_by_indentation() {
+        let src = "data:\n  script: |\n    if x; then\n      # still content\n\n    fi\n  folded: >-  # a comment\n    one\n    two\n  after: 1\nlist:\n  - |2\n     keep leading\n  - x\n";
+        assert_eq!(
+            texts(src, TokenKind::String),
+            [
+                "if x; then",
+                "  # still content",
+                "fi",
+                "one",
+                "two",
+                " keep leading",
+                "x"
+            ]
+        );
+        assert_eq!(texts(src, TokenKind::Comment), ["# a comment"]);
+        assert_eq!(
+            texts(src, TokenKind::NameTag),
+            ["data", "script", "folded", "after", "list"]
+        );
+        assert!(texts(src, TokenKind::Punctuation).contains(&">-"));
+        assert!(texts(src, TokenKind::Punctuation).contains(&"|2"));
+    }
+
+    #[test]
+    fn quoted_keys_may_contain_colons() {
+        let src = "\"a: b\": 1\n'it''s': 'x: y'\n\"multi\n  line \\u00e9\": 2\n";
+        assert_eq!(texts(src, TokenKind::NameTag), ["\"a: b\"", "'it''s'"]);
+        assert_eq!(texts(src, TokenKind::String), ["'x: y'", "\"multi\n  line ", "\""]);
+        assert_eq!(texts(src, TokenKind::StringEscape), ["\\u00e9"]);
+        assert_eq!(texts("k: \"bad \\q\"", TokenKind::Error), ["\\q"]);
+    }
+
+    #[test]
+    fn anchors_aliases_tags_and_flow_collections() {
+        let src = "base: &base {a: 1, b: [x, \"y\"]}\nother: *base\nmerged:\n  <<: *base\nv: !!str 3\n";
+        assert_eq!(texts(src, TokenKind::NameLabel), ["&base"]);
+        assert_eq!(texts(src, TokenKind::NameVariable), ["*base", "*base"]);
+        assert_eq!(texts(src, TokenKind::KeywordType), ["!!str"]);
+        assert_eq!(
+            texts(src, TokenKind::NameTag),
+            ["base", "a", "b", "other", "merged", "<<", "v"]
+        );
+        assert_eq!(texts(src, TokenKind::String), ["x", "\"y\""]);
+    }
+
+    #[test]
+    fn multi_document_streams() {
+        let src = "%YAML 1.2\n---\na: 1\n...\n--- |\n  text\n---\nb: -\n";
+        assert_eq!(texts(src, TokenKind::CommentPreproc), ["%YAML 1.2"]);
+        assert_eq!(
+            texts(src, TokenKind::Punctuation),
+            ["---", ":", "...", "---", "|", "---", ":", "-"]
+        );
+        assert_eq!(texts(src, TokenKind::String), ["text"]);
+        assert_eq!(texts(src, TokenKind::NameTag), ["a", "b"]);
+    }
+
+    #[test]
+    fn manifests_match_golden_tokens() {
+        assert_fixture_tokens(&Yaml, "deployment.yaml");
+        assert_fixture_tokens(&Yaml, "docker-compose.yml");
+    }
+}
@@ -0,0 +1,231 @@
+//! Highlighting into lines of styled segments, for terminal UIs that lay out and draw text themselves.
+
+use super::formatters::{RESET, sgr_escape};
+use super::{HighlightError, Lexer, Style, Theme};
+use crate::terminal::ColorProfile;
+
+/// A run of text within one line, with the style it is drawn in.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Segment {
+    /// Never contains a line break.
+    pub text: String,
+    /// Fully resolved, as [Theme::style_for] returns it.
+    pub style: Style,
+}
+
+/// One line of highlighted text: its segments in order, without the line ending.
+///
+/// Neighboring tokens drawn in the same style share a segment, and an empty line has no segments.
+#[derive(Debug, Clone, PartialEq, Eq, Default)]
+pub struct StyledLine {
+    pub segments: Vec<Segment>,
+}
+
+impl StyledLine {
+    /// Returns the line's text without styles.
+    pub fn text(&self) -> String {
+        self.segments.iter().map(|segment| segment.text.as_str()).collect()
+    }
+
+    /// Returns `true` for a line with no text.
+    pub fn is_empty(&self) -> bool {
+        self.segments.is_empty()
+    }
+
+    /// Renders the line with SGR escapes for `profile`, each segment closed by a reset, so a terminal UI can draw a
+    /// single visible line without formatting the document.
+    ///
+    /// Backgrounds appear only where a style sets one; the rest is left to the UI. [ColorProfile::NoColor] gives the
+    /// plain text.
+    pub fn ansi(&self, profile: ColorProfile) -> String {
+        let mut out = String::with_capacity(self.segments.iter().map(|segment| segment.text.len() + 24).sum());
+        for segment in &self.segments {
+            match sgr_escape(profile, segment.style) {
+                Some(escape) => {
+                    out.push_str(&escape);
+                    out.push_str(&segment.text);
+                    out.push_str(RESET);
+                }
+                None => out.push_str(&segment.text),
+            }
+        }
+        out
+    }
+
+    fn push(&mut self, text: &str, style: Style) {
+        if text.is_empty() {
+            return;
+        }
+        match self.segments.last_mut() {
+            Some(last) if last.style == style => last.text.push_str(text),
+            _ => self.segments.push(Segment { text: text.to_string(), style }),
+        }
+    }
+
+    /// Drops the carriage return of a CRLF line ending.
+    fn end(&mut self) {
+        if let Some(last) = self.segments.last_mut()
+            && last.text.ends_with('\r')
+        {
+            last.text.pop();
+            if last.text.is_empty() {
+                self.segments.pop();
+            }
+        }
+    }
+}
+
+/// Appends `text` in `style` to the last of `lines`, starting a new line at every line break.
+pub(crate) fn push_text(lines: &mut Vec<StyledLine>, text: &str, style: Style) {
+    let mut pieces = text.split('\n');
+    let last = lines.last_mut().expect("there is always a line to append to");
+    last.push(pieces.next().unwrap_or(""), style);
+    for piece in pieces {
+        lines.last_mut().expect("a line was just appended to").end();
+        let mut line = StyledLine::default();
+        line.push(piece, style);
+        lines.push(line);
+    }
+}
+
+/// Tokenizes `src` with `lexer` and splits the result into one [StyledLine] per line, styled with `theme`.
+///
+/// Tokens that span lines are split at each line break, so no segment contains one, and every line of `src` gets an
+/// entry, empty lines included: `src.split('\n')` and the result line up index for index, as do the lines of an
+/// [super::Incremental] document, whose [super::Incremental::styled_lines] gives the same lines for part of it.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::lexers::Rust;
+/// use colorizer::highlight::{Theme, TokenKind, highlight_lines};
+///
+/// let mut theme = Theme::new("plain", Srgb8::new(0, 0, 0), Srgb8::new(0xcc, 0xcc, 0xcc));
+/// theme.set(TokenKind::Comment, Srgb8::new(0x80, 0x80, 0x80));
+/// let lines = highlight_lines("/* one\n\ntwo */ x\n", &Rust, &theme).unwrap();
+/// assert_eq!(lines.len(), 4);
+/// assert_eq!(lines[0].segments[0].text, "/* one");
+/// assert!(lines[1].is_empty());
+/// assert_eq!(lines[2].segments[0].text, "two */");
+/// assert_eq!(lines[2].segments[0].style.foreground, Some(Srgb8::new(0x80, 0x80, 0x80)));
+/// ```
+pub fn highlight_lines(src: &str, lexer: &dyn Lexer, theme: &Theme) -> Result<Vec<StyledLine>, HighlightError> {
+    let tokens = lexer.tokenize(src)?;
+    let mut lines = vec![StyledLine::default()];
+    for token in &tokens {
+        push_text(&mut lines, token.text(src), theme.style_for(token.kind));
+    }
+    Ok(lines)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::lexers::Rust;
+    use crate::highlight::{Incremental, TokenKind};
+
+    use std::time::Instant;
+
+    fn theme() -> Theme {
+        let mut theme = Theme::new("plain", Srgb8::new(0, 0, 0), Srgb8::new(0xcc, 0xcc, 0xcc));
+        theme.set(TokenKind::Comment, Srgb8::new(0x80, 0x80, 0x80));
+        theme.set(TokenKind::Keyword, Srgb8::new(0xc0, 0x40, 0xc0));
+        theme
+    }
+
+    #[test]
+    fn lines_match_the_source_lines() {
+        let src = "fn a() {}\r\n/* b\r\n\r\n c */\n\nlet x = \"s\n t\";";
+        let lines = highlight_lines(src, &Rust, &theme()).unwrap();
+        assert_eq!(lines.len(), src.split('\n').count());
+        for (line, text) in lines.iter().zip(src.split('\n')) {
+            assert_eq!(line.text(), text.trim_end_matches('\r'));
+            assert!(
+                line.segments
+                    .iter()
+                    .all(|segment| !segment.text.is_empty() && !segment.text.contains('\n'))
+            );
+        }
+        assert!(lines[2].is_empty() && lines[4].is_empty());
+        assert_eq!(lines[3].segments[0].style, theme().style_for(TokenKind::Comment));
+
+        assert_eq!(highlight_lines("", &Rust, &theme()).unwrap(), [StyledLine::default()]);
+        assert_eq!(highlight_lines("x\n", &Rust, &theme()).unwrap().len(), 2);
+    }
+
+    #[test]
+    fn segments_carry_resolved_styles_and_merge() {
+        let theme = theme();
+        let lines = highlight_lines("let  mut x", &Rust, &theme).unwrap();
+        let keyword = theme.style_for(TokenKind::Keyword);
+        assert!(keyword.foreground.is_some() && keyword.bold.is_some());
+        // `let` and `mut` stay apart, split by whitespace in the base style.
+        let texts: Vec<_> = lines[0]
+            .segments
+            .iter()
+            .map(|segment| (segment.text.as_str(), segment.style))
+            .collect();
+        assert_eq!(texts[0], ("let", keyword));
+        assert_eq!(texts[2], ("mut", keyword));
+        assert_eq!(texts[1].1, theme.style_for(TokenKind::Whitespace));
+
+        let plain = Theme::new("plain", Srgb8::new(0, 0, 0), Srgb8::new(0xcc, 0xcc, 0xcc));
+        let lines = highlight_lines("a.b(c)", &Rust, &plain).unwrap();
+        assert_eq!(
+            lines[0].segments,
+            [Segment { text: "a.b(c)".to_string(), style: plain.style_for(TokenKind::Text) }]
+        );
+    }
+
+    #[test]
+    fn ansi_renders_one_line() {
+        let lines = highlight_lines("let x\n", &Rust, &theme()).unwrap();
+        assert_eq!(lines[0].ansi(ColorProfile::NoColor), "let x");
+        let colored = lines[0].ansi(ColorProfile::TrueColor);
+        assert!(colored.starts_with("\x1b[38;2;192;64;192mlet\x1b[0m"), "{colored:?}");
+        assert!(!colored.contains('\n'));
+        assert_eq!(lines[1].ansi(ColorProfile::TrueColor), "");
+    }
+
+    #[test]
+    fn incremental_documents_give_the_same_lines() {
+        let theme = theme();
+        let mut doc = Incremental::new(&Rust, "fn a() {}\n/* b\n c */\nlet y;\n").unwrap();
+        doc.edit(10..10, "x ").unwrap();
+        let whole = highlight_lines(doc.source(), &Rust, &theme).unwrap();
+        assert_eq!(doc.styled_lines(0..doc.line_count(), &theme), whole);
+        assert_eq!(doc.styled_lines(2..3, &theme), whole[2..3]);
+        assert!(doc.styled_lines(9..12, &theme).is_empty());
+    }
+
+    #[test]
+    #[ignore = "timing; run with `cargo test --release -- --ignored`"]
+    fn scrolling_a_large_file_fits_in_a_frame() {
+        let sample = include_str!("../../../examples/languages/arena.rs");
+        let src = sample.repeat(20_000 / sample.lines().count() + 1);
+        let theme = theme();
+        let mut doc = Incremental::new(&Rust, src).unwrap();
+        assert!(doc.line_count() > 20_000);
+
+        // Each frame types a character at the top of the viewport, then draws its 60 lines.
+        let frames = 600;
+        let started = Instant::now();
+        let mut drawn = 0;
+        for frame in 0..frames {
+            let top = frame * 30 % (doc.line_count() - 60);
+            let at = doc
+                .tokens_for_lines(top..top + 1)
+                .first()
+                .map_or(0, |token| token.start);
+            doc.edit(at..at, " ").unwrap();
+            for line in doc.styled_lines(top..top + 60, &theme) {
+                drawn += line.ansi(ColorProfile::TrueColor).len();
+            }
+        }
+        let average = started.elapsed() / frames as u32;
+        assert!(drawn > 0);
+        assert!(average.as_micros() < 16_667 / 4, "average frame took {average:?}");
+    }
+}
@@ -0,0 +1,550 @@
+//! Semantic tokens for language servers, in the delta-encoded form LSP 3.17 specifies for
+//! `textDocument/semanticTokens/full`.
+//!
+//! # Examples
+//!
+//! ```
+//! use colorizer::highlight::{Token, TokenKind, lsp};
+//!
+//! let src = "let s = \"héllo\";";
+//! let tokens = [Token::new(TokenKind::KeywordDeclaration, 0, 3), Token::new(TokenKind::String, 8, 16)];
+//! let legend = lsp::Legend::default();
+//! let data = lsp::encode(src, &tokens, &legend);
+//! assert_eq!(data, [0, 0, 3, 15, 1, 0, 8, 7, 18, 0]);
+//! assert_eq!(lsp::decode(src, &data, &legend).unwrap(), tokens);
+//! ```
+
+use crate::highlight::{HighlightError, Token, TokenKind};
+
+/// The semantic token types LSP 3.17 defines, in the order [Legend::default] lists them.
+pub const TOKEN_TYPES: &[&str] = &[
+    "namespace",
+    "type",
+    "class",
+    "enum",
+    "interface",
+    "struct",
+    "typeParameter",
+    "parameter",
+    "variable",
+    "property",
+    "enumMember",
+    "event",
+    "function",
+    "method",
+    "macro",
+    "keyword",
+    "modifier",
+    "comment",
+    "string",
+    "number",
+    "regexp",
+    "operator",
+    "decorator",
+];
+
+/// The semantic token modifiers LSP 3.17 defines, in the order [Legend::default] lists them.
+pub const TOKEN_MODIFIERS: &[&str] = &[
+    "declaration",
+    "definition",
+    "readonly",
+    "static",
+    "deprecated",
+    "abstract",
+    "async",
+    "modification",
+    "documentation",
+    "defaultLibrary",
+];
+
+/// How [Legend::default] maps token kinds onto LSP types and modifiers.
+///
+/// Kinds with no LSP counterpart (plain names, punctuation, whitespace, diff and markup kinds) are left out, so clients
+/// keep their own coloring there. Where several kinds share a type and modifiers, the first one listed is what
+/// [decode] returns.
+const DEFAULT_KINDS: &[(TokenKind, &str, &[&str])] = &[
+    (TokenKind::Keyword, "keyword", &[]),
+    (TokenKind::KeywordConstant, "keyword", &["readonly"]),
+    (TokenKind::KeywordDeclaration, "keyword", &["declaration"]),
+    (TokenKind::KeywordType, "type", &["defaultLibrary"]),
+    (TokenKind::NameBuiltin, "function", &["defaultLibrary"]),
+    (TokenKind::NameFunction, "function", &[]),
+    (TokenKind::NameClass, "class", &[]),
+    (TokenKind::NameTag, "type", &[]),
+    (TokenKind::NameAttribute, "property", &[]),
+    (TokenKind::NameVariable, "variable", &[]),
+    (TokenKind::NameConstant, "variable", &["readonly"]),
+    (TokenKind::String, "string", &[]),
+    (TokenKind::StringEscape, "string", &[]),
+    (TokenKind::StringBacktick, "string", &[]),
+    (TokenKind::StringRegex, "regexp", &[]),
+    (TokenKind::Number, "number", &[]),
+    (TokenKind::NumberDate, "number", &[]),
+    (TokenKind::Operator, "operator", &[]),
+    (TokenKind::Comment, "comment", &[]),
+    (TokenKind::CommentPreproc, "macro", &[]),
+    (TokenKind::NameMacro, "macro", &[]),
+    (TokenKind::CommentDoc, "comment", &["documentation"]),
+];
+
+/// The token types and modifiers a server advertises in its `SemanticTokensLegend`, and how each [TokenKind] maps
+/// onto them.
+///
+/// The default legend lists every standard type and modifier and maps kinds onto the closest ones: a
+/// [TokenKind::KeywordDeclaration] is a `keyword` with the `declaration` modifier, a [TokenKind::KeywordType] is a
+/// `type` from the `defaultLibrary`. Use [Legend::with_kind] to change a mapping or add a custom type.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Legend {
+    token_types: Vec<String>,
+    token_modifiers: Vec<String>,
+    /// Token kind, type index, and modifier bit set.
+    kinds: Vec<(TokenKind, u32, u32)>,
+}
+
+impl Legend {
+    /// Creates a legend with no types, modifiers, or mappings.
+    pub fn new() -> Self {
+        Self { token_types: Vec::new(), token_modifiers: Vec::new(), kinds: Vec::new() }
+    }
+
+    /// Maps `kind` onto `token_type` with `modifiers`, adding them to the legend if they aren't listed yet and
+    /// replacing any earlier mapping for `kind`.
+    ///
+    /// # Panics
+    ///
+    /// Panics if the legend would list more than 32 modifiers, since the protocol encodes them as a 32-bit set.
+    pub fn with_kind(mut self, kind: TokenKind, token_type: &str, modifiers: &[&str]) -> Self {
+        let type_index = index_of(&mut self.token_types, token_type);
+        let mut bits = 0;
+        for modifier in modifiers {
+            let bit = index_of(&mut self.token_modifiers, modifier);
+            assert!(bit < 32, "a legend holds at most 32 token modifiers");
+            bits |= 1 << bit;
+        }
+        self.kinds.retain(|&(existing, ..)| existing != kind);
+        self.kinds.push((kind, type_index, bits));
+        self
+    }
+
+    /// The token types, in the order their indexes refer to.
+    pub fn token_types(&self) -> &[String] {
+        &self.token_types
+    }
+
+    /// The token modifiers, in the order their bits refer to.
+    pub fn token_modifiers(&self) -> &[String] {
+        &self.token_modifiers
+    }
+
+    /// Returns the type index and modifier bits for `kind`, or `None` if it isn't mapped. Unmapped custom kinds
+    /// (see [TokenKind::register]) take their parent's mapping.
+    pub fn classify(&self, kind: TokenKind) -> Option<(u32, u32)> {
+        let mapped = self
+            .kinds
+            .iter()
+            .find(|&&(existing, ..)| existing == kind)
+            .map(|&(_, token_type, modifiers)| (token_type, modifiers));
+        match kind.parent() {
+            Some(parent) if mapped.is_none() && kind.is_custom() => self.classify(parent),
+            _ => mapped,
+        }
+    }
+
+    /// Returns the first kind mapped onto `token_type` with exactly `modifiers`.
+    fn kind(&self, token_type: u32, modifiers: u32) -> Option<TokenKind> {
+        self.kinds
+            .iter()
+            .find(|&&(_, existing_type, existing_modifiers)| {
+                existing_type == token_type && existing_modifiers == modifiers
+            })
+            .map(|&(kind, ..)| kind)
+    }
+}
+
+impl Default for Legend {
+    fn default() -> Self {
+        let mut legend = Self {
+            token_types: TOKEN_TYPES.iter().map(|name| name.to_string()).collect(),
+            token_modifiers: TOKEN_MODIFIERS.iter().map(|name| name.to_string()).collect(),
+            kinds: Vec::new(),
+        };
+        for &(kind, token_type, modifiers) in DEFAULT_KINDS {
+            legend = legend.with_kind(kind, token_type, modifiers);
+        }
+        legend
+    }
+}
+
+/// Returns the position of `name` in `names`, appending it if it's missing.
+fn index_of(names: &mut Vec<String>, name: &str) -> u32 {
+    let index = names.iter().position(|existing| existing == name).unwrap_or_else(|| {
+        names.push(name.to_string());
+        names.len() - 1
+    });
+    index as u32
+}
+
+/// Encodes `tokens` (in order, as lexers produce them) as the `data` array of a `SemanticTokens` response.
+///
+/// Each token becomes five numbers: its line relative to the previous token, its start column (relative to the
+/// previous token when both are on the same line), its length, its type index, and its modifier bits. Columns and
+/// lengths count UTF-16 code units, as the protocol requires, so characters outside the Basic Multilingual Plane (most
+/// emoji, some CJK) count twice. Lines end at `\n`, `\r\n`, or `\r`. Tokens spanning lines are split into one entry
+/// per line, since clients needn't support multiline tokens, and tokens whose kind the legend doesn't map are
+/// skipped.
+pub fn encode(src: &str, tokens: &[Token], legend: &Legend) -> Vec<u32> {
+    let mut data = Vec::new();
+    let mut cursor = Cursor::default();
+    let (mut prev_line, mut prev_col) = (0, 0);
+    let mut emit = |line: u32, col: u32, length: u32, (token_type, modifiers): (u32, u32)| {
+        let delta_start = if line == prev_line { col - prev_col } else { col };
+        data.extend([line - prev_line, delta_start, length, token_type, modifiers]);
+        (prev_line, prev_col) = (line, col);
+    };
+
+    for token in tokens {
+        cursor.advance_to(src, token.start);
+        let Some(class) = legend.classify(token.kind) else { continue };
+        let (mut line, mut col, mut length) = (cursor.line, cursor.col, 0);
+        while cursor.pos < token.end {
+            let ch = cursor.step(src);
+            if ch == '\n' || ch == '\r' {
+                if length > 0 {
+                    emit(line, col, length, class);
+                }
+                (line, col, length) = (cursor.line, cursor.col, 0);
+            } else {
+                length += ch.len_utf16() as u32;
+            }
+        }
+        if length > 0 {
+            emit(line, col, length, class);
+        }
+    }
+    data
+}
+
+/// Decodes a `SemanticTokens` `data` array for `src` back into tokens, as a client would.
+///
+/// Each entry becomes one token, so tokens [encode] split across lines come back as one per line, and kinds that
+/// share a type and modifiers in the legend come back as the first of them.
+pub fn decode(src: &str, data: &[u32], legend: &Legend) -> Result<Vec<Token>, HighlightError> {
+    let entries = data.chunks_exact(5);
+    if !entries.remainder().is_empty() {
+        return Err(HighlightError::Parse(format!(
+            "semantic token data has {} numbers, not a multiple of 5",
+            data.len()
+        )));
+    }
+    let lines = lines(src);
+    let (mut line, mut col) = (0usize, 0u32);
+    let mut tokens = Vec::with_capacity(data.len() / 5);
+    for (index, entry) in entries.enumerate() {
+        let &[delta_line, delta_start, length, token_type, modifiers] = entry else { unreachable!() };
+        line += delta_line as usize;
+        col = if delta_line == 0 { col + delta_start } else { delta_start };
+        let fail = |message: String| HighlightError::Parse(format!("semantic token {index}: {message}"));
+
+        let &(line_start, line_end) = lines
+            .get(line)
+            .ok_or_else(|| fail(format!("line {line} is past the end of the source")))?;
+        let text = &src[line_start..line_end];
+        let offset = |units: u32| {
+            utf16_offset(text, units)
+                .map(|offset| line_start + offset)
+                .ok_or_else(|| fail(format!("column {units} is not a character boundary on line {line}")))
+        };
+        let (start, end) = (offset(col)?, offset(col + length)?);
+        let kind = legend.kind(token_type, modifiers).ok_or_else(|| {
+            fail(format!(
+                "type {token_type} with modifiers {modifiers:#b} maps to no token kind"
+            ))
+        })?;
+        tokens.push(Token::new(kind, start, end));
+    }
+    Ok(tokens)
+}
+
+/// A position in the source, tracked in bytes and in LSP coordinates.
+#[derive(Debug, Default)]
+struct Cursor {
+    pos: usize,
+    line: u32,
+    /// Column in UTF-16 code units.
+    col: u32,
+}
+
+impl Cursor {
+    /// Moves past the next character and returns it.
+    fn step(&mut self, src: &str) -> char {
+        let ch = src[self.pos..].chars().next().expect("cursor within the source");
+        self.pos += ch.len_utf8();
+        match ch {
+            // The `\r` of a `\r\n` is part of the line break the `\n` ends.
+            '\r' if src[self.pos..].starts_with('\n') => {}
+            '\n' | '\r' => (self.line, self.col) = (self.line + 1, 0),
+            ch => self.col += ch.len_utf16() as u32,
+        }
+        ch
+    }
+
+    fn advance_to(&mut self, src: &str, pos: usize) {
+        while self.pos < pos {
+            self.step(src);
+        }
+    }
+}
+
+/// Byte ranges of each line's content, without its line break.
+fn lines(src: &str) -> Vec<(usize, usize)> {
+    let mut lines = Vec::new();
+    let mut start = 0;
+    let bytes = src.as_bytes();
+    let mut pos = 0;
+    while pos < bytes.len() {
+        match bytes[pos] {
+            b'\r' if bytes.get(pos + 1) == Some(&b'\n') => {
+                lines.push((start, pos));
+                pos += 2;
+                start = pos;
+            }
+            b'\n' | b'\r' => {
+                lines.push((start, pos));
+                pos += 1;
+                start = pos;
+            }
+            _ => pos += 1,
+        }
+    }
+    lines.push((start, src.len()));
+    lines
+}
+
+/// Converts a column in UTF-16 code units to a byte offset in `line`, or `None` if it falls inside a surrogate pair
+/// or past the end.
+fn utf16_offset(line: &str, units: u32) -> Option<usize> {
+    let mut counted = 0;
+    for (offset, ch) in line.char_indices() {
+        if counted == units {
+            return Some(offset);
+        }
+        if counted > units {
+            return None;
+        }
+        counted += ch.len_utf16() as u32;
+    }
+    (counted == units).then_some(line.len())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Concatenates `pieces` into a source and the tokens covering it.
+    fn sample(pieces: &[(TokenKind, &str)]) -> (String, Vec<Token>) {
+        let mut src = String::new();
+        let mut tokens = Vec::new();
+        for &(kind, text) in pieces {
+            tokens.push(Token::new(kind, src.len(), src.len() + text.len()));
+            src.push_str(text);
+        }
+        (src, tokens)
+    }
+
+    fn go_sample() -> (String, Vec<Token>) {
+        sample(&[
+            (TokenKind::Comment, "// Package wave says hi 👋.\n"),
+            (TokenKind::KeywordDeclaration, "package"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Name, "wave"),
+            (TokenKind::Whitespace, "\n\n"),
+            (TokenKind::KeywordDeclaration, "func"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::NameFunction, "Greet"),
+            (TokenKind::Punctuation, "("),
+            (TokenKind::Name, "name"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::KeywordType, "string"),
+            (TokenKind::Punctuation, ")"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::KeywordType, "string"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Punctuation, "{"),
+            (TokenKind::Whitespace, "\n\t"),
+            (TokenKind::Name, "msg"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Operator, ":="),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::String, "\"héllo, 世界 👋\""),
+            (TokenKind::Whitespace, "\n\t"),
+            (TokenKind::Keyword, "return"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Name, "msg"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Operator, "+"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::String, "`raw\r\n\tline`"),
+            (TokenKind::Whitespace, "\n"),
+            (TokenKind::Punctuation, "}"),
+            (TokenKind::Whitespace, "\n"),
+        ])
+    }
+
+    fn python_sample() -> (String, Vec<Token>) {
+        sample(&[
+            (TokenKind::KeywordDeclaration, "def"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::NameFunction, "café"),
+            (TokenKind::Punctuation, "("),
+            (TokenKind::Name, "s"),
+            (TokenKind::Punctuation, "):"),
+            (TokenKind::Whitespace, "\n    "),
+            (TokenKind::String, "\"\"\"Docstring 🙂 over\n    two lines.\"\"\""),
+            (TokenKind::Whitespace, "\n    "),
+            (TokenKind::Keyword, "return"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::String, "f\""),
+            (TokenKind::StringEscape, "{"),
+            (TokenKind::Name, "s"),
+            (TokenKind::StringEscape, "}"),
+            (TokenKind::String, "→✓\""),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::Operator, "+"),
+            (TokenKind::Whitespace, " "),
+            (TokenKind::String, "'𝔘'"),
+            (TokenKind::Whitespace, "\n"),
+        ])
+    }
+
+    /// Groups encoded data into quintuples for readable comparisons.
+    fn entries(data: &[u32]) -> Vec<[u32; 5]> {
+        data.chunks_exact(5).map(|entry| entry.try_into().unwrap()).collect()
+    }
+
+    #[test]
+    fn go_sample_encodes_utf16_lengths_and_splits_lines() {
+        let (src, tokens) = go_sample();
+        let data = encode(&src, &tokens, &Legend::default());
+        assert_eq!(
+            entries(&data),
+            [
+                // The emoji in the comment is a surrogate pair: 24 + 2 + 1 units.
+                [0, 0, 27, 15 + 2, 0],
+                [1, 0, 7, 15, 0b1],
+                [2, 0, 4, 15, 0b1],
+                [0, 5, 5, 12, 0],
+                // `string` in the default library, at column 16 and then 24.
+                [0, 11, 6, 1, 1 << 9],
+                [0, 8, 6, 1, 1 << 9],
+                // A tab is one unit; `é` and each of `世界` one each, the emoji two.
+                [1, 5, 2, 21, 0],
+                [0, 3, 14, 18, 0],
+                [1, 1, 6, 15, 0],
+                [0, 11, 1, 21, 0],
+                // The raw string is split at its CRLF.
+                [0, 2, 4, 18, 0],
+                [1, 0, 6, 18, 0],
+            ]
+        );
+    }
+
+    #[test]
+    fn python_sample_encodes_utf16_lengths_and_splits_lines() {
+        let (src, tokens) = python_sample();
+        let data = encode(&src, &tokens, &Legend::default());
+        assert_eq!(
+            entries(&data),
+            [
+                [0, 0, 3, 15, 0b1],
+                // `é` is one unit even though it's two bytes.
+                [0, 4, 4, 12, 0],
+                // The docstring keeps its indentation on its second line.
+                [1, 4, 20, 18, 0],
+                [1, 0, 17, 18, 0],
+                [1, 4, 6, 15, 0],
+                [0, 7, 2, 18, 0],
+                [0, 2, 1, 18, 0],
+                [0, 2, 1, 18, 0],
+                [0, 1, 3, 18, 0],
+                [0, 4, 1, 21, 0],
+                // U+1D518 is outside the BMP, so the quotes around it make four units.
+                [0, 2, 4, 18, 0],
+            ]
+        );
+    }
+
+    #[test]
+    fn decode_inverts_encode() {
+        let legend = Legend::default();
+        for (src, tokens) in [go_sample(), python_sample()] {
+            let data = encode(&src, &tokens, &legend);
+            let decoded = decode(&src, &data, &legend).unwrap();
+            assert_eq!(encode(&src, &decoded, &legend), data);
+
+            // Every decoded token is a mapped token, or one line of one, with its kind's LSP classification.
+            for token in &decoded {
+                let original = tokens
+                    .iter()
+                    .find(|original| original.start <= token.start && token.end <= original.end);
+                let original = original.expect("decoded tokens lie within source tokens");
+                assert_eq!(legend.classify(token.kind), legend.classify(original.kind));
+                assert!(!token.text(&src).contains(['\n', '\r']));
+            }
+        }
+
+        let (src, _) = python_sample();
+        let decoded = decode(&src, &encode(&src, &python_sample().1, &legend), &legend).unwrap();
+        let texts: Vec<_> = decoded.iter().map(|token| token.text(&src)).collect();
+        assert_eq!(texts[2..4], ["\"\"\"Docstring 🙂 over", "    two lines.\"\"\""]);
+        assert_eq!(texts.last(), Some(&"'𝔘'"));
+        // `{` decodes as a plain string, the first kind mapped to `string`.
+        assert_eq!(decoded[6].kind, TokenKind::String);
+    }
+
+    #[test]
+    fn default_legend_lists_the_standard_types() {
+        let legend = Legend::default();
+        assert_eq!(legend.token_types(), TOKEN_TYPES);
+        assert_eq!(legend.token_modifiers(), TOKEN_MODIFIERS);
+        assert_eq!(legend.classify(TokenKind::Comment), Some((17, 0)));
+        assert_eq!(legend.classify(TokenKind::NameBuiltin), Some((12, 1 << 9)));
+        assert_eq!(legend.classify(TokenKind::Punctuation), None);
+    }
+
+    #[test]
+    fn custom_legends_add_types_and_modifiers() {
+        let legend = Legend::new()
+            .with_kind(TokenKind::NameTag, "tag", &[])
+            .with_kind(TokenKind::NameAttribute, "attribute", &["html", "readonly"])
+            .with_kind(TokenKind::NameTag, "element", &[]);
+        assert_eq!(legend.token_types(), ["tag", "attribute", "element"]);
+        assert_eq!(legend.token_modifiers(), ["html", "readonly"]);
+        assert_eq!(legend.classify(TokenKind::NameTag), Some((2, 0)));
+        assert_eq!(legend.classify(TokenKind::NameAttribute), Some((1, 0b11)));
+    }
+
+    #[test]
+    fn decode_rejects_malformed_data() {
+        let legend = Legend::default();
+        let error = |data: &[u32]| decode("a👋\nb", data, &legend).unwrap_err().to_string();
+        assert_eq!(
+            error(&[0, 0, 1]),
+            "failed to parse tokens: semantic token data has 3 numbers, not a multiple of 5"
+        );
+        assert!(error(&[2, 0, 1, 17, 0]).ends_with("semantic token 0: line 2 is past the end of the source"));
+        assert!(error(&[0, 0, 2, 17, 0]).ends_with("column 2 is not a character boundary on line 0"));
+        assert!(error(&[0, 0, 3, 17, 0, 0, 0, 4, 17, 0]).contains("semantic token 1: column 4"));
+        assert!(error(&[0, 0, 1, 16, 0]).contains("type 16 with modifiers 0b0 maps to no token kind"));
+    }
+
+    #[test]
+    fn lone_carriage_returns_end_lines() {
+        let src = "a\rb\r\nc";
+        let tokens = [Token::new(TokenKind::Name, 0, 1), Token::new(TokenKind::Comment, 2, 6)];
+        let legend = Legend::new().with_kind(TokenKind::Comment, "comment", &[]);
+        assert_eq!(
+            entries(&encode(src, &tokens, &legend)),
+            [[1, 0, 1, 0, 0], [1, 0, 1, 0, 0]]
+        );
+    }
+}
@@ -0,0 +1,226 @@
+//! Source highlighting built from three pieces: a [Lexer] that splits text into [Token]s, a [Theme] that colors
+//! each [TokenKind], and a [Formatter] that renders the result.
+//!
+//! # Examples
+//!
+//! ```
+//! use colorizer::colors::Srgb8;
+//! use colorizer::highlight::{Theme, TokenKind, highlight};
+//! use colorizer::highlight::formatters::HtmlFormatter;
+//! use colorizer::highlight::lexers::PlainText;
+//!
+//! let theme = Theme::new("Plain", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+//! let html = highlight("a < b", &PlainText, &theme, &HtmlFormatter::new().with_classes("clz-")).unwrap();
+//! assert_eq!(html, "<pre class=\"clz-pre\"><code>a &lt; b</code></pre>");
+//! assert!(theme.css("clz-").starts_with(".clz-pre {"));
+//! ```
+
+use std::borrow::Cow;
+use std::{fmt, io};
+
+#[cfg(test)]
+mod allocations;
+pub mod ansi;
+mod cache;
+mod cancel;
+mod detect;
+mod diffview;
+mod files;
+mod filter;
+pub mod formatters;
+mod highlighter;
+mod incremental;
+mod input;
+pub mod lexers;
+mod lines;
+pub mod lsp;
+mod parallel;
+mod positions;
+mod range;
+pub mod screenshot;
+mod stream;
+mod template;
+pub mod testing;
+mod theme;
+pub mod themes;
+pub(crate) mod token;
+mod width;
+
+pub use cache::{Cache, LruCache, cache_key};
+pub use cancel::{CancelReason, CancelToken};
+pub use detect::{Detection, detect_language, detect_lexer, detect_modeline};
+pub use diffview::{DiffLine, DiffMode, DiffOptions, DiffRow, LineChange, diff_rows, highlight_diff};
+pub use files::{DEFAULT_MAX_FILE_SIZE, DirError, FileOptions, highlight_dir, highlight_file};
+pub use filter::{
+    CoalesceRuns, Filter, Filters, RainbowBrackets, RedactSecrets, RedactStrings, Redaction, RemapKinds,
+    SecretDetector, StripComments, filter_tokens,
+};
+pub use formatters::Formatter;
+pub use highlighter::{CallFormatter, CallOptions, Highlighter};
+pub use incremental::Incremental;
+pub use input::{Decoded, InputOptions, InvalidUtf8, decode, highlight_bytes, is_binary};
+pub use lexers::{Lexer, LexerState};
+pub use lines::{Segment, StyledLine, highlight_lines};
+pub use parallel::{highlight_parallel, tokenize_parallel};
+pub use positions::{ColumnUnit, Positions};
+pub use range::{MAX_SNIPPET_LINES, RangeOptions, Snippet, extract_snippets, highlight_range, highlight_ranges};
+pub use stream::{highlight_reader, highlight_reader_with};
+pub use template::{TemplateFuncs, TextTemplateFuncs};
+pub use theme::{ContrastIssue, CssOptions, Style, Theme};
+pub use token::{CustomKind, RegisterError, Token, TokenKind};
+
+/// Errors raised while highlighting source text.
+#[derive(Debug)]
+pub enum HighlightError {
+    /// The lexer could not tokenize the input.
+    Lex(String),
+    /// Reading the input or writing the output failed.
+    Io(io::Error),
+    /// A serialized token stream (see [formatters::parse_token_json]) is malformed.
+    Parse(String),
+    /// The input isn't valid UTF-8 and [InvalidUtf8::Error] is in effect; `offset` is the byte offset of the first
+    /// invalid sequence.
+    InvalidUtf8 { offset: usize },
+    /// The input looks binary (see [is_binary]) and [InputOptions::with_allow_binary] isn't set.
+    BinaryInput,
+    /// A file is `size` bytes, more than the `limit` of [FileOptions::with_max_size].
+    TooLarge { size: u64, limit: u64 },
+    /// A [lexers::Strict] lexer met input it can't classify: `found` is the first such character, at byte `offset`.
+    Unexpected { offset: usize, found: char },
+    /// A [CancelToken] fired after `offset` bytes were lexed. `partial` holds the rendering of those bytes when
+    /// [CancelToken::with_partial_output] asks for it.
+    Cancelled {
+        reason: CancelReason,
+        offset: usize,
+        partial: Option<String>,
+    },
+}
+
+impl fmt::Display for HighlightError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            HighlightError::Lex(message) => write!(f, "failed to tokenize source: {message}"),
+            HighlightError::Io(source) => write!(f, "highlight I/O failed: {source}"),
+            HighlightError::Parse(message) => write!(f, "failed to parse tokens: {message}"),
+            HighlightError::InvalidUtf8 { offset } => write!(f, "invalid UTF-8 at byte {offset}"),
+            HighlightError::BinaryInput => write!(f, "input looks binary"),
+            HighlightError::TooLarge { size, limit } => {
+                write!(f, "file is {size} bytes, over the {limit}-byte limit")
+            }
+            HighlightError::Unexpected { offset, found } => {
+                write!(f, "unexpected character {found:?} at byte {offset}")
+            }
+            HighlightError::Cancelled { reason, offset, .. } => {
+                write!(f, "highlighting stopped at byte {offset}: {reason}")
+            }
+        }
+    }
+}
+
+impl std::error::Error for HighlightError {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        match self {
+            HighlightError::Io(source) => Some(source),
+            _ => None,
+        }
+    }
+}
+
+impl From<io::Error> for HighlightError {
+    fn from(source: io::Error) -> Self {
+        HighlightError::Io(source)
+    }
+}
+
+/// Tokenizes `src` with `lexer` and renders it with `formatter` using `theme`.
+pub fn highlight(
+    src: &str, lexer: &dyn Lexer, theme: &Theme, formatter: &dyn Formatter,
+) -> Result<String, HighlightError> {
+    highlight_cancellable(src, lexer, theme, formatter, &CancelToken::new())
+}
+
+/// Like [highlight], but stops with [HighlightError::Cancelled] once `cancel` fires, checking before every line.
+///
+/// With [CancelToken::with_partial_output], the error carries the rendering of the lines lexed until then, so a
+/// service can still show something for input it gave up on.
+pub fn highlight_cancellable(
+    src: &str, lexer: &dyn Lexer, theme: &Theme, formatter: &dyn Formatter, cancel: &CancelToken,
+) -> Result<String, HighlightError> {
+    let mut tokens = Vec::new();
+    let mut out = String::with_capacity(src.len() * 2);
+    match lexer.tokenize_cancellable(src, cancel, &mut tokens) {
+        Ok(()) => formatter.format(src, &tokens, theme, &mut out),
+        Err(HighlightError::Cancelled { reason, offset, .. }) => {
+            let partial = cancel.wants_partial().then(|| {
+                formatter.format(&src[..offset], &tokens, theme, &mut out);
+                out
+            });
+            return Err(HighlightError::Cancelled { reason, offset, partial });
+        }
+        Err(err) => return Err(err),
+    }
+    Ok(out)
+}
+
+/// Like [highlight], but passes the tokens through `filter`, usually a [Filters] chain, before formatting.
+///
+/// Filters that rewrite text ([RedactStrings], [RedactSecrets], [StripComments]) change what is rendered, not just how.
+pub fn highlight_filtered(
+    src: &str, lexer: &dyn Lexer, theme: &Theme, formatter: &dyn Formatter, filter: &dyn Filter,
+) -> Result<String, HighlightError> {
+    let tokens = lexer.tokenize(src)?;
+    let (src, tokens) = filter.apply(Cow::Borrowed(src), tokens);
+    let mut out = String::with_capacity(src.len() * 2);
+    formatter.format(&src, &tokens, theme, &mut out);
+    Ok(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::terminal::ColorProfile;
+    use formatters::{AnsiFormatter, HtmlFormatter};
+    use lexers::PlainText;
+
+    fn theme() -> Theme {
+        Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255))
+    }
+
+    #[test]
+    fn highlight_renders_plain_text_as_html() {
+        let html = highlight("<b>\n", &PlainText, &theme(), &HtmlFormatter::new()).unwrap();
+        assert_eq!(
+            html,
+            "<pre style=\"background-color: #000000; color: #ffffff;\"><code>&lt;b&gt;\n</code></pre>"
+        );
+    }
+
+    #[test]
+    fn highlight_renders_plain_text_as_ansi() {
+        let out = highlight(
+            "hi",
+            &PlainText,
+            &theme(),
+            &AnsiFormatter::new().with_profile(ColorProfile::TrueColor),
+        )
+        .unwrap();
+        assert_eq!(out, "\x1b[38;2;255;255;255mhi\x1b[0m");
+    }
+
+    #[test]
+    fn highlight_filtered_renders_the_filtered_source() {
+        let filters = Filters::new().with(RedactStrings::new("xxx"));
+        let src = "key = \"hunter2\"\n";
+        let html = highlight_filtered(
+            src,
+            &lexers::Python,
+            &theme(),
+            &HtmlFormatter::new().with_classes(""),
+            &filters,
+        );
+        let html = html.unwrap();
+        assert!(html.contains("&quot;xxx&quot;"), "{html}");
+        assert!(!html.contains("hunter2"));
+    }
+}
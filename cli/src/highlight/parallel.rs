@@ -0,0 +1,276 @@
+//! Multi-threaded tokenizing of large documents.
+
+use super::lexers::{Lexer, LexerState};
+use super::token::push_token;
+use super::{Formatter, HighlightError, Theme, Token};
+
+use std::thread;
+
+/// Documents are only split into chunks at least this long, so threads aren't started for small inputs.
+const MIN_CHUNK_LEN: usize = 64 * 1024;
+
+/// Tokenizes `src` like [Lexer::tokenize], lexing chunks of it on up to `threads` threads.
+///
+/// The document is split at line boundaries into one chunk per thread, and every chunk is lexed from the lexer's start
+/// state at the same time. The chunks are then checked in order: a chunk's tokens are kept when the state the previous
+/// chunk really ended in matches the start state ([LexerState::same_as]), which is the case wherever no string,
+/// comment, or other construct is open across the boundary. Otherwise the chunk is lexed again, from the right state,
+/// on the calling thread. The result is always identical to lexing line by line; an input dominated by one huge block
+/// comment just ends up lexed sequentially, and so do lexers whose states can't be compared.
+///
+/// Pass [thread::available_parallelism] for one thread per core. With fewer than two threads, or for inputs too
+/// small to be worth splitting, this is [Lexer::tokenize].
+///
+/// Like [crate::highlight::highlight_reader], this lexes line by line, so lexers that need to see the whole document
+/// up front ([super::lexers::Diff::with_inline_changes]) leave out what that would add.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::lexers::Shell;
+/// use colorizer::highlight::{Lexer, tokenize_parallel};
+///
+/// let src = "echo hi\n".repeat(50_000);
+/// assert_eq!(tokenize_parallel(&Shell, &src, 4).unwrap(), Shell.tokenize(&src).unwrap());
+/// ```
+pub fn tokenize_parallel(lexer: &dyn Lexer, src: &str, threads: usize) -> Result<Vec<Token>, HighlightError> {
+    tokenize_chunks(lexer, src, threads, MIN_CHUNK_LEN)
+}
+
+/// Tokenizes `src` with [tokenize_parallel] and renders it with `formatter` using `theme`.
+pub fn highlight_parallel(
+    src: &str, lexer: &dyn Lexer, theme: &Theme, formatter: &dyn Formatter, threads: usize,
+) -> Result<String, HighlightError> {
+    let tokens = tokenize_parallel(lexer, src, threads)?;
+    let mut out = String::with_capacity(src.len() * 2);
+    formatter.format(src, &tokens, theme, &mut out);
+    Ok(out)
+}
+
+/// A chunk lexed from the start state, before knowing whether that was the state it really starts in.
+struct Speculation<'l> {
+    tokens: Result<Vec<Token>, HighlightError>,
+    state: Box<dyn LexerState + 'l>,
+}
+
+fn tokenize_chunks(
+    lexer: &dyn Lexer, src: &str, threads: usize, min_chunk_len: usize,
+) -> Result<Vec<Token>, HighlightError> {
+    let chunks = split_lines(src, threads.min(src.len() / min_chunk_len.max(1)));
+    if chunks.len() < 2 {
+        return lexer.tokenize(src);
+    }
+
+    let speculations: Vec<Speculation> = thread::scope(|scope| {
+        let workers: Vec<_> = chunks
+            .iter()
+            .map(|&(start, end)| {
+                scope.spawn(move || {
+                    let mut state = lexer.start();
+                    let mut tokens = Vec::new();
+                    let tokens = lex(&mut *state, src, start, end, &mut tokens).map(|()| tokens);
+                    Speculation { tokens, state }
+                })
+            })
+            .collect();
+        workers
+            .into_iter()
+            .map(|worker| worker.join().unwrap_or_else(|panic| std::panic::resume_unwind(panic)))
+            .collect()
+    });
+
+    let start = lexer.start();
+    let mut speculations = speculations.into_iter();
+    let first = speculations.next().expect("at least two chunks");
+    let mut tokens = first.tokens?;
+    let mut state = first.state;
+    for (speculation, &(chunk_start, chunk_end)) in speculations.zip(&chunks[1..]) {
+        if state.same_as(&*start) {
+            for token in speculation.tokens? {
+                push_token(&mut tokens, token.kind, token.start, token.end);
+            }
+            state = speculation.state;
+        } else {
+            lex(&mut *state, src, chunk_start, chunk_end, &mut tokens)?;
+        }
+    }
+    Ok(tokens)
+}
+
+/// Feeds the lines of `src[start..end]` to `state`.
+fn lex(
+    state: &mut dyn LexerState, src: &str, start: usize, end: usize, tokens: &mut Vec<Token>,
+) -> Result<(), HighlightError> {
+    let mut offset = start;
+    for line in src[start..end].split_inclusive('\n') {
+        state.tokenize_line(line, offset, tokens)?;
+        offset += line.len();
+    }
+    Ok(())
+}
+
+/// Splits `src` into at most `count` byte ranges of similar length that end after a newline (or at the end).
+fn split_lines(src: &str, count: usize) -> Vec<(usize, usize)> {
+    let mut chunks = Vec::new();
+    let mut start = 0;
+    for i in 1..count {
+        let target = (src.len() * i / count).max(start);
+        // Search bytes: the target may fall inside a multi-byte character, but a newline byte never does.
+        let Some(newline) = src.as_bytes()[target..].iter().position(|&byte| byte == b'\n') else { break };
+        let end = target + newline + 1;
+        if end > start && end < src.len() {
+            chunks.push((start, end));
+            start = end;
+        }
+    }
+    chunks.push((start, src.len()));
+    chunks
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::TokenKind;
+    use crate::highlight::lexers::{Markdown, PlainText, Shell};
+
+    use rand::rngs::StdRng;
+    use rand::seq::IndexedRandom;
+    use rand::{Rng, SeedableRng};
+    use std::any::Any;
+
+    /// Minimal lexer whose `/* ... */` comments span lines.
+    struct BlockComments;
+
+    #[derive(Clone, PartialEq)]
+    struct BlockCommentState {
+        in_comment: bool,
+    }
+
+    impl Lexer for BlockComments {
+        fn name(&self) -> &str {
+            "Block Comments"
+        }
+
+        fn start(&self) -> Box<dyn LexerState + '_> {
+            Box::new(BlockCommentState { in_comment: false })
+        }
+    }
+
+    impl LexerState for BlockCommentState {
+        fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+            let mut pos = 0;
+            while pos < line.len() {
+                let rest = &line[pos..];
+                let (kind, len) = match (self.in_comment, rest.find(if self.in_comment { "*/" } else { "/*" })) {
+                    (true, Some(end)) => {
+                        self.in_comment = false;
+                        (TokenKind::Comment, end + 2)
+                    }
+                    (false, Some(0)) => {
+                        self.in_comment = true;
+                        (TokenKind::Comment, 2)
+                    }
+                    (false, Some(start)) => (TokenKind::Text, start),
+                    (in_comment, None) => (
+                        if in_comment { TokenKind::Comment } else { TokenKind::Text },
+                        rest.len(),
+                    ),
+                };
+                push_token(tokens, kind, offset + pos, offset + pos + len);
+                pos += len;
+            }
+            Ok(())
+        }
+
+        fn snapshot(&self) -> Option<Box<dyn LexerState>> {
+            Some(Box::new(self.clone()))
+        }
+
+        fn same_as(&self, other: &dyn LexerState) -> bool {
+            crate::highlight::lexers::same_state(self, other)
+        }
+
+        fn as_any(&self) -> Option<&dyn Any> {
+            Some(self)
+        }
+    }
+
+    #[test]
+    fn split_points_follow_newlines() {
+        assert_eq!(split_lines("a\nb\nc\nd\n", 2), [(0, 6), (6, 8)]);
+        assert_eq!(split_lines("a\nb\nc\nd\n", 3), [(0, 4), (4, 6), (6, 8)]);
+        assert_eq!(split_lines("one long line", 4), [(0, 13)]);
+        assert_eq!(split_lines("a\n\n\n", 8), [(0, 2), (2, 3), (3, 4)]);
+        assert_eq!(split_lines("", 3), [(0, 0)]);
+    }
+
+    #[test]
+    fn open_comments_fall_back_to_sequential_lexing() {
+        let src = format!("/*{}*/\nafter\n", "comment\n".repeat(1_000));
+        let tokens = tokenize_chunks(&BlockComments, &src, 8, 16).unwrap();
+        assert_eq!(tokens, BlockComments.tokenize(&src).unwrap());
+        assert_eq!(tokens[0], Token::new(TokenKind::Comment, 0, src.len() - 7));
+    }
+
+    #[test]
+    fn chunks_merge_tokens_across_boundaries() {
+        let src = "text\n".repeat(1_000);
+        assert_eq!(
+            tokenize_chunks(&PlainText, &src, 4, 16).unwrap(),
+            [Token::new(TokenKind::Text, 0, src.len())]
+        );
+    }
+
+    #[test]
+    fn parallel_tokens_match_sequential_tokens() {
+        let shell: &[&str] = &[
+            "echo \"$HOME\"\n",
+            "cat <<EOF\nbody $x\nEOF\n",
+            "x='open\n",
+            "'\n",
+            "case $1 in\n  a) ls ;;\nesac\n",
+            "# comment\n",
+            "echo 'héllo, 世界' # ☕☕☕\n",
+            "\n",
+        ];
+        let markdown: &[&str] = &[
+            "# Title\n",
+            "```sh\n",
+            "echo hi\n",
+            "```\n",
+            "> quote\n",
+            "- item\n",
+            "- café 🎨🎨🎨\n",
+            "\n",
+        ];
+        let comments: &[&str] = &[
+            "/*\n",
+            "*/\n",
+            "code /* a */ code\n",
+            "plain\n",
+            "ééééé /* 世界 */ ü\n",
+            "\n",
+        ];
+        let cases: [(&dyn Lexer, &[&str]); 3] = [
+            (&Shell, shell),
+            (&Markdown::new(), markdown),
+            (&BlockComments, comments),
+        ];
+
+        let mut rng = StdRng::seed_from_u64(0x0c01_0512);
+        for (lexer, fragments) in cases {
+            for _ in 0..40 {
+                let pieces = rng.random_range(0..200);
+                let src: String = (0..pieces).map(|_| *fragments.choose(&mut rng).unwrap()).collect();
+                let threads = rng.random_range(1..9);
+                let min_chunk_len = rng.random_range(1..64);
+                assert_eq!(
+                    tokenize_chunks(lexer, &src, threads, min_chunk_len).unwrap(),
+                    lexer.tokenize(&src).unwrap(),
+                    "{} with {threads} threads: {src:?}",
+                    lexer.name()
+                );
+            }
+        }
+    }
+}
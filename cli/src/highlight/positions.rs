@@ -0,0 +1,200 @@
+//! Conversion between the byte offsets tokens carry and line/column coordinates.
+
+use std::ops::Range;
+use std::sync::OnceLock;
+
+/// What a column counts.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Default)]
+pub enum ColumnUnit {
+    /// Bytes of UTF-8, as token offsets count them.
+    Bytes,
+    /// Characters (Unicode scalar values), as Rust's `chars` and Go's runes count them.
+    #[default]
+    Chars,
+    /// UTF-16 code units, as JavaScript strings and the Language Server Protocol count them, so characters outside
+    /// the Basic Multilingual Plane (most emoji) count twice.
+    Utf16,
+}
+
+impl ColumnUnit {
+    /// Returns how many units `text` spans.
+    pub fn measure(self, text: &str) -> usize {
+        match self {
+            ColumnUnit::Bytes => text.len(),
+            ColumnUnit::Chars => text.chars().count(),
+            ColumnUnit::Utf16 => text.chars().map(char::len_utf16).sum(),
+        }
+    }
+
+    /// Byte offset of column `column` in `line`: the end of the line past its last column, and the start of the
+    /// character a column falls inside.
+    fn offset_in(self, line: &str, column: usize) -> usize {
+        match self {
+            ColumnUnit::Bytes => floor_char_boundary(line, column),
+            ColumnUnit::Chars => line.char_indices().nth(column).map_or(line.len(), |(offset, _)| offset),
+            ColumnUnit::Utf16 => {
+                let mut counted = 0;
+                for (offset, ch) in line.char_indices() {
+                    counted += ch.len_utf16();
+                    if counted > column {
+                        return offset;
+                    }
+                }
+                line.len()
+            }
+        }
+    }
+}
+
+/// A line index over a source, mapping byte offsets to zero-based line and column numbers and back.
+///
+/// Lines end at `\n`; the `\r` of a CRLF ending belongs to the line break, not the line. A source ending in a line
+/// break has an empty last line after it, so the lines line up with `src.split('\n')` and with those of
+/// [super::highlight_lines]. Nothing is scanned until the first lookup, which records where every line starts; each
+/// lookup after that is a binary search plus a walk along a single line, so an index is cheap to keep next to a
+/// token stream for as long as the source lives.
+///
+/// Inputs out of range are clamped rather than rejected: offsets past the end map to the end, offsets inside a
+/// character or a line break map to where it starts, and columns past the end of a line map to its end.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::{ColumnUnit, Positions};
+///
+/// let src = "let a = 1;\r\nlet 😀 = \"é\";";
+/// let positions = Positions::new(src);
+/// let offset = src.find('é').unwrap();
+/// assert_eq!(positions.line_col(offset, ColumnUnit::Bytes), (1, 12));
+/// assert_eq!(positions.line_col(offset, ColumnUnit::Chars), (1, 9));
+/// assert_eq!(positions.line_col(offset, ColumnUnit::Utf16), (1, 10));
+/// assert_eq!(positions.offset(1, 10, ColumnUnit::Utf16), offset);
+/// ```
+#[derive(Debug, Clone)]
+pub struct Positions<'a> {
+    src: &'a str,
+    starts: OnceLock<Vec<usize>>,
+}
+
+impl<'a> Positions<'a> {
+    /// Creates an index over `src`, to be built on first use.
+    pub fn new(src: &'a str) -> Self {
+        Self { src, starts: OnceLock::new() }
+    }
+
+    /// Returns the source the index covers.
+    pub fn source(&self) -> &'a str {
+        self.src
+    }
+
+    /// Returns the number of lines, at least one.
+    pub fn line_count(&self) -> usize {
+        self.starts().len()
+    }
+
+    /// Returns the byte range of line `line`'s text, without its line break; past the last line, the empty range at
+    /// the end of the source.
+    pub fn line(&self, line: usize) -> Range<usize> {
+        let starts = self.starts();
+        let Some(&start) = starts.get(line) else { return self.src.len()..self.src.len() };
+        let end = match starts.get(line + 1) {
+            Some(&next) if next - 1 > start && self.src.as_bytes()[next - 2] == b'\r' => next - 2,
+            Some(&next) => next - 1,
+            None => self.src.len(),
+        };
+        start..end
+    }
+
+    /// Returns the zero-based line and column of byte `offset`, with the column counted in `unit`.
+    pub fn line_col(&self, offset: usize, unit: ColumnUnit) -> (usize, usize) {
+        let offset = floor_char_boundary(self.src, offset);
+        let line = self.starts().partition_point(|&start| start <= offset) - 1;
+        let text = self.line(line);
+        (line, unit.measure(&self.src[text.start..offset.min(text.end)]))
+    }
+
+    /// Returns the byte offset of the zero-based `line` and `column`, with the column counted in `unit`.
+    pub fn offset(&self, line: usize, column: usize, unit: ColumnUnit) -> usize {
+        let text = self.line(line);
+        text.start + unit.offset_in(&self.src[text.clone()], column)
+    }
+
+    fn starts(&self) -> &[usize] {
+        self.starts.get_or_init(|| {
+            let breaks = self.src.bytes().enumerate().filter(|&(_, byte)| byte == b'\n');
+            std::iter::once(0).chain(breaks.map(|(at, _)| at + 1)).collect()
+        })
+    }
+}
+
+/// The largest character boundary of `text` at or before `offset`, clamped to its length.
+fn floor_char_boundary(text: &str, offset: usize) -> usize {
+    let mut offset = offset.min(text.len());
+    while !text.is_char_boundary(offset) {
+        offset -= 1;
+    }
+    offset
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const UNITS: [ColumnUnit; 3] = [ColumnUnit::Bytes, ColumnUnit::Chars, ColumnUnit::Utf16];
+
+    #[test]
+    fn offsets_round_trip_in_every_unit() {
+        let src = "fn main() {\r\n    println!(\"héllo 😀 wörld\");\n}\n\nx";
+        let positions = Positions::new(src);
+        assert_eq!(positions.line_count(), src.split('\n').count());
+        for unit in UNITS {
+            for (offset, _) in src.char_indices().filter(|&(_, ch)| ch != '\r' && ch != '\n') {
+                let (line, column) = positions.line_col(offset, unit);
+                assert_eq!(positions.offset(line, column, unit), offset, "{unit:?} at {offset}");
+            }
+        }
+        let emoji = src.find('😀').unwrap();
+        let after = emoji + '😀'.len_utf8();
+        assert_eq!(positions.line_col(after, ColumnUnit::Chars), (1, 21));
+        assert_eq!(positions.line_col(after, ColumnUnit::Utf16), (1, 22));
+        assert_eq!(positions.line_col(after, ColumnUnit::Bytes), (1, 25));
+        assert_eq!(positions.line_col(src.len(), ColumnUnit::Chars), (4, 1));
+    }
+
+    #[test]
+    fn lines_exclude_their_breaks() {
+        let src = "a\r\nbc\n\r\n\nd\r";
+        let positions = Positions::new(src);
+        let lines: Vec<&str> = (0..positions.line_count())
+            .map(|line| &src[positions.line(line)])
+            .collect();
+        assert_eq!(lines, ["a", "bc", "", "", "d\r"]);
+        assert_eq!(positions.line(9), src.len()..src.len());
+        assert_eq!(Positions::new("").line_count(), 1);
+        assert_eq!(Positions::new("x\n").line(1), 2..2);
+    }
+
+    #[test]
+    fn out_of_range_inputs_are_clamped() {
+        let src = "é\r\n😀x";
+        let positions = Positions::new(src);
+        // Inside `é`, on the `\r` and `\n` of the break, and past the end.
+        assert_eq!(positions.line_col(1, ColumnUnit::Bytes), (0, 0));
+        assert_eq!(positions.line_col(2, ColumnUnit::Chars), (0, 1));
+        assert_eq!(positions.line_col(3, ColumnUnit::Chars), (0, 1));
+        assert_eq!(positions.line_col(99, ColumnUnit::Utf16), (1, 3));
+
+        assert_eq!(positions.offset(0, 9, ColumnUnit::Chars), 2);
+        assert_eq!(positions.offset(1, 1, ColumnUnit::Utf16), 4, "inside a surrogate pair");
+        assert_eq!(positions.offset(1, 2, ColumnUnit::Bytes), 4, "inside a UTF-8 sequence");
+        assert_eq!(positions.offset(5, 0, ColumnUnit::Bytes), src.len());
+    }
+
+    #[test]
+    fn the_index_is_built_on_first_lookup() {
+        let positions = Positions::new("a\nb\n");
+        assert!(positions.starts.get().is_none());
+        assert_eq!(positions.line_col(2, ColumnUnit::Bytes), (1, 0));
+        assert_eq!(positions.starts.get().map(Vec::len), Some(3));
+    }
+}
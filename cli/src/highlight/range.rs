@@ -0,0 +1,457 @@
+//! Highlighting selected lines of a document, for search results and other excerpts.
+
+use super::formatters::Position;
+use super::{CancelToken, Formatter, HighlightError, Lexer, Theme};
+
+use std::ops::{Range, RangeInclusive};
+
+/// Most lines a [Snippet] shows; a match spanning more, or a run of merged windows longer than this, is cut short.
+pub const MAX_SNIPPET_LINES: usize = 100;
+
+/// How [highlight_ranges] widens and joins the requested lines.
+#[derive(Debug, Clone, Default)]
+pub struct RangeOptions {
+    context: usize,
+    separator: Option<String>,
+}
+
+impl RangeOptions {
+    /// Creates options showing only the requested lines, with nothing between windows.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Also shows `lines` lines before and after each range, like `grep -C`.
+    pub fn with_context(mut self, lines: usize) -> Self {
+        self.context = lines;
+        self
+    }
+
+    /// Writes `separator` on a line of its own between windows that aren't adjacent, like the `--` grep prints
+    /// between groups of matches.
+    ///
+    /// The separator is written as is, so for markup formats it must already be escaped.
+    pub fn with_separator(mut self, separator: impl Into<String>) -> Self {
+        self.separator = Some(separator.into());
+        self
+    }
+}
+
+/// Highlights lines `lines` (1-based, inclusive) of `src` with `context` lines around them.
+///
+/// A shorthand for [highlight_ranges] with a single range.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::{Theme, highlight_range};
+/// use colorizer::highlight::formatters::AnsiFormatter;
+/// use colorizer::highlight::lexers::PlainText;
+/// use colorizer::terminal::ColorProfile;
+///
+/// let theme = Theme::new("Plain", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+/// let formatter = AnsiFormatter::new().with_profile(ColorProfile::NoColor).with_line_numbers(true);
+/// let out = highlight_range("a\nb\nc\nd\ne\n", 3..=3, 1, &PlainText, &theme, &formatter).unwrap();
+/// assert_eq!(out, "2 b\n3 c\n4 d\n");
+/// ```
+pub fn highlight_range(
+    src: &str, lines: RangeInclusive<usize>, context: usize, lexer: &dyn Lexer, theme: &Theme,
+    formatter: &dyn Formatter,
+) -> Result<String, HighlightError> {
+    let options = RangeOptions::new().with_context(context);
+    highlight_ranges(src, [lines], &options, lexer, theme, formatter)
+}
+
+/// Highlights only the windows of `src` around `ranges` of lines (1-based, inclusive), in document order.
+///
+/// Each range is widened by the context lines and clamped to the document, and windows that overlap or touch are
+/// merged, so every line is written at most once. Lexing starts at the top of the document, so strings and block
+/// comments that open before a window are still styled inside it, and stops after the last window. Each window is
+/// formatted as a batch starting at its own line, so line numbers (see `with_line_numbers` on the formatters) are the
+/// lines' numbers in the document, and the gutter is as wide as the whole document needs. Formatters that close their
+/// styles at line ends, as [super::formatters::AnsiFormatter] does, produce windows that stand alone.
+///
+/// The formatter's header and footer are written once, around all the windows.
+pub fn highlight_ranges(
+    src: &str, ranges: impl IntoIterator<Item = RangeInclusive<usize>>, options: &RangeOptions, lexer: &dyn Lexer,
+    theme: &Theme, formatter: &dyn Formatter,
+) -> Result<String, HighlightError> {
+    ranges_cancellable(src, ranges, options, lexer, theme, formatter, &CancelToken::new())
+}
+
+/// Like [highlight_ranges], but stops with [HighlightError::Cancelled] once `cancel` fires, checking before every
+/// line it lexes.
+pub(crate) fn ranges_cancellable(
+    src: &str, ranges: impl IntoIterator<Item = RangeInclusive<usize>>, options: &RangeOptions, lexer: &dyn Lexer,
+    theme: &Theme, formatter: &dyn Formatter, cancel: &CancelToken,
+) -> Result<String, HighlightError> {
+    let lines: Vec<&str> = src.split_inclusive('\n').collect();
+    let total = src.lines().count();
+    let windows = windows(ranges, options.context, total);
+
+    let mut out = String::new();
+    let mut position = Position::new().with_total_lines(total);
+    let mut state = lexer.start();
+    let mut tokens = Vec::new();
+    let (mut line, mut offset) = (0, 0);
+    formatter.write_header(theme, &mut out);
+    for (index, window) in windows.iter().enumerate() {
+        // Lex the lines in between only for the state they leave behind.
+        while line < *window.start() {
+            cancel.check(offset)?;
+            tokens.clear();
+            state.tokenize_line(lines[line], 0, &mut tokens)?;
+            offset += lines[line].len();
+            line += 1;
+        }
+
+        if index > 0
+            && let Some(separator) = &options.separator
+        {
+            out.push_str(separator);
+            out.push('\n');
+        }
+        let start = offset;
+        tokens.clear();
+        while line <= *window.end() {
+            cancel.check(offset)?;
+            state.tokenize_line(lines[line], offset - start, &mut tokens)?;
+            offset += lines[line].len();
+            line += 1;
+        }
+        position = Position {
+            line: *window.start(),
+            mid_line: false,
+            offset: start,
+            column: 0,
+            column_bytes: 0,
+            column_utf16: 0,
+            ..position
+        };
+        let text = &src[start..offset];
+        formatter.write_tokens(text, &tokens, theme, position, &mut out);
+        position.advance(text);
+    }
+    formatter.write_footer(theme, position, &mut out);
+    Ok(out)
+}
+
+/// One excerpt of a document around search matches, as returned by [extract_snippets].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Snippet {
+    /// One-based number of the snippet's first line in the document.
+    pub line: usize,
+    /// The formatted excerpt, header and footer included.
+    pub output: String,
+    /// `true` when the snippet was cut at [MAX_SNIPPET_LINES], so matches or context after its last line are left
+    /// out.
+    pub truncated: bool,
+}
+
+/// Highlights the lines of `src` around each of `matches` (byte ranges, as a search returns them) with `context`
+/// lines on either side, emphasizing the matched text itself.
+///
+/// Windows that overlap or touch are merged into one [Snippet], in document order. Lexing starts at the top of the
+/// document, as in [highlight_ranges], so a match inside a multi-line string keeps the string's color. Matches are
+/// painted on [Theme::search_match_color] behind their token colors through [Formatter::write_emphasized], and may
+/// span several tokens or lines. Each snippet is a complete rendering with its own header and footer, numbered (see
+/// `with_line_numbers` on the formatters) with the lines' numbers in the document.
+///
+/// Matches that reach past the end of `src` or split a character are ignored. An empty match still shows its line.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::{Theme, extract_snippets};
+/// use colorizer::highlight::formatters::HtmlFormatter;
+/// use colorizer::highlight::lexers::PlainText;
+///
+/// let theme = Theme::new("Plain", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+/// let formatter = HtmlFormatter::new().with_classes("");
+/// let src = "one\ntwo\nthree\nfour\n";
+/// let snippets = extract_snippets(src, [4..7], 1, &PlainText, &theme, &formatter).unwrap();
+/// assert_eq!(snippets.len(), 1);
+/// assert_eq!(snippets[0].line, 1);
+/// let out = &snippets[0].output;
+/// assert!(out.contains("one\n<span class=\"match\">two</span>\nthree\n"), "{out}");
+/// ```
+pub fn extract_snippets(
+    src: &str, matches: impl IntoIterator<Item = Range<usize>>, context: usize, lexer: &dyn Lexer, theme: &Theme,
+    formatter: &dyn Formatter,
+) -> Result<Vec<Snippet>, HighlightError> {
+    let lines: Vec<&str> = src.split_inclusive('\n').collect();
+    let total = lines.len();
+    let starts: Vec<usize> = lines
+        .iter()
+        .scan(0, |offset, line| {
+            let start = *offset;
+            *offset += line.len();
+            Some(start)
+        })
+        .collect();
+    let line_of = |offset: usize| starts.partition_point(|&start| start <= offset).saturating_sub(1);
+
+    let mut matches: Vec<Range<usize>> = matches
+        .into_iter()
+        .filter(|range| {
+            range.start <= range.end
+                && range.end <= src.len()
+                && src.is_char_boundary(range.start)
+                && src.is_char_boundary(range.end)
+        })
+        .collect();
+    matches.sort_by_key(|range| range.start);
+    let mut emphasis: Vec<Range<usize>> = Vec::with_capacity(matches.len());
+    for range in &matches {
+        match emphasis.last_mut() {
+            Some(last) if range.start <= last.end => last.end = last.end.max(range.end),
+            _ if range.is_empty() => {}
+            _ => emphasis.push(range.clone()),
+        }
+    }
+    // A match ending just after a newline doesn't reach into the next line.
+    let ranges = matches
+        .iter()
+        .map(|range| line_of(range.start) + 1..=line_of(range.end.saturating_sub(1).max(range.start)) + 1);
+    let windows = windows(ranges, context, total);
+
+    let mut snippets = Vec::with_capacity(windows.len());
+    let mut state = lexer.start();
+    let mut tokens = Vec::new();
+    let mut line = 0;
+    for window in windows {
+        let last = (*window.end()).min(window.start() + MAX_SNIPPET_LINES - 1);
+        // Lex the lines in between only for the state they leave behind.
+        while line < *window.start() {
+            tokens.clear();
+            state.tokenize_line(lines[line], 0, &mut tokens)?;
+            line += 1;
+        }
+
+        let (start, end) = (starts[line], starts[last] + lines[last].len());
+        tokens.clear();
+        while line <= last {
+            state.tokenize_line(lines[line], starts[line] - start, &mut tokens)?;
+            line += 1;
+        }
+        let marks: Vec<Range<usize>> = emphasis
+            .iter()
+            .filter(|range| range.start < end && range.end > start)
+            .map(|range| range.start.max(start) - start..range.end.min(end) - start)
+            .collect();
+
+        let text = &src[start..end];
+        let mut position = Position { line: *window.start(), offset: start, ..Position::new().with_total_lines(total) };
+        let mut output = String::new();
+        formatter.write_header(theme, &mut output);
+        formatter.write_emphasized(text, &tokens, &marks, theme, position, &mut output);
+        position.advance(text);
+        formatter.write_footer(theme, position, &mut output);
+        snippets.push(Snippet { line: window.start() + 1, output, truncated: last < *window.end() });
+    }
+    Ok(snippets)
+}
+
+/// Converts 1-based `ranges` into sorted, merged, zero-based windows of the `total` lines, widened by `context`.
+///
+/// Windows separated by at least one line stay apart.
+fn windows(
+    ranges: impl IntoIterator<Item = RangeInclusive<usize>>, context: usize, total: usize,
+) -> Vec<RangeInclusive<usize>> {
+    let mut windows: Vec<_> = ranges
+        .into_iter()
+        .filter(|range| !range.is_empty() && *range.start() <= total && *range.end() >= 1)
+        .map(|range| {
+            let start = range.start().max(&1) - 1;
+            let end = (range.end() - 1).saturating_add(context).min(total - 1);
+            start.saturating_sub(context)..=end
+        })
+        .collect();
+    windows.sort_by_key(|window| *window.start());
+
+    let mut merged: Vec<RangeInclusive<usize>> = Vec::with_capacity(windows.len());
+    for window in windows {
+        match merged.last_mut() {
+            Some(last) if *window.start() <= last.end() + 1 => {
+                *last = *last.start()..=*last.end().max(window.end());
+            }
+            _ => merged.push(window),
+        }
+    }
+    merged
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::TokenKind;
+    use crate::highlight::formatters::{AnsiFormatter, HtmlFormatter};
+    use crate::highlight::lexers::{PlainText, Shell};
+    use crate::terminal::ColorProfile;
+
+    use std::iter;
+
+    fn theme() -> Theme {
+        let mut theme = Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        theme.set(TokenKind::String, Srgb8::new(0, 255, 0));
+        theme
+    }
+
+    fn plain() -> AnsiFormatter {
+        AnsiFormatter::new()
+            .with_profile(ColorProfile::NoColor)
+            .with_line_numbers(true)
+    }
+
+    fn numbered(total: usize) -> String {
+        (1..=total).map(|line| format!("line {line}\n")).collect()
+    }
+
+    #[test]
+    fn windows_are_widened_clamped_and_merged() {
+        assert_eq!(windows([5..=5], 2, 10), [2..=6]);
+        assert_eq!(windows([1..=1, 10..=12], 2, 10), [0..=2, 7..=9]);
+        // Windows that touch merge; a one-line gap keeps them apart.
+        assert_eq!(windows([6..=6, 2..=2], 1, 10), [0..=2, 4..=6]);
+        assert_eq!(windows([2..=2, 5..=5], 1, 10), [0..=5]);
+        assert_eq!(windows([3..=4, 4..=8], 0, 10), [2..=7]);
+        // Ranges entirely outside the document, or empty, are dropped.
+        #[allow(clippy::reversed_empty_ranges)]
+        let empty = 4..=3;
+        assert_eq!(windows([11..=12, 0..=0, empty], 3, 10), []);
+        assert_eq!(windows([1..=1], 1, 0), []);
+    }
+
+    #[test]
+    fn windows_carry_document_line_numbers() {
+        let src = numbered(12);
+        let options = RangeOptions::new().with_context(1).with_separator("--");
+        let out = highlight_ranges(&src, [3..=3, 10..=10], &options, &PlainText, &theme(), &plain()).unwrap();
+        assert_eq!(
+            out,
+            " 2 line 2\n 3 line 3\n 4 line 4\n--\n 9 line 9\n10 line 10\n11 line 11\n"
+        );
+    }
+
+    #[test]
+    fn adjacent_windows_have_no_separator() {
+        let src = numbered(6);
+        let options = RangeOptions::new().with_context(1).with_separator("…");
+        let out = highlight_ranges(&src, [2..=2, 5..=5], &options, &PlainText, &theme(), &plain()).unwrap();
+        assert_eq!(out, "1 line 1\n2 line 2\n3 line 3\n4 line 4\n5 line 5\n6 line 6\n");
+    }
+
+    #[test]
+    fn last_line_without_newline_ends_the_output() {
+        let out = highlight_range("a\nb\nc", 3..=3, 0, &PlainText, &theme(), &plain()).unwrap();
+        assert_eq!(out, "3 c");
+    }
+
+    #[test]
+    fn strings_opened_before_the_window_keep_their_style() {
+        let src = "echo \"one\ntwo\nthree\"\nls\n";
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::TrueColor);
+        let out = highlight_range(src, 2..=2, 0, &Shell, &theme(), &formatter).unwrap();
+        // The window is one self-contained line: the string's color opens at its start and resets before its end.
+        assert_eq!(out, "\x1b[38;2;0;255;0mtwo\x1b[0m\n");
+
+        let full = crate::highlight::highlight(src, &Shell, &theme(), &formatter).unwrap();
+        assert!(full.contains(&out), "{full:?}");
+    }
+
+    #[test]
+    fn nearby_matches_share_a_snippet() {
+        let src = numbered(12);
+        let at = |line: usize| src.find(&format!("line {line}\n")).unwrap();
+        let matches = [at(10)..at(10) + 4, at(3)..at(3) + 4, at(4)..at(4) + 4];
+        let snippets = extract_snippets(&src, matches, 1, &PlainText, &theme(), &plain()).unwrap();
+        let found: Vec<_> = snippets
+            .iter()
+            .map(|snippet| (snippet.line, snippet.output.as_str()))
+            .collect();
+        assert_eq!(
+            found,
+            [
+                (2, " 2 line 2\n 3 line 3\n 4 line 4\n 5 line 5\n"),
+                (9, " 9 line 9\n10 line 10\n11 line 11\n")
+            ]
+        );
+        assert!(snippets.iter().all(|snippet| !snippet.truncated));
+    }
+
+    #[test]
+    fn matches_across_lines_keep_the_string_style() {
+        let src = "echo \"one\ntwo\nthree\"\nls\n";
+        let start = src.find("ne").unwrap();
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::TrueColor);
+        let snippets = extract_snippets(src, iter::once(start..start + 8), 0, &Shell, &theme(), &formatter).unwrap();
+        assert_eq!(snippets.len(), 1);
+        // The string's color stays on throughout, with the match background behind "ne", "two", and "t".
+        let (text, string, matched) = (
+            "\x1b[38;2;200;200;200m",
+            "\x1b[38;2;0;255;0m",
+            "\x1b[38;2;0;255;0;48;2;79;58;10m",
+        );
+        assert_eq!(
+            snippets[0].output,
+            format!(
+                "{text}echo\x1b[0m {string}\"o\x1b[0m{matched}ne\x1b[0m\n{matched}two\x1b[0m\n{matched}t\x1b[0m{string}hree\"\x1b[0m\n"
+            )
+        );
+    }
+
+    #[test]
+    fn html_matches_close_at_line_ends() {
+        let formatter = HtmlFormatter::new().with_classes("").with_line_numbers(true);
+        let src = "ab\ncd\n";
+        let snippets = extract_snippets(src, iter::once(1..4), 0, &PlainText, &theme(), &formatter).unwrap();
+        assert_eq!(
+            snippets[0].output,
+            "<pre class=\"pre\"><code><span class=\"line\"><span class=\"ln\" data-line=\"1\"></span>a\
+             <span class=\"match\">b</span>\n</span><span class=\"line\"><span class=\"ln\" data-line=\"2\"></span>\
+             <span class=\"match\">c</span>d\n</span></code></pre>"
+        );
+        assert!(
+            formatter
+                .css(&theme())
+                .contains(".match { background-color: #4f3a0a; }")
+        );
+    }
+
+    #[test]
+    fn long_snippets_are_truncated() {
+        let src = numbered(MAX_SNIPPET_LINES + 20);
+        let snippets = extract_snippets(&src, iter::once(0..src.len()), 2, &PlainText, &theme(), &plain()).unwrap();
+        assert_eq!(snippets.len(), 1);
+        assert!(snippets[0].truncated);
+        assert_eq!(snippets[0].output.lines().count(), MAX_SNIPPET_LINES);
+    }
+
+    #[test]
+    fn invalid_matches_are_ignored() {
+        let src = "héllo\nworld\n";
+        let snippets = extract_snippets(src, [2..3, 5..40, 7..7], 0, &PlainText, &theme(), &plain()).unwrap();
+        let found: Vec<_> = snippets
+            .iter()
+            .map(|snippet| (snippet.line, snippet.output.as_str()))
+            .collect();
+        assert_eq!(found, [(2, "2 world\n")]);
+    }
+
+    #[test]
+    fn header_and_footer_wrap_all_windows() {
+        let formatter = HtmlFormatter::new().with_classes("").with_line_numbers(true);
+        let options = RangeOptions::new().with_separator("<hr>");
+        let out = highlight_ranges(&numbered(5), [1..=1, 4..=4], &options, &PlainText, &theme(), &formatter).unwrap();
+        assert!(out.starts_with("<pre class=\"pre\"><code>"), "{out}");
+        assert!(out.ends_with("</code></pre>"), "{out}");
+        assert!(
+            out.contains("data-line=\"1\"></span>line 1\n</span><hr>\n<span"),
+            "{out}"
+        );
+        assert!(out.contains("data-line=\"4\"></span>line 4\n</span></code>"), "{out}");
+    }
+}
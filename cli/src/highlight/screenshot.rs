@@ -0,0 +1,578 @@
+//! Rendering highlighted code to images, like the code screenshots shared in slides and posts.
+
+use super::lexers::Lexer;
+use super::{HighlightError, Style, Theme, Token};
+use crate::colors::Srgb8;
+
+use image::{Rgb, RgbImage};
+use rusttype::{Font, Scale, point};
+
+/// Spacing between baselines, as a multiple of the font size.
+const LINE_HEIGHT: f32 = 1.5;
+
+/// Empty columns between the line numbers and the code.
+const GUTTER_GAP: usize = 2;
+
+/// Height of the window title bar, which holds the three buttons, before scaling.
+const CHROME_HEIGHT: f32 = 28.0;
+
+/// Radius of a title bar button and the distance between their centers, before scaling.
+const BUTTON_RADIUS: f32 = 6.0;
+const BUTTON_SPACING: f32 = 20.0;
+
+/// Close, minimize, and zoom button colors, in that order.
+const BUTTONS: [Srgb8; 3] = [
+    Srgb8::new(0xff, 0x5f, 0x56),
+    Srgb8::new(0xff, 0xbd, 0x2e),
+    Srgb8::new(0x27, 0xc9, 0x3f),
+];
+
+const ELLIPSIS: char = '…';
+
+static DEJAVU_SANS_MONO: &[u8] = include_bytes!("../../assets/fonts/DejaVuSansMono.ttf");
+static DEJAVU_SANS_MONO_BOLD: &[u8] = include_bytes!("../../assets/fonts/DejaVuSansMono-Bold.ttf");
+
+/// Typefaces text is drawn in. Styles without a face of their own use the closest one that is set, down to
+/// [Faces::regular].
+#[derive(Clone)]
+pub struct Faces {
+    regular: Font<'static>,
+    bold: Option<Font<'static>>,
+    italic: Option<Font<'static>>,
+    bold_italic: Option<Font<'static>>,
+}
+
+impl Faces {
+    /// Draws every style in `regular` until other faces are added.
+    pub fn new(regular: Font<'static>) -> Self {
+        Self { regular, bold: None, italic: None, bold_italic: None }
+    }
+
+    /// The bundled DejaVu Sans Mono, in regular and bold; italics use the regular face.
+    pub fn embedded() -> Self {
+        let regular = Font::try_from_bytes(DEJAVU_SANS_MONO).expect("bundled font parses");
+        let bold = Font::try_from_bytes(DEJAVU_SANS_MONO_BOLD).expect("bundled font parses");
+        Self::new(regular).with_bold(bold)
+    }
+
+    pub fn with_bold(mut self, font: Font<'static>) -> Self {
+        self.bold = Some(font);
+        self
+    }
+
+    pub fn with_italic(mut self, font: Font<'static>) -> Self {
+        self.italic = Some(font);
+        self
+    }
+
+    pub fn with_bold_italic(mut self, font: Font<'static>) -> Self {
+        self.bold_italic = Some(font);
+        self
+    }
+
+    /// Face for a resolved style; bold italic falls back to bold, then italic.
+    fn for_style(&self, style: Style) -> &Font<'static> {
+        let bold = style.bold == Some(true);
+        let italic = style.italic == Some(true);
+        let face = match (bold, italic) {
+            (true, true) => self
+                .bold_italic
+                .as_ref()
+                .or(self.bold.as_ref())
+                .or(self.italic.as_ref()),
+            (true, false) => self.bold.as_ref(),
+            (false, true) => self.italic.as_ref(),
+            (false, false) => None,
+        };
+        face.unwrap_or(&self.regular)
+    }
+}
+
+impl Default for Faces {
+    fn default() -> Self {
+        Self::embedded()
+    }
+}
+
+/// What to do with lines longer than the image is allowed to be.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum Overflow {
+    /// Widen the image to fit the longest line.
+    #[default]
+    Extend,
+    /// Continue lines past this many columns on the next row, without a line number.
+    Wrap(usize),
+    /// Cut lines off at this many columns, ending them with an ellipsis.
+    Clip(usize),
+}
+
+/// Layout and decoration settings for [render].
+///
+/// Lengths are in logical pixels and multiplied by [ImageOptions::with_scale], so the same options give a sharper
+/// image at 2x for high-density displays.
+#[derive(Clone)]
+pub struct ImageOptions {
+    faces: Faces,
+    font_size: f32,
+    scale: f32,
+    padding: f32,
+    tab_width: usize,
+    line_numbers: bool,
+    window_chrome: bool,
+    overflow: Overflow,
+}
+
+impl ImageOptions {
+    /// 16px [Faces::embedded] text in 32px of padding, at 1x, with no line numbers or title bar.
+    pub fn new() -> Self {
+        Self {
+            faces: Faces::embedded(),
+            font_size: 16.0,
+            scale: 1.0,
+            padding: 32.0,
+            tab_width: 4,
+            line_numbers: false,
+            window_chrome: false,
+            overflow: Overflow::Extend,
+        }
+    }
+
+    pub fn with_faces(mut self, faces: Faces) -> Self {
+        self.faces = faces;
+        self
+    }
+
+    pub fn with_font_size(mut self, size: f32) -> Self {
+        self.font_size = size;
+        self
+    }
+
+    /// Multiplies every length, e.g., 2.0 for retina output.
+    pub fn with_scale(mut self, scale: f32) -> Self {
+        self.scale = scale;
+        self
+    }
+
+    /// Space between the code (or title bar) and the image edge.
+    pub fn with_padding(mut self, padding: f32) -> Self {
+        self.padding = padding;
+        self
+    }
+
+    /// Sets the column interval of tab stops.
+    pub fn with_tab_width(mut self, width: usize) -> Self {
+        self.tab_width = width.max(1);
+        self
+    }
+
+    /// Numbers lines in a gutter styled with [Theme::gutter_style].
+    pub fn with_line_numbers(mut self, enabled: bool) -> Self {
+        self.line_numbers = enabled;
+        self
+    }
+
+    /// Draws a title bar with close, minimize, and zoom buttons above the code.
+    pub fn with_window_chrome(mut self, enabled: bool) -> Self {
+        self.window_chrome = enabled;
+        self
+    }
+
+    pub fn with_overflow(mut self, overflow: Overflow) -> Self {
+        self.overflow = overflow;
+        self
+    }
+}
+
+impl Default for ImageOptions {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+/// Tokenizes `src` with `lexer` and draws it on the theme's background.
+///
+/// Every character advances by the width of the regular face's `M`, so faces should be monospaced; tabs are expanded
+/// to spaces first and control characters are left out. Token backgrounds, underlines, and strikethroughs are drawn;
+/// characters wider than one column overlap their neighbors.
+///
+/// Rendering is deterministic: the same input and options always give the same pixels, so images can be compared in
+/// snapshot tests.
+pub fn render(src: &str, lexer: &dyn Lexer, theme: &Theme, options: &ImageOptions) -> Result<RgbImage, HighlightError> {
+    let tokens = lexer.tokenize(src)?;
+    Ok(draw(src, &tokens, theme, options))
+}
+
+/// A character placed in the grid, with the style it is drawn in.
+#[derive(Debug, Clone, Copy, PartialEq)]
+struct Cell {
+    ch: char,
+    style: Style,
+}
+
+/// One row of the image: a source line, or the part of one that wrapped.
+#[derive(Debug, Clone, PartialEq)]
+struct Row {
+    /// 1-based line number, left out on wrapped continuations.
+    number: Option<usize>,
+    cells: Vec<Cell>,
+}
+
+/// Splits the tokens into rows of cells, expanding tabs and applying `overflow`.
+fn layout(src: &str, tokens: &[Token], theme: &Theme, tab_width: usize, overflow: Overflow) -> Vec<Row> {
+    let mut lines = Vec::new();
+    let mut cells = Vec::new();
+    for token in tokens {
+        let style = theme.style_for(token.kind);
+        for ch in token.text(src).chars() {
+            match ch {
+                '\n' => lines.push(std::mem::take(&mut cells)),
+                '\t' => {
+                    let stop = (cells.len() / tab_width + 1) * tab_width;
+                    cells.resize(stop, Cell { ch: ' ', style });
+                }
+                ch if ch.is_control() => {}
+                ch => cells.push(Cell { ch, style }),
+            }
+        }
+    }
+    if !cells.is_empty() {
+        lines.push(cells);
+    }
+
+    let mut rows = Vec::with_capacity(lines.len());
+    for (index, mut cells) in lines.into_iter().enumerate() {
+        let number = Some(index + 1);
+        match overflow {
+            Overflow::Wrap(columns) if cells.len() > columns.max(1) => {
+                for (part, chunk) in cells.chunks(columns.max(1)).enumerate() {
+                    rows.push(Row { number: number.filter(|_| part == 0), cells: chunk.to_vec() });
+                }
+            }
+            Overflow::Clip(columns) if cells.len() > columns => {
+                cells.truncate(columns.saturating_sub(1));
+                cells.push(Cell { ch: ELLIPSIS, style: theme.gutter_style() });
+                rows.push(Row { number, cells });
+            }
+            _ => rows.push(Row { number, cells }),
+        }
+    }
+    rows
+}
+
+/// Pixel measurements for one [draw] call.
+struct Metrics {
+    scale: Scale,
+    advance: f32,
+    line_height: f32,
+    /// Distance from the top of a row to its baseline.
+    baseline: f32,
+    padding: f32,
+    chrome: f32,
+    gutter_columns: usize,
+}
+
+impl Metrics {
+    fn new(options: &ImageOptions, rows: &[Row]) -> Self {
+        let size = options.font_size * options.scale;
+        let scale = Scale::uniform(size);
+        let regular = &options.faces.regular;
+        let advance = regular.glyph('M').scaled(scale).h_metrics().advance_width;
+        let v_metrics = regular.v_metrics(scale);
+        let line_height = (size * LINE_HEIGHT).round();
+        let baseline = ((line_height - (v_metrics.ascent - v_metrics.descent)) / 2.0 + v_metrics.ascent).round();
+
+        let gutter_columns = match rows.iter().rev().find_map(|row| row.number) {
+            Some(last) if options.line_numbers => last.to_string().len() + GUTTER_GAP,
+            _ => 0,
+        };
+        Self {
+            scale,
+            advance,
+            line_height,
+            baseline,
+            padding: (options.padding * options.scale).round(),
+            chrome: if options.window_chrome { (CHROME_HEIGHT * options.scale).round() } else { 0.0 },
+            gutter_columns,
+        }
+    }
+
+    fn size(&self, rows: &[Row]) -> (u32, u32) {
+        let columns = rows.iter().map(|row| row.cells.len()).max().unwrap_or(0) + self.gutter_columns;
+        let width = 2.0 * self.padding + columns as f32 * self.advance;
+        let height = 2.0 * self.padding + self.chrome + rows.len() as f32 * self.line_height;
+        (width.ceil() as u32, height.ceil() as u32)
+    }
+
+    /// Left edge of grid column `column`, counting the gutter.
+    fn x(&self, column: usize) -> f32 {
+        self.padding + column as f32 * self.advance
+    }
+
+    /// Top edge of row `row`.
+    fn y(&self, row: usize) -> f32 {
+        self.padding + self.chrome + row as f32 * self.line_height
+    }
+}
+
+fn draw(src: &str, tokens: &[Token], theme: &Theme, options: &ImageOptions) -> RgbImage {
+    let rows = layout(src, tokens, theme, options.tab_width, options.overflow);
+    let metrics = Metrics::new(options, &rows);
+    let (width, height) = metrics.size(&rows);
+    let mut canvas = Canvas {
+        image: RgbImage::from_pixel(width.max(1), height.max(1), pixel(theme.background)),
+        faces: &options.faces,
+        foreground: theme.foreground,
+        thickness: options.scale.round().max(1.0),
+        metrics,
+    };
+
+    if options.window_chrome {
+        let radius = BUTTON_RADIUS * options.scale;
+        let y = canvas.metrics.padding + radius;
+        for (i, &color) in BUTTONS.iter().enumerate() {
+            let x = canvas.metrics.padding + radius + i as f32 * BUTTON_SPACING * options.scale;
+            fill_circle(&mut canvas.image, x, y, radius, color);
+        }
+    }
+
+    let gutter = theme.gutter_style();
+    let gutter_columns = canvas.metrics.gutter_columns;
+    for (index, row) in rows.iter().enumerate() {
+        if let Some(number) = row.number.filter(|_| options.line_numbers) {
+            let digits = number.to_string();
+            let start = gutter_columns - GUTTER_GAP - digits.len();
+            for (i, ch) in digits.chars().enumerate() {
+                canvas.draw_cell(Cell { ch, style: gutter }, index, start + i);
+            }
+        }
+        for (column, &cell) in row.cells.iter().enumerate() {
+            canvas.draw_cell(cell, index, gutter_columns + column);
+        }
+    }
+    canvas.image
+}
+
+/// The image being drawn and what is needed to place text on it.
+struct Canvas<'a> {
+    image: RgbImage,
+    faces: &'a Faces,
+    metrics: Metrics,
+    foreground: Srgb8,
+    /// Thickness of underlines and strikethroughs.
+    thickness: f32,
+}
+
+impl Canvas<'_> {
+    /// Draws one character's background, glyph, and decorations at grid position (`row`, `column`).
+    fn draw_cell(&mut self, cell: Cell, row: usize, column: usize) {
+        let Cell { ch, style } = cell;
+        let Metrics { scale, advance, line_height, baseline, .. } = self.metrics;
+        let (left, top) = (self.metrics.x(column), self.metrics.y(row));
+        let right = left + advance;
+        if let Some(background) = style.background {
+            fill_rect(&mut self.image, left, top, right, top + line_height, background);
+        }
+
+        let color = style.foreground.unwrap_or(self.foreground);
+        let baseline = top + baseline;
+        if !ch.is_whitespace() {
+            let glyph = self
+                .faces
+                .for_style(style)
+                .glyph(ch)
+                .scaled(scale)
+                .positioned(point(left, baseline));
+            if let Some(bounds) = glyph.pixel_bounding_box() {
+                let image = &mut self.image;
+                glyph.draw(|x, y, coverage| {
+                    blend(image, bounds.min.x + x as i32, bounds.min.y + y as i32, color, coverage);
+                });
+            }
+        }
+
+        let thickness = self.thickness;
+        if style.underline == Some(true) {
+            let y = baseline + thickness;
+            fill_rect(&mut self.image, left, y, right, y + thickness, color);
+        }
+        if style.strikethrough == Some(true) {
+            let y = (top + line_height / 2.0).round();
+            fill_rect(&mut self.image, left, y, right, y + thickness, color);
+        }
+    }
+}
+
+fn pixel(color: Srgb8) -> Rgb<u8> {
+    Rgb([color.r, color.g, color.b])
+}
+
+/// Mixes `color` into the pixel at (`x`, `y`) by `coverage` (0 to 1), ignoring pixels outside the image.
+fn blend(image: &mut RgbImage, x: i32, y: i32, color: Srgb8, coverage: f32) {
+    if x < 0 || y < 0 || x as u32 >= image.width() || y as u32 >= image.height() {
+        return;
+    }
+    let coverage = coverage.clamp(0.0, 1.0);
+    let target = image.get_pixel_mut(x as u32, y as u32);
+    for (channel, value) in [color.r, color.g, color.b].into_iter().enumerate() {
+        let current = f32::from(target[channel]);
+        target[channel] = (current + (f32::from(value) - current) * coverage).round() as u8;
+    }
+}
+
+/// Fills the pixels whose centers lie in `[left, right) × [top, bottom)`.
+fn fill_rect(image: &mut RgbImage, left: f32, top: f32, right: f32, bottom: f32, color: Srgb8) {
+    let span = |from: f32, to: f32| (from - 0.5).ceil().max(0.0) as i32..(to - 0.5).ceil().max(0.0) as i32;
+    for y in span(top, bottom) {
+        for x in span(left, right) {
+            blend(image, x, y, color, 1.0);
+        }
+    }
+}
+
+/// Fills a circle, anti-aliasing its edge by how far each pixel center is from it.
+fn fill_circle(image: &mut RgbImage, cx: f32, cy: f32, radius: f32, color: Srgb8) {
+    let (left, right) = ((cx - radius).floor() as i32, (cx + radius).ceil() as i32);
+    let (top, bottom) = ((cy - radius).floor() as i32, (cy + radius).ceil() as i32);
+    for y in top..bottom {
+        for x in left..right {
+            let distance = ((x as f32 + 0.5 - cx).powi(2) + (y as f32 + 0.5 - cy).powi(2)).sqrt();
+            blend(image, x, y, color, radius + 0.5 - distance);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::TokenKind;
+    use crate::highlight::lexers::PlainText;
+
+    fn theme() -> Theme {
+        let mut theme = Theme::new("Test", Srgb8::new(0x10, 0x10, 0x10), Srgb8::new(0xee, 0xee, 0xee));
+        theme.set(TokenKind::Keyword, Srgb8::new(0xff, 0x00, 0x80));
+        theme
+    }
+
+    /// Tokens covering `src` that mark every `fn` as a keyword.
+    fn keywords(src: &str) -> Vec<Token> {
+        let mut tokens = Vec::new();
+        let mut start = 0;
+        for (at, _) in src.match_indices("fn") {
+            tokens.push(Token::new(TokenKind::Text, start, at));
+            tokens.push(Token::new(TokenKind::Keyword, at, at + 2));
+            start = at + 2;
+        }
+        tokens.push(Token::new(TokenKind::Text, start, src.len()));
+        tokens.retain(|token| token.start < token.end);
+        tokens
+    }
+
+    fn text(row: &Row) -> String {
+        row.cells.iter().map(|cell| cell.ch).collect()
+    }
+
+    fn texts(rows: &[Row]) -> Vec<(Option<usize>, String)> {
+        rows.iter().map(|row| (row.number, text(row))).collect()
+    }
+
+    fn rows(src: &str, overflow: Overflow) -> Vec<Row> {
+        layout(src, &keywords(src), &theme(), 4, overflow)
+    }
+
+    #[test]
+    fn layout_expands_tabs_and_drops_control_characters() {
+        assert_eq!(
+            texts(&rows("\tfn a\r\nb\tc\n\nd", Overflow::Extend)),
+            [
+                (Some(1), "    fn a".to_string()),
+                (Some(2), "b   c".to_string()),
+                (Some(3), String::new()),
+                (Some(4), "d".to_string()),
+            ]
+        );
+        assert!(rows("", Overflow::Extend).is_empty());
+        assert_eq!(rows("a\n", Overflow::Extend).len(), 1);
+        assert_eq!(
+            rows("fn x", Overflow::Extend)[0].cells[0].style,
+            theme().style_for(TokenKind::Keyword)
+        );
+    }
+
+    #[test]
+    fn long_lines_wrap_or_clip() {
+        let src = "abcdefghij\nxy\n";
+        assert_eq!(
+            texts(&rows(src, Overflow::Wrap(4))),
+            [
+                (Some(1), "abcd".to_string()),
+                (None, "efgh".to_string()),
+                (None, "ij".to_string()),
+                (Some(2), "xy".to_string()),
+            ]
+        );
+
+        let clipped = rows(src, Overflow::Clip(4));
+        assert_eq!(
+            texts(&clipped),
+            [(Some(1), "abc…".to_string()), (Some(2), "xy".to_string())]
+        );
+        assert_eq!(clipped[0].cells[3].style, theme().gutter_style());
+        assert_eq!(texts(&rows("abcd", Overflow::Clip(4))), [(Some(1), "abcd".to_string())]);
+    }
+
+    #[test]
+    fn size_follows_rows_columns_and_decorations() {
+        let src = "fn main() {}\nfn x\n";
+        let tokens = keywords(src);
+        let options = ImageOptions::new().with_padding(10.0);
+        let plain = draw(src, &tokens, &theme(), &options);
+        // 16px text on 24px rows between 10px of padding.
+        assert_eq!(plain.height(), 20 + 2 * 24);
+
+        let advance = Faces::embedded()
+            .regular
+            .glyph('M')
+            .scaled(Scale::uniform(16.0))
+            .h_metrics()
+            .advance_width;
+        assert_eq!(plain.width(), (20.0 + 12.0 * advance).ceil() as u32);
+
+        let numbered = draw(src, &tokens, &theme(), &options.clone().with_line_numbers(true));
+        assert_eq!(numbered.width(), (20.0 + 15.0 * advance).ceil() as u32);
+
+        let chrome = draw(src, &tokens, &theme(), &options.clone().with_window_chrome(true));
+        assert_eq!(chrome.height(), plain.height() + 28);
+
+        let retina = draw(src, &tokens, &theme(), &options.clone().with_scale(2.0));
+        assert_eq!(retina.height(), 2 * plain.height());
+
+        let wrapped = draw(src, &tokens, &theme(), &options.with_overflow(Overflow::Wrap(5)));
+        assert_eq!(wrapped.height(), 20 + 4 * 24);
+    }
+
+    #[test]
+    fn text_is_drawn_in_theme_colors_deterministically() {
+        let src = "fn main() {}\n";
+        let options = ImageOptions::new()
+            .with_scale(2.0)
+            .with_window_chrome(true)
+            .with_line_numbers(true);
+        let image = render(src, &PlainText, &theme(), &options).unwrap();
+        assert_eq!(image, render(src, &PlainText, &theme(), &options).unwrap());
+        assert_eq!(*image.get_pixel(0, 0), Rgb([0x10, 0x10, 0x10]));
+
+        let highlighted = draw(src, &keywords(src), &theme(), &options);
+        let pixels = |image: &RgbImage, color: Rgb<u8>| {
+            (0..image.height())
+                .flat_map(|y| (0..image.width()).map(move |x| (x, y)))
+                .filter(|&(x, y)| *image.get_pixel(x, y) == color)
+                .count()
+        };
+        assert_eq!(pixels(&image, Rgb([0xff, 0x00, 0x80])), 0);
+        assert!(pixels(&highlighted, Rgb([0xff, 0x00, 0x80])) > 0);
+        assert!(
+            pixels(&highlighted, pixel(BUTTONS[0])) > 0,
+            "title bar buttons are drawn"
+        );
+    }
+}
@@ -0,0 +1,369 @@
+//! Streaming highlighting over [BufRead]/[Write] with bounded memory.
+
+use super::formatters::Position;
+use super::input::{SNIFF_LEN, decode_chunk};
+use super::{Decoded, Formatter, HighlightError, InputOptions, Lexer, Theme};
+
+use std::io::{self, BufRead, Read, Write};
+
+/// Upper bound on the bytes tokenized at once; longer lines are fed to the lexer in pieces.
+const CHUNK_LIMIT: usize = 64 * 1024;
+
+/// Highlights `reader` into `writer` one line at a time.
+///
+/// Lexer state carries across lines, so strings and block comments that span lines are classified the same way
+/// as with [super::highlight]. Output is written after every line (or every 64 KiB of an overlong line), so memory
+/// use stays bounded no matter how large the input is. Wrap unbuffered writers such as files in a
+/// [std::io::BufWriter] to avoid a write call per line.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::{Theme, highlight_reader};
+/// use colorizer::highlight::formatters::HtmlFormatter;
+/// use colorizer::highlight::lexers::PlainText;
+///
+/// let theme = Theme::new("Plain", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+/// let mut out = Vec::new();
+/// highlight_reader("a\nb\n".as_bytes(), &mut out, &PlainText, &theme, &HtmlFormatter::new().with_classes("")).unwrap();
+/// assert_eq!(String::from_utf8(out).unwrap(), "<pre class=\"pre\"><code>a\nb\n</code></pre>");
+/// ```
+pub fn highlight_reader<R: BufRead, W: Write>(
+    reader: R, writer: W, lexer: &dyn Lexer, theme: &Theme, formatter: &dyn Formatter,
+) -> Result<(), HighlightError> {
+    highlight_reader_with(reader, writer, lexer, theme, formatter, &InputOptions::default())
+}
+
+/// Like [highlight_reader], but decodes the input following `options`.
+///
+/// The first 8 KiB are read ahead and checked with [super::is_binary] before anything is written. Token offsets in
+/// each batch refer to the decoded text; with invalid input, stand-ins make them drift from the input's byte offsets,
+/// which [super::decode] can map back for whole documents.
+pub fn highlight_reader_with<R: BufRead, W: Write>(
+    mut reader: R, mut writer: W, lexer: &dyn Lexer, theme: &Theme, formatter: &dyn Formatter, options: &InputOptions,
+) -> Result<(), HighlightError> {
+    let mut head = Vec::new();
+    (&mut reader).take(SNIFF_LEN as u64).read_to_end(&mut head)?;
+    options.check_binary(&head)?;
+    let mut reader = head.as_slice().chain(reader);
+
+    let mut state = lexer.start();
+    let mut bytes = Vec::new();
+    let mut tokens = Vec::new();
+    // Decoded text and output are buffers reused for every line.
+    let mut decoded = Decoded::default();
+    let mut out = String::new();
+    // The total line count is unknown while streaming, so formatters size gutters conservatively.
+    let mut position = Position::new();
+    let mut consumed = 0;
+
+    formatter.write_header(theme, &mut out);
+    loop {
+        let eof = read_chunk(&mut reader, &mut bytes)?;
+        let valid = match std::str::from_utf8(&bytes) {
+            Ok(text) => text.len(),
+            // A multi-byte character straddles the chunk boundary; finish it with the next read.
+            Err(err) if err.error_len().is_none() && !eof => err.valid_up_to(),
+            Err(_) => bytes.len(),
+        };
+
+        if valid > 0 {
+            decode_chunk(&bytes[..valid], consumed, options, &mut decoded)?;
+            let chunk = decoded.text();
+            tokens.clear();
+            state.tokenize_line(chunk, 0, &mut tokens)?;
+            decoded.mark_passed(&mut tokens);
+            formatter.write_tokens(chunk, &tokens, theme, position, &mut out);
+            position.advance(chunk);
+            writer.write_all(out.as_bytes())?;
+            out.clear();
+            bytes.drain(..valid);
+            consumed += valid;
+        }
+
+        if eof {
+            break;
+        }
+    }
+    formatter.write_footer(theme, position, &mut out);
+    writer.write_all(out.as_bytes())?;
+    writer.flush()?;
+    Ok(())
+}
+
+/// Appends bytes from `reader` to `bytes` up to and including the next newline, stopping early at [CHUNK_LIMIT].
+///
+/// Returns `true` once the reader is exhausted.
+fn read_chunk<R: BufRead>(reader: &mut R, bytes: &mut Vec<u8>) -> io::Result<bool> {
+    loop {
+        let available = match reader.fill_buf() {
+            Ok(available) => available,
+            Err(err) if err.kind() == io::ErrorKind::Interrupted => continue,
+            Err(err) => return Err(err),
+        };
+        if available.is_empty() {
+            return Ok(true);
+        }
+
+        let room = CHUNK_LIMIT.saturating_sub(bytes.len()).max(1);
+        let window = &available[..available.len().min(room)];
+        if let Some(newline) = window.iter().position(|&byte| byte == b'\n') {
+            bytes.extend_from_slice(&window[..=newline]);
+            reader.consume(newline + 1);
+            return Ok(false);
+        }
+
+        let taken = window.len();
+        bytes.extend_from_slice(window);
+        reader.consume(taken);
+        if bytes.len() >= CHUNK_LIMIT {
+            return Ok(false);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::allocations::peak_live_bytes;
+    use crate::highlight::formatters::{AnsiFormatter, HtmlFormatter};
+    use crate::highlight::lexers::{LexerState, PlainText};
+    use crate::highlight::token::push_token;
+    use crate::highlight::{InvalidUtf8, Token, TokenKind, highlight};
+    use crate::terminal::ColorProfile;
+
+    /// Minimal lexer whose `/* ... */` comments span lines, to exercise state carried between chunks.
+    struct BlockComments;
+
+    struct BlockCommentState {
+        in_comment: bool,
+    }
+
+    impl Lexer for BlockComments {
+        fn name(&self) -> &str {
+            "Block Comments"
+        }
+
+        fn start(&self) -> Box<dyn LexerState + '_> {
+            Box::new(BlockCommentState { in_comment: false })
+        }
+    }
+
+    impl LexerState for BlockCommentState {
+        fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+            let mut pos = 0;
+            while pos < line.len() {
+                let rest = &line[pos..];
+                let (kind, len) = if self.in_comment {
+                    match rest.find("*/") {
+                        Some(end) => {
+                            self.in_comment = false;
+                            (TokenKind::Comment, end + 2)
+                        }
+                        None => (TokenKind::Comment, rest.len()),
+                    }
+                } else {
+                    match rest.find("/*") {
+                        Some(0) => {
+                            self.in_comment = true;
+                            (TokenKind::Comment, 2)
+                        }
+                        Some(start) => (TokenKind::Text, start),
+                        None => (TokenKind::Text, rest.len()),
+                    }
+                };
+                push_token(tokens, kind, offset + pos, offset + pos + len);
+                pos += len;
+            }
+            Ok(())
+        }
+    }
+
+    /// Reader that yields `total` bytes of repeated source lines without materializing them.
+    struct Synthetic {
+        line: &'static [u8],
+        produced: usize,
+        total: usize,
+    }
+
+    impl Read for Synthetic {
+        fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+            let mut written = 0;
+            while written < buf.len() && self.produced < self.total {
+                let at = self.produced % self.line.len();
+                let n = (self.line.len() - at)
+                    .min(buf.len() - written)
+                    .min(self.total - self.produced);
+                buf[written..written + n].copy_from_slice(&self.line[at..at + n]);
+                written += n;
+                self.produced += n;
+            }
+            Ok(written)
+        }
+    }
+
+    /// Writer that discards output while recording its size and the largest single write.
+    #[derive(Default)]
+    struct Measure {
+        total: usize,
+        largest_write: usize,
+    }
+
+    impl Write for Measure {
+        fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+            self.total += buf.len();
+            self.largest_write = self.largest_write.max(buf.len());
+            Ok(buf.len())
+        }
+
+        fn flush(&mut self) -> io::Result<()> {
+            Ok(())
+        }
+    }
+
+    fn theme() -> Theme {
+        let mut theme = Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+        theme.set(TokenKind::Comment, Srgb8::new(100, 100, 100));
+        theme
+    }
+
+    #[test]
+    fn streaming_matches_whole_input_highlighting() {
+        let src = "a /* open\nstill comment\nclose */ b\n";
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::TrueColor);
+        let expected = highlight(src, &BlockComments, &theme(), &formatter).unwrap();
+
+        let mut out = Vec::new();
+        highlight_reader(src.as_bytes(), &mut out, &BlockComments, &theme(), &formatter).unwrap();
+        assert_eq!(String::from_utf8(out).unwrap(), expected);
+    }
+
+    #[test]
+    fn comment_state_carries_across_lines() {
+        let src = "a /* open\nstill comment\nclose */ b\n";
+        let mut out = Vec::new();
+        let formatter = HtmlFormatter::new().with_classes("c-");
+        highlight_reader(src.as_bytes(), &mut out, &BlockComments, &theme(), &formatter).unwrap();
+        assert!(
+            String::from_utf8(out)
+                .unwrap()
+                .contains("<span class=\"c-cm\">still comment\n</span>")
+        );
+    }
+
+    #[test]
+    fn line_numbers_continue_across_chunks() {
+        let src = format!("a\n{}\nb\n", "é".repeat(CHUNK_LIMIT));
+        let mut out = Vec::new();
+        let formatter = AnsiFormatter::new()
+            .with_profile(ColorProfile::NoColor)
+            .with_line_numbers(true);
+        highlight_reader(src.as_bytes(), &mut out, &PlainText, &theme(), &formatter).unwrap();
+
+        let out = String::from_utf8(out).unwrap();
+        let lines: Vec<&str> = out.lines().collect();
+        assert_eq!(lines.len(), 3);
+        assert_eq!(lines[0], "     1 a");
+        assert!(lines[1].starts_with("     2 éé"));
+        assert_eq!(lines[1].chars().filter(|&ch| ch == 'é').count(), CHUNK_LIMIT);
+        assert_eq!(lines[2], "     3 b");
+    }
+
+    #[test]
+    fn overlong_lines_split_on_char_boundaries() {
+        let src = "é".repeat(CHUNK_LIMIT);
+        let mut out = Vec::new();
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::NoColor);
+        highlight_reader(
+            io::BufReader::new(src.as_bytes()),
+            &mut out,
+            &PlainText,
+            &theme(),
+            &formatter,
+        )
+        .unwrap();
+        assert_eq!(String::from_utf8(out).unwrap(), src);
+    }
+
+    #[test]
+    fn invalid_utf8_follows_the_input_policy() {
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::NoColor);
+        let mut out = Vec::new();
+        highlight_reader(&b"ok\n\xff\n"[..], &mut out, &PlainText, &theme(), &formatter).unwrap();
+        assert_eq!(String::from_utf8(out).unwrap(), "ok\n\u{fffd}\n");
+
+        let strict = InputOptions::new().with_invalid_utf8(InvalidUtf8::Error);
+        let result = highlight_reader_with(
+            &b"ok\n\xff\n"[..],
+            io::sink(),
+            &PlainText,
+            &theme(),
+            &formatter,
+            &strict,
+        );
+        assert!(matches!(result, Err(HighlightError::InvalidUtf8 { offset: 3 })));
+    }
+
+    #[test]
+    fn binary_input_is_rejected_before_any_output() {
+        let formatter = HtmlFormatter::new().with_classes("");
+        let mut src = "text\n".repeat(1000).into_bytes();
+        src.push(0);
+        let mut out = Vec::new();
+        let result = highlight_reader(src.as_slice(), &mut out, &PlainText, &theme(), &formatter);
+        assert!(matches!(result, Err(HighlightError::BinaryInput)));
+        assert!(out.is_empty());
+
+        let allowed = InputOptions::new().with_allow_binary(true);
+        highlight_reader_with(src.as_slice(), &mut out, &PlainText, &theme(), &formatter, &allowed).unwrap();
+        assert!(String::from_utf8(out).unwrap().ends_with("text\n\0</code></pre>"));
+    }
+
+    /// Heap the streaming tests may hold at once, whatever the input size: the reader's buffer, a line's decoded text
+    /// and tokens, and the formatter's escapes, with their short lines well under [CHUNK_LIMIT].
+    const PEAK_LIMIT: usize = 64 * 1024;
+
+    /// Most heap held at once while streaming `total` bytes, checking on the way that the output was written in small
+    /// pieces as it was produced.
+    fn streaming_peak(total: usize) -> usize {
+        let reader = Synthetic { line: b"x = 1 /* note\n  more */ y\n", produced: 0, total };
+        let mut measure = Measure::default();
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::TrueColor);
+        let theme = theme();
+        let peak = peak_live_bytes(|| {
+            highlight_reader(
+                io::BufReader::new(reader),
+                &mut measure,
+                &BlockComments,
+                &theme,
+                &formatter,
+            )
+            .unwrap()
+        });
+
+        assert!(measure.total > total);
+        assert!(
+            measure.largest_write < 256,
+            "largest write was {} bytes",
+            measure.largest_write
+        );
+        peak
+    }
+
+    #[test]
+    fn large_inputs_stream_in_bounded_memory() {
+        for total in [1024 * 1024, 16 * 1024 * 1024] {
+            let peak = streaming_peak(total);
+            assert!(peak < PEAK_LIMIT, "streaming {total} bytes held {peak} bytes at once");
+        }
+    }
+
+    #[test]
+    #[ignore = "streams 100 MiB; run with `cargo test --release -- --ignored`"]
+    fn huge_inputs_stream_in_bounded_memory() {
+        let peak = streaming_peak(100 * 1024 * 1024);
+        assert!(peak < PEAK_LIMIT, "held {peak} bytes at once");
+    }
+}
@@ -0,0 +1,216 @@
+//! Highlighting helpers to register as template functions, which always produce output.
+
+use super::formatters::{AnsiFormatter, HtmlFormatter};
+use super::lexers::{self, PlainText};
+use super::{Formatter, HighlightError, Lexer, Theme, Token, TokenKind, detect_lexer, highlight};
+
+use std::sync::Arc;
+
+/// Highlighting for templates that render pages, or terminal output with [TextTemplateFuncs].
+///
+/// colorizer has no template engine of its own; register these methods as functions with whichever one renders the
+/// pages, so templates highlight code where it appears instead of in a separate pass. A function that fails inside a
+/// template usually aborts the whole render, so these never fail: a language without a lexer is highlighted as plain
+/// text, and when lexing fails the code is written escaped and unhighlighted, with the error passed to
+/// [TemplateFuncs::with_error_handler] if one is set.
+///
+/// HTML output is escaped by [HtmlFormatter] and safe to insert as it is, so mark it safe in the engine rather than
+/// escaping it again.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::highlight::{TemplateFuncs, Theme, TokenKind};
+///
+/// let mut theme = Theme::new("Page", Srgb8::new(0, 0, 0), Srgb8::new(0xee, 0xee, 0xee));
+/// theme.set(TokenKind::Keyword, Srgb8::new(0xff, 0, 0x80));
+/// let funcs = TemplateFuncs::new(theme);
+///
+/// let page = format!(
+///     "<style>{}</style>{}",
+///     funcs.theme_css(),
+///     funcs.highlight("go", "package main\n")
+/// );
+/// assert!(page.contains(r#"<span class="kw">package</span>"#));
+/// ```
+#[derive(Clone)]
+pub struct TemplateFuncs<F = HtmlFormatter> {
+    theme: Theme,
+    formatter: F,
+    on_error: Option<ErrorHandler>,
+}
+
+type ErrorHandler = Arc<dyn Fn(&HighlightError) + Send + Sync>;
+
+/// [TemplateFuncs] that render ANSI escapes, for templates printed to a terminal.
+pub type TextTemplateFuncs = TemplateFuncs<AnsiFormatter>;
+
+impl TemplateFuncs {
+    /// Renders HTML with unprefixed classes, styled by [TemplateFuncs::theme_css].
+    pub fn new(theme: Theme) -> Self {
+        Self { theme, formatter: HtmlFormatter::new().with_classes(""), on_error: None }
+    }
+
+    /// Returns the stylesheet for the formatter's classes, to inline in a `<style>` element; empty when the formatter
+    /// uses inline styles.
+    pub fn theme_css(&self) -> String {
+        self.formatter.css(&self.theme)
+    }
+}
+
+impl TemplateFuncs<AnsiFormatter> {
+    /// Renders ANSI escapes for the terminal's detected color profile.
+    pub fn text(theme: Theme) -> Self {
+        Self { theme, formatter: AnsiFormatter::new(), on_error: None }
+    }
+}
+
+impl<F: Formatter> TemplateFuncs<F> {
+    /// Renders with `formatter` instead, to set line numbers, a class prefix, or a color profile.
+    pub fn with_formatter(mut self, formatter: F) -> Self {
+        self.formatter = formatter;
+        self
+    }
+
+    /// Calls `handler` with the error whenever lexing fails and the code is written unhighlighted instead.
+    pub fn with_error_handler(mut self, handler: impl Fn(&HighlightError) + Send + Sync + 'static) -> Self {
+        self.on_error = Some(Arc::new(handler));
+        self
+    }
+
+    /// Highlights `code` in `language`, a name or extension as [lexers::find] takes, or as plain text when no lexer
+    /// has that name.
+    pub fn highlight(&self, language: &str, code: &str) -> String {
+        let lexer = lexers::find(language).unwrap_or(&PlainText);
+        self.render(code, lexer)
+    }
+
+    /// Highlights `code` in the language [detect_lexer] finds for `filename` and the code itself.
+    pub fn highlight_auto(&self, filename: &str, code: &str) -> String {
+        let (lexer, _) = detect_lexer(filename, code);
+        self.render(code, lexer.as_ref())
+    }
+
+    fn render(&self, code: &str, lexer: &dyn Lexer) -> String {
+        match highlight(code, lexer, &self.theme, &self.formatter) {
+            Ok(out) => out,
+            Err(err) => {
+                if let Some(handler) = &self.on_error {
+                    handler(&err);
+                }
+                let tokens: &[Token] =
+                    if code.is_empty() { &[] } else { &[Token::new(TokenKind::Text, 0, code.len())] };
+                let mut out = String::new();
+                self.formatter.format(code, tokens, &self.theme, &mut out);
+                out
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::LexerState;
+    use crate::terminal::ColorProfile;
+
+    use std::sync::Mutex;
+
+    fn theme() -> Theme {
+        let mut theme = Theme::new("Test", Srgb8::new(0, 0, 0), Srgb8::new(0xee, 0xee, 0xee));
+        theme.set(TokenKind::Keyword, Srgb8::new(0xff, 0, 0x80));
+        theme
+    }
+
+    /// Fills `{{ name "arg" "arg" }}` actions from `funcs`, standing in for a template engine.
+    fn execute<F: Formatter>(template: &str, funcs: &TemplateFuncs<F>, css: &str) -> String {
+        let mut out = String::new();
+        let mut rest = template;
+        while let Some(open) = rest.find("{{") {
+            out.push_str(&rest[..open]);
+            let close = rest[open..].find("}}").expect("unclosed action") + open;
+            let action = rest[open + 2..close].trim();
+            let (name, args) = action.split_once(' ').unwrap_or((action, ""));
+            let args: Vec<String> = args
+                .split('"')
+                .skip(1)
+                .step_by(2)
+                .map(|arg| arg.replace("\\n", "\n"))
+                .collect();
+            match name {
+                "highlight" => out.push_str(&funcs.highlight(&args[0], &args[1])),
+                "highlightAuto" => out.push_str(&funcs.highlight_auto(&args[0], &args[1])),
+                "themeCSS" => out.push_str(css),
+                _ => panic!("unknown function {name}"),
+            }
+            rest = &rest[close + 2..];
+        }
+        out.push_str(rest);
+        out
+    }
+
+    #[test]
+    fn page_templates_render_end_to_end() {
+        let funcs = TemplateFuncs::new(theme());
+        let template = concat!(
+            "<style>{{ themeCSS }}</style>\n",
+            "{{ highlight \"go\" \"if x < y {}\\n\" }}\n",
+            "{{ highlightAuto \"deploy.sh\" \"echo hi\\n\" }}\n",
+            "{{ highlight \"klingon\" \"<b>\\n\" }}",
+        );
+        let page = execute(template, &funcs, &funcs.theme_css());
+
+        assert!(page.starts_with("<style>.pre { background-color: #000000; color: #eeeeee; }\n"));
+        assert!(page.contains(".kw { color: #ff0080; }\n"));
+        assert!(
+            page.contains("<span class=\"kw\">if</span> <span class=\"nm\">x</span> <span class=\"op\">&lt;</span>")
+        );
+        assert!(page.contains("<span class=\"nm nb\">echo</span> hi"));
+        assert!(page.ends_with("<pre class=\"pre\"><code>&lt;b&gt;\n</code></pre>"));
+    }
+
+    #[test]
+    fn terminal_templates_render_escapes() {
+        let funcs =
+            TextTemplateFuncs::text(theme()).with_formatter(AnsiFormatter::new().with_profile(ColorProfile::TrueColor));
+        let out = execute("$ {{ highlight \"py\" \"if x:\\n\" }}", &funcs, "");
+        assert!(out.starts_with("$ \x1b[38;2;255;0;128mif\x1b[0m"), "{out:?}");
+    }
+
+    /// Fails on every input, like a lexer that hit a cancelled deadline.
+    struct Failing;
+
+    impl Lexer for Failing {
+        fn name(&self) -> &str {
+            "Failing"
+        }
+
+        fn start(&self) -> Box<dyn LexerState + '_> {
+            Box::new(FailingState)
+        }
+    }
+
+    struct FailingState;
+
+    impl LexerState for FailingState {
+        fn tokenize_line(&mut self, _: &str, offset: usize, _: &mut Vec<Token>) -> Result<(), HighlightError> {
+            Err(HighlightError::Unexpected { offset, found: '?' })
+        }
+    }
+
+    #[test]
+    fn lexer_errors_degrade_to_escaped_plain_text() {
+        let errors = Arc::new(Mutex::new(Vec::new()));
+        let logged = Arc::clone(&errors);
+        let funcs =
+            TemplateFuncs::new(theme()).with_error_handler(move |err| logged.lock().unwrap().push(err.to_string()));
+
+        assert_eq!(
+            funcs.render("a < b\n", &Failing),
+            "<pre class=\"pre\"><code>a &lt; b\n</code></pre>"
+        );
+        assert_eq!(*errors.lock().unwrap(), ["unexpected character '?' at byte 0"]);
+    }
+}
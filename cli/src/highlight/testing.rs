@@ -0,0 +1,406 @@
+//! Golden-file assertions for testing lexers, themes, and formatters, bundled or written elsewhere.
+//!
+//! [assert_golden_tokens] locks in the tokens a lexer produces for a fixture, [assert_golden_output] the rendered
+//! output of a lexer, theme, and formatter together, and [assert_golden_text] any other text, such as a stylesheet.
+//! Each compares against a file checked in next to the test and, when the `UPDATE_GOLDEN` environment variable is
+//! set, rewrites it instead, so accepting a change is `UPDATE_GOLDEN=1 cargo test` followed by reviewing the diff. [assert_round_trip] checks the invariant every lexer
+//! keeps: its tokens put back together are the input.
+//!
+//! Token goldens hold one token per line: its kind, byte range, and text, quoted with escapes for quotes,
+//! backslashes, and invisible characters. A two-line header names the format version and the lexer, so a golden
+//! written in an older format or for another lexer fails with a message saying so rather than as a wall of
+//! differing lines. The format only changes with [TOKENS_FORMAT].
+
+use super::{Formatter, HighlightError, Lexer, Theme};
+
+use std::fmt::Write;
+use std::fs;
+use std::path::Path;
+
+/// The environment variable that makes golden assertions write their goldens instead of comparing against them.
+pub const UPDATE_VAR: &str = "UPDATE_GOLDEN";
+
+/// Version of the format [golden_tokens] writes, named in each golden's header.
+pub const TOKENS_FORMAT: u32 = 1;
+
+/// Lines of header at the top of a token golden.
+const HEADER_LINES: usize = 2;
+
+/// Renders the tokens `lexer` produces for `src` in the token golden format.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::lexers::Go;
+/// use colorizer::highlight::testing::golden_tokens;
+///
+/// let golden = golden_tokens(&Go, "s := \"a\\tb\"\n").unwrap();
+/// assert_eq!(
+///     golden,
+///     concat!(
+///         "# colorizer tokens 1\n",
+///         "# lexer Go\n",
+///         "Name                  0..1          \"s\"\n",
+///         "Whitespace            1..2          \" \"\n",
+///         "Operator              2..4          \":=\"\n",
+///         "Whitespace            4..5          \" \"\n",
+///         "String                5..7          \"\\\"a\"\n",
+///         "StringEscape          7..9          \"\\\\t\"\n",
+///         "String                9..11         \"b\\\"\"\n",
+///         "Whitespace            11..12        \"\\n\"\n",
+///     )
+/// );
+/// ```
+pub fn golden_tokens(lexer: &dyn Lexer, src: &str) -> Result<String, HighlightError> {
+    let mut out = header(lexer);
+    for token in lexer.tokenize(src)? {
+        let range = format!("{}..{}", token.start, token.end);
+        let text = src.get(token.start..token.end).unwrap_or_default();
+        let _ = writeln!(out, "{:<21} {range:<13} {}", token.kind.name(), quote(text));
+    }
+    Ok(out)
+}
+
+/// Replaces the control characters in `text`, other than line breaks and tabs, with visible escapes such as `\x1b`,
+/// so terminal output can be read and diffed as text.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::testing::visible_escapes;
+///
+/// assert_eq!(visible_escapes("\x1b[1;31mfn\x1b[0m\tmain\n"), "\\x1b[1;31mfn\\x1b[0m\tmain\n");
+/// ```
+pub fn visible_escapes(text: &str) -> String {
+    let mut out = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '\n' | '\t' => out.push(c),
+            '\r' => out.push_str("\\r"),
+            c if (c as u32) < 0x80 && c.is_control() => {
+                let _ = write!(out, "\\x{:02x}", c as u32);
+            }
+            c if c.is_control() => {
+                let _ = write!(out, "\\u{{{:x}}}", c as u32);
+            }
+            c => out.push(c),
+        }
+    }
+    out
+}
+
+/// Panics unless `lexer`'s tokens for the fixture at `src_path` match the token golden at `golden_path`, or writes
+/// the golden when [UPDATE_VAR] is set.
+///
+/// The tokens must also [round-trip](assert_round_trip). Paths are relative to the directory the test runs in, the
+/// crate's root under `cargo test`.
+pub fn assert_golden_tokens(lexer: &dyn Lexer, src_path: impl AsRef<Path>, golden_path: impl AsRef<Path>) {
+    let src = read(src_path.as_ref());
+    assert_round_trip(lexer, &src);
+    let actual = match golden_tokens(lexer, &src) {
+        Ok(actual) => actual,
+        Err(err) => panic!(
+            "{} failed to tokenize {}: {err}",
+            lexer.name(),
+            src_path.as_ref().display()
+        ),
+    };
+    check(golden_path.as_ref(), &actual, HEADER_LINES, updating());
+}
+
+/// Panics unless highlighting the fixture at `src_path` with `lexer`, `theme`, and `formatter` gives the output in
+/// the golden at `golden_path`, or writes the golden when [UPDATE_VAR] is set.
+///
+/// The output is compared after [visible_escapes], so goldens for terminal formatters show their escapes. Pick the
+/// formatter's color profile explicitly, since the default depends on the environment the test runs in.
+///
+/// # Examples
+///
+/// ```no_run
+/// use colorizer::highlight::formatters::AnsiFormatter;
+/// use colorizer::highlight::lexers::Go;
+/// use colorizer::highlight::testing::assert_golden_output;
+/// use colorizer::highlight::themes::load_vscode_path;
+/// use colorizer::terminal::ColorProfile;
+///
+/// let theme = load_vscode_path("themes/my-theme.json").unwrap();
+/// let formatter = AnsiFormatter::new().with_profile(ColorProfile::TrueColor);
+/// assert_golden_output(&Go, &theme, &formatter, "fixtures/main.go", "golden/main.go.ansi");
+/// ```
+pub fn assert_golden_output(
+    lexer: &dyn Lexer, theme: &Theme, formatter: &dyn Formatter, src_path: impl AsRef<Path>,
+    golden_path: impl AsRef<Path>,
+) {
+    let src = read(src_path.as_ref());
+    let actual = match super::highlight(&src, lexer, theme, formatter) {
+        Ok(actual) => visible_escapes(&actual),
+        Err(err) => panic!(
+            "{} failed to highlight {}: {err}",
+            lexer.name(),
+            src_path.as_ref().display()
+        ),
+    };
+    check(golden_path.as_ref(), &actual, 0, updating());
+}
+
+/// Panics unless `actual` matches the golden at `golden_path`, or writes the golden when [UPDATE_VAR] is set.
+///
+/// For output the other assertions don't produce, such as a theme's stylesheet; the text is compared as it is.
+///
+/// # Examples
+///
+/// ```no_run
+/// use colorizer::highlight::CssOptions;
+/// use colorizer::highlight::testing::assert_golden_text;
+/// use colorizer::highlight::themes::load_vscode_path;
+///
+/// let theme = load_vscode_path("themes/my-theme.json").unwrap();
+/// assert_golden_text(&theme.stylesheet(&CssOptions::new()), "golden/my-theme.css");
+/// ```
+pub fn assert_golden_text(actual: &str, golden_path: impl AsRef<Path>) {
+    check(golden_path.as_ref(), actual, 0, updating());
+}
+
+/// Panics, naming the first token out of place, unless `lexer`'s tokens for `src` cover it end to end in order, so
+/// that putting their texts back together reproduces it.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::lexers::Python;
+/// use colorizer::highlight::testing::assert_round_trip;
+///
+/// assert_round_trip(&Python, "def f(x):\n    return f\"{x!r:>10}\"\n");
+/// ```
+pub fn assert_round_trip(lexer: &dyn Lexer, src: &str) {
+    let tokens = match lexer.tokenize(src) {
+        Ok(tokens) => tokens,
+        Err(err) => panic!("{} failed to tokenize the sample: {err}", lexer.name()),
+    };
+    let mut offset = 0;
+    for (index, token) in tokens.iter().enumerate() {
+        assert!(
+            token.start == offset && token.start <= token.end && src.get(token.start..token.end).is_some(),
+            "{}: token {index} is {} {}..{}, not a range of the {}-byte sample starting at {offset}",
+            lexer.name(),
+            token.kind.name(),
+            token.start,
+            token.end,
+            src.len()
+        );
+        offset = token.end;
+    }
+    assert!(
+        offset == src.len(),
+        "{}: tokens end at {offset}, before the end of the {}-byte sample",
+        lexer.name(),
+        src.len()
+    );
+}
+
+fn header(lexer: &dyn Lexer) -> String {
+    format!("# colorizer tokens {TOKENS_FORMAT}\n# lexer {}\n", lexer.name())
+}
+
+/// Quotes `text`, escaping quotes, backslashes, and characters that don't show up when printed.
+fn quote(text: &str) -> String {
+    let mut out = String::with_capacity(text.len() + 2);
+    out.push('"');
+    for c in text.chars() {
+        match c {
+            '"' => out.push_str("\\\""),
+            '\\' => out.push_str("\\\\"),
+            '\n' => out.push_str("\\n"),
+            '\r' => out.push_str("\\r"),
+            '\t' => out.push_str("\\t"),
+            // Controls, zero-width spaces and joiners, direction marks, line and paragraph separators, and the BOM.
+            c if c.is_control() || matches!(c, '\u{200b}'..='\u{200f}' | '\u{2028}'..='\u{202e}' | '\u{feff}') => {
+                let _ = write!(out, "\\u{{{:x}}}", c as u32);
+            }
+            c => out.push(c),
+        }
+    }
+    out.push('"');
+    out
+}
+
+fn read(path: &Path) -> String {
+    match fs::read_to_string(path) {
+        Ok(text) => text,
+        Err(err) => panic!("failed to read {}: {err}", path.display()),
+    }
+}
+
+fn updating() -> bool {
+    std::env::var_os(UPDATE_VAR).is_some()
+}
+
+/// Compares `actual` with the golden at `path`, whose first `header` lines must match before the rest is compared,
+/// or writes it when `update` is set.
+fn check(path: &Path, actual: &str, header: usize, update: bool) {
+    if update {
+        if let Some(dir) = path.parent().filter(|dir| !dir.as_os_str().is_empty()) {
+            let _ = fs::create_dir_all(dir);
+        }
+        if let Err(err) = fs::write(path, actual) {
+            panic!("failed to write {}: {err}", path.display());
+        }
+        return;
+    }
+    let expected = match fs::read_to_string(path) {
+        Ok(expected) => expected,
+        Err(err) => panic!(
+            "failed to read {}: {err}; rerun with {UPDATE_VAR}=1 to write it",
+            path.display()
+        ),
+    };
+    if expected == actual {
+        return;
+    }
+    let (old, new) = (head(&expected, header), head(actual, header));
+    if old != new {
+        panic!(
+            "{} has a different header: it was written for\n{old}\nbut the test now writes\n{new}\nrerun with \
+             {UPDATE_VAR}=1 to rewrite it",
+            path.display()
+        );
+    }
+    panic!(
+        "{} doesn't match: {}\nrerun with {UPDATE_VAR}=1 to accept the changes",
+        path.display(),
+        difference(&expected, actual)
+    );
+}
+
+fn head(text: &str, lines: usize) -> String {
+    text.lines().take(lines).collect::<Vec<_>>().join("\n")
+}
+
+/// Describes where `actual` first departs from `expected`, and how many lines differ.
+fn difference(expected: &str, actual: &str) -> String {
+    let (old, new): (Vec<&str>, Vec<&str>) = (expected.split('\n').collect(), actual.split('\n').collect());
+    let differing = (0..old.len().max(new.len()))
+        .filter(|&i| old.get(i) != new.get(i))
+        .count();
+    let first = (0..).find(|&i| old.get(i) != new.get(i)).unwrap_or_default();
+    let show = |line: Option<&&str>| line.map_or("the end of the file".to_string(), |line| format!("{line:?}"));
+    format!(
+        "line {} is {}, expected {} ({differing} lines differ)",
+        first + 1,
+        show(new.get(first)),
+        show(old.get(first))
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colors::Srgb8;
+    use crate::highlight::formatters::AnsiFormatter;
+    use crate::highlight::lexers::{Go, Rule, RuleLexer, RuleTable};
+    use crate::highlight::{Style, Token, TokenKind};
+    use crate::terminal::ColorProfile;
+
+    use std::panic::{AssertUnwindSafe, catch_unwind};
+    use std::path::PathBuf;
+
+    fn scratch(name: &str) -> PathBuf {
+        let dir = std::env::temp_dir().join(format!("colorizer-testing-{}", std::process::id()));
+        fs::create_dir_all(&dir).unwrap();
+        dir.join(name)
+    }
+
+    fn panic_message(run: impl FnOnce()) -> String {
+        let err = catch_unwind(AssertUnwindSafe(run)).expect_err("the assertion passed");
+        err.downcast_ref::<String>().cloned().unwrap_or_default()
+    }
+
+    #[test]
+    fn values_are_quoted_with_stable_escapes() {
+        assert_eq!(quote("a\"b\\c\r\n\t"), r#""a\"b\\c\r\n\t""#);
+        assert_eq!(quote("é\u{7}\u{200b}\u{feff}x"), r#""é\u{7}\u{200b}\u{feff}x""#);
+        assert_eq!(visible_escapes("\r\u{9b}é"), "\\r\\u{9b}é");
+    }
+
+    #[test]
+    fn goldens_are_written_then_compared() {
+        let src = scratch("program.go");
+        let golden = scratch("out/program.go.tokens");
+        fs::write(&src, "package main\n").unwrap();
+        let message = panic_message(|| assert_golden_tokens(&Go, &src, &golden));
+        assert!(message.contains("rerun with UPDATE_GOLDEN=1 to write it"), "{message}");
+
+        check(
+            &golden,
+            &golden_tokens(&Go, "package main\n").unwrap(),
+            HEADER_LINES,
+            true,
+        );
+        assert_golden_tokens(&Go, &src, &golden);
+
+        fs::write(&src, "package other\n").unwrap();
+        let message = panic_message(|| assert_golden_tokens(&Go, &src, &golden));
+        assert!(
+            message.contains(r#"line 5 is "Name                  8..13         \"other\"", expected"#),
+            "{message}"
+        );
+        assert!(message.contains("(2 lines differ)"), "{message}");
+
+        let table = RuleTable::new().with_state("root", vec![Rule::new(r"(?s).+", TokenKind::Text)]);
+        let message = panic_message(|| assert_golden_tokens(&RuleLexer::new("Other", &table).unwrap(), &src, &golden));
+        assert!(
+            message.contains("has a different header: it was written for\n# colorizer tokens 1\n# lexer Go\n"),
+            "{message}"
+        );
+    }
+
+    #[test]
+    fn output_goldens_show_terminal_escapes() {
+        let src = scratch("hello.go");
+        let golden = scratch("hello.go.ansi");
+        fs::write(&src, "package main\n").unwrap();
+        let theme = Theme::new("Plain", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255))
+            .with_override(TokenKind::Keyword, Style::new().with_bold(true));
+        let formatter = AnsiFormatter::new().with_profile(ColorProfile::TrueColor);
+        fs::write(&golden, "stale").unwrap();
+        let message = panic_message(|| assert_golden_output(&Go, &theme, &formatter, &src, &golden));
+        let rendered =
+            visible_escapes(&crate::highlight::highlight("package main\n", &Go, &theme, &formatter).unwrap());
+        assert!(rendered.starts_with("\\x1b[1;"), "{rendered}");
+        assert!(message.contains("doesn't match: line 1 is"), "{message}");
+        fs::write(&golden, &rendered).unwrap();
+        assert_golden_output(&Go, &theme, &formatter, &src, &golden);
+    }
+
+    /// Hands out one token, skipping the first byte of every line.
+    struct Gappy;
+
+    impl Lexer for Gappy {
+        fn name(&self) -> &str {
+            "Gappy"
+        }
+
+        fn start(&self) -> Box<dyn crate::highlight::LexerState + '_> {
+            Box::new(GappyState)
+        }
+    }
+
+    struct GappyState;
+
+    impl crate::highlight::LexerState for GappyState {
+        fn tokenize_line(&mut self, line: &str, offset: usize, tokens: &mut Vec<Token>) -> Result<(), HighlightError> {
+            tokens.push(Token::new(TokenKind::Text, offset + 1, offset + line.len()));
+            Ok(())
+        }
+    }
+
+    #[test]
+    fn round_trips_name_the_first_misplaced_token() {
+        assert_round_trip(&Go, "func main() {}\n");
+        let message = panic_message(|| assert_round_trip(&Gappy, "ab\ncd\n"));
+        assert_eq!(
+            message,
+            "Gappy: token 0 is Text 1..3, not a range of the 6-byte sample starting at 0"
+        );
+    }
+}
@@ -0,0 +1,1428 @@
+//! Highlighting themes mapping token kinds to colors.
+
+use super::TokenKind;
+use super::cache::fnv1a;
+use super::formatters::AnsiFormatter;
+use crate::colors::{Oklab, Oklch, Srgb8, Srgba8};
+use crate::compositing::{MixSpace, mix as blend_mix};
+use crate::conversions::{fit_chroma, in_srgb_gamut, oklab_toe, oklab_toe_inv};
+use crate::diffs::distance;
+use crate::terminal::ColorProfile;
+use crate::tinted_theming::{Base16Scheme, Base24Scheme, SchemeMetadata};
+use crate::wcag::{WCAG_AA_NORMAL, contrast_ratio, ensure_contrast, relative_luminance};
+
+use std::collections::HashMap;
+use std::fmt::Write;
+use std::sync::OnceLock;
+
+/// Colors and font attributes for a token kind.
+///
+/// Every attribute is optional: `None` inherits from the parent kind (see [TokenKind::parent]) and finally from the
+/// theme's base style. Flags are tri-state so a child can turn an attribute off (`Some(false)`) that its parent turns
+/// on.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Default)]
+pub struct Style {
+    pub foreground: Option<Srgb8>,
+    pub background: Option<Srgb8>,
+    pub bold: Option<bool>,
+    pub italic: Option<bool>,
+    pub underline: Option<bool>,
+    pub strikethrough: Option<bool>,
+}
+
+impl Style {
+    /// Creates a style that inherits every attribute.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn with_foreground(mut self, color: Srgb8) -> Self {
+        self.foreground = Some(color);
+        self
+    }
+
+    pub fn with_background(mut self, color: Srgb8) -> Self {
+        self.background = Some(color);
+        self
+    }
+
+    pub fn with_bold(mut self, on: bool) -> Self {
+        self.bold = Some(on);
+        self
+    }
+
+    pub fn with_italic(mut self, on: bool) -> Self {
+        self.italic = Some(on);
+        self
+    }
+
+    pub fn with_underline(mut self, on: bool) -> Self {
+        self.underline = Some(on);
+        self
+    }
+
+    pub fn with_strikethrough(mut self, on: bool) -> Self {
+        self.strikethrough = Some(on);
+        self
+    }
+
+    /// Parses a space-separated TextMate/VS Code `fontStyle` value (e.g., "bold italic").
+    ///
+    /// The value replaces the inherited attributes entirely, so every flag it doesn't name is explicitly off and an
+    /// empty string clears them all. Unknown words are ignored.
+    pub fn from_font_style(value: &str) -> Self {
+        let words: Vec<&str> = value.split_whitespace().collect();
+        let has = |word: &str| Some(words.contains(&word));
+        Self {
+            bold: has("bold"),
+            italic: has("italic"),
+            underline: has("underline"),
+            strikethrough: has("strikethrough"),
+            ..Self::default()
+        }
+    }
+
+    /// Fills every unset attribute from `parent`.
+    pub fn inherit(self, parent: Style) -> Self {
+        Self {
+            foreground: self.foreground.or(parent.foreground),
+            background: self.background.or(parent.background),
+            bold: self.bold.or(parent.bold),
+            italic: self.italic.or(parent.italic),
+            underline: self.underline.or(parent.underline),
+            strikethrough: self.strikethrough.or(parent.strikethrough),
+        }
+    }
+
+    /// Returns `true` when no attribute is set.
+    pub fn is_empty(&self) -> bool {
+        *self == Self::default()
+    }
+}
+
+/// A token kind whose foreground doesn't contrast enough with the background it's drawn on.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct ContrastIssue {
+    pub kind: TokenKind,
+    pub foreground: Srgb8,
+    /// The kind's own background, or the theme background when it has none.
+    pub background: Srgb8,
+    /// Measured WCAG contrast ratio.
+    pub ratio: f32,
+}
+
+/// How [Theme::stylesheet] names and wraps its rules.
+#[derive(Debug, Clone)]
+pub struct CssOptions {
+    prefix: String,
+    chroma: bool,
+    prefers_dark: bool,
+    short_hex: bool,
+}
+
+impl CssOptions {
+    /// Creates options for unprefixed classes as [super::formatters::HtmlFormatter::with_classes] writes them, with
+    /// short hex colors.
+    pub fn new() -> Self {
+        Self { prefix: String::new(), chroma: false, prefers_dark: false, short_hex: true }
+    }
+
+    /// Prepends `prefix` to every class name, matching the prefix given to the formatter.
+    pub fn with_prefix(mut self, prefix: impl Into<String>) -> Self {
+        self.prefix = prefix.into();
+        self
+    }
+
+    /// Targets markup from Chroma and Pygments instead: the wrapper is `.chroma` (and `.bg`), tokens are selected as
+    /// `.chroma .k`, and their class names are Chroma's, several of which can share one kind.
+    ///
+    /// Those spans carry a single class rather than their ancestors' too, so each rule spells out the kind's fully
+    /// inherited style.
+    pub fn with_chroma_classes(mut self, enabled: bool) -> Self {
+        self.chroma = enabled;
+        self
+    }
+
+    /// Wraps the rules in `@media (prefers-color-scheme: dark)`, so they only apply when the reader prefers a dark
+    /// color scheme.
+    pub fn with_prefers_dark(mut self, enabled: bool) -> Self {
+        self.prefers_dark = enabled;
+        self
+    }
+
+    /// Writes colors as `#rgb` when that loses nothing (the default), or always as `#rrggbb`.
+    pub fn with_short_hex(mut self, enabled: bool) -> Self {
+        self.short_hex = enabled;
+        self
+    }
+
+    fn hex(&self, color: Srgb8) -> String {
+        if self.short_hex { color.to_short_hex() } else { color.to_hex() }
+    }
+}
+
+impl Default for CssOptions {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+/// Styles for every token kind plus the base foreground/background pair.
+///
+/// Kinds without an explicit style inherit from their parent kind and then from the theme foreground.
+#[derive(Debug, Clone)]
+pub struct Theme {
+    pub name: String,
+    pub background: Srgb8,
+    pub foreground: Srgb8,
+    /// Cursor color, when the source theme defines one.
+    pub caret: Option<Srgb8>,
+    /// Style of line-number gutters; see [Theme::gutter_style].
+    pub gutter: Style,
+    /// Style of the markers drawn for visible whitespace; see [Theme::whitespace_style].
+    pub whitespace: Style,
+    /// Background of highlighted lines, which may be translucent to let the code background show through; see
+    /// [Theme::line_highlight_color].
+    pub line_highlight: Option<Srgba8>,
+    /// Backgrounds of added and removed lines, and of the changed words within them, in rich diffs; see
+    /// [Theme::diff_added_color] and its siblings.
+    pub diff_added: Option<Srgb8>,
+    pub diff_removed: Option<Srgb8>,
+    pub diff_changed: Option<Srgb8>,
+    /// Background of search matches in snippets; see [Theme::search_match_color].
+    pub search_match: Option<Srgb8>,
+    /// The 16 ANSI colors of the terminal palette the theme was made for, normal then bright, when the source
+    /// theme defines one; see [Theme::from_base16].
+    pub terminal_colors: Option<[Srgb8; 16]>,
+    styles: HashMap<TokenKind, Style>,
+    /// Styles of custom kinds, keyed by name so they can be set before the lexer registers the kind.
+    named: HashMap<String, Style>,
+    /// Fully inherited styles indexed by kind, filled on first use and cleared by every setter.
+    resolved: OnceLock<[Style; TokenKind::ALL.len()]>,
+}
+
+impl PartialEq for Theme {
+    fn eq(&self, other: &Self) -> bool {
+        self.name == other.name
+            && self.background == other.background
+            && self.foreground == other.foreground
+            && self.caret == other.caret
+            && self.gutter == other.gutter
+            && self.whitespace == other.whitespace
+            && self.line_highlight == other.line_highlight
+            && self.diff_added == other.diff_added
+            && self.diff_removed == other.diff_removed
+            && self.diff_changed == other.diff_changed
+            && self.search_match == other.search_match
+            && self.terminal_colors == other.terminal_colors
+            && self.styles == other.styles
+            && self.named == other.named
+    }
+}
+
+impl Theme {
+    /// Creates a theme where every token uses `foreground`, except that [TokenKind::Error] is red and underlined so
+    /// that input the lexer couldn't classify stands out.
+    pub fn new(name: impl Into<String>, background: Srgb8, foreground: Srgb8) -> Self {
+        Self {
+            name: name.into(),
+            background,
+            foreground,
+            caret: None,
+            gutter: Style::new(),
+            whitespace: Style::new(),
+            line_highlight: None,
+            diff_added: None,
+            diff_removed: None,
+            diff_changed: None,
+            search_match: None,
+            terminal_colors: None,
+            styles: HashMap::from([(TokenKind::Error, ERROR_STYLE)]),
+            named: HashMap::new(),
+            resolved: OnceLock::new(),
+        }
+    }
+
+    /// Assigns a foreground color to a token kind, keeping its other attributes.
+    pub fn set(&mut self, kind: TokenKind, color: Srgb8) {
+        let style = Style { foreground: Some(color), ..self.style(kind) };
+        self.set_style(kind, style);
+    }
+
+    /// Returns the theme with the attributes `style` sets replacing those of `kind`'s explicit style, so a loaded
+    /// theme can be tweaked without restating it; clone it first to keep the original.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use colorizer::colors::Srgb8;
+    /// use colorizer::highlight::{Style, Theme, TokenKind};
+    ///
+    /// let mut base = Theme::new("Base", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+    /// base.set(TokenKind::Comment, Srgb8::new(120, 120, 120));
+    /// let tweaked = base.clone().with_override(TokenKind::Comment, Style::new().with_italic(true));
+    /// assert_eq!(tweaked.style_for(TokenKind::Comment).italic, Some(true));
+    /// assert_eq!(tweaked.color_for(TokenKind::Comment), base.color_for(TokenKind::Comment));
+    /// ```
+    pub fn with_override(mut self, kind: TokenKind, style: Style) -> Self {
+        let merged = style.inherit(self.style(kind));
+        self.set_style(kind, merged);
+        self
+    }
+
+    /// Returns the explicit foreground for `kind`, if the theme defines one.
+    pub fn get(&self, kind: TokenKind) -> Option<Srgb8> {
+        self.style(kind).foreground
+    }
+
+    /// Resolves the foreground used to render `kind`.
+    pub fn color_for(&self, kind: TokenKind) -> Srgb8 {
+        self.style_for(kind).foreground.unwrap_or(self.foreground)
+    }
+
+    /// Replaces the explicit style of a token kind.
+    pub fn set_style(&mut self, kind: TokenKind, style: Style) {
+        if kind.is_custom() {
+            self.named.insert(kind.name().to_string(), style);
+        } else {
+            self.styles.insert(kind, style);
+            self.resolved.take();
+        }
+    }
+
+    /// Replaces the explicit style of the kind named `name` (see [TokenKind::name]), which may be a custom kind that
+    /// hasn't been registered yet, so theme files can style a lexer's kinds without depending on the lexer.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use colorizer::colors::Srgb8;
+    /// use colorizer::highlight::{Style, Theme, TokenKind};
+    ///
+    /// let mut theme = Theme::new("Sheet", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+    /// theme.set(TokenKind::NameVariable, Srgb8::new(120, 160, 220));
+    /// theme.set_style_by_name("UnitLiteral", Style::new().with_foreground(Srgb8::new(220, 180, 90)));
+    ///
+    /// let unit = TokenKind::register("UnitLiteral", TokenKind::Number).unwrap();
+    /// let range = TokenKind::register("RangeReference", TokenKind::NameVariable).unwrap();
+    /// assert_eq!(theme.color_for(unit), Srgb8::new(220, 180, 90));
+    /// assert_eq!(theme.color_for(range), Srgb8::new(120, 160, 220));
+    /// ```
+    pub fn set_style_by_name(&mut self, name: &str, style: Style) {
+        match TokenKind::ALL.into_iter().find(|kind| kind.name() == name) {
+            Some(kind) => self.set_style(kind, style),
+            None => {
+                self.named.insert(name.to_string(), style);
+            }
+        }
+    }
+
+    /// Returns the explicit style of `kind` (empty when unset), without inheritance.
+    pub fn style(&self, kind: TokenKind) -> Style {
+        let style = if kind.is_custom() { self.named.get(kind.name()) } else { self.styles.get(&kind) };
+        style.copied().unwrap_or_default()
+    }
+
+    /// Resolves the style used to render `kind` through its parent chain down to the base style.
+    ///
+    /// The result always has a foreground and every flag set; the background stays `None` unless some style in the
+    /// chain sets one, meaning the token sits on the theme background. Results are computed once for all kinds and
+    /// cached until the theme is modified; custom kinds are resolved on each call.
+    pub fn style_for(&self, kind: TokenKind) -> Style {
+        if let TokenKind::Custom(_) = kind {
+            let parent = kind
+                .parent()
+                .map_or_else(|| self.base_style(), |parent| self.style_for(parent));
+            return self.style(kind).inherit(parent);
+        }
+        let resolved = self.resolved.get_or_init(|| {
+            let base = self.base_style();
+            let mut resolved = [base; TokenKind::ALL.len()];
+            // Parents precede their children in declaration order, so one pass resolves every chain.
+            for kind in TokenKind::ALL {
+                let parent = kind.parent().map_or(base, |parent| resolved[parent.slot()]);
+                resolved[kind.slot()] = self.style(kind).inherit(parent);
+            }
+            resolved
+        });
+        resolved[kind.slot()]
+    }
+
+    /// Resolves the line-number gutter style; the foreground defaults to the theme foreground dimmed halfway
+    /// toward the background.
+    pub fn gutter_style(&self) -> Style {
+        let dimmed = mix(self.foreground, self.background, 0.5);
+        self.gutter.inherit(self.base_style().with_foreground(dimmed))
+    }
+
+    /// Resolves the style of visible-whitespace markers; the foreground defaults to the theme foreground dimmed
+    /// further than the gutter's, so markers stay out of the way of the code.
+    pub fn whitespace_style(&self) -> Style {
+        let dimmed = mix(self.foreground, self.background, 0.3);
+        self.whitespace.inherit(self.base_style().with_foreground(dimmed))
+    }
+
+    /// Resolves the background of highlighted lines as drawn over the theme background, defaulting to the background
+    /// lifted slightly toward the foreground.
+    pub fn line_highlight_color(&self) -> Srgb8 {
+        self.line_highlight_rgba().over(self.background)
+    }
+
+    /// Resolves the background of highlighted lines like [Theme::line_highlight_color], but keeps a translucent
+    /// [Theme::line_highlight] translucent, for outputs that composite it themselves.
+    pub fn line_highlight_rgba(&self) -> Srgba8 {
+        self.line_highlight
+            .unwrap_or_else(|| mix(self.foreground, self.background, 0.15).into())
+    }
+
+    /// Resolves the background of added lines in rich diffs, defaulting to a tint of the theme's
+    /// [TokenKind::GenericInserted] color (or a stock green) over the background.
+    pub fn diff_added_color(&self) -> Srgb8 {
+        let tint = self.get(TokenKind::GenericInserted).unwrap_or(DIFF_ADDED);
+        self.diff_added.unwrap_or_else(|| mix(tint, self.background, 0.2))
+    }
+
+    /// Resolves the background of removed lines in rich diffs, defaulting to a tint of the theme's
+    /// [TokenKind::GenericDeleted] color (or a stock red) over the background.
+    pub fn diff_removed_color(&self) -> Srgb8 {
+        let tint = self.get(TokenKind::GenericDeleted).unwrap_or(DIFF_REMOVED);
+        self.diff_removed.unwrap_or_else(|| mix(tint, self.background, 0.2))
+    }
+
+    /// Resolves the background of the changed words within paired added and removed lines, defaulting to a stronger
+    /// tint of the foreground than [Theme::line_highlight_color] uses.
+    pub fn diff_changed_color(&self) -> Srgb8 {
+        self.diff_changed
+            .unwrap_or_else(|| mix(self.foreground, self.background, 0.3))
+    }
+
+    /// Resolves the background of search matches in snippets (see [super::extract_snippets]), defaulting to a tint of
+    /// a stock amber over the background, the way editors mark find results.
+    pub fn search_match_color(&self) -> Srgb8 {
+        self.search_match
+            .unwrap_or_else(|| mix(SEARCH_MATCH, self.background, 0.35))
+    }
+
+    /// Returns a hash of everything that changes how the theme renders, its name and every color and style, for
+    /// keying cached output (see [super::cache_key]).
+    ///
+    /// Themes that compare equal have the same fingerprint; a theme edited after loading gets a new one.
+    pub fn fingerprint(&self) -> u64 {
+        let mut styles: Vec<String> = self
+            .styles
+            .iter()
+            .map(|(kind, style)| format!("{}={style:?}", kind.name()))
+            .chain(self.named.iter().map(|(name, style)| format!("{name}={style:?}")))
+            .collect();
+        styles.sort();
+        let colors = format!(
+            "{:?}",
+            (
+                self.background,
+                self.foreground,
+                self.caret,
+                self.gutter,
+                self.whitespace,
+                self.line_highlight,
+                (self.diff_added, self.diff_removed, self.diff_changed, self.search_match),
+                self.terminal_colors,
+            )
+        );
+        let parts = [self.name.as_bytes(), colors.as_bytes()];
+        fnv1a(parts.into_iter().chain(styles.iter().map(|style| style.as_bytes()))) as u64
+    }
+
+    /// Copies the theme with `background` behind every token kind that doesn't set a background of its own, so a
+    /// formatter paints whole lines with it.
+    pub(crate) fn over_background(&self, background: Srgb8) -> Theme {
+        let mut theme = self.clone();
+        for kind in TokenKind::ALL {
+            if self.style_for(kind).background.is_none() {
+                theme.set_style(kind, Style { background: Some(background), ..self.style(kind) });
+            }
+        }
+        theme
+    }
+
+    /// Reports every token kind whose resolved foreground has less than `min_ratio` WCAG contrast with its
+    /// background.
+    pub fn validate(&self, min_ratio: f32) -> Vec<ContrastIssue> {
+        self.kinds()
+            .filter_map(|kind| self.contrast_issue(kind, min_ratio))
+            .collect()
+    }
+
+    /// Adjusts the lightness of every failing foreground until it meets `min_ratio` (see [ensure_contrast]), and
+    /// returns the issues that could not be fixed.
+    ///
+    /// Kinds are visited parents first, so a child is only given its own color if it still fails after its parent
+    /// was repaired. Kinds that can't reach the ratio get the best achievable color, which the returned issues
+    /// describe.
+    pub fn repair_contrast(&mut self, min_ratio: f32) -> Vec<ContrastIssue> {
+        let mut unfixable = Vec::new();
+        for kind in self.kinds().collect::<Vec<_>>() {
+            let Some(issue) = self.contrast_issue(kind, min_ratio) else { continue };
+            match ensure_contrast(issue.foreground, issue.background, min_ratio) {
+                Ok(color) => self.set(kind, color),
+                Err(err) => {
+                    self.set(kind, err.best);
+                    unfixable.push(ContrastIssue { foreground: err.best, ratio: err.ratio, ..issue });
+                }
+            }
+        }
+        unfixable
+    }
+
+    /// Derives a light variant of a dark theme by mirroring the lightness of every color.
+    ///
+    /// Lightness is measured as toe-corrected OKLab `Lr`, which spaces dark shades the way the eye does, and mirrored
+    /// (`Lr` becomes `1 - Lr`), so a near-black background turns near-white rather than mid-gray. Hue and chroma are
+    /// kept: near-neutral grays map symmetrically around the middle gray and saturated accents stay saturated, with a
+    /// color losing only the chroma that doesn't fit in sRGB at its new lightness. Inverted accents often land too
+    /// close to the new background, so the result then goes through [Theme::repair_contrast] at [WCAG_AA_NORMAL].
+    /// The derivation is deterministic, and applied to a light theme it produces a dark one.
+    pub fn light_variant(&self) -> Theme {
+        let mut theme = Theme::new(
+            format!("{} Light", self.name),
+            invert_lightness(self.background),
+            invert_lightness(self.foreground),
+        );
+        theme.caret = self.caret.map(invert_lightness);
+        theme.gutter = invert_style(self.gutter);
+        theme.whitespace = invert_style(self.whitespace);
+        theme.line_highlight = self
+            .line_highlight
+            .map(|color| Srgba8 { a: color.a, ..invert_lightness(color.rgb()).into() });
+        theme.diff_added = self.diff_added.map(invert_lightness);
+        theme.diff_removed = self.diff_removed.map(invert_lightness);
+        theme.diff_changed = self.diff_changed.map(invert_lightness);
+        theme.search_match = self.search_match.map(invert_lightness);
+        theme.terminal_colors = self.terminal_colors.map(|colors| colors.map(invert_lightness));
+        for (&kind, &style) in &self.styles {
+            theme.set_style(kind, invert_style(style));
+        }
+        for (name, &style) in &self.named {
+            theme.set_style_by_name(name, invert_style(style));
+        }
+        theme.repair_contrast(WCAG_AA_NORMAL);
+        theme
+    }
+
+    /// Built-in kinds in declaration order, then the registered custom kinds the theme styles.
+    fn kinds(&self) -> impl Iterator<Item = TokenKind> + '_ {
+        let custom = TokenKind::registered()
+            .into_iter()
+            .filter(|kind| self.named.contains_key(kind.name()));
+        TokenKind::ALL.into_iter().chain(custom)
+    }
+
+    fn contrast_issue(&self, kind: TokenKind, min_ratio: f32) -> Option<ContrastIssue> {
+        if kind == TokenKind::Whitespace {
+            return None;
+        }
+        let style = self.style_for(kind);
+        let foreground = style.foreground.unwrap_or(self.foreground);
+        let background = style.background.unwrap_or(self.background);
+        let ratio = contrast_ratio(foreground, background);
+        (ratio < min_ratio).then_some(ContrastIssue { kind, foreground, background, ratio })
+    }
+
+    pub(crate) fn base_style(&self) -> Style {
+        Style::new()
+            .with_foreground(self.foreground)
+            .with_bold(false)
+            .with_italic(false)
+            .with_underline(false)
+            .with_strikethrough(false)
+    }
+
+    /// Builds a theme from a Base16 scheme following the tinted-theming styling guidelines.
+    ///
+    /// Uses the same slot assignments as [crate::syntax::base16_to_theme] so both highlighting paths agree. The
+    /// terminal colors follow base16-shell, which repeats the normal accents as the bright ones.
+    pub fn from_base16(scheme: &Base16Scheme) -> Self {
+        Self::from_slots(&scheme.metadata.name, scheme.colors())
+    }
+
+    /// Builds a theme from the Base16-compatible slots of a Base24 scheme, taking the bright terminal colors from
+    /// `base12` through `base17`.
+    pub fn from_base24(scheme: &Base24Scheme) -> Self {
+        Self::from_slots(&scheme.metadata.name, scheme.colors())
+    }
+
+    fn from_slots(name: &str, colors: &[Srgb8]) -> Self {
+        let mut theme = Self::new(name, colors[0], colors[5]);
+        for (kind, slot) in BASE16_SLOTS {
+            theme.set(kind, colors[slot]);
+        }
+        // Changed spans within a diff line keep the line's color over a tint of it.
+        for (kind, slot) in [
+            (TokenKind::GenericInsertedChange, 11),
+            (TokenKind::GenericDeletedChange, 8),
+        ] {
+            theme.set_style(kind, Style::new().with_background(mix(colors[slot], colors[0], 0.25)));
+        }
+        // Red, green, yellow, blue, magenta, and cyan, in ANSI order.
+        let normal = [8, 11, 10, 13, 14, 12];
+        let bright = if colors.len() >= 24 { [0x12, 0x14, 0x13, 0x16, 0x17, 0x15] } else { normal };
+        let mut terminal = [colors[0]; 16];
+        for i in 0..6 {
+            terminal[i + 1] = colors[normal[i]];
+            terminal[i + 9] = colors[bright[i]];
+        }
+        terminal[7] = colors[5];
+        terminal[8] = colors[3];
+        terminal[15] = colors[7];
+        theme.terminal_colors = Some(terminal);
+        theme
+    }
+
+    /// Maps the theme back onto the 16 Base16 slots, for tools that generate terminal or editor configs from one.
+    ///
+    /// The background and foreground become `base00` and `base05`, `base03` takes the comment color, and the rest
+    /// of the grayscale ramp is mixed between and beyond them in OKLab. Each accent slot takes the color shared by
+    /// the most kinds [Theme::from_base16] assigns to it; a slot whose kinds all use the plain foreground, like
+    /// `base0F`, which no kind uses, takes the theme color nearest to the classic Base16 default for that slot. A
+    /// theme built from a Base16 scheme gives back that scheme's accents.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use colorizer::colors::Srgb8;
+    /// use colorizer::highlight::{Theme, TokenKind};
+    ///
+    /// let mut theme = Theme::new("Night", Srgb8::new(0x1e, 0x1e, 0x1e), Srgb8::new(0xd4, 0xd4, 0xd4));
+    /// theme.set(TokenKind::String, Srgb8::new(0xce, 0x91, 0x78));
+    /// let scheme = theme.to_base16();
+    /// assert_eq!(scheme.colors()[0x0B], Srgb8::new(0xce, 0x91, 0x78));
+    /// assert_eq!(scheme.metadata.variant.as_deref(), Some("dark"));
+    /// ```
+    pub fn to_base16(&self) -> Base16Scheme {
+        let (background, foreground) = (self.background, self.foreground);
+        let light = relative_luminance(background) > relative_luminance(foreground);
+        let beyond = if light { Srgb8::new(0, 0, 0) } else { Srgb8::new(255, 255, 255) };
+        let ramp = |from: Srgb8, to: Srgb8, t: f32| blend_mix(from.into(), to.into(), t, MixSpace::Oklab).rgb();
+
+        let mut colors = [background; 16];
+        colors[1] = ramp(background, foreground, 0.1);
+        colors[2] = ramp(background, foreground, 0.2);
+        colors[3] = self
+            .get(TokenKind::Comment)
+            .unwrap_or_else(|| ramp(background, foreground, 0.45));
+        colors[4] = ramp(background, foreground, 0.75);
+        colors[5] = foreground;
+        colors[6] = ramp(foreground, beyond, 0.4);
+        colors[7] = ramp(foreground, beyond, 0.8);
+
+        let candidates: Vec<Srgb8> = self
+            .kinds()
+            .filter_map(|kind| self.style_for(kind).foreground)
+            .filter(|&color| color != foreground && color != background)
+            .collect();
+        for (slot, default) in (8..16).zip(BASE16_DEFAULT_ACCENTS) {
+            let mut votes: Vec<(Srgb8, usize)> = Vec::new();
+            for (kind, _) in BASE16_SLOTS.iter().filter(|&&(_, assigned)| assigned == slot) {
+                let color = self.color_for(*kind);
+                if color == foreground {
+                    continue;
+                }
+                match votes.iter_mut().find(|(voted, _)| *voted == color) {
+                    Some((_, count)) => *count += 1,
+                    None => votes.push((color, 1)),
+                }
+            }
+            // The first color to reach the highest count wins ties, so earlier, more representative kinds decide.
+            let winner = votes
+                .iter()
+                .fold(None, |best: Option<(Srgb8, usize)>, &(color, count)| match best {
+                    Some((_, most)) if most >= count => best,
+                    _ => Some((color, count)),
+                });
+            colors[slot] = winner.map(|(color, _)| color).unwrap_or_else(|| {
+                candidates
+                    .iter()
+                    .copied()
+                    .min_by(|&a, &b| distance(a, default).total_cmp(&distance(b, default)))
+                    .unwrap_or(default)
+            });
+        }
+
+        let metadata = SchemeMetadata {
+            system: "base16".to_string(),
+            name: self.name.clone(),
+            author: None,
+            variant: Some(if light { "light" } else { "dark" }.to_string()),
+        };
+        Base16Scheme::new(metadata, colors)
+    }
+
+    /// Renders a stylesheet for the classes emitted by [super::formatters::HtmlFormatter::with_classes].
+    ///
+    /// The output only depends on the theme and prefix, so callers can serve and cache it separately from the markup.
+    /// A shorthand for [Theme::stylesheet] with `#rrggbb` colors.
+    pub fn css(&self, prefix: &str) -> String {
+        self.stylesheet(&CssOptions::new().with_prefix(prefix).with_short_hex(false))
+    }
+
+    /// Renders a standalone stylesheet: the wrapper rule with the background and foreground, then a rule per styled
+    /// token class with its colors and font attributes.
+    ///
+    /// For this crate's markup, spans carry their ancestors' classes too, so only explicit attributes are emitted and
+    /// the cascade supplies the rest. [CssOptions::with_chroma_classes] writes a complete rule for every class
+    /// instead.
+    pub fn stylesheet(&self, options: &CssOptions) -> String {
+        self.write_stylesheet(options, None)
+    }
+
+    /// Renders one stylesheet for both themes: `light`'s rules, then `dark`'s inside
+    /// `@media (prefers-color-scheme: dark)`.
+    ///
+    /// The dark rules set every attribute either theme sets, so none of the light theme's leaks into dark mode.
+    pub fn stylesheet_pair(light: &Theme, dark: &Theme, options: &CssOptions) -> String {
+        let mut css = light.write_stylesheet(&options.clone().with_prefers_dark(false), None);
+        css.push_str(&dark.write_stylesheet(&options.clone().with_prefers_dark(true), Some(light)));
+        css
+    }
+
+    /// Renders the stylesheet, overriding every attribute `beneath` sets when it comes with one.
+    fn write_stylesheet(&self, options: &CssOptions, beneath: Option<&Theme>) -> String {
+        let (indent, prefix) = (if options.prefers_dark { "  " } else { "" }, &options.prefix);
+        let hex = |color| options.hex(color);
+        let mut css = String::new();
+        if options.prefers_dark {
+            css.push_str("@media (prefers-color-scheme: dark) {\n");
+        }
+        let wrapper = if options.chroma { format!(".{prefix}bg, .{prefix}chroma") } else { format!(".{prefix}pre") };
+        let _ = writeln!(
+            css,
+            "{indent}{wrapper} {{ background-color: {}; color: {}; }}",
+            hex(self.background),
+            hex(self.foreground)
+        );
+        for kind in self.kinds() {
+            let resolved = self.style_for(kind);
+            if options.chroma {
+                let classes = chroma_classes(kind);
+                if classes.is_empty() {
+                    continue;
+                }
+                // Attributes that are off are left out, unless the theme beneath turns them on.
+                let show = |flag: fn(&Style) -> Option<bool>| {
+                    let below = beneath.is_some_and(|theme| flag(&theme.style_for(kind)) == Some(true));
+                    (flag(&resolved) == Some(true) || below).then_some(true)
+                };
+                let visible = Style {
+                    bold: show(|style| style.bold),
+                    italic: show(|style| style.italic),
+                    underline: show(|style| style.underline),
+                    strikethrough: show(|style| style.strikethrough),
+                    background: resolved
+                        .background
+                        .or(beneath.and_then(|theme| theme.style_for(kind).background)),
+                    ..resolved
+                };
+                let selectors: Vec<_> = classes
+                    .iter()
+                    .map(|class| format!(".{prefix}chroma .{prefix}{class}"))
+                    .collect();
+                let declarations = write_declarations(visible, resolved, hex);
+                let _ = writeln!(css, "{indent}{} {{ {declarations} }}", selectors.join(", "));
+            } else {
+                // Children follow their parents in declaration order and win ties.
+                let style = self.style(kind);
+                let style = match beneath {
+                    Some(theme) => style.inherit(theme.style(kind)),
+                    None => style,
+                };
+                if !style.is_empty() {
+                    let declarations = write_declarations(style, resolved, hex);
+                    let _ = writeln!(css, "{indent}.{prefix}{} {{ {declarations} }}", kind.class());
+                }
+            }
+        }
+        if options.prefers_dark {
+            css.push_str("}\n");
+        }
+        css
+    }
+
+    /// Returns the SGR escape that [AnsiFormatter] writes before each kind's tokens under `profile`, for theming tools
+    /// outside this crate. Kinds come in declaration order; [ColorProfile::NoColor] has no escapes.
+    pub fn ansi_map(&self, profile: ColorProfile) -> Vec<(TokenKind, String)> {
+        let formatter = AnsiFormatter::new().with_profile(profile);
+        TokenKind::ALL
+            .into_iter()
+            .filter_map(|kind| Some((kind, formatter.escape(self.style_for(kind))?)))
+            .collect()
+    }
+
+    /// Renders [Theme::ansi_map] as shell assignments, one per line (e.g., `Keyword='\e[38;2;86;156;214m'`), ending
+    /// with `Reset='\e[0m'`.
+    ///
+    /// `\e` stands for the escape character, as `printf '%b'` and `echo -e` read it, so a script can source the file
+    /// and print `printf '%b%s%b' "$Keyword" fn "$Reset"`.
+    pub fn ansi_shell(&self, profile: ColorProfile) -> String {
+        let mut script = String::new();
+        for (kind, escape) in self.ansi_map(profile) {
+            let _ = writeln!(script, "{}='{}'", kind.name(), escape.replace('\x1b', "\\e"));
+        }
+        script.push_str("Reset='\\e[0m'\n");
+        script
+    }
+}
+
+/// Default style of [TokenKind::Error], in the red VS Code uses for invalid code; themes that color errors keep the
+/// underline unless they replace the whole style.
+/// The Base16 slot each kind takes its color from, in the tinted-theming styling guidelines.
+const BASE16_SLOTS: [(TokenKind, usize); 29] = [
+    (TokenKind::Error, 8),
+    (TokenKind::Keyword, 14),
+    (TokenKind::KeywordConstant, 9),
+    (TokenKind::KeywordDeclaration, 14),
+    (TokenKind::KeywordType, 14),
+    (TokenKind::NameBuiltin, 12),
+    (TokenKind::NameFunction, 13),
+    (TokenKind::NameClass, 10),
+    (TokenKind::NameTag, 8),
+    (TokenKind::NameAttribute, 9),
+    (TokenKind::NameVariable, 8),
+    (TokenKind::NameConstant, 9),
+    (TokenKind::NameLabel, 9),
+    (TokenKind::NameMacro, 12),
+    (TokenKind::String, 11),
+    (TokenKind::StringEscape, 12),
+    (TokenKind::StringRegex, 12),
+    (TokenKind::StringBacktick, 11),
+    (TokenKind::Number, 9),
+    (TokenKind::Operator, 5),
+    (TokenKind::Punctuation, 5),
+    (TokenKind::Comment, 3),
+    (TokenKind::CommentPreproc, 14),
+    (TokenKind::GenericHeading, 13),
+    (TokenKind::GenericSubheading, 12),
+    (TokenKind::GenericEmph, 14),
+    (TokenKind::GenericStrong, 10),
+    (TokenKind::GenericInserted, 11),
+    (TokenKind::GenericDeleted, 8),
+];
+
+/// The accents `base08` through `base0F` of the original Base16 Default Dark scheme.
+const BASE16_DEFAULT_ACCENTS: [Srgb8; 8] = [
+    Srgb8::new(0xab, 0x46, 0x42),
+    Srgb8::new(0xdc, 0x96, 0x56),
+    Srgb8::new(0xf7, 0xca, 0x88),
+    Srgb8::new(0xa1, 0xb5, 0x6c),
+    Srgb8::new(0x86, 0xc1, 0xb9),
+    Srgb8::new(0x7c, 0xaf, 0xc2),
+    Srgb8::new(0xba, 0x8b, 0xaf),
+    Srgb8::new(0xa1, 0x69, 0x46),
+];
+
+const ERROR_STYLE: Style = Style {
+    foreground: Some(Srgb8::new(0xf4, 0x47, 0x47)),
+    background: None,
+    bold: None,
+    italic: None,
+    underline: Some(true),
+    strikethrough: None,
+};
+
+/// Tints for diff lines in themes that don't color inserted and deleted text.
+const DIFF_ADDED: Srgb8 = Srgb8::new(0x3f, 0xb9, 0x50);
+const DIFF_REMOVED: Srgb8 = Srgb8::new(0xf8, 0x51, 0x49);
+
+/// Tint for search matches in themes that don't set [Theme::search_match].
+const SEARCH_MATCH: Srgb8 = Srgb8::new(0xe2, 0xa6, 0x1c);
+
+/// Blends `amount` of `color` over `backdrop`.
+fn mix(color: Srgb8, backdrop: Srgb8, amount: f32) -> Srgb8 {
+    let blend = |fg: u8, bg: u8| (fg as f32 * amount + bg as f32 * (1.0 - amount)).round() as u8;
+    Srgb8::new(
+        blend(color.r, backdrop.r),
+        blend(color.g, backdrop.g),
+        blend(color.b, backdrop.b),
+    )
+}
+
+/// Mirrors the toe-corrected OKLab lightness of `color`, keeping its hue and as much of its chroma as sRGB allows.
+fn invert_lightness(color: Srgb8) -> Srgb8 {
+    let Oklch { l, c, h } = Oklch::from(color);
+    let l = oklab_toe_inv(1.0 - oklab_toe(l));
+    let chroma = fit_chroma(c, |chroma| in_srgb_gamut(Oklab::from(Oklch::new(l, chroma, h))));
+    Srgb8::from(Oklch::new(l, chroma, h))
+}
+
+fn invert_style(style: Style) -> Style {
+    Style {
+        foreground: style.foreground.map(invert_lightness),
+        background: style.background.map(invert_lightness),
+        ..style
+    }
+}
+
+/// Renders CSS declarations (e.g., `color: #ff0080; font-weight: bold;`) for the attributes set in `style`, taking
+/// values from its fully `resolved` counterpart.
+///
+/// Underline and strikethrough share `text-decoration`, so setting either emits both resolved values.
+pub(crate) fn css_declarations(style: Style, resolved: Style) -> String {
+    write_declarations(style, resolved, |color| color.to_hex())
+}
+
+fn write_declarations(style: Style, resolved: Style, hex: impl Fn(Srgb8) -> String) -> String {
+    let mut declarations = Vec::new();
+    if let Some(color) = style.foreground.and(resolved.foreground) {
+        declarations.push(format!("color: {};", hex(color)));
+    }
+    if style.background.is_some() {
+        // Only a stylesheet overriding another theme's can ask for a background the style doesn't have.
+        match resolved.background {
+            Some(color) => declarations.push(format!("background-color: {};", hex(color))),
+            None => declarations.push("background-color: transparent;".to_string()),
+        }
+    }
+    let on = |flag: Option<bool>| flag == Some(true);
+    if style.bold.is_some() {
+        declarations.push(format!(
+            "font-weight: {};",
+            if on(resolved.bold) { "bold" } else { "normal" }
+        ));
+    }
+    if style.italic.is_some() {
+        declarations.push(format!(
+            "font-style: {};",
+            if on(resolved.italic) { "italic" } else { "normal" }
+        ));
+    }
+    if style.underline.is_some() || style.strikethrough.is_some() {
+        let decoration = match (on(resolved.underline), on(resolved.strikethrough)) {
+            (true, true) => "underline line-through",
+            (true, false) => "underline",
+            (false, true) => "line-through",
+            (false, false) => "none",
+        };
+        declarations.push(format!("text-decoration: {decoration};"));
+    }
+    declarations.join(" ")
+}
+
+/// Chroma's CSS classes for the token types that [super::lexers::load_chroma_xml] reads as `kind`.
+fn chroma_classes(kind: TokenKind) -> &'static [&'static str] {
+    match kind {
+        TokenKind::Text | TokenKind::PunctuationFence | TokenKind::CommentDoc | TokenKind::Custom(_) => &[],
+        TokenKind::Whitespace => &["w"],
+        TokenKind::Error => &["err", "gr", "gt"],
+        TokenKind::Keyword => &["k", "kn", "kp", "kr", "ow"],
+        TokenKind::KeywordConstant => &["kc"],
+        TokenKind::KeywordDeclaration => &["kd"],
+        TokenKind::KeywordType => &["kt"],
+        TokenKind::Name => &["n", "nx"],
+        TokenKind::NameBuiltin => &["nb", "bp"],
+        TokenKind::NameFunction => &["nf"],
+        TokenKind::NameClass => &["nc", "ne", "nn"],
+        TokenKind::NameTag => &["nt"],
+        TokenKind::NameAttribute => &["na", "nd", "py"],
+        TokenKind::NameVariable => &["nv", "vc", "vg", "vi", "vm"],
+        TokenKind::NameConstant => &["no", "ni"],
+        TokenKind::NameLabel => &["nl"],
+        TokenKind::NameMacro => &["fm"],
+        TokenKind::String => &["l", "s", "sa", "sc", "dl", "sd", "s2", "sh", "sx", "s1", "ss"],
+        TokenKind::StringEscape => &["se", "si"],
+        TokenKind::StringRegex => &["sr"],
+        TokenKind::StringBacktick => &["sb"],
+        TokenKind::Number => &["m", "mb", "mf", "mh", "mi", "il", "mo"],
+        TokenKind::NumberDate => &["ld"],
+        TokenKind::Operator => &["o"],
+        TokenKind::Punctuation => &["p"],
+        TokenKind::Comment => &["c", "c1", "cm", "cs"],
+        TokenKind::CommentPreproc => &["cp", "cpf", "ch"],
+        TokenKind::GenericHeading => &["gh"],
+        TokenKind::GenericSubheading => &["gu"],
+        TokenKind::GenericEmph => &["ge"],
+        TokenKind::GenericStrong => &["gs"],
+        TokenKind::GenericInserted => &["gi"],
+        TokenKind::GenericDeleted => &["gd"],
+        TokenKind::GenericInsertedChange | TokenKind::GenericDeletedChange => &[],
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::testing::assert_golden_text;
+    use crate::tinted_theming::SchemeMetadata;
+
+    fn scheme() -> Base16Scheme {
+        let mut colors = [Srgb8::new(0, 0, 0); 16];
+        for (i, color) in colors.iter_mut().enumerate() {
+            *color = Srgb8::new(i as u8 * 16, i as u8 * 16, i as u8 * 16);
+        }
+        let metadata =
+            SchemeMetadata { system: "base16".to_string(), name: "Ramp".to_string(), author: None, variant: None };
+        Base16Scheme::new(metadata, colors)
+    }
+
+    #[test]
+    fn fingerprints_follow_what_the_theme_renders() {
+        let theme = Theme::from_base16(&scheme());
+        assert_eq!(theme.fingerprint(), theme.clone().fingerprint());
+        assert_eq!(theme.fingerprint(), Theme::from_base16(&scheme()).fingerprint());
+
+        let recolored = theme
+            .clone()
+            .with_override(TokenKind::Keyword, Style::new().with_bold(true));
+        assert_ne!(recolored.fingerprint(), theme.fingerprint());
+        let mut renamed = theme.clone();
+        renamed.name.push('2');
+        assert_ne!(renamed.fingerprint(), theme.fingerprint());
+        let mut highlighted = theme.clone();
+        highlighted.search_match = Some(Srgb8::new(1, 2, 3));
+        assert_ne!(highlighted.fingerprint(), theme.fingerprint());
+    }
+
+    #[test]
+    fn base16_slots_follow_styling_guidelines() {
+        let theme = Theme::from_base16(&scheme());
+        assert_eq!(theme.name, "Ramp");
+        assert_eq!(theme.background, Srgb8::new(0, 0, 0));
+        assert_eq!(theme.foreground, Srgb8::new(80, 80, 80));
+        assert_eq!(theme.color_for(TokenKind::Comment), Srgb8::new(48, 48, 48));
+        assert_eq!(theme.color_for(TokenKind::String), Srgb8::new(176, 176, 176));
+        assert_eq!(theme.color_for(TokenKind::Keyword), Srgb8::new(224, 224, 224));
+    }
+
+    #[test]
+    fn base16_terminal_colors_repeat_the_accents_as_bright() {
+        let colors = scheme().colors().to_vec();
+        let terminal = Theme::from_base16(&scheme()).terminal_colors.unwrap();
+        assert_eq!(
+            (terminal[0], terminal[7], terminal[8], terminal[15]),
+            (colors[0], colors[5], colors[3], colors[7])
+        );
+        assert_eq!(
+            terminal[1..7],
+            [colors[8], colors[11], colors[10], colors[13], colors[14], colors[12]]
+        );
+        assert_eq!(terminal[9..15], terminal[1..7]);
+    }
+
+    #[test]
+    fn base24_terminal_colors_take_the_bright_slots() {
+        let colors: [Srgb8; 24] = std::array::from_fn(|i| Srgb8::new(i as u8 * 10, 0, 0));
+        let metadata =
+            SchemeMetadata { system: "base24".to_string(), name: "Reds".to_string(), author: None, variant: None };
+        let terminal = Theme::from_base24(&Base24Scheme::new(metadata, colors))
+            .terminal_colors
+            .unwrap();
+        assert_eq!(terminal[1], colors[0x08]);
+        assert_eq!(
+            terminal[9..15],
+            [
+                colors[0x12],
+                colors[0x14],
+                colors[0x13],
+                colors[0x16],
+                colors[0x17],
+                colors[0x15]
+            ]
+        );
+    }
+
+    #[test]
+    fn base16_export_gives_back_the_scheme_accents() {
+        let original = scheme();
+        let exported = Theme::from_base16(&original).to_base16();
+        assert_eq!(exported.metadata.name, "Ramp");
+        for slot in [0, 3, 5, 8, 9, 10, 11, 12, 13, 14] {
+            assert_eq!(exported.colors()[slot], original.colors()[slot], "base0{slot:X}");
+        }
+    }
+
+    #[test]
+    fn base16_export_fills_unused_slots_with_the_nearest_theme_color() {
+        let mut theme = Theme::new("Sparse", Srgb8::new(0xfa, 0xfa, 0xfa), Srgb8::new(0x38, 0x3a, 0x42));
+        theme.set(TokenKind::Keyword, Srgb8::new(0xa6, 0x26, 0xa4));
+        theme.set(TokenKind::Name, Srgb8::new(0x40, 0x78, 0xf2));
+        let exported = theme.to_base16();
+        assert_eq!(exported.metadata.variant.as_deref(), Some("light"));
+        assert_eq!(exported.colors()[0x0E], Srgb8::new(0xa6, 0x26, 0xa4));
+        // No kind assigned to base0D is styled, so the blue name color is the closest match to the default blue.
+        assert_eq!(exported.colors()[0x0D], Srgb8::new(0x40, 0x78, 0xf2));
+        assert!(
+            exported.colors()[7].r < exported.colors()[5].r,
+            "the ramp runs past the foreground to black"
+        );
+    }
+
+    #[test]
+    fn missing_kinds_fall_back_to_foreground() {
+        let theme = Theme::new("Empty", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        assert_eq!(theme.get(TokenKind::Keyword), None);
+        assert_eq!(theme.color_for(TokenKind::Keyword), Srgb8::new(200, 200, 200));
+    }
+
+    #[test]
+    fn font_style_values_replace_every_flag() {
+        let style = Style::from_font_style("bold  italic regular");
+        assert_eq!(
+            (style.bold, style.italic, style.underline, style.strikethrough),
+            (Some(true), Some(true), Some(false), Some(false))
+        );
+        assert_eq!(Style::from_font_style("").bold, Some(false));
+    }
+
+    #[test]
+    fn styles_inherit_through_parent_kinds() {
+        let mut theme = Theme::new("Chain", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        theme.set_style(
+            TokenKind::Keyword,
+            Style::new()
+                .with_foreground(Srgb8::new(255, 0, 0))
+                .with_bold(true)
+                .with_underline(true),
+        );
+        theme.set_style(
+            TokenKind::KeywordDeclaration,
+            Style::new().with_bold(false).with_italic(true),
+        );
+
+        let declaration = theme.style_for(TokenKind::KeywordDeclaration);
+        assert_eq!(declaration.foreground, Some(Srgb8::new(255, 0, 0)));
+        assert_eq!(declaration.bold, Some(false));
+        assert_eq!(declaration.italic, Some(true));
+        assert_eq!(declaration.underline, Some(true));
+        assert_eq!(declaration.strikethrough, Some(false));
+        assert_eq!(declaration.background, None);
+
+        let keyword_type = theme.style_for(TokenKind::KeywordType);
+        assert_eq!(keyword_type.bold, Some(true));
+        assert_eq!(
+            theme.style_for(TokenKind::Comment).foreground,
+            Some(Srgb8::new(200, 200, 200))
+        );
+    }
+
+    #[test]
+    fn gutter_and_line_highlight_default_to_theme_blends() {
+        let mut theme = Theme::new("Gutter", Srgb8::new(0, 0, 0), Srgb8::new(200, 100, 0));
+        assert_eq!(theme.gutter_style().foreground, Some(Srgb8::new(100, 50, 0)));
+        assert_eq!(theme.line_highlight_color(), Srgb8::new(30, 15, 0));
+
+        theme.gutter = Style::new().with_italic(true);
+        theme.line_highlight = Some(Srgb8::new(1, 2, 3).into());
+        assert_eq!(theme.gutter_style().italic, Some(true));
+        assert_eq!(theme.gutter_style().bold, Some(false));
+        assert_eq!(theme.line_highlight_color(), Srgb8::new(1, 2, 3));
+        assert_eq!(theme.whitespace_style().foreground, Some(Srgb8::new(60, 30, 0)));
+
+        theme.line_highlight = Some(Srgba8::new(255, 255, 255, 51));
+        assert_eq!(theme.line_highlight_color(), Srgb8::new(51, 51, 51));
+        assert_eq!(theme.line_highlight_rgba().to_css(), "rgba(255, 255, 255, 0.2)");
+        assert_eq!(theme.light_variant().line_highlight.map(|color| color.a), Some(51));
+    }
+
+    #[test]
+    fn diff_backgrounds_tint_inserted_and_deleted_colors() {
+        let mut theme = Theme::new("Diff", Srgb8::new(0, 0, 0), Srgb8::new(200, 100, 0));
+        assert_eq!(theme.diff_added_color(), Srgb8::new(13, 37, 16));
+        assert_eq!(theme.diff_removed_color(), Srgb8::new(50, 16, 15));
+        assert_eq!(theme.diff_changed_color(), Srgb8::new(60, 30, 0));
+
+        theme.set(TokenKind::GenericInserted, Srgb8::new(0, 0, 250));
+        theme.diff_removed = Some(Srgb8::new(1, 2, 3));
+        assert_eq!(theme.diff_added_color(), Srgb8::new(0, 0, 50));
+        assert_eq!(theme.diff_removed_color(), Srgb8::new(1, 2, 3));
+    }
+
+    #[test]
+    fn search_matches_default_to_an_amber_tint() {
+        let mut theme = Theme::new("Search", Srgb8::new(0, 0, 0), Srgb8::new(200, 100, 0));
+        assert_eq!(theme.search_match_color(), Srgb8::new(79, 58, 10));
+        theme.search_match = Some(Srgb8::new(1, 2, 3));
+        assert_eq!(theme.search_match_color(), Srgb8::new(1, 2, 3));
+    }
+
+    #[test]
+    fn over_background_keeps_explicit_backgrounds() {
+        let mut theme = Theme::new("Over", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        theme.set_style(TokenKind::Error, Style::new().with_background(Srgb8::new(255, 0, 0)));
+        let over = theme.over_background(Srgb8::new(0, 40, 0));
+        assert_eq!(
+            over.style_for(TokenKind::Keyword).background,
+            Some(Srgb8::new(0, 40, 0))
+        );
+        assert_eq!(over.style_for(TokenKind::Error).background, Some(Srgb8::new(255, 0, 0)));
+        assert_eq!(over.color_for(TokenKind::Keyword), theme.color_for(TokenKind::Keyword));
+    }
+
+    #[test]
+    fn validate_reports_low_contrast_kinds() {
+        let mut theme = Theme::new("Test", Srgb8::new(30, 30, 30), Srgb8::new(230, 230, 230));
+        theme.set(TokenKind::Comment, Srgb8::new(70, 70, 70));
+        theme.set_style(
+            TokenKind::Keyword,
+            Style::new().with_background(Srgb8::new(220, 220, 220)),
+        );
+
+        let issues = theme.validate(4.5);
+        let kinds: Vec<_> = issues.iter().map(|issue| issue.kind).collect();
+        assert!(kinds.contains(&TokenKind::Comment));
+        assert!(
+            kinds.contains(&TokenKind::CommentPreproc),
+            "children inherit the failing color"
+        );
+        assert!(
+            kinds.contains(&TokenKind::Keyword),
+            "measured against the kind's own background"
+        );
+        assert!(!kinds.contains(&TokenKind::Text));
+        assert!(!kinds.contains(&TokenKind::Whitespace));
+        let comment = issues.iter().find(|issue| issue.kind == TokenKind::Comment).unwrap();
+        assert_eq!(comment.background, theme.background);
+        assert!(comment.ratio < 2.0);
+    }
+
+    #[test]
+    fn repair_contrast_fixes_parents_before_children() {
+        let mut theme = Theme::new("Test", Srgb8::new(30, 30, 30), Srgb8::new(230, 230, 230));
+        theme.set(TokenKind::Comment, Srgb8::new(70, 70, 90));
+
+        assert!(theme.repair_contrast(4.5).is_empty());
+        assert!(theme.validate(4.5).is_empty());
+        assert!(theme.get(TokenKind::Comment).is_some());
+        assert_eq!(
+            theme.get(TokenKind::CommentPreproc),
+            None,
+            "inherits the repaired parent"
+        );
+    }
+
+    #[test]
+    fn repair_contrast_returns_unreachable_kinds() {
+        let mut theme = Theme::new("Test", Srgb8::new(119, 119, 119), Srgb8::new(0, 0, 0));
+        let issues = theme.repair_contrast(7.0);
+        assert!(!issues.is_empty());
+        assert!(
+            issues
+                .iter()
+                .all(|issue| issue.foreground == Srgb8::new(0, 0, 0) && issue.ratio < 7.0)
+        );
+    }
+
+    #[test]
+    fn setters_invalidate_cached_styles() {
+        let mut theme = Theme::new("Cache", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        assert_eq!(theme.color_for(TokenKind::KeywordType), Srgb8::new(200, 200, 200));
+        theme.set(TokenKind::Keyword, Srgb8::new(1, 2, 3));
+        assert_eq!(theme.color_for(TokenKind::KeywordType), Srgb8::new(1, 2, 3));
+        assert_eq!(theme.get(TokenKind::KeywordType), None);
+        assert_eq!(theme, theme.clone());
+    }
+
+    #[test]
+    fn custom_kinds_inherit_until_styled_by_name() {
+        let mut theme = Theme::new("Custom", Srgb8::new(0, 0, 0), Srgb8::new(200, 200, 200));
+        theme.set(TokenKind::String, Srgb8::new(1, 2, 3));
+        theme.set_style_by_name("ThemeHeredocTag", Style::new().with_bold(true));
+        let tag = TokenKind::register("ThemeHeredocTag", TokenKind::StringEscape).unwrap();
+        let inner = TokenKind::register("ThemeHeredocName", tag).unwrap();
+
+        assert_eq!(theme.style_for(inner).foreground, Some(Srgb8::new(1, 2, 3)));
+        assert_eq!(theme.style_for(inner).bold, Some(true));
+        theme.set(inner, Srgb8::new(4, 5, 6));
+        assert_eq!(theme.color_for(inner), Srgb8::new(4, 5, 6));
+        assert_eq!(theme.color_for(tag), Srgb8::new(1, 2, 3));
+
+        let css = theme.css("");
+        assert!(
+            css.ends_with(".xthemeheredoctag { font-weight: bold; }\n.xthemeheredocname { color: #040506; }\n"),
+            "{css}"
+        );
+    }
+
+    #[test]
+    fn css_uses_prefix_and_skips_unset_kinds() {
+        let mut theme = Theme::new("Tiny", Srgb8::new(0x10, 0x10, 0x10), Srgb8::new(0xee, 0xee, 0xee));
+        theme.set(TokenKind::Keyword, Srgb8::new(0xff, 0x00, 0x80));
+
+        let css = theme.css("clz-");
+        assert_eq!(
+            css,
+            ".clz-pre { background-color: #101010; color: #eeeeee; }\n\
+             .clz-er { color: #f44747; text-decoration: underline; }\n\
+             .clz-kw { color: #ff0080; }\n"
+        );
+    }
+
+    #[test]
+    fn css_emits_font_attributes_for_explicit_styles() {
+        let mut theme = Theme::new("Styled", Srgb8::new(0, 0, 0), Srgb8::new(0xee, 0xee, 0xee));
+        theme.set_style(TokenKind::Keyword, Style::new().with_bold(true).with_underline(true));
+        theme.set_style(
+            TokenKind::KeywordType,
+            Style::new().with_bold(false).with_strikethrough(true),
+        );
+
+        let css = theme.css("");
+        assert!(css.contains(".kw { font-weight: bold; text-decoration: underline; }\n"));
+        assert!(css.contains(".kt { font-weight: normal; text-decoration: underline line-through; }\n"));
+        assert!(!css.contains(".kd "));
+    }
+
+    #[test]
+    fn stylesheet_shortens_lossless_colors() {
+        let mut theme = Theme::new("Tiny", Srgb8::new(0x11, 0x22, 0x33), Srgb8::new(0xee, 0xee, 0xee));
+        theme.set(TokenKind::Keyword, Srgb8::new(0xff, 0x00, 0x80));
+        theme.set_style(TokenKind::Comment, Style::new().with_italic(true));
+
+        let css = theme.stylesheet(&CssOptions::new().with_prefix("x-"));
+        assert_eq!(
+            css,
+            ".x-pre { background-color: #123; color: #eee; }\n\
+             .x-er { color: #f44747; text-decoration: underline; }\n\
+             .x-kw { color: #ff0080; }\n\
+             .x-cm { font-style: italic; }\n"
+        );
+        assert_eq!(
+            theme.css("x-"),
+            css.replace("#123", "#112233").replace("#eee", "#eeeeee")
+        );
+    }
+
+    #[test]
+    fn chroma_stylesheet_spells_out_inherited_styles() {
+        let mut theme = Theme::new("Chroma", Srgb8::new(0, 0, 0), Srgb8::new(0xcc, 0xcc, 0xcc));
+        theme.set_style(
+            TokenKind::Keyword,
+            Style::new().with_foreground(Srgb8::new(0xff, 0, 0)).with_bold(true),
+        );
+
+        let css = theme.stylesheet(&CssOptions::new().with_chroma_classes(true));
+        assert!(
+            css.starts_with(".bg, .chroma { background-color: #000; color: #ccc; }\n"),
+            "{css}"
+        );
+        assert!(css.contains(
+            ".chroma .k, .chroma .kn, .chroma .kp, .chroma .kr, .chroma .ow { color: #f00; font-weight: bold; }\n"
+        ));
+        // `kt` has no rule of its own in the theme, but Chroma's spans don't carry `k` beside it.
+        assert!(css.contains(".chroma .kt { color: #f00; font-weight: bold; }\n"));
+        assert!(css.contains(".chroma .c, .chroma .c1, .chroma .cm, .chroma .cs { color: #ccc; }\n"));
+        assert!(!css.contains(".chroma . "), "kinds without Chroma classes are skipped");
+    }
+
+    #[test]
+    fn stylesheet_pair_overrides_every_light_attribute_in_dark_mode() {
+        let mut light = Theme::new("Light", Srgb8::new(0xff, 0xff, 0xff), Srgb8::new(0, 0, 0));
+        light.set_style(TokenKind::Keyword, Style::new().with_bold(true));
+        light.set_style(
+            TokenKind::String,
+            Style::new().with_background(Srgb8::new(0xee, 0xff, 0xee)),
+        );
+        let mut dark = Theme::new("Dark", Srgb8::new(0, 0, 0), Srgb8::new(0xff, 0xff, 0xff));
+        dark.set(TokenKind::Number, Srgb8::new(0x88, 0x88, 0xff));
+
+        let css = Theme::stylesheet_pair(&light, &dark, &CssOptions::new());
+        assert_eq!(
+            css,
+            ".pre { background-color: #fff; color: #000; }\n\
+             .er { color: #f44747; text-decoration: underline; }\n\
+             .kw { font-weight: bold; }\n\
+             .st { background-color: #efe; }\n\
+             @media (prefers-color-scheme: dark) {\n  \
+             .pre { background-color: #000; color: #fff; }\n  \
+             .er { color: #f44747; text-decoration: underline; }\n  \
+             .kw { font-weight: normal; }\n  \
+             .st { background-color: transparent; }\n  \
+             .nu { color: #88f; }\n\
+             }\n"
+        );
+
+        let chroma = Theme::stylesheet_pair(&light, &dark, &CssOptions::new().with_chroma_classes(true));
+        let dark_rules = &chroma[chroma.find("@media").unwrap()..];
+        assert!(
+            dark_rules.contains("  .chroma .kd { color: #fff; font-weight: normal; }\n"),
+            "{dark_rules}"
+        );
+        assert!(dark_rules.contains(".chroma .sr { color: #fff; background-color: transparent; }\n"));
+    }
+
+    #[test]
+    fn ansi_map_matches_the_formatter_escapes() {
+        let mut theme = Theme::new("Term", Srgb8::new(0, 0, 0), Srgb8::new(0xc0, 0xc0, 0xc0));
+        theme.set_style(
+            TokenKind::Keyword,
+            Style::new().with_foreground(Srgb8::new(86, 156, 214)).with_bold(true),
+        );
+
+        let map = theme.ansi_map(ColorProfile::TrueColor);
+        assert_eq!(map.len(), TokenKind::ALL.len());
+        assert!(map.contains(&(TokenKind::KeywordType, "\x1b[1;38;2;86;156;214m".to_string())));
+        assert!(map.contains(&(TokenKind::Text, "\x1b[38;2;192;192;192m".to_string())));
+        assert!(theme.ansi_map(ColorProfile::NoColor).is_empty());
+
+        let script = theme.ansi_shell(ColorProfile::Ansi256);
+        assert!(script.starts_with("Text='\\e[38;5;"), "{script}");
+        assert!(script.contains("\nKeyword='\\e[1;38;5;"));
+        assert!(script.ends_with("\nReset='\\e[0m'\n"));
+        assert!(!script.contains('\x1b'));
+    }
+
+    #[test]
+    fn dark_plus_matches_golden_stylesheet() {
+        const GOLDEN: &str = "../examples/golden/dark-plus.css";
+        let dark = crate::highlight::themes::load_vscode_path("../examples/themes/dark_plus.json").unwrap();
+
+        // Changes to the defaults or to the VS Code importer show up here as a diff of the stylesheet.
+        let actual = Theme::stylesheet_pair(&dark.light_variant(), &dark, &CssOptions::new());
+        assert_golden_text(&actual, GOLDEN);
+    }
+
+    #[test]
+    fn light_variant_mirrors_lightness_and_keeps_chroma() {
+        let mut theme = Theme::new("Dark", Srgb8::new(0x1e, 0x1e, 0x1e), Srgb8::new(0xd4, 0xd4, 0xd4));
+        let blue = Srgb8::new(0x5e, 0x81, 0xac);
+        theme.set(TokenKind::Keyword, blue);
+
+        let light = theme.light_variant();
+        assert_eq!(light.name, "Dark Light");
+        let gray = |color: Srgb8| oklab_toe(Oklab::from(color).l);
+        assert!((gray(light.background) - (1.0 - gray(theme.background))).abs() < 0.01);
+        assert!((gray(light.foreground) - (1.0 - gray(theme.foreground))).abs() < 0.01);
+        assert!(light.background.r == light.background.g && light.background.g == light.background.b);
+
+        let (before, after) = (Oklch::from(blue), Oklch::from(light.color_for(TokenKind::Keyword)));
+        assert!((before.h - after.h).abs() < 3.0, "{before:?} {after:?}");
+        assert!((before.c - after.c).abs() < 0.01, "{before:?} {after:?}");
+    }
+
+    #[test]
+    fn light_variant_passes_contrast_validation() {
+        let mut theme = Theme::new("Dark", Srgb8::new(0x28, 0x2a, 0x36), Srgb8::new(0xf8, 0xf8, 0xf2));
+        // Mid-lightness colors land near the inverted background and need repair.
+        theme.set(TokenKind::Comment, Srgb8::new(0x62, 0x72, 0xa4));
+        theme.set(TokenKind::String, Srgb8::new(0xf1, 0xfa, 0x8c));
+        theme.set_style(
+            TokenKind::GenericInsertedChange,
+            Style::new().with_background(Srgb8::new(0x20, 0x40, 0x20)),
+        );
+
+        let light = theme.light_variant();
+        assert!(light.background.to_hsl().l > 0.8);
+        assert!(light.validate(WCAG_AA_NORMAL).is_empty());
+        assert_eq!(light, theme.light_variant(), "derivation is deterministic");
+    }
+
+    #[test]
+    fn light_mocha_matches_golden_stylesheet() {
+        const GOLDEN: &str = "../examples/golden/catppuccin-mocha-light.css";
+        let schemes = crate::tinted_theming::load_base24_schemes("../examples/base24/catppuccin-mocha.yml").unwrap();
+        let light = Theme::from_base24(&schemes[0]).light_variant();
+
+        // Class-mode markup doesn't depend on the theme, so the stylesheet captures everything the derivation changes
+        // in highlighted HTML.
+        let actual = super::super::formatters::HtmlFormatter::new()
+            .with_classes("clz-")
+            .css(&light);
+        assert_golden_text(&actual, GOLDEN);
+    }
+}
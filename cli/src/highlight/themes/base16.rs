@@ -0,0 +1,32 @@
+//! Base16 and Base24 scheme loader.
+
+use super::ThemeError;
+use crate::highlight::Theme;
+use crate::tinted_theming::{Scheme, parse_scheme};
+
+use std::io::Read;
+
+/// Loads a Base16 or Base24 scheme and styles it with [Theme::from_base16] or [Theme::from_base24].
+///
+/// Schemes may use the tinted-theming layout or the original Base16 one; see
+/// [crate::tinted_theming::parse_scheme]. A missing slot or a malformed color fails with a message naming the key.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::TokenKind;
+/// use colorizer::highlight::themes::load_base16;
+///
+/// let file = std::fs::File::open("../examples/base16/oxocarbon-dark.yml").unwrap();
+/// let theme = load_base16(file).unwrap();
+/// assert_eq!(theme.name, "Oxocarbon Dark");
+/// assert_eq!(theme.color_for(TokenKind::String).to_hex(), "#33b1ff");
+/// ```
+pub fn load_base16(mut reader: impl Read) -> Result<Theme, ThemeError> {
+    let mut text = String::new();
+    reader.read_to_string(&mut text)?;
+    match parse_scheme(&text).map_err(|err| ThemeError::Parse(err.to_string()))? {
+        Scheme::Base16(scheme) => Ok(Theme::from_base16(&scheme)),
+        Scheme::Base24(scheme) => Ok(Theme::from_base24(&scheme)),
+    }
+}
@@ -0,0 +1,347 @@
+//! Defining a theme in code, with the checks a hand-filled [Theme] would skip.
+
+use crate::colors::Srgb8;
+use crate::highlight::{ContrastIssue, Style, Theme, TokenKind};
+use crate::parse::{ColorParseError, parse_color};
+
+use std::collections::HashMap;
+use std::fmt;
+
+/// Builds a [Theme] step by step, checking it as a whole in [ThemeBuilder::build].
+///
+/// Colors are given as strings in any form [parse_color] accepts, and a color that doesn't parse is reported by
+/// `build` along with what it was for. Besides the fixed hierarchy of [TokenKind::parent],
+/// [ThemeBuilder::inherit] lets a kind take its unset attributes from any other kind.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::themes::ThemeBuilder;
+/// use colorizer::highlight::{Style, TokenKind};
+///
+/// let theme = ThemeBuilder::new("Ember")
+///     .background("#1c1b19")
+///     .foreground("#fce8c3")
+///     .color(TokenKind::Keyword, "#ff6b5a")
+///     .style(TokenKind::Comment, Style::new().with_italic(true))
+///     .inherit(TokenKind::NameFunction, TokenKind::Keyword)
+///     .min_contrast(4.5)
+///     .build()
+///     .unwrap();
+/// assert_eq!(theme.color_for(TokenKind::NameFunction).to_hex(), "#ff6b5a");
+///
+/// let broken = ThemeBuilder::new("Broken").background("#1c1b19").foreground("#fce8c3");
+/// let error = broken.color(TokenKind::String, "#ggg").build().unwrap_err();
+/// assert_eq!(error.to_string(), "String foreground: '#ggg' is not a valid hex color");
+/// ```
+#[derive(Debug, Clone, Default)]
+pub struct ThemeBuilder {
+    name: String,
+    background: Option<String>,
+    foreground: Option<String>,
+    /// Styles in the order they were given, with the foregrounds and backgrounds still to parse.
+    styles: Vec<(TokenKind, Style, Option<String>, Option<String>)>,
+    parents: HashMap<TokenKind, TokenKind>,
+    min_contrast: Option<f32>,
+}
+
+impl ThemeBuilder {
+    /// Starts a theme called `name`; [ThemeBuilder::background] and [ThemeBuilder::foreground] are required.
+    pub fn new(name: impl Into<String>) -> Self {
+        Self { name: name.into(), ..Self::default() }
+    }
+
+    /// Sets the background that tokens without one of their own sit on.
+    pub fn background(mut self, color: &str) -> Self {
+        self.background = Some(color.to_string());
+        self
+    }
+
+    /// Sets the foreground of tokens whose kinds set none.
+    pub fn foreground(mut self, color: &str) -> Self {
+        self.foreground = Some(color.to_string());
+        self
+    }
+
+    /// Sets the foreground of `kind`, on top of what earlier calls set for it.
+    pub fn color(mut self, kind: TokenKind, color: &str) -> Self {
+        self.styles.push((kind, Style::new(), Some(color.to_string()), None));
+        self
+    }
+
+    /// Sets the background of `kind`, on top of what earlier calls set for it.
+    pub fn background_of(mut self, kind: TokenKind, color: &str) -> Self {
+        self.styles.push((kind, Style::new(), None, Some(color.to_string())));
+        self
+    }
+
+    /// Sets the attributes `style` sets for `kind`, on top of what earlier calls set for it.
+    pub fn style(mut self, kind: TokenKind, style: Style) -> Self {
+        self.styles.push((kind, style, None, None));
+        self
+    }
+
+    /// Makes `kind` take the attributes it doesn't set from `parent` instead of from [TokenKind::parent].
+    ///
+    /// Kinds below `kind` in the fixed hierarchy follow it, so they inherit from `parent` too.
+    pub fn inherit(mut self, kind: TokenKind, parent: TokenKind) -> Self {
+        self.parents.insert(kind, parent);
+        self
+    }
+
+    /// Fails [ThemeBuilder::build] when some kind's foreground has less than `ratio` WCAG contrast with its
+    /// background; see [Theme::validate].
+    pub fn min_contrast(mut self, ratio: f32) -> Self {
+        self.min_contrast = Some(ratio);
+        self
+    }
+
+    /// Checks the theme and builds it.
+    ///
+    /// Fails when the background or foreground is missing, when a color doesn't parse, when [ThemeBuilder::inherit]
+    /// makes a kind its own ancestor, or when the [ThemeBuilder::min_contrast] check finds kinds falling short.
+    pub fn build(self) -> Result<Theme, ThemeBuildError> {
+        let base = |color: Option<String>, field: &'static str| -> Result<Srgb8, ThemeBuildError> {
+            let color = color.ok_or(ThemeBuildError::Missing(field))?;
+            parse_color(&color).map_err(|source| ThemeBuildError::Color { kind: None, field, source })
+        };
+        let background = base(self.background, "background")?;
+        let foreground = base(self.foreground, "foreground")?;
+
+        let mut styles: HashMap<TokenKind, Style> = HashMap::new();
+        for (kind, style, fg, bg) in self.styles {
+            let parse = |color: Option<String>, field: &'static str| {
+                color
+                    .map(|color| parse_color(&color))
+                    .transpose()
+                    .map_err(|source| ThemeBuildError::Color { kind: Some(kind), field, source })
+            };
+            let style = Style {
+                foreground: parse(fg, "foreground")?.or(style.foreground),
+                background: parse(bg, "background")?.or(style.background),
+                ..style
+            };
+            let entry = styles.entry(kind).or_default();
+            *entry = style.inherit(*entry);
+        }
+
+        let mut theme = Theme::new(self.name, background, foreground);
+        for (&kind, &style) in &styles {
+            theme.set_style(kind, style);
+        }
+        if !self.parents.is_empty() {
+            let resolved = resolve(&theme, &self.parents)?;
+            // A kind with a parent of its own gets everything it resolves to, so the fixed hierarchy has nothing left
+            // to fill in; the kinds below it inherit from that.
+            for kind in self.parents.keys() {
+                theme.set_style(*kind, resolved[kind]);
+            }
+        }
+
+        if let Some(ratio) = self.min_contrast {
+            let issues = theme.validate(ratio);
+            if !issues.is_empty() {
+                return Err(ThemeBuildError::Contrast { min_ratio: ratio, issues });
+            }
+        }
+        Ok(theme)
+    }
+}
+
+/// Resolves the style of every kind through `parents`, falling back to [TokenKind::parent] and then to the theme's
+/// base style, or finds a kind that ends up its own ancestor.
+fn resolve(
+    theme: &Theme, parents: &HashMap<TokenKind, TokenKind>,
+) -> Result<HashMap<TokenKind, Style>, ThemeBuildError> {
+    let parent = |kind: TokenKind| parents.get(&kind).copied().or(kind.parent());
+    let base = theme.base_style();
+    let mut resolved: HashMap<TokenKind, Style> = HashMap::new();
+    for kind in TokenKind::ALL {
+        if resolved.contains_key(&kind) {
+            continue;
+        }
+        // Walk up to the first resolved ancestor, then resolve the path back down.
+        let mut path = vec![kind];
+        let mut above = parent(kind);
+        while let Some(ancestor) = above
+            && !resolved.contains_key(&ancestor)
+        {
+            if let Some(start) = path.iter().position(|&seen| seen == ancestor) {
+                let mut cycle = path.split_off(start);
+                cycle.push(ancestor);
+                return Err(ThemeBuildError::Cycle(cycle));
+            }
+            path.push(ancestor);
+            above = parent(ancestor);
+        }
+        let mut style = above.map_or(base, |ancestor| resolved[&ancestor]);
+        for &kind in path.iter().rev() {
+            style = theme.style(kind).inherit(style);
+            resolved.insert(kind, style);
+        }
+    }
+    Ok(resolved)
+}
+
+/// Why [ThemeBuilder::build] failed.
+#[derive(Debug, Clone, PartialEq)]
+pub enum ThemeBuildError {
+    /// The theme's `background` or `foreground` was never set.
+    Missing(&'static str),
+    /// The `field` color of `kind`, or of the theme itself for `None`, doesn't parse.
+    Color {
+        kind: Option<TokenKind>,
+        field: &'static str,
+        source: ColorParseError,
+    },
+    /// [ThemeBuilder::inherit] makes the first kind its own ancestor, through the kinds listed in order.
+    Cycle(Vec<TokenKind>),
+    /// Kinds whose contrast is below [ThemeBuilder::min_contrast].
+    Contrast { min_ratio: f32, issues: Vec<ContrastIssue> },
+}
+
+impl fmt::Display for ThemeBuildError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            ThemeBuildError::Missing(field) => write!(f, "theme has no {field}"),
+            ThemeBuildError::Color { kind: None, field, source } => write!(f, "theme {field}: {source}"),
+            ThemeBuildError::Color { kind: Some(kind), field, source } => {
+                write!(f, "{} {field}: {source}", kind.name())
+            }
+            ThemeBuildError::Cycle(kinds) => {
+                let chain: Vec<_> = kinds.iter().map(|kind| kind.name()).collect();
+                write!(f, "inheritance cycle: {}", chain.join(" -> "))
+            }
+            ThemeBuildError::Contrast { min_ratio, issues } => {
+                write!(f, "contrast below {min_ratio}:1 for")?;
+                for (index, issue) in issues.iter().enumerate() {
+                    let separator = if index == 0 { " " } else { ", " };
+                    write!(
+                        f,
+                        "{separator}{} foreground ({} on {}, {:.2}:1)",
+                        issue.kind.name(),
+                        issue.foreground,
+                        issue.background,
+                        issue.ratio
+                    )?;
+                }
+                Ok(())
+            }
+        }
+    }
+}
+
+impl std::error::Error for ThemeBuildError {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        match self {
+            ThemeBuildError::Color { source, .. } => Some(source),
+            _ => None,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn builder() -> ThemeBuilder {
+        ThemeBuilder::new("Test").background("#000000").foreground("#c8c8c8")
+    }
+
+    #[test]
+    fn styles_merge_in_order() {
+        let theme = builder()
+            .color(TokenKind::Keyword, "red")
+            .style(TokenKind::Keyword, Style::new().with_bold(true))
+            .background_of(TokenKind::Keyword, "rgb(0, 0, 64)")
+            .color(TokenKind::Keyword, "#00ff00")
+            .build()
+            .unwrap();
+        let style = theme.style_for(TokenKind::Keyword);
+        assert_eq!(style.foreground, Some(Srgb8::new(0, 255, 0)));
+        assert_eq!(style.background, Some(Srgb8::new(0, 0, 64)));
+        assert_eq!(style.bold, Some(true));
+        // The fixed hierarchy still applies.
+        assert_eq!(theme.color_for(TokenKind::KeywordDeclaration), Srgb8::new(0, 255, 0));
+    }
+
+    #[test]
+    fn inherit_reparents_a_kind_and_its_children() {
+        let theme = builder()
+            .color(TokenKind::String, "#00ff00")
+            .style(TokenKind::Name, Style::new().with_italic(true))
+            .inherit(TokenKind::Name, TokenKind::String)
+            .build()
+            .unwrap();
+        assert_eq!(theme.color_for(TokenKind::Name), Srgb8::new(0, 255, 0));
+        assert_eq!(theme.style_for(TokenKind::Name).italic, Some(true));
+        assert_eq!(theme.color_for(TokenKind::NameFunction), Srgb8::new(0, 255, 0));
+        assert_eq!(theme.color_for(TokenKind::Keyword), Srgb8::new(200, 200, 200));
+    }
+
+    #[test]
+    fn inheritance_cycles_name_the_kinds_involved() {
+        let error = builder()
+            .inherit(TokenKind::Keyword, TokenKind::KeywordDeclaration)
+            .build()
+            .unwrap_err();
+        assert_eq!(
+            error,
+            ThemeBuildError::Cycle(vec![
+                TokenKind::Keyword,
+                TokenKind::KeywordDeclaration,
+                TokenKind::Keyword
+            ])
+        );
+        assert_eq!(
+            error.to_string(),
+            "inheritance cycle: Keyword -> KeywordDeclaration -> Keyword"
+        );
+
+        let error = builder()
+            .inherit(TokenKind::String, TokenKind::Comment)
+            .inherit(TokenKind::Comment, TokenKind::Number)
+            .inherit(TokenKind::Number, TokenKind::String)
+            .build()
+            .unwrap_err();
+        assert!(
+            matches!(&error, ThemeBuildError::Cycle(kinds) if kinds.len() == 4),
+            "{error}"
+        );
+        assert!(builder().inherit(TokenKind::Text, TokenKind::Text).build().is_err());
+    }
+
+    #[test]
+    fn errors_name_the_field() {
+        let missing = ThemeBuilder::new("Test").background("#000").build().unwrap_err();
+        assert_eq!(missing.to_string(), "theme has no foreground");
+        let invalid = builder().background("nope").build().unwrap_err();
+        assert_eq!(invalid.to_string(), "theme background: unknown color 'nope'");
+        let invalid = builder()
+            .background_of(TokenKind::Comment, "rgb(1, 2)")
+            .build()
+            .unwrap_err();
+        assert!(
+            matches!(
+                &invalid,
+                ThemeBuildError::Color { kind: Some(TokenKind::Comment), field: "background", .. }
+            ),
+            "{invalid}"
+        );
+    }
+
+    #[test]
+    fn contrast_check_is_optional() {
+        let dim = builder().color(TokenKind::Comment, "#202020");
+        assert!(dim.clone().build().is_ok());
+        let error = dim.min_contrast(4.5).build().unwrap_err();
+        let ThemeBuildError::Contrast { issues, .. } = &error else { panic!("{error}") };
+        assert!(issues.iter().any(|issue| issue.kind == TokenKind::Comment));
+        assert!(
+            error
+                .to_string()
+                .starts_with("contrast below 4.5:1 for Comment foreground (#202020 on #000000"),
+            "{error}"
+        );
+    }
+}
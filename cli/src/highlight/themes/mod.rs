@@ -0,0 +1,173 @@
+//! Loaders that import editor color themes as highlighting [Theme]s.
+//!
+//! Editor themes style TextMate scopes rather than token kinds, so each [super::TokenKind] is resolved through its
+//! representative scope ([super::TokenKind::scope]) against the theme's scope selectors.
+
+use crate::colors::{Srgb8, Srgba8};
+use crate::highlight::{Style, Theme, TokenKind};
+use crate::parse::parse_color_with_alpha;
+use crate::terminal::{Background, detect_background};
+use selector::{Selector, resolve};
+
+use std::{fmt, io};
+
+mod base16;
+mod builder;
+pub(crate) mod plist;
+mod selector;
+mod tmtheme;
+mod vscode;
+
+pub use base16::load_base16;
+pub use builder::{ThemeBuildError, ThemeBuilder};
+pub use tmtheme::load_tmtheme;
+pub use vscode::{load_vscode, load_vscode_path};
+
+/// Errors raised while loading a theme.
+#[derive(Debug)]
+pub enum ThemeError {
+    Io(io::Error),
+    Parse(String),
+}
+
+impl fmt::Display for ThemeError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            ThemeError::Io(source) => write!(f, "failed to read theme: {source}"),
+            ThemeError::Parse(message) => write!(f, "failed to parse theme: {message}"),
+        }
+    }
+}
+
+impl std::error::Error for ThemeError {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        match self {
+            ThemeError::Io(source) => Some(source),
+            _ => None,
+        }
+    }
+}
+
+impl From<io::Error> for ThemeError {
+    fn from(source: io::Error) -> Self {
+        ThemeError::Io(source)
+    }
+}
+
+/// Picks `light` when the terminal has a light background and `dark` otherwise, including when detection fails.
+///
+/// Detection asks the terminal, so call this once at startup; see [detect_background]. [Theme::light_variant]
+/// derives a light theme when only a dark one is at hand.
+pub fn auto(dark: Theme, light: Theme) -> Theme {
+    for_background(detect_background(), dark, light)
+}
+
+/// Picks `light` for [Background::Light] and `dark` for the rest, as [auto] does with the detected background.
+pub fn for_background(background: Background, dark: Theme, light: Theme) -> Theme {
+    match background {
+        Background::Light => light,
+        Background::Dark | Background::Unknown => dark,
+    }
+}
+
+/// Attributes a single scope rule may set; `None` leaves the attribute to less specific rules.
+#[derive(Debug, Clone, Default)]
+pub(crate) struct RuleSettings {
+    pub(crate) foreground: Option<Srgb8>,
+    pub(crate) background: Option<Srgb8>,
+    /// Flags parsed with [Style::from_font_style]; colors are ignored.
+    pub(crate) font_style: Option<Style>,
+}
+
+/// Resolves every token kind's style from scope rules listed in theme order.
+///
+/// Attributes resolve independently, so a rule that only sets `fontStyle` (even to an empty string, which clears
+/// inherited attributes) does not hide a less specific rule's foreground. Kinds that no rule matches keep an empty
+/// style and inherit from their parent kind.
+pub(crate) fn apply_rules(theme: &mut Theme, rules: &[(Selector, RuleSettings)]) {
+    for kind in TokenKind::ALL {
+        let Some(scope) = kind.scope() else { continue };
+        let stack = ["source", scope];
+        let style = Style {
+            foreground: resolve(rules, &stack, |settings| settings.foreground),
+            background: resolve(rules, &stack, |settings| settings.background),
+            ..resolve(rules, &stack, |settings| settings.font_style).unwrap_or_default()
+        };
+        if !style.is_empty() {
+            theme.set_style(kind, style);
+        }
+    }
+}
+
+/// Parses any color [parse_color_with_alpha] accepts, compositing translucent colors over `backdrop` when one is
+/// known.
+///
+/// Themes in the wild contain the odd malformed value, so an unparseable color leaves the attribute unset instead of
+/// failing the whole theme.
+pub(crate) fn parse_theme_color(value: &str, backdrop: Option<Srgb8>) -> Option<Srgb8> {
+    let (color, alpha) = parse_color_with_alpha(value).ok()?;
+    let Some(backdrop) = backdrop.filter(|_| alpha < 1.0) else { return Some(color) };
+    let blend = |fg: u8, bg: u8| (fg as f32 * alpha + bg as f32 * (1.0 - alpha)).round() as u8;
+    Some(Srgb8::new(
+        blend(color.r, backdrop.r),
+        blend(color.g, backdrop.g),
+        blend(color.b, backdrop.b),
+    ))
+}
+
+/// Parses any color [parse_color_with_alpha] accepts, keeping its alpha, for the theme colors formatters can draw
+/// translucently.
+pub(crate) fn parse_theme_rgba(value: &str) -> Option<Srgba8> {
+    value.parse().ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn unknown_backgrounds_get_the_dark_theme() {
+        let dark = Theme::new("Dark", Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+        let light = dark.light_variant();
+        assert_eq!(
+            for_background(Background::Light, dark.clone(), light.clone()).name,
+            "Dark Light"
+        );
+        assert_eq!(
+            for_background(Background::Dark, dark.clone(), light.clone()).name,
+            "Dark"
+        );
+        assert_eq!(for_background(Background::Unknown, dark, light).name, "Dark");
+    }
+
+    #[test]
+    fn theme_colors_accept_short_long_and_alpha_forms() {
+        assert_eq!(parse_theme_color("#f80", None), Some(Srgb8::new(0xff, 0x88, 0x00)));
+        assert_eq!(parse_theme_color("#F8F8F2", None), Some(Srgb8::new(0xf8, 0xf8, 0xf2)));
+        assert_eq!(parse_theme_color("#ffffff80", None), Some(Srgb8::new(255, 255, 255)));
+        assert_eq!(
+            parse_theme_color("#ffffff80", Some(Srgb8::new(0, 0, 0))),
+            Some(Srgb8::new(128, 128, 128))
+        );
+        assert_eq!(parse_theme_color("#12345", None), None);
+        assert_eq!(parse_theme_color("bogus", None), None);
+    }
+
+    #[test]
+    fn theme_colors_accept_css_functions_and_names() {
+        let backdrop = Some(Srgb8::new(0, 0, 0));
+        assert_eq!(parse_theme_color("red", None), Some(Srgb8::new(255, 0, 0)));
+        assert_eq!(
+            parse_theme_color("rgb(255, 255, 255)", backdrop),
+            Some(Srgb8::new(255, 255, 255))
+        );
+        assert_eq!(
+            parse_theme_color("rgba(255, 255, 255, 50%)", backdrop),
+            Some(Srgb8::new(128, 128, 128))
+        );
+        assert_eq!(
+            parse_theme_color("hsl(0, 0%, 100%)", None),
+            Some(Srgb8::new(255, 255, 255))
+        );
+    }
+}
@@ -0,0 +1,261 @@
+//! Minimal XML property list reader covering the subset used by `.tmTheme` files.
+
+use super::ThemeError;
+
+/// A parsed property list value.
+#[derive(Debug, Clone, PartialEq)]
+pub(crate) enum Plist {
+    String(String),
+    Dict(Vec<(String, Plist)>),
+    Array(Vec<Plist>),
+    /// Integers, reals, booleans, dates, and data, kept as raw text (`"true"`/`"false"` for booleans).
+    Scalar(String),
+}
+
+impl Plist {
+    /// Looks up `key` when this value is a dictionary.
+    pub(crate) fn get(&self, key: &str) -> Option<&Plist> {
+        match self {
+            Plist::Dict(entries) => entries.iter().find(|(name, _)| name == key).map(|(_, value)| value),
+            _ => None,
+        }
+    }
+
+    pub(crate) fn as_str(&self) -> Option<&str> {
+        match self {
+            Plist::String(value) => Some(value),
+            _ => None,
+        }
+    }
+
+    pub(crate) fn as_array(&self) -> Option<&[Plist]> {
+        match self {
+            Plist::Array(items) => Some(items),
+            _ => None,
+        }
+    }
+}
+
+/// Parses an XML plist document and returns its root value.
+pub(crate) fn parse(text: &str) -> Result<Plist, ThemeError> {
+    let mut reader = Reader { text, pos: 0 };
+    reader.skip_misc();
+    let (name, empty) = reader.open_tag()?;
+    if name != "plist" {
+        return Err(reader.error(format!("expected <plist>, found <{name}>")));
+    }
+    if empty {
+        return Ok(Plist::Dict(Vec::new()));
+    }
+    let value = reader.value()?;
+    reader.close_tag("plist")?;
+    Ok(value)
+}
+
+struct Reader<'a> {
+    text: &'a str,
+    pos: usize,
+}
+
+impl<'a> Reader<'a> {
+    fn rest(&self) -> &'a str {
+        &self.text[self.pos..]
+    }
+
+    fn error(&self, message: impl Into<String>) -> ThemeError {
+        ThemeError::Parse(format!("{} at byte {}", message.into(), self.pos))
+    }
+
+    /// Skips whitespace, comments, the XML declaration, and doctype.
+    fn skip_misc(&mut self) {
+        loop {
+            let trimmed = self.rest().trim_start();
+            self.pos = self.text.len() - trimmed.len();
+            let end = if trimmed.starts_with("<!--") {
+                trimmed.find("-->").map(|i| i + 3)
+            } else if trimmed.starts_with("<?") {
+                trimmed.find("?>").map(|i| i + 2)
+            } else if trimmed.starts_with("<!DOCTYPE") {
+                trimmed.find('>').map(|i| i + 1)
+            } else {
+                return;
+            };
+            self.pos += end.unwrap_or(trimmed.len());
+        }
+    }
+
+    /// Reads `<name ...>` or `<name .../>`, returning the name and whether the element is empty.
+    fn open_tag(&mut self) -> Result<(String, bool), ThemeError> {
+        self.skip_misc();
+        let rest = self.rest();
+        if !rest.starts_with('<') || rest.starts_with("</") {
+            return Err(self.error("expected an element"));
+        }
+        let end = rest.find('>').ok_or_else(|| self.error("unterminated tag"))?;
+        let inner = &rest[1..end];
+        let empty = inner.ends_with('/');
+        let name = inner
+            .trim_end_matches('/')
+            .split_whitespace()
+            .next()
+            .unwrap_or("")
+            .to_string();
+        self.pos += end + 1;
+        Ok((name, empty))
+    }
+
+    fn close_tag(&mut self, name: &str) -> Result<(), ThemeError> {
+        self.skip_misc();
+        let expected = format!("</{name}>");
+        if self.rest().starts_with(&expected) {
+            self.pos += expected.len();
+            Ok(())
+        } else {
+            Err(self.error(format!("expected {expected}")))
+        }
+    }
+
+    fn at_close(&mut self) -> bool {
+        self.skip_misc();
+        self.rest().starts_with("</")
+    }
+
+    /// Reads character data up to the next tag, decoding entities and CDATA sections.
+    fn text(&mut self) -> Result<String, ThemeError> {
+        let mut out = String::new();
+        loop {
+            let rest = self.rest();
+            if let Some(cdata) = rest.strip_prefix("<![CDATA[") {
+                let end = cdata.find("]]>").ok_or_else(|| self.error("unterminated CDATA"))?;
+                out.push_str(&cdata[..end]);
+                self.pos += "<![CDATA[".len() + end + 3;
+                continue;
+            }
+            if let Some(comment) = rest.strip_prefix("<!--") {
+                let end = comment.find("-->").ok_or_else(|| self.error("unterminated comment"))?;
+                self.pos += 4 + end + 3;
+                continue;
+            }
+            let end = rest.find('<').ok_or_else(|| self.error("unexpected end of document"))?;
+            decode_entities(&rest[..end], &mut out);
+            self.pos += end;
+            if !self.rest().starts_with("<![CDATA[") && !self.rest().starts_with("<!--") {
+                return Ok(out);
+            }
+        }
+    }
+
+    fn value(&mut self) -> Result<Plist, ThemeError> {
+        let (name, empty) = self.open_tag()?;
+        match name.as_str() {
+            "dict" => {
+                let mut entries = Vec::new();
+                if !empty {
+                    while !self.at_close() {
+                        let (key_tag, key_empty) = self.open_tag()?;
+                        if key_tag != "key" {
+                            return Err(self.error(format!("expected <key>, found <{key_tag}>")));
+                        }
+                        let key = if key_empty { String::new() } else { self.text_element("key")? };
+                        entries.push((key, self.value()?));
+                    }
+                    self.close_tag("dict")?;
+                }
+                Ok(Plist::Dict(entries))
+            }
+            "array" => {
+                let mut items = Vec::new();
+                if !empty {
+                    while !self.at_close() {
+                        items.push(self.value()?);
+                    }
+                    self.close_tag("array")?;
+                }
+                Ok(Plist::Array(items))
+            }
+            "true" | "false" if empty => Ok(Plist::Scalar(name)),
+            _ if empty => {
+                Ok(if name == "string" { Plist::String(String::new()) } else { Plist::Scalar(String::new()) })
+            }
+            "string" => Ok(Plist::String(self.text_element("string")?)),
+            _ => Ok(Plist::Scalar(self.text_element(&name)?)),
+        }
+    }
+
+    fn text_element(&mut self, name: &str) -> Result<String, ThemeError> {
+        let text = self.text()?;
+        self.close_tag(name)?;
+        Ok(text)
+    }
+}
+
+/// Appends `raw` to `out` with the predefined XML entities and character references decoded.
+pub(crate) fn decode_entities(raw: &str, out: &mut String) {
+    let mut rest = raw;
+    while let Some(amp) = rest.find('&') {
+        out.push_str(&rest[..amp]);
+        rest = &rest[amp..];
+        let Some(semi) = rest.find(';') else { break };
+        let entity = &rest[1..semi];
+        let decoded = match entity {
+            "lt" => Some('<'),
+            "gt" => Some('>'),
+            "amp" => Some('&'),
+            "quot" => Some('"'),
+            "apos" => Some('\''),
+            _ => entity
+                .strip_prefix("#x")
+                .map(|hex| u32::from_str_radix(hex, 16))
+                .or_else(|| entity.strip_prefix('#').map(str::parse))
+                .and_then(Result::ok)
+                .and_then(char::from_u32),
+        };
+        match decoded {
+            Some(ch) => {
+                out.push(ch);
+                rest = &rest[semi + 1..];
+            }
+            None => {
+                out.push('&');
+                rest = &rest[1..];
+            }
+        }
+    }
+    out.push_str(rest);
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parses_nested_values() {
+        let doc = r#"<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <!-- comment -->
+    <key>name</key>
+    <string>Tom &amp; Jerry &#x263A;</string>
+    <key>items</key>
+    <array>
+        <dict><key>flag</key><true/></dict>
+        <string/>
+        <integer>3</integer>
+    </array>
+</dict>
+</plist>"#;
+        let plist = parse(doc).unwrap();
+        assert_eq!(plist.get("name").and_then(Plist::as_str), Some("Tom & Jerry ☺"));
+        let items = plist.get("items").and_then(Plist::as_array).unwrap();
+        assert_eq!(items[0].get("flag"), Some(&Plist::Scalar("true".to_string())));
+        assert_eq!(items[1], Plist::String(String::new()));
+        assert_eq!(items[2], Plist::Scalar("3".to_string()));
+    }
+
+    #[test]
+    fn rejects_malformed_documents() {
+        assert!(parse("<dict></dict>").is_err());
+        assert!(parse("<plist><dict><key>a</key>").is_err());
+    }
+}
@@ -0,0 +1,161 @@
+//! TextMate scope selectors with basic specificity scoring.
+//!
+//! Supports comma-separated alternatives, descendant paths (`source.go string`), and exclusions
+//! (`string - string.regexp`). Each path element matches a scope by dot-separated prefix.
+
+/// A parsed scope selector such as `"keyword.control, storage - storage.type"`.
+#[derive(Debug, Clone, PartialEq)]
+pub(crate) struct Selector {
+    alternatives: Vec<Alternative>,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct Alternative {
+    path: Vec<String>,
+    excludes: Vec<Vec<String>>,
+}
+
+/// How specifically a selector matched a scope stack; larger is more specific.
+///
+/// Compares, in order: how deep in the stack the innermost match sits, how many dot-separated segments of that scope
+/// the selector named, and how many path elements matched.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub(crate) struct Score {
+    depth: usize,
+    segments: usize,
+    path_len: usize,
+}
+
+impl Selector {
+    pub(crate) fn parse(text: &str) -> Self {
+        let alternatives = text
+            .split(',')
+            .filter_map(|alternative| {
+                let mut parts = alternative.split(" - ");
+                let path = split_path(parts.next()?);
+                if path.is_empty() {
+                    return None;
+                }
+                let excludes = parts.map(split_path).filter(|path| !path.is_empty()).collect();
+                Some(Alternative { path, excludes })
+            })
+            .collect();
+        Self { alternatives }
+    }
+
+    /// Returns the best score among the alternatives matching `stack` (ordered outermost to innermost).
+    pub(crate) fn score(&self, stack: &[&str]) -> Option<Score> {
+        self.alternatives
+            .iter()
+            .filter(|alternative| {
+                !alternative
+                    .excludes
+                    .iter()
+                    .any(|exclude| match_path(exclude, stack).is_some())
+            })
+            .filter_map(|alternative| match_path(&alternative.path, stack))
+            .max()
+    }
+}
+
+fn split_path(text: &str) -> Vec<String> {
+    text.split_whitespace().map(str::to_string).collect()
+}
+
+/// Matches `path` as an ordered subsequence of `stack`, anchoring its last element as deep as possible.
+fn match_path(path: &[String], stack: &[&str]) -> Option<Score> {
+    let (last, ancestors) = path.split_last()?;
+    for depth in (0..stack.len()).rev() {
+        if !prefix_matches(stack[depth], last) {
+            continue;
+        }
+        let mut remaining = &stack[..depth];
+        let found_ancestors = ancestors.iter().rev().all(|element| {
+            match remaining.iter().rposition(|scope| prefix_matches(scope, element)) {
+                Some(index) => {
+                    remaining = &remaining[..index];
+                    true
+                }
+                None => false,
+            }
+        });
+        if found_ancestors {
+            return Some(Score { depth: depth + 1, segments: last.split('.').count(), path_len: path.len() });
+        }
+    }
+    None
+}
+
+fn prefix_matches(scope: &str, prefix: &str) -> bool {
+    scope
+        .strip_prefix(prefix)
+        .is_some_and(|rest| rest.is_empty() || rest.starts_with('.'))
+}
+
+/// Resolves a value for `stack` from rules in theme order, letting the highest score win and later rules break ties.
+///
+/// `pick` extracts the attribute being resolved, so rules that do not set it are skipped.
+pub(crate) fn resolve<T, U>(rules: &[(Selector, T)], stack: &[&str], pick: impl Fn(&T) -> Option<U>) -> Option<U> {
+    let mut best: Option<(Score, U)> = None;
+    for (selector, settings) in rules {
+        let Some(value) = pick(settings) else { continue };
+        let Some(score) = selector.score(stack) else { continue };
+        if best.as_ref().is_none_or(|(current, _)| score >= *current) {
+            best = Some((score, value));
+        }
+    }
+    best.map(|(_, value)| value)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn prefixes_match_on_segment_boundaries() {
+        let selector = Selector::parse("keyword");
+        assert!(selector.score(&["source", "keyword.control"]).is_some());
+        assert!(selector.score(&["source", "keywords"]).is_none());
+    }
+
+    #[test]
+    fn comma_alternatives_and_exclusions() {
+        let selector = Selector::parse("comment, string - string.regexp");
+        assert!(selector.score(&["source", "comment.line"]).is_some());
+        assert!(selector.score(&["source", "string.quoted"]).is_some());
+        assert!(selector.score(&["source", "string.regexp"]).is_none());
+    }
+
+    #[test]
+    fn longer_and_deeper_matches_score_higher() {
+        let stack = ["source.go", "keyword.control"];
+        let broad = Selector::parse("keyword").score(&stack).unwrap();
+        let narrow = Selector::parse("keyword.control").score(&stack).unwrap();
+        let scoped = Selector::parse("source.go keyword.control").score(&stack).unwrap();
+        let outer = Selector::parse("source").score(&stack).unwrap();
+        assert!(outer < broad && broad < narrow && narrow < scoped);
+    }
+
+    #[test]
+    fn descendant_paths_require_ancestors() {
+        assert!(
+            Selector::parse("text.html string")
+                .score(&["source.go", "string.quoted"])
+                .is_none()
+        );
+    }
+
+    #[test]
+    fn resolve_prefers_specific_rules_then_later_rules() {
+        let rules = vec![
+            (Selector::parse("keyword.control"), Some(1)),
+            (Selector::parse("keyword"), Some(2)),
+            (Selector::parse("keyword.control"), None),
+            (Selector::parse("string"), Some(3)),
+            (Selector::parse("string"), Some(4)),
+        ];
+        assert_eq!(resolve(&rules, &["source", "keyword.control"], |value| *value), Some(1));
+        assert_eq!(resolve(&rules, &["source", "string.quoted"], |value| *value), Some(4));
+        assert_eq!(resolve(&rules, &["source", "comment"], |value| *value), None);
+    }
+}
@@ -0,0 +1,174 @@
+//! TextMate `.tmTheme` (XML plist) loader.
+
+use super::plist::{self, Plist};
+use super::selector::Selector;
+use super::{RuleSettings, ThemeError, apply_rules, parse_theme_color, parse_theme_rgba};
+use crate::colors::Srgb8;
+use crate::highlight::{Style, Theme};
+
+use std::io::Read;
+
+/// Loads a TextMate `.tmTheme` file.
+///
+/// The unscoped settings entry supplies the background, foreground, and caret colors. Scoped entries are matched
+/// against each token kind's representative scope, with the most specific selector winning; entries with unknown
+/// scopes or unparsable colors are ignored.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::TokenKind;
+/// use colorizer::highlight::themes::load_tmtheme;
+///
+/// let file = std::fs::File::open("../examples/themes/monokai.tmTheme").unwrap();
+/// let theme = load_tmtheme(file).unwrap();
+/// assert_eq!(theme.name, "Monokai");
+/// assert_eq!(theme.color_for(TokenKind::String).to_hex(), "#e6db74");
+/// ```
+pub fn load_tmtheme(mut reader: impl Read) -> Result<Theme, ThemeError> {
+    let mut text = String::new();
+    reader.read_to_string(&mut text)?;
+    parse_tmtheme(&text)
+}
+
+fn parse_tmtheme(text: &str) -> Result<Theme, ThemeError> {
+    let root = plist::parse(text)?;
+    let name = root.get("name").and_then(Plist::as_str).unwrap_or("Untitled");
+    let items = root
+        .get("settings")
+        .and_then(Plist::as_array)
+        .ok_or_else(|| ThemeError::Parse("missing settings array".to_string()))?;
+
+    let global = items
+        .iter()
+        .find(|item| item.get("scope").is_none())
+        .and_then(|item| item.get("settings"));
+    let global_color = |key: &str, backdrop: Option<Srgb8>| {
+        global
+            .and_then(|settings| settings.get(key))
+            .and_then(Plist::as_str)
+            .and_then(|value| parse_theme_color(value, backdrop))
+    };
+    let background = global_color("background", None).unwrap_or(Srgb8::new(0, 0, 0));
+    let foreground = global_color("foreground", Some(background)).unwrap_or(Srgb8::new(255, 255, 255));
+
+    let mut theme = Theme::new(name, background, foreground);
+    theme.caret = global_color("caret", Some(background));
+    theme.gutter.foreground = global_color("gutterForeground", Some(background));
+    theme.whitespace.foreground = global_color("invisibles", Some(background));
+    theme.line_highlight = global
+        .and_then(|settings| settings.get("lineHighlight"))
+        .and_then(Plist::as_str)
+        .and_then(parse_theme_rgba);
+
+    let rules: Vec<(Selector, RuleSettings)> = items
+        .iter()
+        .filter_map(|item| {
+            let scope = item.get("scope").and_then(Plist::as_str)?;
+            let setting = |key: &str| {
+                item.get("settings")
+                    .and_then(|settings| settings.get(key))
+                    .and_then(Plist::as_str)
+            };
+            let settings = RuleSettings {
+                foreground: setting("foreground").and_then(|value| parse_theme_color(value, Some(background))),
+                background: setting("background").and_then(|value| parse_theme_color(value, Some(background))),
+                font_style: setting("fontStyle").map(Style::from_font_style),
+            };
+            Some((Selector::parse(scope), settings))
+        })
+        .collect();
+    apply_rules(&mut theme, &rules);
+    Ok(theme)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::TokenKind;
+
+    const MONOKAI: &str = include_str!("../../../../examples/themes/monokai.tmTheme");
+    const SOLARIZED_DARK: &str = include_str!("../../../../examples/themes/solarized-dark.tmTheme");
+
+    fn hex(theme: &Theme, kind: TokenKind) -> String {
+        theme.color_for(kind).to_hex()
+    }
+
+    #[test]
+    fn monokai_maps_scopes_to_token_kinds() {
+        let theme = parse_tmtheme(MONOKAI).unwrap();
+        assert_eq!(theme.name, "Monokai");
+        assert_eq!(theme.background.to_hex(), "#272822");
+        assert_eq!(theme.foreground.to_hex(), "#f8f8f2");
+        assert_eq!(theme.caret.map(|caret| caret.to_hex()), Some("#f8f8f0".to_string()));
+        assert_eq!(theme.line_highlight_color().to_hex(), "#3e3d32");
+        assert_eq!(
+            theme.whitespace_style().foreground.map(|color| color.to_hex()),
+            Some("#3b3a32".to_string())
+        );
+
+        assert_eq!(hex(&theme, TokenKind::Comment), "#75715e");
+        assert_eq!(hex(&theme, TokenKind::String), "#e6db74");
+        assert_eq!(hex(&theme, TokenKind::Number), "#ae81ff");
+        assert_eq!(hex(&theme, TokenKind::Keyword), "#f92672");
+        assert_eq!(hex(&theme, TokenKind::KeywordDeclaration), "#f92672");
+        assert_eq!(hex(&theme, TokenKind::KeywordType), "#66d9ef");
+        assert_eq!(hex(&theme, TokenKind::NameFunction), "#a6e22e");
+        assert_eq!(hex(&theme, TokenKind::NameBuiltin), "#66d9ef");
+        assert_eq!(hex(&theme, TokenKind::NameTag), "#f92672");
+        assert_eq!(hex(&theme, TokenKind::NameAttribute), "#a6e22e");
+
+        assert_eq!(theme.style_for(TokenKind::KeywordType).italic, Some(true));
+        assert_eq!(theme.style_for(TokenKind::NameClass).underline, Some(true));
+        assert_eq!(theme.style_for(TokenKind::Keyword).italic, Some(false));
+        assert_eq!(
+            theme.style_for(TokenKind::Error).background,
+            Some(Srgb8::new(0xf9, 0x26, 0x72))
+        );
+    }
+
+    #[test]
+    fn solarized_dark_resolves_comma_selectors_and_specificity() {
+        let theme = parse_tmtheme(SOLARIZED_DARK).unwrap();
+        assert_eq!(theme.name, "Solarized (dark)");
+        assert_eq!(theme.background.to_hex(), "#002b36");
+        assert_eq!(theme.foreground.to_hex(), "#839496");
+
+        assert_eq!(hex(&theme, TokenKind::Comment), "#586e75");
+        assert_eq!(hex(&theme, TokenKind::String), "#2aa198");
+        assert_eq!(hex(&theme, TokenKind::StringRegex), "#dc322f");
+        assert_eq!(hex(&theme, TokenKind::Keyword), "#859900");
+        assert_eq!(hex(&theme, TokenKind::Operator), "#859900");
+        assert_eq!(hex(&theme, TokenKind::Number), "#d33682");
+        assert_eq!(hex(&theme, TokenKind::KeywordConstant), "#b58900");
+        assert_eq!(hex(&theme, TokenKind::NameFunction), "#268bd2");
+        assert_eq!(hex(&theme, TokenKind::NameClass), "#cb4b16");
+    }
+
+    #[test]
+    fn unknown_scopes_and_bad_colors_are_ignored() {
+        let doc = r#"<plist><dict><key>settings</key><array>
+            <dict><key>settings</key><dict><key>background</key><string>#000000</string></dict></dict>
+            <dict><key>scope</key><string>markup.list.numbered</string>
+                <key>settings</key><dict><key>foreground</key><string>#ff0000</string></dict></dict>
+            <dict><key>scope</key><string>comment</string>
+                <key>settings</key><dict><key>foreground</key><string>not-a-color</string></dict></dict>
+        </array></dict></plist>"#;
+        let theme = parse_tmtheme(doc).unwrap();
+        assert_eq!(theme.name, "Untitled");
+        assert_eq!(theme.get(TokenKind::Comment), None);
+        assert!(
+            TokenKind::ALL
+                .iter()
+                .all(|&kind| kind == TokenKind::Error || theme.get(kind).is_none())
+        );
+    }
+
+    #[test]
+    fn missing_settings_is_an_error() {
+        assert!(matches!(
+            parse_tmtheme("<plist><dict/></plist>"),
+            Err(ThemeError::Parse(_))
+        ));
+    }
+}
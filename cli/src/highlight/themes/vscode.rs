@@ -0,0 +1,522 @@
+//! VS Code JSON color theme loader.
+
+use super::selector::Selector;
+use super::{RuleSettings, ThemeError, apply_rules, parse_theme_color, parse_theme_rgba};
+use crate::colors::Srgb8;
+use crate::highlight::{Style, Theme, TokenKind};
+
+use serde::Deserialize;
+use serde_json::Value;
+use std::collections::HashMap;
+use std::fs;
+use std::io::Read;
+use std::path::Path;
+
+/// Maximum depth of `include` chains followed by [load_vscode_path].
+const MAX_INCLUDE_DEPTH: usize = 8;
+
+#[derive(Debug, Default, Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct RawTheme {
+    name: Option<String>,
+    #[serde(rename = "type")]
+    kind: Option<String>,
+    include: Option<String>,
+    #[serde(default)]
+    colors: HashMap<String, String>,
+    #[serde(default)]
+    token_colors: Vec<RawTokenColor>,
+    #[serde(default)]
+    semantic_highlighting: bool,
+    #[serde(default)]
+    semantic_token_colors: HashMap<String, Value>,
+}
+
+#[derive(Debug, Deserialize)]
+struct RawTokenColor {
+    scope: Option<RawScope>,
+    #[serde(default)]
+    settings: RawSettings,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(untagged)]
+enum RawScope {
+    One(String),
+    Many(Vec<String>),
+}
+
+#[derive(Debug, Default, Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct RawSettings {
+    foreground: Option<String>,
+    background: Option<String>,
+    font_style: Option<String>,
+}
+
+/// Loads a VS Code color theme (`.json`, comments and trailing commas allowed).
+///
+/// `tokenColors` rules are matched against each token kind's representative scope. When the theme enables
+/// `semanticHighlighting`, matching `semanticTokenColors` entries take precedence, as they do in the editor.
+/// `semanticTokenColors` keys naming a kind, like `"CellReference"`, style that kind directly, which is how a theme
+/// reaches custom kinds. An `include` key is ignored; use [load_vscode_path] to follow it.
+pub fn load_vscode(mut reader: impl Read) -> Result<Theme, ThemeError> {
+    let mut text = String::new();
+    reader.read_to_string(&mut text)?;
+    Ok(build_theme(parse_raw(&text)?))
+}
+
+/// Loads a VS Code color theme from disk, merging any `include`d base themes first (e.g., `dark_plus.json`
+/// including `dark_vs.json`).
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::highlight::TokenKind;
+/// use colorizer::highlight::themes::load_vscode_path;
+///
+/// let theme = load_vscode_path("../examples/themes/dark_plus.json").unwrap();
+/// assert_eq!(theme.background.to_hex(), "#1e1e1e");
+/// assert_eq!(theme.color_for(TokenKind::NameFunction).to_hex(), "#dcdcaa");
+/// ```
+pub fn load_vscode_path(path: impl AsRef<Path>) -> Result<Theme, ThemeError> {
+    Ok(build_theme(load_with_includes(path.as_ref(), 0)?))
+}
+
+fn load_with_includes(path: &Path, depth: usize) -> Result<RawTheme, ThemeError> {
+    if depth > MAX_INCLUDE_DEPTH {
+        return Err(ThemeError::Parse(format!(
+            "include chain too deep at {}",
+            path.display()
+        )));
+    }
+    let text = fs::read_to_string(path)?;
+    let mut raw = parse_raw(&text)?;
+    let Some(include) = raw.include.take() else { return Ok(raw) };
+
+    let base_path = path.parent().unwrap_or(Path::new(".")).join(include);
+    let mut base = load_with_includes(&base_path, depth + 1)?;
+    base.name = raw.name.or(base.name);
+    base.kind = raw.kind.or(base.kind);
+    base.colors.extend(raw.colors);
+    base.token_colors.extend(raw.token_colors);
+    base.semantic_highlighting |= raw.semantic_highlighting;
+    base.semantic_token_colors.extend(raw.semantic_token_colors);
+    Ok(base)
+}
+
+fn parse_raw(text: &str) -> Result<RawTheme, ThemeError> {
+    serde_json::from_str(&strip_jsonc(text)).map_err(|err| ThemeError::Parse(err.to_string()))
+}
+
+fn build_theme(raw: RawTheme) -> Theme {
+    let light = raw
+        .kind
+        .as_deref()
+        .is_some_and(|kind| kind.starts_with("light") || kind == "hcLight");
+    let (default_bg, default_fg) = if light {
+        (Srgb8::new(0xff, 0xff, 0xff), Srgb8::new(0, 0, 0))
+    } else {
+        (Srgb8::new(0x1e, 0x1e, 0x1e), Srgb8::new(0xd4, 0xd4, 0xd4))
+    };
+
+    // Legacy themes put the editor colors in a scope-less tokenColors entry.
+    let global = raw
+        .token_colors
+        .iter()
+        .find(|rule| rule.scope.is_none())
+        .map(|rule| &rule.settings);
+    let color = |key: &str, legacy: Option<&String>, backdrop: Option<Srgb8>| {
+        raw.colors
+            .get(key)
+            .or(legacy)
+            .and_then(|value| parse_theme_color(value, backdrop))
+    };
+    let background = color(
+        "editor.background",
+        global.and_then(|settings| settings.background.as_ref()),
+        None,
+    )
+    .unwrap_or(default_bg);
+    let foreground = color(
+        "editor.foreground",
+        global.and_then(|settings| settings.foreground.as_ref()),
+        Some(background),
+    )
+    .unwrap_or(default_fg);
+
+    let mut theme = Theme::new(raw.name.as_deref().unwrap_or("Untitled"), background, foreground);
+    theme.caret = color("editorCursor.foreground", None, Some(background));
+    theme.gutter.foreground = color("editorLineNumber.foreground", None, Some(background));
+    theme.whitespace.foreground = color("editorWhitespace.foreground", None, Some(background));
+    theme.line_highlight = raw
+        .colors
+        .get("editor.lineHighlightBackground")
+        .and_then(|value| parse_theme_rgba(value));
+    theme.diff_added = color("diffEditor.insertedLineBackground", None, Some(background));
+    theme.diff_removed = color("diffEditor.removedLineBackground", None, Some(background));
+    theme.search_match = color("editor.findMatchHighlightBackground", None, Some(background));
+
+    // Bracket pair colors for RainbowBrackets; RainbowBrackets::default_colors fills in the levels a theme leaves out.
+    for level in 1..=6 {
+        if let Some(foreground) = color(
+            &format!("editorBracketHighlight.foreground{level}"),
+            None,
+            Some(background),
+        ) {
+            theme.set_style_by_name(
+                &format!("PunctuationBracket{level}"),
+                Style::new().with_foreground(foreground),
+            );
+        }
+    }
+    if let Some(foreground) = color(
+        "editorBracketHighlight.unexpectedBracket.foreground",
+        None,
+        Some(background),
+    ) {
+        theme.set_style_by_name("PunctuationBracketMismatch", Style::new().with_foreground(foreground));
+    }
+
+    let mut rules = Vec::new();
+    for rule in &raw.token_colors {
+        let selectors = match &rule.scope {
+            Some(RawScope::One(scope)) => vec![scope.as_str()],
+            Some(RawScope::Many(scopes)) => scopes.iter().map(String::as_str).collect(),
+            None => continue,
+        };
+        let settings = RuleSettings {
+            foreground: rule
+                .settings
+                .foreground
+                .as_deref()
+                .and_then(|value| parse_theme_color(value, Some(background))),
+            background: rule
+                .settings
+                .background
+                .as_deref()
+                .and_then(|value| parse_theme_color(value, Some(background))),
+            font_style: rule.settings.font_style.as_deref().map(Style::from_font_style),
+        };
+        rules.push((Selector::parse(&selectors.join(",")), settings));
+    }
+    apply_rules(&mut theme, &rules);
+
+    if raw.semantic_highlighting {
+        apply_semantic_rules(&mut theme, &raw.semantic_token_colors, background);
+    }
+    apply_named_rules(&mut theme, &raw.semantic_token_colors, background);
+    theme
+}
+
+/// Semantic token type and modifiers that best describe each kind, per the LSP semantic token legend.
+fn semantic_token(kind: TokenKind) -> Option<(&'static str, &'static [&'static str])> {
+    let token: (&str, &[&str]) = match kind {
+        TokenKind::Keyword | TokenKind::KeywordDeclaration => ("keyword", &[]),
+        TokenKind::KeywordType => ("type", &[]),
+        TokenKind::NameFunction => ("function", &[]),
+        TokenKind::NameBuiltin => ("function", &["defaultLibrary"]),
+        TokenKind::NameClass => ("class", &[]),
+        TokenKind::NameVariable => ("variable", &[]),
+        TokenKind::NameConstant => ("variable", &["readonly"]),
+        TokenKind::NameAttribute => ("property", &[]),
+        TokenKind::String => ("string", &[]),
+        TokenKind::StringRegex => ("regexp", &[]),
+        TokenKind::Number | TokenKind::NumberDate => ("number", &[]),
+        TokenKind::Operator => ("operator", &[]),
+        TokenKind::NameMacro => ("macro", &[]),
+        TokenKind::Comment => ("comment", &[]),
+        TokenKind::CommentDoc => ("comment", &["documentation"]),
+        _ => return None,
+    };
+    Some(token)
+}
+
+/// Applies `semanticTokenColors`, whose keys look like `type.modifier1.modifier2` with `*` matching any type.
+///
+/// The entry naming the most modifiers wins, then an exact type over `*`. Language-scoped keys (`type:go`) are
+/// skipped because they only apply to a single language.
+fn apply_semantic_rules(theme: &mut Theme, entries: &HashMap<String, Value>, background: Srgb8) {
+    for kind in TokenKind::ALL {
+        let Some((token_type, modifiers)) = semantic_token(kind) else { continue };
+        let best = entries
+            .iter()
+            .filter(|(key, _)| !key.contains(':'))
+            .filter_map(|(key, value)| {
+                let mut parts = key.split('.');
+                let selector_type = parts.next()?;
+                let selector_modifiers: Vec<&str> = parts.collect();
+                let type_matches = selector_type == "*" || selector_type == token_type;
+                let modifiers_match = selector_modifiers.iter().all(|modifier| modifiers.contains(modifier));
+                (type_matches && modifiers_match)
+                    .then(|| ((selector_modifiers.len(), selector_type != "*", key.as_str()), value))
+            })
+            .max_by(|(a, _), (b, _)| a.cmp(b));
+
+        let Some((_, value)) = best else { continue };
+        let style = semantic_style(value, background).inherit(theme.style(kind));
+        theme.set_style(kind, style);
+    }
+}
+
+/// Applies `semanticTokenColors` entries keyed by a token kind name, such as `"UnitLiteral"`, which style custom kinds
+/// (see [TokenKind::register]) whether or not the lexer defining them has been loaded.
+///
+/// Semantic token types are camelCase, so names starting with an uppercase letter can't be mistaken for them; these
+/// entries apply even when `semanticHighlighting` is off.
+fn apply_named_rules(theme: &mut Theme, entries: &HashMap<String, Value>, background: Srgb8) {
+    let named = entries
+        .iter()
+        .filter(|(key, _)| key.starts_with(|c: char| c.is_ascii_uppercase()) && !key.contains(['.', ':']));
+    for (name, value) in named {
+        let existing = TokenKind::from_name(name)
+            .map(|kind| theme.style(kind))
+            .unwrap_or_default();
+        theme.set_style_by_name(name, semantic_style(value, background).inherit(existing));
+    }
+}
+
+/// Reads a semantic entry: either a color string or an object with `foreground`, `fontStyle`, and boolean flags.
+///
+/// Flags override `fontStyle`; attributes the entry doesn't mention stay unset so the scope rules still apply.
+fn semantic_style(value: &Value, background: Srgb8) -> Style {
+    let color = |value: &Value| {
+        value
+            .as_str()
+            .and_then(|color| parse_theme_color(color, Some(background)))
+    };
+    match value {
+        Value::String(_) => Style { foreground: color(value), ..Style::default() },
+        Value::Object(map) => {
+            let flag = |name: &str| map.get(name).and_then(Value::as_bool);
+            let font_style = map.get("fontStyle").and_then(Value::as_str).map(Style::from_font_style);
+            let flags = Style {
+                foreground: map.get("foreground").and_then(color),
+                bold: flag("bold"),
+                italic: flag("italic"),
+                underline: flag("underline"),
+                strikethrough: flag("strikethrough"),
+                ..Style::default()
+            };
+            flags.inherit(font_style.unwrap_or_default())
+        }
+        _ => Style::default(),
+    }
+}
+
+/// Converts JSONC to JSON by blanking `//` and `/* */` comments and dropping trailing commas.
+fn strip_jsonc(text: &str) -> String {
+    let mut without_comments = String::with_capacity(text.len());
+    let mut chars = text.chars().peekable();
+    let mut in_string = false;
+    while let Some(ch) = chars.next() {
+        if in_string {
+            without_comments.push(ch);
+            match ch {
+                '\\' => without_comments.extend(chars.next()),
+                '"' => in_string = false,
+                _ => {}
+            }
+            continue;
+        }
+        match (ch, chars.peek()) {
+            ('"', _) => {
+                in_string = true;
+                without_comments.push(ch);
+            }
+            ('/', Some('/')) => {
+                for next in chars.by_ref() {
+                    if next == '\n' {
+                        without_comments.push('\n');
+                        break;
+                    }
+                }
+            }
+            ('/', Some('*')) => {
+                chars.next();
+                let mut previous = '\0';
+                for next in chars.by_ref() {
+                    if previous == '*' && next == '/' {
+                        break;
+                    }
+                    previous = next;
+                }
+                without_comments.push(' ');
+            }
+            _ => without_comments.push(ch),
+        }
+    }
+
+    let mut json = String::with_capacity(without_comments.len());
+    let mut in_string = false;
+    let mut escaped = false;
+    for (i, ch) in without_comments.char_indices() {
+        if in_string {
+            in_string = ch != '"' || escaped;
+            escaped = ch == '\\' && !escaped;
+        } else if ch == '"' {
+            in_string = true;
+        } else if ch == ',' {
+            let next = without_comments[i + 1..].trim_start().chars().next();
+            if matches!(next, Some('}' | ']')) {
+                continue;
+            }
+        }
+        json.push(ch);
+    }
+    json
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::highlight::RainbowBrackets;
+    use crate::highlight::filter::LIGHT_BRACKETS;
+
+    fn hex(theme: &Theme, kind: TokenKind) -> String {
+        theme.color_for(kind).to_hex()
+    }
+
+    #[test]
+    fn jsonc_comments_and_trailing_commas_are_removed() {
+        let jsonc = r#"{
+            // line comment with "quotes"
+            "a": "http://example.com", /* block */
+            "b": ["x,]", "y\"//",],
+        }"#;
+        let value: Value = serde_json::from_str(&strip_jsonc(jsonc)).unwrap();
+        assert_eq!(value["a"], "http://example.com");
+        assert_eq!(value["b"][0], "x,]");
+        assert_eq!(value["b"][1], "y\"//");
+    }
+
+    #[test]
+    fn dark_plus_matches_the_editor_palette() {
+        let theme = load_vscode_path("../examples/themes/dark_plus.json").unwrap();
+        assert_eq!(theme.name, "Dark+");
+        assert_eq!(theme.background.to_hex(), "#1e1e1e");
+        assert_eq!(theme.foreground.to_hex(), "#d4d4d4");
+        assert_eq!(
+            theme.gutter_style().foreground.map(|color| color.to_hex()),
+            Some("#858585".to_string())
+        );
+        assert_eq!(theme.line_highlight_color().to_hex(), "#272727");
+        assert_eq!(
+            theme.line_highlight.map(|color| color.to_hex()),
+            Some("#ffffff0a".to_string())
+        );
+
+        assert_eq!(hex(&theme, TokenKind::Keyword), "#c586c0");
+        assert_eq!(hex(&theme, TokenKind::KeywordDeclaration), "#569cd6");
+        assert_eq!(hex(&theme, TokenKind::KeywordType), "#569cd6");
+        assert_eq!(hex(&theme, TokenKind::KeywordConstant), "#569cd6");
+        assert_eq!(hex(&theme, TokenKind::NameFunction), "#dcdcaa");
+        assert_eq!(hex(&theme, TokenKind::NameBuiltin), "#dcdcaa");
+        assert_eq!(hex(&theme, TokenKind::NameClass), "#4ec9b0");
+        assert_eq!(hex(&theme, TokenKind::NameVariable), "#9cdcfe");
+        assert_eq!(hex(&theme, TokenKind::String), "#ce9178");
+        assert_eq!(hex(&theme, TokenKind::StringEscape), "#d7ba7d");
+        assert_eq!(hex(&theme, TokenKind::StringRegex), "#d16969");
+        assert_eq!(hex(&theme, TokenKind::Number), "#b5cea8");
+        assert_eq!(hex(&theme, TokenKind::Comment), "#6a9955");
+        assert_eq!(hex(&theme, TokenKind::Operator), "#d4d4d4");
+    }
+
+    #[test]
+    fn reader_loading_ignores_includes() {
+        let file = fs::File::open("../examples/themes/dark_plus.json").unwrap();
+        let theme = load_vscode(file).unwrap();
+        assert_eq!(theme.get(TokenKind::Comment), None);
+        assert_eq!(hex(&theme, TokenKind::NameFunction), "#dcdcaa");
+    }
+
+    #[test]
+    fn font_styles_map_and_empty_strings_clear() {
+        let jsonc = r##"{
+            "name": "Styles",
+            "type": "light",
+            "tokenColors": [
+                { "scope": "comment", "settings": { "foreground": "#008000", "fontStyle": "italic bold" } },
+                { "scope": ["comment.line", "keyword"], "settings": { "fontStyle": "" } },
+                { "scope": "string", "settings": { "fontStyle": "underline strikethrough" } },
+            ],
+        }"##;
+        let theme = load_vscode(jsonc.as_bytes()).unwrap();
+        assert_eq!(theme.background.to_hex(), "#ffffff");
+
+        // comment.line clears the inherited italic/bold but keeps the less specific foreground.
+        let comment = theme.style_for(TokenKind::Comment);
+        assert_eq!((comment.bold, comment.italic), (Some(false), Some(false)));
+        assert_eq!(hex(&theme, TokenKind::Comment), "#008000");
+        let string = theme.style_for(TokenKind::String);
+        assert_eq!(
+            (string.underline, string.strikethrough, string.bold),
+            (Some(true), Some(true), Some(false))
+        );
+    }
+
+    #[test]
+    fn semantic_colors_apply_only_when_enabled() {
+        let jsonc = r##"{
+            "semanticHighlighting": true,
+            "tokenColors": [{ "scope": "entity.name.function", "settings": { "foreground": "#111111" } }],
+            "semanticTokenColors": {
+                "function": "#222222",
+                "function.defaultLibrary": { "foreground": "#333333", "bold": true },
+                "*.readonly": { "italic": true },
+                "variable:go": "#444444",
+            },
+        }"##;
+        let theme = load_vscode(jsonc.as_bytes()).unwrap();
+        assert_eq!(hex(&theme, TokenKind::NameFunction), "#222222");
+        assert_eq!(hex(&theme, TokenKind::NameBuiltin), "#333333");
+        assert_eq!(theme.style_for(TokenKind::NameBuiltin).bold, Some(true));
+        assert_eq!(theme.style_for(TokenKind::NameConstant).italic, Some(true));
+        assert_eq!(theme.get(TokenKind::NameVariable), None);
+
+        let disabled = load_vscode(jsonc.replace("true,", "false,").as_bytes()).unwrap();
+        assert_eq!(hex(&disabled, TokenKind::NameFunction), "#111111");
+    }
+
+    #[test]
+    fn bracket_colors_come_from_the_editor_colors() {
+        let jsonc = r##"{
+            "type": "light",
+            "colors": {
+                "editorBracketHighlight.foreground2": "#123456",
+                "editorBracketHighlight.unexpectedBracket.foreground": "#ff0000",
+            },
+        }"##;
+        let mut theme = load_vscode(jsonc.as_bytes()).unwrap();
+        let rainbow = RainbowBrackets::new();
+        assert!(theme.style(rainbow.depth_kind(1)).is_empty());
+        rainbow.default_colors(&mut theme);
+        assert_eq!(theme.color_for(rainbow.depth_kind(1)), LIGHT_BRACKETS[0]);
+        assert_eq!(hex(&theme, rainbow.depth_kind(2)), "#123456");
+        assert_eq!(theme.color_for(rainbow.depth_kind(6)), LIGHT_BRACKETS[2]);
+        let mismatch = theme.style_for(RainbowBrackets::mismatch_kind());
+        assert_eq!(
+            (mismatch.foreground, mismatch.underline),
+            (Some(Srgb8::new(255, 0, 0)), Some(true))
+        );
+    }
+
+    #[test]
+    fn kind_names_style_custom_kinds() {
+        let jsonc = r##"{
+            "tokenColors": [{ "scope": "constant.numeric", "settings": { "foreground": "#111111" } }],
+            "semanticTokenColors": { "VscodeUnitLiteral": { "foreground": "#222222", "italic": true } },
+        }"##;
+        let theme = load_vscode(jsonc.as_bytes()).unwrap();
+        let unit = TokenKind::register("VscodeUnitLiteral", TokenKind::Number).unwrap();
+        let bare = TokenKind::register("VscodeBareLiteral", TokenKind::Number).unwrap();
+        assert_eq!(hex(&theme, unit), "#222222");
+        assert_eq!(theme.style_for(unit).italic, Some(true));
+        assert_eq!(hex(&theme, bare), "#111111");
+    }
+
+    #[test]
+    fn invalid_json_is_a_parse_error() {
+        assert!(matches!(load_vscode("{ nope".as_bytes()), Err(ThemeError::Parse(_))));
+    }
+}
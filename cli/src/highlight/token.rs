@@ -0,0 +1,738 @@
+//! Token types shared by lexers, themes, and formatters.
+//!
+//! Tokens reference the source by byte offsets so concatenating every token's text reproduces the input exactly.
+
+use serde::{Deserialize, Deserializer, Serialize, Serializer, de};
+use std::fmt;
+use std::sync::{RwLock, RwLockReadGuard};
+
+/// Semantic category assigned to a span of source text.
+///
+/// Categories are deliberately coarse (in the spirit of Pygments token types) so themes only need a handful of entries to
+/// cover every language. Lexers that need finer categories register [TokenKind::Custom] kinds with
+/// [TokenKind::register].
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, PartialOrd, Ord)]
+#[repr(u32)]
+pub enum TokenKind {
+    Text,
+    Whitespace,
+    Error,
+    Keyword,
+    KeywordConstant,
+    KeywordDeclaration,
+    KeywordType,
+    Name,
+    NameBuiltin,
+    NameFunction,
+    NameClass,
+    NameTag,
+    NameAttribute,
+    NameVariable,
+    NameConstant,
+    NameLabel,
+    NameMacro,
+    String,
+    StringEscape,
+    StringRegex,
+    StringBacktick,
+    Number,
+    NumberDate,
+    Operator,
+    Punctuation,
+    PunctuationFence,
+    Comment,
+    CommentPreproc,
+    CommentDoc,
+    GenericHeading,
+    GenericSubheading,
+    GenericEmph,
+    GenericStrong,
+    GenericInserted,
+    GenericInsertedChange,
+    GenericDeleted,
+    GenericDeletedChange,
+    /// A kind added at run time by [TokenKind::register], styled like its parent unless a theme names it.
+    Custom(CustomKind),
+}
+
+/// Handle to a registered [TokenKind::Custom] kind; registration order decides how handles compare.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, PartialOrd, Ord)]
+pub struct CustomKind(u32);
+
+/// Errors raised by [TokenKind::register].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum RegisterError {
+    /// Names must start with an ASCII uppercase letter followed by ASCII letters and digits, like the built-in names.
+    InvalidName(String),
+    /// The name is taken by a built-in kind.
+    Builtin(TokenKind),
+    /// `name` is already registered with a different parent, `parent`.
+    Conflict { name: String, parent: TokenKind },
+    /// `name` differs only in case from the registered kind `existing`, so the two would share a CSS class.
+    CaseConflict { name: String, existing: TokenKind },
+}
+
+impl fmt::Display for RegisterError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            RegisterError::InvalidName(name) => {
+                write!(f, "failed to register token type: {name:?} is not a valid name")
+            }
+            RegisterError::Builtin(kind) => write!(f, "failed to register token type: {kind} is a built-in type"),
+            RegisterError::Conflict { name, parent } => {
+                write!(
+                    f,
+                    "failed to register token type: {name:?} is already registered under {parent}"
+                )
+            }
+            RegisterError::CaseConflict { name, existing } => {
+                write!(
+                    f,
+                    "failed to register token type: {name:?} differs only in case from {existing}"
+                )
+            }
+        }
+    }
+}
+
+impl std::error::Error for RegisterError {}
+
+struct Registered {
+    name: &'static str,
+    class: &'static str,
+    parent: TokenKind,
+}
+
+/// Registered kinds, indexed by [CustomKind]. Entries are never removed, which lets names be handed out as `'static`.
+static REGISTERED: RwLock<Vec<Registered>> = RwLock::new(Vec::new());
+
+fn registered() -> RwLockReadGuard<'static, Vec<Registered>> {
+    REGISTERED.read().unwrap_or_else(|err| err.into_inner())
+}
+
+/// Scope prefixes (TextMate/Sublime naming) mapped to token kinds, most specific first.
+const SCOPE_KINDS: &[(&str, TokenKind)] = &[
+    ("comment.line.documentation", TokenKind::CommentDoc),
+    ("comment.block.documentation", TokenKind::CommentDoc),
+    ("comment", TokenKind::Comment),
+    ("markup.heading", TokenKind::GenericHeading),
+    ("meta.diff.header", TokenKind::GenericHeading),
+    ("meta.diff.range", TokenKind::GenericSubheading),
+    ("markup.inserted.change", TokenKind::GenericInsertedChange),
+    ("markup.inserted", TokenKind::GenericInserted),
+    ("markup.deleted.change", TokenKind::GenericDeletedChange),
+    ("markup.deleted", TokenKind::GenericDeleted),
+    ("markup.italic", TokenKind::GenericEmph),
+    ("markup.bold", TokenKind::GenericStrong),
+    ("markup.raw.inline", TokenKind::StringBacktick),
+    ("markup.inline.raw", TokenKind::StringBacktick),
+    ("meta.preprocessor", TokenKind::CommentPreproc),
+    ("string.regexp", TokenKind::StringRegex),
+    ("constant.character.escape", TokenKind::StringEscape),
+    ("string", TokenKind::String),
+    ("constant.numeric.date", TokenKind::NumberDate),
+    ("constant.numeric", TokenKind::Number),
+    ("constant.language", TokenKind::KeywordConstant),
+    ("constant.character", TokenKind::String),
+    ("constant", TokenKind::NameConstant),
+    ("keyword.operator", TokenKind::Operator),
+    ("keyword", TokenKind::Keyword),
+    ("storage.type", TokenKind::KeywordType),
+    ("storage", TokenKind::KeywordDeclaration),
+    ("entity.name.function", TokenKind::NameFunction),
+    ("support.function", TokenKind::NameBuiltin),
+    ("variable.function", TokenKind::NameFunction),
+    ("entity.name.tag", TokenKind::NameTag),
+    ("entity.other.attribute-name", TokenKind::NameAttribute),
+    ("entity.name.label", TokenKind::NameLabel),
+    ("entity.name.macro", TokenKind::NameMacro),
+    ("entity.name", TokenKind::NameClass),
+    ("support.type", TokenKind::NameClass),
+    ("support.class", TokenKind::NameClass),
+    ("support.macro", TokenKind::NameMacro),
+    ("support", TokenKind::NameBuiltin),
+    ("variable.language", TokenKind::NameBuiltin),
+    ("variable", TokenKind::NameVariable),
+    ("entity", TokenKind::Name),
+    ("invalid", TokenKind::Error),
+    ("punctuation.definition.raw.code-fence", TokenKind::PunctuationFence),
+    ("punctuation", TokenKind::Punctuation),
+];
+
+impl TokenKind {
+    /// Every built-in token kind in declaration order; [TokenKind::registered] lists the custom ones.
+    pub const ALL: [TokenKind; 37] = [
+        TokenKind::Text,
+        TokenKind::Whitespace,
+        TokenKind::Error,
+        TokenKind::Keyword,
+        TokenKind::KeywordConstant,
+        TokenKind::KeywordDeclaration,
+        TokenKind::KeywordType,
+        TokenKind::Name,
+        TokenKind::NameBuiltin,
+        TokenKind::NameFunction,
+        TokenKind::NameClass,
+        TokenKind::NameTag,
+        TokenKind::NameAttribute,
+        TokenKind::NameVariable,
+        TokenKind::NameConstant,
+        TokenKind::NameLabel,
+        TokenKind::NameMacro,
+        TokenKind::String,
+        TokenKind::StringEscape,
+        TokenKind::StringRegex,
+        TokenKind::StringBacktick,
+        TokenKind::Number,
+        TokenKind::NumberDate,
+        TokenKind::Operator,
+        TokenKind::Punctuation,
+        TokenKind::PunctuationFence,
+        TokenKind::Comment,
+        TokenKind::CommentPreproc,
+        TokenKind::CommentDoc,
+        TokenKind::GenericHeading,
+        TokenKind::GenericSubheading,
+        TokenKind::GenericEmph,
+        TokenKind::GenericStrong,
+        TokenKind::GenericInserted,
+        TokenKind::GenericInsertedChange,
+        TokenKind::GenericDeleted,
+        TokenKind::GenericDeletedChange,
+    ];
+
+    /// Adds a custom kind named `name` that inherits its style from `parent` wherever a theme doesn't style it by
+    /// name (see [super::Theme::set_style_by_name]).
+    ///
+    /// Registering the same name with the same parent again returns the same kind, so lexers can register their kinds
+    /// whenever they are constructed; a different parent, the name of a built-in kind, or a registered name in other
+    /// case (the CSS class is the lowercased name) is a conflict. Kinds are
+    /// shared by the whole process and registration is safe from any thread.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use colorizer::highlight::TokenKind;
+    ///
+    /// let cell = TokenKind::register("CellReference", TokenKind::NameVariable).unwrap();
+    /// assert_eq!(cell.name(), "CellReference");
+    /// assert_eq!(cell.parent(), Some(TokenKind::NameVariable));
+    /// assert_eq!(TokenKind::from_name("CellReference"), Some(cell));
+    /// assert_eq!(TokenKind::register("CellReference", TokenKind::NameVariable), Ok(cell));
+    /// assert!(TokenKind::register("CellReference", TokenKind::String).is_err());
+    /// ```
+    pub fn register(name: &str, parent: TokenKind) -> Result<TokenKind, RegisterError> {
+        let mut chars = name.chars();
+        let valid = chars.next().is_some_and(|c| c.is_ascii_uppercase()) && chars.all(|c| c.is_ascii_alphanumeric());
+        if !valid {
+            return Err(RegisterError::InvalidName(name.to_string()));
+        }
+        if let Some(kind) = TokenKind::ALL.into_iter().find(|kind| kind.name() == name) {
+            return Err(RegisterError::Builtin(kind));
+        }
+
+        let mut kinds = REGISTERED.write().unwrap_or_else(|err| err.into_inner());
+        if let Some(index) = kinds.iter().position(|kind| kind.name == name) {
+            let registered = kinds[index].parent;
+            return if registered == parent {
+                Ok(TokenKind::Custom(CustomKind(index as u32)))
+            } else {
+                Err(RegisterError::Conflict { name: name.to_string(), parent: registered })
+            };
+        }
+        if let Some(index) = kinds.iter().position(|kind| kind.name.eq_ignore_ascii_case(name)) {
+            let existing = TokenKind::Custom(CustomKind(index as u32));
+            return Err(RegisterError::CaseConflict { name: name.to_string(), existing });
+        }
+        let id = u32::try_from(kinds.len()).expect("too many registered token kinds");
+        kinds.push(Registered {
+            name: Box::leak(name.into()),
+            class: Box::leak(format!("x{}", name.to_ascii_lowercase()).into()),
+            parent,
+        });
+        Ok(TokenKind::Custom(CustomKind(id)))
+    }
+
+    /// Every registered custom kind, in registration order.
+    pub fn registered() -> Vec<TokenKind> {
+        (0..registered().len())
+            .map(|id| TokenKind::Custom(CustomKind(id as u32)))
+            .collect()
+    }
+
+    /// Whether the kind was added with [TokenKind::register].
+    pub fn is_custom(&self) -> bool {
+        matches!(self, TokenKind::Custom(_))
+    }
+
+    /// The nearest built-in ancestor: the kind itself unless it is custom, in which case its parent chain is followed.
+    pub fn builtin(&self) -> TokenKind {
+        let mut kind = *self;
+        while let TokenKind::Custom(custom) = kind {
+            kind = registered()[custom.0 as usize].parent;
+        }
+        kind
+    }
+
+    /// Dense index for per-kind tables: the position in [TokenKind::ALL] for built-in kinds, then registration order
+    /// for custom ones.
+    pub(crate) fn slot(&self) -> usize {
+        match self {
+            TokenKind::Custom(custom) => TokenKind::ALL.len() + custom.0 as usize,
+            // SAFETY: `#[repr(u32)]` lays the enum out with its `u32` discriminant first, and the built-in variants
+            // are declared in the order of `ALL`, so the discriminant is the position.
+            _ => unsafe { *(self as *const TokenKind as *const u32) as usize },
+        }
+    }
+
+    /// Looks up a kind by its [TokenKind::name], built-in or registered.
+    pub fn from_name(name: &str) -> Option<TokenKind> {
+        TokenKind::ALL.into_iter().find(|kind| kind.name() == name).or_else(|| {
+            registered()
+                .iter()
+                .position(|kind| kind.name == name)
+                .map(|id| TokenKind::Custom(CustomKind(id as u32)))
+        })
+    }
+
+    /// Stable, human-readable name (e.g., "KeywordDeclaration"); custom kinds use their registered name.
+    pub fn name(&self) -> &'static str {
+        match self {
+            TokenKind::Custom(custom) => registered()[custom.0 as usize].name,
+            TokenKind::Text => "Text",
+            TokenKind::Whitespace => "Whitespace",
+            TokenKind::Error => "Error",
+            TokenKind::Keyword => "Keyword",
+            TokenKind::KeywordConstant => "KeywordConstant",
+            TokenKind::KeywordDeclaration => "KeywordDeclaration",
+            TokenKind::KeywordType => "KeywordType",
+            TokenKind::Name => "Name",
+            TokenKind::NameBuiltin => "NameBuiltin",
+            TokenKind::NameFunction => "NameFunction",
+            TokenKind::NameClass => "NameClass",
+            TokenKind::NameTag => "NameTag",
+            TokenKind::NameAttribute => "NameAttribute",
+            TokenKind::NameVariable => "NameVariable",
+            TokenKind::NameConstant => "NameConstant",
+            TokenKind::NameLabel => "NameLabel",
+            TokenKind::NameMacro => "NameMacro",
+            TokenKind::String => "String",
+            TokenKind::StringEscape => "StringEscape",
+            TokenKind::StringRegex => "StringRegex",
+            TokenKind::StringBacktick => "StringBacktick",
+            TokenKind::Number => "Number",
+            TokenKind::NumberDate => "NumberDate",
+            TokenKind::Operator => "Operator",
+            TokenKind::Punctuation => "Punctuation",
+            TokenKind::PunctuationFence => "PunctuationFence",
+            TokenKind::Comment => "Comment",
+            TokenKind::CommentPreproc => "CommentPreproc",
+            TokenKind::CommentDoc => "CommentDoc",
+            TokenKind::GenericHeading => "GenericHeading",
+            TokenKind::GenericSubheading => "GenericSubheading",
+            TokenKind::GenericEmph => "GenericEmph",
+            TokenKind::GenericStrong => "GenericStrong",
+            TokenKind::GenericInserted => "GenericInserted",
+            TokenKind::GenericInsertedChange => "GenericInsertedChange",
+            TokenKind::GenericDeleted => "GenericDeleted",
+            TokenKind::GenericDeletedChange => "GenericDeletedChange",
+        }
+    }
+
+    /// Short CSS class suffix used by the HTML formatter and stylesheet export (e.g., "kw"); custom kinds use their
+    /// lowercased name after an `x` (e.g., "xcellreference").
+    pub fn class(&self) -> &'static str {
+        match self {
+            TokenKind::Custom(custom) => registered()[custom.0 as usize].class,
+            TokenKind::Text => "tx",
+            TokenKind::Whitespace => "ws",
+            TokenKind::Error => "er",
+            TokenKind::Keyword => "kw",
+            TokenKind::KeywordConstant => "kc",
+            TokenKind::KeywordDeclaration => "kd",
+            TokenKind::KeywordType => "kt",
+            TokenKind::Name => "nm",
+            TokenKind::NameBuiltin => "nb",
+            TokenKind::NameFunction => "nf",
+            TokenKind::NameClass => "nc",
+            TokenKind::NameTag => "nt",
+            TokenKind::NameAttribute => "na",
+            TokenKind::NameVariable => "nv",
+            TokenKind::NameConstant => "no",
+            TokenKind::NameLabel => "nl",
+            TokenKind::NameMacro => "nx",
+            TokenKind::String => "st",
+            TokenKind::StringEscape => "se",
+            TokenKind::StringRegex => "sr",
+            TokenKind::StringBacktick => "sb",
+            TokenKind::Number => "nu",
+            TokenKind::NumberDate => "nd",
+            TokenKind::Operator => "op",
+            TokenKind::Punctuation => "pu",
+            TokenKind::PunctuationFence => "pf",
+            TokenKind::Comment => "cm",
+            TokenKind::CommentPreproc => "cp",
+            TokenKind::CommentDoc => "cd",
+            TokenKind::GenericHeading => "gh",
+            TokenKind::GenericSubheading => "gu",
+            TokenKind::GenericEmph => "ge",
+            TokenKind::GenericStrong => "gs",
+            TokenKind::GenericInserted => "gi",
+            TokenKind::GenericInsertedChange => "gic",
+            TokenKind::GenericDeleted => "gd",
+            TokenKind::GenericDeletedChange => "gdc",
+        }
+    }
+
+    /// Kind whose style this kind inherits when the theme leaves an attribute unset (e.g., `KeywordDeclaration`
+    /// inherits from `Keyword`); top-level kinds inherit from the theme's base style. Custom kinds always have the
+    /// parent they were registered with.
+    pub fn parent(&self) -> Option<TokenKind> {
+        match self {
+            TokenKind::Custom(custom) => Some(registered()[custom.0 as usize].parent),
+            TokenKind::KeywordConstant | TokenKind::KeywordDeclaration | TokenKind::KeywordType => {
+                Some(TokenKind::Keyword)
+            }
+            TokenKind::NameBuiltin
+            | TokenKind::NameFunction
+            | TokenKind::NameClass
+            | TokenKind::NameTag
+            | TokenKind::NameAttribute
+            | TokenKind::NameVariable
+            | TokenKind::NameConstant
+            | TokenKind::NameLabel
+            | TokenKind::NameMacro => Some(TokenKind::Name),
+            TokenKind::StringEscape | TokenKind::StringRegex | TokenKind::StringBacktick => Some(TokenKind::String),
+            TokenKind::NumberDate => Some(TokenKind::Number),
+            TokenKind::PunctuationFence => Some(TokenKind::Punctuation),
+            TokenKind::CommentPreproc | TokenKind::CommentDoc => Some(TokenKind::Comment),
+            TokenKind::GenericInsertedChange => Some(TokenKind::GenericInserted),
+            TokenKind::GenericDeletedChange => Some(TokenKind::GenericDeleted),
+            _ => None,
+        }
+    }
+
+    /// Representative TextMate scope for the kind, used to resolve theme rules written against scope selectors.
+    ///
+    /// [TokenKind::Text] and [TokenKind::Whitespace] have none; they always use the theme foreground. Neither do
+    /// custom kinds, which theme loaders style by name instead.
+    pub fn scope(&self) -> Option<&'static str> {
+        let scope = match self {
+            TokenKind::Text | TokenKind::Whitespace | TokenKind::Custom(_) => return None,
+            TokenKind::Error => "invalid.illegal",
+            TokenKind::Keyword => "keyword.control",
+            TokenKind::KeywordConstant => "constant.language",
+            TokenKind::KeywordDeclaration => "storage.modifier",
+            TokenKind::KeywordType => "storage.type",
+            TokenKind::Name => "entity.other",
+            TokenKind::NameBuiltin => "support.function",
+            TokenKind::NameFunction => "entity.name.function",
+            TokenKind::NameClass => "entity.name.class",
+            TokenKind::NameTag => "entity.name.tag",
+            TokenKind::NameAttribute => "entity.other.attribute-name",
+            TokenKind::NameVariable => "variable.other",
+            TokenKind::NameConstant => "constant.other",
+            TokenKind::NameLabel => "entity.name.label",
+            TokenKind::NameMacro => "entity.name.macro",
+            TokenKind::String => "string.quoted",
+            TokenKind::StringEscape => "constant.character.escape",
+            TokenKind::StringRegex => "string.regexp",
+            TokenKind::StringBacktick => "markup.inline.raw",
+            TokenKind::Number => "constant.numeric",
+            TokenKind::NumberDate => "constant.numeric.date",
+            TokenKind::Operator => "keyword.operator",
+            TokenKind::Punctuation => "punctuation.separator",
+            TokenKind::PunctuationFence => "punctuation.definition.raw.code-fence",
+            TokenKind::Comment => "comment.line",
+            TokenKind::CommentPreproc => "meta.preprocessor",
+            TokenKind::CommentDoc => "comment.block.documentation",
+            TokenKind::GenericHeading => "markup.heading",
+            TokenKind::GenericSubheading => "meta.diff.range",
+            TokenKind::GenericEmph => "markup.italic",
+            TokenKind::GenericStrong => "markup.bold",
+            TokenKind::GenericInserted => "markup.inserted",
+            TokenKind::GenericInsertedChange => "markup.inserted.change",
+            TokenKind::GenericDeleted => "markup.deleted",
+            TokenKind::GenericDeletedChange => "markup.deleted.change",
+        };
+        Some(scope)
+    }
+
+    /// Classifies a single scope name (e.g., "keyword.control.go"), returning `None` for unrecognized scopes.
+    pub fn from_scope(scope: &str) -> Option<TokenKind> {
+        SCOPE_KINDS
+            .iter()
+            .find(|(prefix, _)| scope_matches(scope, prefix))
+            .map(|&(_, kind)| kind)
+    }
+
+    /// Classifies a scope stack ordered from outermost to innermost.
+    ///
+    /// The innermost recognized scope wins, except that `punctuation.definition.*` scopes defer to their enclosing
+    /// scope so string quotes and comment markers are styled like the string or comment they delimit.
+    pub fn from_scopes<'a, I>(scopes: I) -> TokenKind
+    where
+        I: DoubleEndedIterator<Item = &'a str>,
+    {
+        let mut delimiter = false;
+        for scope in scopes.rev() {
+            if scope_matches(scope, "punctuation.definition") {
+                delimiter = true;
+                continue;
+            }
+            if let Some(kind) = TokenKind::from_scope(scope) {
+                return kind;
+            }
+        }
+        if delimiter { TokenKind::Punctuation } else { TokenKind::Text }
+    }
+}
+
+impl fmt::Display for TokenKind {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(self.name())
+    }
+}
+
+/// Kinds serialize as their [TokenKind::name], the form rule tables and token streams use.
+impl Serialize for TokenKind {
+    fn serialize<S: Serializer>(&self, serializer: S) -> Result<S::Ok, S::Error> {
+        serializer.serialize_str(self.name())
+    }
+}
+
+impl<'de> Deserialize<'de> for TokenKind {
+    fn deserialize<D: Deserializer<'de>>(deserializer: D) -> Result<Self, D::Error> {
+        let name = String::deserialize(deserializer)?;
+        TokenKind::from_name(&name).ok_or_else(|| de::Error::custom(format!("unknown token type {name:?}")))
+    }
+}
+
+fn scope_matches(scope: &str, prefix: &str) -> bool {
+    scope
+        .strip_prefix(prefix)
+        .is_some_and(|rest| rest.is_empty() || rest.starts_with('.'))
+}
+
+/// A classified span of source text, addressed by byte offsets into the original input.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Token {
+    pub kind: TokenKind,
+    pub start: usize,
+    pub end: usize,
+}
+
+impl Token {
+    /// Creates a token covering `start..end`.
+    pub const fn new(kind: TokenKind, start: usize, end: usize) -> Self {
+        Self { kind, start, end }
+    }
+
+    /// Returns the token's text within `src`.
+    pub fn text<'a>(&self, src: &'a str) -> &'a str {
+        &src[self.start..self.end]
+    }
+
+    /// Length of the token in bytes.
+    pub fn len(&self) -> usize {
+        self.end - self.start
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.start == self.end
+    }
+}
+
+/// Appends a token, merging it into the previous token when both are contiguous and share a kind.
+///
+/// Empty spans are dropped so lexers can push unconditionally.
+pub fn push_token(tokens: &mut Vec<Token>, kind: TokenKind, start: usize, end: usize) {
+    if start >= end {
+        return;
+    }
+    if let Some(last) = tokens.last_mut()
+        && last.kind == kind
+        && last.end == start
+    {
+        last.end = end;
+        return;
+    }
+    tokens.push(Token::new(kind, start, end));
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn representative_scopes_classify_back_to_their_kind() {
+        for kind in TokenKind::ALL {
+            if let Some(scope) = kind.scope() {
+                assert_eq!(TokenKind::from_scope(scope), Some(kind), "{scope}");
+            }
+        }
+    }
+
+    #[test]
+    fn scope_prefixes_match_on_segment_boundaries() {
+        assert_eq!(TokenKind::from_scope("keyword.control.go"), Some(TokenKind::Keyword));
+        assert_eq!(
+            TokenKind::from_scope("keyword.operator.assignment"),
+            Some(TokenKind::Operator)
+        );
+        assert_eq!(TokenKind::from_scope("keywords"), None);
+        assert_eq!(TokenKind::from_scope("source.go"), None);
+    }
+
+    #[test]
+    fn innermost_scope_wins() {
+        let scopes = ["source.rust", "meta.function.rust", "entity.name.function.rust"];
+        assert_eq!(TokenKind::from_scopes(scopes.into_iter()), TokenKind::NameFunction);
+    }
+
+    #[test]
+    fn string_delimiters_take_the_string_kind() {
+        let scopes = [
+            "source.go",
+            "string.quoted.double.go",
+            "punctuation.definition.string.begin.go",
+        ];
+        assert_eq!(TokenKind::from_scopes(scopes.into_iter()), TokenKind::String);
+
+        let bare = ["source.go", "punctuation.definition.generic.begin.go"];
+        assert_eq!(TokenKind::from_scopes(bare.into_iter()), TokenKind::Punctuation);
+    }
+
+    #[test]
+    fn unclassified_scopes_fall_back_to_text() {
+        let scopes = ["source.go", "meta.block.go"];
+        assert_eq!(TokenKind::from_scopes(scopes.into_iter()), TokenKind::Text);
+    }
+
+    #[test]
+    fn parents_are_top_level_kinds() {
+        assert_eq!(TokenKind::KeywordDeclaration.parent(), Some(TokenKind::Keyword));
+        assert_eq!(TokenKind::Keyword.parent(), None);
+        for kind in TokenKind::ALL {
+            if let Some(parent) = kind.parent() {
+                assert_eq!(parent.parent(), None, "{kind:?}");
+                assert!(parent < kind, "{kind:?} must follow its parent");
+            }
+        }
+    }
+
+    #[test]
+    fn names_and_classes_are_unique() {
+        let mut names: Vec<_> = TokenKind::ALL.iter().map(TokenKind::name).collect();
+        let mut classes: Vec<_> = TokenKind::ALL.iter().map(TokenKind::class).collect();
+        names.sort_unstable();
+        names.dedup();
+        classes.sort_unstable();
+        classes.dedup();
+        assert_eq!(names.len(), TokenKind::ALL.len());
+        assert_eq!(classes.len(), TokenKind::ALL.len());
+    }
+
+    #[test]
+    fn names_round_trip() {
+        for kind in TokenKind::ALL {
+            assert_eq!(TokenKind::from_name(kind.name()), Some(kind));
+        }
+        assert_eq!(TokenKind::from_name("keyword"), None);
+
+        let json = serde_json::to_string(&TokenKind::NameBuiltin).unwrap();
+        assert_eq!(json, "\"NameBuiltin\"");
+        assert_eq!(
+            serde_json::from_str::<TokenKind>(&json).unwrap(),
+            TokenKind::NameBuiltin
+        );
+        let err = serde_json::from_str::<TokenKind>("\"Keywrd\"").unwrap_err();
+        assert!(err.to_string().contains("unknown token type \"Keywrd\""), "{err}");
+    }
+
+    #[test]
+    fn registration_is_idempotent_and_rejects_conflicts() {
+        let cell = TokenKind::register("TokenCellReference", TokenKind::NameVariable).unwrap();
+        assert!(cell.is_custom());
+        assert_eq!(cell.to_string(), "TokenCellReference");
+        assert_eq!(cell.class(), "xtokencellreference");
+        assert_eq!(cell.builtin(), TokenKind::NameVariable);
+        assert_eq!(cell.scope(), None);
+        assert!(TokenKind::registered().contains(&cell));
+        assert_eq!(
+            TokenKind::register("TokenCellReference", TokenKind::NameVariable),
+            Ok(cell)
+        );
+        assert_eq!(
+            TokenKind::register("TokenCellReference", TokenKind::Name),
+            Err(RegisterError::Conflict { name: "TokenCellReference".to_string(), parent: TokenKind::NameVariable })
+        );
+        assert_eq!(
+            TokenKind::register("String", TokenKind::Text),
+            Err(RegisterError::Builtin(TokenKind::String))
+        );
+        let err = TokenKind::register("TokenCELLReference", TokenKind::NameVariable).unwrap_err();
+        assert_eq!(
+            err,
+            RegisterError::CaseConflict { name: "TokenCELLReference".to_string(), existing: cell }
+        );
+        assert_eq!(
+            err.to_string(),
+            "failed to register token type: \"TokenCELLReference\" differs only in case from TokenCellReference"
+        );
+        for name in ["", "cellReference", "Cell-Reference", "Cellé"] {
+            assert!(
+                matches!(
+                    TokenKind::register(name, TokenKind::Text),
+                    Err(RegisterError::InvalidName(_))
+                ),
+                "{name}"
+            );
+        }
+
+        let json = serde_json::to_string(&cell).unwrap();
+        assert_eq!(json, "\"TokenCellReference\"");
+        assert_eq!(serde_json::from_str::<TokenKind>(&json).unwrap(), cell);
+    }
+
+    #[test]
+    fn slots_follow_all_then_registration_order() {
+        for (index, kind) in TokenKind::ALL.iter().enumerate() {
+            assert_eq!(kind.slot(), index, "{kind}");
+        }
+        let kind = TokenKind::register("TokenSlotProbe", TokenKind::Text).unwrap();
+        let TokenKind::Custom(custom) = kind else { unreachable!() };
+        assert_eq!(kind.slot(), TokenKind::ALL.len() + custom.0 as usize);
+    }
+
+    #[test]
+    fn concurrent_registrations_agree() {
+        let kinds: Vec<TokenKind> = std::thread::scope(|scope| {
+            let handles: Vec<_> = (0..8)
+                .map(|_| scope.spawn(|| TokenKind::register("TokenUnitLiteral", TokenKind::Number).unwrap()))
+                .collect();
+            handles.into_iter().map(|handle| handle.join().unwrap()).collect()
+        });
+        assert!(kinds.iter().all(|&kind| kind == kinds[0]));
+        assert_eq!(
+            TokenKind::registered()
+                .iter()
+                .filter(|kind| kind.name() == "TokenUnitLiteral")
+                .count(),
+            1
+        );
+    }
+
+    #[test]
+    fn push_token_merges_contiguous_runs() {
+        let mut tokens = Vec::new();
+        push_token(&mut tokens, TokenKind::Text, 0, 2);
+        push_token(&mut tokens, TokenKind::Text, 2, 4);
+        push_token(&mut tokens, TokenKind::Keyword, 4, 4);
+        push_token(&mut tokens, TokenKind::Keyword, 4, 6);
+        assert_eq!(
+            tokens,
+            vec![Token::new(TokenKind::Text, 0, 4), Token::new(TokenKind::Keyword, 4, 6)]
+        );
+    }
+}
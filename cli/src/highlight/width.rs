@@ -0,0 +1,228 @@
+//! Terminal cell widths of text, for laying out lines that must fit a number of columns.
+
+/// East Asian Wide and Fullwidth ranges, plus the emoji terminals draw two cells wide.
+const WIDE: &[(u32, u32)] = &[
+    (0x1100, 0x115f),
+    (0x231a, 0x231b),
+    (0x2329, 0x232a),
+    (0x23e9, 0x23ec),
+    (0x23f0, 0x23f0),
+    (0x23f3, 0x23f3),
+    (0x25fd, 0x25fe),
+    (0x2614, 0x2615),
+    (0x2648, 0x2653),
+    (0x267f, 0x267f),
+    (0x2693, 0x2693),
+    (0x26a1, 0x26a1),
+    (0x26aa, 0x26ab),
+    (0x26bd, 0x26be),
+    (0x26c4, 0x26c5),
+    (0x26ce, 0x26ce),
+    (0x26d4, 0x26d4),
+    (0x26ea, 0x26ea),
+    (0x26f2, 0x26f3),
+    (0x26f5, 0x26f5),
+    (0x26fa, 0x26fa),
+    (0x26fd, 0x26fd),
+    (0x2705, 0x2705),
+    (0x270a, 0x270b),
+    (0x2728, 0x2728),
+    (0x274c, 0x274c),
+    (0x274e, 0x274e),
+    (0x2753, 0x2755),
+    (0x2757, 0x2757),
+    (0x2795, 0x2797),
+    (0x27b0, 0x27b0),
+    (0x27bf, 0x27bf),
+    (0x2b1b, 0x2b1c),
+    (0x2b50, 0x2b50),
+    (0x2b55, 0x2b55),
+    (0x2e80, 0x303e),
+    (0x3041, 0x33ff),
+    (0x3400, 0x4dbf),
+    (0x4e00, 0x9fff),
+    (0xa000, 0xa4cf),
+    (0xa960, 0xa97f),
+    (0xac00, 0xd7a3),
+    (0xf900, 0xfaff),
+    (0xfe10, 0xfe19),
+    (0xfe30, 0xfe6f),
+    (0xff00, 0xff60),
+    (0xffe0, 0xffe6),
+    (0x16fe0, 0x16fe4),
+    (0x17000, 0x18cff),
+    (0x1b000, 0x1b2ff),
+    (0x1f004, 0x1f004),
+    (0x1f0cf, 0x1f0cf),
+    (0x1f18e, 0x1f18e),
+    (0x1f191, 0x1f19a),
+    (0x1f200, 0x1f202),
+    (0x1f210, 0x1f23b),
+    (0x1f240, 0x1f248),
+    (0x1f250, 0x1f251),
+    (0x1f260, 0x1f265),
+    (0x1f300, 0x1f320),
+    (0x1f32d, 0x1f335),
+    (0x1f337, 0x1f37c),
+    (0x1f37e, 0x1f393),
+    (0x1f3a0, 0x1f3ca),
+    (0x1f3cf, 0x1f3d3),
+    (0x1f3e0, 0x1f3f0),
+    (0x1f3f4, 0x1f3f4),
+    (0x1f3f8, 0x1f43e),
+    (0x1f440, 0x1f440),
+    (0x1f442, 0x1f4fc),
+    (0x1f4ff, 0x1f53d),
+    (0x1f54b, 0x1f54e),
+    (0x1f550, 0x1f567),
+    (0x1f57a, 0x1f57a),
+    (0x1f595, 0x1f596),
+    (0x1f5a4, 0x1f5a4),
+    (0x1f5fb, 0x1f64f),
+    (0x1f680, 0x1f6c5),
+    (0x1f6cc, 0x1f6cc),
+    (0x1f6d0, 0x1f6d2),
+    (0x1f6d5, 0x1f6d7),
+    (0x1f6dc, 0x1f6df),
+    (0x1f6eb, 0x1f6ec),
+    (0x1f6f4, 0x1f6fc),
+    (0x1f7e0, 0x1f7eb),
+    (0x1f7f0, 0x1f7f0),
+    (0x1f90c, 0x1f93a),
+    (0x1f93c, 0x1f945),
+    (0x1f947, 0x1f9ff),
+    (0x1fa70, 0x1faff),
+    (0x20000, 0x2fffd),
+    (0x30000, 0x3fffd),
+];
+
+/// Combining marks, Hangul vowel and final jamo, and the invisible format characters, which take no cell of their
+/// own. The marks cover the common scripts rather than every one Unicode defines.
+const ZERO: &[(u32, u32)] = &[
+    (0x00ad, 0x00ad),
+    (0x0300, 0x036f),
+    (0x0483, 0x0489),
+    (0x0591, 0x05bd),
+    (0x05bf, 0x05bf),
+    (0x05c1, 0x05c2),
+    (0x05c4, 0x05c5),
+    (0x05c7, 0x05c7),
+    (0x0610, 0x061a),
+    (0x064b, 0x065f),
+    (0x0670, 0x0670),
+    (0x06d6, 0x06dc),
+    (0x06df, 0x06e4),
+    (0x06e7, 0x06e8),
+    (0x06ea, 0x06ed),
+    (0x0900, 0x0902),
+    (0x093a, 0x093a),
+    (0x093c, 0x093c),
+    (0x0941, 0x0948),
+    (0x094d, 0x094d),
+    (0x0951, 0x0957),
+    (0x0962, 0x0963),
+    (0x0e31, 0x0e31),
+    (0x0e34, 0x0e3a),
+    (0x0e47, 0x0e4e),
+    (0x1160, 0x11ff),
+    (0x1ab0, 0x1aff),
+    (0x1dc0, 0x1dff),
+    (0x200b, 0x200f),
+    (0x202a, 0x202e),
+    (0x2060, 0x2064),
+    (0x20d0, 0x20ff),
+    (0x302a, 0x302d),
+    (0x3099, 0x309a),
+    (0xd7b0, 0xd7ff),
+    (0xfe00, 0xfe0f),
+    (0xfe20, 0xfe2f),
+    (0xfeff, 0xfeff),
+    (0x1f3fb, 0x1f3ff),
+    (0xe0000, 0xe007f),
+    (0xe0100, 0xe01ef),
+];
+
+const ZWJ: char = '\u{200d}';
+
+fn contains(ranges: &[(u32, u32)], ch: char) -> bool {
+    let code = u32::from(ch);
+    ranges
+        .binary_search_by(|&(start, end)| {
+            if end < code {
+                std::cmp::Ordering::Less
+            } else if start > code {
+                std::cmp::Ordering::Greater
+            } else {
+                std::cmp::Ordering::Equal
+            }
+        })
+        .is_ok()
+}
+
+/// Returns the cells `ch` takes on its own: 2 for wide characters, 0 for combining marks and other characters drawn
+/// onto the one before them (control characters included), and 1 otherwise.
+fn char_width(ch: char) -> usize {
+    if ch < ' ' || ('\u{7f}'..'\u{a0}').contains(&ch) || ch == ZWJ {
+        0
+    } else if ch.is_ascii() {
+        1
+    } else if contains(ZERO, ch) {
+        0
+    } else if contains(WIDE, ch) {
+        2
+    } else {
+        1
+    }
+}
+
+/// Measures text a character at a time, counting the characters a zero-width joiner glues onto an emoji as part of
+/// it, the way terminals draw family and profession emoji as one glyph.
+#[derive(Debug, Clone, Copy, Default)]
+pub(crate) struct Widths {
+    joined: bool,
+}
+
+impl Widths {
+    /// Returns the cells `ch` adds after the characters measured so far.
+    pub(crate) fn next(&mut self, ch: char) -> usize {
+        let joined = std::mem::replace(&mut self.joined, ch == ZWJ);
+        if joined { 0 } else { char_width(ch) }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn str_width(text: &str) -> usize {
+        let mut widths = Widths::default();
+        text.chars().map(|ch| widths.next(ch)).sum()
+    }
+
+    #[test]
+    fn tables_are_sorted_and_disjoint() {
+        for table in [WIDE, ZERO] {
+            assert!(table.iter().all(|&(start, end)| start <= end));
+            assert!(table.windows(2).all(|pair| pair[0].1 < pair[1].0));
+        }
+    }
+
+    #[test]
+    fn east_asian_characters_take_two_cells() {
+        assert_eq!(str_width("// 注释"), 7);
+        assert_eq!(str_width("ｈｉ"), 4);
+        assert_eq!(str_width("한국어"), 6);
+        assert_eq!(str_width("カタカナ"), 8);
+        assert_eq!(str_width("ｶﾀｶﾅ"), 4, "halfwidth katakana stays narrow");
+    }
+
+    #[test]
+    fn combining_marks_and_joined_emoji_take_none_of_their_own() {
+        assert_eq!(str_width("e\u{301}"), 1);
+        assert_eq!(str_width("\u{200b}"), 0);
+        assert_eq!(str_width("👍🏽"), 2);
+        assert_eq!(str_width("👩\u{200d}💻"), 2);
+        assert_eq!(str_width("👨\u{200d}👩\u{200d}👧"), 2);
+        assert_eq!(str_width("a\tb"), 2, "tabs are measured by whoever knows the column");
+    }
+}
@@ -6,10 +6,15 @@ mod vimrc;
 
 pub mod base16_builder;
 pub mod colors;
+pub mod compositing;
 pub mod diffs;
+pub mod gradient;
+pub mod highlight;
 pub mod palette;
+pub mod parse;
 pub mod random;
 pub mod syntax;
+pub mod terminal;
 pub mod tinted_theming;
 pub mod wcag;
 
@@ -17,7 +22,7 @@ pub mod harmonies;
 pub use harmonies::{HarmonyKind, harmonies, normalize_saturation, set_lightness, shift_lightness};
 
 pub mod shades;
-pub use shades::{darken_hsl, desaturate_hsl, lighten_hsl, mix_rgb, shade, tint, tone};
+pub use shades::{darken_hsl, desaturate_hsl, lighten_hsl, mix_rgb, rotate_hue_hsl, saturate_hsl, shade, tint, tone};
 
 pub mod interpolation;
 pub use interpolation::{gradient_lab, gradient_lch, lerp_lab, lerp_lch, lerp_rgb};
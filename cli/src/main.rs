@@ -386,8 +386,7 @@ fn handle_scheme(action: SchemeAction) {
                                 }
                             };
 
-                            let syntax_set = syntax::load_syntax_set();
-                            if let Some(syntax_ref) = syntax::find_syntax_by_name(&syntax_set, &lang) {
+                            if let Some(syntax_ref) = syntax::find_syntax_by_name(syntax::syntax_set(), &lang) {
                                 if let Ok(file_handle) = File::open(&file_path) {
                                     let reader = BufReader::new(file_handle);
                                     let _ = syntax::highlight_code_to_terminal(
@@ -845,8 +844,7 @@ fn handle_preview(preview_type: PreviewType) {
                 return;
             };
 
-            let syntax_set = syntax::load_syntax_set();
-            let syntax = match syntax::find_syntax_by_name(&syntax_set, &language) {
+            let syntax = match syntax::find_syntax_by_name(syntax::syntax_set(), &language) {
                 Some(syn) => syn,
                 None => {
                     eprintln!("Unknown language: {language}");
@@ -1,8 +1,10 @@
 //! Palette generation helpers and visualization utilities.
 
 use crate::GoldenPalette;
-use crate::colors::{Hsl, Rgb, Srgb8};
+use crate::colors::{Hsl, Oklab, Oklch, Rgb, Srgb8, Srgba8};
+use crate::conversions::{fit_chroma, in_srgb_gamut};
 use crate::diffs::ensure_min_distance;
+use crate::gradient::Gradient;
 use crate::harmonies::{HarmonyKind, harmonies};
 use crate::shades::{darken_hsl, lighten_hsl};
 use crate::wcag::contrast_ratio;
@@ -71,6 +73,33 @@ pub fn golden_ratio_palette(
     enforce_min_delta_e(colors, min_delta_e)
 }
 
+/// Generates `count` colors with evenly spaced OKLch hues, starting at `base`, all sharing its lightness and chroma.
+///
+/// Because OKLab is perceptually uniform, neighboring entries look equally far apart and equally bright, unlike
+/// rotating hue in HSL, where yellow reads far lighter than blue. When some hue can't hold the base chroma inside
+/// sRGB, the whole palette's chroma is lowered until every entry fits; clipping channels instead would shift hues
+/// and break the even spacing.
+pub fn oklch_palette(base: Srgb8, count: usize) -> Vec<Srgb8> {
+    let base = Oklch::from(base);
+    let hues: Vec<f32> = (0..count).map(|i| base.h + 360.0 * i as f32 / count as f32).collect();
+    let chroma = fit_chroma(base.c, |chroma| {
+        hues.iter()
+            .all(|&h| in_srgb_gamut(Oklab::from(Oklch::new(base.l, chroma, h))))
+    });
+    hues.into_iter()
+        .map(|h| Srgb8::from(Oklch::new(base.l, chroma, h)))
+        .collect()
+}
+
+/// Samples `count` evenly spaced colors from `gradient`, dropping their alpha, then applies the optional perceptual
+/// distance filter.
+///
+/// Ramps suit ordered data, like severity levels or the age of a change, better than the harmony palettes above.
+pub fn gradient_palette(gradient: &Gradient, count: usize, min_delta_e: Option<f32>) -> Vec<Srgb8> {
+    let colors = gradient.colors(count).into_iter().map(Srgba8::rgb).collect();
+    enforce_min_delta_e(colors, min_delta_e)
+}
+
 fn apply_variation(color: Hsl, round: usize) -> Hsl {
     if round == 0 {
         return color;
@@ -106,6 +135,7 @@ fn filter_by_contrast(colors: Vec<Srgb8>, background: Option<Srgb8>, min_contras
 /// Attempts to load a TrueType font from the system.
 ///
 /// TODO: Allow users to pass in a custom font family via CLI flag (e.g., --font "FontName").
+#[cfg(not(target_family = "wasm"))]
 fn load_system_font() -> Option<Font<'static>> {
     if let Some((data, _)) = font_loader::system_fonts::get(
         &font_loader::system_fonts::FontPropertyBuilder::new()
@@ -138,6 +168,12 @@ fn load_system_font() -> Option<Font<'static>> {
     None
 }
 
+/// WebAssembly has no system fonts, so labels fall back to the bitmap font.
+#[cfg(target_family = "wasm")]
+fn load_system_font() -> Option<Font<'static>> {
+    None
+}
+
 /// Renders the palette into an RGB image with vertical bars and optional labels.
 pub fn palette_to_image<'a>(colors: &[Srgb8], labels: PaletteLabelStyle<'a>, size: (u32, u32)) -> RgbImage {
     let system_font = load_system_font();
@@ -331,6 +367,16 @@ mod tests {
         assert_eq!(palette.len(), 5);
     }
 
+    #[test]
+    fn gradient_palette_samples_the_ramp_opaquely() {
+        let gradient: Gradient = "#000, #ff880080, #fff".parse().unwrap();
+        let palette = gradient_palette(&gradient, 3, None);
+        assert_eq!(
+            palette,
+            [Srgb8::new(0, 0, 0), Srgb8::new(255, 136, 0), Srgb8::new(255, 255, 255)]
+        );
+    }
+
     #[test]
     fn palette_from_base_enforces_contrast_when_requested() {
         let base = Srgb8::new(200, 200, 200);
@@ -341,6 +387,54 @@ mod tests {
         }
     }
 
+    #[test]
+    fn oklch_palette_spaces_neighbors_evenly() {
+        use crate::diffs::delta_e_ok;
+
+        let spread = |palette: &[Srgb8]| {
+            let oklabs: Vec<_> = palette.iter().copied().map(Oklab::from).collect();
+            let deltas: Vec<f32> = (0..oklabs.len())
+                .map(|i| delta_e_ok(oklabs[i], oklabs[(i + 1) % oklabs.len()]))
+                .collect();
+            let max = deltas.iter().copied().fold(f32::MIN, f32::max);
+            let min = deltas.iter().copied().fold(f32::MAX, f32::min);
+            (max - min) / max
+        };
+
+        for base in [
+            Srgb8::new(255, 128, 0),
+            Srgb8::new(40, 90, 200),
+            Srgb8::new(229, 108, 117),
+        ] {
+            let palette = oklch_palette(base, 8);
+            assert_eq!(palette.len(), 8);
+            assert!(spread(&palette) < 0.1, "{base}: spread {}", spread(&palette));
+
+            let lightness: Vec<f32> = palette.iter().map(|&c| Oklab::from(c).l).collect();
+            assert!(lightness.iter().all(|l| (l - lightness[0]).abs() < 0.01));
+
+            // Rotating hue in HSL instead lets yellow and blue drift far apart in lightness and spacing.
+            let rotated: Vec<Srgb8> = (0..8).map(|i| base.rotate_hue(45.0 * i as f32)).collect();
+            assert!(spread(&rotated) > 0.3, "{base}: HSL spread {}", spread(&rotated));
+        }
+    }
+
+    #[test]
+    fn oklch_palette_reduces_chroma_instead_of_clipping() {
+        // Pure red has more chroma than most hues can hold at its lightness.
+        let palette = oklch_palette(Srgb8::new(255, 0, 0), 6);
+        let chroma: Vec<f32> = palette.iter().map(|&c| Oklch::from(c).c).collect();
+        assert!(chroma[0] < Oklch::from(Srgb8::new(255, 0, 0)).c);
+        assert!(chroma.iter().all(|c| (c - chroma[0]).abs() < 0.01), "{chroma:?}");
+        let hues: Vec<f32> = palette.iter().map(|&c| Oklch::from(c).h).collect();
+        for (i, h) in hues.iter().enumerate() {
+            let expected = crate::colors::wrap_degrees(hues[0] + 60.0 * i as f32);
+            let diff = (h - expected + 540.0) % 360.0 - 180.0;
+            assert!(diff.abs() < 2.0, "hue {i}: {h} vs {expected}");
+        }
+        assert!(oklch_palette(Srgb8::new(255, 0, 0), 0).is_empty());
+    }
+
     #[test]
     fn golden_ratio_palette_respects_min_delta_e() {
         let palette = golden_ratio_palette(6, 0.5..0.8, 0.4..0.6, Some(2.0));
@@ -0,0 +1,453 @@
+//! Parsing colors from the textual forms found in theme files and configuration.
+//!
+//! Accepts the CSS notations editors and users write by hand:
+//! - Hex: `#rgb`, `#rgba`, `#rrggbb`, `#rrggbbaa`
+//! - Functional: `rgb()`/`rgba()` and `hsl()`/`hsla()`, with comma- or space-separated components
+//! - The 148 CSS named colors (`rebeccapurple`, `dodgerblue`) and `transparent`
+
+use crate::colors::{Hsl, Srgb8, Srgba8};
+use std::{fmt, str::FromStr};
+
+/// Errors raised when a string is not a recognizable color.
+#[derive(Debug, Clone, PartialEq)]
+pub enum ColorParseError {
+    Empty,
+    /// A `#` color with the wrong length or non-hex digits.
+    InvalidHex(String),
+    /// A word that is neither a named color nor a color function.
+    UnknownColor(String),
+    /// A functional color without its closing parenthesis.
+    Unterminated(String),
+    /// A color function with the wrong number of components.
+    ComponentCount {
+        function: String,
+        found: usize,
+    },
+    /// A component that isn't a number (or percentage, or angle) the function accepts.
+    InvalidComponent {
+        function: String,
+        token: String,
+    },
+}
+
+impl fmt::Display for ColorParseError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            ColorParseError::Empty => write!(f, "empty color value"),
+            ColorParseError::InvalidHex(value) => write!(f, "'{value}' is not a valid hex color"),
+            ColorParseError::UnknownColor(value) => write!(f, "unknown color '{value}'"),
+            ColorParseError::Unterminated(value) => write!(f, "'{value}' is missing a closing parenthesis"),
+            ColorParseError::ComponentCount { function, found } => {
+                write!(f, "{function}() takes 3 or 4 components, found {found}")
+            }
+            ColorParseError::InvalidComponent { function, token } => {
+                write!(f, "'{token}' is not a valid {function}() component")
+            }
+        }
+    }
+}
+
+impl std::error::Error for ColorParseError {}
+
+/// Parses a color, ignoring any alpha component.
+///
+/// Parsing is case-insensitive and ignores surrounding whitespace. Use [parse_color_with_alpha] to keep the alpha.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::parse::parse_color;
+///
+/// assert_eq!(parse_color("#f80").unwrap(), Srgb8::new(255, 136, 0));
+/// assert_eq!(parse_color("rgb(255, 128, 0)").unwrap(), Srgb8::new(255, 128, 0));
+/// assert_eq!(parse_color("hsl(30, 100%, 50%)").unwrap(), Srgb8::new(255, 128, 0));
+/// assert_eq!(parse_color(" RebeccaPurple ").unwrap().to_hex(), "#663399");
+/// assert!(parse_color("rgb(255, oops, 0)").unwrap_err().to_string().contains("oops"));
+/// ```
+pub fn parse_color(value: &str) -> Result<Srgb8, ColorParseError> {
+    parse_color_with_alpha(value).map(|(color, _)| color)
+}
+
+/// Parses a color and its alpha in [0, 1], which is 1 for forms that don't specify one.
+pub fn parse_color_with_alpha(value: &str) -> Result<(Srgb8, f32), ColorParseError> {
+    let value = value.trim();
+    if value.is_empty() {
+        return Err(ColorParseError::Empty);
+    }
+    if let Some(hex) = value.strip_prefix('#') {
+        return parse_hex(hex).ok_or_else(|| ColorParseError::InvalidHex(value.to_string()));
+    }
+    let lower = value.to_ascii_lowercase();
+    if let Some((function, args)) = lower.split_once('(') {
+        let args = args
+            .trim_end()
+            .strip_suffix(')')
+            .ok_or_else(|| ColorParseError::Unterminated(value.to_string()))?;
+        return parse_function(function.trim_end(), args);
+    }
+    if lower == "transparent" {
+        return Ok((Srgb8::new(0, 0, 0), 0.0));
+    }
+    named_color(&lower)
+        .map(|color| (color, 1.0))
+        .ok_or_else(|| ColorParseError::UnknownColor(value.to_string()))
+}
+
+impl FromStr for Srgb8 {
+    type Err = ColorParseError;
+
+    /// Parses any form [parse_color] accepts, so `color.to_string().parse()` returns the same color.
+    fn from_str(value: &str) -> Result<Self, Self::Err> {
+        parse_color(value)
+    }
+}
+
+impl FromStr for Srgba8 {
+    type Err = ColorParseError;
+
+    /// Parses any form [parse_color_with_alpha] accepts, keeping the alpha, so `color.to_string().parse()` returns the
+    /// same color.
+    fn from_str(value: &str) -> Result<Self, Self::Err> {
+        let (Srgb8 { r, g, b }, alpha) = parse_color_with_alpha(value)?;
+        Ok(Srgba8::new(r, g, b, (alpha * 255.0).round() as u8))
+    }
+}
+
+/// Looks up a lowercase CSS color name.
+pub fn named_color(name: &str) -> Option<Srgb8> {
+    NAMED_COLORS
+        .binary_search_by(|(candidate, _)| candidate.cmp(&name))
+        .ok()
+        .map(|index| NAMED_COLORS[index].1)
+}
+
+fn parse_hex(hex: &str) -> Option<(Srgb8, f32)> {
+    if !hex.is_ascii() {
+        return None;
+    }
+    let digits: Vec<u8> = match hex.len() {
+        3 | 4 => hex
+            .chars()
+            .map(|ch| ch.to_digit(16).map(|d| d as u8 * 17))
+            .collect::<Option<_>>()?,
+        6 | 8 => (0..hex.len())
+            .step_by(2)
+            .map(|i| u8::from_str_radix(&hex[i..i + 2], 16).ok())
+            .collect::<Option<_>>()?,
+        _ => return None,
+    };
+    let alpha = digits.get(3).map_or(1.0, |&a| a as f32 / 255.0);
+    Some((Srgb8::new(digits[0], digits[1], digits[2]), alpha))
+}
+
+/// Parses the arguments of `rgb()`, `rgba()`, `hsl()`, or `hsla()`.
+///
+/// Both the legacy comma syntax (`rgba(255, 0, 0, 0.5)`) and the space syntax (`rgb(255 0 0 / 50%)`) are accepted,
+/// and either name takes an optional alpha, as in CSS Color 4.
+fn parse_function(function: &str, args: &str) -> Result<(Srgb8, f32), ColorParseError> {
+    let tokens: Vec<&str> = args
+        .split(|ch: char| ch == ',' || ch == '/' || ch.is_whitespace())
+        .filter(|token| !token.is_empty())
+        .collect();
+    let name = match function {
+        "rgb" | "rgba" => "rgb",
+        "hsl" | "hsla" => "hsl",
+        _ => return Err(ColorParseError::UnknownColor(function.to_string())),
+    };
+    if !(3..=4).contains(&tokens.len()) {
+        return Err(ColorParseError::ComponentCount { function: function.to_string(), found: tokens.len() });
+    }
+    let invalid =
+        |token: &str| ColorParseError::InvalidComponent { function: function.to_string(), token: token.into() };
+    let alpha = match tokens.get(3) {
+        Some(token) => unit(token).ok_or_else(|| invalid(token))?,
+        None => 1.0,
+    };
+    let color = if name == "rgb" {
+        let channel = |token: &str| {
+            let value = match token.strip_suffix('%') {
+                Some(percent) => number(percent)? / 100.0 * 255.0,
+                None => number(token)?,
+            };
+            Some(value.clamp(0.0, 255.0).round() as u8)
+        };
+        let [r, g, b] = [tokens[0], tokens[1], tokens[2]].map(|token| channel(token).ok_or_else(|| invalid(token)));
+        Srgb8::new(r?, g?, b?)
+    } else {
+        let hue = {
+            let token = tokens[0];
+            number(token.strip_suffix("deg").unwrap_or(token)).ok_or_else(|| invalid(token))?
+        };
+        let percent = |token: &str| {
+            let value = number(token.strip_suffix('%').unwrap_or(token)).ok_or_else(|| invalid(token))?;
+            Ok(value / 100.0)
+        };
+        Srgb8::from(Hsl::new(hue, percent(tokens[1])?, percent(tokens[2])?))
+    };
+    Ok((color, alpha))
+}
+
+/// Parses an alpha value, either a fraction (`0.5`) or a percentage (`50%`), clamped to [0, 1].
+fn unit(token: &str) -> Option<f32> {
+    let value = match token.strip_suffix('%') {
+        Some(percent) => number(percent)? / 100.0,
+        None => number(token)?,
+    };
+    Some(value.clamp(0.0, 1.0))
+}
+
+/// Parses a finite number; `inf` and `NaN` are not colors.
+fn number(token: &str) -> Option<f32> {
+    token.parse::<f32>().ok().filter(|value| value.is_finite())
+}
+
+/// CSS named colors, sorted for binary search.
+const NAMED_COLORS: &[(&str, Srgb8)] = &[
+    ("aliceblue", Srgb8::new(240, 248, 255)),
+    ("antiquewhite", Srgb8::new(250, 235, 215)),
+    ("aqua", Srgb8::new(0, 255, 255)),
+    ("aquamarine", Srgb8::new(127, 255, 212)),
+    ("azure", Srgb8::new(240, 255, 255)),
+    ("beige", Srgb8::new(245, 245, 220)),
+    ("bisque", Srgb8::new(255, 228, 196)),
+    ("black", Srgb8::new(0, 0, 0)),
+    ("blanchedalmond", Srgb8::new(255, 235, 205)),
+    ("blue", Srgb8::new(0, 0, 255)),
+    ("blueviolet", Srgb8::new(138, 43, 226)),
+    ("brown", Srgb8::new(165, 42, 42)),
+    ("burlywood", Srgb8::new(222, 184, 135)),
+    ("cadetblue", Srgb8::new(95, 158, 160)),
+    ("chartreuse", Srgb8::new(127, 255, 0)),
+    ("chocolate", Srgb8::new(210, 105, 30)),
+    ("coral", Srgb8::new(255, 127, 80)),
+    ("cornflowerblue", Srgb8::new(100, 149, 237)),
+    ("cornsilk", Srgb8::new(255, 248, 220)),
+    ("crimson", Srgb8::new(220, 20, 60)),
+    ("cyan", Srgb8::new(0, 255, 255)),
+    ("darkblue", Srgb8::new(0, 0, 139)),
+    ("darkcyan", Srgb8::new(0, 139, 139)),
+    ("darkgoldenrod", Srgb8::new(184, 134, 11)),
+    ("darkgray", Srgb8::new(169, 169, 169)),
+    ("darkgreen", Srgb8::new(0, 100, 0)),
+    ("darkgrey", Srgb8::new(169, 169, 169)),
+    ("darkkhaki", Srgb8::new(189, 183, 107)),
+    ("darkmagenta", Srgb8::new(139, 0, 139)),
+    ("darkolivegreen", Srgb8::new(85, 107, 47)),
+    ("darkorange", Srgb8::new(255, 140, 0)),
+    ("darkorchid", Srgb8::new(153, 50, 204)),
+    ("darkred", Srgb8::new(139, 0, 0)),
+    ("darksalmon", Srgb8::new(233, 150, 122)),
+    ("darkseagreen", Srgb8::new(143, 188, 143)),
+    ("darkslateblue", Srgb8::new(72, 61, 139)),
+    ("darkslategray", Srgb8::new(47, 79, 79)),
+    ("darkslategrey", Srgb8::new(47, 79, 79)),
+    ("darkturquoise", Srgb8::new(0, 206, 209)),
+    ("darkviolet", Srgb8::new(148, 0, 211)),
+    ("deeppink", Srgb8::new(255, 20, 147)),
+    ("deepskyblue", Srgb8::new(0, 191, 255)),
+    ("dimgray", Srgb8::new(105, 105, 105)),
+    ("dimgrey", Srgb8::new(105, 105, 105)),
+    ("dodgerblue", Srgb8::new(30, 144, 255)),
+    ("firebrick", Srgb8::new(178, 34, 34)),
+    ("floralwhite", Srgb8::new(255, 250, 240)),
+    ("forestgreen", Srgb8::new(34, 139, 34)),
+    ("fuchsia", Srgb8::new(255, 0, 255)),
+    ("gainsboro", Srgb8::new(220, 220, 220)),
+    ("ghostwhite", Srgb8::new(248, 248, 255)),
+    ("gold", Srgb8::new(255, 215, 0)),
+    ("goldenrod", Srgb8::new(218, 165, 32)),
+    ("gray", Srgb8::new(128, 128, 128)),
+    ("green", Srgb8::new(0, 128, 0)),
+    ("greenyellow", Srgb8::new(173, 255, 47)),
+    ("grey", Srgb8::new(128, 128, 128)),
+    ("honeydew", Srgb8::new(240, 255, 240)),
+    ("hotpink", Srgb8::new(255, 105, 180)),
+    ("indianred", Srgb8::new(205, 92, 92)),
+    ("indigo", Srgb8::new(75, 0, 130)),
+    ("ivory", Srgb8::new(255, 255, 240)),
+    ("khaki", Srgb8::new(240, 230, 140)),
+    ("lavender", Srgb8::new(230, 230, 250)),
+    ("lavenderblush", Srgb8::new(255, 240, 245)),
+    ("lawngreen", Srgb8::new(124, 252, 0)),
+    ("lemonchiffon", Srgb8::new(255, 250, 205)),
+    ("lightblue", Srgb8::new(173, 216, 230)),
+    ("lightcoral", Srgb8::new(240, 128, 128)),
+    ("lightcyan", Srgb8::new(224, 255, 255)),
+    ("lightgoldenrodyellow", Srgb8::new(250, 250, 210)),
+    ("lightgray", Srgb8::new(211, 211, 211)),
+    ("lightgreen", Srgb8::new(144, 238, 144)),
+    ("lightgrey", Srgb8::new(211, 211, 211)),
+    ("lightpink", Srgb8::new(255, 182, 193)),
+    ("lightsalmon", Srgb8::new(255, 160, 122)),
+    ("lightseagreen", Srgb8::new(32, 178, 170)),
+    ("lightskyblue", Srgb8::new(135, 206, 250)),
+    ("lightslategray", Srgb8::new(119, 136, 153)),
+    ("lightslategrey", Srgb8::new(119, 136, 153)),
+    ("lightsteelblue", Srgb8::new(176, 196, 222)),
+    ("lightyellow", Srgb8::new(255, 255, 224)),
+    ("lime", Srgb8::new(0, 255, 0)),
+    ("limegreen", Srgb8::new(50, 205, 50)),
+    ("linen", Srgb8::new(250, 240, 230)),
+    ("magenta", Srgb8::new(255, 0, 255)),
+    ("maroon", Srgb8::new(128, 0, 0)),
+    ("mediumaquamarine", Srgb8::new(102, 205, 170)),
+    ("mediumblue", Srgb8::new(0, 0, 205)),
+    ("mediumorchid", Srgb8::new(186, 85, 211)),
+    ("mediumpurple", Srgb8::new(147, 112, 219)),
+    ("mediumseagreen", Srgb8::new(60, 179, 113)),
+    ("mediumslateblue", Srgb8::new(123, 104, 238)),
+    ("mediumspringgreen", Srgb8::new(0, 250, 154)),
+    ("mediumturquoise", Srgb8::new(72, 209, 204)),
+    ("mediumvioletred", Srgb8::new(199, 21, 133)),
+    ("midnightblue", Srgb8::new(25, 25, 112)),
+    ("mintcream", Srgb8::new(245, 255, 250)),
+    ("mistyrose", Srgb8::new(255, 228, 225)),
+    ("moccasin", Srgb8::new(255, 228, 181)),
+    ("navajowhite", Srgb8::new(255, 222, 173)),
+    ("navy", Srgb8::new(0, 0, 128)),
+    ("oldlace", Srgb8::new(253, 245, 230)),
+    ("olive", Srgb8::new(128, 128, 0)),
+    ("olivedrab", Srgb8::new(107, 142, 35)),
+    ("orange", Srgb8::new(255, 165, 0)),
+    ("orangered", Srgb8::new(255, 69, 0)),
+    ("orchid", Srgb8::new(218, 112, 214)),
+    ("palegoldenrod", Srgb8::new(238, 232, 170)),
+    ("palegreen", Srgb8::new(152, 251, 152)),
+    ("paleturquoise", Srgb8::new(175, 238, 238)),
+    ("palevioletred", Srgb8::new(219, 112, 147)),
+    ("papayawhip", Srgb8::new(255, 239, 213)),
+    ("peachpuff", Srgb8::new(255, 218, 185)),
+    ("peru", Srgb8::new(205, 133, 63)),
+    ("pink", Srgb8::new(255, 192, 203)),
+    ("plum", Srgb8::new(221, 160, 221)),
+    ("powderblue", Srgb8::new(176, 224, 230)),
+    ("purple", Srgb8::new(128, 0, 128)),
+    ("rebeccapurple", Srgb8::new(102, 51, 153)),
+    ("red", Srgb8::new(255, 0, 0)),
+    ("rosybrown", Srgb8::new(188, 143, 143)),
+    ("royalblue", Srgb8::new(65, 105, 225)),
+    ("saddlebrown", Srgb8::new(139, 69, 19)),
+    ("salmon", Srgb8::new(250, 128, 114)),
+    ("sandybrown", Srgb8::new(244, 164, 96)),
+    ("seagreen", Srgb8::new(46, 139, 87)),
+    ("seashell", Srgb8::new(255, 245, 238)),
+    ("sienna", Srgb8::new(160, 82, 45)),
+    ("silver", Srgb8::new(192, 192, 192)),
+    ("skyblue", Srgb8::new(135, 206, 235)),
+    ("slateblue", Srgb8::new(106, 90, 205)),
+    ("slategray", Srgb8::new(112, 128, 144)),
+    ("slategrey", Srgb8::new(112, 128, 144)),
+    ("snow", Srgb8::new(255, 250, 250)),
+    ("springgreen", Srgb8::new(0, 255, 127)),
+    ("steelblue", Srgb8::new(70, 130, 180)),
+    ("tan", Srgb8::new(210, 180, 140)),
+    ("teal", Srgb8::new(0, 128, 128)),
+    ("thistle", Srgb8::new(216, 191, 216)),
+    ("tomato", Srgb8::new(255, 99, 71)),
+    ("turquoise", Srgb8::new(64, 224, 208)),
+    ("violet", Srgb8::new(238, 130, 238)),
+    ("wheat", Srgb8::new(245, 222, 179)),
+    ("white", Srgb8::new(255, 255, 255)),
+    ("whitesmoke", Srgb8::new(245, 245, 245)),
+    ("yellow", Srgb8::new(255, 255, 0)),
+    ("yellowgreen", Srgb8::new(154, 205, 50)),
+];
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn rgba(value: &str) -> (Srgb8, f32) {
+        parse_color_with_alpha(value).unwrap()
+    }
+
+    #[test]
+    fn hex_forms() {
+        assert_eq!(rgba("#f80"), (Srgb8::new(255, 136, 0), 1.0));
+        assert_eq!(rgba("#F80C"), (Srgb8::new(255, 136, 0), 0.8));
+        assert_eq!(rgba("#FF8000"), (Srgb8::new(255, 128, 0), 1.0));
+        assert_eq!(rgba("  #ff800080\n"), (Srgb8::new(255, 128, 0), 128.0 / 255.0));
+        for bad in ["#", "#ff", "#12345", "#ggg", "#ff8000ff00", "#ffé"] {
+            assert_eq!(parse_color(bad), Err(ColorParseError::InvalidHex(bad.to_string())));
+        }
+    }
+
+    #[test]
+    fn translucent_colors_round_trip_through_display() {
+        for value in ["#ff800080", "#ff8000", "rgba(255, 128, 0, 0.5)", "transparent"] {
+            let color: Srgba8 = value.parse().unwrap();
+            assert_eq!(color.to_string().parse::<Srgba8>(), Ok(color), "{value}");
+        }
+        assert_eq!("#ff800080".parse(), Ok(Srgba8::new(255, 128, 0, 128)));
+        assert_eq!("#ff8000".parse::<Srgba8>().unwrap().to_string(), "#ff8000");
+    }
+
+    #[test]
+    fn rgb_functions() {
+        let orange = Srgb8::new(255, 128, 0);
+        assert_eq!(rgba("rgb(255, 128, 0)"), (orange, 1.0));
+        assert_eq!(rgba("RGB( 255 ,128,0 )"), (orange, 1.0));
+        assert_eq!(rgba("rgb(255 128 0)"), (orange, 1.0));
+        assert_eq!(rgba("rgb(100%, 50%, 0%)"), (orange, 1.0));
+        assert_eq!(rgba("rgba(255, 128, 0, 0.25)"), (orange, 0.25));
+        assert_eq!(rgba("rgba(255, 128, 0, 25%)"), (orange, 0.25));
+        assert_eq!(rgba("rgb(255 128 0 / .5)"), (orange, 0.5));
+        assert_eq!(rgba("rgb(300, -5, 0, 2)"), (Srgb8::new(255, 0, 0), 1.0));
+    }
+
+    #[test]
+    fn hsl_functions() {
+        let orange = Srgb8::new(255, 128, 0);
+        assert_eq!(rgba("hsl(30, 100%, 50%)"), (orange, 1.0));
+        assert_eq!(rgba("hsl(30deg 100% 50%)"), (orange, 1.0));
+        assert_eq!(rgba("hsla(390, 100%, 50%, 50%)"), (orange, 0.5));
+        assert_eq!(rgba("hsl(0, 0%, 100%)"), (Srgb8::new(255, 255, 255), 1.0));
+    }
+
+    #[test]
+    fn named_colors() {
+        assert_eq!(NAMED_COLORS.len(), 148);
+        assert!(NAMED_COLORS.windows(2).all(|pair| pair[0].0 < pair[1].0));
+        assert_eq!(parse_color("rebeccapurple").unwrap().to_hex(), "#663399");
+        assert_eq!(parse_color("DodgerBlue").unwrap().to_hex(), "#1e90ff");
+        assert_eq!(parse_color("gray"), parse_color("grey"));
+        assert_eq!(parse_color("green").unwrap(), Srgb8::new(0, 128, 0));
+        assert_eq!(rgba("transparent").1, 0.0);
+    }
+
+    #[test]
+    fn errors_name_the_offending_token() {
+        assert_eq!(parse_color("  "), Err(ColorParseError::Empty));
+        assert_eq!(
+            parse_color("blurple"),
+            Err(ColorParseError::UnknownColor("blurple".into()))
+        );
+        assert_eq!(
+            parse_color("cmyk(0, 0, 0, 0)"),
+            Err(ColorParseError::UnknownColor("cmyk".into()))
+        );
+        assert_eq!(
+            parse_color("rgb(1, 2, 3"),
+            Err(ColorParseError::Unterminated("rgb(1, 2, 3".into()))
+        );
+        assert_eq!(
+            parse_color("rgb(1, 2)"),
+            Err(ColorParseError::ComponentCount { function: "rgb".into(), found: 2 })
+        );
+        let err = parse_color("hsl(30, lots, 50%)").unwrap_err();
+        assert_eq!(err.to_string(), "'lots' is not a valid hsl() component");
+        assert!(parse_color("rgb(nan, 0, 0)").is_err());
+        assert!(parse_color("rgba(0, 0, 0, inf)").is_err());
+    }
+
+    #[test]
+    fn display_round_trips_through_from_str() {
+        for value in ["#000000", "#ff8000", "#663399"] {
+            let color: Srgb8 = value.parse().unwrap();
+            assert_eq!(color.to_string(), value);
+            assert_eq!(color.to_string().parse::<Srgb8>().unwrap(), color);
+        }
+        assert_eq!("tomato".parse::<Srgb8>().unwrap().to_string(), "#ff6347");
+    }
+}
@@ -1,7 +1,7 @@
 //! Tints, shades, and tones generation for color manipulation.
 //!
 //! Provides functions to create color variations by mixing with white (tints), black (shades), or gray (tones).
-//! Also includes HSL-based convenience functions for lightening, darkening, and desaturating colors.
+//! Also includes HSL-based convenience functions for lightening, darkening, saturating, and rotating the hue of colors.
 
 use crate::colors::{Hsl, Rgb, clamp01};
 
@@ -198,6 +198,54 @@ pub fn desaturate_hsl(color: Hsl, amount: f32) -> Hsl {
     Hsl::new(color.h, clamp01(color.s - amount), color.l)
 }
 
+/// Saturates an HSL color by increasing its saturation.
+///
+/// Increases the saturation component by the specified amount, clamped to [0, 1].
+/// Equivalent to `desaturate_hsl(color, -amount)`.
+///
+/// # Arguments
+///
+/// * `color` - The HSL color to saturate
+/// * `amount` - The amount to increase saturation (positive values saturate)
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Hsl;
+/// use colorizer::shades::saturate_hsl;
+///
+/// let muted_blue = Hsl::new(240.0, 0.4, 0.5);
+/// let vibrant_blue = saturate_hsl(muted_blue, 0.4);
+/// // vibrant_blue has saturation = 0.8
+/// ```
+pub fn saturate_hsl(color: Hsl, amount: f32) -> Hsl {
+    Hsl::new(color.h, clamp01(color.s + amount), color.l)
+}
+
+/// Rotates the hue of an HSL color around the color wheel.
+///
+/// The result wraps to [0, 360), so rotating magenta (300°) by 90° gives orange (30°).
+/// Negative values rotate the other way.
+///
+/// # Arguments
+///
+/// * `color` - The HSL color to rotate
+/// * `degrees` - The angle to add to the hue
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Hsl;
+/// use colorizer::shades::rotate_hue_hsl;
+///
+/// let magenta = Hsl::new(300.0, 1.0, 0.5);
+/// let orange = rotate_hue_hsl(magenta, 90.0);
+/// assert_eq!(orange.h, 30.0);
+/// ```
+pub fn rotate_hue_hsl(color: Hsl, degrees: f32) -> Hsl {
+    Hsl::new(color.h + degrees, color.s, color.l)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -385,6 +433,32 @@ mod tests {
         assert!(approx_eq(result.l, color.l));
     }
 
+    #[test]
+    fn test_saturate_hsl() {
+        let color = Hsl::new(60.0, 0.3, 0.5);
+
+        let result = saturate_hsl(color, 0.3);
+        assert!(approx_eq(result.h, 60.0));
+        assert!(approx_eq(result.s, 0.6));
+        assert!(approx_eq(result.l, 0.5));
+
+        let result = saturate_hsl(color, 1.0);
+        assert!(approx_eq(result.s, 1.0));
+    }
+
+    #[test]
+    fn test_rotate_hue_wraps() {
+        let color = Hsl::new(350.0, 0.5, 0.5);
+        assert!(approx_eq(rotate_hue_hsl(color, 20.0).h, 10.0));
+        assert!(approx_eq(rotate_hue_hsl(color, -360.0).h, 350.0));
+        assert!(approx_eq(rotate_hue_hsl(color, 730.0).h, 0.0));
+        assert!(approx_eq(rotate_hue_hsl(Hsl::new(10.0, 0.5, 0.5), -20.0).h, 350.0));
+
+        let result = rotate_hue_hsl(color, 180.0);
+        assert!(approx_eq(result.s, color.s));
+        assert!(approx_eq(result.l, color.l));
+    }
+
     #[test]
     fn test_gray_clamping_in_tone() {
         let color = Rgb::new(1.0, 0.0, 0.0);
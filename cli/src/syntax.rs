@@ -9,6 +9,7 @@ use crate::tinted_theming::{Base16Scheme, Base24Scheme};
 use owo_colors::OwoColorize;
 use std::io::{self, BufRead};
 use std::str::FromStr;
+use std::sync::OnceLock;
 use syntect::easy::HighlightLines;
 use syntect::highlighting::{Color, FontStyle, ScopeSelectors, Style as SyntectStyle, Theme};
 use syntect::parsing::{SyntaxReference, SyntaxSet};
@@ -220,7 +221,7 @@ pub fn highlight_code_to_terminal<R: BufRead>(
         let line_with_newline = format!("{line}\n");
 
         let ranges = highlighter
-            .highlight_line(&line_with_newline, &load_syntax_set())
+            .highlight_line(&line_with_newline, syntax_set())
             .map_err(io::Error::other)?;
 
         let line_str = render_highlighted_line(&ranges, panel_bg);
@@ -247,7 +248,7 @@ pub fn highlight_code_to_terminal<R: BufRead>(
 pub fn highlight_string_to_terminal(
     code: &str, syntax: &SyntaxReference, theme: &Theme, theme_name: Option<&str>,
 ) -> io::Result<()> {
-    let syntax_set = load_syntax_set();
+    let syntax_set = syntax_set();
     let mut highlighter = HighlightLines::new(syntax, theme);
     let mut highlighted_lines = Vec::new();
     let mut max_width = 0;
@@ -256,9 +257,7 @@ pub fn highlight_string_to_terminal(
     let status_fg = theme.settings.foreground.map(color_tuple_from_syntect);
 
     for line in LinesWithEndings::from(code) {
-        let ranges = highlighter
-            .highlight_line(line, &syntax_set)
-            .map_err(io::Error::other)?;
+        let ranges = highlighter.highlight_line(line, syntax_set).map_err(io::Error::other)?;
 
         let line_str = render_highlighted_line(&ranges, panel_bg);
         let visible_width = line.trim_end().chars().count();
@@ -400,10 +399,27 @@ fn style_background(style: &SyntectStyle, panel_bg: Option<(u8, u8, u8)>) -> Opt
 }
 
 /// Loads the extended syntax set with support for 100+ languages including TypeScript and Elm.
+#[cfg(feature = "grammars")]
 pub fn load_syntax_set() -> SyntaxSet {
     two_face::syntax::extra_newlines()
 }
 
+/// Loads syntect's default syntax set, which lacks the extra languages two-face bundles.
+#[cfg(not(feature = "grammars"))]
+pub fn load_syntax_set() -> SyntaxSet {
+    SyntaxSet::load_defaults_newlines()
+}
+
+/// Returns the shared syntax set, loading it on first use.
+///
+/// Loading deserializes every bundled grammar, and syntect compiles a grammar's patterns the first time they are
+/// tried, so both costs are paid once per process instead of once per lookup. Concurrent first calls are safe: one
+/// thread loads the set while the others wait for it.
+pub fn syntax_set() -> &'static SyntaxSet {
+    static SYNTAX_SET: OnceLock<SyntaxSet> = OnceLock::new();
+    SYNTAX_SET.get_or_init(load_syntax_set)
+}
+
 /// Finds a syntax by language name (e.g., "rust", "python").
 ///
 /// Case-insensitive search that tries both the name and extension.
@@ -0,0 +1,634 @@
+//! Terminal color capabilities.
+//!
+//! Provides color profile detection from the environment, background detection by asking the terminal, and the
+//! xterm 256-color palette used to downgrade truecolor output for terminals that cannot display it.
+
+use crate::colors::{Lab, Srgb8};
+use crate::diffs::delta_e_2000;
+use crate::wcag::contrast_ratio;
+
+use std::cell::RefCell;
+use std::env;
+use std::io;
+use std::sync::OnceLock;
+
+/// Color depth supported by the output terminal.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Default)]
+pub enum ColorProfile {
+    /// 24-bit color (`\x1b[38;2;r;g;bm`).
+    #[default]
+    TrueColor,
+    /// xterm 256-color palette (`\x1b[38;5;nm`).
+    Ansi256,
+    /// The 16 standard and bright ANSI colors (`\x1b[31m`, `\x1b[91m`, ...).
+    Ansi16,
+    /// No escape sequences at all.
+    NoColor,
+}
+
+impl ColorProfile {
+    /// Detects the profile from the `COLORTERM` and `TERM` environment variables.
+    pub fn detect() -> Self {
+        let colorterm = env::var("COLORTERM").ok();
+        let term = env::var("TERM").ok();
+        Self::from_env_values(colorterm.as_deref(), term.as_deref())
+    }
+
+    /// Picks a profile from raw `COLORTERM` and `TERM` values.
+    ///
+    /// `COLORTERM=truecolor` (or `24bit`) wins; otherwise `TERM` decides, with `*-256color` mapping to
+    /// [ColorProfile::Ansi256], an unset or `dumb` terminal to [ColorProfile::NoColor], and anything else to
+    /// [ColorProfile::Ansi16].
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use colorizer::terminal::ColorProfile;
+    ///
+    /// assert_eq!(ColorProfile::from_env_values(Some("truecolor"), Some("xterm")), ColorProfile::TrueColor);
+    /// assert_eq!(ColorProfile::from_env_values(None, Some("screen-256color")), ColorProfile::Ansi256);
+    /// assert_eq!(ColorProfile::from_env_values(None, Some("dumb")), ColorProfile::NoColor);
+    /// ```
+    pub fn from_env_values(colorterm: Option<&str>, term: Option<&str>) -> Self {
+        let colorterm = colorterm.unwrap_or("").to_ascii_lowercase();
+        if colorterm == "truecolor" || colorterm == "24bit" {
+            return ColorProfile::TrueColor;
+        }
+
+        let term = term.unwrap_or("").to_ascii_lowercase();
+        if term.is_empty() || term == "dumb" {
+            ColorProfile::NoColor
+        } else if term.contains("truecolor") || term.contains("24bit") || term.contains("direct") {
+            ColorProfile::TrueColor
+        } else if term.contains("256color") {
+            ColorProfile::Ansi256
+        } else {
+            ColorProfile::Ansi16
+        }
+    }
+
+    /// Returns the SGR parameters that select `color` as the foreground (e.g., `38;5;196`), or `None` for
+    /// [ColorProfile::NoColor].
+    pub fn foreground_params(self, color: Srgb8) -> Option<String> {
+        self.color_params(color, 38, 30, 90)
+    }
+
+    /// Returns the SGR parameters that select `color` as the background (e.g., `48;5;196`), or `None` for
+    /// [ColorProfile::NoColor].
+    pub fn background_params(self, color: Srgb8) -> Option<String> {
+        self.color_params(color, 48, 40, 100)
+    }
+
+    fn color_params(self, color: Srgb8, extended: u8, normal: u8, bright: u8) -> Option<String> {
+        match self {
+            ColorProfile::TrueColor => Some(format!("{extended};2;{};{};{}", color.r, color.g, color.b)),
+            ColorProfile::Ansi256 => Some(format!("{extended};5;{}", nearest_ansi256(color))),
+            ColorProfile::Ansi16 => {
+                let index = nearest_ansi16(color);
+                let code = if index < 8 { normal + index } else { bright + index - 8 };
+                Some(code.to_string())
+            }
+            ColorProfile::NoColor => None,
+        }
+    }
+}
+
+/// Whether a formatter emits color at all, independent of the [ColorProfile] it renders with.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Default)]
+pub enum ColorChoice {
+    /// Color when the output is a terminal, unless the environment says otherwise (see [color_override]).
+    #[default]
+    Auto,
+    /// Always color, even when piping or when `NO_COLOR` is set.
+    Always,
+    /// Never color.
+    Never,
+}
+
+/// Reads the `NO_COLOR`, `CLICOLOR_FORCE`, and `CLICOLOR` conventions; see [color_override_from_env_values].
+pub fn color_override() -> Option<bool> {
+    let no_color = env::var("NO_COLOR").ok();
+    let clicolor = env::var("CLICOLOR").ok();
+    let clicolor_force = env::var("CLICOLOR_FORCE").ok();
+    color_override_from_env_values(no_color.as_deref(), clicolor.as_deref(), clicolor_force.as_deref())
+}
+
+/// Decides whether the environment forces color on (`Some(true)`) or off (`Some(false)`), or leaves it to the
+/// terminal check (`None`).
+///
+/// A non-empty `NO_COLOR` wins, then a `CLICOLOR_FORCE` other than `0`, then `CLICOLOR=0`.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::terminal::color_override_from_env_values;
+///
+/// assert_eq!(color_override_from_env_values(Some("1"), None, Some("1")), Some(false));
+/// assert_eq!(color_override_from_env_values(None, Some("0"), Some("1")), Some(true));
+/// assert_eq!(color_override_from_env_values(Some(""), Some("1"), None), None);
+/// ```
+pub fn color_override_from_env_values(
+    no_color: Option<&str>, clicolor: Option<&str>, clicolor_force: Option<&str>,
+) -> Option<bool> {
+    if no_color.is_some_and(|value| !value.is_empty()) {
+        Some(false)
+    } else if clicolor_force.is_some_and(|value| !value.is_empty() && value != "0") {
+        Some(true)
+    } else if clicolor == Some("0") {
+        Some(false)
+    } else {
+        None
+    }
+}
+
+/// Whether the terminal draws text on a light or a dark background.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Default)]
+pub enum Background {
+    Light,
+    Dark,
+    /// The terminal didn't say; most are dark, so callers usually treat this as [Background::Dark].
+    #[default]
+    Unknown,
+}
+
+impl Background {
+    /// Classifies a background color by whether black text contrasts with it more than white text does.
+    pub fn of(color: Srgb8) -> Self {
+        let (black, white) = (Srgb8::new(0, 0, 0), Srgb8::new(255, 255, 255));
+        if contrast_ratio(color, black) > contrast_ratio(color, white) {
+            Background::Light
+        } else {
+            Background::Dark
+        }
+    }
+
+    /// Reads a `COLORFGBG` value, which rxvt-style terminals set to the foreground and background ANSI color indices
+    /// (`15;0`, sometimes with a field between them). Backgrounds 7 and 9-15 are light, and 0-6 and 8 dark.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use colorizer::terminal::Background;
+    ///
+    /// assert_eq!(Background::from_colorfgbg("0;15"), Background::Light);
+    /// assert_eq!(Background::from_colorfgbg("15;default;0"), Background::Dark);
+    /// assert_eq!(Background::from_colorfgbg("default;default"), Background::Unknown);
+    /// ```
+    pub fn from_colorfgbg(value: &str) -> Self {
+        match value
+            .rsplit(';')
+            .next()
+            .and_then(|index| index.trim().parse::<u8>().ok())
+        {
+            Some(7 | 9..=15) => Background::Light,
+            Some(0..=6 | 8) => Background::Dark,
+            _ => Background::Unknown,
+        }
+    }
+}
+
+/// Detects whether the terminal background is light or dark: from the color the terminal reports
+/// ([background_color]), or else from `COLORFGBG`.
+///
+/// A failed query counts as no answer, so `/dev/tty` being unavailable still falls back to `COLORFGBG`. Each call
+/// queries the terminal again, so detect once at startup.
+pub fn detect_background() -> Background {
+    if let Ok(Some(color)) = background_color() {
+        return Background::of(color);
+    }
+    env::var("COLORFGBG").map_or(Background::Unknown, |value| Background::from_colorfgbg(&value))
+}
+
+/// Asks the terminal for its background color with an OSC 11 query, returning `None` when it doesn't answer.
+///
+/// Nothing is written unless stdout is a terminal and `TERM` names one. The terminal is put in raw mode with `stty`
+/// for the exchange and restored after it. The query is followed by a device attributes request, which every
+/// terminal answers, so a terminal that ignores OSC 11 is noticed at once instead of after [QUERY_TIMEOUT], and no
+/// late reply is left for the shell to read. tmux 3.3 and later answer OSC 11 with the color of the pane, so inside
+/// tmux the query is sent as is; older versions answer only the device attributes request. GNU screen doesn't answer
+/// OSC 11, so inside it the query is wrapped in its passthrough sequence.
+///
+/// The color is also useful on its own: setting it as a theme's background and calling
+/// [crate::highlight::Theme::repair_contrast] fits the theme to the user's actual terminal.
+pub fn background_color() -> io::Result<Option<Srgb8>> {
+    use std::io::IsTerminal;
+
+    let term = env::var("TERM").unwrap_or_default();
+    if !io::stdout().is_terminal() || term.is_empty() || term == "dumb" {
+        return Ok(None);
+    }
+    let query = passthrough(
+        &format!("{OSC11_QUERY}{DA1_QUERY}"),
+        env::var_os("TMUX").is_some(),
+        term.starts_with("screen"),
+    );
+    query_terminal(&query).map(|reply| parse_osc11_reply(&reply))
+}
+
+/// Longest wait for the terminal's replies to [background_color].
+pub const QUERY_TIMEOUT: std::time::Duration = std::time::Duration::from_millis(500);
+
+/// Requests the background color, terminated with BEL rather than ST so the query can sit inside a passthrough
+/// sequence, which ST would end.
+const OSC11_QUERY: &str = "\x1b]11;?\x07";
+/// Requests the primary device attributes.
+const DA1_QUERY: &str = "\x1b[c";
+
+/// Wraps `query` so GNU screen forwards it to the terminal it runs in rather than interpreting it. tmux answers the
+/// query itself, and wrapping it there would make the reply depend on `allow-passthrough`, so under tmux, whose
+/// `TERM` often starts with `screen` too, it is left alone.
+fn passthrough(query: &str, tmux: bool, screen: bool) -> String {
+    if screen && !tmux { format!("\x1bP{query}\x1b\\") } else { query.to_string() }
+}
+
+/// Writes `query` to the controlling terminal in raw mode and collects its replies until the device attributes
+/// reply arrives or [QUERY_TIMEOUT] passes.
+#[cfg(unix)]
+fn query_terminal(query: &str) -> io::Result<Vec<u8>> {
+    use std::fs::OpenOptions;
+    use std::io::{Read, Write};
+    use std::time::Instant;
+
+    let mut tty = OpenOptions::new().read(true).write(true).open("/dev/tty")?;
+    let _raw = RawMode::enter()?;
+    tty.write_all(query.as_bytes())?;
+    tty.flush()?;
+
+    let deadline = Instant::now() + QUERY_TIMEOUT;
+    let mut reply = Vec::new();
+    let mut buf = [0; 256];
+    while !has_da1_reply(&reply) && Instant::now() < deadline {
+        // Raw mode's `time 1` makes a read with nothing to return give up after a tenth of a second.
+        let read = tty.read(&mut buf)?;
+        reply.extend_from_slice(&buf[..read]);
+    }
+    Ok(reply)
+}
+
+#[cfg(not(unix))]
+fn query_terminal(_query: &str) -> io::Result<Vec<u8>> {
+    Ok(Vec::new())
+}
+
+/// Raw, unechoed terminal input for as long as it lives, restoring the saved `stty` settings when dropped.
+#[cfg(unix)]
+struct RawMode {
+    saved: String,
+}
+
+#[cfg(unix)]
+impl RawMode {
+    fn enter() -> io::Result<Self> {
+        let saved = stty(&["-g"])?.trim().to_string();
+        stty(&["raw", "-echo", "min", "0", "time", "1"])?;
+        Ok(Self { saved })
+    }
+}
+
+#[cfg(unix)]
+impl Drop for RawMode {
+    fn drop(&mut self) {
+        let _ = stty(&[&self.saved]);
+    }
+}
+
+/// Runs `stty` on the controlling terminal, which it reads from stdin, so this works when stdin is redirected.
+#[cfg(unix)]
+fn stty(args: &[&str]) -> io::Result<String> {
+    let tty = std::fs::File::open("/dev/tty")?;
+    let output = std::process::Command::new("stty").args(args).stdin(tty).output()?;
+    if !output.status.success() {
+        return Err(io::Error::other(format!(
+            "stty failed: {}",
+            String::from_utf8_lossy(&output.stderr).trim()
+        )));
+    }
+    Ok(String::from_utf8_lossy(&output.stdout).into_owned())
+}
+
+/// Reports whether `reply` contains a device attributes reply (`ESC [ ? 6 4 ; 1 c`).
+fn has_da1_reply(reply: &[u8]) -> bool {
+    reply.windows(3).enumerate().any(|(start, window)| {
+        window == b"\x1b[?"
+            && reply[start + 3..]
+                .iter()
+                .find(|byte| !(byte.is_ascii_digit() || **byte == b';'))
+                == Some(&b'c')
+    })
+}
+
+/// Reads the color from an OSC 11 reply (`ESC ] 11 ; rgb:1e1e/1e1e/1e1e BEL`) among other terminal output.
+///
+/// Terminals answer with 1 to 4 hex digits per component, scaled to 8 bits here, and terminate with BEL or ST. Some
+/// answer `rgba:` with a fourth component, which is ignored, and a few answer with `#rrggbb`.
+fn parse_osc11_reply(reply: &[u8]) -> Option<Srgb8> {
+    let start = reply.windows(4).position(|window| window == b"]11;")? + 4;
+    let body = &reply[start..];
+    let end = body.iter().position(|&byte| byte == b'\x07' || byte == b'\x1b')?;
+    let body = std::str::from_utf8(&body[..end]).ok()?;
+    if let Some(hex) = body.strip_prefix('#') {
+        return if hex.is_ascii() { Srgb8::from_hex(hex) } else { None };
+    }
+    let (_, components) = body.split_once(':')?;
+    let mut channels = components.split('/').map(|digits| {
+        if !(1..=4).contains(&digits.len()) || !digits.bytes().all(|byte| byte.is_ascii_hexdigit()) {
+            return None;
+        }
+        let value = u32::from_str_radix(digits, 16).ok()?;
+        let max = (1u32 << (4 * digits.len())) - 1;
+        Some(((value * 255 + max / 2) / max) as u8)
+    });
+    Some(Srgb8::new(channels.next()??, channels.next()??, channels.next()??))
+}
+
+/// The xterm 256-color palette: 16 system colors, a 6×6×6 color cube, and a 24-step gray ramp.
+///
+/// System colors use xterm's defaults; terminals are free to remap them.
+pub const XTERM_PALETTE: [Srgb8; 256] = xterm_palette();
+
+const SYSTEM_COLORS: [Srgb8; 16] = [
+    Srgb8::new(0x00, 0x00, 0x00),
+    Srgb8::new(0xcd, 0x00, 0x00),
+    Srgb8::new(0x00, 0xcd, 0x00),
+    Srgb8::new(0xcd, 0xcd, 0x00),
+    Srgb8::new(0x00, 0x00, 0xee),
+    Srgb8::new(0xcd, 0x00, 0xcd),
+    Srgb8::new(0x00, 0xcd, 0xcd),
+    Srgb8::new(0xe5, 0xe5, 0xe5),
+    Srgb8::new(0x7f, 0x7f, 0x7f),
+    Srgb8::new(0xff, 0x00, 0x00),
+    Srgb8::new(0x00, 0xff, 0x00),
+    Srgb8::new(0xff, 0xff, 0x00),
+    Srgb8::new(0x5c, 0x5c, 0xff),
+    Srgb8::new(0xff, 0x00, 0xff),
+    Srgb8::new(0x00, 0xff, 0xff),
+    Srgb8::new(0xff, 0xff, 0xff),
+];
+
+const CUBE_LEVELS: [u8; 6] = [0, 95, 135, 175, 215, 255];
+
+const fn xterm_palette() -> [Srgb8; 256] {
+    let mut palette = [Srgb8::new(0, 0, 0); 256];
+    let mut i = 0;
+    while i < 16 {
+        palette[i] = SYSTEM_COLORS[i];
+        i += 1;
+    }
+    while i < 232 {
+        let cube = i - 16;
+        palette[i] = Srgb8::new(
+            CUBE_LEVELS[cube / 36],
+            CUBE_LEVELS[(cube / 6) % 6],
+            CUBE_LEVELS[cube % 6],
+        );
+        i += 1;
+    }
+    while i < 256 {
+        let level = 8 + 10 * (i - 232) as u8;
+        palette[i] = Srgb8::new(level, level, level);
+        i += 1;
+    }
+    palette
+}
+
+/// Returns the index of the perceptually closest color cube or gray ramp entry (16-255) to `color`.
+///
+/// Distance is CIEDE2000 ([crate::diffs::distance]), so a tinted gray maps to a tinted cube entry rather than the
+/// neutral ramp entry RGB distance would pick.
+/// The system colors are skipped because terminals commonly remap them, so they would not reproduce the requested
+/// color reliably.
+pub fn nearest_ansi256(color: Srgb8) -> u8 {
+    nearest_in(color, 16..256)
+}
+
+/// Returns the index (0-15) of the perceptually closest standard or bright ANSI color to `color`.
+///
+/// xterm's system colors are fully saturated, so by distance alone a pastel is closer to a gray than to its own hue.
+/// Colors with a chroma of at least [NEUTRAL_CHROMA] are therefore matched among the non-gray entries only, and
+/// grayer colors among black, white, and the two grays.
+pub fn nearest_ansi16(color: Srgb8) -> u8 {
+    nearest_in(color, 0..16)
+}
+
+/// Lab chroma below which [nearest_ansi16] treats a color as gray.
+pub const NEUTRAL_CHROMA: f32 = 20.0;
+
+/// The gray system colors: black, white, bright black, and bright white.
+const NEUTRAL_SYSTEM_COLORS: [usize; 4] = [0, 7, 8, 15];
+
+/// Entries in each thread's table of [nearest_in] answers.
+const NEAREST_SLOTS: usize = 256;
+
+fn nearest_in(color: Srgb8, range: std::ops::Range<usize>) -> u8 {
+    // Formatters ask for the same few theme colors once per token, and each search runs up to 240 ΔE2000
+    // evaluations, so answers are remembered per thread. The table is direct-mapped: a color takes the slot its hash
+    // picks from whichever color held it, so converting arbitrary images or input can't grow it.
+    type Slot = Option<(Srgb8, usize, u8)>;
+    thread_local! {
+        static NEAREST: RefCell<[Slot; NEAREST_SLOTS]> = const { RefCell::new([None; NEAREST_SLOTS]) };
+    }
+    let key = u32::from_be_bytes([range.start as u8, color.r, color.g, color.b]);
+    let slot = (key.wrapping_mul(0x9e37_79b9) >> 24) as usize % NEAREST_SLOTS;
+    NEAREST.with(|table| {
+        let mut table = table.borrow_mut();
+        match table[slot] {
+            Some((cached, start, index)) if cached == color && start == range.start => index,
+            _ => {
+                let index = search_nearest(color, range.clone());
+                table[slot] = Some((color, range.start, index));
+                index
+            }
+        }
+    })
+}
+
+fn search_nearest(color: Srgb8, range: std::ops::Range<usize>) -> u8 {
+    let palette = xterm_lab();
+    let lab = Lab::from(color);
+    let neutral = (lab.a * lab.a + lab.b * lab.b).sqrt() < NEUTRAL_CHROMA;
+    range
+        .filter(|index| *index >= 16 || NEUTRAL_SYSTEM_COLORS.contains(index) == neutral)
+        .min_by(|&a, &b| delta_e_2000(lab, palette[a]).total_cmp(&delta_e_2000(lab, palette[b])))
+        .unwrap_or(0) as u8
+}
+
+/// [XTERM_PALETTE] converted to Lab once.
+fn xterm_lab() -> &'static [Lab; 256] {
+    static LAB: OnceLock<[Lab; 256]> = OnceLock::new();
+    LAB.get_or_init(|| XTERM_PALETTE.map(Lab::from))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn osc11_replies_scale_every_component_width() {
+        let reply = |body: &str| parse_osc11_reply(format!("\x1b]11;{body}\x1b\\\x1b[?62;22c").as_bytes());
+        assert_eq!(reply("rgb:1e1e/1e1e/1e1e"), Some(Srgb8::new(0x1e, 0x1e, 0x1e)));
+        assert_eq!(reply("rgb:f/8/0"), Some(Srgb8::new(255, 136, 0)));
+        assert_eq!(reply("rgb:fff/800/000"), Some(Srgb8::new(255, 128, 0)));
+        assert_eq!(reply("rgba:ffff/ffff/ffff/ffff"), Some(Srgb8::new(255, 255, 255)));
+        assert_eq!(reply("#fdf6e3"), Some(Srgb8::new(0xfd, 0xf6, 0xe3)));
+        assert_eq!(
+            parse_osc11_reply(b"noise\x1b]11;rgb:0000/0000/0000\x07"),
+            Some(Srgb8::new(0, 0, 0))
+        );
+        for bad in ["rgb:12345/0/0", "rgb:zz/00/00", "rgb:00/00", "?"] {
+            assert_eq!(reply(bad), None, "{bad}");
+        }
+        assert_eq!(
+            parse_osc11_reply(b"\x1b[?62;22c"),
+            None,
+            "terminals that ignore the query"
+        );
+    }
+
+    #[test]
+    fn device_attributes_replies_end_the_exchange() {
+        assert!(has_da1_reply(b"\x1b]11;rgb:0/0/0\x07\x1b[?64;1;22c"));
+        assert!(has_da1_reply(b"\x1b[?6c"));
+        assert!(!has_da1_reply(b"\x1b[?64;1;22"));
+        assert!(!has_da1_reply(b"\x1b]11;rgb:0/0/0\x07"));
+    }
+
+    #[test]
+    fn only_screen_gets_passthrough_wrapped_queries() {
+        let query = format!("{OSC11_QUERY}{DA1_QUERY}");
+        assert_eq!(passthrough(&query, false, false), "\x1b]11;?\x07\x1b[c");
+        assert_eq!(passthrough(&query, true, false), query, "tmux answers OSC 11 itself");
+        assert_eq!(passthrough(&query, true, true), query, "tmux with TERM=screen");
+        assert_eq!(passthrough(&query, false, true), "\x1bP\x1b]11;?\x07\x1b[c\x1b\\");
+    }
+
+    #[test]
+    fn backgrounds_classify_by_contrast() {
+        assert_eq!(Background::of(Srgb8::new(0xfd, 0xf6, 0xe3)), Background::Light);
+        assert_eq!(Background::of(Srgb8::new(0x28, 0x2c, 0x34)), Background::Dark);
+        assert_eq!(Background::from_colorfgbg("12;7"), Background::Light);
+        assert_eq!(Background::from_colorfgbg("7;8"), Background::Dark);
+        assert_eq!(Background::from_colorfgbg(""), Background::Unknown);
+    }
+
+    #[test]
+    fn palette_layout_matches_xterm() {
+        assert_eq!(XTERM_PALETTE[16], Srgb8::new(0, 0, 0));
+        assert_eq!(XTERM_PALETTE[196], Srgb8::new(255, 0, 0));
+        assert_eq!(XTERM_PALETTE[231], Srgb8::new(255, 255, 255));
+        assert_eq!(XTERM_PALETTE[232], Srgb8::new(8, 8, 8));
+        assert_eq!(XTERM_PALETTE[255], Srgb8::new(238, 238, 238));
+    }
+
+    #[test]
+    fn nearest_search_prefers_gray_ramp_for_muted_grays() {
+        // Truncating #6c6c6c to the cube would give #5f5f5f; the gray ramp has an exact match.
+        assert_eq!(nearest_ansi256(Srgb8::new(0x6c, 0x6c, 0x6c)), 242);
+        assert_eq!(nearest_ansi16(Srgb8::new(0x80, 0x80, 0x80)), 8);
+    }
+
+    #[test]
+    fn pastels_keep_their_hue_in_sixteen_colors() {
+        let cases = [
+            ("#89b4fa", 12),
+            ("#a6e3a1", 2),
+            ("#f9e2af", 3),
+            ("#cba6f7", 13),
+            ("#94e2d5", 6),
+            ("#cdd6f4", 7),
+            ("#6c7086", 8),
+        ];
+        for (hex, index) in cases {
+            assert_eq!(nearest_ansi16(Srgb8::from_hex(hex).unwrap()), index, "{hex}");
+        }
+    }
+
+    #[test]
+    fn nearest_table_evictions_keep_answers_exact() {
+        // Far more colors than the table holds, twice over, so most lookups follow an eviction.
+        let colors: Vec<Srgb8> = (0..2048u32)
+            .map(|i| Srgb8::new((i * 37) as u8, (i * 11) as u8, (i >> 3) as u8))
+            .collect();
+        for _ in 0..2 {
+            for &color in &colors {
+                assert_eq!(nearest_ansi256(color), search_nearest(color, 16..256), "{color}");
+                assert_eq!(nearest_ansi16(color), search_nearest(color, 0..16), "{color}");
+            }
+        }
+    }
+
+    #[test]
+    fn palette_entries_map_to_themselves() {
+        for (index, &color) in XTERM_PALETTE.iter().enumerate() {
+            let nearest = if index < 16 { nearest_ansi16(color) } else { nearest_ansi256(color) };
+            assert_eq!(nearest as usize, index, "{color}");
+        }
+    }
+
+    #[test]
+    fn tinted_grays_keep_their_tint() {
+        // RGB distance picks the neutral #767676 (243); perceptually the blue-gray #5f5f87 is closer.
+        let overlay = Srgb8::new(0x6c, 0x70, 0x86);
+        assert_eq!(nearest_ansi256(overlay), 60);
+        assert_eq!(XTERM_PALETTE[60], Srgb8::new(0x5f, 0x5f, 0x87));
+    }
+
+    #[test]
+    fn background_params_mirror_foreground_codes() {
+        let red = Srgb8::new(255, 0, 0);
+        assert_eq!(
+            ColorProfile::TrueColor.background_params(red).as_deref(),
+            Some("48;2;255;0;0")
+        );
+        assert_eq!(
+            ColorProfile::Ansi256.background_params(red).as_deref(),
+            Some("48;5;196")
+        );
+        assert_eq!(ColorProfile::Ansi16.background_params(red).as_deref(), Some("101"));
+        assert_eq!(
+            ColorProfile::Ansi16.foreground_params(Srgb8::new(0, 0, 0)).as_deref(),
+            Some("30")
+        );
+        assert_eq!(ColorProfile::NoColor.background_params(red), None);
+    }
+
+    #[test]
+    fn color_conventions_take_precedence_in_order() {
+        let cases = [
+            (None, None, None, None),
+            (Some("1"), None, None, Some(false)),
+            (Some(""), None, None, None),
+            (Some("1"), None, Some("1"), Some(false)),
+            (None, Some("0"), None, Some(false)),
+            (None, Some("1"), None, None),
+            (None, Some("0"), Some("1"), Some(true)),
+            (None, None, Some("0"), None),
+        ];
+        for (no_color, clicolor, force, expected) in cases {
+            assert_eq!(
+                color_override_from_env_values(no_color, clicolor, force),
+                expected,
+                "{no_color:?} {clicolor:?} {force:?}"
+            );
+        }
+    }
+
+    #[test]
+    fn env_detection() {
+        let cases = [
+            (Some("truecolor"), Some("xterm-256color"), ColorProfile::TrueColor),
+            (Some("24bit"), None, ColorProfile::TrueColor),
+            (None, Some("xterm-direct"), ColorProfile::TrueColor),
+            (None, Some("xterm-256color"), ColorProfile::Ansi256),
+            (Some(""), Some("xterm"), ColorProfile::Ansi16),
+            (None, Some("dumb"), ColorProfile::NoColor),
+            (None, None, ColorProfile::NoColor),
+        ];
+        for (colorterm, term, expected) in cases {
+            assert_eq!(
+                ColorProfile::from_env_values(colorterm, term),
+                expected,
+                "{colorterm:?} {term:?}"
+            );
+        }
+    }
+}
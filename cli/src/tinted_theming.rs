@@ -79,6 +79,7 @@ pub enum SchemeError {
     Io { path: PathBuf, source: std::io::Error },
     Parse { path: PathBuf, source: serde_yml::Error },
     Serialize { source: serde_yml::Error },
+    Deserialize { source: serde_yml::Error },
     MissingField(&'static str),
     MissingColor(String),
     InvalidHex { key: String, value: String },
@@ -92,6 +93,7 @@ impl fmt::Display for SchemeError {
             SchemeError::Io { path, source } => write!(f, "failed to read {}: {}", path.display(), source),
             SchemeError::Parse { path, source } => write!(f, "failed to parse {}: {}", path.display(), source),
             SchemeError::Serialize { source } => write!(f, "failed to serialize scheme: {}", source),
+            SchemeError::Deserialize { source } => write!(f, "failed to parse scheme: {}", source),
             SchemeError::MissingField(field) => write!(f, "scheme is missing required field '{field}'"),
             SchemeError::MissingColor(key) => write!(f, "scheme palette missing '{key}'"),
             SchemeError::InvalidHex { key, value } => {
@@ -108,18 +110,89 @@ impl std::error::Error for SchemeError {
         match self {
             SchemeError::Io { source, .. } => Some(source),
             SchemeError::Parse { source, .. } => Some(source),
+            SchemeError::Deserialize { source } => Some(source),
             _ => None,
         }
     }
 }
 
+/// A scheme of either system, as returned by [parse_scheme].
+#[derive(Debug, Clone)]
+pub enum Scheme {
+    Base16(Base16Scheme),
+    Base24(Base24Scheme),
+}
+
+/// Scheme fields in either the tinted-theming layout (`name` plus a `palette` map) or the original Base16 layout
+/// (`scheme` plus top-level `base00` keys).
 #[derive(Debug, Deserialize)]
 struct RawScheme {
     system: Option<String>,
     name: Option<String>,
+    scheme: Option<String>,
     author: Option<String>,
     variant: Option<String>,
-    palette: HashMap<String, String>,
+    #[serde(default)]
+    palette: Slots,
+    /// Every other top-level key, among them the original layout's colors.
+    #[serde(flatten)]
+    legacy: Slots,
+}
+
+impl RawScheme {
+    fn palette(&self) -> &HashMap<String, String> {
+        if self.palette.0.is_empty() { &self.legacy.0 } else { &self.palette.0 }
+    }
+}
+
+/// Palette slots by key, read from a map whose values may be anything.
+///
+/// Strings are kept as they are and integers as six-digit hex, since YAML reads an unquoted `000000` or `282828` as
+/// a number; other values, such as the lists and maps extra keys in the original layout can hold, are skipped.
+#[derive(Debug, Default)]
+struct Slots(HashMap<String, String>);
+
+impl<'de> Deserialize<'de> for Slots {
+    fn deserialize<D: serde::Deserializer<'de>>(deserializer: D) -> Result<Self, D::Error> {
+        let slots = HashMap::<String, Slot>::deserialize(deserializer)?;
+        Ok(Slots(
+            slots
+                .into_iter()
+                .filter_map(|(key, slot)| match slot {
+                    Slot::Text(text) => Some((key, text)),
+                    Slot::Number(number) => Some((key, format!("{number:06}"))),
+                    Slot::Other(_) => None,
+                })
+                .collect(),
+        ))
+    }
+}
+
+/// One value from a [Slots] map.
+#[derive(Deserialize)]
+#[serde(untagged)]
+enum Slot {
+    Text(String),
+    Number(u64),
+    Other(serde::de::IgnoredAny),
+}
+
+/// Parses one scheme from YAML text in either the tinted-theming or the original Base16 layout.
+///
+/// The `system` field picks Base16 or Base24; without one, a palette with `base10` is read as Base24.
+pub fn parse_scheme(yaml: &str) -> Result<Scheme, SchemeError> {
+    let raw: RawScheme = serde_yml::from_str(yaml).map_err(|source| SchemeError::Deserialize { source })?;
+    let base24 = match raw.system.as_deref() {
+        Some("base16") => false,
+        Some("base24") => true,
+        Some(system) => return Err(SchemeError::UnsupportedSystem(system.to_string())),
+        None => raw.palette().contains_key("base10"),
+    };
+    if base24 {
+        parse_base24(raw, PathBuf::new()).map(Scheme::Base24)
+    } else {
+        parse_base16(raw, PathBuf::new()).map(Scheme::Base16)
+    }
 }
 
 /// Loads Base16 schemes from a file or directory path.
@@ -168,7 +241,7 @@ fn parse_file(path: &Path) -> Result<RawScheme, SchemeError> {
 
 fn parse_base16(raw: RawScheme, _: PathBuf) -> Result<Base16Scheme, SchemeError> {
     let metadata = metadata(&raw, "base16")?;
-    let colors = build_palette(&raw.palette, &BASE16_KEYS)?;
+    let colors = build_palette(raw.palette(), &BASE16_KEYS)?;
     let mut array = [Srgb8::new(0, 0, 0); 16];
     array.copy_from_slice(&colors);
     Ok(Base16Scheme { metadata, colors: array })
@@ -176,14 +249,18 @@ fn parse_base16(raw: RawScheme, _: PathBuf) -> Result<Base16Scheme, SchemeError>
 
 fn parse_base24(raw: RawScheme, _: PathBuf) -> Result<Base24Scheme, SchemeError> {
     let metadata = metadata(&raw, "base24")?;
-    let colors = build_palette(&raw.palette, &BASE24_KEYS)?;
+    let colors = build_palette(raw.palette(), &BASE24_KEYS)?;
     let mut array = [Srgb8::new(0, 0, 0); 24];
     array.copy_from_slice(&colors);
     Ok(Base24Scheme { metadata, colors: array })
 }
 
 fn metadata(raw: &RawScheme, expected_system: &str) -> Result<SchemeMetadata, SchemeError> {
-    let name = raw.name.clone().ok_or(SchemeError::MissingField("name"))?;
+    let name = raw
+        .name
+        .clone()
+        .or_else(|| raw.scheme.clone())
+        .ok_or(SchemeError::MissingField("name"))?;
     let system = raw.system.clone().unwrap_or_else(|| expected_system.to_string());
     if system != expected_system {
         return Err(SchemeError::UnsupportedSystem(system));
@@ -227,8 +304,8 @@ pub fn write_base16_scheme(scheme: &Base16Scheme, path: impl AsRef<Path>) -> Res
     let yaml = serde_yml::to_string(&output).map_err(|source| SchemeError::Serialize { source })?;
 
     let path_ref = path.as_ref();
-    let mut file = fs::File::create(path_ref)
-        .map_err(|source| SchemeError::Io { path: path_ref.to_path_buf(), source })?;
+    let mut file =
+        fs::File::create(path_ref).map_err(|source| SchemeError::Io { path: path_ref.to_path_buf(), source })?;
 
     file.write_all(yaml.as_bytes())
         .map_err(|source| SchemeError::Io { path: path_ref.to_path_buf(), source })?;
@@ -254,8 +331,8 @@ pub fn write_base24_scheme(scheme: &Base24Scheme, path: impl AsRef<Path>) -> Res
     let yaml = serde_yml::to_string(&output).map_err(|source| SchemeError::Serialize { source })?;
 
     let path_ref = path.as_ref();
-    let mut file = fs::File::create(path_ref)
-        .map_err(|source| SchemeError::Io { path: path_ref.to_path_buf(), source })?;
+    let mut file =
+        fs::File::create(path_ref).map_err(|source| SchemeError::Io { path: path_ref.to_path_buf(), source })?;
 
     file.write_all(yaml.as_bytes())
         .map_err(|source| SchemeError::Io { path: path_ref.to_path_buf(), source })?;
@@ -296,4 +373,25 @@ mod tests {
         assert_eq!(scheme.colors().len(), 24);
         assert_eq!(scheme.colors()[23], Srgb8::from_hex("#f5bde6").unwrap());
     }
+
+    #[test]
+    fn legacy_slots_accept_unquoted_numbers_and_other_extras() {
+        let scheme = parse_scheme(include_str!("../../examples/base16/legacy-unquoted.yaml")).unwrap();
+        let Scheme::Base16(scheme) = scheme else { panic!("expected a Base16 scheme") };
+        assert_eq!(scheme.metadata.name, "Legacy Unquoted");
+        assert_eq!(scheme.colors()[0], Srgb8::new(0, 0, 0));
+        assert_eq!(scheme.colors()[1], Srgb8::from_hex("#282828").unwrap());
+    }
+
+    #[test]
+    fn slots_keep_strings_and_integers_and_skip_the_rest() {
+        let json = r#"{"scheme": "S", "base00": 0, "base01": 282828, "base02": "383838", "tags": ["a"], "x": 1.5}"#;
+        let raw: RawScheme = serde_json::from_str(json).unwrap();
+        let mut slots: Vec<(&str, &str)> = raw.palette().iter().map(|(k, v)| (k.as_str(), v.as_str())).collect();
+        slots.sort();
+        assert_eq!(
+            slots,
+            [("base00", "000000"), ("base01", "282828"), ("base02", "383838")]
+        );
+    }
 }
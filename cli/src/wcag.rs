@@ -1,8 +1,10 @@
 //! WCAG (Web Content Accessibility Guidelines) color contrast utilities.
 //!
-//! Implements relative luminance and contrast ratio calculations per WCAG 2.1 specification.
+//! Implements relative luminance and contrast ratio calculations per WCAG 2.1 specification, plus minimal
+//! lightness adjustments that bring a foreground up to a required ratio.
 
-use crate::colors::{Rgb, Srgb8};
+use crate::colors::{Hsl, Rgb, Srgb8};
+use std::fmt;
 
 /// WCAG AA minimum contrast ratio for normal text.
 pub const WCAG_AA_NORMAL: f32 = 4.5;
@@ -150,6 +152,98 @@ pub fn choose_accessible_foreground(bg: Srgb8, candidates: &[Srgb8], min_ratio:
         .copied()
 }
 
+/// Error returned by [ensure_contrast] when no lightness of the foreground reaches the required ratio.
+///
+/// This happens against mid-tone backgrounds, where even black and white fall short of high ratios.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct ContrastUnreachable {
+    /// Black or white, whichever contrasts more with the background.
+    pub best: Srgb8,
+    /// Contrast ratio of `best` against the background.
+    pub ratio: f32,
+    /// The ratio that was asked for.
+    pub required: f32,
+}
+
+impl fmt::Display for ContrastUnreachable {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(
+            f,
+            "contrast of {:.2}:1 cannot be reached; {} gets the most at {:.2}:1",
+            self.required, self.best, self.ratio
+        )
+    }
+}
+
+impl std::error::Error for ContrastUnreachable {}
+
+/// Lightens or darkens `fg` as little as possible until it has at least `min_ratio` contrast with `bg`.
+///
+/// Only HSL lightness changes, so the hue and saturation survive. When both directions can reach the ratio, the one
+/// needing the smaller change wins, which usually keeps the foreground on its side of the background. A foreground
+/// that already passes is returned unchanged.
+///
+/// # Errors
+///
+/// Returns [ContrastUnreachable], carrying the best achievable color, when neither direction reaches the ratio.
+///
+/// # Examples
+///
+/// ```
+/// use colorizer::colors::Srgb8;
+/// use colorizer::wcag::{WCAG_AA_NORMAL, contrast_ratio, ensure_contrast};
+///
+/// let bg = Srgb8::new(30, 30, 30);
+/// let comment = ensure_contrast(Srgb8::new(90, 90, 110), bg, WCAG_AA_NORMAL).unwrap();
+/// assert!(contrast_ratio(comment, bg) >= WCAG_AA_NORMAL);
+///
+/// let mid_gray = Srgb8::new(119, 119, 119);
+/// assert!(ensure_contrast(Srgb8::new(100, 100, 100), mid_gray, 7.0).is_err());
+/// ```
+pub fn ensure_contrast(fg: Srgb8, bg: Srgb8, min_ratio: f32) -> Result<Srgb8, ContrastUnreachable> {
+    if contrast_ratio(fg, bg) >= min_ratio {
+        return Ok(fg);
+    }
+    let hsl = fg.to_hsl();
+    let with_lightness = |l: f32| Srgb8::from_hsl(Hsl { l, ..hsl });
+    let passes = |l: f32| contrast_ratio(with_lightness(l), bg) >= min_ratio;
+    // Lightness moves every channel the same way, so luminance is monotonic in it and each direction has a single
+    // threshold to search for.
+    let lighter = passes(1.0).then(|| closest_passing(hsl.l, 1.0, passes));
+    let darker = passes(0.0).then(|| closest_passing(hsl.l, 0.0, passes));
+    let l = match (lighter, darker) {
+        (Some(up), Some(down)) => {
+            if up - hsl.l <= hsl.l - down {
+                up
+            } else {
+                down
+            }
+        }
+        (Some(l), None) | (None, Some(l)) => l,
+        (None, None) => {
+            let (white, black) = (Srgb8::new(255, 255, 255), Srgb8::new(0, 0, 0));
+            let best = if contrast_ratio(white, bg) >= contrast_ratio(black, bg) { white } else { black };
+            return Err(ContrastUnreachable { best, ratio: contrast_ratio(best, bg), required: min_ratio });
+        }
+    };
+    Ok(with_lightness(l))
+}
+
+/// Bisects between a failing lightness `from` and a passing lightness `to` for the passing value closest to `from`.
+fn closest_passing(from: f32, to: f32, passes: impl Fn(f32) -> bool) -> f32 {
+    let (mut fail, mut pass) = (from, to);
+    // 2^-16 is well below one 8-bit step, so the result is the closest color that passes.
+    for _ in 0..16 {
+        let mid = (fail + pass) / 2.0;
+        if passes(mid) {
+            pass = mid;
+        } else {
+            fail = mid;
+        }
+    }
+    pass
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -321,4 +415,57 @@ mod tests {
         assert!(meets_aa_large(ratio));
         assert!(!meets_aa_normal(ratio));
     }
+
+    #[test]
+    fn test_ensure_contrast_keeps_passing_colors() {
+        let bg = Srgb8::new(0, 0, 0);
+        let fg = Srgb8::new(200, 100, 50);
+        assert_eq!(ensure_contrast(fg, bg, WCAG_AA_NORMAL), Ok(fg));
+    }
+
+    #[test]
+    fn test_ensure_contrast_changes_lightness_minimally() {
+        let bg = Srgb8::new(40, 42, 54);
+        let fg = Srgb8::new(98, 114, 164);
+        let fixed = ensure_contrast(fg, bg, WCAG_AA_NORMAL).unwrap();
+        assert!(contrast_ratio(fixed, bg) >= WCAG_AA_NORMAL);
+        assert!(
+            relative_luminance(fixed) > relative_luminance(fg),
+            "should lighten on a dark background"
+        );
+
+        let (hsl, fixed_hsl) = (fg.to_hsl(), fixed.to_hsl());
+        assert!((hsl.h - fixed_hsl.h).abs() < 1.0);
+        let barely_less = Srgb8::from_hsl(Hsl { l: fixed_hsl.l - 0.005, ..fixed_hsl });
+        assert!(contrast_ratio(barely_less, bg) < WCAG_AA_NORMAL);
+    }
+
+    #[test]
+    fn test_ensure_contrast_darkens_on_light_backgrounds() {
+        let bg = Srgb8::new(250, 250, 250);
+        let fg = Srgb8::new(150, 180, 150);
+        let fixed = ensure_contrast(fg, bg, WCAG_AAA_NORMAL).unwrap();
+        assert!(contrast_ratio(fixed, bg) >= WCAG_AAA_NORMAL);
+        assert!(relative_luminance(fixed) < relative_luminance(fg));
+    }
+
+    #[test]
+    fn test_ensure_contrast_crosses_over_when_only_the_other_side_works() {
+        // Against a fairly light gray only black can reach 7:1, even though the foreground is lighter still.
+        let bg = Srgb8::new(170, 170, 170);
+        let fg = Srgb8::new(200, 200, 200);
+        let fixed = ensure_contrast(fg, bg, WCAG_AAA_NORMAL).unwrap();
+        assert!(contrast_ratio(fixed, bg) >= WCAG_AAA_NORMAL);
+        assert!(relative_luminance(fixed) < relative_luminance(bg));
+    }
+
+    #[test]
+    fn test_ensure_contrast_reports_best_effort_when_unreachable() {
+        let bg = Srgb8::new(119, 119, 119);
+        let err = ensure_contrast(Srgb8::new(100, 100, 100), bg, WCAG_AAA_NORMAL).unwrap_err();
+        assert_eq!(err.best, Srgb8::new(0, 0, 0));
+        assert!(approx_eq(err.ratio, contrast_ratio(err.best, bg)));
+        assert!(err.ratio < WCAG_AAA_NORMAL);
+        assert!(err.to_string().contains("7.00:1"));
+    }
 }
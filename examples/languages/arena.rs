@@ -0,0 +1,130 @@
+#![allow(dead_code)]
+//! A typed arena and a tiny tokenizer over borrowed input.
+//!
+//! Exercises lifetimes, raw strings, nested comments, and macros.
+
+use std::collections::HashMap;
+use std::fmt::{self, Display};
+
+/* A block comment /* with a nested one */ that keeps going. */
+
+/// Maximum number of nodes before the arena refuses to grow.
+pub const MAX_NODES: usize = 1_000_000usize;
+static GREETING: &str = r#"He said "hi" and left"#;
+const PATTERN: &[u8] = br##"a "# b"##;
+
+/** Index of a node in an [`Arena`]. */
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+#[repr(transparent)]
+pub struct Id(u32);
+
+#[derive(Debug, Default)]
+pub struct Arena<'src, T> {
+    nodes: Vec<T>,
+    names: HashMap<&'src str, Id>,
+}
+
+impl<'src, T: Display> Arena<'src, T> {
+    pub fn new() -> Self {
+        Self { nodes: Vec::with_capacity(16), names: HashMap::new() }
+    }
+
+    /// Adds `value` under `name`, returning its id.
+    pub fn insert(&mut self, name: &'src str, value: T) -> Result<Id, ArenaError> {
+        if self.nodes.len() >= MAX_NODES {
+            return Err(ArenaError::Full { limit: MAX_NODES });
+        }
+        let id = Id(self.nodes.len() as u32);
+        self.nodes.push(value);
+        self.names.insert(name, id);
+        Ok(id)
+    }
+
+    pub fn get<'a>(&'a self, id: Id) -> Option<&'a T> {
+        self.nodes.get(id.0 as usize)
+    }
+}
+
+#[derive(Debug)]
+pub enum ArenaError {
+    Full { limit: usize },
+}
+
+impl fmt::Display for ArenaError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            ArenaError::Full { limit } => write!(f, "arena is full ({limit} nodes)"),
+        }
+    }
+}
+
+impl std::error::Error for ArenaError {}
+
+macro_rules! token {
+    ($kind:ident, $text:expr) => {
+        Token { kind: Kind::$kind, text: $text }
+    };
+}
+
+#[derive(Debug, PartialEq)]
+enum Kind {
+    Char,
+    Number,
+    Word,
+}
+
+#[derive(Debug, PartialEq)]
+struct Token<'a> {
+    kind: Kind,
+    text: &'a str,
+}
+
+fn tokenize<'a>(input: &'a str) -> Vec<Token<'a>> {
+    let mut tokens = Vec::new();
+    let bytes = input.as_bytes();
+    let mut i = 0;
+    'outer: while i < bytes.len() {
+        let start = i;
+        match bytes[i] {
+            b'0'..=b'9' => {
+                while i < bytes.len() && bytes[i].is_ascii_digit() {
+                    i += 1;
+                }
+                tokens.push(token!(Number, &input[start..i]));
+            }
+            b'\'' | b'\\' => {
+                i += 1;
+                tokens.push(token!(Char, &input[start..i]));
+            }
+            b' ' | b'\t' | b'\n' => i += 1,
+            _ => loop {
+                i += 1;
+                if i == bytes.len() || bytes[i] == b' ' {
+                    tokens.push(token!(Word, &input[start..i]));
+                    continue 'outer;
+                }
+            },
+        }
+    }
+    tokens
+}
+
+fn main() {
+    let mut arena: Arena<'static, f64> = Arena::new();
+    let half = arena.insert("half", 0.5).expect("empty arena");
+    arena.insert("scale", 2.5e-3f64).unwrap();
+    let quote = '\'';
+    let newline = b'\n';
+    let heart = '\u{2764}';
+    let masked = 0xFF_u8 & 0b1010_1010;
+    let pair = (1, (2, 3));
+    println!("{} {:?} {} {newline} {}", GREETING, arena.get(half), quote, heart);
+    assert_eq!(pair.1.0, 2, "tuple fields are numbers, not floats");
+    let words: Vec<_> = tokenize("let x = 42").into_iter().map(|t| t.text).collect::<Vec<&str>>();
+    if masked >> 4 < 0x10 && PATTERN.len() > 3 {
+        eprintln!("{words:?}");
+    }
+    let text = "multi-line \
+                string with a \"quote\"";
+    let _ = (text, r"C:\path", c"nul-terminated", 1..=10, 5.max(3));
+}
@@ -0,0 +1,85 @@
+import React, { useCallback, useEffect, useMemo, useState } from "react";
+import type { ReactNode } from "react";
+
+export interface Column<T> {
+  key: keyof T & string;
+  title: string;
+  width?: number;
+  render?: (row: T, index: number) => ReactNode;
+}
+
+type SortState<T> = { key: keyof T; direction: "asc" | "desc" } | null;
+
+interface TableProps<T extends { id: string | number }> {
+  rows: readonly T[];
+  columns: Column<T>[];
+  filter?: string;
+  onSelect?(row: T): void;
+}
+
+const SLUG = /[^a-z0-9]+/gi;
+
+function useDebounced<T>(value: T, delay = 250): T {
+  const [debounced, setDebounced] = useState<T>(value);
+  useEffect(() => {
+    const timer = setTimeout(() => setDebounced(value), delay);
+    return () => clearTimeout(timer);
+  }, [value, delay]);
+  return debounced;
+}
+
+export function Table<T extends { id: string | number }>({ rows, columns, filter = "", onSelect }: TableProps<T>) {
+  const [sort, setSort] = useState<SortState<T>>(null);
+  const query = useDebounced(filter.trim().toLowerCase());
+
+  const visible = useMemo(() => {
+    const matches = rows.filter((row) =>
+      columns.some((column) => String(row[column.key] ?? "").toLowerCase().includes(query)),
+    );
+    if (!sort) return matches;
+    const sign = sort.direction === "asc" ? 1 : -1;
+    return [...matches].sort((a, b) => (a[sort.key] < b[sort.key] ? -sign : sign));
+  }, [rows, columns, query, sort]);
+
+  const toggle = useCallback((key: keyof T) => {
+    setSort((current) => (current?.key === key && current.direction === "asc" ? { key, direction: "desc" } : { key, direction: "asc" }));
+  }, []);
+
+  const total = visible.length / Math.max(rows.length, 1);
+
+  return (
+    <>
+      {/* Header row */}
+      <table className="table" data-sort={sort?.direction ?? "none"}>
+        <thead>
+          <tr>
+            {columns.map((column) => (
+              <th key={column.key} style={{ width: column.width }} onClick={() => toggle(column.key)}>
+                {column.title}
+                {sort?.key === column.key && <SortIcon direction={sort.direction} />}
+              </th>
+            ))}
+          </tr>
+        </thead>
+        <tbody>
+          {visible.map((row, index) => (
+            <tr key={row.id} id={`row-${String(row.id).replace(SLUG, "-")}`} onClick={() => onSelect?.(row)}>
+              {columns.map((column) => (
+                <td key={column.key}>{column.render ? column.render(row, index) : String(row[column.key])}</td>
+              ))}
+            </tr>
+          ))}
+        </tbody>
+      </table>
+      <p className="summary">
+        Showing {visible.length} of {rows.length} rows &mdash; {`${(total * 100).toFixed(0)}% ${
+          query ? <mark>{`"${query}"`}</mark> : "unfiltered"
+        }`}
+      </p>
+    </>
+  );
+}
+
+function SortIcon({ direction }: { direction: "asc" | "desc" }) {
+  return <span aria-hidden>{direction === "asc" ? "▲" : "▼"}</span>;
+}
@@ -0,0 +1,55 @@
+# f-string edge cases collected from CPython's Lib/test/test_fstring.py (3.13).
+import decimal
+from datetime import datetime
+
+x, y, width, precision, value = 1, 2.5, 10, 4, decimal.Decimal("12.34567")
+d = {"#": "hash", "a": 1, 0: "zero"}
+a = "A"
+
+# Plain replacement fields, conversions, and format specs.
+total = f"total: {x + y:.2f}"
+conversions = f"{a!r} {a!s} {a!a} {a!r:>10}"
+spaced = f'{3!s  }' f'{3!=4!s:.3}'
+literal = f'{{}}' f'{{{{}}}}' f'{{x}}' f'{{{x}}}' f'{"{{}}"}'
+
+# Nested replacement fields inside format specs.
+nested = f'result: {value:{width}.{precision}}'
+deeper = f'result: {value:{width:{0}}.{precision:1}}'
+expr_spec = f'{10:#{3 != {4:5} and width}x}'
+date = f'{datetime(1991, 10, 12):%Y-%m-%d %H:%M}'
+
+# Self-documenting expressions.
+debug = f'{x=}' f'{x = }' f'{x=!r:^20}' f"sadsd {1 + 1 =  :{1 + 1:1d}f}"
+
+# Walrus needs parentheses; bare `:=` starts a format spec.
+walrus = f'{x:=10}' f'{(z:=10)}'
+
+# Lambdas and dictionary displays must be parenthesized or spaced.
+lam = f'{(lambda y: x * y)("8")!r:10}'
+comp = f'expr={ {x: y for x, y in [(1, 2), ]} }'
+dict_lookup = f'{d["a"]}' f"{d['#']}" f'{d[0]}'
+
+# Reused quotes, backslashes, and nested f-strings (PEP 701).
+reused = f"{d["#"]}" f"{'\n'.join(['a', 'b'])}" f'{"\N{LEFT CURLY BRACKET}"}'
+nest = f'{f"{0}"*3}' f"{f"{f"{x}"}"}" f'{f'{f'{y}'}'}'
+escapes = f'\x7b{x}\x7d \t{x}\N{RIGHT CURLY BRACKET}'
+
+# Raw f-strings in every prefix order and case.
+raw = fr'\{{\}}' rf'\d{x}\n' FR'{2}' fR'{x!r}' Rf"\N{x}" rF'{x:>{width}}'
+raw_triple = rf'''{3+
+4}\n'''
+
+# Triple-quoted f-strings spanning lines, with comments in the expression.
+multi = f"""first {x
+    + 1  # comment with { and " inside
+} last { y
+:>{width}} done"""
+both = f'''{
+    d[
+        "a"
+    ]!r:*^{
+        width
+    }}'''
+
+# Bytes, unicode, and conversions of calls.
+others = (b'\x00\n', rb'\d', u'\u00e9', f'{print!r}', f'{"a" "b"}')
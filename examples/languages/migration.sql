@@ -0,0 +1,69 @@
+-- Migration 0007: split addresses out of customers and keep a change log.
+-- Applied with `sqlite3 shop.db < migration.sql`.
+
+PRAGMA foreign_keys = OFF;
+PRAGMA journal_mode = WAL;
+
+BEGIN TRANSACTION;
+
+CREATE TABLE IF NOT EXISTS "addresses" (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    customer_id INTEGER NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    [line 1] TEXT NOT NULL,
+    `postal code` TEXT COLLATE NOCASE,
+    country TEXT NOT NULL DEFAULT 'NZ' CHECK (length(country) = 2),
+    created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+) STRICT;
+
+INSERT INTO addresses (customer_id, [line 1], `postal code`, country)
+SELECT id, street, postcode, coalesce(upper(country_code), 'NZ')
+FROM customers
+WHERE street IS NOT NULL AND street <> '';
+
+/*
+ * SQLite can't drop columns that are part of an index, so rebuild the table
+ * the way https://sqlite.org/lang_altertable.html recommends.
+ */
+CREATE TABLE customers_new (
+    id INTEGER PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    name TEXT,
+    balance_cents INTEGER NOT NULL DEFAULT 0,
+    ratio REAL DEFAULT 1.5e-2,
+    flags INTEGER DEFAULT 0x0F
+) WITHOUT ROWID;
+
+INSERT INTO customers_new (id, email, name, balance_cents)
+SELECT id, lower(email), name || ' (' || id || ')', CAST(balance * 100 AS INTEGER)
+FROM customers;
+
+DROP TABLE customers;
+ALTER TABLE customers_new RENAME TO customers;
+
+CREATE TABLE change_log (
+    id INTEGER PRIMARY KEY,
+    entity TEXT NOT NULL,
+    entity_id INTEGER NOT NULL,
+    note TEXT,
+    at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER addresses_log AFTER UPDATE OF country ON addresses
+FOR EACH ROW WHEN old.country IS NOT new.country
+BEGIN
+    INSERT INTO change_log (entity, entity_id, note)
+    VALUES ('address', new.id, printf('country %s -> %s', old.country, new.country));
+END;
+
+CREATE INDEX IF NOT EXISTS addresses_customer_idx ON addresses (customer_id);
+
+-- Parameters used by the application's queries against the new schema.
+SELECT * FROM addresses WHERE customer_id = ?1 AND country = :country;
+UPDATE customers SET balance_cents = balance_cents + @delta WHERE id = $id;
+INSERT INTO change_log (entity, entity_id, note) VALUES ('customer', ?, 'it''s done')
+    ON CONFLICT DO NOTHING;
+
+PRAGMA user_version = 7;
+PRAGMA foreign_keys = ON;
+
+COMMIT;
@@ -0,0 +1,129 @@
+// query.hpp - a small compile-time checked SQL query builder.
+//
+// Copyright (c) 2024 The Query Authors. Licensed under the MIT license.
+
+#pragma once
+
+#include <cstdint>
+#include <optional>
+#include <string_view>
+#include <type_traits>
+#include "query/config.h"
+
+#if defined(QUERY_SHARED) && !defined(QUERY_STATIC)
+#  if defined(_WIN32)
+#    define QUERY_API __declspec(dllexport)
+#  else
+#    define QUERY_API __attribute__((visibility("default")))
+#  endif
+#else
+#  define QUERY_API
+#endif
+
+#define QUERY_VERSION_MAJOR 2
+#define QUERY_VERSION_MINOR 14
+#define QUERY_VERSION ((QUERY_VERSION_MAJOR << 16) | QUERY_VERSION_MINOR)
+
+#define QUERY_STRINGIFY_(x) #x
+#define QUERY_STRINGIFY(x) QUERY_STRINGIFY_(x)
+#define QUERY_CONCAT(a, b) a##b
+
+/* Checks a condition in debug builds, reporting where it failed. */
+#ifndef NDEBUG
+#  define QUERY_ASSERT(cond, msg)                                        \
+    do {                                                                 \
+      if (!(cond)) {                                                     \
+        ::query::detail::assert_failed(#cond, msg, __FILE__, __LINE__);  \
+      }                                                                  \
+    } while (false)
+#else
+#  define QUERY_ASSERT(cond, msg) ((void)0)
+#endif
+
+namespace query {
+
+inline constexpr std::uint32_t version = QUERY_VERSION;
+inline constexpr std::size_t max_columns = 1'024;
+inline constexpr std::uint64_t page_mask = 0xFFFF'F000ULL;
+
+namespace detail {
+
+[[noreturn]] QUERY_API void assert_failed(const char* cond, const char* msg, const char* file, int line);
+
+template <typename T>
+concept Column = requires(T column) {
+  { column.name() } -> std::convertible_to<std::string_view>;
+  typename T::value_type;
+};
+
+}  // namespace detail
+
+/// A column of a table, typed by the values it holds.
+template <typename T>
+class Col final {
+ public:
+  using value_type = T;
+
+  constexpr explicit Col(std::string_view name) noexcept : name_(name) {}
+
+  [[nodiscard]] constexpr std::string_view name() const noexcept { return name_; }
+
+  template <typename U>
+    requires std::is_convertible_v<U, T>
+  constexpr auto operator==(U&& value) const {
+    return Predicate<T>{name_, '=', static_cast<T>(std::forward<U>(value))};
+  }
+
+ private:
+  std::string_view name_;
+};
+
+/** The schema the queries in this header were written against. */
+inline constexpr std::string_view schema = R"sql(
+CREATE TABLE users (
+  id      INTEGER PRIMARY KEY,
+  name    TEXT NOT NULL,   -- may contain ")" and "quotes"
+  created TIMESTAMP DEFAULT (datetime('now'))
+);
+)sql";
+
+inline constexpr auto pattern = R"re(^[a-z_][a-z0-9_]*\.(\w+)$)re";
+inline constexpr wchar_t separator = L'\x2028';
+inline constexpr char newline = '\n';
+
+template <detail::Column... Columns>
+struct Select {
+  std::tuple<Columns...> columns;
+
+  template <std::size_t N>
+  constexpr auto& get() noexcept {
+    static_assert(N < sizeof...(Columns), "column index out of range");
+    return std::get<N>(columns);
+  }
+};
+
+template <typename... Columns>
+Select(Columns...) -> Select<Columns...>;
+
+/// Runs a query on a worker, resuming the caller when the rows arrive.
+template <typename Row>
+task<std::optional<Row>> fetch_one(connection& conn, std::string_view sql) {
+  auto rows = co_await conn.execute(sql);
+  QUERY_ASSERT(rows.size() <= 1, "expected at most one row");
+  if (rows.empty()) co_return std::nullopt;
+  co_return rows.front().template as<Row>();
+}
+
+namespace literals {
+
+constexpr Col<std::int64_t> operator""_col(const char* name, std::size_t size) noexcept {
+  return Col<std::int64_t>{std::string_view{name, size}};
+}
+
+}  // namespace literals
+
+}  // namespace query
+
+#if __has_include(<query/extensions.hpp>)
+#  include <query/extensions.hpp>
+#endif
@@ -0,0 +1,29 @@
+defmodule Colorizer.Palette do
+  @moduledoc """
+  Builds palettes from a #{@base} color.
+  """
+
+  alias Colorizer.{Color, Harmony}
+
+  @default_steps 5
+
+  # Returns `steps` evenly spaced hues.
+  def hues(%Color{hue: hue} = color, steps \\ @default_steps) when steps > 0 do
+    for i <- 0..(steps - 1) do
+      %{color | hue: rem(hue + div(360, steps) * i, 360)}
+    end
+  end
+
+  defp label(:warm), do: "warm #{?w}"
+  defp label(:cool), do: 'cool'
+
+  def parse!(input) do
+    case Regex.run(~r/^#([\da-f]{6})$/i, input) do
+      [_, hex] -> {:ok, String.to_integer(hex, 16)}
+      nil -> raise ArgumentError, message: "bad color: \"#{input}\""
+    end
+  end
+
+  def demo, do: Enum.map(~w{red green}, &String.upcase/1) |> Enum.join(", ")
+  def ratio, do: 1_000 / 3.5e2 + 0xFF
+end
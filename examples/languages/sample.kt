@@ -0,0 +1,30 @@
+package dev.stormlight.colorizer
+
+import kotlin.math.roundToInt
+
+/* Palette helpers. /* nested */ still a comment */
+@JvmInline
+value class Hue(val degrees: Int)
+
+data class Swatch(val name: String, val hue: Hue, val alpha: Double = 1.0)
+
+// Spreads `count` hues around the wheel.
+fun String.swatches(count: Int = 5): List<Swatch> {
+    require(count > 0) { "count must be positive, was $count" }
+    val step = 360 / count
+    return (0 until count).map { i ->
+        Swatch("$this-${i + 1}", Hue((i * step) % 360), alpha = 0.5f.toDouble())
+    }
+}
+
+fun main() {
+    val banner = """
+        |Swatches for ${"teal".uppercase()}:
+        |  — raw \n stays
+    """.trimMargin()
+    println(banner)
+    for (s in "teal".swatches()) println("${s.name}\t#${s.hue.degrees.toString(16)}")
+    val initial = 'k'
+    if (initial != '\n' && initial in 'a'..'z') println(0xFF_EC or 0b1010L.toInt())
+    val level = (initial.code * 0.75).roundToInt()
+}
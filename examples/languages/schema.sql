@@ -0,0 +1,233 @@
+--
+-- PostgreSQL database dump
+--
+
+\restrict ZcHn2fJd7nTqL0aYvWb8
+
+-- Dumped from database version 16.4
+-- Dumped by pg_dump version 16.4
+
+SET statement_timeout = 0;
+SET lock_timeout = 0;
+SET idle_in_transaction_session_timeout = 0;
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+SELECT pg_catalog.set_config('search_path', '', false);
+SET check_function_bodies = false;
+SET xmloption = content;
+SET client_min_messages = warning;
+SET row_security = off;
+
+--
+-- Name: shop; Type: SCHEMA; Schema: -; Owner: shop_owner
+--
+
+CREATE SCHEMA shop;
+
+
+ALTER SCHEMA shop OWNER TO shop_owner;
+
+--
+-- Name: pgcrypto; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public;
+
+
+--
+-- Name: order_status; Type: TYPE; Schema: shop; Owner: shop_owner
+--
+
+CREATE TYPE shop.order_status AS ENUM (
+    'pending',
+    'paid',
+    'shipped',
+    'cancelled'
+);
+
+
+ALTER TYPE shop.order_status OWNER TO shop_owner;
+
+--
+-- Name: touch_updated_at(); Type: FUNCTION; Schema: shop; Owner: shop_owner
+--
+
+CREATE FUNCTION shop.touch_updated_at() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    NEW.updated_at := now();
+    RETURN NEW;
+END;
+$$;
+
+
+ALTER FUNCTION shop.touch_updated_at() OWNER TO shop_owner;
+
+--
+-- Name: order_total(bigint); Type: FUNCTION; Schema: shop; Owner: shop_owner
+--
+
+CREATE FUNCTION shop.order_total(p_order_id bigint) RETURNS numeric
+    LANGUAGE sql STABLE
+    AS $_$
+    SELECT COALESCE(SUM(li.quantity * li.unit_price), 0)::numeric(12,2)
+    FROM shop.line_items li
+    WHERE li.order_id = $1
+$_$;
+
+
+ALTER FUNCTION shop.order_total(p_order_id bigint) OWNER TO shop_owner;
+
+SET default_tablespace = '';
+
+SET default_table_access_method = heap;
+
+--
+-- Name: customers; Type: TABLE; Schema: shop; Owner: shop_owner
+--
+
+CREATE TABLE shop.customers (
+    id bigint NOT NULL,
+    email text NOT NULL,
+    display_name character varying(120),
+    api_key uuid DEFAULT gen_random_uuid() NOT NULL,
+    metadata jsonb DEFAULT '{}'::jsonb NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT customers_email_check CHECK ((email ~~* '%@%'::text))
+);
+
+
+ALTER TABLE shop.customers OWNER TO shop_owner;
+
+--
+-- Name: COLUMN customers.display_name; Type: COMMENT; Schema: shop; Owner: shop_owner
+--
+
+COMMENT ON COLUMN shop.customers.display_name IS 'Shown on invoices; defaults to the part of the email before the ''@''.';
+
+
+--
+-- Name: customers_id_seq; Type: SEQUENCE; Schema: shop; Owner: shop_owner
+--
+
+CREATE SEQUENCE shop.customers_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE shop.customers_id_seq OWNER TO shop_owner;
+
+--
+-- Name: customers_id_seq; Type: SEQUENCE OWNED BY; Schema: shop; Owner: shop_owner
+--
+
+ALTER SEQUENCE shop.customers_id_seq OWNED BY shop.customers.id;
+
+
+--
+-- Name: orders; Type: TABLE; Schema: shop; Owner: shop_owner
+--
+
+CREATE TABLE shop.orders (
+    id bigint NOT NULL,
+    customer_id bigint NOT NULL,
+    status shop.order_status DEFAULT 'pending'::shop.order_status NOT NULL,
+    tags text[] DEFAULT ARRAY[]::text[],
+    placed_at timestamp with time zone,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE shop.orders OWNER TO shop_owner;
+
+--
+-- Name: line_items; Type: TABLE; Schema: shop; Owner: shop_owner
+--
+
+CREATE TABLE shop.line_items (
+    order_id bigint NOT NULL,
+    sku text NOT NULL,
+    quantity integer DEFAULT 1 NOT NULL,
+    unit_price numeric(10,2) NOT NULL,
+    CONSTRAINT line_items_quantity_check CHECK ((quantity > 0))
+);
+
+
+ALTER TABLE shop.line_items OWNER TO shop_owner;
+
+--
+-- Name: paid_orders; Type: VIEW; Schema: shop; Owner: shop_owner
+--
+
+CREATE VIEW shop.paid_orders AS
+ SELECT o.id,
+    c.email,
+    shop.order_total(o.id) AS total,
+    (o.metadata ->> 'channel'::text) AS channel
+   FROM (shop.orders o
+     JOIN shop.customers c ON ((c.id = o.customer_id)))
+  WHERE (o.status = 'paid'::shop.order_status);
+
+
+ALTER VIEW shop.paid_orders OWNER TO shop_owner;
+
+--
+-- Name: customers id; Type: DEFAULT; Schema: shop; Owner: shop_owner
+--
+
+ALTER TABLE ONLY shop.customers ALTER COLUMN id SET DEFAULT nextval('shop.customers_id_seq'::regclass);
+
+
+--
+-- Name: customers customers_pkey; Type: CONSTRAINT; Schema: shop; Owner: shop_owner
+--
+
+ALTER TABLE ONLY shop.customers
+    ADD CONSTRAINT customers_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: line_items line_items_pkey; Type: CONSTRAINT; Schema: shop; Owner: shop_owner
+--
+
+ALTER TABLE ONLY shop.line_items
+    ADD CONSTRAINT line_items_pkey PRIMARY KEY (order_id, sku);
+
+
+--
+-- Name: customers_lower_email_idx; Type: INDEX; Schema: shop; Owner: shop_owner
+--
+
+CREATE UNIQUE INDEX customers_lower_email_idx ON shop.customers USING btree (lower(email));
+
+
+--
+-- Name: orders orders_touch_updated_at; Type: TRIGGER; Schema: shop; Owner: shop_owner
+--
+
+CREATE TRIGGER orders_touch_updated_at BEFORE UPDATE ON shop.orders FOR EACH ROW EXECUTE FUNCTION shop.touch_updated_at();
+
+
+--
+-- Name: line_items line_items_order_id_fkey; Type: FK CONSTRAINT; Schema: shop; Owner: shop_owner
+--
+
+ALTER TABLE ONLY shop.line_items
+    ADD CONSTRAINT line_items_order_id_fkey FOREIGN KEY (order_id) REFERENCES shop.orders(id) ON DELETE CASCADE;
+
+
+/* Grants are dumped last /* after every object */ so they can refer to them. */
+GRANT USAGE ON SCHEMA shop TO reporting;
+GRANT SELECT ON TABLE shop.paid_orders TO reporting;
+
+
+--
+-- PostgreSQL database dump complete
+--
+
+\unrestrict ZcHn2fJd7nTqL0aYvWb8
+
@@ -0,0 +1,116 @@
+// JavaScript wrapper for the colorizer WebAssembly module built from this crate for wasm32-wasip1.
+//
+// The module needs a WASI preview 1 implementation for its standard library: node:wasi under Node, or a shim with
+// the same `wasiImport` and `initialize(instance)` shape in browsers. Instances are created synchronously, so outside
+// Node load it in a worker, where browsers allow compiling large modules that way.
+
+const encoder = new TextEncoder();
+const decoder = new TextDecoder();
+
+/** Raised for a failed call, with the module's error message, or its panic message when it trapped. */
+export class ColorizerError extends Error {
+  constructor(message, options) {
+    super(message, options);
+    this.name = "ColorizerError";
+  }
+}
+
+export class Colorizer {
+  #module;
+  #newWasi;
+  #exports;
+
+  /**
+   * Compiles the module from `bytes` and instantiates it.
+   *
+   * `newWasi` returns a fresh WASI instance, such as `() => new WASI({ version: "preview1" })` from node:wasi; one is
+   * made for the first instance and for each one started after a panic.
+   */
+  static async load(bytes, newWasi) {
+    return new Colorizer(await WebAssembly.compile(bytes), newWasi);
+  }
+
+  constructor(module, newWasi) {
+    this.#module = module;
+    this.#newWasi = newWasi;
+    this.#instantiate();
+  }
+
+  /**
+   * Highlights `source` as `language` with one of `listThemes()`, the first when `theme` is empty, rendered as
+   * "html", "html-classes", or "css".
+   */
+  highlight(source, language, theme = "", format = "html") {
+    const exports = this.#exports;
+    const args = [];
+    try {
+      for (const text of [source, language, theme, format]) {
+        this.#write(text, args);
+      }
+      return this.#call((exports) => exports.colorizer_highlight(...args.flatMap(({ ptr, len }) => [ptr, len])));
+    } finally {
+      // Frees whatever was allocated before a failure, unless a trap replaced the instance, taking its memory along.
+      if (exports === this.#exports) {
+        args.forEach(({ ptr, capacity }) => exports.colorizer_free(ptr, capacity));
+      }
+    }
+  }
+
+  /** Names of the languages `highlight` accepts. */
+  listLanguages() {
+    return this.#call((exports) => exports.colorizer_languages()).split("\n");
+  }
+
+  /** Names of the bundled themes, the default first. */
+  listThemes() {
+    return this.#call((exports) => exports.colorizer_themes()).split("\n");
+  }
+
+  #instantiate() {
+    const wasi = this.#newWasi();
+    const instance = new WebAssembly.Instance(this.#module, { wasi_snapshot_preview1: wasi.wasiImport });
+    wasi.initialize(instance);
+    this.#exports = instance.exports;
+  }
+
+  /**
+   * Encodes `text` into a buffer allocated in the module's memory, the only copy made of it, adding the buffer to
+   * `buffers` as soon as it is allocated so the caller can free it even if encoding throws.
+   */
+  #write(text, buffers) {
+    const exports = this.#exports;
+    // UTF-8 takes at most three bytes for each UTF-16 code unit.
+    const capacity = text.length * 3;
+    const buffer = { ptr: exports.colorizer_alloc(capacity) >>> 0, len: 0, capacity };
+    buffers.push(buffer);
+    buffer.len = encoder.encodeInto(text, new Uint8Array(exports.memory.buffer, buffer.ptr, capacity)).written;
+  }
+
+  /** Runs an export and returns its output, throwing its error, or its panic after replacing the trapped instance. */
+  #call(run) {
+    const exports = this.#exports;
+    let status;
+    try {
+      status = run(exports);
+    } catch (err) {
+      if (!(err instanceof WebAssembly.RuntimeError)) {
+        throw err;
+      }
+      const message = this.#output(exports) || err.message;
+      this.#instantiate();
+      throw new ColorizerError(message, { cause: err });
+    }
+    const output = this.#output(exports);
+    if (status !== 0) {
+      throw new ColorizerError(output);
+    }
+    return output;
+  }
+
+  #output(exports) {
+    // Pointers and lengths come back as signed 32-bit integers.
+    const ptr = exports.colorizer_output_ptr() >>> 0;
+    const len = exports.colorizer_output_len() >>> 0;
+    return decoder.decode(new Uint8Array(exports.memory.buffer, ptr, len));
+  }
+}
@@ -0,0 +1,142 @@
+//! The exports `colorizer.js` calls, with strings passed as UTF-8 in linear memory.
+//!
+//! The caller allocates a buffer with [colorizer_alloc] for each argument and encodes the string straight into it,
+//! and the call borrows the bytes where they are, so the source crosses the boundary once. Results and error messages
+//! go to an output buffer, read with [colorizer_output_ptr] and [colorizer_output_len], that holds them until the
+//! next call.
+//!
+//! A panic aborts on WebAssembly, trapping the call; the panic hook puts its message in the output buffer first, so
+//! the wrapper can report it and start a fresh instance.
+
+use std::alloc::{Layout, alloc, dealloc};
+use std::panic;
+use std::ptr::NonNull;
+use std::sync::{Mutex, Once};
+
+/// Status a call returns when it succeeded, leaving its result in the output buffer.
+const OK: u32 = 0;
+/// Status a call returns when it failed, leaving the error message in the output buffer.
+const ERR: u32 = 1;
+
+static OUTPUT: Mutex<String> = Mutex::new(String::new());
+
+/// Replaces the output buffer's contents.
+fn set_output(text: String) {
+    *OUTPUT.lock().unwrap_or_else(|err| err.into_inner()) = text;
+}
+
+/// Clears the output buffer and installs the panic hook, once, before every call.
+fn begin() {
+    static HOOK: Once = Once::new();
+    HOOK.call_once(|| {
+        panic::set_hook(Box::new(|info| {
+            // The lock is only held while the buffer is replaced, which can't panic, so this finds it free.
+            if let Ok(mut output) = OUTPUT.try_lock() {
+                *output = format!("colorizer panicked: {info}");
+            }
+        }))
+    });
+    set_output(String::new());
+}
+
+/// Borrows each pointer and length pair as UTF-8.
+///
+/// # Safety
+///
+/// Unless its length is zero, each pointer must point to that many initialized bytes that stay untouched for the
+/// returned lifetime.
+unsafe fn texts<'a, const N: usize>(buffers: [(*const u8, usize); N]) -> Result<[&'a str; N], String> {
+    let mut texts = [""; N];
+    for (text, &(ptr, len)) in texts.iter_mut().zip(&buffers) {
+        if len > 0 {
+            let bytes = unsafe { std::slice::from_raw_parts(ptr, len) };
+            *text = std::str::from_utf8(bytes).map_err(|err| format!("argument is not UTF-8: {err}"))?;
+        }
+    }
+    Ok(texts)
+}
+
+/// Leaves `result` in the output buffer and returns its status.
+fn finish(result: Result<String, String>) -> u32 {
+    let status = if result.is_ok() { OK } else { ERR };
+    set_output(result.unwrap_or_else(|message| message));
+    status
+}
+
+/// Allocates `len` bytes for an argument, to be freed with [colorizer_free].
+#[unsafe(no_mangle)]
+pub extern "C" fn colorizer_alloc(len: usize) -> *mut u8 {
+    match Layout::array::<u8>(len) {
+        Ok(layout) if len > 0 => unsafe { alloc(layout) },
+        _ => NonNull::dangling().as_ptr(),
+    }
+}
+
+/// Frees a buffer from [colorizer_alloc].
+///
+/// # Safety
+///
+/// `ptr` and `len` must be a pointer [colorizer_alloc] returned and the length it was given.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn colorizer_free(ptr: *mut u8, len: usize) {
+    if let Ok(layout) = Layout::array::<u8>(len)
+        && len > 0
+    {
+        unsafe { dealloc(ptr, layout) }
+    }
+}
+
+/// Start of the output buffer.
+#[unsafe(no_mangle)]
+pub extern "C" fn colorizer_output_ptr() -> *const u8 {
+    OUTPUT.lock().unwrap_or_else(|err| err.into_inner()).as_ptr()
+}
+
+/// Length of the output buffer in bytes.
+#[unsafe(no_mangle)]
+pub extern "C" fn colorizer_output_len() -> usize {
+    OUTPUT.lock().unwrap_or_else(|err| err.into_inner()).len()
+}
+
+/// Highlights the source with [crate::highlight], leaving the result or the error message in the output buffer.
+///
+/// With the `test-panic` feature, the language `test-panic` panics instead, so the wrapper's tests can trap a call.
+///
+/// # Safety
+///
+/// Each pointer and length pair must describe a buffer as [texts] requires.
+#[unsafe(no_mangle)]
+#[allow(clippy::too_many_arguments)]
+pub unsafe extern "C" fn colorizer_highlight(
+    source: *const u8, source_len: usize, language: *const u8, language_len: usize, theme: *const u8, theme_len: usize,
+    format: *const u8, format_len: usize,
+) -> u32 {
+    begin();
+    let buffers = [
+        (source, source_len),
+        (language, language_len),
+        (theme, theme_len),
+        (format, format_len),
+    ];
+    finish(unsafe { texts(buffers) }.and_then(|[source, language, theme, format]| {
+        #[cfg(feature = "test-panic")]
+        if language == "test-panic" {
+            panic!("asked to panic by the test-panic language");
+        }
+        crate::highlight(source, language, theme, format).map_err(|err| err.to_string())
+    }))
+}
+
+/// Leaves the names from [crate::languages] in the output buffer, one per line.
+#[unsafe(no_mangle)]
+pub extern "C" fn colorizer_languages() -> u32 {
+    begin();
+    finish(Ok(crate::languages().join("\n")))
+}
+
+/// Leaves the names from [crate::themes] in the output buffer, one per line.
+#[unsafe(no_mangle)]
+pub extern "C" fn colorizer_themes() -> u32 {
+    begin();
+    finish(Ok(crate::themes().join("\n")))
+}
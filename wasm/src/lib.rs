@@ -0,0 +1,224 @@
+//! WebAssembly build of the highlighter, for highlighting code to HTML from JavaScript.
+//!
+//! The functions here hold the logic so it can be tested natively; the module built for `wasm32-wasip1` exports them
+//! over linear memory (see `ffi.rs`), and `colorizer.js` wraps those exports for JavaScript. Every call shares the
+//! global [Registry] and the themes parsed by earlier calls.
+
+use colorizer::highlight::formatters::HtmlFormatter;
+use colorizer::highlight::lexers::Registry;
+use colorizer::highlight::themes::{ThemeError, load_base16, load_tmtheme, load_vscode};
+use colorizer::highlight::{HighlightError, Theme};
+
+use std::fmt;
+use std::sync::OnceLock;
+
+#[cfg(target_family = "wasm")]
+mod ffi;
+
+/// Prefix of the class names the `html-classes` and `css` formats use.
+pub const CLASS_PREFIX: &str = "clz-";
+
+/// Names [highlight] accepts as its format: inline-styled HTML, HTML with class names, and the stylesheet for them.
+pub const FORMATS: [&str; 3] = ["html", "html-classes", "css"];
+
+/// Errors raised by [highlight].
+#[derive(Debug)]
+pub enum Error {
+    UnknownLanguage(String),
+    UnknownTheme(String),
+    UnknownFormat(String),
+    Theme(ThemeError),
+    Highlight(HighlightError),
+}
+
+impl fmt::Display for Error {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            Error::UnknownLanguage(name) => write!(f, "unknown language {name:?}"),
+            Error::UnknownTheme(name) => write!(f, "unknown theme {name:?}"),
+            Error::UnknownFormat(name) => write!(f, "unknown format {name:?}, expected one of {}", FORMATS.join(", ")),
+            Error::Theme(source) => write!(f, "{source}"),
+            Error::Highlight(source) => write!(f, "{source}"),
+        }
+    }
+}
+
+impl std::error::Error for Error {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        match self {
+            Error::Theme(source) => Some(source),
+            Error::Highlight(source) => Some(source),
+            _ => None,
+        }
+    }
+}
+
+/// A theme bundled into the module, in the format it was written in.
+enum Source {
+    Base16(&'static str),
+    TmTheme(&'static str),
+    VsCode(&'static str),
+}
+
+impl Source {
+    fn load(&self) -> Result<Theme, ThemeError> {
+        match self {
+            Source::Base16(text) => load_base16(text.as_bytes()),
+            Source::TmTheme(text) => load_tmtheme(text.as_bytes()),
+            Source::VsCode(text) => load_vscode(text.as_bytes()),
+        }
+    }
+}
+
+/// The bundled themes by name, the first being the default.
+const THEMES: [(&str, Source); 8] = [
+    (
+        "catppuccin-mocha",
+        Source::Base16(include_str!("../../examples/base24/catppuccin-mocha.yml")),
+    ),
+    (
+        "catppuccin-macchiato",
+        Source::Base16(include_str!("../../examples/base24/catppuccin-macchiato.yml")),
+    ),
+    (
+        "catppuccin-frappe",
+        Source::Base16(include_str!("../../examples/base24/catppuccin-frappe.yml")),
+    ),
+    (
+        "catppuccin-latte",
+        Source::Base16(include_str!("../../examples/base24/catppuccin-latte.yml")),
+    ),
+    (
+        "oxocarbon-dark",
+        Source::Base16(include_str!("../../examples/base16/oxocarbon-dark.yml")),
+    ),
+    (
+        "oxocarbon-light",
+        Source::Base16(include_str!("../../examples/base16/oxocarbon-light.yml")),
+    ),
+    (
+        "monokai",
+        Source::TmTheme(include_str!("../../examples/themes/monokai.tmTheme")),
+    ),
+    (
+        "dark-vs",
+        Source::VsCode(include_str!("../../examples/themes/dark_vs.json")),
+    ),
+];
+
+/// Themes parsed so far, by their index in [THEMES].
+static LOADED: [OnceLock<Theme>; THEMES.len()] = [const { OnceLock::new() }; THEMES.len()];
+
+/// Highlights `source` as `language` with the bundled `theme`, rendered as `format` (one of [FORMATS]).
+///
+/// Languages are looked up in [Registry::global] by name or alias, like [colorizer::highlight::lexers::find]. An
+/// empty theme picks the first of [themes]; the `css` format ignores the source and returns the theme's stylesheet.
+///
+/// # Examples
+///
+/// ```
+/// let html = colorizer_wasm::highlight("x := 1\n", "go", "monokai", "html").unwrap();
+/// assert!(html.starts_with("<pre"));
+/// assert!(colorizer_wasm::highlight("x", "go", "nope", "html").is_err());
+/// ```
+pub fn highlight(source: &str, language: &str, theme: &str, format: &str) -> Result<String, Error> {
+    let theme = bundled_theme(theme)?;
+    let formatter = match format {
+        "html" => HtmlFormatter::new(),
+        "html-classes" | "css" => HtmlFormatter::new().with_classes(CLASS_PREFIX),
+        _ => return Err(Error::UnknownFormat(format.to_string())),
+    };
+    if format == "css" {
+        return Ok(formatter.css(theme));
+    }
+    let lexer = Registry::global()
+        .get(language)
+        .ok_or_else(|| Error::UnknownLanguage(language.to_string()))?;
+    colorizer::highlight::highlight(source, lexer, theme, &formatter).map_err(Error::Highlight)
+}
+
+/// Names of the languages [highlight] accepts, sorted; see [Registry::names].
+pub fn languages() -> Vec<String> {
+    Registry::global().names()
+}
+
+/// Names of the bundled themes, the default first.
+pub fn themes() -> Vec<&'static str> {
+    THEMES.iter().map(|&(name, _)| name).collect()
+}
+
+fn bundled_theme(name: &str) -> Result<&'static Theme, Error> {
+    let index = match name {
+        "" => 0,
+        _ => THEMES
+            .iter()
+            .position(|&(theme, _)| theme.eq_ignore_ascii_case(name))
+            .ok_or_else(|| Error::UnknownTheme(name.to_string()))?,
+    };
+    if let Some(theme) = LOADED[index].get() {
+        return Ok(theme);
+    }
+    let theme = THEMES[index].1.load().map_err(Error::Theme)?;
+    Ok(LOADED[index].get_or_init(|| theme))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn go_sample_matches_golden_html() {
+        const GOLDEN: &str = "../examples/golden/sample.go.html";
+        let source = include_str!("../../examples/languages/sample.go");
+        let actual = highlight(source, "go", "monokai", "html").unwrap();
+        if std::env::var_os("UPDATE_GOLDEN").is_some() {
+            std::fs::write(GOLDEN, &actual).unwrap();
+        }
+        let expected = std::fs::read_to_string(GOLDEN).unwrap();
+        assert_eq!(actual, expected, "rerun with UPDATE_GOLDEN=1 to accept changes");
+    }
+
+    #[test]
+    fn formats_share_one_parsed_theme() {
+        let classes = highlight("fn main() {}\n", "rust", "MONOKAI", "html-classes").unwrap();
+        assert!(classes.contains(r#"class="clz-"#));
+        let css = highlight("ignored", "not-a-language", "monokai", "css").unwrap();
+        assert!(css.contains(".clz-"));
+        assert!(std::ptr::eq(
+            bundled_theme("monokai").unwrap(),
+            bundled_theme("Monokai").unwrap()
+        ));
+    }
+
+    #[test]
+    fn unknown_names_are_reported() {
+        let messages: Vec<String> = [
+            ("go", "monokai", "png"),
+            ("cobol-85", "monokai", "html"),
+            ("go", "nope", "html"),
+        ]
+        .iter()
+        .map(|&(language, theme, format)| highlight("", language, theme, format).unwrap_err().to_string())
+        .collect();
+        assert_eq!(
+            messages,
+            [
+                r#"unknown format "png", expected one of html, html-classes, css"#,
+                r#"unknown language "cobol-85""#,
+                r#"unknown theme "nope""#,
+            ]
+        );
+    }
+
+    #[test]
+    fn lists_languages_and_themes() {
+        let languages = languages();
+        assert!(
+            ["Go", "Python", "Rust"]
+                .iter()
+                .all(|name| languages.iter().any(|language| language == name))
+        );
+        assert_eq!(themes()[0], "catppuccin-mocha");
+        assert_eq!(themes().len(), THEMES.len());
+    }
+}
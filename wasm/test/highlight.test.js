@@ -0,0 +1,51 @@
+// Checks the release build against the native one; run `npm test`, which builds it first with the test-panic feature.
+
+import assert from "node:assert/strict";
+import { readFile } from "node:fs/promises";
+import { test } from "node:test";
+import { WASI } from "node:wasi";
+
+import { Colorizer, ColorizerError } from "../colorizer.js";
+
+const repo = new URL("../../", import.meta.url);
+const wasm = await readFile(new URL("wasm/target/wasm32-wasip1/release/colorizer_wasm.wasm", repo));
+const colorizer = await Colorizer.load(wasm, () => new WASI({ version: "preview1", returnOnExit: true }));
+
+test("Go highlights to the HTML the native build produces", async () => {
+  const source = await readFile(new URL("examples/languages/sample.go", repo), "utf8");
+  // Written by the crate's go_sample_matches_golden_html test.
+  const golden = await readFile(new URL("examples/golden/sample.go.html", repo), "utf8");
+  assert.equal(colorizer.highlight(source, "go", "monokai", "html"), golden);
+});
+
+test("non-ASCII source survives the boundary", () => {
+  const html = colorizer.highlight('s := "héllo, 世界 🎨"\n', "go", "monokai", "html");
+  assert.match(html, /héllo, 世界 🎨/);
+});
+
+test("failures throw with the module's message", () => {
+  assert.throws(() => colorizer.highlight("x", "cobol-85", "monokai", "html"), {
+    name: "ColorizerError",
+    message: 'unknown language "cobol-85"',
+  });
+  assert.throws(() => colorizer.highlight("x", "go", "monokai", "png"), ColorizerError);
+  // The instance is still usable afterwards.
+  assert.match(colorizer.highlight("x", "go", "monokai", "html"), /^<pre/);
+});
+
+test("a trapped panic is reported and the instance replaced", () => {
+  assert.throws(() => colorizer.highlight("x", "test-panic"), (err) => {
+    assert.ok(err instanceof ColorizerError);
+    assert.match(err.message, /^colorizer panicked: .*asked to panic by the test-panic language/s);
+    assert.ok(err.cause instanceof WebAssembly.RuntimeError);
+    return true;
+  });
+  assert.match(colorizer.highlight('s := "après"\n', "go", "monokai", "html"), /après/);
+  assert.equal(colorizer.listThemes()[0], "catppuccin-mocha");
+});
+
+test("languages and themes are listed", () => {
+  assert.ok(["Go", "Python", "Rust"].every((name) => colorizer.listLanguages().includes(name)));
+  assert.equal(colorizer.listThemes()[0], "catppuccin-mocha");
+  assert.match(colorizer.highlight("", "", "dark-vs", "css"), /\.clz-/);
+});